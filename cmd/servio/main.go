@@ -6,16 +6,28 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"servio/internal/audit"
+	"servio/internal/cli"
 	"servio/internal/config"
+	"servio/internal/container"
 	httpserver "servio/internal/http"
+	"servio/internal/logging"
+	"servio/internal/secrets"
 	"servio/internal/storage"
 	"servio/internal/systemd"
 )
 
 func main() {
+	// Subcommands (servio projects list, servio svc restart api, ...) run the
+	// API client instead of the server
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	// Initialize configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -24,9 +36,11 @@ func main() {
 	}
 
 	// Initialize structured logger
-	setupLogger(cfg.LogLevel)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(logging.ParseLevel(cfg.LogLevel))
+	logging.Setup(cfg.LogFormat, logLevel)
 
-	slog.Info("Starting Servio", "version", "1.0.0")
+	slog.Info("Starting Servio", "version", "1.0.0", "profile", cfg.Profile)
 
 	// Initialize storage
 	store, err := storage.New(cfg.DBPath)
@@ -36,25 +50,75 @@ func main() {
 	}
 	defer store.Close()
 
-	// Initialize systemd service manager
-	svcManager := systemd.NewManager()
+	// Persist systemd/nginx/git actions to the audit trail
+	audit.SetRecorder(audit.NewStoreRecorder(store))
+
+	// Initialize secrets encryption
+	key, err := secrets.LoadKey(cfg.SecretKeyFile)
+	if err != nil {
+		slog.Error("Failed to load secret key", "error", err, "path", cfg.SecretKeyFile)
+		os.Exit(1)
+	}
+	cipher, err := secrets.NewCipher(key)
+	if err != nil {
+		slog.Error("Failed to initialize secrets cipher", "error", err)
+		os.Exit(1)
+	}
+
+	// Keep every generated unit, nginx site, and env file in the database,
+	// sealing the private ones, so the host's files can be rebuilt
+	audit.SetFileKeeper(audit.NewStoreKeeper(store, cipher))
+
+	// Initialize the service manager: systemd or another init system, with
+	// services that run as containers sent to Docker or Podman
+	resolver := secrets.NewResolver(store, cipher)
+	systemdManager := systemd.NewManager()
+	systemdManager.SetSecretResolver(resolver)
+	host, err := container.NewHost(store, systemdManager, resolver, container.HostOptions{
+		Mock:          cfg.Mock,
+		Supervise:     cfg.Supervise,
+		Init:          cfg.Init,
+		SupervisorDir: filepath.Join(filepath.Dir(cfg.DBPath), "supervisor"),
+	})
+	if err != nil {
+		slog.Error("Failed to initialize the service manager", "error", err)
+		os.Exit(1)
+	}
 
 	// Initialize HTTP server
-	server := httpserver.NewServer(cfg.Addr, store, svcManager)
+	server := httpserver.NewServer(cfg.Listen, store, host.Manager, cipher)
+	if err := server.Configure(cfg); err != nil {
+		slog.Error("Failed to configure the server", "error", err)
+		os.Exit(1)
+	}
+	// Reboot and shutdown go through systemctl, so only systemd hosts allow them
+	server.SetPowerControl(host.Units != nil)
+	if host.Units != nil {
+		server.SetUnitWatcher(host.Units)
+	}
 
 	// Start server in goroutine
 	go func() {
-		slog.Info("🚀 Server starting", "addr", cfg.Addr)
+		slog.Info("🚀 Server starting", "addr", cfg.Listen, "tls", cfg.TLSCert != "", "client_certs", cfg.TLSClientCA != "")
 		if err := server.Start(); err != nil && err != http.ErrServerClosed {
 			slog.Error("Server error", "error", err)
 			os.Exit(1)
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Reload the config on SIGHUP; wait for an interrupt signal to stop
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	for running := true; running; {
+		select {
+		case <-hup:
+			cfg = server.Reload(cfg, logLevel)
+		case <-quit:
+			running = false
+		}
+	}
 
 	slog.Info("Shutting down server...")
 
@@ -65,26 +129,9 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		slog.Error("Server shutdown error", "error", err)
 	}
-
-	slog.Info("Server stopped")
-}
-
-func setupLogger(level string) {
-	var slogLevel slog.Level
-	switch level {
-	case "debug":
-		slogLevel = slog.LevelDebug
-	case "info":
-		slogLevel = slog.LevelInfo
-	case "warn":
-		slogLevel = slog.LevelWarn
-	case "error":
-		slogLevel = slog.LevelError
-	default:
-		slogLevel = slog.LevelInfo
+	if host.Processes != nil {
+		host.Processes.Shutdown()
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slogLevel})
-	logger := slog.New(handler)
-	slog.SetDefault(logger)
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,248 @@
+// Package acme issues Let's Encrypt certificates for nginx sites with certbot,
+// answering the DNS-01 challenge through a DNS provider's API. The names need
+// not resolve to the server, so certificates can be issued before a domain is
+// pointed at it, for internal-only services, and for wildcard names.
+package acme
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+)
+
+// DNS providers, named after the certbot plugins that talk to them
+const (
+	Cloudflare   = "cloudflare"
+	Route53      = "route53"
+	DigitalOcean = "digitalocean"
+)
+
+// Providers lists every supported DNS provider
+var Providers = []string{Cloudflare, Route53, DigitalOcean}
+
+// domainPattern matches a lowercase host name with at least two labels
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9-]{2,63}$`)
+
+// LiveDir is where certbot keeps the current files of each certificate
+var LiveDir = "/etc/letsencrypt/live"
+
+var (
+	// ErrUnknownProvider is returned for providers outside Providers
+	ErrUnknownProvider = errors.New("unknown DNS provider")
+	// ErrNoCredentials is returned when a provider that needs an API token has none
+	ErrNoCredentials = errors.New("the DNS provider needs an API token")
+	// ErrInvalidDomain is returned for wildcard domains that are not plain host names
+	ErrInvalidDomain = errors.New("invalid domain")
+	// ErrNoDomains is returned when a site has no name a certificate can cover
+	ErrNoDomains = errors.New("no domain names a certificate can cover")
+	// ErrCommandFailed is returned when certbot fails
+	ErrCommandFailed = errors.New("certbot failed")
+)
+
+// Request describes a certificate to issue or renew
+type Request struct {
+	Name     string   // certbot certificate name
+	Domains  []string // from Domains
+	Email    string   // where Let's Encrypt sends expiry notices
+	Provider string
+	// Credentials is the provider's API token. Route53 takes none and uses
+	// the AWS credentials root has, from an instance role or /root/.aws.
+	Credentials string
+	// CredentialsDir keeps the provider's credentials file, which certbot
+	// reads again on every renewal
+	CredentialsDir string
+	Staging        bool // use Let's Encrypt's staging environment
+}
+
+// CertPath returns the certificate chain nginx serves for a certificate name
+func CertPath(name string) string {
+	return filepath.Join(LiveDir, name, "fullchain.pem")
+}
+
+// KeyPath returns the private key of a certificate name
+func KeyPath(name string) string {
+	return filepath.Join(LiveDir, name, "privkey.pem")
+}
+
+// Domains returns the names of a server_name list a certificate can cover:
+// regex names and the catch-all "_" are dropped, and a leading-dot name
+// (".example.com") stands for both the name and its subdomains
+func Domains(serverName string) []string {
+	var domains []string
+	add := func(name string) {
+		if !slices.Contains(domains, name) {
+			domains = append(domains, name)
+		}
+	}
+	for _, name := range strings.Fields(strings.ToLower(serverName)) {
+		switch {
+		case strings.HasPrefix(name, "~") || name == "_" || !strings.Contains(name, "."):
+			continue
+		case strings.HasPrefix(name, "."):
+			add(name[1:])
+			add("*" + name)
+		case strings.HasPrefix(name, "*.") || !strings.Contains(name, "*"):
+			add(name)
+		}
+	}
+	return domains
+}
+
+// WildcardDomains returns the names of a wildcard certificate for domain:
+// the domain itself and all its subdomains one level down
+func WildcardDomains(domain string) []string {
+	return []string{domain, "*." + domain}
+}
+
+// CheckDomain rejects domains a wildcard certificate cannot be issued for
+func CheckDomain(domain string) error {
+	if !domainPattern.MatchString(domain) {
+		return fmt.Errorf("%w: %q", ErrInvalidDomain, domain)
+	}
+	return nil
+}
+
+// WildcardCovers reports whether the wildcard certificate of domain is valid
+// for name, as returned by Domains. Wildcards only reach one level down, so
+// a.b.example.com needs a certificate of its own.
+func WildcardCovers(domain, name string) bool {
+	if name == domain || name == "*."+domain {
+		return true
+	}
+	label, rest, ok := strings.Cut(name, ".")
+	return ok && rest == domain && label != "*"
+}
+
+// Issue obtains or renews a certificate, keeping the current one until it is
+// due for renewal unless the names changed. certbot's own timer renews it
+// afterwards and reloads nginx. Output is passed to out line by line. A dry
+// run records the commands instead.
+func Issue(ctx context.Context, req Request, out func(line string)) error {
+	if len(req.Domains) == 0 {
+		return ErrNoDomains
+	}
+	args := []string{"certbot", "certonly", "--non-interactive", "--agree-tos", "--email", req.Email,
+		"--cert-name", req.Name, "--keep-until-expiring", "--expand",
+		"--deploy-hook", "systemctl reload nginx"}
+	if req.Staging {
+		args = append(args, "--staging")
+	}
+
+	switch req.Provider {
+	case Cloudflare, DigitalOcean:
+		path, err := writeCredentials(ctx, req)
+		if err != nil {
+			return err
+		}
+		args = append(args, "--dns-"+req.Provider, "--dns-"+req.Provider+"-credentials", path)
+	case Route53:
+		args = append(args, "--dns-route53")
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownProvider, req.Provider)
+	}
+	for _, domain := range req.Domains {
+		args = append(args, "-d", domain)
+	}
+
+	cmd := privileged(ctx, args)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(cmd.Args)
+		return nil
+	}
+
+	var log bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &log, &log
+	start := time.Now()
+	err := cmd.Run()
+	audit.Log(ctx, audit.CategoryNginx, "issue-certificate", strings.Join(cmd.Args, " "), log.String(), err, time.Since(start))
+	scanner := bufio.NewScanner(&log)
+	for scanner.Scan() {
+		out(scanner.Text())
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCommandFailed, err)
+	}
+	return nil
+}
+
+// writeCredentials saves the provider's API token as the INI file its certbot
+// plugin reads, readable by its owner only, and returns its path
+func writeCredentials(ctx context.Context, req Request) (string, error) {
+	key := "dns_cloudflare_api_token"
+	if req.Provider == DigitalOcean {
+		key = "dns_digitalocean_token"
+	}
+	path := filepath.Join(req.CredentialsDir, req.Provider+".ini")
+	content := fmt.Sprintf("# Managed by Servio\n%s = %s\n", key, req.Credentials)
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(req.CredentialsDir, 0700)
+		plan.Write(path, content, 0600)
+		return path, nil
+	}
+	if req.Credentials == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoCredentials, req.Provider)
+	}
+	if err := os.MkdirAll(req.CredentialsDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s credentials: %w", req.Provider, err)
+	}
+	audit.WroteFile(ctx, audit.CategoryNginx, path, []byte(content), 0600)
+	return path, nil
+}
+
+// Expiry returns when a certificate expires, from `certbot certificates`,
+// since only root can read the certificate files
+func Expiry(ctx context.Context, name string) (time.Time, error) {
+	output, err := privileged(ctx, []string{"certbot", "certificates", "--cert-name", name}).CombinedOutput()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v: %s", ErrCommandFailed, err, strings.TrimSpace(string(output)))
+	}
+	// "    Expiry Date: 2026-01-15 10:00:00+00:00 (VALID: 89 days)"
+	for _, line := range strings.Split(string(output), "\n") {
+		_, rest, ok := strings.Cut(line, "Expiry Date: ")
+		if !ok {
+			continue
+		}
+		date, _, _ := strings.Cut(rest, " (")
+		expires, err := time.Parse("2006-01-02 15:04:05-07:00", date)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: unexpected expiry date %q", ErrCommandFailed, date)
+		}
+		return expires, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: certificate %s not found", ErrCommandFailed, name)
+}
+
+// Delete removes a certificate and stops its renewal. Credentials files stay,
+// since other certificates may use them.
+func Delete(ctx context.Context, name string) error {
+	cmd := privileged(ctx, []string{"certbot", "delete", "--non-interactive", "--cert-name", name})
+	output, err := audit.Run(ctx, audit.CategoryNginx, "delete-certificate", cmd)
+	if err != nil {
+		return fmt.Errorf("%w: %v: %s", ErrCommandFailed, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// privileged returns a command running args through sudo unless Servio runs as root
+func privileged(ctx context.Context, args []string) *exec.Cmd {
+	if os.Geteuid() != 0 {
+		args = append([]string{"sudo"}, args...)
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
@@ -0,0 +1,86 @@
+// Package activity keeps the events published on the bus for the activity
+// feed, which lists them with the audit trail of host actions. Job updates
+// are left out: jobs keep their own history, and every job publishes
+// several.
+package activity
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"servio/internal/events"
+	"servio/internal/notify"
+	"servio/internal/storage"
+)
+
+const (
+	// Retention is how long recorded events are kept
+	Retention = 30 * 24 * time.Hour
+	// queueSize bounds how many events may wait to be recorded
+	queueSize = 256
+	// pruneInterval is how often events older than Retention are deleted
+	pruneInterval = time.Hour
+)
+
+// Recorder stores events from the bus
+type Recorder struct {
+	store storage.Store
+	queue chan events.Event
+}
+
+// NewRecorder creates a Recorder. Call Run to start recording.
+func NewRecorder(store storage.Store) *Recorder {
+	return &Recorder{store: store, queue: make(chan events.Event, queueSize)}
+}
+
+// Handle queues an event to be recorded. It never blocks; when the queue is
+// full the event is dropped.
+func (r *Recorder) Handle(e events.Event) {
+	if e.Type == events.JobUpdated {
+		return
+	}
+	select {
+	case r.queue <- e:
+	default:
+		slog.Warn("Activity queue full, dropping event", "event", e.Type)
+	}
+}
+
+// Run records queued events and prunes old ones until ctx is cancelled
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	r.prune(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-r.queue:
+			r.record(ctx, e)
+		case <-ticker.C:
+			r.prune(ctx)
+		}
+	}
+}
+
+func (r *Recorder) record(ctx context.Context, e events.Event) {
+	_, failed := notify.Describe(e, "", "")
+	err := r.store.RecordEvent(ctx, &storage.EventRecord{
+		Type:      e.Type,
+		ProjectID: e.ProjectID,
+		ServiceID: e.ServiceID,
+		Data:      e.Data,
+		Failed:    failed,
+		CreatedAt: e.Time,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record event", "event", e.Type, "error", err)
+	}
+}
+
+func (r *Recorder) prune(ctx context.Context) {
+	if _, err := r.store.PruneEvents(ctx, time.Now().Add(-Retention)); err != nil {
+		slog.WarnContext(ctx, "Failed to prune events", "error", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package agent lets one Servio manage the units and nginx sites of other
+// servers. Each remote server runs `servio agent`, which registers with the
+// central Servio and serves a small API (Handler) over the host's systemd,
+// journal, and nginx. The central server assigns projects to hosts and its
+// Router sends the service operations of those projects to the host's agent
+// through a Client; everything else stays local.
+package agent
+
+import (
+	"errors"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/monitor"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// ErrAgent is wrapped by errors from requests to an agent, including agents
+// that cannot be reached
+var ErrAgent = errors.New("agent request failed")
+
+// ErrLocalOnly is wrapped by errors for features that only work for projects
+// on the central server, such as git deploys and journal retention
+var ErrLocalOnly = errors.New("only supported for projects on this server")
+
+const (
+	// apiPrefix is the path every agent endpoint lives under
+	apiPrefix = "/agent/v1"
+	// RegisterPath is the central server endpoint agents join through
+	RegisterPath = "/api/agents/register"
+	// dryRunHeader asks an agent to report its changes instead of making them
+	dryRunHeader = "X-Dry-Run"
+	// requestTimeout bounds agent requests other than log follows
+	requestTimeout = 30 * time.Second
+)
+
+// Registration is the body an agent joins with. Token is the secret the
+// central server presents on every request to the agent.
+type Registration struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// unitInfo is the state of a unit on an agent
+type unitInfo struct {
+	Status      systemd.ServiceStatus `json:"status"`
+	ActiveState string                `json:"active_state"`
+	StartTime   string                `json:"start_time"`
+	Exists      bool                  `json:"exists"`
+}
+
+// installRequest carries a unit generated, with its secrets resolved, by the central server
+type installRequest struct {
+	Service *storage.Service `json:"service"`
+	Content string           `json:"content"`
+	Private bool             `json:"private"` // holds secrets; written readable by root only
+}
+
+// portStatus reports whether a TCP port is bound on an agent's host
+type portStatus struct {
+	InUse bool `json:"in_use"`
+}
+
+// SitePreview is a project's nginx site as the agent would install it
+type SitePreview struct {
+	Config    string `json:"config"`
+	Path      string `json:"path"`
+	Installed bool   `json:"installed"`
+}
+
+// changeResponse answers requests that change the host; Actions lists what a
+// dry run would have done
+type changeResponse struct {
+	Actions []dryrun.Action `json:"actions,omitempty"`
+}
+
+// errorResponse is the body of failed agent requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// HostStats is the last stats reading of an agent host
+type HostStats struct {
+	Host   *storage.Host  `json:"host"`
+	Online bool           `json:"online"`
+	Error  string         `json:"error,omitempty"`
+	Stats  *monitor.Stats `json:"stats,omitempty"`
+}
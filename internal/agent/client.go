@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/monitor"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// maxErrorBody bounds how much of a failed response is read for its message
+const maxErrorBody = 4096
+
+// Client calls the agent API of one host. Registry.NewClient creates one.
+type Client struct {
+	name  string
+	url   string
+	token string
+	http  *http.Client
+}
+
+// Name returns the name of the client's host
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Ping checks that the agent is up and can reach systemd
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/ping", nil, nil, nil)
+}
+
+// Stats reads the host's resources and those of the given units
+func (c *Client) Stats(ctx context.Context, units []string) (*monitor.Stats, error) {
+	var stats monitor.Stats
+	if err := c.call(ctx, http.MethodGet, "/stats", url.Values{"unit": units}, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// PortInUse reports whether a TCP port is bound on the host
+func (c *Client) PortInUse(ctx context.Context, port int) (bool, error) {
+	var status portStatus
+	if err := c.call(ctx, http.MethodGet, "/ports/"+strconv.Itoa(port), nil, nil, &status); err != nil {
+		return false, err
+	}
+	return status.InUse, nil
+}
+
+// UnitAction starts, stops, restarts, enables, or disables a unit
+func (c *Client) UnitAction(ctx context.Context, unit, action string) error {
+	return c.change(ctx, http.MethodPost, "/units/"+url.PathEscape(unit)+"/"+action, nil)
+}
+
+// unitInfo reads a unit's status
+func (c *Client) unitInfo(ctx context.Context, unit string) (*unitInfo, error) {
+	var info unitInfo
+	if err := c.call(ctx, http.MethodGet, "/units/"+url.PathEscape(unit), nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// InstallUnit writes a unit generated for service and reloads the host's systemd
+func (c *Client) InstallUnit(ctx context.Context, service *storage.Service, content string, private bool) error {
+	return c.change(ctx, http.MethodPut, "/units/"+url.PathEscape(service.ServiceName()), installRequest{Service: service, Content: content, Private: private})
+}
+
+// UninstallUnit stops and removes a unit
+func (c *Client) UninstallUnit(ctx context.Context, unit string) error {
+	return c.change(ctx, http.MethodDelete, "/units/"+url.PathEscape(unit), nil)
+}
+
+// Logs reads a unit's journal between since and until, in journalctl's time syntax
+func (c *Client) Logs(ctx context.Context, unit, since, until string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/units/"+url.PathEscape(unit)+"/logs", url.Values{"since": {since}, "until": {until}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrAgent, c.name, err)
+	}
+	return string(data), nil
+}
+
+// Entries reads the last lines of a unit's journal since a time
+func (c *Client) Entries(ctx context.Context, unit, since string, lines int) ([]systemd.JournalEntry, error) {
+	var entries []systemd.JournalEntry
+	query := url.Values{"since": {since}, "lines": {strconv.Itoa(lines)}}
+	if err := c.call(ctx, http.MethodGet, "/units/"+url.PathEscape(unit)+"/entries", query, nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// FollowUnit follows a unit's journal from after cursor until ctx is done or the agent ends the stream
+func (c *Client) FollowUnit(ctx context.Context, unit, cursor string) (<-chan systemd.JournalEntry, error) {
+	return follow[systemd.JournalEntry](ctx, c, "/units/"+url.PathEscape(unit)+"/follow", url.Values{"cursor": {cursor}})
+}
+
+// FollowUnits follows the merged journals of several units
+func (c *Client) FollowUnits(ctx context.Context, units []string, lines int, after time.Time) (<-chan systemd.LogLine, error) {
+	query := url.Values{"unit": units, "lines": {strconv.Itoa(lines)}}
+	if !after.IsZero() {
+		query.Set("after", after.Format(time.RFC3339Nano))
+	}
+	return follow[systemd.LogLine](ctx, c, "/logs/follow", query)
+}
+
+// PreviewSite generates a project's nginx site as the agent would install it
+func (c *Client) PreviewSite(ctx context.Context, project *storage.Project) (*SitePreview, error) {
+	var preview SitePreview
+	if err := c.call(ctx, http.MethodPost, "/site/preview", nil, project, &preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// InstallSite installs a project's nginx site and reloads nginx on the host
+func (c *Client) InstallSite(ctx context.Context, project *storage.Project) error {
+	return c.change(ctx, http.MethodPut, "/site", project)
+}
+
+// RemoveSite removes a project's nginx site from the host
+func (c *Client) RemoveSite(ctx context.Context, project *storage.Project) error {
+	return c.change(ctx, http.MethodDelete, "/site", project)
+}
+
+// change makes a request that changes the host. In a dry run the agent only
+// reports its actions, which are added to the plan.
+func (c *Client) change(ctx context.Context, method, path string, body interface{}) error {
+	var resp changeResponse
+	if err := c.call(ctx, method, path, nil, body, &resp); err != nil {
+		return err
+	}
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Append(c.name, resp.Actions)
+	}
+	return nil
+}
+
+// call makes a request bounded by requestTimeout and decodes its JSON response into out
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %s: invalid response: %w", ErrAgent, c.name, err)
+	}
+	return nil
+}
+
+// do sends a request to the agent, returning the response of a successful one.
+// The caller closes its body.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := c.url + apiPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrAgent, c.name, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if dryrun.FromContext(ctx) != nil {
+		req.Header.Set(dryRunHeader, "1")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrAgent, c.name, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e errorResponse
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("%w: %s: %s (%d)", ErrAgent, c.name, e.Error, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// follow streams the newline-delimited JSON values of a follow endpoint
+func follow[T any](ctx context.Context, c *Client, path string, query url.Values) (<-chan T, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan T, 100)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var v T
+			if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+				continue
+			}
+			select {
+			case ch <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Join registers an agent with the central server at serverURL, using the
+// server's agent token
+func Join(ctx context.Context, serverURL, token string, reg Registration) (*storage.Host, error) {
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(serverURL, "/")+RegisterPath, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", serverURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode >= 300 {
+		var e errorResponse
+		if json.Unmarshal(body, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("server refused to register the agent: %s (%d)", e.Error, resp.StatusCode)
+	}
+	var host storage.Host
+	if err := json.Unmarshal(body, &host); err != nil {
+		return nil, fmt.Errorf("invalid registration response: %w", err)
+	}
+	return &host, nil
+}
@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/monitor"
+	"servio/internal/nginx"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// Units is what an agent needs from its service manager: the usual
+// operations, plus installing units generated by the central server.
+// systemd.Manager and systemd.MockManager implement it.
+type Units interface {
+	systemd.ServiceManager
+	InstallServiceFile(ctx context.Context, service *storage.Service, content string, private bool) error
+}
+
+// Handler serves the agent API on a remote host. Every request must carry
+// the token the agent registered with.
+type Handler struct {
+	units Units
+	nginx *nginx.Manager
+	token string
+	mux   *http.ServeMux
+}
+
+// NewHandler creates the agent API over the host's units and nginx sites
+func NewHandler(units Units, nginxManager *nginx.Manager, token string) *Handler {
+	h := &Handler{units: units, nginx: nginxManager, token: token, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET "+apiPrefix+"/ping", h.handlePing)
+	h.mux.HandleFunc("GET "+apiPrefix+"/stats", h.handleStats)
+	h.mux.HandleFunc("GET "+apiPrefix+"/ports/{port}", h.handlePort)
+	h.mux.HandleFunc("GET "+apiPrefix+"/units/{unit}", h.unit(h.handleUnitInfo))
+	h.mux.HandleFunc("PUT "+apiPrefix+"/units/{unit}", h.unit(h.handleInstallUnit))
+	h.mux.HandleFunc("DELETE "+apiPrefix+"/units/{unit}", h.unit(h.handleUninstallUnit))
+	h.mux.HandleFunc("POST "+apiPrefix+"/units/{unit}/{action}", h.unit(h.handleUnitAction))
+	h.mux.HandleFunc("GET "+apiPrefix+"/units/{unit}/logs", h.unit(h.handleUnitLogs))
+	h.mux.HandleFunc("GET "+apiPrefix+"/units/{unit}/entries", h.unit(h.handleUnitEntries))
+	h.mux.HandleFunc("GET "+apiPrefix+"/units/{unit}/follow", h.unit(h.handleFollowUnit))
+	h.mux.HandleFunc("GET "+apiPrefix+"/logs/follow", h.handleFollowUnits)
+	h.mux.HandleFunc("POST "+apiPrefix+"/site/preview", h.site(h.handleSitePreview))
+	h.mux.HandleFunc("PUT "+apiPrefix+"/site", h.site(h.handleInstallSite))
+	h.mux.HandleFunc("DELETE "+apiPrefix+"/site", h.site(h.handleRemoveSite))
+	return h
+}
+
+// ServeHTTP checks the token and routes the request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		writeError(w, "invalid agent token", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get(dryRunHeader) != "" {
+		r = r.WithContext(dryrun.WithPlan(r.Context(), &dryrun.Plan{}))
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// managedUnit reports whether name is a unit Servio generates. Agents touch no other units.
+func managedUnit(name string) bool {
+	return strings.HasPrefix(name, "servio-") && strings.HasSuffix(name, ".service") && !strings.ContainsAny(name, "/\\")
+}
+
+// unit resolves the {unit} wildcard of a route
+func (h *Handler) unit(next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("unit")
+		if !managedUnit(name) {
+			writeError(w, "not a Servio unit: "+name, http.StatusBadRequest)
+			return
+		}
+		next(w, r, name)
+	}
+}
+
+// site decodes the project a site route is for
+func (h *Handler) site(next func(http.ResponseWriter, *http.Request, *storage.Project)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var project storage.Project
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+			writeError(w, "invalid project", http.StatusBadRequest)
+			return
+		}
+		next(w, r, &project)
+	}
+}
+
+// handlePing checks the host's systemd
+func (h *Handler) handlePing(w http.ResponseWriter, r *http.Request) {
+	if err := h.units.Ping(r.Context()); err != nil {
+		writeError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleStats reports the host's resources and those of the ?unit= units
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	var units []string
+	for _, name := range r.URL.Query()["unit"] {
+		if managedUnit(name) {
+			units = append(units, name)
+		}
+	}
+	writeJSON(w, monitor.GetStats(units...))
+}
+
+// handlePort reports whether a TCP port is bound on the host, so the central
+// server can check a service's port where it will run
+func (h *Handler) handlePort(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		writeError(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, portStatus{InUse: monitor.PortInUse(port)})
+}
+
+func (h *Handler) handleUnitInfo(w http.ResponseWriter, r *http.Request, unit string) {
+	ctx := r.Context()
+	status, err := h.units.Status(ctx, unit)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	startTime, _ := h.units.GetStartTime(ctx, unit)
+	writeJSON(w, unitInfo{
+		Status:      status,
+		ActiveState: h.units.ActiveState(ctx, unit),
+		StartTime:   startTime,
+		Exists:      h.units.ServiceExists(unit),
+	})
+}
+
+func (h *Handler) handleInstallUnit(w http.ResponseWriter, r *http.Request, unit string) {
+	var req installRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Service == nil {
+		writeError(w, "invalid install request", http.StatusBadRequest)
+		return
+	}
+	if req.Service.ServiceName() != unit {
+		writeError(w, "service does not match unit "+unit, http.StatusBadRequest)
+		return
+	}
+	h.change(w, r, func(ctx context.Context) error {
+		return h.units.InstallServiceFile(ctx, req.Service, req.Content, req.Private)
+	})
+}
+
+func (h *Handler) handleUninstallUnit(w http.ResponseWriter, r *http.Request, unit string) {
+	h.change(w, r, func(ctx context.Context) error { return h.units.UninstallService(ctx, unit) })
+}
+
+func (h *Handler) handleUnitAction(w http.ResponseWriter, r *http.Request, unit string) {
+	actions := map[string]func(context.Context, string) error{
+		"start":   h.units.Start,
+		"stop":    h.units.Stop,
+		"restart": h.units.Restart,
+		"enable":  h.units.Enable,
+		"disable": h.units.Disable,
+	}
+	action, ok := actions[r.PathValue("action")]
+	if !ok {
+		writeError(w, "unknown action", http.StatusNotFound)
+		return
+	}
+	h.change(w, r, func(ctx context.Context) error { return action(ctx, unit) })
+}
+
+func (h *Handler) handleUnitLogs(w http.ResponseWriter, r *http.Request, unit string) {
+	q := r.URL.Query()
+	logs, err := h.units.GetLogsWithTimeRange(r.Context(), unit, q.Get("since"), q.Get("until"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(logs))
+}
+
+func (h *Handler) handleUnitEntries(w http.ResponseWriter, r *http.Request, unit string) {
+	lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+	entries, err := h.units.GetLogEntries(r.Context(), unit, r.URL.Query().Get("since"), lines)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+// handleFollowUnit streams a unit's journal from ?cursor= as newline-delimited JSON
+func (h *Handler) handleFollowUnit(w http.ResponseWriter, r *http.Request, unit string) {
+	entries, err := h.units.StreamLogs(r.Context(), unit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	streamJSON(w, entries)
+}
+
+// handleFollowUnits streams the merged journals of the ?unit= units as newline-delimited JSON
+func (h *Handler) handleFollowUnits(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	units := q["unit"]
+	for _, unit := range units {
+		if !managedUnit(unit) {
+			writeError(w, "not a Servio unit: "+unit, http.StatusBadRequest)
+			return
+		}
+	}
+	lines, _ := strconv.Atoi(q.Get("lines"))
+	var after time.Time
+	if v := q.Get("after"); v != "" {
+		var err error
+		if after, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			writeError(w, "invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	logLines, err := h.units.StreamUnitLogs(r.Context(), units, lines, after)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	streamJSON(w, logLines)
+}
+
+func (h *Handler) handleSitePreview(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	config, err := h.nginx.GenerateSiteConfig(project)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, SitePreview{Config: config, Path: h.nginx.SiteConfigPath(project), Installed: h.nginx.SiteExists(project)})
+}
+
+func (h *Handler) handleInstallSite(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	h.change(w, r, func(ctx context.Context) error { return h.nginx.InstallSite(ctx, project) })
+}
+
+func (h *Handler) handleRemoveSite(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	h.change(w, r, func(ctx context.Context) error { return h.nginx.UninstallSite(ctx, project) })
+}
+
+// change runs an operation that changes the host, answering with the actions
+// of a dry run
+func (h *Handler) change(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) {
+	ctx := r.Context()
+	if err := op(ctx); err != nil {
+		slog.WarnContext(ctx, "Agent operation failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var resp changeResponse
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		resp.Actions = plan.Actions()
+	}
+	writeJSON(w, resp)
+}
+
+// streamJSON writes each value from ch on its own line, flushing as it goes,
+// until ch closes
+func streamJSON[T any](w http.ResponseWriter, ch <-chan T) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for v := range ch {
+		if err := enc.Encode(v); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: msg})
+}
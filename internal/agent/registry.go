@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/secrets"
+	"servio/internal/storage"
+)
+
+const (
+	// pollInterval is how often each host's stats are read
+	pollInterval = 10 * time.Second
+	// pollTimeout bounds one stats read, so a host that is down does not hold up the others
+	pollTimeout = 5 * time.Second
+)
+
+// Registry finds the agent of a host and keeps the last stats of every host
+type Registry struct {
+	store       storage.Store
+	cipher      *secrets.Cipher
+	http        *http.Client // trusts the CA given to TrustCA
+	reconfigure chan struct{}
+
+	mu    sync.Mutex
+	stats map[int64]HostStats
+}
+
+// NewRegistry creates a Registry. Call Run to start reading host stats.
+func NewRegistry(store storage.Store, cipher *secrets.Cipher) *Registry {
+	return &Registry{
+		store:       store,
+		cipher:      cipher,
+		http:        &http.Client{},
+		reconfigure: make(chan struct{}, 1),
+		stats:       make(map[int64]HostStats),
+	}
+}
+
+// Client returns the agent of a host, or nil for host 0, this server
+func (r *Registry) Client(ctx context.Context, hostID int64) (*Client, error) {
+	if hostID == 0 {
+		return nil, nil
+	}
+	host, err := r.store.GetHost(ctx, hostID)
+	if err != nil {
+		return nil, err
+	}
+	if host == nil {
+		return nil, fmt.Errorf("%w: host %d not found", ErrAgent, hostID)
+	}
+	return r.client(host)
+}
+
+// ForUnit returns the agent running a unit, or nil when it runs on this server
+func (r *Registry) ForUnit(ctx context.Context, unit string) (*Client, error) {
+	hostID, err := r.store.UnitHostID(ctx, unit)
+	if err != nil {
+		return nil, err
+	}
+	return r.Client(ctx, hostID)
+}
+
+// UnitHost returns the ID of the host running a unit; 0 is this server
+func (r *Registry) UnitHost(ctx context.Context, unit string) (int64, error) {
+	return r.store.UnitHostID(ctx, unit)
+}
+
+// reachable reports whether a host answered its last poll, or has not been polled yet
+func (r *Registry) reachable(hostID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.stats[hostID]
+	return !ok || s.Online
+}
+
+// ForProject returns the agent running a project, or nil when it runs on this server
+func (r *Registry) ForProject(ctx context.Context, projectID int64) (*Client, error) {
+	project, err := r.store.GetProject(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil {
+		return nil, nil
+	}
+	return r.Client(ctx, project.HostID)
+}
+
+func (r *Registry) client(host *storage.Host) (*Client, error) {
+	token, err := r.cipher.Decrypt(host.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt agent token of host %s: %w", host.Name, err)
+	}
+	return r.NewClient(host.Name, host.URL, token), nil
+}
+
+// NewClient creates a client for the agent of the named host at baseURL
+func (r *Registry) NewClient(name, baseURL, token string) *Client {
+	return &Client{name: name, url: strings.TrimRight(baseURL, "/"), token: token, http: r.http}
+}
+
+// Stats returns the last reading of each host, by host name
+func (r *Registry) Stats(ctx context.Context) []HostStats {
+	hosts, err := r.store.ListHosts(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list hosts", "error", err)
+		return []HostStats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]HostStats, 0, len(hosts))
+	for _, h := range hosts {
+		s, ok := r.stats[h.ID]
+		if !ok {
+			s = HostStats{Error: "not polled yet"}
+		}
+		s.Host = h
+		result = append(result, s)
+	}
+	return result
+}
+
+// Reconfigure asks Run to poll the hosts now rather than at the next
+// interval, after one joins or is deleted. It never blocks.
+func (r *Registry) Reconfigure() {
+	select {
+	case r.reconfigure <- struct{}{}:
+	default:
+	}
+}
+
+// Run reads every host's stats until ctx is cancelled
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		r.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.reconfigure:
+		}
+	}
+}
+
+// poll reads the stats of every host, with those of the units of its projects
+func (r *Registry) poll(ctx context.Context) {
+	hosts, err := r.store.ListHosts(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list hosts", "error", err)
+		return
+	}
+	if len(hosts) == 0 {
+		r.mu.Lock()
+		clear(r.stats)
+		r.mu.Unlock()
+		return
+	}
+	units, err := r.hostUnits(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list host units", "error", err)
+	}
+
+	results := make(map[int64]HostStats, len(hosts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host *storage.Host) {
+			defer wg.Done()
+			result := r.pollHost(ctx, host, units[host.ID])
+			mu.Lock()
+			results[host.ID] = result
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	r.stats = results
+	r.mu.Unlock()
+}
+
+// pollHost reads one host's stats, recording when it was last seen
+func (r *Registry) pollHost(ctx context.Context, host *storage.Host, units []string) HostStats {
+	client, err := r.client(host)
+	if err != nil {
+		return HostStats{Error: err.Error()}
+	}
+	pollCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+	stats, err := client.Stats(pollCtx, units)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.DebugContext(ctx, "Failed to read host stats", "host", host.Name, "error", err)
+		}
+		return HostStats{Error: err.Error()}
+	}
+	if err := r.store.TouchHost(ctx, host.ID, time.Now()); err != nil {
+		slog.WarnContext(ctx, "Failed to record host contact", "host", host.Name, "error", err)
+	}
+	return HostStats{Online: true, Stats: stats}
+}
+
+// hostUnits lists the units of the projects on each host
+func (r *Registry) hostUnits(ctx context.Context) (map[int64][]string, error) {
+	projects, err := r.store.ListProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	units := map[int64][]string{}
+	for _, p := range projects {
+		if p.HostID == 0 {
+			continue
+		}
+		services, err := r.store.ListServicesByProject(ctx, p.ID)
+		if err != nil {
+			return units, err
+		}
+		for _, sv := range services {
+			units[p.HostID] = append(units[p.HostID], sv.ServiceName())
+		}
+	}
+	return units, nil
+}
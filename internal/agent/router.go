@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// Router is the ServiceManager of a central server: operations on the units
+// of projects assigned to a host go to its agent, and the rest to the local
+// manager. Unit files are always generated locally, so blueprints and
+// secrets only need to exist on the central server.
+type Router struct {
+	local   systemd.ServiceManager
+	hosts   *Registry
+	secrets systemd.SecretResolver
+}
+
+// NewRouter creates a Router over the local manager. secrets resolves the
+// secrets of units installed on agents; may be nil.
+func NewRouter(local systemd.ServiceManager, hosts *Registry, secrets systemd.SecretResolver) *Router {
+	return &Router{local: local, hosts: hosts, secrets: secrets}
+}
+
+// action runs a unit action locally or on the unit's agent
+func (r *Router) action(ctx context.Context, serviceName, action string, local func(context.Context, string) error) error {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return local(ctx, serviceName)
+	}
+	return client.UnitAction(ctx, serviceName, action)
+}
+
+// Start starts a unit
+func (r *Router) Start(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "start", r.local.Start)
+}
+
+// Stop stops a unit
+func (r *Router) Stop(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "stop", r.local.Stop)
+}
+
+// Restart restarts a unit
+func (r *Router) Restart(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "restart", r.local.Restart)
+}
+
+// Enable enables a unit
+func (r *Router) Enable(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "enable", r.local.Enable)
+}
+
+// Disable disables a unit
+func (r *Router) Disable(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "disable", r.local.Disable)
+}
+
+// Status returns a unit's status
+func (r *Router) Status(ctx context.Context, serviceName string) (systemd.ServiceStatus, error) {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return systemd.ServiceStatus{Name: serviceName}, err
+	}
+	if client == nil {
+		return r.local.Status(ctx, serviceName)
+	}
+	info, err := client.unitInfo(ctx, serviceName)
+	if err != nil {
+		return systemd.ServiceStatus{Name: serviceName}, err
+	}
+	return info.Status, nil
+}
+
+// ActiveState returns a unit's active state, or "" when its agent cannot be
+// reached. Hosts that missed their last poll are not asked, since the state
+// watcher calls this for every unit every few seconds.
+func (r *Router) ActiveState(ctx context.Context, serviceName string) string {
+	hostID, err := r.hosts.UnitHost(ctx, serviceName)
+	if err != nil {
+		return ""
+	}
+	if hostID == 0 {
+		return r.local.ActiveState(ctx, serviceName)
+	}
+	if !r.hosts.reachable(hostID) {
+		return ""
+	}
+	client, err := r.hosts.Client(ctx, hostID)
+	if err != nil {
+		return ""
+	}
+	info, err := client.unitInfo(ctx, serviceName)
+	if err != nil {
+		return ""
+	}
+	return info.ActiveState
+}
+
+// GetStartTime returns when a unit last started
+func (r *Router) GetStartTime(ctx context.Context, serviceName string) (string, error) {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return "", err
+	}
+	if client == nil {
+		return r.local.GetStartTime(ctx, serviceName)
+	}
+	info, err := client.unitInfo(ctx, serviceName)
+	if err != nil {
+		return "", err
+	}
+	return info.StartTime, nil
+}
+
+// GetLogsWithTimeRange reads a unit's journal
+func (r *Router) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return "", err
+	}
+	if client == nil {
+		return r.local.GetLogsWithTimeRange(ctx, serviceName, since, until)
+	}
+	return client.Logs(ctx, serviceName, since, until)
+}
+
+// GetLogEntries reads the last lines of a unit's journal
+func (r *Router) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]systemd.JournalEntry, error) {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return r.local.GetLogEntries(ctx, serviceName, since, lines)
+	}
+	return client.Entries(ctx, serviceName, since, lines)
+}
+
+// StreamLogs follows a unit's journal
+func (r *Router) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan systemd.JournalEntry, error) {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return r.local.StreamLogs(ctx, serviceName, cursor)
+	}
+	return client.FollowUnit(ctx, serviceName, cursor)
+}
+
+// StreamUnitLogs follows several units, wherever they run, as one stream.
+// Hosts that cannot be reached are left out.
+func (r *Router) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan systemd.LogLine, error) {
+	var local []string
+	remote := map[int64][]string{}
+	for _, name := range serviceNames {
+		hostID, err := r.hosts.UnitHost(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if hostID == 0 {
+			local = append(local, name)
+		} else {
+			remote[hostID] = append(remote[hostID], name)
+		}
+	}
+	if len(remote) == 0 {
+		return r.local.StreamUnitLogs(ctx, local, lines, after)
+	}
+
+	var sources []<-chan systemd.LogLine
+	if len(local) > 0 {
+		source, err := r.local.StreamUnitLogs(ctx, local, lines, after)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	for hostID, names := range remote {
+		client, err := r.hosts.Client(ctx, hostID)
+		if err == nil {
+			var source <-chan systemd.LogLine
+			if source, err = client.FollowUnits(ctx, names, lines, after); err == nil {
+				sources = append(sources, source)
+				continue
+			}
+		}
+		slog.WarnContext(ctx, "Failed to follow logs on host", "host_id", hostID, "error", err)
+	}
+	return systemd.MergeLogLines(ctx, sources), nil
+}
+
+// GenerateServiceFile generates a unit file locally
+func (r *Router) GenerateServiceFile(service *storage.Service) (string, error) {
+	return r.local.GenerateServiceFile(service)
+}
+
+// InstallService installs a service's unit on the host its project runs on.
+// Units for agents are generated and have their secrets resolved here;
+// containers only run on this server.
+func (r *Router) InstallService(ctx context.Context, service *storage.Service) error {
+	client, err := r.hosts.ForProject(ctx, service.ProjectID)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return r.local.InstallService(ctx, service)
+	}
+	if service.IsContainer() {
+		return fmt.Errorf("containers: %w", ErrLocalOnly)
+	}
+
+	content, err := r.local.GenerateServiceFile(service)
+	if err != nil {
+		return fmt.Errorf("failed to generate service file: %w", err)
+	}
+	private := false
+	if r.secrets != nil && dryrun.FromContext(ctx) == nil {
+		resolved, substituted, err := r.secrets.Resolve(ctx, service, content)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		if substituted {
+			content, private = resolved, true
+		}
+	}
+	return client.InstallUnit(ctx, service, content, private)
+}
+
+// UninstallService removes a unit from the host it runs on
+func (r *Router) UninstallService(ctx context.Context, serviceName string) error {
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return r.local.UninstallService(ctx, serviceName)
+	}
+	return client.UninstallUnit(ctx, serviceName)
+}
+
+// ServiceExists reports whether a unit is installed on the host it runs on
+func (r *Router) ServiceExists(serviceName string) bool {
+	ctx := context.Background()
+	client, err := r.hosts.ForUnit(ctx, serviceName)
+	if err != nil {
+		return false
+	}
+	if client == nil {
+		return r.local.ServiceExists(serviceName)
+	}
+	info, err := client.unitInfo(ctx, serviceName)
+	return err == nil && info.Exists
+}
+
+// Reload reloads the local systemd; agents reload their own after changing units
+func (r *Router) Reload(ctx context.Context) error {
+	return r.local.Reload(ctx)
+}
+
+// Ping checks the local systemd
+func (r *Router) Ping(ctx context.Context) error {
+	return r.local.Ping(ctx)
+}
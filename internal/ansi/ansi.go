@@ -0,0 +1,193 @@
+// Package ansi processes the ANSI escape codes programs write to color their
+// output: it strips them for plain text, or converts SGR (color and style)
+// codes to HTML spans with ansi-* classes for the log viewer.
+package ansi
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Modes for handling escape codes
+const (
+	ModeStrip = "strip" // remove every escape sequence
+	ModeHTML  = "html"  // escape the text and turn SGR codes into spans
+	ModeRaw   = "raw"   // leave the text unchanged
+)
+
+// ErrInvalidMode is wrapped by ParseMode errors
+var ErrInvalidMode = errors.New("invalid ansi mode")
+
+// escapes matches CSI sequences (including SGR), OSC sequences such as
+// hyperlinks and window titles, and the remaining two-byte escapes
+var escapes = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// colors are the names of the eight standard colors, in SGR order
+var colors = [8]string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ParseMode validates a mode, returning def when it is empty
+func ParseMode(mode, def string) (string, error) {
+	switch mode {
+	case "":
+		return def, nil
+	case ModeStrip, ModeHTML, ModeRaw:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: %q must be strip, html, or raw", ErrInvalidMode, mode)
+}
+
+// Apply processes s according to mode
+func Apply(mode, s string) string {
+	switch mode {
+	case ModeStrip:
+		return Strip(s)
+	case ModeHTML:
+		return HTML(s)
+	}
+	return s
+}
+
+// Strip removes escape sequences
+func Strip(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return escapes.ReplaceAllString(s, "")
+}
+
+// HTML escapes s for HTML and wraps text styled by SGR codes in spans, e.g.
+// "\x1b[1;31mfail\x1b[0m" becomes `<span class="ansi-bold ansi-red">fail</span>`.
+// Styles reset at each newline, since every journal line is its own entry.
+// 256-color codes below 16 map to the standard classes; other extended
+// colors are dropped. Other escape sequences are removed.
+func HTML(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return html.EscapeString(s)
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = htmlLine(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// htmlLine converts one line for HTML
+func htmlLine(s string) string {
+	var b strings.Builder
+	var st style
+	text := func(t string) {
+		if t == "" {
+			return
+		}
+		if classes := st.classes(); classes != "" {
+			b.WriteString(`<span class="` + classes + `">` + html.EscapeString(t) + `</span>`)
+		} else {
+			b.WriteString(html.EscapeString(t))
+		}
+	}
+
+	last := 0
+	for _, loc := range escapes.FindAllStringIndex(s, -1) {
+		text(s[last:loc[0]])
+		last = loc[1]
+		if seq := s[loc[0]:loc[1]]; strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m") {
+			st.apply(seq[2 : len(seq)-1])
+		}
+	}
+	text(s[last:])
+	return b.String()
+}
+
+// style is the SGR state in effect
+type style struct {
+	bold, dim, italic, underline bool
+	fg, bg                       string
+}
+
+// apply updates the style with the parameters of an SGR sequence
+func (st *style) apply(params string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		n, err := strconv.Atoi(codes[i])
+		if err != nil {
+			n = 0 // an empty parameter means reset
+		}
+		switch {
+		case n == 0:
+			*st = style{}
+		case n == 1:
+			st.bold = true
+		case n == 2:
+			st.dim = true
+		case n == 3:
+			st.italic = true
+		case n == 4:
+			st.underline = true
+		case n == 22:
+			st.bold, st.dim = false, false
+		case n == 23:
+			st.italic = false
+		case n == 24:
+			st.underline = false
+		case n >= 30 && n <= 37:
+			st.fg = colors[n-30]
+		case n == 39:
+			st.fg = ""
+		case n >= 40 && n <= 47:
+			st.bg = colors[n-40]
+		case n == 49:
+			st.bg = ""
+		case n >= 90 && n <= 97:
+			st.fg = "bright-" + colors[n-90]
+		case n >= 100 && n <= 107:
+			st.bg = "bright-" + colors[n-100]
+		case n == 38 || n == 48:
+			// Extended color: 5;n (256 colors) or 2;r;g;b (true color)
+			var color string
+			if i+2 < len(codes) && codes[i+1] == "5" {
+				if c, err := strconv.Atoi(codes[i+2]); err == nil && c < 16 {
+					color = colors[c%8]
+					if c >= 8 {
+						color = "bright-" + color
+					}
+				}
+				i += 2
+			} else if i+1 < len(codes) && codes[i+1] == "2" {
+				i += 4
+			}
+			if n == 38 {
+				st.fg = color
+			} else {
+				st.bg = color
+			}
+		}
+	}
+}
+
+// classes returns the CSS classes for the style
+func (st style) classes() string {
+	var classes []string
+	if st.bold {
+		classes = append(classes, "ansi-bold")
+	}
+	if st.dim {
+		classes = append(classes, "ansi-dim")
+	}
+	if st.italic {
+		classes = append(classes, "ansi-italic")
+	}
+	if st.underline {
+		classes = append(classes, "ansi-underline")
+	}
+	if st.fg != "" {
+		classes = append(classes, "ansi-"+st.fg)
+	}
+	if st.bg != "" {
+		classes = append(classes, "ansi-bg-"+st.bg)
+	}
+	return strings.Join(classes, " ")
+}
@@ -0,0 +1,68 @@
+package ansi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"\x1b[1;31mfail\x1b[0m", "fail"},
+		{"\x1b[38;5;208morange\x1b[39m text", "orange text"},
+		{"\x1b[2K\x1b[1Gprogress 50%", "progress 50%"},
+		{"\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"\x1b]0;title\x07after", "after"},
+		{"a\x1bMb", "ab"},
+		{"keeps [31m without ESC", "keeps [31m without ESC"},
+	}
+	for _, tt := range tests {
+		if got := Strip(tt.in); got != tt.want {
+			t.Errorf("Strip(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"<b>&", "&lt;b&gt;&amp;"},
+		{"\x1b[1;31mfail\x1b[0m ok", `<span class="ansi-bold ansi-red">fail</span> ok`},
+		{"\x1b[32mgreen\x1b[39m default", `<span class="ansi-green">green</span> default`},
+		{"\x1b[44;97mhi\x1b[m", `<span class="ansi-bright-white ansi-bg-blue">hi</span>`},
+		{"\x1b[38;5;9mred\x1b[0m", `<span class="ansi-bright-red">red</span>`},
+		{"\x1b[38;5;208morange", "orange"},
+		{"\x1b[38;2;1;2;3;1mtrue", `<span class="ansi-bold">true</span>`},
+		{"\x1b[1mbold\x1b[22m\x1b[4mu\x1b[24m", `<span class="ansi-bold">bold</span><span class="ansi-underline">u</span>`},
+		{"\x1b[31mone\ntwo", "<span class=\"ansi-red\">one</span>\ntwo"}, // styles end with the line
+		{"\x1b[31m<x>", `<span class="ansi-red">&lt;x&gt;</span>`},
+		{"\x1b]0;title\x07text", "text"},
+	}
+	for _, tt := range tests {
+		if got := HTML(tt.in); got != tt.want {
+			t.Errorf("HTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		mode, want string
+		wantErr    bool
+	}{
+		{"", ModeHTML, false},
+		{ModeStrip, ModeStrip, false},
+		{ModeRaw, ModeRaw, false},
+		{"HTML", "", true},
+		{"color", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.mode, ModeHTML)
+		if tt.wantErr != errors.Is(err, ErrInvalidMode) || got != tt.want {
+			t.Errorf("ParseMode(%q) = %q, %v; want %q", tt.mode, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package appmetrics receives custom metrics that managed applications push,
+// such as request counts or queue depth, over StatsD (UDP) or OTLP/HTTP with
+// JSON encoding. An Aggregator sums them up per service and metric until
+// they are flushed, once a minute, to be stored beside the host's samples.
+package appmetrics
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Metric kinds
+const (
+	KindCounter = "counter" // summed over the interval
+	KindGauge   = "gauge"   // the last value
+	KindTimer   = "timer"   // the mean of the interval's observations
+)
+
+// maxSeries bounds how many metrics are held between flushes, so a client
+// sending ever-new names cannot grow memory without limit
+const maxSeries = 5000
+
+// ErrInvalidName is returned for a metric or service name that cannot be stored
+var ErrInvalidName = errors.New("invalid metric name")
+
+// namePattern matches the metric and service names accepted
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-]{0,127}$`)
+
+// Point is one observation pushed by a service. For timers, Value is the sum
+// of Count observations (0 counts as 1); for gauges, Delta adds Value to the
+// current value instead of replacing it.
+type Point struct {
+	Service string
+	Name    string
+	Kind    string
+	Value   float64
+	Count   int64
+	Delta   bool
+}
+
+// Aggregate is a metric summed up over one interval: a counter's total, a
+// gauge's last value, or a timer's mean of Count observations
+type Aggregate struct {
+	Service string
+	Name    string
+	Kind    string
+	Value   float64
+	Count   int64
+}
+
+type seriesKey struct{ service, name string }
+
+// Aggregator sums up points between flushes. It is safe for concurrent use.
+type Aggregator struct {
+	mu      sync.Mutex
+	series  map[seriesKey]*Aggregate
+	gauges  map[seriesKey]float64 // last gauge values, the base for deltas in later intervals; bounded by maxSeries
+	dropped int64
+}
+
+// NewAggregator creates an empty aggregator
+func NewAggregator() *Aggregator {
+	return &Aggregator{series: map[seriesKey]*Aggregate{}, gauges: map[seriesKey]float64{}}
+}
+
+// Add records a point. A metric whose kind changes starts over as the new
+// kind.
+func (a *Aggregator) Add(p Point) error {
+	if !namePattern.MatchString(p.Service) || !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("%w: %s.%s", ErrInvalidName, p.Service, p.Name)
+	}
+	if p.Kind != KindCounter && p.Kind != KindGauge && p.Kind != KindTimer {
+		return fmt.Errorf("unknown metric kind %q", p.Kind)
+	}
+	count := max(p.Count, 1)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := seriesKey{p.Service, p.Name}
+	agg := a.series[key]
+	if agg == nil || agg.Kind != p.Kind {
+		if agg == nil && len(a.series) >= maxSeries {
+			a.dropped++
+			return nil
+		}
+		agg = &Aggregate{Service: p.Service, Name: p.Name, Kind: p.Kind}
+		a.series[key] = agg
+	}
+	switch p.Kind {
+	case KindCounter:
+		agg.Value += p.Value
+		agg.Count++
+	case KindGauge:
+		value := p.Value
+		if p.Delta {
+			value += a.gauges[key]
+		}
+		agg.Value = value
+		agg.Count++
+		if _, known := a.gauges[key]; known || len(a.gauges) < maxSeries {
+			a.gauges[key] = value
+		}
+	case KindTimer:
+		// Value holds the sum until Flush divides it
+		agg.Value += p.Value
+		agg.Count += count
+	}
+	return nil
+}
+
+// Flush returns the metrics summed up since the last flush, sorted by
+// service and name, and starts a new interval. Dropped reports how many
+// points were discarded for exceeding the series limit.
+func (a *Aggregator) Flush() (aggregates []Aggregate, dropped int64) {
+	a.mu.Lock()
+	series, dropped := a.series, a.dropped
+	a.series, a.dropped = map[seriesKey]*Aggregate{}, 0
+	a.mu.Unlock()
+
+	aggregates = make([]Aggregate, 0, len(series))
+	for _, agg := range series {
+		if agg.Kind == KindTimer && agg.Count > 0 {
+			agg.Value /= float64(agg.Count)
+		}
+		aggregates = append(aggregates, *agg)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		if aggregates[i].Service != aggregates[j].Service {
+			return aggregates[i].Service < aggregates[j].Service
+		}
+		return aggregates[i].Name < aggregates[j].Name
+	})
+	return aggregates, dropped
+}
@@ -0,0 +1,251 @@
+package appmetrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OTLPPath is where OTLP/HTTP exporters send metrics
+const OTLPPath = "/v1/metrics"
+
+// maxOTLPBody bounds an export request
+const maxOTLPBody = 4 << 20
+
+// OTLP aggregation temporalities
+const (
+	temporalityDelta      = 1
+	temporalityCumulative = 2
+)
+
+// otlpRequest is the part of an OTLP/JSON ExportMetricsServiceRequest that is read
+type otlpRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []otlpMetric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string         `json:"stringValue"`
+		IntValue    json.RawMessage `json:"intValue"`
+		BoolValue   *bool           `json:"boolValue"`
+		DoubleValue *float64        `json:"doubleValue"`
+	} `json:"value"`
+}
+
+// text renders an attribute's value for telling series apart
+func (a otlpAttribute) text() string {
+	v := a.Value
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return strings.Trim(string(v.IntValue), `"`)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+type otlpMetric struct {
+	Name  string `json:"name"`
+	Gauge *struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	} `json:"gauge"`
+	Sum *struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	} `json:"sum"`
+	Histogram *struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	} `json:"histogram"`
+}
+
+// otlpNumberPoint carries asInt as a string, as OTLP/JSON encodes 64-bit integers
+type otlpNumberPoint struct {
+	Attributes []otlpAttribute `json:"attributes"`
+	AsDouble   *float64        `json:"asDouble"`
+	AsInt      json.Number     `json:"asInt"`
+}
+
+func (p otlpNumberPoint) value() (float64, error) {
+	if p.AsDouble != nil {
+		return *p.AsDouble, nil
+	}
+	return p.AsInt.Float64()
+}
+
+type otlpHistogramPoint struct {
+	Attributes []otlpAttribute `json:"attributes"`
+	Count      json.Number     `json:"count"`
+	Sum        *float64        `json:"sum"`
+}
+
+// OTLPHandler receives OTLP/HTTP metric exports in JSON encoding. The
+// service is the resource's service.name attribute. Points with different
+// attributes are added up into one metric. Cumulative sums and histograms
+// are turned into the change since the previous export; the first export of
+// a series only sets the base.
+type OTLPHandler struct {
+	agg *Aggregator
+
+	mu         sync.Mutex
+	cumulative map[string][2]float64 // series (with attributes) → last value and count; bounded by maxSeries
+}
+
+// NewOTLPHandler creates a handler adding what it receives to agg
+func NewOTLPHandler(agg *Aggregator) *OTLPHandler {
+	return &OTLPHandler{agg: agg, cumulative: map[string][2]float64{}}
+}
+
+func (h *OTLPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != OTLPPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Only OTLP/JSON is supported; set the exporter's protocol to http/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxOTLPBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid OTLP/JSON request", http.StatusBadRequest)
+		return
+	}
+
+	rejected := 0
+	for _, rm := range req.ResourceMetrics {
+		service := ""
+		for _, a := range rm.Resource.Attributes {
+			if a.Key == "service.name" {
+				service = a.text()
+			}
+		}
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				n, err := h.add(service, m)
+				if err != nil {
+					slog.Debug("Skipped OTLP metric", "service", service, "metric", m.Name, "error", err)
+				}
+				rejected += n
+			}
+		}
+	}
+
+	// An ExportMetricsServiceResponse; partial success reports what was rejected
+	w.Header().Set("Content-Type", "application/json")
+	if rejected > 0 {
+		fmt.Fprintf(w, `{"partialSuccess":{"rejectedDataPoints":"%d","errorMessage":"unsupported or invalid data points"}}`, rejected)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+// add records a metric's data points and returns how many were rejected
+func (h *OTLPHandler) add(service string, m otlpMetric) (rejected int, err error) {
+	record := func(p Point) {
+		if err := h.agg.Add(p); err != nil {
+			rejected++
+		}
+	}
+	switch {
+	case m.Gauge != nil:
+		for _, dp := range m.Gauge.DataPoints {
+			v, err := dp.value()
+			if err != nil {
+				rejected++
+				continue
+			}
+			record(Point{Service: service, Name: m.Name, Kind: KindGauge, Value: v})
+		}
+	case m.Sum != nil:
+		for _, dp := range m.Sum.DataPoints {
+			v, err := dp.value()
+			if err != nil {
+				rejected++
+				continue
+			}
+			switch {
+			case !m.Sum.IsMonotonic:
+				// An up-down counter reports a level, like a gauge
+				record(Point{Service: service, Name: m.Name, Kind: KindGauge, Value: v})
+			case m.Sum.AggregationTemporality == temporalityCumulative:
+				if delta, _, ok := h.change(service, m.Name, dp.Attributes, v, 0); ok {
+					record(Point{Service: service, Name: m.Name, Kind: KindCounter, Value: delta})
+				}
+			default:
+				record(Point{Service: service, Name: m.Name, Kind: KindCounter, Value: v})
+			}
+		}
+	case m.Histogram != nil:
+		for _, dp := range m.Histogram.DataPoints {
+			count, err := dp.Count.Float64()
+			if err != nil || dp.Sum == nil {
+				rejected++
+				continue
+			}
+			sum := *dp.Sum
+			if m.Histogram.AggregationTemporality == temporalityCumulative {
+				var ok bool
+				if sum, count, ok = h.change(service, m.Name, dp.Attributes, sum, count); !ok {
+					continue
+				}
+			}
+			if count > 0 {
+				record(Point{Service: service, Name: m.Name, Kind: KindTimer, Value: sum, Count: int64(count)})
+			}
+		}
+	default:
+		return 1, fmt.Errorf("unsupported metric type")
+	}
+	return rejected, nil
+}
+
+// change returns how much a cumulative series grew since its last export.
+// A series that went down was restarted, so its whole value is new. ok is
+// false for the first export of a series.
+func (h *OTLPHandler) change(service, name string, attrs []otlpAttribute, value, count float64) (dValue, dCount float64, ok bool) {
+	parts := make([]string, len(attrs))
+	for i, a := range attrs {
+		parts[i] = a.Key + "=" + a.text()
+	}
+	sort.Strings(parts)
+	key := service + "\x00" + name + "\x00" + strings.Join(parts, "\x00")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, seen := h.cumulative[key]
+	if seen || len(h.cumulative) < maxSeries {
+		h.cumulative[key] = [2]float64{value, count}
+	}
+	if !seen {
+		return 0, 0, false
+	}
+	if value < last[0] || count < last[1] {
+		return value, count, true
+	}
+	return value - last[0], count - last[1], true
+}
@@ -0,0 +1,105 @@
+package appmetrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxPacket is the largest StatsD datagram read; larger ones are cut off
+const maxPacket = 64 * 1024
+
+// ParseStatsD parses one StatsD line: name:value|type, optionally followed by
+// |@rate and DogStatsD |#tag:value,... sections. Types are c (counter), g
+// (gauge, with +N or -N changing it), and ms, h, or d (timers). The service
+// is the service tag, or else the name's first dotted segment, so
+// web.requests:1|c and requests:1|c|#service:web are the same metric.
+func ParseStatsD(line string) (Point, error) {
+	var p Point
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return p, fmt.Errorf("%q has no value", line)
+	}
+	sections := strings.Split(rest, "|")
+	if len(sections) < 2 {
+		return p, fmt.Errorf("%q has no type", line)
+	}
+	value, err := strconv.ParseFloat(sections[0], 64)
+	if err != nil {
+		return p, fmt.Errorf("%q has an invalid value", line)
+	}
+	rate := 1.0
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			if rate, err = strconv.ParseFloat(section[1:], 64); err != nil || rate <= 0 || rate > 1 {
+				return p, fmt.Errorf("%q has an invalid sample rate", line)
+			}
+		case strings.HasPrefix(section, "#"):
+			for _, tag := range strings.Split(section[1:], ",") {
+				if service, ok := strings.CutPrefix(tag, "service:"); ok {
+					p.Service = service
+				}
+			}
+		}
+	}
+
+	switch sections[1] {
+	case "c":
+		p.Kind, p.Value = KindCounter, value/rate
+	case "g":
+		p.Kind, p.Value = KindGauge, value
+		p.Delta = strings.HasPrefix(sections[0], "+") || strings.HasPrefix(sections[0], "-")
+	case "ms", "h", "d":
+		// A sampled timer stands for 1/rate observations of the value
+		p.Kind, p.Value, p.Count = KindTimer, value/rate, int64(1/rate+0.5)
+	default:
+		return p, fmt.Errorf("%q has unsupported type %s", line, sections[1])
+	}
+
+	p.Name = name
+	if p.Service == "" {
+		if p.Service, p.Name, ok = strings.Cut(name, "."); !ok {
+			return p, fmt.Errorf("%q names no service: prefix it with the service name or tag it service:NAME", line)
+		}
+	}
+	return p, nil
+}
+
+// ServeStatsD reads StatsD datagrams from conn into agg until ctx is done,
+// then closes conn. A datagram holds one line or several separated by
+// newlines; lines that fail to parse are logged at debug level and skipped.
+func ServeStatsD(ctx context.Context, conn net.PacketConn, agg *Aggregator) {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, maxPacket)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("StatsD listener stopped", "error", err)
+			}
+			return
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			p, err := ParseStatsD(string(line))
+			if err == nil {
+				err = agg.Add(p)
+			}
+			if err != nil {
+				slog.Debug("Skipped StatsD metric", "from", addr, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package appmetrics
+
+import (
+	"testing"
+)
+
+func TestParseStatsD(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    Point
+		wantErr bool
+	}{
+		{line: "web.requests:1|c", want: Point{Service: "web", Name: "requests", Kind: KindCounter, Value: 1}},
+		{line: "web.http.requests:3|c|@0.5", want: Point{Service: "web", Name: "http.requests", Kind: KindCounter, Value: 6}},
+		{line: "requests:1|c|#env:prod,service:web", want: Point{Service: "web", Name: "requests", Kind: KindCounter, Value: 1}},
+		{line: "web.queue:42|g", want: Point{Service: "web", Name: "queue", Kind: KindGauge, Value: 42}},
+		{line: "web.queue:-3|g", want: Point{Service: "web", Name: "queue", Kind: KindGauge, Value: -3, Delta: true}},
+		{line: "web.queue:+3|g", want: Point{Service: "web", Name: "queue", Kind: KindGauge, Value: 3, Delta: true}},
+		{line: "web.latency:12.5|ms", want: Point{Service: "web", Name: "latency", Kind: KindTimer, Value: 12.5, Count: 1}},
+		{line: "web.latency:10|h|@0.25", want: Point{Service: "web", Name: "latency", Kind: KindTimer, Value: 40, Count: 4}},
+		{line: "web.latency:10|d", want: Point{Service: "web", Name: "latency", Kind: KindTimer, Value: 10, Count: 1}},
+		{line: "requests:1|c", wantErr: true}, // no service
+		{line: "web.requests", wantErr: true},
+		{line: "web.requests:1", wantErr: true},
+		{line: "web.requests:one|c", wantErr: true},
+		{line: "web.requests:1|s", wantErr: true},
+		{line: "web.requests:1|c|@0", wantErr: true},
+		{line: "web.requests:1|c|@2", wantErr: true},
+		{line: "web.requests:1|c|@x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStatsD(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseStatsD(%q) = %+v, want an error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseStatsD(%q) = %+v, %v; want %+v", tt.line, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// Categories of host actions recorded in the audit trail
+const (
+	CategorySystemd   = "systemd"
+	CategoryNginx     = "nginx"
+	CategoryGit       = "git"
+	CategoryContainer = "container"
+	CategoryDatabase  = "database"
+	CategoryEnv       = "env"
+	CategoryUser      = "user"
+	CategoryPackages  = "packages"
+	CategoryBuild     = "build"
+	CategoryAuth      = "auth"
+	CategoryExec      = "exec"
+)
+
+// maxOutputBytes caps how much command output is persisted per entry
+const maxOutputBytes = 64 * 1024
+
+// Recorder persists audit entries
+type Recorder interface {
+	Record(ctx context.Context, entry *storage.AuditEntry)
+}
+
+var (
+	mu       sync.RWMutex
+	recorder Recorder
+)
+
+// SetRecorder installs the recorder used by Run and Log. A nil recorder disables auditing.
+func SetRecorder(r Recorder) {
+	mu.Lock()
+	defer mu.Unlock()
+	recorder = r
+}
+
+type targetKey struct{}
+
+type target struct {
+	projectID int64
+	serviceID int64
+}
+
+// WithTarget attributes actions performed with ctx to a project and (optionally) a service
+func WithTarget(ctx context.Context, projectID, serviceID int64) context.Context {
+	return context.WithValue(ctx, targetKey{}, target{projectID: projectID, serviceID: serviceID})
+}
+
+// stopGrace is how long a command whose context was cancelled has to exit
+// after SIGTERM before it is killed and its output pipes are closed, which
+// children it left holding them would otherwise keep open
+const stopGrace = 10 * time.Second
+
+// stoppable makes a cancelled cmd exit the way systemd would stop it: SIGTERM,
+// which sudo passes on, then SIGKILL after stopGrace
+func stoppable(cmd *exec.Cmd) {
+	if cmd.Cancel == nil {
+		return // not started with exec.CommandContext
+	}
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = stopGrace
+}
+
+// Run executes cmd, captures its combined output, and records the result.
+// It returns the output and error exactly like cmd.CombinedOutput.
+func Run(ctx context.Context, category, action string, cmd *exec.Cmd) ([]byte, error) {
+	// A dry run only records the command; nothing happened, so nothing is audited
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(cmd.Args)
+		return nil, nil
+	}
+
+	stoppable(cmd)
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	Log(ctx, category, action, strings.Join(cmd.Args, " "), string(output), err, time.Since(start))
+	return output, err
+}
+
+// Stream is Run for commands worth watching: each line of the command's
+// stdout and stderr is passed to out as it is printed, with the stream it
+// came from, "stdout" or "stderr". out is called from one goroutine at a time.
+func Stream(ctx context.Context, category, action string, cmd *exec.Cmd, out func(stream, line string)) ([]byte, error) {
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(cmd.Args)
+		return nil, nil
+	}
+
+	var (
+		outMu    sync.Mutex
+		combined bytes.Buffer
+		wg       sync.WaitGroup
+	)
+	scan := func(stream string, r io.Reader) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			outMu.Lock()
+			combined.WriteString(scanner.Text() + "\n")
+			out(stream, scanner.Text())
+			outMu.Unlock()
+		}
+		io.Copy(io.Discard, r) // a line too long for the scanner must not block the command
+	}
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	wg.Add(2)
+	go scan("stdout", stdoutR)
+	go scan("stderr", stderrR)
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+
+	stoppable(cmd)
+	start := time.Now()
+	err := cmd.Run()
+	stdoutW.Close()
+	stderrW.Close()
+	wg.Wait()
+
+	output := combined.Bytes()
+	Log(ctx, category, action, strings.Join(cmd.Args, " "), string(output), err, time.Since(start))
+	return output, err
+}
+
+// Log records an action that did not go through Run, such as writing a config file
+func Log(ctx context.Context, category, action, command, output string, err error, duration time.Duration) {
+	mu.RLock()
+	r := recorder
+	mu.RUnlock()
+	if r == nil {
+		return
+	}
+
+	if len(output) > maxOutputBytes {
+		output = output[:maxOutputBytes] + "\n... (truncated)"
+	}
+
+	entry := &storage.AuditEntry{
+		Actor:      storage.ActorFromContext(ctx),
+		Category:   category,
+		Action:     action,
+		Command:    command,
+		Output:     output,
+		Success:    err == nil,
+		DurationMS: duration.Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if t, ok := ctx.Value(targetKey{}).(target); ok {
+		entry.ProjectID = t.projectID
+		entry.ServiceID = t.serviceID
+	}
+
+	r.Record(ctx, entry)
+}
+
+// StoreRecorder writes audit entries to the database
+type StoreRecorder struct {
+	store storage.Store
+}
+
+// NewStoreRecorder creates a Recorder backed by storage
+func NewStoreRecorder(store storage.Store) *StoreRecorder {
+	return &StoreRecorder{store: store}
+}
+
+// Record persists an entry. It detaches from request cancellation so that
+// actions are still recorded when the client disconnects mid-request.
+func (r *StoreRecorder) Record(ctx context.Context, entry *storage.AuditEntry) {
+	ctx = context.WithoutCancel(ctx)
+	if err := r.store.CreateAuditEntry(ctx, entry); err != nil && !errors.Is(err, context.Canceled) {
+		slog.WarnContext(ctx, "Failed to record audit entry", "action", entry.Action, "error", err)
+	}
+}
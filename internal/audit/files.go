@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// FileKeeper keeps the content of the files Servio generates on the host,
+// and which units it enabled, so they can be put back after /etc is lost or
+// the host is rebuilt
+type FileKeeper interface {
+	KeepFile(ctx context.Context, file *storage.Artifact)
+	ForgetFile(ctx context.Context, path string)
+	KeepEnabled(ctx context.Context, unit string, projectID, serviceID int64, enabled bool)
+}
+
+var (
+	keeperMu sync.RWMutex
+	keeper   FileKeeper
+)
+
+// SetFileKeeper installs the keeper used by WroteFile and RemovedFile. A nil
+// keeper keeps nothing.
+func SetFileKeeper(k FileKeeper) {
+	keeperMu.Lock()
+	defer keeperMu.Unlock()
+	keeper = k
+}
+
+func fileKeeper() FileKeeper {
+	keeperMu.RLock()
+	defer keeperMu.RUnlock()
+	return keeper
+}
+
+// WroteFile records the content just written to path, attributed to the
+// project and service of WithTarget. Writers call it once the file is in
+// place, never in a dry run.
+func WroteFile(ctx context.Context, category, path string, content []byte, mode os.FileMode) {
+	k := fileKeeper()
+	if k == nil || dryrun.FromContext(ctx) != nil {
+		return
+	}
+	sum := sha256.Sum256(content)
+	file := &storage.Artifact{
+		Path:     path,
+		Category: category,
+		Mode:     fmt.Sprintf("%04o", mode.Perm()),
+		Size:     len(content),
+		Checksum: hex.EncodeToString(sum[:]),
+		Content:  content,
+	}
+	if t, ok := ctx.Value(targetKey{}).(target); ok {
+		file.ProjectID = t.projectID
+		file.ServiceID = t.serviceID
+	}
+	k.KeepFile(ctx, file)
+}
+
+// RemovedFile forgets path, and everything under it when it was a directory
+func RemovedFile(ctx context.Context, path string) {
+	k := fileKeeper()
+	if k == nil || dryrun.FromContext(ctx) != nil {
+		return
+	}
+	k.ForgetFile(ctx, path)
+}
+
+// UnitEnabled records that unit was just enabled or disabled, attributed
+// like WroteFile
+func UnitEnabled(ctx context.Context, unit string, enabled bool) {
+	k := fileKeeper()
+	if k == nil || dryrun.FromContext(ctx) != nil {
+		return
+	}
+	t, _ := ctx.Value(targetKey{}).(target)
+	k.KeepEnabled(ctx, unit, t.projectID, t.serviceID, enabled)
+}
+
+// Sealer encrypts and decrypts file content; secrets.Cipher is one
+type Sealer interface {
+	Encrypt(plaintext string) ([]byte, error)
+	Decrypt(ciphertext []byte) (string, error)
+}
+
+// FileContent returns the content of a kept file as it was written,
+// decrypting it when it was sealed
+func FileContent(file *storage.Artifact, sealer Sealer) ([]byte, error) {
+	if !file.Encrypted {
+		return file.Content, nil
+	}
+	content, err := sealer.Decrypt(file.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", file.Path, err)
+	}
+	return []byte(content), nil
+}
+
+// StoreKeeper keeps files in the database. Files no one but their owner may
+// read, such as env files and DNS credentials, hold secrets and are sealed.
+type StoreKeeper struct {
+	store  storage.Store
+	sealer Sealer
+}
+
+// NewStoreKeeper creates a FileKeeper backed by storage
+func NewStoreKeeper(store storage.Store, sealer Sealer) *StoreKeeper {
+	return &StoreKeeper{store: store, sealer: sealer}
+}
+
+// KeepFile saves a file. Like Record, it detaches from request cancellation
+// and only logs failures: the file itself was written.
+func (k *StoreKeeper) KeepFile(ctx context.Context, file *storage.Artifact) {
+	ctx = context.WithoutCancel(ctx)
+	if private(file.Mode) {
+		sealed, err := k.sealer.Encrypt(string(file.Content))
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to encrypt generated file", "path", file.Path, "error", err)
+			return
+		}
+		file.Content, file.Encrypted = sealed, true
+	}
+	if err := k.store.SaveArtifact(ctx, file); err != nil && !errors.Is(err, context.Canceled) {
+		slog.WarnContext(ctx, "Failed to keep generated file", "path", file.Path, "error", err)
+	}
+}
+
+// ForgetFile deletes what was kept for a removed path
+func (k *StoreKeeper) ForgetFile(ctx context.Context, path string) {
+	ctx = context.WithoutCancel(ctx)
+	if err := k.store.DeleteArtifacts(ctx, path); err != nil && !errors.Is(err, context.Canceled) {
+		slog.WarnContext(ctx, "Failed to forget generated file", "path", path, "error", err)
+	}
+}
+
+// KeepEnabled records a unit's enablement
+func (k *StoreKeeper) KeepEnabled(ctx context.Context, unit string, projectID, serviceID int64, enabled bool) {
+	ctx = context.WithoutCancel(ctx)
+	if err := k.store.SetUnitEnabled(ctx, unit, projectID, serviceID, enabled); err != nil && !errors.Is(err, context.Canceled) {
+		slog.WarnContext(ctx, "Failed to record unit enablement", "unit", unit, "error", err)
+	}
+}
+
+// private reports whether an octal mode gives group and others no access
+func private(mode string) bool {
+	var perm os.FileMode
+	if _, err := fmt.Sscanf(mode, "%o", &perm); err != nil {
+		return true
+	}
+	return perm&0o077 == 0
+}
@@ -0,0 +1,167 @@
+package blueprints
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"servio/internal/audit"
+	"servio/internal/storage"
+)
+
+// Toolchains asset builds run with
+const (
+	ToolchainPython = "python"
+	ToolchainNode   = "node"
+)
+
+// AssetBuild is a step of a deploy that builds static files for nginx to
+// serve, such as Django's collectstatic or a frontend's npm run build
+type AssetBuild struct {
+	Name      string     // shown in deploy logs
+	Toolchain string     // ToolchainPython or ToolchainNode
+	Dir       string     // where Commands run
+	Marker    string     // file in Dir the build needs; without it the build is skipped
+	Commands  [][]string // run in order as the service's user
+	URLPath   string     // location nginx serves Output at, such as /static/
+	Output    string     // directory the build fills
+	SPA       bool       // paths under URLPath without a file get Output's index.html
+}
+
+// AssetBuilder is implemented by web blueprints whose services build static
+// files when they are deployed
+type AssetBuilder interface {
+	// AssetBuilds returns a service's builds. They depend only on the
+	// service, so its nginx site can list their outputs before a deploy.
+	AssetBuilds(service *storage.Service) []AssetBuild
+}
+
+// AssetBuilds returns the asset builds of a service, none when its
+// blueprint builds no assets
+func (r *Registry) AssetBuilds(service *storage.Service) []AssetBuild {
+	bp, ok := r.Get(service.Type)
+	if !ok {
+		return nil
+	}
+	if builder, ok := bp.(AssetBuilder); ok {
+		return builder.AssetBuilds(service)
+	}
+	return nil
+}
+
+// BuildAssets runs a service's asset builds in order, in the environment its
+// unit gets from the blueprint and the service. Builds whose marker file is
+// missing are skipped; a missing toolchain is installed first. Commands and
+// their output go to out. It is deploy.AssetBuilder.
+func (r *Registry) BuildAssets(ctx context.Context, service *storage.Service, out Output) error {
+	builds := r.AssetBuilds(service)
+	if len(builds) == 0 {
+		return nil
+	}
+	env := unitEnvironment(r, service)
+	ctx = WithOutput(ctx, out)
+	for _, b := range builds {
+		if b.Marker != "" {
+			if _, err := os.Stat(filepath.Join(b.Dir, b.Marker)); err != nil {
+				out("stdout", fmt.Sprintf("skipping %s: no %s in %s", b.Name, b.Marker, b.Dir))
+				continue
+			}
+		}
+		if err := EnsureToolchain(ctx, b.Toolchain); err != nil {
+			return err
+		}
+		for _, args := range b.Commands {
+			cmd := asUser(ctx, service.User, env, args)
+			cmd.Dir = b.Dir
+			out("command", strings.Join(args, " "))
+			if _, err := audit.Stream(ctx, audit.CategoryBuild, b.Name, cmd, out); err != nil {
+				return fmt.Errorf("%s failed: %s: %w", b.Name, strings.Join(args, " "), err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDir returns dir relative to base, or def relative to base when dir is empty
+func resolveDir(base, dir, def string) string {
+	if dir == "" {
+		dir = def
+	}
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(base, dir)
+}
+
+// urlPath returns path as an nginx location prefix, starting and ending
+// with a slash, or def when it is empty
+func urlPath(path, def string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return def
+	}
+	return "/" + path + "/"
+}
+
+// EnsureToolchain installs what a toolchain's builds need when it is not on
+// the PATH: Node.js with npm, or Python 3, with dnf or else apt-get
+func EnsureToolchain(ctx context.Context, toolchain string) error {
+	var probe string
+	var dnf, apt []string
+	switch toolchain {
+	case ToolchainNode:
+		probe, dnf, apt = "npm", []string{"nodejs", "npm"}, []string{"nodejs", "npm"}
+	case ToolchainPython:
+		probe, dnf, apt = "python3", []string{"python3"}, []string{"python3", "python3-venv"}
+	default:
+		return fmt.Errorf("unknown toolchain %q", toolchain)
+	}
+	if _, err := exec.LookPath(probe); err == nil {
+		return nil
+	}
+	outputFrom(ctx)("stdout", fmt.Sprintf("%s not found, installing %s", probe, strings.Join(apt, " ")))
+	if _, err := run(ctx, "sudo", append([]string{"dnf", "install", "-y"}, dnf...)...); err != nil {
+		if _, err := run(ctx, "sudo", append([]string{"apt-get", "install", "-y"}, apt...)...); err != nil {
+			return fmt.Errorf("failed to install the %s toolchain: %w", toolchain, err)
+		}
+	}
+	return nil
+}
+
+// unitEnvironment returns the KEY=VALUE variables a service's unit sets:
+// the blueprint's, then the service's own. $PATH, which blueprints use to
+// put a virtualenv first, is expanded.
+func unitEnvironment(r *Registry, service *storage.Service) []string {
+	environment := service.Environment
+	if bp, ok := r.Get(service.Type); ok {
+		environment = bp.GenerateEnvironment(service) + "\n" + environment
+	}
+	var env []string
+	for _, line := range strings.Split(environment, "\n") {
+		if line = strings.TrimSpace(line); strings.Contains(line, "=") {
+			env = append(env, strings.ReplaceAll(line, "$PATH", os.Getenv("PATH")))
+		}
+	}
+	return env
+}
+
+// asUser returns a command running args as name with env added to the
+// environment. Other users are switched to with runuser as root, or else
+// sudo; env goes on the command line through env(1), as sudo drops it.
+func asUser(ctx context.Context, name string, env, args []string) *exec.Cmd {
+	if current, err := user.Current(); name == "" || err == nil && current.Username == name {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), env...)
+		return cmd
+	}
+	switcher := []string{"sudo", "-u", name, "--"}
+	if os.Geteuid() == 0 {
+		switcher = []string{"runuser", "-u", name, "--"}
+	}
+	argv := append(append(append(switcher, "env"), env...), args...)
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
@@ -2,6 +2,7 @@ package blueprints
 
 import (
 	"context"
+	"sync"
 
 	"servio/internal/storage"
 )
@@ -55,9 +56,12 @@ type Blueprint interface {
 	InstallDependencies(ctx context.Context, version string) error
 }
 
-// Registry holds all registered blueprints and provides discovery
+// Registry holds all registered blueprints and provides discovery.
+// Blueprints installed from the catalog come and go while it is in use.
 type Registry struct {
+	mu         sync.RWMutex
 	blueprints map[string]Blueprint
+	builtin    map[string]bool
 }
 
 // NewRegistry creates a new blueprint registry with all built-in blueprints.
@@ -70,25 +74,51 @@ func NewRegistry() *Registry {
 	// Register built-in blueprints
 	// Add new blueprints here:
 	r.Register(&DjangoBlueprint{})
+	r.Register(&CeleryBlueprint{})
+	r.Register(&SidekiqBlueprint{})
 	// r.Register(&MongoDBBlueprint{})
 	// r.Register(&NodeBlueprint{})
 
+	r.builtin = make(map[string]bool, len(r.blueprints))
+	for t := range r.blueprints {
+		r.builtin[t] = true
+	}
 	return r
 }
 
 // Register adds a blueprint to the registry
 func (r *Registry) Register(bp Blueprint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.blueprints[bp.Type()] = bp
 }
 
+// Unregister removes a blueprint that is not built in
+func (r *Registry) Unregister(serviceType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.builtin[serviceType] {
+		delete(r.blueprints, serviceType)
+	}
+}
+
+// IsBuiltin reports whether a type is one of the blueprints compiled in
+func (r *Registry) IsBuiltin(serviceType string) bool {
+	return r.builtin[serviceType]
+}
+
 // Get retrieves a blueprint by type
 func (r *Registry) Get(serviceType string) (Blueprint, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	bp, ok := r.blueprints[serviceType]
 	return bp, ok
 }
 
 // Types returns all registered blueprint type identifiers
 func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	types := make([]string, 0, len(r.blueprints))
 	for t := range r.blueprints {
 		types = append(types, t)
@@ -99,6 +129,8 @@ func (r *Registry) Types() []string {
 // AllMetadata returns metadata for all registered blueprints.
 // Used by the API to populate the service form dynamically.
 func (r *Registry) AllMetadata() []BlueprintMetadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	metas := make([]BlueprintMetadata, 0, len(r.blueprints))
 	for _, bp := range r.blueprints {
 		metas = append(metas, bp.Metadata())
@@ -108,7 +140,7 @@ func (r *Registry) AllMetadata() []BlueprintMetadata {
 
 // GetDefaults returns the defaults for a specific blueprint and version
 func (r *Registry) GetDefaults(serviceType, version string) (BlueprintDefaults, bool) {
-	bp, ok := r.blueprints[serviceType]
+	bp, ok := r.Get(serviceType)
 	if !ok {
 		return BlueprintDefaults{}, false
 	}
@@ -117,6 +149,6 @@ func (r *Registry) GetDefaults(serviceType, version string) (BlueprintDefaults,
 
 // IsManaged returns true if the service type has a blueprint
 func (r *Registry) IsManaged(serviceType string) bool {
-	_, ok := r.blueprints[serviceType]
+	_, ok := r.Get(serviceType)
 	return ok
 }
@@ -0,0 +1,157 @@
+package blueprints
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// catalogTimeout bounds each request to the catalog
+	catalogTimeout = 15 * time.Second
+	// maxCatalogFile bounds the index and each definition file
+	maxCatalogFile = 1 << 20
+)
+
+var (
+	// ErrCatalogNotConfigured is returned when the catalog URL or key is not set
+	ErrCatalogNotConfigured = errors.New("blueprint catalog not configured")
+	// ErrCatalogUnavailable is wrapped when the catalog cannot be fetched
+	ErrCatalogUnavailable = errors.New("blueprint catalog unavailable")
+	// ErrBadSignature is wrapped when the index signature or a definition's hash does not match
+	ErrBadSignature = errors.New("blueprint catalog verification failed")
+	// ErrNotInCatalog is wrapped when the catalog has no blueprint of a type
+	ErrNotInCatalog = errors.New("blueprint not in catalog")
+)
+
+// CatalogEntry is one blueprint the catalog index offers
+type CatalogEntry struct {
+	BlueprintMetadata
+	File   string `json:"file"`   // definition file, relative to the index
+	SHA256 string `json:"sha256"` // hex SHA-256 of the definition file
+}
+
+// CatalogIndex is the catalog's signed index.json
+type CatalogIndex struct {
+	Blueprints []CatalogEntry `json:"blueprints"`
+}
+
+// Catalog is a remote catalog of blueprint definitions. Its index is signed
+// with an Ed25519 key in index.json.sig next to it, and pins the SHA-256 of
+// each definition file, so files can be served from anywhere.
+type Catalog struct {
+	URL       *url.URL
+	PublicKey ed25519.PublicKey
+	Client    *http.Client
+}
+
+// NewCatalog returns the catalog at an index URL, checking the URL and the
+// base64 public key
+func NewCatalog(indexURL, publicKey string) (*Catalog, error) {
+	if indexURL == "" || publicKey == "" {
+		return nil, ErrCatalogNotConfigured
+	}
+	u, err := url.Parse(indexURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q is not an http(s) URL", ErrCatalogNotConfigured, indexURL)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: the key must be a base64 Ed25519 public key", ErrCatalogNotConfigured)
+	}
+	return &Catalog{URL: u, PublicKey: key, Client: &http.Client{Timeout: catalogTimeout}}, nil
+}
+
+// Index fetches the index and verifies its signature
+func (c *Catalog) Index(ctx context.Context) (*CatalogIndex, error) {
+	data, err := c.fetch(ctx, c.URL)
+	if err != nil {
+		return nil, err
+	}
+	sigURL := *c.URL
+	sigURL.Path += ".sig"
+	sigData, err := c.fetch(ctx, &sigURL)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil || !ed25519.Verify(c.PublicKey, data, sig) {
+		return nil, fmt.Errorf("%w: the index signature does not match the catalog key", ErrBadSignature)
+	}
+
+	index := &CatalogIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("%w: bad index: %v", ErrCatalogUnavailable, err)
+	}
+	return index, nil
+}
+
+// Entry returns the index entry of a blueprint type
+func (idx *CatalogIndex) Entry(blueprintType string) (CatalogEntry, bool) {
+	for _, e := range idx.Blueprints {
+		if e.Type == blueprintType {
+			return e, true
+		}
+	}
+	return CatalogEntry{}, false
+}
+
+// Definition fetches an entry's definition file, checks it against the
+// index's hash and parses it. It returns the file with its URL.
+func (c *Catalog) Definition(ctx context.Context, entry CatalogEntry) (*Definition, []byte, string, error) {
+	ref, err := url.Parse(entry.File)
+	if err != nil || entry.File == "" {
+		return nil, nil, "", fmt.Errorf("%w: %s has a bad file %q", ErrCatalogUnavailable, entry.Type, entry.File)
+	}
+	u := c.URL.ResolveReference(ref)
+	data, err := c.fetch(ctx, u)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), entry.SHA256) {
+		return nil, nil, "", fmt.Errorf("%w: %s does not match the hash in the index", ErrBadSignature, u)
+	}
+	d, err := ParseDefinition(data)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if d.Type() != entry.Type {
+		return nil, nil, "", fmt.Errorf("%w: %s defines %q, not %q", ErrInvalidDefinition, u, d.Type(), entry.Type)
+	}
+	return d, data, u.String(), nil
+}
+
+// fetch GETs a catalog file
+func (c *Catalog) fetch(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCatalogUnavailable, err)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCatalogUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s answered %s", ErrCatalogUnavailable, u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCatalogFile+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCatalogUnavailable, err)
+	}
+	if len(data) > maxCatalogFile {
+		return nil, fmt.Errorf("%w: %s is larger than %d bytes", ErrCatalogUnavailable, u, maxCatalogFile)
+	}
+	return data, nil
+}
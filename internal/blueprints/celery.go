@@ -0,0 +1,176 @@
+package blueprints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// =============================================================================
+// CELERY BLUEPRINT CONFIGURATION
+// =============================================================================
+// To add a new Celery version:
+// 1. Add the version to `versions` slice below
+// 2. Update `defaultVersion` if needed
+// =============================================================================
+
+var celeryVersions = []string{"5.4", "5.3"}
+var celeryDefaultVersion = "5.4"
+var celeryDefaultConcurrency = 2
+var celeryDefaultQueue = "celery"
+
+// CeleryConfig holds Celery-specific configuration from the service Config JSON
+type CeleryConfig struct {
+	App         string   `json:"app"`         // the -A argument, e.g. "myproject"
+	Concurrency int      `json:"concurrency"` // worker processes
+	Queues      []string `json:"queues"`      // queues to consume, "celery" by default
+	LogLevel    string   `json:"log_level"`
+	VenvPath    string   `json:"venv_path"` // Path to virtual environment
+}
+
+// CeleryBlueprint provides configuration for Celery worker services
+type CeleryBlueprint struct{}
+
+func (c *CeleryBlueprint) Type() string {
+	return "celery"
+}
+
+func (c *CeleryBlueprint) Metadata() BlueprintMetadata {
+	return BlueprintMetadata{
+		Type:        "celery",
+		DisplayName: "Celery",
+		Description: "Python task queue worker with a Redis broker",
+		Icon:        "🌿",
+		Versions:    celeryVersions,
+		Default:     celeryDefaultVersion,
+	}
+}
+
+func (c *CeleryBlueprint) Defaults(version string) BlueprintDefaults {
+	return BlueprintDefaults{
+		Command:    "",
+		User:       "www-data",
+		WorkingDir: "/var/www/app",
+		Hint:       "Leave the command empty to generate it from the concurrency and queues, which can then be scaled. Set CELERY_BROKER_URL in the environment.",
+	}
+}
+
+func (c *CeleryBlueprint) parseConfig(service *storage.Service) CeleryConfig {
+	cfg := CeleryConfig{
+		App:         "app",
+		Concurrency: celeryDefaultConcurrency,
+		Queues:      []string{celeryDefaultQueue},
+		LogLevel:    "INFO",
+	}
+
+	if service.Config != "" {
+		if err := json.Unmarshal([]byte(service.Config), &cfg); err != nil {
+			slog.Warn("Failed to parse Celery config", "error", err)
+		}
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = celeryDefaultConcurrency
+	}
+	if len(cfg.Queues) == 0 {
+		cfg.Queues = []string{celeryDefaultQueue}
+	}
+
+	return cfg
+}
+
+func (c *CeleryBlueprint) GenerateCommand(service *storage.Service) string {
+	cfg := c.parseConfig(service)
+
+	celeryPath := "/usr/bin/env celery"
+	if cfg.VenvPath != "" {
+		celeryPath = filepath.Join(cfg.VenvPath, "bin", "celery")
+	}
+
+	return fmt.Sprintf("%s -A %s worker --concurrency %d --queues %s --loglevel %s",
+		celeryPath, cfg.App, cfg.Concurrency, strings.Join(cfg.Queues, ","), cfg.LogLevel)
+}
+
+func (c *CeleryBlueprint) GenerateEnvironment(service *storage.Service) string {
+	cfg := c.parseConfig(service)
+
+	env := "PYTHONDONTWRITEBYTECODE=1\n"
+	env += "PYTHONUNBUFFERED=1\n"
+
+	if cfg.VenvPath != "" {
+		env += fmt.Sprintf("VIRTUAL_ENV=%s\n", cfg.VenvPath)
+		env += fmt.Sprintf("PATH=%s/bin:$PATH\n", cfg.VenvPath)
+	}
+
+	return env
+}
+
+// GenerateSystemdOverrides stops the worker with TERM, Celery's warm
+// shutdown, and gives running tasks time to finish before they are killed
+func (c *CeleryBlueprint) GenerateSystemdOverrides(service *storage.Service) string {
+	return workerOverrides(service)
+}
+
+func (c *CeleryBlueprint) InstallDependencies(ctx context.Context, version string) error {
+	if version == "" {
+		version = celeryDefaultVersion
+	}
+
+	slog.Info("Installing Celery", "version", version)
+
+	// Install Python and pip if not present
+	if _, err := run(ctx, "sudo", "dnf", "install", "-y", "python3", "python3-pip"); err != nil {
+		// Fallback to apt
+		if _, err := run(ctx, "sudo", "apt-get", "install", "-y", "python3", "python3-pip"); err != nil {
+			return fmt.Errorf("failed to install python: %w", err)
+		}
+	}
+
+	// Install celery globally (user can override with venv)
+	if _, err := run(ctx, "pip3", "install", fmt.Sprintf("celery[redis]==%s.*", version)); err != nil {
+		slog.Warn("Failed to install specific celery version, trying latest", "error", err)
+		if _, err := run(ctx, "pip3", "install", "celery[redis]"); err != nil {
+			return fmt.Errorf("failed to install celery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *CeleryBlueprint) WorkerSettings(service *storage.Service) WorkerSettings {
+	cfg := c.parseConfig(service)
+	return WorkerSettings{Concurrency: cfg.Concurrency, Queues: cfg.Queues}
+}
+
+func (c *CeleryBlueprint) BrokerURL(service *storage.Service) string {
+	return envValue(service.Environment, "CELERY_BROKER_URL")
+}
+
+// QueueKey returns the queue's name; Celery's Redis transport keeps each queue in a list of that name
+func (c *CeleryBlueprint) QueueKey(queue string) string {
+	return queue
+}
+
+// workerOverrides is the [Service] section of worker blueprints: TERM lets
+// the worker finish its running jobs, for up to TimeoutStopSec
+func workerOverrides(service *storage.Service) string {
+	user := service.User
+	if user == "" {
+		user = "root"
+	}
+	workingDir := service.WorkingDir
+	if workingDir == "" {
+		workingDir = "/"
+	}
+	return fmt.Sprintf(`[Service]
+Type=simple
+User=%s
+WorkingDirectory=%s
+KillSignal=SIGTERM
+KillMode=mixed
+TimeoutStopSec=600`, user, workingDir)
+}
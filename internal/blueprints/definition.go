@@ -0,0 +1,196 @@
+package blueprints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"servio/internal/storage"
+)
+
+// ErrInvalidDefinition is wrapped for blueprint definitions that cannot be installed
+var ErrInvalidDefinition = errors.New("invalid blueprint definition")
+
+// definitionType matches the types catalog blueprints may register
+var definitionType = regexp.MustCompile(`^[a-z][a-z0-9-]{1,31}$`)
+
+// DefinitionFile describes a blueprint in JSON rather than Go code, as the
+// remote catalog serves them. Command, Environment, SystemdOverrides and the
+// arguments of Install are Go templates over .Service, .Config (the
+// service's config JSON laid over Config here) and .Version.
+type DefinitionFile struct {
+	Type             string            `json:"type"`
+	DisplayName      string            `json:"display_name"`
+	Description      string            `json:"description"`
+	Icon             string            `json:"icon"`
+	Versions         []string          `json:"versions"`
+	Default          string            `json:"default_version"`
+	Defaults         BlueprintDefaults `json:"defaults"`
+	Config           map[string]any    `json:"config"`            // defaults of .Config
+	Command          string            `json:"command"`           // ExecStart
+	Environment      string            `json:"environment"`       // KEY=VALUE lines
+	SystemdOverrides string            `json:"systemd_overrides"` // replaces the [Service] header block
+	Install          [][]string        `json:"install"`           // commands run in order to install dependencies
+}
+
+// Definition is the blueprint of a parsed definition file
+type Definition struct {
+	file                            DefinitionFile
+	command, environment, overrides *template.Template
+	install                         [][]*template.Template
+}
+
+// definitionData is what a definition's templates see
+type definitionData struct {
+	Service *storage.Service
+	Config  map[string]any
+	Version string
+}
+
+// ParseDefinition parses and checks a blueprint definition file
+func ParseDefinition(data []byte) (*Definition, error) {
+	d := &Definition{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d.file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	switch {
+	case !definitionType.MatchString(d.file.Type) || d.file.Type == "custom" || d.file.Type == "catalog":
+		return nil, fmt.Errorf("%w: type %q must be 2 to 32 lowercase letters, digits, and dashes", ErrInvalidDefinition, d.file.Type)
+	case d.file.DisplayName == "":
+		return nil, fmt.Errorf("%w: %s has no display_name", ErrInvalidDefinition, d.file.Type)
+	case len(d.file.Versions) == 0:
+		return nil, fmt.Errorf("%w: %s lists no versions", ErrInvalidDefinition, d.file.Type)
+	}
+	if d.file.Default == "" {
+		d.file.Default = d.file.Versions[0]
+	}
+	if !slices.Contains(d.file.Versions, d.file.Default) {
+		return nil, fmt.Errorf("%w: default_version %q of %s is not one of its versions", ErrInvalidDefinition, d.file.Default, d.file.Type)
+	}
+
+	var err error
+	parse := func(name, text string) *template.Template {
+		if err != nil {
+			return nil
+		}
+		var t *template.Template
+		t, err = template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			err = fmt.Errorf("%w: %s: %v", ErrInvalidDefinition, d.file.Type, err)
+		}
+		return t
+	}
+	d.command = parse("command", d.file.Command)
+	d.environment = parse("environment", d.file.Environment)
+	d.overrides = parse("systemd_overrides", d.file.SystemdOverrides)
+	for i, args := range d.file.Install {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: install command %d of %s is empty", ErrInvalidDefinition, i+1, d.file.Type)
+		}
+		argv := make([]*template.Template, len(args))
+		for j, arg := range args {
+			argv[j] = parse(fmt.Sprintf("install[%d][%d]", i, j), arg)
+		}
+		d.install = append(d.install, argv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// render executes one of the definition's templates, logging failures
+// rather than failing unit generation
+func (d *Definition) render(t *template.Template, data definitionData) string {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		slog.Warn("Failed to render blueprint template", "blueprint", d.file.Type, "template", t.Name(), "error", err)
+		return ""
+	}
+	return b.String()
+}
+
+func (d *Definition) data(service *storage.Service, version string) definitionData {
+	cfg := maps.Clone(d.file.Config)
+	if cfg == nil {
+		cfg = map[string]any{}
+	}
+	if service != nil && service.Config != "" {
+		if err := json.Unmarshal([]byte(service.Config), &cfg); err != nil {
+			slog.Warn("Failed to parse blueprint config", "blueprint", d.file.Type, "error", err)
+		}
+	}
+	if service == nil {
+		service = &storage.Service{}
+	}
+	if version == "" {
+		version = service.Version
+	}
+	if version == "" {
+		version = d.file.Default
+	}
+	return definitionData{Service: service, Config: cfg, Version: version}
+}
+
+func (d *Definition) Type() string {
+	return d.file.Type
+}
+
+func (d *Definition) Metadata() BlueprintMetadata {
+	return BlueprintMetadata{
+		Type:        d.file.Type,
+		DisplayName: d.file.DisplayName,
+		Description: d.file.Description,
+		Icon:        d.file.Icon,
+		Versions:    d.file.Versions,
+		Default:     d.file.Default,
+	}
+}
+
+func (d *Definition) Defaults(version string) BlueprintDefaults {
+	return d.file.Defaults
+}
+
+func (d *Definition) GenerateCommand(service *storage.Service) string {
+	return strings.TrimSpace(d.render(d.command, d.data(service, "")))
+}
+
+func (d *Definition) GenerateEnvironment(service *storage.Service) string {
+	return d.render(d.environment, d.data(service, ""))
+}
+
+func (d *Definition) GenerateSystemdOverrides(service *storage.Service) string {
+	return d.render(d.overrides, d.data(service, ""))
+}
+
+// InstallDependencies runs the definition's install commands in order,
+// stopping at the first that fails
+func (d *Definition) InstallDependencies(ctx context.Context, version string) error {
+	data := d.data(nil, version)
+	slog.InfoContext(ctx, "Installing blueprint dependencies", "blueprint", d.file.Type, "version", data.Version)
+	for _, argv := range d.install {
+		args := make([]string, len(argv))
+		for i, t := range argv {
+			var b strings.Builder
+			if err := t.Execute(&b, data); err != nil {
+				return fmt.Errorf("failed to render install command of %s: %w", d.file.Type, err)
+			}
+			args[i] = b.String()
+		}
+		output, err := run(ctx, args[0], args[1:]...)
+		if err != nil {
+			return fmt.Errorf("%s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os/exec"
+	"os"
 	"path/filepath"
 
 	"servio/internal/storage"
@@ -23,6 +23,8 @@ var djangoVersions = []string{"22.0", "21.2", "20.1"}
 var djangoDefaultVersion = "22.0"
 var djangoDefaultWorkers = 2
 var djangoDefaultBind = "0.0.0.0:8000"
+var djangoDefaultStaticURL = "/static/"
+var djangoDefaultFrontendURL = "/app/"
 
 // DjangoConfig holds Django-specific configuration from the service Config JSON
 type DjangoConfig struct {
@@ -31,6 +33,14 @@ type DjangoConfig struct {
 	BindAddress  string `json:"bind_address"` // e.g., "0.0.0.0:8000"
 	VenvPath     string `json:"venv_path"`    // Path to virtual environment
 	Requirements string `json:"requirements"` // Path to requirements.txt
+
+	// Static files, collected on deploy and served by nginx
+	StaticRoot     string `json:"static_root"`     // settings.STATIC_ROOT; <working_dir>/staticfiles by default
+	StaticURL      string `json:"static_url"`      // settings.STATIC_URL; /static/ by default
+	Collectstatic  *bool  `json:"collectstatic"`   // false skips collectstatic
+	FrontendDir    string `json:"frontend_dir"`    // npm project built on deploy, relative to the working directory
+	FrontendOutput string `json:"frontend_output"` // what npm run build writes, relative to frontend_dir; dist by default
+	FrontendURL    string `json:"frontend_url"`    // where nginx serves the frontend; /app/ by default
 }
 
 // DjangoBlueprint provides configuration for Django/Gunicorn services
@@ -121,6 +131,53 @@ KillMode=mixed
 TimeoutStopSec=5`
 }
 
+// AssetBuilds runs collectstatic into static_root and, with a frontend_dir,
+// npm run build there. nginx serves static_root at static_url and the
+// frontend's output at frontend_url as a single-page app.
+func (d *DjangoBlueprint) AssetBuilds(service *storage.Service) []AssetBuild {
+	cfg := d.parseConfig(service)
+	workDir := service.WorkingDir
+	if workDir == "" {
+		workDir = "/var/www/app"
+	}
+	var builds []AssetBuild
+
+	if cfg.Collectstatic == nil || *cfg.Collectstatic {
+		python := "python3"
+		if cfg.VenvPath != "" {
+			python = filepath.Join(cfg.VenvPath, "bin", "python")
+		}
+		builds = append(builds, AssetBuild{
+			Name:      "collectstatic",
+			Toolchain: ToolchainPython,
+			Dir:       workDir,
+			Marker:    "manage.py",
+			Commands:  [][]string{{python, "manage.py", "collectstatic", "--noinput"}},
+			URLPath:   urlPath(cfg.StaticURL, djangoDefaultStaticURL),
+			Output:    resolveDir(workDir, cfg.StaticRoot, "staticfiles"),
+		})
+	}
+
+	if cfg.FrontendDir != "" {
+		dir := resolveDir(workDir, cfg.FrontendDir, "")
+		install := []string{"npm", "install"}
+		if _, err := os.Stat(filepath.Join(dir, "package-lock.json")); err == nil {
+			install = []string{"npm", "ci"}
+		}
+		builds = append(builds, AssetBuild{
+			Name:      "frontend build",
+			Toolchain: ToolchainNode,
+			Dir:       dir,
+			Marker:    "package.json",
+			Commands:  [][]string{install, {"npm", "run", "build"}},
+			URLPath:   urlPath(cfg.FrontendURL, djangoDefaultFrontendURL),
+			Output:    resolveDir(dir, cfg.FrontendOutput, "dist"),
+			SPA:       true,
+		})
+	}
+	return builds
+}
+
 func (d *DjangoBlueprint) InstallDependencies(ctx context.Context, version string) error {
 	if version == "" {
 		version = djangoDefaultVersion
@@ -129,21 +186,17 @@ func (d *DjangoBlueprint) InstallDependencies(ctx context.Context, version strin
 	slog.Info("Installing Gunicorn", "version", version)
 
 	// Install Python and pip if not present
-	cmd := exec.CommandContext(ctx, "sudo", "dnf", "install", "-y", "python3", "python3-pip")
-	if err := cmd.Run(); err != nil {
+	if _, err := run(ctx, "sudo", "dnf", "install", "-y", "python3", "python3-pip"); err != nil {
 		// Fallback to apt
-		cmd = exec.CommandContext(ctx, "sudo", "apt-get", "install", "-y", "python3", "python3-pip")
-		if err := cmd.Run(); err != nil {
+		if _, err := run(ctx, "sudo", "apt-get", "install", "-y", "python3", "python3-pip"); err != nil {
 			return fmt.Errorf("failed to install python: %w", err)
 		}
 	}
 
 	// Install gunicorn globally (user can override with venv)
-	pipCmd := exec.CommandContext(ctx, "pip3", "install", fmt.Sprintf("gunicorn==%s", version))
-	if err := pipCmd.Run(); err != nil {
+	if _, err := run(ctx, "pip3", "install", fmt.Sprintf("gunicorn==%s", version)); err != nil {
 		slog.Warn("Failed to install specific gunicorn version, trying latest", "error", err)
-		pipCmd = exec.CommandContext(ctx, "pip3", "install", "gunicorn")
-		if err := pipCmd.Run(); err != nil {
+		if _, err := run(ctx, "pip3", "install", "gunicorn"); err != nil {
 			return fmt.Errorf("failed to install gunicorn: %w", err)
 		}
 	}
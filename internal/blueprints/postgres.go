@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"os/exec"
 
 	"servio/internal/storage"
 )
@@ -147,17 +146,14 @@ func (p *PostgresBlueprint) InstallDependencies(ctx context.Context, version str
 	var isDebian bool
 
 	// Try Amazon Linux 2023 / RHEL first
-	cmd := exec.CommandContext(ctx, "sudo", "dnf", "install", "-y", fmt.Sprintf("postgresql%s-server", version))
-	output, err := cmd.CombinedOutput()
+	output, err := run(ctx, "sudo", "dnf", "install", "-y", fmt.Sprintf("postgresql%s-server", version))
 	if err != nil {
 		// Fallback to apt for Debian/Ubuntu
 		slog.Info("dnf not available, trying apt", "error", string(output))
 		isDebian = true
-		cmd = exec.CommandContext(ctx, "sudo", "apt-get", "update")
-		cmd.Run() // Update package lists
+		run(ctx, "sudo", "apt-get", "update") // Update package lists
 
-		cmd = exec.CommandContext(ctx, "sudo", "apt-get", "install", "-y", fmt.Sprintf("postgresql-%s", version))
-		output, installErr = cmd.CombinedOutput()
+		output, installErr = run(ctx, "sudo", "apt-get", "install", "-y", fmt.Sprintf("postgresql-%s", version))
 		if installErr != nil {
 			return fmt.Errorf("failed to install postgresql: %s - %w", string(output), installErr)
 		}
@@ -169,18 +165,15 @@ func (p *PostgresBlueprint) InstallDependencies(ctx context.Context, version str
 	if !isDebian {
 		// Try multiple init methods for different RHEL/Amazon Linux versions
 		// Method 1: postgresql-setup (Amazon Linux 2023)
-		initCmd := exec.CommandContext(ctx, "sudo", "postgresql-setup", "--initdb")
-		if err := initCmd.Run(); err != nil {
+		if _, err := run(ctx, "sudo", "postgresql-setup", "--initdb"); err != nil {
 			slog.Debug("postgresql-setup failed, trying version-specific", "error", err)
 
 			// Method 2: Version-specific setup script
-			initCmd = exec.CommandContext(ctx, "sudo", fmt.Sprintf("/usr/pgsql-%s/bin/postgresql-%s-setup", version, version), "initdb")
-			if err := initCmd.Run(); err != nil {
+			if _, err := run(ctx, "sudo", fmt.Sprintf("/usr/pgsql-%s/bin/postgresql-%s-setup", version, version), "initdb"); err != nil {
 				slog.Debug("Version-specific setup failed, trying initdb directly", "error", err)
 
 				// Method 3: Direct initdb as postgres user
-				initCmd = exec.CommandContext(ctx, "sudo", "-u", "postgres", "initdb", "-D", "/var/lib/pgsql/data")
-				if err := initCmd.Run(); err != nil {
+				if _, err := run(ctx, "sudo", "-u", "postgres", "initdb", "-D", "/var/lib/pgsql/data"); err != nil {
 					slog.Warn("Database init failed (may already exist)", "error", err)
 				}
 			}
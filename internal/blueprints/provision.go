@@ -0,0 +1,41 @@
+package blueprints
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"servio/internal/audit"
+)
+
+// Output receives what InstallDependencies runs, line by line: "command"
+// with each command about to run, then "stdout" and "stderr" with what it prints
+type Output func(stream, line string)
+
+type outputKey struct{}
+
+// WithOutput returns a context whose provisioning commands report to out
+func WithOutput(ctx context.Context, out Output) context.Context {
+	return context.WithValue(ctx, outputKey{}, out)
+}
+
+// outputFrom returns the Output of ctx, or one that drops everything
+func outputFrom(ctx context.Context) Output {
+	if out, ok := ctx.Value(outputKey{}).(Output); ok {
+		return out
+	}
+	return func(string, string) {}
+}
+
+// run runs a provisioning command, audited under packages, streaming its
+// output to the context's Output. It returns the combined output.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	// A cancelled install stops here rather than trying its fallbacks
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	out := outputFrom(ctx)
+	out("command", strings.Join(cmd.Args, " "))
+	return audit.Stream(ctx, audit.CategoryPackages, "install", cmd, out)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"os/exec"
 
 	"servio/internal/storage"
 )
@@ -73,12 +72,10 @@ func (r *RedisBlueprint) InstallDependencies(ctx context.Context, version string
 	slog.Info("Installing Redis", "version", version)
 
 	// Try Amazon Linux 2023 / RHEL first
-	cmd := exec.CommandContext(ctx, "sudo", "dnf", "install", "-y", "redis")
-	if err := cmd.Run(); err != nil {
+	if _, err := run(ctx, "sudo", "dnf", "install", "-y", "redis"); err != nil {
 		// Fallback to apt for Debian/Ubuntu
 		slog.Debug("dnf failed, trying apt", "error", err)
-		cmd = exec.CommandContext(ctx, "sudo", "apt-get", "install", "-y", "redis-server")
-		if err := cmd.Run(); err != nil {
+		if _, err := run(ctx, "sudo", "apt-get", "install", "-y", "redis-server"); err != nil {
 			return fmt.Errorf("failed to install redis: %w", err)
 		}
 	}
@@ -0,0 +1,134 @@
+package blueprints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// =============================================================================
+// SIDEKIQ BLUEPRINT CONFIGURATION
+// =============================================================================
+// To add a new Sidekiq version:
+// 1. Add the version to `versions` slice below
+// 2. Update `defaultVersion` if needed
+// =============================================================================
+
+var sidekiqVersions = []string{"7", "6"}
+var sidekiqDefaultVersion = "7"
+var sidekiqDefaultConcurrency = 5
+var sidekiqDefaultQueue = "default"
+
+// SidekiqConfig holds Sidekiq-specific configuration from the service Config JSON
+type SidekiqConfig struct {
+	Concurrency int      `json:"concurrency"` // worker threads
+	Queues      []string `json:"queues"`      // queues to consume, "default" by default
+	Environment string   `json:"environment"` // RAILS_ENV, "production" by default
+}
+
+// SidekiqBlueprint provides configuration for Sidekiq worker services of Ruby apps
+type SidekiqBlueprint struct{}
+
+func (s *SidekiqBlueprint) Type() string {
+	return "sidekiq"
+}
+
+func (s *SidekiqBlueprint) Metadata() BlueprintMetadata {
+	return BlueprintMetadata{
+		Type:        "sidekiq",
+		DisplayName: "Sidekiq",
+		Description: "Ruby background job worker with a Redis queue",
+		Icon:        "💎",
+		Versions:    sidekiqVersions,
+		Default:     sidekiqDefaultVersion,
+	}
+}
+
+func (s *SidekiqBlueprint) Defaults(version string) BlueprintDefaults {
+	return BlueprintDefaults{
+		Command:    "",
+		User:       "www-data",
+		WorkingDir: "/var/www/app",
+		Hint:       "Leave the command empty to generate it from the concurrency and queues, which can then be scaled. Set REDIS_URL in the environment.",
+	}
+}
+
+func (s *SidekiqBlueprint) parseConfig(service *storage.Service) SidekiqConfig {
+	cfg := SidekiqConfig{
+		Concurrency: sidekiqDefaultConcurrency,
+		Queues:      []string{sidekiqDefaultQueue},
+		Environment: "production",
+	}
+
+	if service.Config != "" {
+		if err := json.Unmarshal([]byte(service.Config), &cfg); err != nil {
+			slog.Warn("Failed to parse Sidekiq config", "error", err)
+		}
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = sidekiqDefaultConcurrency
+	}
+	if len(cfg.Queues) == 0 {
+		cfg.Queues = []string{sidekiqDefaultQueue}
+	}
+
+	return cfg
+}
+
+func (s *SidekiqBlueprint) GenerateCommand(service *storage.Service) string {
+	cfg := s.parseConfig(service)
+
+	var queues strings.Builder
+	for _, q := range cfg.Queues {
+		queues.WriteString(" --queue " + q)
+	}
+	return fmt.Sprintf("/usr/bin/env bundle exec sidekiq --environment %s --concurrency %d%s",
+		cfg.Environment, cfg.Concurrency, queues.String())
+}
+
+func (s *SidekiqBlueprint) GenerateEnvironment(service *storage.Service) string {
+	cfg := s.parseConfig(service)
+	return fmt.Sprintf("RAILS_ENV=%s\nMALLOC_ARENA_MAX=2\n", cfg.Environment)
+}
+
+// GenerateSystemdOverrides stops the worker with TERM, on which Sidekiq
+// stops fetching jobs and waits for the running ones
+func (s *SidekiqBlueprint) GenerateSystemdOverrides(service *storage.Service) string {
+	return workerOverrides(service)
+}
+
+func (s *SidekiqBlueprint) InstallDependencies(ctx context.Context, version string) error {
+	if version == "" {
+		version = sidekiqDefaultVersion
+	}
+
+	slog.Info("Installing Ruby and Bundler for Sidekiq", "version", version)
+
+	// Sidekiq itself comes from the app's Gemfile
+	if _, err := run(ctx, "sudo", "dnf", "install", "-y", "ruby", "rubygem-bundler"); err != nil {
+		// Fallback to apt
+		if _, err := run(ctx, "sudo", "apt-get", "install", "-y", "ruby", "ruby-bundler"); err != nil {
+			return fmt.Errorf("failed to install ruby: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SidekiqBlueprint) WorkerSettings(service *storage.Service) WorkerSettings {
+	cfg := s.parseConfig(service)
+	return WorkerSettings{Concurrency: cfg.Concurrency, Queues: cfg.Queues}
+}
+
+func (s *SidekiqBlueprint) BrokerURL(service *storage.Service) string {
+	return envValue(service.Environment, "REDIS_URL")
+}
+
+// QueueKey returns the list Sidekiq keeps a queue's jobs in
+func (s *SidekiqBlueprint) QueueKey(queue string) string {
+	return "queue:" + queue
+}
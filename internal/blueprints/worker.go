@@ -0,0 +1,91 @@
+package blueprints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// MaxConcurrency caps the concurrency a worker can be scaled to
+const MaxConcurrency = 256
+
+// queueName matches the queue names workers accept on their command line
+var queueName = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+var (
+	// ErrNotWorker is wrapped when a service's blueprint is not a worker
+	ErrNotWorker = errors.New("not a worker service")
+	// ErrInvalidWorkerSettings is wrapped for concurrency and queues a worker cannot take
+	ErrInvalidWorkerSettings = errors.New("invalid worker settings")
+)
+
+// WorkerSettings is how many jobs a worker runs at once and which queues it takes them from
+type WorkerSettings struct {
+	Concurrency int      `json:"concurrency"`
+	Queues      []string `json:"queues"`
+}
+
+// Validate checks the concurrency is within bounds and the queues are plain names
+func (w WorkerSettings) Validate() error {
+	if w.Concurrency < 1 || w.Concurrency > MaxConcurrency {
+		return fmt.Errorf("%w: concurrency must be between 1 and %d", ErrInvalidWorkerSettings, MaxConcurrency)
+	}
+	if len(w.Queues) == 0 {
+		return fmt.Errorf("%w: at least one queue is needed", ErrInvalidWorkerSettings)
+	}
+	for _, q := range w.Queues {
+		if !queueName.MatchString(q) {
+			return fmt.Errorf("%w: queue %q must be letters, digits, and _.:-", ErrInvalidWorkerSettings, q)
+		}
+	}
+	return nil
+}
+
+// Worker is implemented by blueprints of background workers that take jobs
+// from named queues in a Redis broker. Their concurrency and queues are kept
+// in the service's config, and their command is generated from it.
+type Worker interface {
+	Blueprint
+
+	// WorkerSettings returns the service's concurrency and queues
+	WorkerSettings(service *storage.Service) WorkerSettings
+
+	// BrokerURL returns the broker's redis:// URL from the service's environment, or "" when it has none
+	BrokerURL(service *storage.Service) string
+
+	// QueueKey returns the Redis list that holds a queue's waiting jobs
+	QueueKey(queue string) string
+}
+
+// ApplyWorkerSettings returns the service's config JSON with the settings
+// laid over it, keeping its other keys
+func ApplyWorkerSettings(service *storage.Service, settings WorkerSettings) (string, error) {
+	cfg := map[string]any{}
+	if service.Config != "" {
+		if err := json.Unmarshal([]byte(service.Config), &cfg); err != nil {
+			return "", fmt.Errorf("%w: the service's config is not a JSON object: %v", ErrInvalidWorkerSettings, err)
+		}
+	}
+	cfg["concurrency"] = settings.Concurrency
+	cfg["queues"] = settings.Queues
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// envValue returns the last value of key in KEY=VALUE lines
+func envValue(environment, key string) string {
+	value := ""
+	for _, line := range strings.Split(environment, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok && k == key {
+			value = strings.Trim(v, `"'`)
+		}
+	}
+	return value
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"servio/internal/agent"
+	"servio/internal/nginx"
+	"servio/internal/systemd"
+)
+
+// agentShutdownTimeout bounds how long "servio agent" waits for requests on exit
+const agentShutdownTimeout = 10 * time.Second
+
+// agentFlags are the flags of "servio agent"
+type agentFlags struct {
+	join, token, addr, advertise string
+	tlsCert, tlsKey              string
+	insecure                     bool
+	secretFile, name, distro     string
+	ipv6                         bool
+	upstreamHost                 string
+	mock                         bool
+}
+
+// newAgentCommand builds "servio agent", which serves the agent API on this
+// host and joins the central server, which then manages the units and nginx
+// sites of the projects assigned to this host. The secret sent with the
+// registration is generated once and kept in --secret-file, as the central
+// server only lets the agent that registered a host name join under it again.
+func newAgentCommand() *cobra.Command {
+	var f agentFlags
+	cmd := &cobra.Command{
+		Use:   "agent --join URL --token TOKEN (--tls-cert FILE --tls-key FILE | --insecure)",
+		Short: "Let a central Servio manage this host's services (as root)",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runAgent(cmd, &f)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&f.join, "join", os.Getenv("SERVIO_AGENT_JOIN"), "URL of the central server (env SERVIO_AGENT_JOIN)")
+	flags.StringVar(&f.token, "token", "", "the central server's SERVIO_AGENT_TOKEN (default env SERVIO_AGENT_TOKEN)")
+	flags.StringVar(&f.addr, "addr", ":8421", "address to serve the agent API on")
+	flags.StringVar(&f.advertise, "url", "", "URL the central server reaches this agent on (default https://HOSTNAME:PORT)")
+	flags.StringVar(&f.tlsCert, "tls-cert", os.Getenv("SERVIO_AGENT_TLS_CERT"), "TLS certificate file; serves HTTPS when set with --tls-key (env SERVIO_AGENT_TLS_CERT)")
+	flags.StringVar(&f.tlsKey, "tls-key", os.Getenv("SERVIO_AGENT_TLS_KEY"), "TLS private key file (env SERVIO_AGENT_TLS_KEY)")
+	flags.BoolVar(&f.insecure, "insecure", false, "allow an http --url, sending the token and units' secrets in cleartext (trusted networks only)")
+	flags.StringVar(&f.secretFile, "secret-file", "/var/lib/servio/agent.secret", "file keeping the secret this agent registered with")
+	flags.StringVar(&f.name, "name", "", "host name shown by the central server (default the hostname)")
+	flags.StringVar(&f.distro, "distro", "", "nginx layout: ubuntu or debian for sites-available, anything else for conf.d (default detected)")
+	flags.BoolVar(&f.ipv6, "nginx-ipv6", false, "generated nginx sites also listen on IPv6 ([::]:80 and [::]:443)")
+	flags.StringVar(&f.upstreamHost, "nginx-upstream-host", nginx.DefaultUpstreamHost, "address nginx sites proxy to services on, e.g. ::1 for IPv6-only services")
+	flags.BoolVar(&f.mock, "mock", false, "simulate systemd in memory (for development)")
+	return cmd
+}
+
+func runAgent(cmd *cobra.Command, f *agentFlags) error {
+	ctx := cmd.Context()
+	if f.token == "" {
+		f.token = os.Getenv("SERVIO_AGENT_TOKEN")
+	}
+	if f.join == "" || f.token == "" {
+		return usageError(cmd, "--join and --token are required")
+	}
+	if (f.tlsCert == "") != (f.tlsKey == "") {
+		return usageError(cmd, "--tls-cert and --tls-key must be set together")
+	}
+
+	if f.name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to read the hostname, pass --name: %w", err)
+		}
+		f.name = hostname
+	}
+	if f.advertise == "" {
+		_, port, err := net.SplitHostPort(f.addr)
+		if err != nil {
+			return usageError(cmd, "invalid --addr %q: %v", f.addr, err)
+		}
+		scheme := "https://"
+		if f.tlsCert == "" {
+			scheme = "http://"
+		}
+		f.advertise = scheme + net.JoinHostPort(f.name, port)
+	}
+	// The central server sends units with their secrets resolved, so they
+	// only travel in cleartext when asked to
+	u, err := url.Parse(f.advertise)
+	if err != nil || u.Scheme != "https" && !f.insecure {
+		return usageError(cmd, "--url must be https: serve it with --tls-cert and --tls-key or a TLS proxy, or pass --insecure on a trusted network")
+	}
+	if u.Scheme != "https" {
+		slog.Warn("Insecure mode: the agent token and units' secrets cross the network in cleartext", "url", f.advertise)
+	}
+
+	var tlsConfig *tls.Config
+	if f.tlsCert != "" {
+		if tlsConfig, err = agent.ServerTLS(f.tlsCert, f.tlsKey); err != nil {
+			return err
+		}
+	}
+	secret, err := agentSecret(f.secretFile)
+	if err != nil {
+		return err
+	}
+
+	var units agent.Units = systemd.NewManager()
+	if f.mock {
+		units = systemd.NewMockManager(systemd.NewManager())
+		slog.Warn("Mock mode: systemd is simulated in memory and units are lost on restart")
+	}
+	nginxManager := nginx.NewManager()
+	if f.distro == "" {
+		if _, err := os.Stat("/etc/nginx/sites-available"); err == nil {
+			f.distro = "debian"
+		}
+	}
+	if f.distro != "" {
+		nginxManager.Configure(f.distro)
+	}
+	if err := nginxManager.SetNetwork(f.ipv6, f.upstreamHost); err != nil {
+		return usageError(cmd, "%v", err)
+	}
+
+	server := &http.Server{
+		Addr:              f.addr,
+		Handler:           agent.NewHandler(units, nginxManager, secret),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		// No WriteTimeout: log follows stream until the central server hangs up
+	}
+	listener, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", f.addr, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	slog.Info("Agent listening", "addr", listener.Addr().String(), "url", f.advertise, "tls", tlsConfig != nil)
+
+	// The central server pings the agent before accepting it, so join once serving
+	host, err := agent.Join(ctx, f.join, f.token, agent.Registration{Name: f.name, URL: f.advertise, Token: secret})
+	if err != nil {
+		server.Close()
+		return err
+	}
+	slog.Info("Joined the central server", "server", f.join, "host", host.Name, "host_id", host.ID)
+
+	select {
+	case err := <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Agent shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), agentShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// agentSecret reads the agent's secret from path, generating and saving one
+// readable by root only on the first start
+func agentSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if secret := strings.TrimSpace(string(data)); secret != "" {
+			return secret, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read the agent secret: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate the agent secret: %w", err)
+	}
+	secret := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to save the agent secret: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to save the agent secret: %w", err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,465 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// backupVersion is bumped when the archive layout changes incompatibly
+const backupVersion = 1
+
+// manifestName is the first entry of a backup; files follow under filesPrefix
+// at their absolute paths, e.g. files/etc/systemd/system/servio-api.service
+const (
+	manifestName = "manifest.json"
+	filesPrefix  = "files/"
+)
+
+// Kinds of files in a backup
+const (
+	kindDatabase  = "database"
+	kindSecretKey = "secret-key"
+	kindEnv       = "env"
+	kindUnit      = "unit"
+	kindNginx     = "nginx"
+)
+
+// backupManifest describes a backup archive
+type backupManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Hostname  string       `json:"hostname"`
+	Files     []backupFile `json:"files"`
+}
+
+// backupFile is one archived file and where it came from
+type backupFile struct {
+	Path string `json:"path"` // original absolute path
+	Kind string `json:"kind"`
+}
+
+// nginxSiteGlobs match the site configs Servio writes on either distro family
+var nginxSiteGlobs = []string{
+	"/etc/nginx/conf.d/servio-*.conf",
+	"/etc/nginx/sites-available/servio-*.conf",
+	"/etc/nginx/sites-enabled/servio-*.conf",
+}
+
+// dataPath picks a data file path: the server's environment variable, then the
+// "servio install" layout if present, then the server's default
+func dataPath(env, installed, fallback string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	if _, err := os.Stat(installed); err == nil {
+		return installed
+	}
+	return fallback
+}
+
+// newBackupCommand builds "servio backup"
+func newBackupCommand() *cobra.Command {
+	var out, dbPath, keyFile string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Archive the database, secrets key, env files, units, and nginx sites (as root)",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runBackup(cmd.Context(), out, dbPath, keyFile)
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "servio-backup-"+time.Now().Format("20060102-150405")+".tar.gz", "archive to write")
+	cmd.Flags().StringVar(&dbPath, "db", dataPath("SERVIO_DB", "/var/lib/servio/data.db", "servio.db"), "SQLite database path")
+	cmd.Flags().StringVar(&keyFile, "secret-key-file", dataPath("SERVIO_SECRET_KEY_FILE", "/var/lib/servio/servio.key", "servio.key"), "secrets master key")
+	return cmd
+}
+
+func runBackup(ctx context.Context, out, dbPath, keyFile string) error {
+	var files []backupFile
+	add := func(path, kind string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, backupFile{Path: abs, Kind: kind})
+		return nil
+	}
+	if err := add(dbPath, kindDatabase); err != nil {
+		return err
+	}
+	if _, err := os.Stat(keyFile); err == nil {
+		if err := add(keyFile, kindSecretKey); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "warning: no secret key at %s; encrypted secrets will not be restorable\n", keyFile)
+	}
+	for _, env := range []string{envPath, ".env"} {
+		if _, err := os.Stat(env); err == nil {
+			if err := add(env, kindEnv); err != nil {
+				return err
+			}
+		}
+	}
+	units, _ := filepath.Glob(filepath.Join(systemd.ServiceDir, "servio-*.service"))
+	timers, _ := filepath.Glob(filepath.Join(systemd.ServiceDir, "servio-cron-*.timer")) // cron job schedules
+	units = append(units, timers...)
+	for _, unit := range units {
+		files = append(files, backupFile{Path: unit, Kind: kindUnit})
+	}
+	for _, glob := range nginxSiteGlobs {
+		sites, _ := filepath.Glob(glob)
+		for _, site := range sites {
+			files = append(files, backupFile{Path: site, Kind: kindNginx})
+		}
+	}
+
+	// Snapshot the database so a running server can keep writing to it
+	tmpDir, err := os.MkdirTemp("", "servio-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	snapshot := filepath.Join(tmpDir, "data.db")
+	if err := storage.Snapshot(ctx, dbPath, snapshot); err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	manifest := backupManifest{Version: backupVersion, CreatedAt: time.Now().UTC(), Hostname: hostname, Files: files}
+	if err := writeBackup(out, manifest, map[string]string{files[0].Path: snapshot}); err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, f := range files {
+		counts[f.Kind]++
+	}
+	fmt.Printf("Wrote %s: database, %d units, %d nginx files, %d env files", out, counts[kindUnit], counts[kindNginx], counts[kindEnv])
+	if counts[kindSecretKey] > 0 {
+		fmt.Print(", secret key")
+	}
+	fmt.Println()
+	fmt.Println("The archive contains credentials and the secrets key; store it securely.")
+	return nil
+}
+
+// writeBackup writes the manifest and files to a gzipped tar at out. Sources
+// maps an original path to the file to read instead (the database snapshot).
+// The archive is written beside out and renamed, so a failure leaves no partial file.
+func writeBackup(out string, manifest backupManifest, sources map[string]string) (err error) {
+	tmp := out + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0600, Size: int64(len(data)), ModTime: manifest.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		source := file.Path
+		if s, ok := sources[file.Path]; ok {
+			source = s
+		}
+		if err := addToArchive(tw, source, filesPrefix+strings.TrimPrefix(file.Path, "/"), file.Path); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, out)
+}
+
+// addToArchive writes one file (or symlink) read from source under name,
+// keeping the owner and mode of the original path
+func addToArchive(tw *tar.Writer, source, name, original string) error {
+	info, err := os.Lstat(original)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", original, err)
+	}
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(original); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if uid := header.Uid; uid >= 0 {
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			header.Uname = u.Username
+		}
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(header.Gid)); err == nil {
+		header.Gname = g.Name
+	}
+
+	if link != "" {
+		return tw.WriteHeader(header)
+	}
+
+	src, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	defer src.Close()
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header.Size = srcInfo.Size()
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, src); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", original, err)
+	}
+	return nil
+}
+
+// restoreFlags are the flags of "servio restore"
+type restoreFlags struct {
+	dbPath, keyFile string
+	force, dryRun   bool
+}
+
+// newRestoreCommand builds "servio restore ARCHIVE"
+func newRestoreCommand() *cobra.Command {
+	var flags restoreFlags
+	cmd := &cobra.Command{
+		Use:   "restore ARCHIVE",
+		Short: "Rebuild Servio's state from a backup (as root)",
+		Args:  exactArgs(1, "restore takes exactly one archive"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRestore(cmd.Context(), args[0], flags)
+		},
+	}
+	cmd.Flags().StringVar(&flags.dbPath, "db", "", "restore the database here instead of its original path")
+	cmd.Flags().StringVar(&flags.keyFile, "secret-key-file", "", "restore the secrets key here instead of its original path")
+	cmd.Flags().BoolVar(&flags.force, "force", false, "overwrite an existing database and restore while servio is running")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "list what would be restored without changing anything")
+	return cmd
+}
+
+func runRestore(ctx context.Context, archive string, flags restoreFlags) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("not a servio backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return err
+	}
+	targets := make(map[string]string) // original path -> restore path
+	kinds := make(map[string]string)
+	for _, file := range manifest.Files {
+		targets[file.Path], kinds[file.Path] = file.Path, file.Kind
+		if file.Kind == kindDatabase && flags.dbPath != "" {
+			targets[file.Path], _ = filepath.Abs(flags.dbPath)
+		}
+		if file.Kind == kindSecretKey && flags.keyFile != "" {
+			targets[file.Path], _ = filepath.Abs(flags.keyFile)
+		}
+	}
+
+	if !flags.dryRun {
+		if os.Geteuid() != 0 {
+			return errors.New("restore must be run as root (try sudo, or --dry-run to preview)")
+		}
+		if !flags.force {
+			if exec.CommandContext(ctx, "systemctl", "is-active", "--quiet", "servio").Run() == nil {
+				return errors.New("servio is running; stop it first (systemctl stop servio) or use --force")
+			}
+			for orig, kind := range kinds {
+				if _, err := os.Stat(targets[orig]); kind == kindDatabase && err == nil {
+					return fmt.Errorf("%s already exists; use --force to replace it", targets[orig])
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Restoring backup of %s from %s\n", manifest.Hostname, manifest.CreatedAt.Local().Format(time.DateTime))
+	var units []string
+	nginxChanged := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		orig := "/" + strings.TrimPrefix(header.Name, filesPrefix)
+		target, ok := targets[orig]
+		if !strings.HasPrefix(header.Name, filesPrefix) || !ok {
+			return fmt.Errorf("unexpected archive entry %s", header.Name)
+		}
+
+		fmt.Printf("  %-10s %s\n", kinds[orig], target)
+		if flags.dryRun {
+			continue
+		}
+		if err := restoreFile(tr, header, target); err != nil {
+			return err
+		}
+		switch kinds[orig] {
+		case kindDatabase:
+			// The snapshot is complete; a stale write-ahead log would be replayed into it
+			os.Remove(target + "-wal")
+			os.Remove(target + "-shm")
+		case kindUnit:
+			units = append(units, filepath.Base(target))
+		case kindNginx:
+			nginxChanged = true
+		}
+	}
+	if flags.dryRun {
+		return nil
+	}
+
+	if len(units) > 0 {
+		if err := runQuiet(ctx, "systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+		if err := runQuiet(ctx, "systemctl", append([]string{"enable"}, units...)...); err != nil {
+			return err
+		}
+	}
+	if nginxChanged {
+		if err := runQuiet(ctx, "nginx", "-t"); err != nil {
+			return err
+		}
+		if err := runQuiet(ctx, "systemctl", "reload", "nginx"); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}
+
+	fmt.Printf("Restored %d units (enabled, not started). Start Servio with: systemctl start servio\n", len(units))
+	return nil
+}
+
+// readManifest reads and checks the first archive entry
+func readManifest(tr *tar.Reader) (*backupManifest, error) {
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, errors.New("not a servio backup: missing manifest")
+	}
+	var manifest backupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("not a servio backup: %w", err)
+	}
+	if manifest.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	return &manifest, nil
+}
+
+// restoreFile writes one archive entry to target with its original mode and
+// owner (when that user still exists), replacing any existing file atomically
+func restoreFile(tr *tar.Reader, header *tar.Header, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		os.Remove(target)
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		return nil
+	}
+
+	tmp := target + ".restore"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+	if _, err := io.Copy(f, tr); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	uid, gid := 0, 0
+	if u, err := user.Lookup(header.Uname); err == nil {
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if g, err := user.LookupGroup(header.Gname); err == nil {
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if err := os.Chown(tmp, uid, gid); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+	return nil
+}
+
+// runQuiet runs a command, returning its output as part of any error
+func runQuiet(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
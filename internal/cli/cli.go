@@ -0,0 +1,133 @@
+// Package cli implements the servio command-line client, which manages a
+// running Servio server over its HTTP API. Each subcommand is a cobra command
+// built by a new*Command function next to the code it runs.
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// errUsage reports bad arguments; the command's usage has already been printed
+var errUsage = errors.New("usage")
+
+// newRootCommand builds the servio command tree
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "servio",
+		Short: "Manage a Servio server from the command line",
+		Long: `Manage a Servio server from the command line. Run "servio [server flags]"
+to run the server itself.
+
+Services are named NAME or PROJECT/NAME. The endpoint and credentials saved
+by login can be overridden with SERVIO_ENDPOINT, SERVIO_USERNAME, and
+SERVIO_PASSWORD.`,
+		// Run prints errors itself and only shows the usage for bad arguments
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError(cmd, "%v", err)
+	})
+	root.AddCommand(
+		newLoginCommand(),
+		newProjectsCommand(),
+		newServiceCommand(),
+		newLogsCommand(),
+		newExportCommand(),
+		newPlanCommand(),
+		newDoctorCommand(),
+		newInstallCommand(),
+		newBackupCommand(),
+		newRestoreCommand(),
+		newReconcileCommand(),
+		newAgentCommand(),
+	)
+	root.InitDefaultHelpCmd()
+	root.InitDefaultCompletionCmd()
+	return root
+}
+
+// IsCommand reports whether arg names a CLI subcommand rather than a server flag
+func IsCommand(arg string) bool {
+	if arg == cobra.ShellCompRequestCmd || arg == cobra.ShellCompNoDescRequestCmd {
+		return true
+	}
+	for _, cmd := range newRootCommand().Commands() {
+		if cmd.Name() == arg || cmd.HasAlias(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run executes a CLI subcommand and returns the process exit code
+func Run(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	root := newRootCommand()
+	root.SetArgs(args)
+	err := root.ExecuteContext(ctx)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	case errors.Is(err, context.Canceled):
+		return 130
+	default:
+		fmt.Fprintln(os.Stderr, "servio:", err)
+		return 1
+	}
+}
+
+// usageError prints a problem with a command's arguments followed by its
+// usage, and returns errUsage
+func usageError(cmd *cobra.Command, format string, args ...interface{}) error {
+	fmt.Fprintf(cmd.ErrOrStderr(), "servio: "+format+"\n", args...)
+	fmt.Fprint(cmd.ErrOrStderr(), cmd.UsageString())
+	return errUsage
+}
+
+// noArgs rejects positional arguments
+func noArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return usageError(cmd, "unexpected argument %q", args[0])
+	}
+	return nil
+}
+
+// exactArgs requires n positional arguments, explaining what they are with msg
+func exactArgs(n int, msg string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) != n {
+			return usageError(cmd, "%s", msg)
+		}
+		return nil
+	}
+}
+
+// runGroup runs a command that only groups subcommands, such as "svc", when
+// no subcommand matched
+func runGroup(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return usageError(cmd, "missing action")
+	}
+	return usageError(cmd, "unknown action %q", args[0])
+}
+
+// connect loads the stored config and builds an API client from it
+func connect() (*client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(cfg)
+}
@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix marks an endpoint that is a unix socket path, as in the server's -listen
+const unixPrefix = "unix:"
+
+// requestTimeout bounds non-streaming API calls; slow service actions get up to the server's own limit
+const requestTimeout = 5 * time.Minute
+
+// client calls the Servio HTTP API
+type client struct {
+	cfg     *Config
+	baseURL string
+	http    *http.Client
+}
+
+// apiError is the error body every API endpoint returns
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Message + " (" + e.Code + ")"
+}
+
+// newClient builds a client for the configured endpoint, dialing a unix
+// socket or presenting a client certificate when configured
+func newClient(cfg *Config) (*client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	baseURL := strings.TrimRight(cfg.Endpoint, "/")
+
+	if socket, ok := strings.CutPrefix(cfg.Endpoint, unixPrefix); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://servio"
+	}
+
+	if cfg.CACert != "" || cfg.ClientCert != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CACert != "" {
+			pem, err := os.ReadFile(cfg.CACert)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.New("CA bundle contains no PEM certificates")
+			}
+		}
+		if cfg.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &client{cfg: cfg, baseURL: baseURL, http: &http.Client{Transport: transport}}, nil
+}
+
+// newRequest builds an authenticated request for an API path
+func (c *client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Username != "" {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+	return req, nil
+}
+
+// do calls the API and decodes a JSON response into out (if non-nil), or
+// copies the body as is when out is an io.Writer. Error responses are
+// returned as *apiError.
+func (c *client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", c.cfg.Endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// streamRetry is how long stream waits before reconnecting a dropped stream
+const streamRetry = 3 * time.Second
+
+// stream calls a server-sent events endpoint and passes each event to fn until
+// ctx is cancelled, fn fails, or the server rejects the request. Once
+// connected, a dropped stream is reconnected with the ID of the last event
+// received, so the server resumes after it.
+func (c *client) stream(ctx context.Context, path string, fn func(event, data string) error) error {
+	var lastID string
+	var fnErr error
+	connected := false
+	for {
+		err := c.streamOnce(ctx, path, &lastID, &connected, func(event, data string) error {
+			fnErr = fn(event, data)
+			return fnErr
+		})
+		var apiErr *apiError
+		if !connected || fnErr != nil || ctx.Err() != nil || errors.As(err, &apiErr) {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "servio: stream interrupted, reconnecting...")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(streamRetry):
+		}
+	}
+}
+
+// streamOnce makes one connection for stream, sending lastID as Last-Event-ID
+// and keeping it up to date. connected is set once the server accepts.
+func (c *client) streamOnce(ctx context.Context, path string, lastID *string, connected *bool, fn func(event, data string) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	if *lastID != "" {
+		req.Header.Set("Last-Event-ID", *lastID)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", c.cfg.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	*connected = true
+
+	return readEvents(resp.Body, lastID, fn)
+}
+
+// checkResponse turns a non-2xx response into an *apiError
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	apiErr := &apiError{}
+	if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = resp.Status
+	}
+	return apiErr
+}
@@ -0,0 +1,680 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"servio/internal/ansi"
+	"servio/internal/storage"
+)
+
+// newLoginCommand builds "servio login", which checks the given credentials
+// against the server and saves them. Flags left out keep the stored values.
+func newLoginCommand() *cobra.Command {
+	var flags Config
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Save the server endpoint and credentials",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runLogin(cmd, &flags)
+		},
+	}
+	cmd.Flags().StringVar(&flags.Endpoint, "endpoint", defaultEndpoint, "server URL (http://host:port, https://host:port, or unix:/path.sock)")
+	cmd.Flags().StringVar(&flags.Username, "user", "", "basic auth username")
+	cmd.Flags().StringVar(&flags.Password, "password", "", "basic auth password (prompted for when omitted)")
+	cmd.Flags().StringVar(&flags.CACert, "ca", "", "CA bundle to trust for an HTTPS endpoint")
+	cmd.Flags().StringVar(&flags.ClientCert, "cert", "", "client certificate for mutual TLS")
+	cmd.Flags().StringVar(&flags.ClientKey, "key", "", "client certificate key")
+	return cmd
+}
+
+func runLogin(cmd *cobra.Command, flags *Config) error {
+	ctx := cmd.Context()
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	given := cmd.Flags().Changed
+	if given("endpoint") {
+		cfg.Endpoint = flags.Endpoint
+	}
+	if given("user") {
+		cfg.Username = flags.Username
+	}
+	if given("ca") {
+		cfg.CACert = flags.CACert
+	}
+	if given("cert") {
+		cfg.ClientCert = flags.ClientCert
+	}
+	if given("key") {
+		cfg.ClientKey = flags.ClientKey
+	}
+	cfg.Password = flags.Password
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return usageError(cmd, "--cert and --key must be given together")
+	}
+
+	if cfg.Username != "" && cfg.Password == "" {
+		if cfg.Password, err = promptPassword(); err != nil {
+			return err
+		}
+	}
+
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/projects?limit=1", nil, nil); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	path, err := saveConfig(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s; saved %s\n", cfg.Endpoint, path)
+	return nil
+}
+
+// promptPassword reads a password from the terminal without echoing it
+func promptPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	if stty(os.Stdin, "-echo") == nil {
+		defer func() {
+			stty(os.Stdin, "echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stty changes terminal settings; it fails harmlessly when stdin is not a terminal
+func stty(tty *os.File, arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
+
+// newProjectsCommand builds "servio projects"
+func newProjectsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "projects",
+		Short: "List projects",
+		RunE:  runGroup,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List projects",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return listProjects(cmd.Context())
+		},
+	})
+	return cmd
+}
+
+// listProjects handles "servio projects list"
+func listProjects(ctx context.Context) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	var projects []*storage.Project
+	if err := c.do(ctx, http.MethodGet, "/api/projects", nil, &projects); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tDOMAIN\tDESCRIPTION")
+	for _, p := range projects {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.ID, p.Name, orDash(p.Domain), p.Description)
+	}
+	return tw.Flush()
+}
+
+// newExportCommand builds "servio export ansible|terraform", which writes to
+// --out or stdout
+func newExportCommand() *cobra.Command {
+	var project, out string
+	cmd := &cobra.Command{
+		Use:       "export ansible|terraform",
+		Short:     "Print this server's services, units, .env files, and nginx sites as infrastructure as code",
+		ValidArgs: []string{"ansible", "terraform"},
+		Args:      exactArgs(1, "expected a format, ansible or terraform"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[0] != "ansible" && args[0] != "terraform" {
+				return usageError(cmd, "unknown format %q", args[0])
+			}
+			return runExport(cmd.Context(), args[0], project, out)
+		},
+	}
+	cmd.Flags().StringVar(&project, "project", "", "only export this project")
+	cmd.Flags().StringVar(&out, "out", "", "file to write instead of stdout")
+	cmd.RegisterFlagCompletionFunc("project", completeProjects)
+	return cmd
+}
+
+func runExport(ctx context.Context, format, project, out string) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"format": {format}}
+	if project != "" {
+		p, err := findProject(ctx, c, project)
+		if err != nil {
+			return err
+		}
+		query.Set("project_id", strconv.FormatInt(p.ID, 10))
+	}
+
+	var buf bytes.Buffer
+	if err := c.do(ctx, http.MethodGet, "/api/export/config?"+query.Encode(), nil, &buf); err != nil {
+		return err
+	}
+	if out == "" {
+		_, err := buf.WriteTo(os.Stdout)
+		return err
+	}
+	if err := os.WriteFile(out, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", out)
+	return nil
+}
+
+// projectPlan is a project's plan as GET /api/projects/{id}/plan returns it
+type projectPlan struct {
+	Files []struct {
+		Path      string `json:"path"`
+		Change    string `json:"change"`
+		Target    string `json:"target"`
+		Diff      string `json:"diff"`
+		Sensitive bool   `json:"sensitive"`
+	} `json:"files"`
+	Unchanged   int      `json:"unchanged"`
+	Commands    []string `json:"commands"`
+	Fingerprint string   `json:"fingerprint"`
+	Restarted   []string `json:"restarted"`
+}
+
+// planFlags are the flags of "servio plan"
+type planFlags struct {
+	apply, restart, color bool
+}
+
+// newPlanCommand builds "servio plan PROJECT", which prints the files applying
+// the project would change, and applies exactly that plan with --apply
+func newPlanCommand() *cobra.Command {
+	var flags planFlags
+	cmd := &cobra.Command{
+		Use:               "plan PROJECT",
+		Short:             "Show the unit, .env, and nginx files applying a project would change, as diffs, and apply them",
+		Args:              exactArgs(1, "expected a project name"),
+		ValidArgsFunction: completeProjectArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPlan(cmd.Context(), args[0], flags)
+		},
+	}
+	cmd.Flags().BoolVar(&flags.apply, "apply", false, "apply the plan after printing it")
+	cmd.Flags().BoolVar(&flags.restart, "restart", false, "with --apply, restart running services whose files changed")
+	cmd.Flags().BoolVar(&flags.color, "color", false, "color the diffs")
+	return cmd
+}
+
+func runPlan(ctx context.Context, name string, flags planFlags) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	project, err := findProject(ctx, c, name)
+	if err != nil {
+		return err
+	}
+	path := "/api/projects/" + strconv.FormatInt(project.ID, 10) + "/plan"
+	var plan projectPlan
+	if err := c.do(ctx, http.MethodGet, path, nil, &plan); err != nil {
+		return err
+	}
+
+	for _, f := range plan.Files {
+		line := fmt.Sprintf("%s %s", f.Change, f.Path)
+		if f.Target != "" {
+			line += " -> " + f.Target
+		}
+		if flags.color {
+			line = "\x1b[1m" + line + "\x1b[0m"
+		}
+		fmt.Println(line)
+		if f.Sensitive {
+			fmt.Println("  (holds secrets; diff not shown)")
+		}
+		printDiff(f.Diff, flags.color)
+	}
+	for _, cmd := range plan.Commands {
+		fmt.Printf("run %s\n", cmd)
+	}
+	if len(plan.Files) == 0 {
+		fmt.Printf("No changes; %d files match.\n", plan.Unchanged)
+		return nil
+	}
+	fmt.Printf("\n%d to change, %d unchanged.\n", len(plan.Files), plan.Unchanged)
+	if !flags.apply {
+		return nil
+	}
+
+	body := map[string]interface{}{"fingerprint": plan.Fingerprint, "restart": flags.restart}
+	var applied projectPlan
+	if err := c.do(ctx, http.MethodPost, path+"/apply", body, &applied); err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d changes.\n", len(applied.Files))
+	if len(applied.Restarted) > 0 {
+		fmt.Printf("Restarted %s.\n", strings.Join(applied.Restarted, ", "))
+	}
+	return nil
+}
+
+// reconcileResult is the server's answer to reconciling the host
+type reconcileResult struct {
+	Files []struct {
+		Path    string `json:"path"`
+		Change  string `json:"change"`
+		Source  string `json:"source"`
+		Project string `json:"project"`
+		Part    string `json:"part"`
+	} `json:"files"`
+	Enabled []string `json:"enabled"`
+	Started []string `json:"started"`
+	Skipped []string `json:"skipped"`
+	Errors  []string `json:"errors"`
+}
+
+// newReconcileCommand builds "servio reconcile", which has the server install
+// again what is missing from the host or changed on it, and lists what that was
+func newReconcileCommand() *cobra.Command {
+	var dryRun, start bool
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Install again every unit, .env file, and nginx site missing from the host or changed on it",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runReconcile(cmd.Context(), dryRun, start)
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list what would change without changing anything")
+	cmd.Flags().BoolVar(&start, "start", false, "also start enabled units that are not running")
+	return cmd
+}
+
+func runReconcile(ctx context.Context, dryRun, start bool) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	path := "/api/system/reconcile"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	var result reconcileResult
+	if err := c.do(ctx, http.MethodPost, path, map[string]bool{"start": start}, &result); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, f := range result.Files {
+		part := f.Part
+		if f.Project != "" {
+			part = f.Project + "/" + part
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s (%s)\n", f.Change, f.Path, part, f.Source)
+	}
+	for _, unit := range result.Enabled {
+		fmt.Fprintf(tw, "enable\t%s\t\n", unit)
+	}
+	for _, unit := range result.Started {
+		fmt.Fprintf(tw, "start\t%s\t\n", unit)
+	}
+	tw.Flush()
+	for _, name := range result.Skipped {
+		fmt.Printf("Skipped %s: it runs on an agent host.\n", name)
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintln(os.Stderr, "error:", e)
+	}
+
+	changes := len(result.Files) + len(result.Enabled) + len(result.Started)
+	switch {
+	case changes == 0 && len(result.Errors) == 0:
+		fmt.Println("Nothing to reconcile; the host matches.")
+	case dryRun:
+		fmt.Printf("\n%d changes to make.\n", changes)
+	default:
+		fmt.Printf("\nMade %d changes.\n", changes)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d parts failed to reconcile", len(result.Errors))
+	}
+	return nil
+}
+
+// printDiff prints a unified diff indented, with added and removed lines
+// colored when color is set
+func printDiff(diff string, color bool) {
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		switch {
+		case !color, strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+		case line[0] == '+':
+			line = "\x1b[32m" + line + "\x1b[0m"
+		case line[0] == '-':
+			line = "\x1b[31m" + line + "\x1b[0m"
+		case line[0] == '@':
+			line = "\x1b[36m" + line + "\x1b[0m"
+		}
+		fmt.Println("  " + line)
+	}
+}
+
+// findProject looks a project up by name
+func findProject(ctx context.Context, c *client, name string) (*storage.Project, error) {
+	var projects []*storage.Project
+	if err := c.do(ctx, http.MethodGet, "/api/projects", nil, &projects); err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no project named %q", name)
+}
+
+// newServiceCommand builds "servio svc", which lists, controls, and scales services
+func newServiceCommand() *cobra.Command {
+	var project string
+	cmd := &cobra.Command{
+		Use:   "svc",
+		Short: "List, control, or scale services",
+		RunE:  runGroup,
+	}
+	cmd.PersistentFlags().StringVar(&project, "project", "", "only consider services in this project")
+	cmd.RegisterFlagCompletionFunc("project", completeProjects)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List services",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := connect()
+			if err != nil {
+				return err
+			}
+			return listServices(cmd.Context(), c, project)
+		},
+	})
+	for _, action := range []struct{ name, short string }{
+		{"status", "Print a service's status"},
+		{"start", "Start a service"},
+		{"stop", "Stop a service"},
+		{"restart", "Restart a service"},
+	} {
+		cmd.AddCommand(&cobra.Command{
+			Use:               action.name + " NAME",
+			Short:             action.short,
+			Args:              exactArgs(1, action.name+" takes exactly one service name"),
+			ValidArgsFunction: completeServices,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return controlService(cmd.Context(), action.name, project, args[0])
+			},
+		})
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:               "scale NAME N",
+		Short:             "Run N instances of a service",
+		Args:              exactArgs(2, "scale takes a service name and a number of instances"),
+		ValidArgsFunction: completeServices,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			replicas, err := strconv.Atoi(args[1])
+			if err != nil || replicas < 1 {
+				return usageError(cmd, "invalid number of instances %q", args[1])
+			}
+			return scaleService(cmd.Context(), project, args[0], replicas)
+		},
+	})
+	return cmd
+}
+
+// controlService handles "servio svc status|start|stop|restart NAME"
+func controlService(ctx context.Context, action, project, name string) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	svc, err := findService(ctx, c, project, name)
+	if err != nil {
+		return err
+	}
+	if action == "status" {
+		return printServiceStatus(ctx, c, svc)
+	}
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/services/%d/%s", svc.ID, action), nil, &resp); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", svc.Name, resp.Status)
+	return nil
+}
+
+// scaleService handles "servio svc scale NAME N"
+func scaleService(ctx context.Context, project, name string, replicas int) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	svc, err := findService(ctx, c, project, name)
+	if err != nil {
+		return err
+	}
+	body := map[string]int{"replicas": replicas}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/services/%d/scale", svc.ID), body, nil); err != nil {
+		return err
+	}
+	fmt.Printf("%s scaled to %d instances\n", svc.Name, replicas)
+	return nil
+}
+
+// listServices prints every service, optionally limited to one project
+func listServices(ctx context.Context, c *client, project string) error {
+	services, names, err := fetchServices(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPROJECT\tNAME\tTYPE\tPORT")
+	for _, svc := range services {
+		if project != "" && names[svc.ProjectID] != project {
+			continue
+		}
+		port := "-"
+		if svc.Port > 0 {
+			port = fmt.Sprint(svc.Port)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", svc.ID, names[svc.ProjectID], svc.Name, svc.Type, port)
+	}
+	return tw.Flush()
+}
+
+// printServiceStatus fetches a service's live systemd status
+func printServiceStatus(ctx context.Context, c *client, svc *storage.Service) error {
+	var full storage.Service
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/services/%d", svc.ID), nil, &full); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", full.Name, orDash(full.Status))
+	return nil
+}
+
+// logsFlags are the flags of "servio logs"
+type logsFlags struct {
+	follow  bool
+	lines   int
+	since   string
+	project string
+	color   bool
+}
+
+// newLogsCommand builds "servio logs NAME", which prints or follows a service's logs
+func newLogsCommand() *cobra.Command {
+	var flags logsFlags
+	cmd := &cobra.Command{
+		Use:               "logs NAME",
+		Short:             "Print (or follow) a service's logs",
+		Args:              exactArgs(1, "logs takes exactly one service name"),
+		ValidArgsFunction: completeServices,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if flags.follow && (flags.lines > 0 || flags.since != "") {
+				return usageError(cmd, "--lines and --since do not apply to --follow")
+			}
+			return runLogs(cmd.Context(), args[0], flags)
+		},
+	}
+	cmd.Flags().BoolVarP(&flags.follow, "follow", "f", false, "follow the log as new lines are written")
+	cmd.Flags().IntVarP(&flags.lines, "lines", "n", 0, "print at most this many of the most recent lines (server default 1000)")
+	cmd.Flags().StringVar(&flags.since, "since", "", "print lines from this long ago, such as 30m or 2d, or an RFC 3339 time, instead of since the service last started")
+	cmd.Flags().StringVar(&flags.project, "project", "", "only consider services in this project")
+	cmd.Flags().BoolVar(&flags.color, "color", false, "keep the color escape codes the service printed")
+	cmd.RegisterFlagCompletionFunc("project", completeProjects)
+	return cmd
+}
+
+func runLogs(ctx context.Context, name string, flags logsFlags) error {
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	svc, err := findService(ctx, c, flags.project, name)
+	if err != nil {
+		return err
+	}
+
+	query := "?ansi=" + ansi.ModeStrip
+	if flags.color {
+		query = "?ansi=" + ansi.ModeRaw
+	}
+	if !flags.follow {
+		if flags.lines > 0 {
+			query += "&lines=" + strconv.Itoa(flags.lines)
+		}
+		if flags.since != "" {
+			query += "&since=" + url.QueryEscape(flags.since)
+		}
+		var resp struct {
+			Logs      string `json:"logs"`
+			Truncated bool   `json:"truncated"`
+		}
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/services/%d/logs%s", svc.ID, query), nil, &resp); err != nil {
+			return err
+		}
+		if resp.Truncated {
+			fmt.Fprintln(os.Stderr, "(older lines omitted; use --lines to print more)")
+		}
+		fmt.Print(resp.Logs)
+		return nil
+	}
+
+	err = c.stream(ctx, fmt.Sprintf("/api/services/%d/logs/stream%s", svc.ID, query), func(event, data string) error {
+		if event == "error" {
+			return fmt.Errorf("log stream failed: %s", data)
+		}
+		fmt.Println(data)
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil // interrupted by the user
+	}
+	return err
+}
+
+// fetchServices returns every visible service plus project names keyed by ID
+func fetchServices(ctx context.Context, c *client) ([]*storage.Service, map[int64]string, error) {
+	var projects []*storage.Project
+	if err := c.do(ctx, http.MethodGet, "/api/projects", nil, &projects); err != nil {
+		return nil, nil, err
+	}
+	names := make(map[int64]string, len(projects))
+	for _, p := range projects {
+		names[p.ID] = p.Name
+	}
+
+	var services []*storage.Service
+	if err := c.do(ctx, http.MethodGet, "/api/services?sort=name", nil, &services); err != nil {
+		return nil, nil, err
+	}
+	return services, names, nil
+}
+
+// findService resolves NAME or PROJECT/NAME to a single service. Names are only
+// unique within a project, so a bare name matching several services is an error.
+func findService(ctx context.Context, c *client, project, name string) (*storage.Service, error) {
+	if p, n, ok := strings.Cut(name, "/"); ok {
+		project, name = p, n
+	}
+
+	services, names, err := fetchServices(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*storage.Service
+	for _, svc := range services {
+		if svc.Name == name && (project == "" || names[svc.ProjectID] == project) {
+			matches = append(matches, svc)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if project != "" {
+			return nil, fmt.Errorf("no service %q in project %q", name, project)
+		}
+		return nil, fmt.Errorf("no service named %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		in := make([]string, len(matches))
+		for i, svc := range matches {
+			in[i] = names[svc.ProjectID] + "/" + svc.Name
+		}
+		return nil, fmt.Errorf("service name %q is ambiguous; use one of: %s", name, strings.Join(in, ", "))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"servio/internal/storage"
+)
+
+// completeTimeout keeps a slow or unreachable server from stalling the shell
+const completeTimeout = 2 * time.Second
+
+// completeProjects offers project names for a --project flag
+func completeProjects(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	ctx, cancel := completeContext(cmd)
+	defer cancel()
+	return matching(projectNames(ctx), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeProjectArg offers project names for a command's first argument
+func completeProjectArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeProjects(cmd, args, toComplete)
+}
+
+// completeServices offers service names for a command's first argument
+func completeServices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	ctx, cancel := completeContext(cmd)
+	defer cancel()
+	return matching(serviceNames(ctx), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeContext bounds the server requests made for a completion. Completion
+// never fails: a missing server just means no candidates.
+func completeContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithTimeout(ctx, completeTimeout)
+}
+
+// projectNames fetches project names, or none if the server is unreachable
+func projectNames(ctx context.Context) []string {
+	c, err := connect()
+	if err != nil {
+		return nil
+	}
+	var projects []*storage.Project
+	if err := c.do(ctx, http.MethodGet, "/api/projects", nil, &projects); err != nil {
+		return nil
+	}
+	out := make([]string, len(projects))
+	for i, p := range projects {
+		out[i] = p.Name
+	}
+	return out
+}
+
+// serviceNames offers each service by bare name when that is unambiguous,
+// and always as PROJECT/NAME
+func serviceNames(ctx context.Context) []string {
+	c, err := connect()
+	if err != nil {
+		return nil
+	}
+	services, names, err := fetchServices(ctx, c)
+	if err != nil {
+		return nil
+	}
+
+	count := make(map[string]int)
+	for _, svc := range services {
+		count[svc.Name]++
+	}
+	var out []string
+	for _, svc := range services {
+		if count[svc.Name] == 1 {
+			out = append(out, svc.Name)
+		}
+		out = append(out, names[svc.ProjectID]+"/"+svc.Name)
+	}
+	return out
+}
+
+// matching filters candidates by prefix and sorts them
+func matching(candidates []string, prefix string) []string {
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, c)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config is where the CLI finds the server and how it authenticates. It is
+// stored as JSON in the user's config directory; SERVIO_ENDPOINT,
+// SERVIO_USERNAME, and SERVIO_PASSWORD override the stored values.
+type Config struct {
+	Endpoint   string `json:"endpoint"` // http(s)://host:port or unix:/path/to.sock
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	CACert     string `json:"ca_cert,omitempty"`     // CA bundle to trust for an HTTPS endpoint
+	ClientCert string `json:"client_cert,omitempty"` // client certificate for mutual TLS
+	ClientKey  string `json:"client_key,omitempty"`
+}
+
+// defaultEndpoint matches the server's default -addr
+const defaultEndpoint = "http://127.0.0.1:8080"
+
+// configPath returns the location of the stored CLI config
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(dir, "servio", "cli.json"), nil
+}
+
+// loadConfig reads the stored config, if any, and applies environment overrides
+func loadConfig() (*Config, error) {
+	cfg := &Config{Endpoint: defaultEndpoint}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	for env, field := range map[string]*string{
+		"SERVIO_ENDPOINT": &cfg.Endpoint,
+		"SERVIO_USERNAME": &cfg.Username,
+		"SERVIO_PASSWORD": &cfg.Password,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the config readable by the current user only, since it holds a password
+func saveConfig(cfg *Config) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %w", err)
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"servio/internal/doctor"
+	"servio/internal/systemd"
+)
+
+// nginxDirs are the site directories of the supported distributions; doctor
+// checks the ones present, or conf.d when nginx is not installed yet
+var nginxDirs = []string{"/etc/nginx/sites-available", "/etc/nginx/sites-enabled", "/etc/nginx/conf.d"}
+
+// errChecksFailed makes doctor exit non-zero without repeating the report
+var errChecksFailed = errors.New("some checks failed")
+
+// newDoctorCommand builds "servio doctor": checks run in this process unless
+// --remote asks the configured server, which sees its own user and permissions
+func newDoctorCommand() *cobra.Command {
+	var remote bool
+	var dataDir string
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check host prerequisites (systemd, nginx, git, sudo, paths)",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runDoctor(cmd.Context(), remote, dataDir)
+		},
+	}
+	cmd.Flags().BoolVar(&remote, "remote", false, "ask the running server (GET /api/system/doctor) instead of checking locally")
+	cmd.Flags().StringVar(&dataDir, "data-dir", "/var/lib/servio", "Servio's data directory (local checks only)")
+	return cmd
+}
+
+func runDoctor(ctx context.Context, remote bool, dataDir string) error {
+	var report *doctor.Report
+	if remote {
+		c, err := connect()
+		if err != nil {
+			return err
+		}
+		report = &doctor.Report{}
+		if err := c.do(ctx, http.MethodGet, "/api/system/doctor", nil, report); err != nil {
+			return err
+		}
+	} else {
+		dirs := []string{systemd.ServiceDir}
+		for _, dir := range nginxDirs {
+			if _, err := os.Stat(dir); err == nil {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) == 1 {
+			dirs = append(dirs, nginxDirs[len(nginxDirs)-1])
+		}
+		report = doctor.Run(ctx, doctor.Options{Dirs: append(dirs, dataDir)})
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range report.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", statusLabel(r.Status), r.Name, r.Detail)
+		if r.Fix != "" {
+			fmt.Fprintf(tw, "\t\t  fix: %s\n", r.Fix)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if report.Status == doctor.StatusFail {
+		return errChecksFailed
+	}
+	return nil
+}
+
+func statusLabel(s doctor.Status) string {
+	switch s {
+	case doctor.StatusPass:
+		return "[ok]"
+	case doctor.StatusWarn:
+		return "[warn]"
+	default:
+		return "[FAIL]"
+	}
+}
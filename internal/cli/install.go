@@ -0,0 +1,417 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// Paths written by "servio install"
+const (
+	unitPath     = "/etc/systemd/system/servio.service"
+	envPath      = "/etc/servio/servio.env"
+	sudoersPath  = "/etc/sudoers.d/servio"
+	polkitPath   = "/etc/polkit-1/rules.d/50-servio.rules"
+	installedBin = "/usr/local/bin/servio"
+)
+
+// installOptions are the flags of "servio install"
+type installOptions struct {
+	User    string
+	Addr    string
+	DataDir string
+	Bin     string
+	Force   bool
+	DryRun  bool
+}
+
+// installer performs (or, in dry-run mode, prints) each installation step
+type installer struct {
+	ctx context.Context
+	installOptions
+	uid, gid int
+}
+
+// unitTemplate is the hardened unit for Servio itself. Managing other units,
+// /etc/nginx, and service working directories needs the ambient capabilities;
+// sudo (for nginx reloads and package installs) needs NoNewPrivileges off.
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Servio - Lightweight Service Manager
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+Group={{.User}}
+{{- if ne .User "root"}}
+SupplementaryGroups=systemd-journal
+AmbientCapabilities=CAP_CHOWN CAP_DAC_OVERRIDE CAP_FOWNER CAP_NET_BIND_SERVICE
+{{- end}}
+WorkingDirectory={{.DataDir}}
+ExecStart={{.Bin}} -config {{.EnvPath}} -addr {{.Addr}} -db {{.DataDir}}/data.db -secret-key-file {{.DataDir}}/servio.key
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=always
+RestartSec=5s
+LimitNOFILE=65535
+
+# Hardening that leaves /etc and service directories writable
+NoNewPrivileges=false
+PrivateTmp=true
+ProtectClock=true
+ProtectHostname=true
+ProtectKernelModules=true
+ProtectKernelTunables=true
+ProtectKernelLogs=true
+ProtectControlGroups=true
+RestrictRealtime=true
+LockPersonality=true
+SystemCallArchitectures=native
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// sudoersTemplate covers the commands Servio runs through sudo
+var sudoersTemplate = template.Must(template.New("sudoers").Parse(`# Managed by "servio install"
+Defaults:{{.User}} env_keep += "DEBIAN_FRONTEND"
+{{- range .Commands}}
+{{$.User}} ALL=(root) NOPASSWD: {{.}}
+{{- end}}
+{{- range .PostgresCommands}}
+{{$.User}} ALL=(postgres) NOPASSWD: {{.}}
+{{- end}}
+`))
+
+// polkitTemplate lets the Servio user start, stop, and reload systemd units,
+// which systemctl otherwise only allows root to do
+const polkitTemplate = `// Managed by "servio install"
+polkit.addRule(function(action, subject) {
+    if (action.id.indexOf("org.freedesktop.systemd1.") == 0 && subject.user == %q) {
+        return polkit.Result.YES;
+    }
+});
+`
+
+// newInstallCommand builds "servio install"
+func newInstallCommand() *cobra.Command {
+	in := &installer{}
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install and start Servio as a systemd service (as root)",
+		Args:  noArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !filepath.IsAbs(in.DataDir) || !filepath.IsAbs(in.Bin) {
+				return usageError(cmd, "--data-dir and --bin must be absolute paths")
+			}
+			in.ctx = cmd.Context()
+			return runInstall(in)
+		},
+	}
+	cmd.Flags().StringVar(&in.User, "user", "servio", `system user to run as ("root" skips the dedicated user, sudoers, and polkit entries)`)
+	cmd.Flags().StringVar(&in.Addr, "addr", ":8080", "HTTP server address")
+	cmd.Flags().StringVar(&in.DataDir, "data-dir", "/var/lib/servio", "directory for the database and secret key")
+	cmd.Flags().StringVar(&in.Bin, "bin", installedBin, "where to install the servio binary")
+	cmd.Flags().BoolVar(&in.Force, "force", false, "overwrite an existing servio.service unit")
+	cmd.Flags().BoolVar(&in.DryRun, "dry-run", false, "print the files and commands without changing anything")
+	return cmd
+}
+
+func runInstall(in *installer) error {
+	if !in.DryRun {
+		if os.Geteuid() != 0 {
+			return errors.New("install must be run as root (try sudo, or --dry-run to preview)")
+		}
+		if _, err := os.Stat(unitPath); err == nil && !in.Force {
+			return fmt.Errorf("%s already exists; use --force to replace it", unitPath)
+		}
+		if err := in.checkTools(); err != nil {
+			return err
+		}
+	}
+	return in.install()
+}
+
+// checkTools fails before anything is changed if a command the installation
+// (or a non-root Servio) relies on is missing
+func (in *installer) checkTools() error {
+	tools := []string{"systemctl"}
+	if in.User != "root" {
+		tools = append(tools, "useradd", "sudo", "visudo")
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			if in.User != "root" && (tool == "sudo" || tool == "visudo") {
+				return fmt.Errorf("%s not found: install sudo, or use --user root", tool)
+			}
+			return fmt.Errorf("%s not found: %w", tool, err)
+		}
+	}
+	return nil
+}
+
+func (in *installer) install() error {
+	if in.User != "root" {
+		if err := in.createUser(); err != nil {
+			return err
+		}
+	}
+	if err := in.mkdir(in.DataDir, 0750, in.uid); err != nil {
+		return err
+	}
+	if err := in.installBinary(); err != nil {
+		return err
+	}
+	password, err := in.writeEnvFile()
+	if err != nil {
+		return err
+	}
+	if in.User != "root" {
+		if err := in.writeSudoers(); err != nil {
+			return err
+		}
+		if err := in.writeFile(polkitPath, fmt.Sprintf(polkitTemplate, in.User), 0644, false); err != nil {
+			return err
+		}
+	}
+
+	var unit bytes.Buffer
+	if err := unitTemplate.Execute(&unit, struct {
+		installOptions
+		EnvPath string
+	}{in.installOptions, envPath}); err != nil {
+		return err
+	}
+	if err := in.writeFile(unitPath, unit.String(), 0644, false); err != nil {
+		return err
+	}
+	if err := in.run("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := in.run("systemctl", "enable", "--now", "servio"); err != nil {
+		return err
+	}
+
+	if in.DryRun {
+		return nil
+	}
+	fmt.Printf("\nServio is running as %s on %s and starts on boot.\n", in.User, in.Addr)
+	if password != "" {
+		fmt.Printf("Log in as admin with password %s (stored in %s).\n", password, envPath)
+	} else {
+		fmt.Printf("Log in with the credentials in %s.\n", envPath)
+	}
+	fmt.Println("Check it with: systemctl status servio")
+	return nil
+}
+
+// createUser adds the system user unless it already exists
+func (in *installer) createUser() error {
+	if u, err := user.Lookup(in.User); err == nil {
+		in.uid, _ = strconv.Atoi(u.Uid)
+		in.gid, _ = strconv.Atoi(u.Gid)
+		return nil
+	}
+	if err := in.run("useradd", "--system", "--user-group", "--home-dir", in.DataDir, "--shell", "/usr/sbin/nologin", in.User); err != nil {
+		return err
+	}
+	if in.DryRun {
+		return nil
+	}
+	u, err := user.Lookup(in.User)
+	if err != nil {
+		return fmt.Errorf("failed to look up new user %s: %w", in.User, err)
+	}
+	in.uid, _ = strconv.Atoi(u.Uid)
+	in.gid, _ = strconv.Atoi(u.Gid)
+	return nil
+}
+
+// installBinary copies the running executable to -bin, unless it is already there
+func (in *installer) installBinary() error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate servio binary: %w", err)
+	}
+	if self, err = filepath.EvalSymlinks(self); err != nil {
+		return fmt.Errorf("failed to locate servio binary: %w", err)
+	}
+	if self == in.Bin {
+		return nil
+	}
+	if in.DryRun {
+		fmt.Printf("# copy %s to %s\n", self, in.Bin)
+		return nil
+	}
+
+	data, err := os.ReadFile(self)
+	if err != nil {
+		return fmt.Errorf("failed to read servio binary: %w", err)
+	}
+	// Write beside the target and rename, so a running copy is replaced atomically
+	tmp := in.Bin + ".new"
+	if err := os.WriteFile(tmp, data, 0755); err != nil {
+		return fmt.Errorf("failed to install binary: %w", err)
+	}
+	if err := os.Rename(tmp, in.Bin); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to install binary: %w", err)
+	}
+	return nil
+}
+
+// writeEnvFile creates the environment file with generated admin credentials.
+// An existing file is kept so reinstalling never changes the login; the
+// returned password is empty in that case.
+func (in *installer) writeEnvFile() (string, error) {
+	if _, err := os.Stat(envPath); err == nil && !in.DryRun {
+		return "", nil
+	}
+	// Config stays root-owned; the service user only needs to read it
+	if err := in.mkdir(filepath.Dir(envPath), 0750, 0); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf)
+	if in.DryRun {
+		password = "<generated>"
+	}
+	content := "SERVIO_PROFILE=prod\nSERVIO_USERNAME=admin\nSERVIO_PASSWORD=" + password + "\n"
+	// Readable by the service user through its group, but not writable by it
+	return password, in.writeFile(envPath, content, 0640, true)
+}
+
+// writeSudoers grants the commands Servio runs with sudo, resolved to absolute
+// paths as sudoers requires. The file is checked with visudo before it is used.
+func (in *installer) writeSudoers() error {
+	var commands []string
+	if nginx, err := exec.LookPath("nginx"); err == nil {
+		commands = append(commands, nginx+" -t")
+	}
+	if systemctl, err := exec.LookPath("systemctl"); err == nil {
+		commands = append(commands, systemctl+" reload nginx", systemctl+" reboot", systemctl+" poweroff")
+	}
+	// Let's Encrypt certificates; certbot may only be installed later
+	certbot, err := exec.LookPath("certbot")
+	if err != nil {
+		certbot = "/usr/bin/certbot"
+	}
+	commands = append(commands, certbot+" certonly *", certbot+" certificates *", certbot+" delete *")
+	// Journal vacuums, system-wide and per service namespace
+	if journalctl, err := exec.LookPath("journalctl"); err == nil {
+		commands = append(commands, journalctl+" --vacuum-*", journalctl+" --namespace=* --vacuum-*")
+	}
+	// Blueprint installs of database packages, and OS package updates
+	if dnf, err := exec.LookPath("dnf"); err == nil {
+		commands = append(commands, dnf+" install -y postgresql*", dnf+" makecache -q", dnf+" upgrade -y*")
+	}
+	if apt, err := exec.LookPath("apt-get"); err == nil {
+		// sudoers needs the colons and equals signs of the dpkg options escaped
+		commands = append(commands, apt+" update", apt+" install -y postgresql-*",
+			apt+` -y -o Dpkg\:\:Options\:\:\=--force-confdef -o Dpkg\:\:Options\:\:\=--force-confold *`)
+	}
+
+	// Database administration of postgres services, as the postgres user.
+	// The client tools may only arrive with a blueprint install, so fall
+	// back to where both distributions put them.
+	var postgresCommands []string
+	for _, name := range []string{"psql", "pg_dump"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			path = "/usr/bin/" + name
+		}
+		postgresCommands = append(postgresCommands, path+" *")
+	}
+
+	var buf bytes.Buffer
+	if err := sudoersTemplate.Execute(&buf, struct {
+		User             string
+		Commands         []string
+		PostgresCommands []string
+	}{in.User, commands, postgresCommands}); err != nil {
+		return err
+	}
+	content := buf.String()
+
+	if in.DryRun {
+		return in.writeFile(sudoersPath, content, 0440, false)
+	}
+	tmp := sudoersPath + ".new"
+	if err := os.WriteFile(tmp, []byte(content), 0440); err != nil {
+		return fmt.Errorf("failed to write sudoers entry: %w", err)
+	}
+	if out, err := exec.CommandContext(in.ctx, "visudo", "-cf", tmp).CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("generated sudoers entry is invalid: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	if err := os.Rename(tmp, sudoersPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write sudoers entry: %w", err)
+	}
+	return nil
+}
+
+// mkdir creates a directory owned by uid and the service user's group
+func (in *installer) mkdir(dir string, mode os.FileMode, uid int) error {
+	if in.DryRun {
+		fmt.Printf("# mkdir -m %o %s\n", mode, dir)
+		return nil
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return err
+	}
+	return os.Chown(dir, uid, in.gid)
+}
+
+// writeFile writes a root-owned file, optionally group-owned by the service user
+func (in *installer) writeFile(path, content string, mode os.FileMode, serviceGroup bool) error {
+	if in.DryRun {
+		fmt.Printf("# write %s (mode %o)\n%s\n", path, mode, content)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if serviceGroup {
+		return os.Chown(path, 0, in.gid)
+	}
+	return nil
+}
+
+// run executes a setup command, printing it first
+func (in *installer) run(name string, args ...string) error {
+	fmt.Printf("# %s %s\n", name, strings.Join(args, " "))
+	if in.DryRun {
+		return nil
+	}
+	out, err := exec.CommandContext(in.ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
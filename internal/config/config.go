@@ -1,40 +1,438 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/joho/godotenv"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Addr     string
-	DBPath   string
-	LogLevel string
+	Addr            string
+	Listen          string // host:port or unix:/path/to.sock; defaults to Addr
+	SocketMode      os.FileMode
+	SocketGroup     string
+	TLSCert         string
+	TLSKey          string
+	TLSClientCA     string         // CA bundle; when set, clients must present a certificate it signed
+	Admins          []string       // users besides SERVIO_USERNAME who are not limited to their teams
+	RateLimit       int            // API requests per minute per client; 0 disables
+	RateLimits      map[string]int // per-client overrides of RateLimit
+	Dev             bool           // read templates and static files from the source tree
+	BasePath        string         // URL prefix when reverse-proxied below a location, e.g. /servio; "" at the root
+	DBPath          string
+	LogLevel        string // debug, info, warn, or error
+	LogFormat       string // text or json
+	SecretKeyFile   string
+	NginxSitesDir   string // overrides the distro's sites-available (or conf.d) directory
+	NginxEnabledDir string // overrides the distro's sites-enabled directory; used with NginxSitesDir
+	ConfigFile      string // env file read at startup and again by Reload
+	Profile         string // dev, staging, prod, or "" for the plain defaults
+	DryRun          bool   // log host changes instead of making them (see dryrun.SetGlobal)
+	Mock            bool   // simulate systemd in memory
+	Supervise       bool   // run services as child processes instead of systemd units; the default on macOS
+	Init            string // init system running services: auto, systemd, openrc, or runit
+	RequireAuth     bool   // refuse to start without credentials
+	Username        string // SERVIO_USERNAME; environment only, never a flag
+	Password        string // SERVIO_PASSWORD; environment only, never a flag
+	AgentToken      string // SERVIO_AGENT_TOKEN, which agents join with; environment only, never a flag
+	AgentCA         string // CA bundle trusted for agents' HTTPS certificates, besides the system's
+	StatsDAddr      string // UDP address receiving services' StatsD metrics; "" disables
+	OTLPAddr        string // HTTP address receiving services' OTLP/JSON metrics; "" disables
+
+	// Single sign-on through an OpenID Connect provider or GitHub
+	OIDCIssuer       string            // e.g. https://accounts.google.com, or "github"; "" disables SSO
+	OIDCClientID     string            // the client registered with the provider
+	OIDCClientSecret string            // SERVIO_OIDC_CLIENT_SECRET; environment only, never a flag
+	OIDCRedirectURL  string            // callback registered with the provider; derived from requests when ""
+	OIDCGroupsClaim  string            // claim listing a user's groups
+	OIDCGroups       map[string]string // group, email, or @domain → "admin" or a team name
+}
+
+// profiles adjust the defaults of other settings for where Servio runs.
+// Flags and SERVIO_* variables still override them.
+var profiles = map[string]map[string]string{
+	// A laptop: local only, verbose, and nothing on the machine is changed
+	"dev": {"SERVIO_ADDR": "127.0.0.1:8080", "SERVIO_LOG_LEVEL": "debug", "SERVIO_MOCK": "1", "SERVIO_DRY_RUN": "1"},
+	// A real host being tried out: verbose, and credentials are required
+	"staging": {"SERVIO_LOG_LEVEL": "debug", "SERVIO_REQUIRE_AUTH": "1"},
+	// A VPS: credentials are required and -dev and -mock are refused
+	"prod": {"SERVIO_REQUIRE_AUTH": "1"},
 }
 
-// Load loads the configuration from environment variables and flags
+// profile holds the defaults of the profile being parsed; guarded by loaded
+var profile map[string]string
+
+// loaded remembers which variables came from the config file, so Reload can
+// tell them apart from the process environment, which always wins
+var loaded struct {
+	sync.Mutex
+	path       string
+	processEnv map[string]bool
+	fileKeys   map[string]bool
+}
+
+// Load loads the configuration from the config file, environment variables and flags
 func Load() (*Config, error) {
-	// Load .env file if it exists
-	_ = godotenv.Load()
+	loaded.Lock()
+	defer loaded.Unlock()
 
-	cfg := &Config{}
+	loaded.processEnv = make(map[string]bool)
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		loaded.processEnv[key] = true
+	}
+
+	path, explicit := configPath(os.Args[1:])
+	loaded.path = path
+	vars, err := godotenv.Read(path)
+	if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	applyFile(vars)
+
+	return parse(flag.CommandLine, os.Args[1:])
+}
+
+// Reload re-reads the config file and re-parses the command line, which still
+// takes precedence. Variables removed from the file are unset again.
+func Reload() (*Config, error) {
+	loaded.Lock()
+	defer loaded.Unlock()
+
+	vars, err := godotenv.Read(loaded.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read config file %s: %w", loaded.path, err)
+	}
+	for key := range loaded.fileKeys {
+		if _, ok := vars[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	applyFile(vars)
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return parse(fs, os.Args[1:])
+}
+
+// applyFile exports the config file's variables, except those the process
+// environment already sets
+func applyFile(vars map[string]string) {
+	loaded.fileKeys = make(map[string]bool, len(vars))
+	for key, value := range vars {
+		if loaded.processEnv[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		loaded.fileKeys[key] = true
+	}
+}
+
+// configPath finds -config in args before the flags are parsed, since the
+// file supplies the flags' defaults
+func configPath(args []string) (path string, explicit bool) {
+	if value, ok := flagValue(args, "config"); ok {
+		return value, true
+	}
+	if value, ok := os.LookupEnv("SERVIO_CONFIG"); ok {
+		return value, true
+	}
+	return ".env", false
+}
+
+// flagValue finds the value of -name or --name in args without parsing them
+func flagValue(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		flagName, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || flagName != name {
+			continue
+		}
+		if hasValue {
+			return value, true
+		}
+		if i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// parse defines the flags on fs and builds a Config from args
+func parse(fs *flag.FlagSet, args []string) (*Config, error) {
+	name, ok := flagValue(args, "profile")
+	if !ok {
+		name = os.Getenv("SERVIO_PROFILE")
+	}
+	if _, known := profiles[name]; !known && name != "" {
+		return nil, fmt.Errorf("invalid profile %q: must be dev, staging, or prod", name)
+	}
+	profile = profiles[name]
+
+	cfg := &Config{
+		Username:   os.Getenv("SERVIO_USERNAME"),
+		Password:   os.Getenv("SERVIO_PASSWORD"),
+		AgentToken: os.Getenv("SERVIO_AGENT_TOKEN"),
+
+		OIDCClientSecret: os.Getenv("SERVIO_OIDC_CLIENT_SECRET"),
+	}
 
 	// Define flags
-	flag.StringVar(&cfg.Addr, "addr", getEnv("SERVIO_ADDR", ":8080"), "HTTP server address")
-	flag.StringVar(&cfg.DBPath, "db", getEnv("SERVIO_DB", "servio.db"), "SQLite database path")
-	flag.StringVar(&cfg.LogLevel, "log-level", getEnv("SERVIO_LOG_LEVEL", "info"), "Log level (debug, info, warn, error)")
+	fs.StringVar(&cfg.ConfigFile, "config", getEnv("SERVIO_CONFIG", ".env"), "Env file with SERVIO_* settings; re-read on SIGHUP")
+	fs.StringVar(&cfg.Profile, "profile", name, "Defaults for where Servio runs: dev, staging, or prod")
+	fs.BoolVar(&cfg.DryRun, "dry-run", getEnv("SERVIO_DRY_RUN", "") == "1", "Log unit file, nginx, and command changes instead of making them; the database still changes")
+	fs.BoolVar(&cfg.Mock, "mock", getEnv("SERVIO_MOCK", "") == "1", "Simulate systemd in memory, for machines without it such as macOS")
+	fs.BoolVar(&cfg.Supervise, "supervise", getEnv("SERVIO_SUPERVISE", defaultSupervise()) == "1", "Run services as child processes of Servio instead of systemd units, for machines without systemd; the default on macOS")
+	fs.StringVar(&cfg.Init, "init", getEnv("SERVIO_INIT", "auto"), "Init system running services: systemd, openrc, runit, or auto to detect it")
+	fs.BoolVar(&cfg.RequireAuth, "require-auth", getEnv("SERVIO_REQUIRE_AUTH", "") == "1", "Refuse to start without SERVIO_USERNAME and SERVIO_PASSWORD (or -tls-client-ca or -oidc-issuer)")
+	fs.StringVar(&cfg.Addr, "addr", getEnv("SERVIO_ADDR", ":8080"), "HTTP server address")
+	fs.StringVar(&cfg.DBPath, "db", getEnv("SERVIO_DB", "servio.db"), "SQLite database path")
+	fs.StringVar(&cfg.LogLevel, "log-level", getEnv("SERVIO_LOG_LEVEL", "info"), "Log level (debug, info, warn, error)")
+	fs.StringVar(&cfg.LogFormat, "log-format", getEnv("SERVIO_LOG_FORMAT", "text"), "Log format: text, or json for log aggregators")
+	fs.StringVar(&cfg.Listen, "listen", getEnv("SERVIO_LISTEN", ""), "Listen address: host:port or unix:/path/to.sock (overrides -addr)")
+	socketMode := fs.String("socket-mode", getEnv("SERVIO_SOCKET_MODE", "0660"), "Permissions (octal) for a unix socket")
+	fs.StringVar(&cfg.SocketGroup, "socket-group", getEnv("SERVIO_SOCKET_GROUP", ""), "Group to own a unix socket, e.g. the nginx user's group")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", getEnv("SERVIO_TLS_CERT", ""), "TLS certificate file; serves HTTPS when set with -tls-key")
+	fs.StringVar(&cfg.TLSKey, "tls-key", getEnv("SERVIO_TLS_KEY", ""), "TLS private key file")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", getEnv("SERVIO_TLS_CLIENT_CA", ""), "CA bundle for client certificates; when set, every client must present one")
+	fs.StringVar(&cfg.AgentCA, "agent-ca", getEnv("SERVIO_AGENT_CA", ""), "CA bundle to trust for agents' HTTPS certificates, besides the system's")
+	admins := fs.String("admins", getEnv("SERVIO_ADMINS", ""), "Comma-separated users (e.g. client certificate names) with access to every project")
+	fs.StringVar(&cfg.OIDCIssuer, "oidc-issuer", getEnv("SERVIO_OIDC_ISSUER", ""), "OpenID Connect provider to sign in with, e.g. https://accounts.google.com, or github")
+	fs.StringVar(&cfg.OIDCClientID, "oidc-client-id", getEnv("SERVIO_OIDC_CLIENT_ID", ""), "Client ID registered with the OIDC provider; the secret is read from SERVIO_OIDC_CLIENT_SECRET")
+	fs.StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", getEnv("SERVIO_OIDC_REDIRECT_URL", ""), "Callback URL registered with the provider, ending in /auth/callback (default: derived from the request)")
+	fs.StringVar(&cfg.OIDCGroupsClaim, "oidc-groups-claim", getEnv("SERVIO_OIDC_GROUPS_CLAIM", "groups"), "ID token claim listing the user's groups")
+	oidcGroups := fs.String("oidc-groups", getEnv("SERVIO_OIDC_GROUPS", ""), "Who may sign in and as what, e.g. ops=admin,acme-devs=acme,@example.com=staff (group, email, or @domain = admin or a team)")
+	fs.StringVar(&cfg.StatsDAddr, "statsd-addr", getEnv("SERVIO_STATSD_ADDR", ""), "UDP address to receive services' StatsD metrics on, e.g. 127.0.0.1:8125 (default: off)")
+	fs.StringVar(&cfg.OTLPAddr, "otlp-addr", getEnv("SERVIO_OTLP_ADDR", ""), "Address to receive services' OTLP/HTTP metrics (JSON encoding) on, e.g. 127.0.0.1:4318 (default: off)")
+	fs.IntVar(&cfg.RateLimit, "rate-limit", getEnvInt("SERVIO_RATE_LIMIT", 0), "API requests per minute allowed per client (0 = unlimited)")
+	rateLimits := fs.String("rate-limits", getEnv("SERVIO_RATE_LIMITS", ""), "Per-client overrides of -rate-limit, e.g. deploy-bot=600,ci=60")
+	fs.StringVar(&cfg.BasePath, "base-path", getEnv("SERVIO_BASE_PATH", ""), "URL prefix to serve every route under, e.g. /servio behind an nginx location")
+	fs.BoolVar(&cfg.Dev, "dev", getEnv("SERVIO_DEV", "") == "1", "Development mode: reload templates and static files from internal/http on every request")
+	fs.StringVar(&cfg.NginxSitesDir, "nginx-sites-dir", getEnv("SERVIO_NGINX_SITES_DIR", ""), "Directory for generated nginx sites (default: chosen by the distro setting)")
+	fs.StringVar(&cfg.NginxEnabledDir, "nginx-enabled-dir", getEnv("SERVIO_NGINX_ENABLED_DIR", ""), "Directory to symlink sites into; leave empty for a conf.d layout")
+	fs.StringVar(&cfg.SecretKeyFile, "secret-key-file", getEnv("SERVIO_SECRET_KEY_FILE", "servio.key"), "Path to the master key used to encrypt secrets (created if missing)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.Listen == "" {
+		cfg.Listen = cfg.Addr
+	}
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn, or error", cfg.LogLevel)
+	}
+	switch cfg.Init {
+	case "auto", "systemd", "openrc", "runit":
+	default:
+		return nil, fmt.Errorf("invalid init system %q: must be systemd, openrc, runit, or auto", cfg.Init)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", cfg.LogFormat)
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return nil, fmt.Errorf("invalid socket mode %q: must be octal permissions such as 0660", *socketMode)
+	}
+	cfg.SocketMode = os.FileMode(mode)
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return nil, fmt.Errorf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
+	for _, name := range strings.Split(*admins, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.Admins = append(cfg.Admins, name)
+		}
+	}
 
-	flag.Parse()
+	if cfg.BasePath, err = normalizeBasePath(cfg.BasePath); err != nil {
+		return nil, err
+	}
+
+	if cfg.NginxEnabledDir != "" && cfg.NginxSitesDir == "" {
+		return nil, fmt.Errorf("-nginx-enabled-dir requires -nginx-sites-dir")
+	}
+
+	if cfg.OIDCGroups, err = parseOIDCGroups(*oidcGroups); err != nil {
+		return nil, err
+	}
+	if cfg.OIDCIssuer != "" {
+		if cfg.OIDCClientID == "" || cfg.OIDCClientSecret == "" {
+			return nil, fmt.Errorf("-oidc-issuer requires -oidc-client-id and SERVIO_OIDC_CLIENT_SECRET")
+		}
+		if len(cfg.OIDCGroups) == 0 {
+			return nil, fmt.Errorf("-oidc-issuer requires -oidc-groups: map at least one group, email, or @domain to admin or a team")
+		}
+	}
+
+	if cfg.RequireAuth && (cfg.Username == "" || cfg.Password == "") && cfg.TLSClientCA == "" && cfg.OIDCIssuer == "" {
+		return nil, fmt.Errorf("credentials required: set SERVIO_USERNAME and SERVIO_PASSWORD, -tls-client-ca, or -oidc-issuer")
+	}
+	if cfg.Profile == "prod" && (cfg.Dev || cfg.Mock) {
+		return nil, fmt.Errorf("-dev and -mock are not allowed with -profile prod")
+	}
+
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("invalid rate limit %d: must not be negative", cfg.RateLimit)
+	}
+	if cfg.RateLimits, err = parseRateLimits(*rateLimits); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
 
+// RestartOnly returns the settings that changed between cur and next but are
+// bound when the process starts, then copies their startup values into next
+// so later reloads still report them
+func RestartOnly(cur, next *Config) []string {
+	var restart []string
+	if next.Listen != cur.Listen {
+		restart = append(restart, "listen")
+	}
+	if next.SocketMode != cur.SocketMode || next.SocketGroup != cur.SocketGroup {
+		restart = append(restart, "socket")
+	}
+	if next.TLSCert != cur.TLSCert || next.TLSKey != cur.TLSKey || next.TLSClientCA != cur.TLSClientCA {
+		restart = append(restart, "tls")
+	}
+	if next.AgentCA != cur.AgentCA {
+		restart = append(restart, "agent-ca")
+	}
+	if next.DBPath != cur.DBPath {
+		restart = append(restart, "db")
+	}
+	if next.SecretKeyFile != cur.SecretKeyFile {
+		restart = append(restart, "secret-key-file")
+	}
+	if next.Dev != cur.Dev {
+		restart = append(restart, "dev")
+	}
+	if next.Profile != cur.Profile {
+		restart = append(restart, "profile")
+	}
+	if next.Mock != cur.Mock {
+		restart = append(restart, "mock")
+	}
+	if next.Supervise != cur.Supervise {
+		restart = append(restart, "supervise")
+	}
+	if next.Init != cur.Init {
+		restart = append(restart, "init")
+	}
+	if next.LogFormat != cur.LogFormat {
+		restart = append(restart, "log-format")
+	}
+	if next.BasePath != cur.BasePath {
+		restart = append(restart, "base-path")
+	}
+	if next.StatsDAddr != cur.StatsDAddr || next.OTLPAddr != cur.OTLPAddr {
+		restart = append(restart, "metrics listeners")
+	}
+
+	next.Listen, next.SocketMode, next.SocketGroup = cur.Listen, cur.SocketMode, cur.SocketGroup
+	next.TLSCert, next.TLSKey, next.TLSClientCA, next.AgentCA = cur.TLSCert, cur.TLSKey, cur.TLSClientCA, cur.AgentCA
+	next.DBPath, next.SecretKeyFile, next.Dev, next.BasePath = cur.DBPath, cur.SecretKeyFile, cur.Dev, cur.BasePath
+	next.LogFormat, next.Profile, next.Mock = cur.LogFormat, cur.Profile, cur.Mock
+	next.Supervise, next.Init = cur.Supervise, cur.Init
+	next.StatsDAddr, next.OTLPAddr = cur.StatsDAddr, cur.OTLPAddr
+	return restart
+}
+
+// normalizeBasePath turns "servio/" or "/servio" into "/servio", and "/" into ""
+func normalizeBasePath(p string) (string, error) {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "?#%\\ ") {
+		return "", fmt.Errorf("invalid base path %q: must be a plain URL path such as /servio", p)
+	}
+	return "/" + p, nil
+}
+
+// parseRateLimits parses a comma-separated list of client=limit pairs
+func parseRateLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		client, value, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || strings.TrimSpace(client) == "" || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid rate limit override %q: expected client=requests_per_minute", pair)
+		}
+		limits[strings.TrimSpace(client)] = n
+	}
+	return limits, nil
+}
+
+// parseOIDCGroups parses a comma-separated list of match=role pairs, where
+// match is a group, an email address, or @domain and role is admin or a team
+func parseOIDCGroups(s string) (map[string]string, error) {
+	groups := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		match, role, ok := strings.Cut(pair, "=")
+		match, role = strings.TrimSpace(match), strings.TrimSpace(role)
+		if !ok || match == "" || role == "" {
+			return nil, fmt.Errorf("invalid OIDC group mapping %q: expected group=admin or group=team", pair)
+		}
+		groups[match] = role
+	}
+	return groups, nil
+}
+
+// defaultSupervise turns -supervise on where there is no systemd
+func defaultSupervise() string {
+	if runtime.GOOS == "darwin" {
+		return "1"
+	}
+	return ""
+}
+
+// getEnvInt returns an integer environment variable, or the default if it is unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	if value, exists := lookupEnv(key); exists {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
 // getEnv returns the value of an environment variable or a default value
 func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
+	if value, exists := lookupEnv(key); exists {
 		return value
 	}
 	return defaultValue
 }
+
+// lookupEnv looks up an environment variable, falling back to the profile's default
+func lookupEnv(key string) (string, bool) {
+	if value, exists := os.LookupEnv(key); exists {
+		return value, true
+	}
+	value, exists := profile[key]
+	return value, exists
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]int
+		wantErr bool
+	}{
+		{in: "", want: map[string]int{}},
+		{in: "ci=600", want: map[string]int{"ci": 600}},
+		{in: " ci = 600 , deploy-bot=0,", want: map[string]int{"ci": 600, "deploy-bot": 0}},
+		{in: "ci", wantErr: true},
+		{in: "=5", wantErr: true},
+		{in: "ci=fast", wantErr: true},
+		{in: "ci=-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimits(tt.in)
+		if (err != nil) != tt.wantErr || !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRateLimits(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseOIDCGroups(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "", want: map[string]string{}},
+		{in: "ops=admin, @example.com = web ,jo@example.com=admin", want: map[string]string{"ops": "admin", "@example.com": "web", "jo@example.com": "admin"}},
+		{in: "acme/platform=admin", want: map[string]string{"acme/platform": "admin"}},
+		{in: "ops", wantErr: true},
+		{in: "ops=", wantErr: true},
+		{in: "=admin", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOIDCGroups(tt.in)
+		if (err != nil) != tt.wantErr || !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseOIDCGroups(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "servio", want: "/servio"},
+		{in: " /panel/servio/ ", want: "/panel/servio"},
+		{in: "/servio?x=1", wantErr: true},
+		{in: "/ser vio", wantErr: true},
+		{in: "/servio%2f", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeBasePath(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeBasePath(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRestartOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{name: "nothing", change: func(c *Config) {}},
+		{name: "reloadable", change: func(c *Config) { c.LogLevel, c.RateLimit, c.Password = "debug", 60, "new" }},
+		{name: "listen", change: func(c *Config) { c.Listen = ":9090" }, want: []string{"listen"}},
+		{name: "tls and socket", change: func(c *Config) { c.TLSKey, c.SocketGroup = "key.pem", "www-data" }, want: []string{"socket", "tls"}},
+		{name: "metrics", change: func(c *Config) { c.OTLPAddr = ":4318" }, want: []string{"metrics listeners"}},
+		{name: "mode", change: func(c *Config) { c.Mock, c.Init = true, "runit" }, want: []string{"mock", "init"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur := &Config{Listen: ":8080", LogLevel: "info", Init: "auto", StatsDAddr: ":8125"}
+			next := *cur
+			tt.change(&next)
+			if got := RestartOnly(cur, &next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RestartOnly() = %v, want %v", got, tt.want)
+			}
+			// The startup values stay in place, so the next reload reports them again
+			if again := RestartOnly(cur, &next); again != nil {
+				t.Errorf("RestartOnly() after keeping startup values = %v, want none", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Package container runs services as Docker or Podman containers instead of
+// systemd units. A Runtime implements systemd.ServiceRuntime over the
+// engine's HTTP API, and Router sends each service's operations to the
+// runtime its Runtime field names. Containers share the host's network, so
+// a service's port, nginx proxying, and connections to other services work
+// as they do for units.
+package container
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"servio/internal/systemd"
+)
+
+// ErrEngine is wrapped by errors from requests to a container engine,
+// including engines that are not running
+var ErrEngine = errors.New("container engine request failed")
+
+// ErrSystemdOnly is wrapped by errors for features that only work for
+// services run by systemd, such as journal retention and file logging
+var ErrSystemdOnly = errors.New("only supported for services run by systemd")
+
+// infoPriority is the syslog priority of container log lines, which have
+// none; the journal gives a unit's output the same
+const infoPriority = 6
+
+// containerName is the name of the container a unit name stands for
+func containerName(unit string) string {
+	return strings.TrimSuffix(unit, ".service")
+}
+
+// readLogs reads a multiplexed log stream, calling fn with each line until
+// it returns false or the stream ends. Every frame has an 8-byte header:
+// the stream (1 stdout, 2 stderr), three zero bytes, and the payload size.
+// Lines start with the RFC 3339 timestamp the engine adds.
+func readLogs(r io.Reader, name, hostname string, fn func(systemd.JournalEntry) bool) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	partial := map[byte]string{} // each stream's line not yet ended
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return err
+		}
+		lines := strings.Split(partial[header[0]]+string(payload), "\n")
+		partial[header[0]] = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			if !fn(parseLine(name, hostname, line)) {
+				return nil
+			}
+		}
+	}
+}
+
+// parseLine splits the engine's timestamp off a log line
+func parseLine(name, hostname, line string) systemd.JournalEntry {
+	entry := systemd.JournalEntry{Priority: infoPriority, Hostname: hostname, Identifier: name, Message: line}
+	if stamp, message, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			entry.Time, entry.Message = t, strings.TrimSuffix(message, "\r")
+			entry.Cursor = t.Format(time.RFC3339Nano)
+		}
+	}
+	return entry
+}
+
+// parseSince reads a time in the formats journalctl's --since is given:
+// local "2006-01-02 15:04:05", systemd's timestamps, or RFC 3339
+func parseSince(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "Mon 2006-01-02 15:04:05 MST", time.RFC3339Nano} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// unixTime formats t for the engine's since and until parameters
+func unixTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// shellQuote quotes an argument for the commands shown in dry runs and previews
+func shellQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`!*?;&|<>()[]{}#~") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
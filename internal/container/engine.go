@@ -0,0 +1,278 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"servio/internal/storage"
+)
+
+// maxErrorBody bounds how much of a failed response is read for its message
+const maxErrorBody = 4096
+
+// Engine calls the Docker Engine API over a unix socket. Podman serves the
+// same API, so one client covers both runtimes.
+type Engine struct {
+	name   string // the runtime, and the CLI named in dry runs and the audit trail
+	socket string
+	http   *http.Client
+}
+
+// NewEngine creates a client for the engine of a runtime listening on socket
+func NewEngine(name, socket string) *Engine {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Engine{name: name, socket: socket, http: &http.Client{Transport: transport}}
+}
+
+// DefaultSocket returns where a runtime's engine listens: DOCKER_HOST or
+// CONTAINER_HOST when they name a unix socket, else the rootful socket (or,
+// for Podman run by another user, that user's).
+func DefaultSocket(runtime string) string {
+	if runtime == storage.RuntimePodman {
+		if path, ok := strings.CutPrefix(os.Getenv("CONTAINER_HOST"), "unix://"); ok {
+			return path
+		}
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Geteuid() != 0 {
+			return filepath.Join(dir, "podman", "podman.sock")
+		}
+		return "/run/podman/podman.sock"
+	}
+	if path, ok := strings.CutPrefix(os.Getenv("DOCKER_HOST"), "unix://"); ok {
+		return path
+	}
+	return "/var/run/docker.sock"
+}
+
+// createConfig is the body of a container create request
+type createConfig struct {
+	Image      string            `json:"Image"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	User       string            `json:"User,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels"`
+	HostConfig hostConfig        `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	NetworkMode   string        `json:"NetworkMode"`
+	RestartPolicy restartPolicy `json:"RestartPolicy"`
+}
+
+type restartPolicy struct {
+	Name string `json:"Name"` // "no" or "unless-stopped"
+}
+
+// containerInfo is the subset of a container inspection Runtime reads
+type containerInfo struct {
+	ID    string `json:"Id"`
+	State struct {
+		Status    string    `json:"Status"` // created, running, paused, restarting, exited, dead
+		Running   bool      `json:"Running"`
+		Pid       int       `json:"Pid"`
+		ExitCode  int       `json:"ExitCode"`
+		StartedAt time.Time `json:"StartedAt"`
+		Error     string    `json:"Error"`
+	} `json:"State"`
+	Config struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+	HostConfig hostConfig `json:"HostConfig"`
+}
+
+// statsSample is the subset of a container stats reading Runtime reads
+type statsSample struct {
+	CPUStats    cpuStats `json:"cpu_stats"`
+	PreCPUStats cpuStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64 `json:"usage"`
+	} `json:"memory_stats"`
+}
+
+type cpuStats struct {
+	CPUUsage struct {
+		TotalUsage uint64 `json:"total_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  int    `json:"online_cpus"`
+}
+
+// progressMessage is one line of an image pull's progress stream
+type progressMessage struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// Ping checks that the engine answers
+func (e *Engine) Ping(ctx context.Context) error {
+	return e.call(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// Pull pulls an image, reading the progress stream to its end
+func (e *Engine) Pull(ctx context.Context, image string) error {
+	resp, err := e.do(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg progressMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: pull %s: %w", ErrEngine, image, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%w: pull %s: %s", ErrEngine, image, msg.Error)
+		}
+	}
+}
+
+// Create creates a stopped container
+func (e *Engine) Create(ctx context.Context, name string, config *createConfig) error {
+	return e.call(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, config, nil)
+}
+
+// Remove removes a container, stopping it first; removing a missing one is not an error
+func (e *Engine) Remove(ctx context.Context, name string) error {
+	err := e.call(ctx, http.MethodDelete, "/containers/"+url.PathEscape(name), url.Values{"force": {"1"}}, nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Action starts, stops, or restarts a container
+func (e *Engine) Action(ctx context.Context, name, action string) error {
+	return e.call(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/"+action, nil, nil, nil)
+}
+
+// SetRestartPolicy changes a container's restart policy
+func (e *Engine) SetRestartPolicy(ctx context.Context, name, policy string) error {
+	body := map[string]restartPolicy{"RestartPolicy": {Name: policy}}
+	return e.call(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/update", nil, body, nil)
+}
+
+// Inspect reads a container's state; it returns an error matching
+// isNotFound when there is no such container
+func (e *Engine) Inspect(ctx context.Context, name string) (*containerInfo, error) {
+	var info containerInfo
+	if err := e.call(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Stats takes one stats reading of a container. The engine waits for a
+// second sample, so the reading carries the CPU used in between.
+func (e *Engine) Stats(ctx context.Context, name string) (*statsSample, error) {
+	var sample statsSample
+	if err := e.call(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/stats", url.Values{"stream": {"false"}}, nil, &sample); err != nil {
+		return nil, err
+	}
+	return &sample, nil
+}
+
+// Logs opens a container's log stream; the caller closes it. Containers run
+// without a TTY, so the stream is multiplexed (see readLogs).
+func (e *Engine) Logs(ctx context.Context, name string, query url.Values) (io.ReadCloser, error) {
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	query.Set("timestamps", "1")
+	resp, err := e.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// call makes a request and decodes its JSON response into out
+func (e *Engine) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := e.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %s: invalid response: %w", ErrEngine, e.name, err)
+	}
+	return nil
+}
+
+// do sends a request, returning the response of a successful one (including
+// 304, which start and stop answer when there is nothing to do). The caller
+// closes its body.
+func (e *Engine) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := "http://" + e.name + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrEngine, e.name, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s at %s: %w", ErrEngine, e.name, e.socket, err)
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+		var answer struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		if json.Unmarshal(data, &answer) != nil || answer.Message == "" {
+			answer.Message = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("%w: %s: %w", ErrEngine, e.name, &engineError{status: resp.StatusCode, message: answer.Message})
+	}
+	return resp, nil
+}
+
+// engineError is an error answer from the engine
+type engineError struct {
+	status  int
+	message string
+}
+
+func (e *engineError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.message, e.status)
+}
+
+// isNotFound reports whether err is the engine answering that a container does not exist
+func isNotFound(err error) bool {
+	var e *engineError
+	return errors.As(err, &e) && e.status == http.StatusNotFound
+}
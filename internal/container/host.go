@@ -0,0 +1,63 @@
+package container
+
+import (
+	"context"
+	"log/slog"
+
+	"servio/internal/monitor"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// HostOptions choose what runs a host's services
+type HostOptions struct {
+	Mock          bool   // simulate systemd in memory
+	Supervise     bool   // run services as child processes of Servio
+	Init          string // auto, systemd, openrc, or runit
+	SupervisorDir string // state of supervised services
+}
+
+// Host is the service manager of the machine Servio runs on
+type Host struct {
+	Manager   systemd.ServiceManager
+	Units     *systemd.UnitWatcher    // follows systemd units over D-Bus; nil under other init systems
+	Processes *systemd.ProcessManager // nil unless Servio supervises services itself
+}
+
+// NewHost picks the service manager: an in-memory mock, a supervisor of
+// child processes, OpenRC or runit, or systemd, with services that run as
+// containers sent to Docker or Podman. Supervised services that are enabled
+// are started.
+func NewHost(store storage.Store, m *systemd.Manager, secrets systemd.SecretResolver, opts HostOptions) (*Host, error) {
+	route := func(runtime systemd.ServiceRuntime) systemd.ServiceManager {
+		return NewRouter(store, runtime,
+			NewRuntime(storage.RuntimeDocker, secrets),
+			NewRuntime(storage.RuntimePodman, secrets))
+	}
+
+	if opts.Mock {
+		mock := systemd.NewMockManager(m)
+		monitor.SetProcessSource(mock.Process)
+		slog.Warn("Mock mode: systemd is simulated in memory and units are lost on restart")
+		return &Host{Manager: mock}, nil
+	}
+	if opts.Supervise {
+		processes, err := systemd.NewProcessManager(m, opts.SupervisorDir)
+		if err != nil {
+			return nil, err
+		}
+		monitor.SetProcessSource(processes.Process)
+		processes.StartEnabled(context.Background())
+		slog.Warn("Supervisor mode: services run as child processes of Servio, not systemd units", "dir", opts.SupervisorDir)
+		return &Host{Manager: route(processes), Processes: processes}, nil
+	}
+	if native := systemd.NewInitManager(opts.Init, m); native != nil {
+		slog.Info("Running services under "+native.InitName(), "logs", systemd.LogFileDir)
+		return &Host{Manager: route(native)}, nil
+	}
+
+	// Follow unit states over D-Bus instead of asking systemctl each time
+	units := systemd.NewUnitWatcher("servio-")
+	m.SetUnitWatcher(units)
+	return &Host{Manager: route(m), Units: units}, nil
+}
@@ -0,0 +1,257 @@
+package container
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"servio/internal/monitor"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// Router is the ServiceManager of one host's services: operations on a unit
+// go to the runtime its service runs under, and units without a service
+// (or with an unknown runtime) to systemd.
+type Router struct {
+	store    storage.Store
+	systemd  systemd.ServiceRuntime
+	runtimes map[string]systemd.ServiceRuntime
+}
+
+// NewRouter creates a Router over the given runtimes; the one named
+// storage.RuntimeSystemd is the default
+func NewRouter(store storage.Store, runtimes ...systemd.ServiceRuntime) *Router {
+	r := &Router{store: store, runtimes: make(map[string]systemd.ServiceRuntime, len(runtimes))}
+	for _, runtime := range runtimes {
+		r.runtimes[runtime.Name()] = runtime
+		if runtime.Name() == storage.RuntimeSystemd {
+			r.systemd = runtime
+		}
+	}
+	return r
+}
+
+// SetBlueprints passes the blueprint registry to systemd; blueprints do not
+// apply to containers
+func (r *Router) SetBlueprints(blueprints systemd.BlueprintProvider) {
+	if mgr, ok := r.systemd.(interface {
+		SetBlueprints(systemd.BlueprintProvider)
+	}); ok {
+		mgr.SetBlueprints(blueprints)
+	}
+}
+
+// named returns the runtime with the given name, or systemd
+func (r *Router) named(name string) systemd.ServiceRuntime {
+	if runtime, ok := r.runtimes[name]; ok {
+		return runtime
+	}
+	return r.systemd
+}
+
+// forUnit returns the runtime a unit's service runs under
+func (r *Router) forUnit(ctx context.Context, serviceName string) (systemd.ServiceRuntime, error) {
+	name, err := r.store.UnitRuntime(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return r.named(name), nil
+}
+
+// Start starts a unit
+func (r *Router) Start(ctx context.Context, serviceName string) error {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	return runtime.Start(ctx, serviceName)
+}
+
+// Stop stops a unit
+func (r *Router) Stop(ctx context.Context, serviceName string) error {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	return runtime.Stop(ctx, serviceName)
+}
+
+// Restart restarts a unit
+func (r *Router) Restart(ctx context.Context, serviceName string) error {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	return runtime.Restart(ctx, serviceName)
+}
+
+// Enable enables a unit
+func (r *Router) Enable(ctx context.Context, serviceName string) error {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	return runtime.Enable(ctx, serviceName)
+}
+
+// Disable disables a unit
+func (r *Router) Disable(ctx context.Context, serviceName string) error {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	return runtime.Disable(ctx, serviceName)
+}
+
+// Status returns a unit's status
+func (r *Router) Status(ctx context.Context, serviceName string) (systemd.ServiceStatus, error) {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return systemd.ServiceStatus{Name: serviceName}, err
+	}
+	return runtime.Status(ctx, serviceName)
+}
+
+// ActiveState returns a unit's active state, or "" when it cannot be read
+func (r *Router) ActiveState(ctx context.Context, serviceName string) string {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return ""
+	}
+	return runtime.ActiveState(ctx, serviceName)
+}
+
+// Reload reloads systemd
+func (r *Router) Reload(ctx context.Context) error {
+	return r.systemd.Reload(ctx)
+}
+
+// GetStartTime returns when a unit last started
+func (r *Router) GetStartTime(ctx context.Context, serviceName string) (string, error) {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return "", err
+	}
+	return runtime.GetStartTime(ctx, serviceName)
+}
+
+// GetLogsWithTimeRange reads a unit's log
+func (r *Router) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return "", err
+	}
+	return runtime.GetLogsWithTimeRange(ctx, serviceName, since, until)
+}
+
+// GetLogEntries reads the last lines of a unit's log
+func (r *Router) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]systemd.JournalEntry, error) {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.GetLogEntries(ctx, serviceName, since, lines)
+}
+
+// StreamLogs follows a unit's log
+func (r *Router) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan systemd.JournalEntry, error) {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.StreamLogs(ctx, serviceName, cursor)
+}
+
+// StreamUnitLogs follows several units, whatever they run under, as one
+// stream. Runtimes whose logs cannot be followed are left out.
+func (r *Router) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan systemd.LogLine, error) {
+	groups := map[string][]string{}
+	for _, name := range serviceNames {
+		runtime, err := r.forUnit(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		groups[runtime.Name()] = append(groups[runtime.Name()], name)
+	}
+	if len(groups) <= 1 {
+		runtime := r.systemd
+		for name := range groups {
+			runtime = r.named(name)
+		}
+		return runtime.StreamUnitLogs(ctx, serviceNames, lines, after)
+	}
+
+	var sources []<-chan systemd.LogLine
+	for name, units := range groups {
+		source, err := r.named(name).StreamUnitLogs(ctx, units, lines, after)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to follow logs", "runtime", name, "error", err)
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return systemd.MergeLogLines(ctx, sources), nil
+}
+
+// GenerateServiceFile generates what a service's runtime installs: a unit
+// file, or the command that creates its container
+func (r *Router) GenerateServiceFile(service *storage.Service) (string, error) {
+	return r.named(service.Runtime).GenerateServiceFile(service)
+}
+
+// InstallService installs a service with its runtime
+func (r *Router) InstallService(ctx context.Context, service *storage.Service) error {
+	return r.named(service.Runtime).InstallService(ctx, service)
+}
+
+// UninstallService removes a unit
+func (r *Router) UninstallService(ctx context.Context, serviceName string) error {
+	runtime, err := r.forUnit(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	return runtime.UninstallService(ctx, serviceName)
+}
+
+// ServiceExists reports whether a unit is installed
+func (r *Router) ServiceExists(serviceName string) bool {
+	runtime, err := r.forUnit(context.Background(), serviceName)
+	if err != nil {
+		return false
+	}
+	return runtime.ServiceExists(serviceName)
+}
+
+// Ping checks systemd; container engines are checked when used
+func (r *Router) Ping(ctx context.Context) error {
+	return r.systemd.Ping(ctx)
+}
+
+// IsContainer reports whether a unit runs as a container
+func (r *Router) IsContainer(ctx context.Context, serviceName string) bool {
+	name, err := r.store.UnitRuntime(ctx, serviceName)
+	return err == nil && name != storage.RuntimeSystemd
+}
+
+// Stats reads the CPU and memory use of the given container units, which
+// the monitor package cannot see. Units of other runtimes are left out.
+func (r *Router) Stats(ctx context.Context, serviceNames []string) map[string]monitor.ServiceStat {
+	groups := map[string][]string{}
+	for _, name := range serviceNames {
+		if runtime, err := r.store.UnitRuntime(ctx, name); err == nil {
+			groups[runtime] = append(groups[runtime], name)
+		}
+	}
+	stats := map[string]monitor.ServiceStat{}
+	for name, units := range groups {
+		runtime, ok := r.runtimes[name].(*Runtime)
+		if !ok {
+			continue
+		}
+		for unit, stat := range runtime.Stats(ctx, units) {
+			stats[unit] = stat
+		}
+	}
+	return stats
+}
@@ -0,0 +1,506 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/monitor"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+const (
+	// followRetry is how long a log follow waits before reopening the stream,
+	// which the engine ends whenever the container stops
+	followRetry = 2 * time.Second
+	// stopTimeout is how long a container gets to exit before it is killed
+	stopTimeout = 10
+)
+
+// Restart policies. systemd's enabled units come back after a reboot; an
+// enabled container comes back with the engine, and after it exits.
+const (
+	policyEnabled  = "unless-stopped"
+	policyDisabled = "no"
+)
+
+// Runtime runs services as containers of one engine. It implements
+// systemd.ServiceRuntime; unit names map to containers without ".service".
+type Runtime struct {
+	engine   *Engine
+	secrets  systemd.SecretResolver
+	hostname string
+}
+
+// NewRuntime creates the runtime for docker or podman, at the engine's
+// default socket. secrets resolves ${secret:KEY} in environments; may be nil.
+func NewRuntime(name string, secrets systemd.SecretResolver) *Runtime {
+	hostname, _ := os.Hostname()
+	return &Runtime{engine: NewEngine(name, DefaultSocket(name)), secrets: secrets, hostname: hostname}
+}
+
+// Name returns the runtime's storage.Runtime* value
+func (r *Runtime) Name() string {
+	return r.engine.name
+}
+
+// run makes an engine call, recorded in the audit trail as the equivalent
+// CLI command. A dry run records the command instead.
+func (r *Runtime) run(ctx context.Context, action string, args []string, call func(context.Context) error) error {
+	command := append([]string{r.engine.name}, args...)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(command)
+		return nil
+	}
+	start := time.Now()
+	err := call(ctx)
+	audit.Log(ctx, audit.CategoryContainer, action, strings.Join(command, " "), "", err, time.Since(start))
+	return err
+}
+
+// action starts, stops, or restarts a container
+func (r *Runtime) action(ctx context.Context, serviceName, action string, args ...string) error {
+	name := containerName(serviceName)
+	return r.run(ctx, action, append(append([]string{action}, args...), name), func(ctx context.Context) error {
+		return r.engine.Action(ctx, name, action)
+	})
+}
+
+// Start starts a container
+func (r *Runtime) Start(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "start")
+}
+
+// Stop stops a container, killing it after stopTimeout seconds
+func (r *Runtime) Stop(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "stop")
+}
+
+// Restart restarts a container
+func (r *Runtime) Restart(ctx context.Context, serviceName string) error {
+	return r.action(ctx, serviceName, "restart")
+}
+
+// setPolicy changes a container's restart policy
+func (r *Runtime) setPolicy(ctx context.Context, serviceName, policy string) error {
+	name := containerName(serviceName)
+	return r.run(ctx, "update", []string{"update", "--restart", policy, name}, func(ctx context.Context) error {
+		return r.engine.SetRestartPolicy(ctx, name, policy)
+	})
+}
+
+// Enable makes the engine start the container when it starts, and restart it when it exits
+func (r *Runtime) Enable(ctx context.Context, serviceName string) error {
+	return r.setPolicy(ctx, serviceName, policyEnabled)
+}
+
+// Disable turns the container's restarts off
+func (r *Runtime) Disable(ctx context.Context, serviceName string) error {
+	return r.setPolicy(ctx, serviceName, policyDisabled)
+}
+
+// Status describes a container the way systemctl status describes a unit
+func (r *Runtime) Status(ctx context.Context, serviceName string) (systemd.ServiceStatus, error) {
+	status := systemd.ServiceStatus{Name: serviceName}
+	name := containerName(serviceName)
+	info, err := r.engine.Inspect(ctx, name)
+	if isNotFound(err) {
+		status.Output = fmt.Sprintf("○ %s - container not created\n", name)
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	status.Active = info.State.Running
+	status.Enabled = info.HostConfig.RestartPolicy.Name == policyEnabled
+	dot := "○"
+	if status.Active {
+		dot = "●"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s - %s container %.12s\n", dot, name, r.engine.name, info.ID)
+	fmt.Fprintf(&b, "     Image: %s\n", info.Config.Image)
+	fmt.Fprintf(&b, "    Status: %s", info.State.Status)
+	if info.State.Running {
+		fmt.Fprintf(&b, " since %s; PID %d", info.State.StartedAt.Local().Format("Mon 2006-01-02 15:04:05 MST"), info.State.Pid)
+	} else if !info.State.StartedAt.IsZero() {
+		fmt.Fprintf(&b, " (exit code %d)", info.State.ExitCode)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "   Restart: %s\n", info.HostConfig.RestartPolicy.Name)
+	if info.State.Error != "" {
+		fmt.Fprintf(&b, "     Error: %s\n", info.State.Error)
+	}
+	status.Output = b.String()
+	return status, nil
+}
+
+// ActiveState maps a container's state to a systemd active state, or "" when
+// the engine cannot be asked. A missing container is "inactive", like a
+// unit that is not installed.
+func (r *Runtime) ActiveState(ctx context.Context, serviceName string) string {
+	info, err := r.engine.Inspect(ctx, containerName(serviceName))
+	if isNotFound(err) {
+		return "inactive"
+	}
+	if err != nil {
+		return ""
+	}
+	switch info.State.Status {
+	case "running", "paused":
+		return "active"
+	case "restarting":
+		return "activating"
+	case "exited", "dead":
+		if info.State.ExitCode != 0 {
+			return "failed"
+		}
+	}
+	return "inactive"
+}
+
+// Reload does nothing: the engine has no configuration to reload
+func (r *Runtime) Reload(ctx context.Context) error {
+	return nil
+}
+
+// GetStartTime returns when the container last started, in local time, or
+// "" when it never has
+func (r *Runtime) GetStartTime(ctx context.Context, serviceName string) (string, error) {
+	info, err := r.engine.Inspect(ctx, containerName(serviceName))
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get start time: %w", err)
+	}
+	if info.State.StartedAt.Year() <= 1 {
+		return "", nil
+	}
+	return info.State.StartedAt.Local().Format("2006-01-02 15:04:05"), nil
+}
+
+// entries reads a container's log lines matching query
+func (r *Runtime) entries(ctx context.Context, serviceName string, query url.Values) ([]systemd.JournalEntry, error) {
+	name := containerName(serviceName)
+	body, err := r.engine.Logs(ctx, name, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs: %w", err)
+	}
+	defer body.Close()
+	entries := []systemd.JournalEntry{}
+	err = readLogs(body, name, r.hostname, func(e systemd.JournalEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs: %w", err)
+	}
+	return entries, nil
+}
+
+// GetLogsWithTimeRange returns a container's log lines between since and
+// until, formatted like journalctl -o short-iso
+func (r *Runtime) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	query := url.Values{}
+	for key, value := range map[string]string{"since": since, "until": until} {
+		if value == "" {
+			continue
+		}
+		t, err := parseSince(value)
+		if err != nil {
+			return "", err
+		}
+		query.Set(key, unixTime(t))
+	}
+	entries, err := r.entries(ctx, serviceName, query)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.Line())
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// GetLogEntries returns a container's log lines since the given time, keeping
+// only the last lines when lines is positive
+func (r *Runtime) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]systemd.JournalEntry, error) {
+	query := url.Values{}
+	if since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("since", unixTime(t))
+	}
+	if lines > 0 {
+		query.Set("tail", strconv.Itoa(lines))
+	}
+	return r.entries(ctx, serviceName, query)
+}
+
+// StreamLogs follows a container's log until ctx is done, starting with the
+// lines after cursor, or the last 10 when cursor is empty. Cursors are the
+// lines' times.
+func (r *Runtime) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan systemd.JournalEntry, error) {
+	var after time.Time
+	if cursor != "" {
+		after, _ = time.Parse(time.RFC3339Nano, cursor)
+	}
+	return r.follow(ctx, serviceName, after, 10)
+}
+
+// follow streams a container's log lines after the given time, or its last
+// tail lines when after is zero. The engine ends a follow when the container
+// stops, so it is reopened from the last line until ctx is done.
+func (r *Runtime) follow(ctx context.Context, serviceName string, after time.Time, tail int) (<-chan systemd.JournalEntry, error) {
+	name := containerName(serviceName)
+	open := func() (func(func(systemd.JournalEntry) bool) error, func() error, error) {
+		query := url.Values{"follow": {"1"}}
+		if after.IsZero() {
+			query.Set("tail", strconv.Itoa(tail))
+		} else {
+			query.Set("since", unixTime(after))
+		}
+		body, err := r.engine.Logs(ctx, name, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		read := func(fn func(systemd.JournalEntry) bool) error { return readLogs(body, name, r.hostname, fn) }
+		return read, body.Close, nil
+	}
+	read, closeBody, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan systemd.JournalEntry)
+	go func() {
+		defer close(ch)
+		for {
+			read(func(e systemd.JournalEntry) bool {
+				// since has second precision: skip what was already sent
+				if !after.IsZero() && !e.Time.After(after) {
+					return true
+				}
+				after = e.Time
+				select {
+				case ch <- e:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+			closeBody()
+			if after.IsZero() {
+				after = time.Now()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(followRetry):
+			}
+			if read, closeBody, err = open(); err != nil {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// StreamUnitLogs follows several containers as one stream ordered by time,
+// starting with the last lines of each, or with the lines after the given time
+func (r *Runtime) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan systemd.LogLine, error) {
+	var sources []<-chan systemd.LogLine
+	for _, unit := range serviceNames {
+		entries, err := r.follow(ctx, unit, after, lines)
+		if err != nil {
+			return nil, err
+		}
+		source := make(chan systemd.LogLine)
+		go func(unit string) {
+			defer close(source)
+			for e := range entries {
+				select {
+				case source <- systemd.LogLine{Unit: unit, Time: e.Time, Priority: e.Priority, Message: e.Message}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(unit)
+		sources = append(sources, source)
+	}
+	return systemd.MergeLogLines(ctx, sources), nil
+}
+
+// config builds the container a service runs as. Environment values are
+// taken as written, with their secret references.
+func (r *Runtime) config(service *storage.Service) *createConfig {
+	config := &createConfig{
+		Image:      service.Image,
+		Cmd:        strings.Fields(service.Command),
+		WorkingDir: service.WorkingDir,
+		Labels: map[string]string{
+			"servio.service":    service.Name,
+			"servio.project_id": strconv.FormatInt(service.ProjectID, 10),
+		},
+		HostConfig: hostConfig{NetworkMode: "host", RestartPolicy: restartPolicy{Name: policyDisabled}},
+	}
+	// Services default to root, which would override the image's own user
+	if service.User != "root" {
+		config.User = service.User
+	}
+	for _, line := range strings.Split(service.Environment, "\n") {
+		if line = strings.TrimSpace(line); line != "" && strings.Contains(line, "=") {
+			config.Env = append(config.Env, line)
+		}
+	}
+	return config
+}
+
+// createArgs is the CLI command equivalent to creating the container
+func createArgs(name string, config *createConfig) []string {
+	args := []string{"create", "--name", name, "--network", config.HostConfig.NetworkMode, "--restart", config.HostConfig.RestartPolicy.Name}
+	for _, key := range []string{"servio.service", "servio.project_id"} {
+		args = append(args, "--label", key+"="+config.Labels[key])
+	}
+	for _, env := range config.Env {
+		args = append(args, "--env", env)
+	}
+	if config.User != "" {
+		args = append(args, "--user", config.User)
+	}
+	if config.WorkingDir != "" {
+		args = append(args, "--workdir", config.WorkingDir)
+	}
+	return append(append(args, config.Image), config.Cmd...)
+}
+
+// GenerateServiceFile describes the container a service runs as, as the
+// command that would create it. Blueprints and systemd_raw do not apply.
+func (r *Runtime) GenerateServiceFile(service *storage.Service) (string, error) {
+	args := createArgs(containerName(service.ServiceName()), r.config(service))
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by Servio - %s container for service %s\n", r.engine.name, service.Name)
+	b.WriteString(r.engine.name)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "--") && i > 0 {
+			b.WriteString(" \\\n ")
+		}
+		b.WriteString(" " + shellQuote(arg))
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// InstallService pulls the service's image and replaces its container. As
+// with units, reinstalling keeps whether it is enabled and running; a new
+// container is created stopped and disabled.
+func (r *Runtime) InstallService(ctx context.Context, service *storage.Service) error {
+	name := containerName(service.ServiceName())
+	config := r.config(service)
+	running := false
+	if info, err := r.engine.Inspect(ctx, name); err == nil {
+		config.HostConfig.RestartPolicy = info.HostConfig.RestartPolicy
+		running = info.State.Running
+	} else if !isNotFound(err) && dryrun.FromContext(ctx) == nil {
+		return err
+	}
+	args := createArgs(name, config) // with secret references, for the audit trail
+
+	if r.secrets != nil && dryrun.FromContext(ctx) == nil {
+		for i, env := range config.Env {
+			resolved, _, err := r.secrets.Resolve(ctx, service, env)
+			if err != nil {
+				return fmt.Errorf("failed to resolve secrets: %w", err)
+			}
+			config.Env[i] = resolved
+		}
+	}
+
+	err := r.run(ctx, "pull", []string{"pull", config.Image}, func(ctx context.Context) error {
+		return r.engine.Pull(ctx, config.Image)
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.UninstallService(ctx, service.ServiceName()); err != nil {
+		return err
+	}
+	err = r.run(ctx, "create", args, func(ctx context.Context) error {
+		return r.engine.Create(ctx, name, config)
+	})
+	if err != nil || !running {
+		return err
+	}
+	return r.Start(ctx, service.ServiceName())
+}
+
+// UninstallService stops and removes a container
+func (r *Runtime) UninstallService(ctx context.Context, serviceName string) error {
+	name := containerName(serviceName)
+	return r.run(ctx, "rm", []string{"rm", "--force", name}, func(ctx context.Context) error {
+		return r.engine.Remove(ctx, name)
+	})
+}
+
+// ServiceExists reports whether the container exists
+func (r *Runtime) ServiceExists(serviceName string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := r.engine.Inspect(ctx, containerName(serviceName))
+	return err == nil
+}
+
+// Ping checks that the engine answers
+func (r *Runtime) Ping(ctx context.Context) error {
+	return r.engine.Ping(ctx)
+}
+
+// Stats reads the CPU and memory use of containers, concurrently since each
+// reading takes about a second. Containers that cannot be read are left out.
+func (r *Runtime) Stats(ctx context.Context, serviceNames []string) map[string]monitor.ServiceStat {
+	stats := make(map[string]monitor.ServiceStat, len(serviceNames))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, unit := range serviceNames {
+		wg.Add(1)
+		go func(unit string) {
+			defer wg.Done()
+			stat := monitor.ServiceStat{ActiveState: r.ActiveState(ctx, unit)}
+			if stat.ActiveState == "" {
+				return
+			}
+			if stat.ActiveState == "active" {
+				sample, err := r.engine.Stats(ctx, containerName(unit))
+				if err != nil {
+					return
+				}
+				stat.MemoryUsage = float64(sample.MemoryStats.Usage) / 1024 / 1024
+				cpuDelta := float64(sample.CPUStats.CPUUsage.TotalUsage) - float64(sample.PreCPUStats.CPUUsage.TotalUsage)
+				systemDelta := float64(sample.CPUStats.SystemUsage) - float64(sample.PreCPUStats.SystemUsage)
+				if cpuDelta > 0 && systemDelta > 0 {
+					stat.CPUUsage = cpuDelta / systemDelta * float64(max(sample.CPUStats.OnlineCPUs, 1)) * 100
+				}
+			}
+			mu.Lock()
+			stats[unit] = stat
+			mu.Unlock()
+		}(unit)
+	}
+	wg.Wait()
+	return stats
+}
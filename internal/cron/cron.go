@@ -0,0 +1,136 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/events"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// pollInterval is how often the watcher reads the jobs' latest runs
+const pollInterval = 30 * time.Second
+
+// Units generates a job's service and timer units, with secret references unresolved
+func Units(job *storage.CronJob) (service, timer string, err error) {
+	schedule, err := Parse(job.Schedule)
+	if err != nil {
+		return "", "", err
+	}
+	service, timer = systemd.GenerateCronUnits(job, schedule.Calendars())
+	return service, timer, nil
+}
+
+// Install writes a job's units and enables or disables its timer. secrets
+// resolves ${secret:NAME} in the environment; may be nil.
+func Install(ctx context.Context, job *storage.CronJob, secrets systemd.SecretResolver) error {
+	service, timer, err := Units(job)
+	if err != nil {
+		return err
+	}
+	private := false
+	if secrets != nil && dryrun.FromContext(ctx) == nil {
+		// Secrets are scoped by project, which is all the resolver reads of the service
+		resolved, substituted, err := secrets.Resolve(ctx, &storage.Service{ProjectID: job.ProjectID, Name: job.Name}, service)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		if substituted {
+			service, private = resolved, true
+		}
+	}
+	return systemd.InstallTimer(ctx, job.UnitName(), service, timer, private, job.Enabled)
+}
+
+// Remove stops and removes a job's units
+func Remove(ctx context.Context, job *storage.CronJob) error {
+	return systemd.RemoveTimer(ctx, job.UnitName())
+}
+
+// RunNow starts a run of a job without waiting for it
+func RunNow(ctx context.Context, job *storage.CronJob) error {
+	return systemd.StartTimerJob(ctx, job.UnitName())
+}
+
+// WithNextRuns fills in the next n times each job runs; disabled jobs and
+// jobs with invalid schedules get none
+func WithNextRuns(jobs []*storage.CronJob, n int) {
+	now := time.Now()
+	for _, job := range jobs {
+		if !job.Enabled {
+			continue
+		}
+		if schedule, err := Parse(job.Schedule); err == nil {
+			job.NextRuns = schedule.NextN(now, n)
+		}
+	}
+}
+
+// Watcher records the outcome of each job's runs and publishes a
+// cron.finished event for each
+type Watcher struct {
+	store  storage.Store
+	events *events.Bus
+}
+
+// NewWatcher creates a Watcher. Call Run to start watching.
+func NewWatcher(store storage.Store, bus *events.Bus) *Watcher {
+	return &Watcher{store: store, events: bus}
+}
+
+// Run polls the jobs' latest runs until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll records every job's latest run that is newer than the one stored.
+// Runs that finished between two polls are only seen if they were the last.
+func (w *Watcher) poll(ctx context.Context) {
+	jobs, err := w.store.ListCronJobs(ctx, 0)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list cron jobs", "error", err)
+		return
+	}
+	for _, job := range jobs {
+		run, err := systemd.LastTimerRun(ctx, job.UnitName())
+		if err != nil {
+			slog.DebugContext(ctx, "Failed to read cron job run", "job", job.Name, "error", err)
+			continue
+		}
+		if run == nil || (job.LastRunAt != nil && !run.StartedAt.After(*job.LastRunAt)) {
+			continue
+		}
+		if err := w.store.RecordCronRun(ctx, job.ID, run); err != nil {
+			slog.WarnContext(ctx, "Failed to record cron job run", "job", job.Name, "error", err)
+			continue
+		}
+		if run.Status == storage.CronFailed {
+			slog.WarnContext(ctx, "Cron job failed", "job", job.Name, "project_id", job.ProjectID, "exit_code", run.ExitCode)
+		}
+		w.events.Publish(events.Event{
+			Type:      events.CronFinished,
+			ProjectID: job.ProjectID,
+			Data: map[string]interface{}{
+				"cron_job_id": job.ID,
+				"name":        job.Name,
+				"status":      run.Status,
+				"exit_code":   run.ExitCode,
+				"duration_ms": run.Duration.Milliseconds(),
+				"started_at":  run.StartedAt,
+			},
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Package cron runs the scheduled commands of projects. Schedules are
+// written in crontab syntax and installed as systemd timers, each starting a
+// oneshot unit; a Watcher records how each run ended.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule is wrapped by errors for malformed schedules
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// maxSearch bounds how far ahead Next looks; a schedule such as
+// "0 0 30 2 *" never fires
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// macros are the crontab shorthands
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is one of a schedule's five fields
+type field struct {
+	name     string
+	min, max int
+	names    []string // month or weekday names, from min
+}
+
+var fields = [5]field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// weekdays are systemd's weekday names, from Sunday
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// Schedule is a parsed crontab schedule. Times are in the server's local
+// time zone, as systemd timers are.
+type Schedule struct {
+	minutes, hours, days, months, weekdays []bool
+	// anyDay and anyWeekday record a day of month or week starting with "*":
+	// as in cron, a schedule restricting both fires on days matching either
+	anyDay, anyWeekday bool
+}
+
+// Parse reads a crontab schedule: five fields (minute, hour, day of month,
+// month, day of week) of *, numbers, ranges, lists, and /steps, with month
+// and weekday names, or one of @hourly, @daily, @weekly, @monthly, @yearly
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%w: %q must have 5 fields (minute hour day month weekday) or be @hourly, @daily, @weekly, @monthly, or @yearly", ErrInvalidSchedule, expr)
+	}
+
+	var sets [5][]bool
+	for i, part := range parts {
+		set, err := fields[i].parse(part)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	// 7 is Sunday too
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &Schedule{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4][:7],
+		anyDay:     strings.HasPrefix(parts[2], "*"),
+		anyWeekday: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parse reads one field into the set of values it matches
+func (f field) parse(s string) ([]bool, error) {
+	set := make([]bool, f.max+1)
+	for _, item := range strings.Split(s, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%w: %s step %q must be a positive number", ErrInvalidSchedule, f.name, stepText)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if f.name == "day of week" {
+			hi = 6 // "*" is every day once
+		}
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(first); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(last); err != nil {
+					return nil, err
+				}
+				if hi < lo {
+					return nil, fmt.Errorf("%w: %s range %q is backwards", ErrInvalidSchedule, f.name, rng)
+				}
+			} else if hasStep {
+				hi = f.max // "5/10" runs from 5 to the end
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// value reads a number or name within the field's bounds
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%w: %s %q must be between %d and %d", ErrInvalidSchedule, f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// matchesDay reports whether the schedule fires on t's day
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day, weekday := s.days[t.Day()], s.weekdays[t.Weekday()]
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Next returns the first time after t the schedule fires, or the zero time
+// when it never does
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		if !s.months[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// NextN returns up to n times after t the schedule fires
+func (s *Schedule) NextN(t time.Time, n int) []time.Time {
+	var times []time.Time
+	for len(times) < n {
+		if t = s.Next(t); t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Calendars returns the schedule as systemd OnCalendar expressions. A
+// schedule restricting both the day of month and of week needs two, since
+// systemd requires both to match where cron requires either.
+func (s *Schedule) Calendars() []string {
+	clock := " " + list(s.hours, 0, nil) + ":" + list(s.minutes, 0, nil) + ":00"
+	date := "*-" + list(s.months, 1, nil) + "-"
+	byDay := date + list(s.days, 1, nil) + clock
+	byWeekday := date + "*" + clock
+	if days := list(s.weekdays, 0, weekdays); days != "*" {
+		byWeekday = days + " " + byWeekday
+		if s.anyDay || s.anyWeekday {
+			return []string{days + " " + byDay}
+		}
+		return []string{byWeekday, byDay}
+	}
+	return []string{byDay}
+}
+
+// list formats the values of a set from min as "*" or a comma list,
+// using names when given
+func list(set []bool, min int, names []string) string {
+	var values []string
+	all := true
+	for v := min; v < len(set); v++ {
+		if !set[v] {
+			all = false
+			continue
+		}
+		if names != nil {
+			values = append(values, names[v-min])
+		} else {
+			values = append(values, fmt.Sprintf("%02d", v))
+		}
+	}
+	if all {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
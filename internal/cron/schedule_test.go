@@ -0,0 +1,96 @@
+package cron
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr      string
+		calendars []string
+	}{
+		{"* * * * *", []string{"*-*-* *:*:00"}},
+		{"@daily", []string{"*-*-* 00:00:00"}},
+		{"@HOURLY", []string{"*-*-* *:00:00"}},
+		{"@weekly", []string{"Sun *-*-* 00:00:00"}},
+		{"30 2 * * *", []string{"*-*-* 02:30:00"}},
+		{"*/15 9-17 * * mon-fri", []string{"Mon,Tue,Wed,Thu,Fri *-*-* 09,10,11,12,13,14,15,16,17:00,15,30,45:00"}},
+		{"0 0 1,15 * *", []string{"*-*-01,15 00:00:00"}},
+		{"0 12 * jan,JUL *", []string{"*-01,07-* 12:00:00"}},
+		{"5/20 * * * *", []string{"*-*-* *:05,25,45:00"}},
+		{"0 0 * * 7", []string{"Sun *-*-* 00:00:00"}}, // 7 is Sunday too
+		{"0 0 * * 0-7", []string{"*-*-* 00:00:00"}},
+		// Restricting both days fires on either, which systemd needs two calendars for
+		{"0 6 13 * fri", []string{"Fri *-*-* 06:00:00", "*-*-13 06:00:00"}},
+		{"0 6 */2 * fri", []string{"Fri *-*-01,03,05,07,09,11,13,15,17,19,21,23,25,27,29,31 06:00:00"}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.expr, err)
+			continue
+		}
+		if got := s.Calendars(); !reflect.DeepEqual(got, tt.calendars) {
+			t.Errorf("Parse(%q).Calendars() = %q, want %q", tt.expr, got, tt.calendars)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"", "must have 5 fields"},
+		{"* * * *", "must have 5 fields"},
+		{"* * * * * *", "must have 5 fields"},
+		{"@reboot", "must have 5 fields"},
+		{"60 * * * *", `minute "60" must be between 0 and 59`},
+		{"* 24 * * *", `hour "24" must be between 0 and 23`},
+		{"* * 0 * *", `day of month "0" must be between 1 and 31`},
+		{"* * * 13 *", `month "13" must be between 1 and 12`},
+		{"* * * * 8", `day of week "8" must be between 0 and 7`},
+		{"* * * foo *", `month "foo"`},
+		{"*/0 * * * *", `minute step "0" must be a positive number`},
+		{"*/x * * * *", `minute step "x"`},
+		{"30-10 * * * *", `minute range "30-10" is backwards`},
+		{"1-2-3 * * * *", `minute "2-3"`},
+		{"1,,2 * * * *", `minute ""`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if !errors.Is(err, ErrInvalidSchedule) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidSchedule with %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 1, 30, 10, 7, 30, 0, time.UTC) // a Friday
+	tests := []struct {
+		expr string
+		want string // zero time when it never fires
+	}{
+		{"* * * * *", "2026-01-30 10:08"},
+		{"*/15 * * * *", "2026-01-30 10:15"},
+		{"0 9 * * *", "2026-01-31 09:00"},
+		{"0 0 1 * *", "2026-02-01 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		{"0 0 * * mon", "2026-02-02 00:00"},
+		{"0 12 1 * mon", "2026-02-01 12:00"}, // the 1st, or the Monday after, whichever comes first
+		{"0 0 30 2 *", "0001-01-01 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("Parse(%q).Next() = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Package dbus is a minimal D-Bus client, enough to call methods on the
+// system bus and receive signals from it: Servio uses it to follow systemd's
+// unit state changes rather than polling systemctl. It authenticates with
+// EXTERNAL over a unix socket and sends little-endian messages.
+package dbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSystemBus is the system bus address used when DBUS_SYSTEM_BUS_ADDRESS is unset
+const DefaultSystemBus = "unix:path=/run/dbus/system_bus_socket"
+
+// ErrClosed is returned for calls on a closed connection and pending calls when it closes
+var ErrClosed = errors.New("D-Bus connection closed")
+
+// Conn is a connection to a message bus. It is safe for concurrent use.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writeMu  sync.Mutex
+	serial   atomic.Uint32
+	name     string
+	messages chan *Message
+	done     chan struct{} // closed by Close
+
+	mu      sync.Mutex
+	pending map[uint32]chan *Message
+	closed  bool
+}
+
+// SystemBus connects to the system bus
+func SystemBus(ctx context.Context) (*Conn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = DefaultSystemBus
+	}
+	return Dial(ctx, address)
+}
+
+// Dial connects to the bus at a D-Bus address such as
+// unix:path=/run/dbus/system_bus_socket, authenticates, and registers with it
+func Dial(ctx context.Context, address string) (*Conn, error) {
+	path, err := socketPath(address)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:     netConn,
+		reader:   bufio.NewReader(netConn),
+		messages: make(chan *Message, 64),
+		done:     make(chan struct{}),
+		pending:  make(map[uint32]chan *Message),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+	if err := c.authenticate(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+	go c.readLoop()
+
+	reply, err := c.Call(ctx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "")
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("D-Bus Hello: %w", err)
+	}
+	if len(reply.Body) > 0 {
+		c.name, _ = reply.Body[0].(string)
+	}
+	return c, nil
+}
+
+// socketPath returns the socket of the first unix transport in address
+func socketPath(address string) (string, error) {
+	for _, transport := range strings.Split(address, ";") {
+		kind, params, ok := strings.Cut(transport, ":")
+		if !ok || kind != "unix" {
+			continue
+		}
+		for _, param := range strings.Split(params, ",") {
+			key, value, _ := strings.Cut(param, "=")
+			value, err := url.PathUnescape(value)
+			if err != nil {
+				return "", fmt.Errorf("invalid D-Bus address %q", address)
+			}
+			switch key {
+			case "path":
+				return value, nil
+			case "abstract":
+				return "@" + value, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no unix socket in D-Bus address %q", address)
+}
+
+// authenticate runs the EXTERNAL handshake, which identifies the client by
+// the uid the bus sees on the socket
+func (c *Conn) authenticate() error {
+	uid := fmt.Sprintf("%x", strconv.Itoa(os.Getuid()))
+	if _, err := c.conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("D-Bus authentication rejected: %s", strings.TrimSpace(line))
+	}
+	_, err = c.conn.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+// Name returns the unique name the bus gave the connection
+func (c *Conn) Name() string {
+	return c.name
+}
+
+// Messages delivers the signals and method calls the connection receives.
+// It is closed when the connection is. The reader waits for it, so it must
+// be drained, and not by a goroutine waiting on a Call.
+func (c *Conn) Messages() <-chan *Message {
+	return c.messages
+}
+
+// Call calls a method and waits for its reply. Error replies are returned
+// as *Error.
+func (c *Conn) Call(ctx context.Context, destination, path, iface, member, signature string, args ...interface{}) (*Message, error) {
+	m := &Message{
+		Type: TypeMethodCall, Destination: destination, Path: path, Interface: iface, Member: member,
+		Signature: signature, Body: args,
+	}
+	reply := make(chan *Message, 1)
+	serial, err := c.send(m, reply)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, serial)
+		c.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r, ok := <-reply:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if r.Type == TypeError {
+			e := &Error{Name: r.ErrorName}
+			if len(r.Body) > 0 {
+				e.Message, _ = r.Body[0].(string)
+			}
+			return nil, e
+		}
+		return r, nil
+	}
+}
+
+// Send sends a message, such as the reply to a method call, without waiting
+func (c *Conn) Send(m *Message) error {
+	_, err := c.send(m, nil)
+	return err
+}
+
+// send assigns m a serial and writes it; reply, when set, receives its reply
+func (c *Conn) send(m *Message, reply chan *Message) (uint32, error) {
+	serial := c.serial.Add(1)
+	data, err := m.marshal(serial)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, ErrClosed
+	}
+	if reply != nil {
+		c.pending[serial] = reply
+	}
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	_, err = c.conn.Write(data)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.Close()
+		return 0, err
+	}
+	return serial, nil
+}
+
+// readLoop hands replies to their calls and everything else to Messages
+// until the connection fails or is closed
+func (c *Conn) readLoop() {
+	defer close(c.messages)
+	defer c.Close()
+	for {
+		m, err := readMessage(c.reader)
+		if err != nil {
+			return
+		}
+		switch m.Type {
+		case TypeMethodReturn, TypeError:
+			c.mu.Lock()
+			reply, ok := c.pending[m.ReplySerial]
+			delete(c.pending, m.ReplySerial)
+			c.mu.Unlock()
+			if ok {
+				reply <- m
+			}
+		default:
+			select {
+			case c.messages <- m:
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection, failing pending calls. The message channel
+// closes once the reader has stopped.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	for serial, reply := range c.pending {
+		close(reply)
+		delete(c.pending, serial)
+	}
+	c.mu.Unlock()
+	return c.conn.Close()
+}
@@ -0,0 +1,598 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Message types
+const (
+	TypeMethodCall   byte = 1
+	TypeMethodReturn byte = 2
+	TypeError        byte = 3
+	TypeSignal       byte = 4
+)
+
+// FlagNoReplyExpected marks method calls and returns nobody waits on
+const FlagNoReplyExpected byte = 1
+
+// Header field codes
+const (
+	fieldPath        byte = 1
+	fieldInterface   byte = 2
+	fieldMember      byte = 3
+	fieldErrorName   byte = 4
+	fieldReplySerial byte = 5
+	fieldDestination byte = 6
+	fieldSender      byte = 7
+	fieldSignature   byte = 8
+)
+
+// maxMessage bounds the header and body a message may declare
+const maxMessage = 128 << 20
+
+// maxDepth bounds how deeply containers may nest
+const maxDepth = 32
+
+var errMalformed = errors.New("malformed D-Bus message")
+
+// Message is a D-Bus message. Body values are decoded as: y byte, b bool,
+// n int16, q uint16, i int32, u and h uint32, x int64, t uint64, d float64,
+// s o g string, v Variant, arrays []interface{}, dicts
+// map[interface{}]interface{}, and structs []interface{}.
+type Message struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        string
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// Variant is a value with its own signature
+type Variant struct {
+	Signature string
+	Value     interface{}
+}
+
+// Error is an error reply
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// marshal encodes m, little-endian, with the given serial
+func (m *Message) marshal(serial uint32) ([]byte, error) {
+	body := &encoder{}
+	types, err := splitSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if len(types) != len(m.Body) {
+		return nil, fmt.Errorf("signature %q has %d values, body has %d", m.Signature, len(types), len(m.Body))
+	}
+	for i, sig := range types {
+		if err := body.encode(sig, m.Body[i], 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []interface{}
+	add := func(code byte, sig string, value interface{}) {
+		fields = append(fields, []interface{}{code, Variant{sig, value}})
+	}
+	if m.Path != "" {
+		add(fieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		add(fieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		add(fieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		add(fieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		add(fieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		add(fieldDestination, "s", m.Destination)
+	}
+	if m.Signature != "" {
+		add(fieldSignature, "g", m.Signature)
+	}
+
+	header := &encoder{}
+	header.buf = append(header.buf, 'l', m.Type, m.Flags, 1)
+	header.encode("u", uint32(len(body.buf)), 0)
+	header.encode("u", serial, 0)
+	if err := header.encode("a(yv)", fields, 0); err != nil {
+		return nil, err
+	}
+	header.align(8)
+	return append(header.buf, body.buf...), nil
+}
+
+// readMessage reads one message
+func readMessage(r io.Reader) (*Message, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, errMalformed
+	}
+	bodyLen := order.Uint32(fixed[4:])
+	fieldsLen := order.Uint32(fixed[12:])
+	if bodyLen > maxMessage || fieldsLen > maxMessage {
+		return nil, errMalformed
+	}
+	headerLen := (16 + int(fieldsLen) + 7) &^ 7
+	buf := make([]byte, headerLen+int(bodyLen))
+	copy(buf, fixed)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &Message{Type: fixed[1], Flags: fixed[2], Serial: order.Uint32(fixed[8:])}
+	header := &decoder{buf: buf[:16+int(fieldsLen)], pos: 12, order: order}
+	fields, err := header.decode("a(yv)", 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields.([]interface{}) {
+		field := f.([]interface{})
+		value := field[1].(Variant).Value
+		switch field[0].(byte) {
+		case fieldPath:
+			m.Path, _ = value.(string)
+		case fieldInterface:
+			m.Interface, _ = value.(string)
+		case fieldMember:
+			m.Member, _ = value.(string)
+		case fieldErrorName:
+			m.ErrorName, _ = value.(string)
+		case fieldReplySerial:
+			m.ReplySerial, _ = value.(uint32)
+		case fieldDestination:
+			m.Destination, _ = value.(string)
+		case fieldSender:
+			m.Sender, _ = value.(string)
+		case fieldSignature:
+			m.Signature, _ = value.(string)
+		}
+	}
+
+	types, err := splitSignature(m.Signature)
+	if err != nil {
+		return nil, err
+	}
+	body := &decoder{buf: buf[headerLen:], order: order}
+	for _, sig := range types {
+		value, err := body.decode(sig, 0)
+		if err != nil {
+			return nil, err
+		}
+		m.Body = append(m.Body, value)
+	}
+	return m, nil
+}
+
+// splitSignature splits a signature into its complete types
+func splitSignature(sig string) ([]string, error) {
+	var types []string
+	for sig != "" {
+		n, err := typeLen(sig, 0)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, sig[:n])
+		sig = sig[n:]
+	}
+	return types, nil
+}
+
+// typeLen returns the length of the complete type sig starts with
+func typeLen(sig string, depth int) (int, error) {
+	if sig == "" || depth > maxDepth {
+		return 0, fmt.Errorf("invalid signature %q", sig)
+	}
+	switch sig[0] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 'h', 's', 'o', 'g', 'v':
+		return 1, nil
+	case 'a':
+		n, err := typeLen(sig[1:], depth+1)
+		return n + 1, err
+	case '(', '{':
+		end := byte(')')
+		if sig[0] == '{' {
+			end = '}'
+		}
+		i := 1
+		for i < len(sig) && sig[i] != end {
+			n, err := typeLen(sig[i:], depth+1)
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+		if i >= len(sig) || i == 1 {
+			return 0, fmt.Errorf("invalid signature %q", sig)
+		}
+		return i + 1, nil
+	}
+	return 0, fmt.Errorf("invalid signature %q", sig)
+}
+
+// alignment returns the boundary values of the type sig starts with are aligned to
+func alignment(sig string) int {
+	switch sig[0] {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+// encode appends v as a value of the complete type sig
+func (e *encoder) encode(sig string, v interface{}, depth int) error {
+	if depth > maxDepth {
+		return errMalformed
+	}
+	mismatch := fmt.Errorf("cannot encode %T as %q", v, sig)
+	switch sig[0] {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return mismatch
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch
+		}
+		n := uint32(0)
+		if b {
+			n = 1
+		}
+		e.uint32(n)
+	case 'n', 'q':
+		var n uint16
+		switch x := v.(type) {
+		case int16:
+			n = uint16(x)
+		case uint16:
+			n = x
+		default:
+			return mismatch
+		}
+		e.align(2)
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, n)
+	case 'i', 'u', 'h':
+		var n uint32
+		switch x := v.(type) {
+		case int32:
+			n = uint32(x)
+		case uint32:
+			n = x
+		default:
+			return mismatch
+		}
+		e.uint32(n)
+	case 'x', 't', 'd':
+		var n uint64
+		switch x := v.(type) {
+		case int64:
+			n = uint64(x)
+		case uint64:
+			n = x
+		case float64:
+			n = math.Float64bits(x)
+		default:
+			return mismatch
+		}
+		e.align(8)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, n)
+	case 's', 'o':
+		s, ok := v.(string)
+		if !ok {
+			return mismatch
+		}
+		e.uint32(uint32(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'g':
+		s, ok := v.(string)
+		if !ok || len(s) > 255 {
+			return mismatch
+		}
+		e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+	case 'v':
+		variant, ok := v.(Variant)
+		if !ok {
+			return mismatch
+		}
+		if n, err := typeLen(variant.Signature, depth+1); err != nil || n != len(variant.Signature) {
+			return fmt.Errorf("invalid variant signature %q", variant.Signature)
+		}
+		e.encode("g", variant.Signature, depth+1)
+		return e.encode(variant.Signature, variant.Value, depth+1)
+	case 'a':
+		return e.encodeArray(sig[1:], v, depth)
+	case '(':
+		fields, ok := v.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		types, err := splitSignature(sig[1 : len(sig)-1])
+		if err != nil || len(types) != len(fields) {
+			return mismatch
+		}
+		e.align(8)
+		for i, t := range types {
+			if err := e.encode(t, fields[i], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// encodeArray appends an array of elem values: a []interface{} or
+// []string, or for dicts with string keys a map[string]interface{}
+func (e *encoder) encodeArray(elem string, v interface{}, depth int) error {
+	e.uint32(0)
+	lenAt := len(e.buf) - 4
+	e.align(alignment(elem))
+	start := len(e.buf)
+
+	switch x := v.(type) {
+	case []interface{}:
+		for _, item := range x {
+			if err := e.encode(elem, item, depth+1); err != nil {
+				return err
+			}
+		}
+	case []string:
+		for _, item := range x {
+			if err := e.encode(elem, item, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if elem[0] != '{' || elem[1] != 's' {
+			return fmt.Errorf("cannot encode %T as %q", v, "a"+elem)
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.align(8)
+			e.encode("s", k, depth+1)
+			if err := e.encode(elem[2:len(elem)-1], x[k], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %T as %q", v, "a"+elem)
+	}
+	binary.LittleEndian.PutUint32(e.buf[lenAt:], uint32(len(e.buf)-start))
+	return nil
+}
+
+type decoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *decoder) align(n int) {
+	d.pos = (d.pos + n - 1) &^ (n - 1)
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, errMalformed
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	d.align(4)
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+// decode reads a value of the complete type sig
+func (d *decoder) decode(sig string, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errMalformed
+	}
+	switch sig[0] {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		n, err := d.uint32()
+		return n != 0, err
+	case 'n', 'q':
+		d.align(2)
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		n, err := d.uint32()
+		return int32(n), err
+	case 'u', 'h':
+		return d.uint32()
+	case 'x', 't', 'd':
+		d.align(8)
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		n := d.order.Uint64(b)
+		switch sig[0] {
+		case 'x':
+			return int64(n), nil
+		case 'd':
+			return math.Float64frombits(n), nil
+		}
+		return n, nil
+	case 's', 'o':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case 'g':
+		n, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n[0]]), nil
+	case 'v':
+		s, err := d.decode("g", depth+1)
+		if err != nil {
+			return nil, err
+		}
+		inner := s.(string)
+		if n, err := typeLen(inner, depth+1); err != nil || n != len(inner) {
+			return nil, errMalformed
+		}
+		value, err := d.decode(inner, depth+1)
+		return Variant{Signature: inner, Value: value}, err
+	case 'a':
+		return d.decodeArray(sig[1:], depth)
+	case '(':
+		types, err := splitSignature(sig[1 : len(sig)-1])
+		if err != nil {
+			return nil, err
+		}
+		d.align(8)
+		fields := make([]interface{}, 0, len(types))
+		for _, t := range types {
+			value, err := d.decode(t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, value)
+		}
+		return fields, nil
+	}
+	return nil, errMalformed
+}
+
+func (d *decoder) decodeArray(elem string, depth int) (interface{}, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxMessage {
+		return nil, errMalformed
+	}
+	d.align(alignment(elem))
+	end := d.pos + int(n)
+	if end > len(d.buf) {
+		return nil, errMalformed
+	}
+
+	if elem[0] == '{' {
+		types, err := splitSignature(elem[1 : len(elem)-1])
+		// Keys must be basic types, which are also the hashable ones
+		if err != nil || len(types) != 2 || len(types[0]) != 1 || types[0] == "v" {
+			return nil, errMalformed
+		}
+		dict := make(map[interface{}]interface{})
+		for d.pos < end {
+			d.align(8)
+			key, err := d.decode(types[0], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decode(types[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+		}
+		return dict, nil
+	}
+
+	items := []interface{}{}
+	for d.pos < end {
+		value, err := d.decode(elem, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
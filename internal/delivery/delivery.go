@@ -0,0 +1,197 @@
+// Package delivery posts events from the events bus to outgoing HTTP
+// endpoints. A Dispatcher queues events for a route function, which records
+// a pending delivery for every endpoint that wants the event and passes it
+// to Go; each delivery is then posted, retried with exponential backoff, and
+// saved after every attempt. internal/webhooks and internal/notify decide
+// who gets an event and what they are sent.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"servio/internal/events"
+	"servio/internal/storage"
+)
+
+const (
+	// MaxAttempts is how many times an event's delivery is tried before it is marked failed
+	MaxAttempts = 5
+	// retryBase is the delay before the first retry; it doubles after each attempt
+	retryBase = 2 * time.Second
+	// maxRetryAfter caps how long a Retry-After header may delay the next attempt
+	maxRetryAfter = time.Minute
+	// attemptTimeout bounds a single HTTP request
+	attemptTimeout = 10 * time.Second
+	// maxConcurrent bounds how many deliveries are in flight at once
+	maxConcurrent = 4
+	// queueSize bounds how many events may wait to be dispatched
+	queueSize = 256
+	// maxErrorBody is how much of a failed response body is kept in the delivery error
+	maxErrorBody = 512
+)
+
+// Delivery is one request to post, and where to keep its outcome
+type Delivery struct {
+	URL    string // may hold credentials, so errors never include it
+	Body   []byte // JSON
+	Header http.Header
+	State  *storage.DeliveryState
+	Save   func(ctx context.Context) error // stores State
+	Log    []any                           // attributes identifying the delivery in logs
+}
+
+// Dispatcher queues events and sends the deliveries its route function makes
+type Dispatcher struct {
+	kind      string // what is delivered, for logs
+	userAgent string
+	route     func(ctx context.Context, e events.Event)
+	client    *http.Client
+	queue     chan events.Event
+	sem       chan struct{}
+}
+
+// NewDispatcher creates a Dispatcher passing events to route, which calls Go
+// for each delivery. Call Run to start delivering.
+func NewDispatcher(kind, userAgent string, route func(ctx context.Context, e events.Event)) *Dispatcher {
+	return &Dispatcher{
+		kind:      kind,
+		userAgent: userAgent,
+		route:     route,
+		client:    &http.Client{Timeout: attemptTimeout},
+		queue:     make(chan events.Event, queueSize),
+		sem:       make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Handle queues an event for delivery. It never blocks; when the queue is
+// full the event is dropped with a warning. Subscribe it to an events.Bus.
+func (d *Dispatcher) Handle(e events.Event) {
+	select {
+	case d.queue <- e:
+	default:
+		slog.Warn("Delivery queue full, dropping event", "kind", d.kind, "event", e.Type)
+	}
+}
+
+// Run routes queued events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-d.queue:
+			d.route(ctx, e)
+		}
+	}
+}
+
+// Go sends a delivery in the background with MaxAttempts, a few at a time
+func (d *Dispatcher) Go(ctx context.Context, delivery *Delivery) {
+	go func() {
+		d.sem <- struct{}{}
+		defer func() { <-d.sem }()
+		d.Send(ctx, delivery, MaxAttempts)
+	}()
+}
+
+// Send posts a delivery until it succeeds, a non-retryable response is
+// received, or attempts run out, saving its state after every attempt
+func (d *Dispatcher) Send(ctx context.Context, delivery *Delivery, attempts int) {
+	state := delivery.State
+	delay := retryBase
+	for {
+		state.Attempts++
+		status, retryAfter, retry, err := d.attempt(ctx, delivery)
+		state.ResponseStatus = status
+
+		if err == nil {
+			now := time.Now()
+			state.Status = storage.DeliverySucceeded
+			state.Error = ""
+			state.DeliveredAt = &now
+			d.save(ctx, delivery)
+			return
+		}
+
+		state.Error = err.Error()
+		if !retry || state.Attempts >= attempts {
+			state.Status = storage.DeliveryFailed
+			d.save(ctx, delivery)
+			slog.WarnContext(ctx, "Delivery failed", append([]any{"kind", d.kind, "attempts", state.Attempts, "error", err}, delivery.Log...)...)
+			return
+		}
+		d.save(ctx, delivery)
+
+		wait := max(delay, min(retryAfter, maxRetryAfter))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// Fail marks a delivery that cannot be sent as failed with err and saves it
+func (d *Dispatcher) Fail(ctx context.Context, delivery *Delivery, err error) {
+	delivery.State.Status = storage.DeliveryFailed
+	delivery.State.Error = err.Error()
+	d.save(ctx, delivery)
+}
+
+// attempt makes one request. It reports the response status, how long the
+// server asked to wait before retrying, whether a failure is worth retrying,
+// and the failure itself.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) (int, time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, 0, false, errors.New("invalid URL")
+	}
+	for key, values := range delivery.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", d.userAgent)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return 0, 0, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, 0, false, nil
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	err = fmt.Errorf("endpoint responded %s", resp.Status)
+	if len(bytes.TrimSpace(snippet)) > 0 {
+		err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(snippet))
+	}
+	return resp.StatusCode, retryAfter, retry, err
+}
+
+func (d *Dispatcher) save(ctx context.Context, delivery *Delivery) {
+	if err := delivery.Save(context.WithoutCancel(ctx)); err != nil {
+		slog.ErrorContext(ctx, "Failed to save delivery", append([]any{"kind", d.kind, "error", err}, delivery.Log...)...)
+	}
+}
@@ -0,0 +1,322 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/agent"
+	"servio/internal/blueprints"
+	"servio/internal/dryrun"
+	"servio/internal/events"
+	"servio/internal/git"
+	"servio/internal/jobs"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// ErrDeployInProgress is returned when a service already has an unfinished deployment
+var ErrDeployInProgress = errors.New("a deployment is already in progress for this service")
+
+// Deployer runs the deploy pipeline for a service: fetch the repository,
+// build its static files, reinstall the unit file, and restart the service.
+// Every run is recorded as a storage.Deployment and executed as a deploy job.
+type Deployer struct {
+	store      storage.Store
+	svcManager systemd.ServiceManager
+	jobs       *jobs.Runner
+	events     *events.Bus
+	env        EnvSyncer    // writes managed .env files; nil skips them
+	assets     AssetBuilder // builds static files; nil skips them
+	mu         sync.Mutex   // serializes the in-progress check with creating the record
+
+	subMu       sync.Mutex
+	subscribers map[int64]map[chan Event]struct{} // keyed by service ID
+}
+
+// EnvSyncer writes a service's managed .env file, returning its path or ""
+// when the service has none (see envfile.Manager)
+type EnvSyncer interface {
+	Sync(ctx context.Context, service *storage.Service) (string, error)
+}
+
+// AssetBuilder builds a service's static files after its checkout, passing
+// the commands it runs and their output to out (see blueprints.Registry)
+type AssetBuilder interface {
+	BuildAssets(ctx context.Context, service *storage.Service, out blueprints.Output) error
+}
+
+// Event is a progress update from a deployment: a status change or a log line
+type Event struct {
+	DeploymentID int64  `json:"deployment_id"`
+	ServiceID    int64  `json:"service_id"`
+	Status       string `json:"status"`
+	Line         string `json:"line,omitempty"`
+}
+
+// NewDeployer creates a new Deployer
+func NewDeployer(store storage.Store, svcManager systemd.ServiceManager, runner *jobs.Runner, bus *events.Bus) *Deployer {
+	return &Deployer{store: store, svcManager: svcManager, jobs: runner, events: bus, subscribers: make(map[int64]map[chan Event]struct{})}
+}
+
+// SetEnvSyncer sets what writes services' .env files before their units are installed
+func (d *Deployer) SetEnvSyncer(env EnvSyncer) {
+	d.env = env
+}
+
+// SetAssetBuilder sets what builds services' static files before their units are installed
+func (d *Deployer) SetAssetBuilder(assets AssetBuilder) {
+	d.assets = assets
+}
+
+// Subscribe streams events for deployments of a service until cancel is called.
+// Slow subscribers miss events rather than stalling the pipeline; the full log
+// is always available from the stored deployment.
+func (d *Deployer) Subscribe(serviceID int64) (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	d.subMu.Lock()
+	if d.subscribers[serviceID] == nil {
+		d.subscribers[serviceID] = make(map[chan Event]struct{})
+	}
+	d.subscribers[serviceID][ch] = struct{}{}
+	d.subMu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			d.subMu.Lock()
+			delete(d.subscribers[serviceID], ch)
+			if len(d.subscribers[serviceID]) == 0 {
+				delete(d.subscribers, serviceID)
+			}
+			d.subMu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// publish delivers an event to the service's subscribers without blocking
+func (d *Deployer) publish(deployment *storage.Deployment, line string) {
+	event := Event{DeploymentID: deployment.ID, ServiceID: deployment.ServiceID, Status: deployment.Status, Line: line}
+
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+	for ch := range d.subscribers[deployment.ServiceID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Start records a pending deployment and queues the pipeline as a deploy job.
+// The returned deployment can be polled until it reaches a final status.
+func (d *Deployer) Start(ctx context.Context, service *storage.Service) (*storage.Deployment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.checkIdle(ctx, service.ID); err != nil {
+		return nil, err
+	}
+	if err := d.checkFetch(ctx, service); err != nil {
+		return nil, err
+	}
+
+	deployment := &storage.Deployment{ServiceID: service.ID}
+	if err := d.store.CreateDeployment(ctx, deployment); err != nil {
+		return nil, err
+	}
+	d.publish(deployment, "")
+	queued := *deployment
+
+	job, err := d.jobs.Enqueue(ctx, storage.Job{Kind: jobs.KindDeploy, ProjectID: service.ProjectID, ServiceID: service.ID},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			deployment.JobID = job.ID
+			return d.run(ctx, service, deployment, logf)
+		})
+	if err != nil {
+		finished := time.Now()
+		deployment.Status = storage.DeploymentFailed
+		deployment.FinishedAt = &finished
+		deployment.Log = fmt.Sprintf("deploy not started: %v\n", err)
+		if uerr := d.store.UpdateDeployment(ctx, deployment); uerr != nil {
+			slog.WarnContext(ctx, "Failed to record unstarted deployment", "deployment_id", deployment.ID, "error", uerr)
+		}
+		d.publish(deployment, "")
+		return nil, err
+	}
+
+	queued.JobID = job.ID
+	return &queued, nil
+}
+
+// DryRun runs the pipeline against the plan in ctx (see dryrun.WithPlan),
+// recording what a deploy would change without creating a deployment
+func (d *Deployer) DryRun(ctx context.Context, service *storage.Service) error {
+	if err := d.checkFetch(ctx, service); err != nil {
+		return err
+	}
+	return d.execute(ctx, service, &storage.Deployment{ServiceID: service.ID}, func(string, ...interface{}) {})
+}
+
+// checkIdle reports ErrDeployInProgress if the latest deployment has not finished
+func (d *Deployer) checkIdle(ctx context.Context, serviceID int64) error {
+	latest, err := d.store.ListDeployments(ctx, serviceID, 1)
+	if err != nil {
+		return err
+	}
+	if len(latest) > 0 && latest[0].FinishedAt == nil {
+		return ErrDeployInProgress
+	}
+	return nil
+}
+
+// checkFetch refuses git deploys of services on agent hosts, since the
+// repository would be fetched on this server
+func (d *Deployer) checkFetch(ctx context.Context, service *storage.Service) error {
+	if service.GitRepoURL == "" || service.WorkingDir == "" {
+		return nil
+	}
+	project, err := d.store.GetProject(ctx, service.ProjectID)
+	if err != nil {
+		return err
+	}
+	if project != nil && project.HostID != 0 {
+		return fmt.Errorf("git deploys: %w", agent.ErrLocalOnly)
+	}
+	return nil
+}
+
+// run executes the pipeline steps, appending their progress to the deployment
+// log and to the log of the job running it
+func (d *Deployer) run(ctx context.Context, service *storage.Service, deployment *storage.Deployment, logf jobs.Logf) error {
+	// The deployment is recorded even when the job is cancelled
+	saveCtx := context.WithoutCancel(ctx)
+	var log strings.Builder
+	step := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		line := fmt.Sprintf("[%s] %s", time.Now().Format(time.TimeOnly), msg)
+		log.WriteString(line + "\n")
+		d.publish(deployment, line)
+		logf("%s", msg)
+	}
+
+	started := time.Now()
+	deployment.Status = storage.DeploymentRunning
+	deployment.StartedAt = &started
+	if err := d.store.UpdateDeployment(saveCtx, deployment); err != nil {
+		slog.WarnContext(ctx, "Failed to mark deployment running", "deployment_id", deployment.ID, "error", err)
+	}
+	d.publish(deployment, "")
+
+	err := ctx.Err()
+	if err == nil {
+		err = d.execute(ctx, service, deployment, step)
+	}
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+
+	finished := time.Now()
+	deployment.FinishedAt = &finished
+	if err != nil {
+		step("deploy failed: %v", err)
+		deployment.Status = storage.DeploymentFailed
+		slog.WarnContext(ctx, "Deployment failed", "service", service.Name, "deployment_id", deployment.ID, "error", err)
+	} else {
+		step("deploy finished in %s", finished.Sub(started).Round(time.Millisecond))
+		deployment.Status = storage.DeploymentSucceeded
+		slog.InfoContext(ctx, "Deployment succeeded", "service", service.Name, "deployment_id", deployment.ID, "commit", deployment.Commit)
+	}
+	deployment.Log = log.String()
+
+	if uerr := d.store.UpdateDeployment(saveCtx, deployment); uerr != nil {
+		slog.ErrorContext(ctx, "Failed to save deployment result", "deployment_id", deployment.ID, "error", uerr)
+	}
+	d.publish(deployment, "")
+
+	data := map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"service":       service.Name,
+		"status":        deployment.Status,
+		"commit":        deployment.Commit,
+		"duration_ms":   finished.Sub(started).Milliseconds(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	d.events.Publish(events.Event{Type: events.DeployFinished, ProjectID: service.ProjectID, ServiceID: service.ID, Data: data})
+	return err
+}
+
+func (d *Deployer) execute(ctx context.Context, service *storage.Service, deployment *storage.Deployment, step func(string, ...interface{})) error {
+	if service.GitRepoURL != "" && service.WorkingDir != "" {
+		step("fetching %s into %s", service.GitRepoURL, service.WorkingDir)
+		if err := git.CloneRepository(ctx, service.GitRepoURL, service.WorkingDir, git.CloneOptions{Branch: service.GitBranch, Filter: service.GitFilter}); err != nil {
+			return err
+		}
+		// A dry run fetched nothing, so there is no new commit to report
+		if dryrun.FromContext(ctx) == nil {
+			commit, err := git.HeadCommit(ctx, service.WorkingDir)
+			if err != nil {
+				return err
+			}
+			deployment.Commit = commit
+			step("checked out %s", commit)
+			if git.UsesLFS(service.WorkingDir) {
+				step("fetched the Git LFS files")
+			}
+		}
+	} else {
+		step("no git repository configured, skipping fetch")
+	}
+
+	if d.env != nil {
+		path, err := d.env.Sync(ctx, service)
+		if err != nil {
+			return fmt.Errorf("failed to write .env file: %w", err)
+		}
+		if path != "" {
+			step("wrote %s", path)
+		}
+	}
+
+	if d.assets != nil {
+		err := d.assets.BuildAssets(ctx, service, func(stream, line string) {
+			switch stream {
+			case "command":
+				step("$ %s", line)
+			case "stderr":
+				step("! %s", line)
+			default:
+				step("| %s", line)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	step("installing unit %s", service.ServiceName())
+	if err := d.svcManager.InstallService(ctx, service); err != nil {
+		return err
+	}
+
+	restart, err := d.store.GetSettingBool(ctx, storage.SettingDeployRestart)
+	if err != nil {
+		return err
+	}
+	if !restart {
+		step("%s is disabled, not restarting %s", storage.SettingDeployRestart, service.ServiceName())
+		return nil
+	}
+
+	step("restarting %s", service.ServiceName())
+	return d.svcManager.Restart(ctx, service.ServiceName())
+}
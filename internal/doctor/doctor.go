@@ -0,0 +1,246 @@
+// Package doctor checks that a host has what Servio needs to manage services:
+// systemd and journald access (or OpenRC or runit), nginx, git, sudo rights,
+// and writable directories.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/systemd"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// checkTimeout bounds each external command
+const checkTimeout = 5 * time.Second
+
+// Result is a single check's outcome, with a fix for anything but a pass
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// Report lists every check in a stable order; Status is the worst result
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Options are the directories Servio writes to on this host: systemd units,
+// nginx sites, and its own data
+type Options struct {
+	Dirs []string
+}
+
+// check is one named prerequisite check
+type check struct {
+	name string
+	run  func(ctx context.Context) Result
+}
+
+// Run executes all checks concurrently and reports them in a fixed order
+func Run(ctx context.Context, opts Options) *Report {
+	checks := append(initChecks(),
+		check{"nginx", checkNginx},
+		check{"git", checkGit},
+		check{"sudo", checkSudo},
+	)
+	for _, dir := range opts.Dirs {
+		dir := dir
+		checks = append(checks, check{"writable " + dir, func(context.Context) Result { return checkWritable(dir) }})
+	}
+
+	report := &Report{Status: StatusPass, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			result := c.run(ctx)
+			result.Name = c.name
+			report.Checks[i] = result
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, r := range report.Checks {
+		report.worst(r.Status)
+	}
+	return report
+}
+
+// Add appends a check made outside this package, such as one of the server's
+// own state
+func (r *Report) Add(result Result) {
+	r.Checks = append(r.Checks, result)
+	r.worst(result.Status)
+}
+
+// worst lowers the report's status to s when s is worse
+func (r *Report) worst(s Status) {
+	if s == StatusFail || (s == StatusWarn && r.Status == StatusPass) {
+		r.Status = s
+	}
+}
+
+// output runs a command and returns its trimmed combined output
+func output(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// firstLine keeps command output to one line in a report
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// initChecks checks systemd and its journal, or the tools of OpenRC or runit
+// on hosts running those, where services log to files instead
+func initChecks() []check {
+	switch systemd.DetectInit() {
+	case systemd.InitOpenRC:
+		return []check{{"openrc", checkOpenRC}}
+	case systemd.InitRunit:
+		return []check{{"runit", checkRunit}}
+	}
+	return []check{{"systemd", checkSystemd}, {"journald", checkJournald}}
+}
+
+func checkOpenRC(ctx context.Context) Result {
+	for _, tool := range []string{"rc-service", "rc-update", "supervise-daemon"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return Result{Status: StatusFail, Detail: tool + " not found", Fix: "install OpenRC (apk add openrc)"}
+		}
+	}
+	out, err := output(ctx, "rc-status", "--runlevel")
+	if err != nil {
+		return Result{Status: StatusFail, Detail: firstLine(out), Fix: "make sure OpenRC is running (containers often lack it)"}
+	}
+	return Result{Status: StatusPass, Detail: "OpenRC, runlevel " + firstLine(out)}
+}
+
+func checkRunit(ctx context.Context) Result {
+	for _, tool := range []string{"sv", "svlogd", "chpst"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return Result{Status: StatusFail, Detail: tool + " not found", Fix: "install runit (xbps-install runit)"}
+		}
+	}
+	return Result{Status: StatusPass, Detail: "runit"}
+}
+
+func checkSystemd(ctx context.Context) Result {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return Result{Status: StatusFail, Detail: "systemctl not found", Fix: "Servio needs a systemd-based Linux distribution"}
+	}
+	out, err := output(ctx, "systemctl", "show", "--property=Version", "--value")
+	if err != nil {
+		return Result{Status: StatusFail, Detail: firstLine(out), Fix: "make sure systemd is running as PID 1 (containers often lack it)"}
+	}
+	return Result{Status: StatusPass, Detail: "systemd " + out}
+}
+
+func checkJournald(ctx context.Context) Result {
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return Result{Status: StatusFail, Detail: "journalctl not found", Fix: "install systemd's journal (journalctl) to view service logs"}
+	}
+	out, err := output(ctx, "journalctl", "--no-pager", "--quiet", "-n", "1")
+	if err != nil || strings.Contains(out, "No journal files") || strings.Contains(out, "not seeing messages") {
+		return Result{Status: StatusFail, Detail: firstLine(out), Fix: "add the Servio user to the systemd-journal group (usermod -aG systemd-journal USER)"}
+	}
+	return Result{Status: StatusPass, Detail: "journal is readable"}
+}
+
+func checkNginx(ctx context.Context) Result {
+	if _, err := exec.LookPath("nginx"); err != nil {
+		return Result{Status: StatusFail, Detail: "nginx not found", Fix: "install nginx (dnf install nginx, or apt-get install nginx) to serve project domains"}
+	}
+	// nginx -v prints "nginx version: nginx/1.24.0" to stderr
+	out, err := output(ctx, "nginx", "-v")
+	if err != nil {
+		return Result{Status: StatusFail, Detail: firstLine(out), Fix: "check the nginx installation"}
+	}
+	return Result{Status: StatusPass, Detail: strings.TrimPrefix(firstLine(out), "nginx version: ")}
+}
+
+func checkGit(ctx context.Context) Result {
+	if _, err := exec.LookPath("git"); err != nil {
+		return Result{Status: StatusFail, Detail: "git not found", Fix: "install git to clone and deploy repositories"}
+	}
+	out, err := output(ctx, "git", "--version")
+	if err != nil {
+		return Result{Status: StatusFail, Detail: firstLine(out), Fix: "check the git installation"}
+	}
+	return Result{Status: StatusPass, Detail: strings.TrimPrefix(out, "git version ")}
+}
+
+// checkSudo verifies the commands Servio runs through sudo are allowed without a password
+func checkSudo(ctx context.Context) Result {
+	if os.Geteuid() == 0 {
+		return Result{Status: StatusPass, Detail: "running as root"}
+	}
+	if _, err := exec.LookPath("sudo"); err != nil {
+		return Result{Status: StatusFail, Detail: "sudo not found", Fix: "install sudo, or run Servio as root"}
+	}
+
+	var denied []string
+	for _, cmd := range [][]string{{"nginx", "-t"}, {"systemctl", "reload", "nginx"}} {
+		if _, err := output(ctx, "sudo", append([]string{"-n", "-l"}, cmd...)...); err != nil {
+			denied = append(denied, strings.Join(cmd, " "))
+		}
+	}
+	if len(denied) > 0 {
+		return Result{Status: StatusFail, Detail: "not allowed without a password: " + strings.Join(denied, ", "),
+			Fix: "run servio install, or add NOPASSWD sudoers entries for these commands"}
+	}
+	return Result{Status: StatusPass, Detail: "nginx test and reload allowed"}
+}
+
+// checkWritable creates and removes a temporary file, which also accounts for
+// capabilities and read-only mounts that permission bits do not show
+func checkWritable(dir string) Result {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		parent := filepath.Dir(dir)
+		if r := checkWritable(parent); r.Status == StatusFail {
+			return Result{Status: StatusFail, Detail: "does not exist and " + parent + " is not writable", Fix: "create " + dir + " and give the Servio user write access"}
+		}
+		return Result{Status: StatusWarn, Detail: "does not exist yet"}
+	}
+	if err != nil {
+		return Result{Status: StatusFail, Detail: err.Error(), Fix: "check the path and its permissions"}
+	}
+	if !info.IsDir() {
+		return Result{Status: StatusFail, Detail: "not a directory", Fix: "remove or rename " + dir}
+	}
+
+	f, err := os.CreateTemp(dir, ".servio-doctor-*")
+	if err != nil {
+		fix := "give the Servio user write access to " + dir
+		if os.Geteuid() != 0 {
+			fix += " (servio install grants CAP_DAC_OVERRIDE)"
+		}
+		return Result{Status: StatusFail, Detail: "not writable", Fix: fix}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return Result{Status: StatusPass, Detail: "writable"}
+}
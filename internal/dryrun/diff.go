@@ -0,0 +1,181 @@
+package dryrun
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each hunk
+const diffContext = 3
+
+// maxDiffCells bounds the line-by-line comparison; larger files are shown
+// as entirely replaced
+const maxDiffCells = 4 << 20
+
+// Diff returns a unified diff turning old into new, labelled with path, or
+// "" when they are equal. A missing file is diffed as empty.
+func Diff(path, old, new string) string {
+	if old == new {
+		return ""
+	}
+	a, b := splitLines(old), splitLines(new)
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	from, to := "a"+path, "b"+path
+	if old == "" {
+		from = "/dev/null"
+	}
+	if new == "" {
+		to = "/dev/null"
+	}
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+
+	// Walk the edits, emitting a hunk for each run of changes along with
+	// its context, merging runs whose context overlaps
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-diffContext, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+
+		oldStart, newStart := ops[start].oldLine, ops[start].newLine
+		oldCount, newCount := 0, 0
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+			body.WriteByte(op.kind)
+			body.WriteString(op.text)
+			body.WriteByte('\n')
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n%s", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount), body.String())
+		i = end
+	}
+	return out.String()
+}
+
+// hunkRange formats a hunk's start line and length; empty ranges name the
+// line before them
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edit is one line of a diff: ' ' kept, '-' removed, or '+' added, with
+// the (1-based) line it is at in each file
+type edit struct {
+	kind             byte
+	text             string
+	oldLine, newLine int
+}
+
+// diffLines finds the edits turning a into b through their longest common
+// subsequence of lines
+func diffLines(a, b []string) []edit {
+	// Lines shared at the start and end need no comparison
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var ops []edit
+	oldLine, newLine := 1, 1
+	keep := func(text string) {
+		ops = append(ops, edit{' ', text, oldLine, newLine})
+		oldLine++
+		newLine++
+	}
+	remove := func(text string) {
+		ops = append(ops, edit{'-', text, oldLine, newLine})
+		oldLine++
+	}
+	add := func(text string) {
+		ops = append(ops, edit{'+', text, oldLine, newLine})
+		newLine++
+	}
+
+	for _, line := range a[:prefix] {
+		keep(line)
+	}
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, line := range midA {
+			remove(line)
+		}
+		for _, line := range midB {
+			add(line)
+		}
+	} else {
+		// lcs[i][j] is the common subsequence length of midA[i:] and midB[j:]
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				keep(midA[i])
+				i++
+				j++
+			case j < len(midB) && (i == len(midA) || lcs[i][j+1] > lcs[i+1][j]):
+				add(midB[j])
+				j++
+			default:
+				remove(midA[i])
+				i++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		keep(line)
+	}
+	return ops
+}
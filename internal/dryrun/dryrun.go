@@ -0,0 +1,139 @@
+// Package dryrun records the host changes an operation would make instead of
+// making them. Code that writes files or runs commands checks FromContext and,
+// when a plan is present, records the action and skips it.
+//
+// SetGlobal makes every operation without its own plan a dry run, for
+// running Servio against a host it must not change; those actions are logged.
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Action types
+const (
+	ActionWrite   = "write"
+	ActionRemove  = "remove"
+	ActionMkdir   = "mkdir"
+	ActionSymlink = "symlink"
+	ActionRun     = "run"
+)
+
+// Action is one file change or command a dry run skipped
+type Action struct {
+	Type    string `json:"type"`
+	Path    string `json:"path,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Content string `json:"content,omitempty"` // written file contents
+	Target  string `json:"target,omitempty"`  // what a symlink points to
+	Command string `json:"command,omitempty"`
+	Host    string `json:"host,omitempty"` // agent host the change is on; "" for this server
+}
+
+// Plan collects the actions of a dry run in order
+type Plan struct {
+	mu      sync.Mutex
+	actions []Action
+	global  bool // logs each action instead of keeping it
+}
+
+type planKey struct{}
+
+// global is set while the whole process runs in dry-run mode
+var global atomic.Bool
+
+// SetGlobal turns process-wide dry-run mode on or off
+func SetGlobal(enabled bool) {
+	global.Store(enabled)
+}
+
+// WithPlan makes operations run with ctx record into plan instead of changing the host
+func WithPlan(ctx context.Context, plan *Plan) context.Context {
+	return context.WithValue(ctx, planKey{}, plan)
+}
+
+// FromContext returns the plan of a dry run, or nil when changes should be made.
+// In global dry-run mode, operations without a plan of their own get one that
+// logs the actions.
+func FromContext(ctx context.Context) *Plan {
+	plan, _ := ctx.Value(planKey{}).(*Plan)
+	if plan == nil && global.Load() {
+		return &Plan{global: true}
+	}
+	return plan
+}
+
+// Global reports whether the plan comes from global dry-run mode rather than
+// a dry-run request
+func (p *Plan) Global() bool {
+	return p.global
+}
+
+// Actions returns the recorded actions; never nil, so it encodes as []
+func (p *Plan) Actions() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Action{}, p.actions...)
+}
+
+func (p *Plan) add(a Action) {
+	if p.global {
+		attrs := []any{"type", a.Type}
+		if a.Path != "" {
+			attrs = append(attrs, "path", a.Path)
+		}
+		if a.Command != "" {
+			attrs = append(attrs, "command", a.Command)
+		}
+		if a.Host != "" {
+			attrs = append(attrs, "host", a.Host)
+		}
+		slog.Info("Dry run: skipped host change", attrs...)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = append(p.actions, a)
+}
+
+// Write records writing a file
+func (p *Plan) Write(path, content string, mode os.FileMode) {
+	p.add(Action{Type: ActionWrite, Path: path, Mode: fmt.Sprintf("%04o", mode), Content: content})
+}
+
+// Remove records deleting a file
+func (p *Plan) Remove(path string) {
+	p.add(Action{Type: ActionRemove, Path: path})
+}
+
+// Mkdir records creating a directory (and its parents), unless it already exists
+func (p *Plan) Mkdir(path string, mode os.FileMode) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return
+	}
+	p.add(Action{Type: ActionMkdir, Path: path, Mode: fmt.Sprintf("%04o", mode)})
+}
+
+// Symlink records creating link pointing at target
+func (p *Plan) Symlink(target, link string) {
+	p.add(Action{Type: ActionSymlink, Path: link, Target: target})
+}
+
+// Run records running a command
+func (p *Plan) Run(args []string) {
+	p.add(Action{Type: ActionRun, Command: strings.Join(args, " ")})
+}
+
+// Append records the actions an agent host reported for its part of the dry run
+func (p *Plan) Append(host string, actions []Action) {
+	for _, a := range actions {
+		a.Host = host
+		p.add(a)
+	}
+}
@@ -0,0 +1,362 @@
+// Package envfile manages the .env file of a service from the variables
+// stored for it and for its project: rendering it with secrets decrypted and
+// ${secret:NAME} references resolved, writing it, and comparing it with what
+// is on disk.
+package envfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/secrets"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// Name is the file written in the working directory unless a path is set
+const Name = ".env"
+
+// mask stands in for secret values in dry runs and drift reports
+const mask = "***"
+
+var (
+	// ErrInvalidKey is wrapped for variable names that are not shell identifiers
+	ErrInvalidKey = errors.New("invalid variable name")
+	// ErrNoPath is returned when a service has neither a working directory nor a path for its file
+	ErrNoPath = errors.New("no .env path: set a working directory or an explicit path")
+)
+
+// keyPattern is what variable names must look like, as in POSIX shells
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Drift compares the file on disk with what Servio would write. Secret values
+// are never included, only the names of the variables that differ.
+type Drift struct {
+	Path     string     `json:"path"`
+	Exists   bool       `json:"exists"`
+	InSync   bool       `json:"in_sync"`
+	Missing  []string   `json:"missing"`  // stored but not in the file
+	Extra    []string   `json:"extra"`    // in the file but not stored
+	Changed  []string   `json:"changed"`  // in both with different values
+	Modified bool       `json:"modified"` // the file changed since Servio last wrote it
+	Written  *time.Time `json:"written_at,omitempty"`
+}
+
+// Manager renders and writes services' .env files
+type Manager struct {
+	store   storage.Store
+	cipher  *secrets.Cipher
+	secrets systemd.SecretResolver
+}
+
+// NewManager creates a Manager; resolver fills in ${secret:NAME} references
+func NewManager(store storage.Store, cipher *secrets.Cipher, resolver systemd.SecretResolver) *Manager {
+	return &Manager{store: store, cipher: cipher, secrets: resolver}
+}
+
+// CheckKey returns an ErrInvalidKey unless key is a valid variable name
+func CheckKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("%w: %q must start with a letter or underscore and hold only letters, digits, and underscores", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// Path returns where a service's file goes: file's path when set, else .env
+// in the working directory
+func Path(service *storage.Service, file *storage.EnvFile) (string, error) {
+	if file != nil && file.Path != "" {
+		return file.Path, nil
+	}
+	if service.WorkingDir == "" || service.WorkingDir == "/" {
+		return "", ErrNoPath
+	}
+	return filepath.Join(service.WorkingDir, Name), nil
+}
+
+// Encrypt prepares a variable for storage, moving a secret's value into its ciphertext
+func (m *Manager) Encrypt(v *storage.EnvVar) error {
+	if !v.Secret {
+		return nil
+	}
+	ciphertext, err := m.cipher.Encrypt(v.Value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", v.Key, err)
+	}
+	v.Ciphertext, v.Value = ciphertext, ""
+	return nil
+}
+
+// Mask blanks the values of secret variables for responses
+func Mask(vars []*storage.EnvVar) {
+	for _, v := range vars {
+		if v.Secret {
+			v.Value = mask
+		}
+	}
+}
+
+// Vars returns the variables written to a service's file: its project's,
+// with the service's own replacing any of the same name, by key
+func (m *Manager) Vars(ctx context.Context, service *storage.Service) ([]*storage.EnvVar, error) {
+	vars, err := m.store.ListEnvVars(ctx, service.ID)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := m.store.ListProjectEnvVars(ctx, service.ProjectID)
+	if err != nil || len(shared) == 0 {
+		return vars, err
+	}
+	merged := make([]*storage.EnvVar, 0, len(vars)+len(shared))
+	for _, v := range shared {
+		if !slices.ContainsFunc(vars, func(own *storage.EnvVar) bool { return own.Key == v.Key }) {
+			merged = append(merged, v)
+		}
+	}
+	merged = append(merged, vars...)
+	slices.SortFunc(merged, func(a, b *storage.EnvVar) int { return strings.Compare(a.Key, b.Key) })
+	return merged, nil
+}
+
+// Sync writes a service's file if it or its project has variables, and
+// returns its path ("" when neither has any). The file is 0600 and owned by
+// the service's user. Dry runs plan it with secret values masked.
+func (m *Manager) Sync(ctx context.Context, service *storage.Service) (string, error) {
+	vars, err := m.Vars(ctx, service)
+	if err != nil || len(vars) == 0 {
+		return "", err
+	}
+	file, err := m.store.GetEnvFile(ctx, service.ID)
+	if err != nil {
+		return "", err
+	}
+	path, err := Path(service, file)
+	if err != nil {
+		return "", err
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		Mask(vars)
+		plan.Write(path, Render(vars), 0600)
+		return path, nil
+	}
+
+	values, err := m.values(ctx, service, vars)
+	if err != nil {
+		return "", err
+	}
+	content := Render(values)
+	if err := write(ctx, service, path, content); err != nil {
+		return "", err
+	}
+	if err := m.store.RecordEnvFileWrite(ctx, service.ID, checksum(content)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Contents returns what Sync would write for a service, secrets included,
+// and "" when neither it nor its project has variables. It is for comparing with the file on
+// disk and must not be shown.
+func (m *Manager) Contents(ctx context.Context, service *storage.Service) (string, error) {
+	vars, err := m.Vars(ctx, service)
+	if err != nil || len(vars) == 0 {
+		return "", err
+	}
+	values, err := m.values(ctx, service, vars)
+	if err != nil {
+		return "", err
+	}
+	return Render(values), nil
+}
+
+// Drift reads a service's file and compares it with its and its project's variables
+func (m *Manager) Drift(ctx context.Context, service *storage.Service) (*Drift, error) {
+	vars, err := m.Vars(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	file, err := m.store.GetEnvFile(ctx, service.ID)
+	if err != nil {
+		return nil, err
+	}
+	path, err := Path(service, file)
+	if err != nil {
+		return nil, err
+	}
+	want, err := m.values(ctx, service, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &Drift{Path: path, Missing: []string{}, Extra: []string{}, Changed: []string{}}
+	if file != nil {
+		drift.Written = file.WrittenAt
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		data = nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	default:
+		drift.Exists = true
+	}
+	have := Parse(string(data))
+	wanted := make(map[string]string, len(want))
+	for _, v := range want {
+		wanted[v.Key] = v.Value
+		got, ok := have[v.Key]
+		switch {
+		case !ok:
+			drift.Missing = append(drift.Missing, v.Key)
+		case got != v.Value:
+			drift.Changed = append(drift.Changed, v.Key)
+		}
+	}
+	for key := range have {
+		if _, ok := wanted[key]; !ok {
+			drift.Extra = append(drift.Extra, key)
+		}
+	}
+	slices.Sort(drift.Extra)
+	if drift.Exists && file != nil && file.Checksum != "" {
+		drift.Modified = checksum(string(data)) != file.Checksum
+	}
+	drift.InSync = len(drift.Missing)+len(drift.Extra)+len(drift.Changed) == 0 && (drift.Exists || len(want) == 0)
+	return drift, nil
+}
+
+// values returns the variables with secrets decrypted and references resolved
+func (m *Manager) values(ctx context.Context, service *storage.Service, vars []*storage.EnvVar) ([]*storage.EnvVar, error) {
+	out := make([]*storage.EnvVar, len(vars))
+	for i, v := range vars {
+		value := v.Value
+		if v.Secret {
+			plain, err := m.cipher.Decrypt(v.Ciphertext)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s: %w", v.Key, err)
+			}
+			value = plain
+		}
+		if m.secrets != nil {
+			resolved, _, err := m.secrets.Resolve(ctx, service, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", v.Key, err)
+			}
+			value = resolved
+		}
+		out[i] = &storage.EnvVar{Key: v.Key, Value: value, Secret: v.Secret}
+	}
+	return out, nil
+}
+
+// Render formats variables as KEY="value" lines, which both dotenv loaders
+// and systemd's EnvironmentFile= read
+func Render(vars []*storage.EnvVar) string {
+	var b strings.Builder
+	b.WriteString("# Managed by Servio; changes here are overwritten on the next deploy\n")
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s=%s\n", v.Key, quote(v.Value))
+	}
+	return b.String()
+}
+
+// Parse reads KEY=value lines, skipping blanks and comments. Values may be
+// double-quoted with backslash escapes, single-quoted, or bare, and an
+// "export " prefix is allowed.
+func Parse(content string) map[string]string {
+	vars := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = unquote(value[1 : len(value)-1])
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+		vars[key] = value
+	}
+	return vars
+}
+
+// quote double-quotes a value, escaping backslashes, quotes, dollar signs,
+// and newlines
+func quote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// unquote undoes quote, leaving unknown escapes as they are
+func unquote(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case '\\', '"', '$', '`':
+			b.WriteByte(value[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// write replaces path with content, readable only by the service's user
+func write(ctx context.Context, service *storage.Service, path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	start := time.Now()
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err == nil {
+		// WriteFile keeps the mode of an existing file
+		err = os.Chmod(path, 0600)
+	}
+	audit.Log(ctx, audit.CategoryEnv, "write-env", "write "+path, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	audit.WroteFile(ctx, audit.CategoryEnv, path, []byte(content), 0600)
+	if service.User != "" && service.User != "root" {
+		// Best effort, like the working directory itself
+		if output, err := exec.Command("chown", service.User, path).CombinedOutput(); err != nil {
+			slog.WarnContext(ctx, "Failed to chown env file", "path", path, "user", service.User, "error", err, "output", string(output))
+		}
+	}
+	return nil
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
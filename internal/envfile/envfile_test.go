@@ -0,0 +1,51 @@
+package envfile
+
+import (
+	"reflect"
+	"testing"
+
+	"servio/internal/storage"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"bare values", "A=1\nB = two words \n", map[string]string{"A": "1", "B": "two words"}},
+		{"comments and blanks", "# header\n\nA=1\n  # indented\n", map[string]string{"A": "1"}},
+		{"export prefix", "export A=1", map[string]string{"A": "1"}},
+		{"double quotes", `A="x \"y\" \$HOME\nz \\ \q"`, map[string]string{"A": "x \"y\" $HOME\nz \\ \\q"}},
+		{"single quotes", `A='\n $x'`, map[string]string{"A": `\n $x`}},
+		{"unbalanced quote", `A="open`, map[string]string{"A": `"open`}},
+		{"equals in value", "URL=postgres://u:p@h/db?sslmode=disable", map[string]string{"URL": "postgres://u:p@h/db?sslmode=disable"}},
+		{"empty value", "A=\nB=\"\"", map[string]string{"A": "", "B": ""}},
+		{"no equals", "JUSTAKEY\nA=1", map[string]string{"A": "1"}},
+		{"last wins", "A=1\nA=2", map[string]string{"A": "2"}},
+		{"CRLF", "A=1\r\nB=2\r\n", map[string]string{"A": "1", "B": "2"}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse(%q) = %q, want %q", tt.name, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRenderParse(t *testing.T) {
+	vars := []*storage.EnvVar{
+		{Key: "PLAIN", Value: "value"},
+		{Key: "QUOTES", Value: `say "hi"`},
+		{Key: "DOLLAR", Value: "$HOME and ${PATH}"},
+		{Key: "BACKSLASH", Value: `C:\dir\n`},
+		{Key: "MULTILINE", Value: "line one\nline two"},
+		{Key: "EMPTY", Value: ""},
+	}
+	got := Parse(Render(vars))
+	for _, v := range vars {
+		if got[v.Key] != v.Value {
+			t.Errorf("%s = %q after Render and Parse, want %q", v.Key, got[v.Key], v.Value)
+		}
+	}
+}
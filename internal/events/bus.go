@@ -0,0 +1,85 @@
+// Package events carries notable changes (a service starting or crashing, a
+// deployment finishing, an nginx site going live) from where they happen to
+// whoever reacts to them, such as webhook delivery.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	ServiceStarted = "service.started"
+	ServiceCrashed = "service.crashed"
+	ServiceStopped = "service.stopped"
+	JobUpdated     = "job.updated"
+	DeployFinished = "deploy.finished"
+	NginxDeployed  = "nginx.deployed"
+	LogAlert       = "log.alert"
+	CronFinished   = "cron.finished"
+)
+
+// Types lists every event type that can be published
+var Types = []string{ServiceStarted, ServiceCrashed, ServiceStopped, JobUpdated, DeployFinished, NginxDeployed, LogAlert, CronFinished}
+
+// Event is a single change. Data holds type-specific details.
+type Event struct {
+	Type      string                 `json:"type"`
+	Time      time.Time              `json:"time"`
+	ProjectID int64                  `json:"project_id,omitempty"`
+	ServiceID int64                  `json:"service_id,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// Handler receives published events. It runs on the publisher's goroutine,
+// so it must hand slow work off instead of blocking.
+type Handler func(Event)
+
+// Bus fans events out to its subscribers
+type Bus struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]Handler
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[int]Handler)}
+}
+
+// Subscribe registers a handler for every subsequent event until the
+// returned function is called
+func (b *Bus) Subscribe(h Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.handlers[id] = h
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.handlers, id)
+		})
+	}
+}
+
+// Publish delivers an event to every subscriber. A zero Time is set to now.
+// Publishing on a nil Bus is a no-op.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, h := range b.handlers {
+		h(e)
+	}
+}
@@ -1,16 +1,28 @@
 package git
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
 )
 
-// CloneRepository clones a git repository to the specified directory
-// If repoURL is empty, this function does nothing
-func CloneRepository(repoURL, targetDir string) error {
+// CloneOptions are a service's choices for its checkout
+type CloneOptions struct {
+	Branch string // checked out unless empty
+	Filter string // partial clone filter such as blob:none; a full clone when empty
+}
+
+// CloneRepository clones a git repository to the specified directory, or
+// pulls it when it is already there. A repository that uses Git LFS then has
+// git-lfs installed if needed and its LFS files fetched. If repoURL is empty,
+// this function does nothing
+func CloneRepository(ctx context.Context, repoURL, targetDir string, opts CloneOptions) error {
 	if repoURL == "" {
 		return nil
 	}
@@ -26,7 +38,10 @@ func CloneRepository(repoURL, targetDir string) error {
 		gitDir := filepath.Join(targetDir, ".git")
 		if _, err := os.Stat(gitDir); err == nil {
 			// It's already a git repo, try to pull latest
-			return pullRepository(targetDir)
+			if err := pullRepository(ctx, targetDir, opts.Branch); err != nil {
+				return err
+			}
+			return syncLFS(ctx, targetDir)
 		}
 		// Directory exists but not a git repo
 		return fmt.Errorf("directory %s already exists and is not a git repository", targetDir)
@@ -34,24 +49,44 @@ func CloneRepository(repoURL, targetDir string) error {
 
 	// Create parent directory if needed
 	parentDir := filepath.Dir(targetDir)
-	if err := os.MkdirAll(parentDir, 0755); err != nil {
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(parentDir, 0755)
+	} else if err := os.MkdirAll(parentDir, 0755); err != nil {
 		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
 	// Clone the repository
-	cmd := exec.Command("git", "clone", repoURL, targetDir)
-	output, err := cmd.CombinedOutput()
+	args := []string{"clone"}
+	if opts.Branch != "" {
+		args = append(args, "--branch", opts.Branch)
+	}
+	if opts.Filter != "" {
+		// Blobs (or trees) are fetched as checkouts need them, so the
+		// history of large files never reaches the disk
+		args = append(args, "--filter="+opts.Filter)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(args, "--", repoURL, targetDir)...)
+	output, err := audit.Run(ctx, audit.CategoryGit, "clone", cmd)
 	if err != nil {
 		return fmt.Errorf("git clone failed: %w\nOutput: %s", err, string(output))
 	}
 
-	return nil
+	return syncLFS(ctx, targetDir)
 }
 
-// pullRepository pulls the latest changes from the remote repository
-func pullRepository(repoDir string) error {
-	cmd := exec.Command("git", "-C", repoDir, "pull", "--ff-only")
-	output, err := cmd.CombinedOutput()
+// pullRepository pulls the latest changes from the remote repository, first
+// switching to branch when it is set
+func pullRepository(ctx context.Context, repoDir, branch string) error {
+	if branch != "" {
+		for _, args := range [][]string{{"fetch", "origin", branch}, {"checkout", branch}} {
+			cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoDir}, args...)...)
+			if output, err := audit.Run(ctx, audit.CategoryGit, args[0], cmd); err != nil {
+				return fmt.Errorf("git %s failed: %w\nOutput: %s", args[0], err, string(output))
+			}
+		}
+	}
+	cmd := exec.CommandContext(ctx, "git", "-C", repoDir, "pull", "--ff-only")
+	output, err := audit.Run(ctx, audit.CategoryGit, "pull", cmd)
 	if err != nil {
 		return fmt.Errorf("git pull failed: %w\nOutput: %s", err, string(output))
 	}
@@ -83,12 +118,25 @@ func isValidGitURL(url string) bool {
 }
 
 // UpdateRepository performs a git pull in the specified directory
-func UpdateRepository(repoDir string) error {
+func UpdateRepository(ctx context.Context, repoDir string) error {
 	// Check if directory exists and is a git repo
 	gitDir := filepath.Join(repoDir, ".git")
 	if _, err := os.Stat(gitDir); os.IsNotExist(err) {
 		return fmt.Errorf("directory %s is not a git repository", repoDir)
 	}
 
-	return pullRepository(repoDir)
+	if err := pullRepository(ctx, repoDir, ""); err != nil {
+		return err
+	}
+	return syncLFS(ctx, repoDir)
+}
+
+// HeadCommit returns the commit hash currently checked out in repoDir
+func HeadCommit(ctx context.Context, repoDir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "-C", repoDir, "rev-parse", "HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
 }
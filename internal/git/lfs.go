@@ -0,0 +1,81 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"servio/internal/audit"
+)
+
+// UsesLFS reports whether the repository checked out in repoDir keeps files
+// in Git LFS, going by its top-level .gitattributes and .lfsconfig
+func UsesLFS(repoDir string) bool {
+	if _, err := os.Stat(filepath.Join(repoDir, ".lfsconfig")); err == nil {
+		return true
+	}
+	f, err := os.Open(filepath.Join(repoDir, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// A pattern followed by its attributes; comments start with #
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && !strings.HasPrefix(fields[0], "#") && slices.Contains(fields[1:], "filter=lfs") {
+			return true
+		}
+	}
+	return false
+}
+
+// syncLFS fetches the LFS files of the checkout in repoDir, installing
+// git-lfs first when the repository uses it and the host lacks it. Objects
+// no longer needed by recent commits are then pruned, so a repository of
+// large files does not keep every old version on disk.
+func syncLFS(ctx context.Context, repoDir string) error {
+	if !UsesLFS(repoDir) {
+		return nil
+	}
+	slog.InfoContext(ctx, "Repository uses Git LFS, fetching its files", "dir", repoDir)
+	if err := InstallLFS(ctx); err != nil {
+		return err
+	}
+	for _, args := range [][]string{{"install", "--local"}, {"pull"}} {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoDir, "lfs"}, args...)...)
+		if output, err := audit.Run(ctx, audit.CategoryGit, "lfs "+args[0], cmd); err != nil {
+			return fmt.Errorf("git lfs %s failed: %w\nOutput: %s", args[0], err, string(output))
+		}
+	}
+	// Pruning only frees space, so a failure does not fail the checkout
+	cmd := exec.CommandContext(ctx, "git", "-C", repoDir, "lfs", "prune")
+	if output, err := audit.Run(ctx, audit.CategoryGit, "lfs prune", cmd); err != nil {
+		slog.WarnContext(ctx, "Failed to prune Git LFS objects", "dir", repoDir, "error", err, "output", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// InstallLFS installs git-lfs unless it is already on the PATH, with dnf or
+// else apt-get
+func InstallLFS(ctx context.Context) error {
+	if _, err := exec.LookPath("git-lfs"); err == nil {
+		return nil
+	}
+	slog.InfoContext(ctx, "Installing git-lfs")
+	cmd := exec.CommandContext(ctx, "sudo", "dnf", "install", "-y", "git-lfs")
+	if _, err := audit.Run(ctx, audit.CategoryPackages, "install", cmd); err != nil {
+		slog.DebugContext(ctx, "dnf failed, trying apt", "error", err)
+		cmd = exec.CommandContext(ctx, "sudo", "apt-get", "install", "-y", "git-lfs")
+		if output, err := audit.Run(ctx, audit.CategoryPackages, "install", cmd); err != nil {
+			return fmt.Errorf("failed to install git-lfs: %w\nOutput: %s", err, string(output))
+		}
+	}
+	return nil
+}
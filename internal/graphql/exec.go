@@ -0,0 +1,693 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// maxDepth bounds how deeply selections may nest, so one request cannot
+// walk the whole database many times over
+const maxDepth = 12
+
+// Request is a GraphQL request
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is nil when the request could
+// not run at all.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is an error in a response, with the path of the field it happened
+// at while executing
+type Error struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Options limits what a request may do and how its errors are reported
+type Options struct {
+	QueryOnly bool               // refuse mutations, as for GET requests
+	Code      func(error) string // names a resolver error's kind in extensions.code; optional
+}
+
+// Execute parses, validates, and runs a request against schema
+func Execute(ctx context.Context, schema *Schema, req Request, opts Options) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return failed(err.Error())
+	}
+	op, err := pickOperation(doc, req.OperationName)
+	if err != nil {
+		return failed(err.Error())
+	}
+	root := schema.Query
+	if op.kind == "mutation" {
+		if opts.QueryOnly {
+			return failed("mutations must be sent with POST")
+		}
+		if root = schema.Mutation; root == nil {
+			return failed("the schema has no mutations")
+		}
+	}
+
+	v := &validator{doc: doc, op: op, defined: map[string]bool{}}
+	for _, def := range op.variables {
+		v.defined[def.name] = true
+	}
+	v.selection(root, op.selection, 1, map[string]bool{})
+	if len(v.errs) > 0 {
+		return &Response{Errors: v.errs}
+	}
+	vars, err := variables(op, req.Variables)
+	if err != nil {
+		return failed(err.Error())
+	}
+
+	e := &executor{doc: doc, vars: vars, code: opts.Code}
+	data, _ := e.object(ctx, root, nil, op.selection, nil)
+	resp := &Response{Errors: e.errs}
+	if data != nil {
+		resp.Data = data
+	}
+	return resp
+}
+
+func failed(message string) *Response {
+	return &Response{Errors: []*Error{{Message: message}}}
+}
+
+// pickOperation returns the operation called name, or the only one
+func pickOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, errors.New("the document has several operations; name one with operationName")
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("no operation named %s", name)
+}
+
+// variables applies defaults to the request's variables and checks that
+// required ones are given. Their values are coerced where they are used.
+func variables(op *operation, given map[string]any) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, def := range op.variables {
+		v, ok := given[def.name]
+		if !ok && def.defValue != nil {
+			literal, err := literalValue(def.defValue, nil)
+			if err != nil {
+				return nil, err
+			}
+			v, ok = literal, true
+		}
+		if (!ok || v == nil) && def.typ.nonNull {
+			return nil, fmt.Errorf("variable $%s of type %s is required", def.name, def.typ)
+		}
+		if ok {
+			vars[def.name] = v
+		}
+	}
+	return vars, nil
+}
+
+// validator checks a request against the schema before any of it runs
+type validator struct {
+	doc     *document
+	op      *operation
+	defined map[string]bool // variables the operation declares
+	errs    []*Error
+}
+
+func (v *validator) errorf(line int, format string, args ...any) {
+	v.errs = append(v.errs, &Error{Message: fmt.Sprintf("line %d: ", line) + fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) selection(parent *Object, sel []selection, depth int, spreading map[string]bool) {
+	for _, s := range sel {
+		v.directives(s.directives())
+		switch s := s.(type) {
+		case *field:
+			v.field(parent, s, depth, spreading)
+		case *fragmentSpread:
+			f := v.doc.fragments[s.name]
+			switch {
+			case f == nil:
+				v.errorf(s.line, "unknown fragment %s", s.name)
+			case f.on != parent.Name:
+				v.errorf(s.line, "fragment %s on %s cannot be spread in %s", s.name, f.on, parent.Name)
+			case spreading[s.name]:
+				v.errorf(s.line, "fragment %s spreads itself", s.name)
+			default:
+				spreading[s.name] = true
+				v.selection(parent, f.selection, depth, spreading)
+				delete(spreading, s.name)
+			}
+		case *inlineFragment:
+			if s.on != "" && s.on != parent.Name {
+				v.errs = append(v.errs, &Error{Message: fmt.Sprintf("an inline fragment on %s cannot be used in %s", s.on, parent.Name)})
+				continue
+			}
+			v.selection(parent, s.selection, depth, spreading)
+		}
+	}
+}
+
+func (v *validator) field(parent *Object, f *field, depth int, spreading map[string]bool) {
+	if f.name == "__typename" {
+		if len(f.selection) > 0 {
+			v.errorf(f.line, "__typename has no fields")
+		}
+		return
+	}
+	def := parent.field(f.name)
+	if def == nil {
+		v.errorf(f.line, "cannot query field %s on type %s", f.name, parent.Name)
+		return
+	}
+	for _, arg := range f.args {
+		if !hasArgDef(def.Args, arg.name) {
+			v.errorf(f.line, "unknown argument %s on field %s.%s", arg.name, parent.Name, f.name)
+		}
+		v.value(f.line, arg.value)
+	}
+	for _, arg := range def.Args {
+		if _, required := arg.Type.(*NonNull); required && arg.Default == nil && !hasArg(f.args, arg.Name) {
+			v.errorf(f.line, "field %s.%s requires argument %s", parent.Name, f.name, arg.Name)
+		}
+	}
+
+	obj, isObject := named(def.Type).(*Object)
+	switch {
+	case isObject && len(f.selection) == 0:
+		v.errorf(f.line, "field %s of type %s needs a selection of its fields", f.name, def.Type)
+	case !isObject && len(f.selection) > 0:
+		v.errorf(f.line, "field %s of type %s has no fields", f.name, def.Type)
+	case isObject && depth >= maxDepth:
+		v.errorf(f.line, "the query nests deeper than %d levels", maxDepth)
+	case isObject:
+		v.selection(obj, f.selection, depth+1, spreading)
+	}
+}
+
+// value checks that the variables a value uses are declared
+func (v *validator) value(line int, val value) {
+	switch val := val.(type) {
+	case variableRef:
+		if !v.defined[string(val)] {
+			v.errorf(line, "variable $%s is not declared", val)
+		}
+	case listValue:
+		for _, item := range val {
+			v.value(line, item)
+		}
+	case objectValue:
+		for _, item := range val {
+			v.value(line, item)
+		}
+	}
+}
+
+func (v *validator) directives(dirs []*directive) {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			v.errs = append(v.errs, &Error{Message: fmt.Sprintf("unknown directive @%s", d.name)})
+		}
+	}
+}
+
+func hasArgDef(args []*Arg, name string) bool {
+	for _, a := range args {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasArg(args []*argument, name string) bool {
+	for _, a := range args {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// named strips the list and non-null wrappers from t
+func named(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *List:
+			t = w.Of
+		case *NonNull:
+			t = w.Of
+		default:
+			return t
+		}
+	}
+}
+
+// executor runs a validated operation, collecting field errors
+type executor struct {
+	doc  *document
+	vars map[string]any
+	code func(error) string
+	errs []*Error
+}
+
+func (e *executor) errorf(path []any, format string, args ...any) {
+	e.errs = append(e.errs, &Error{Message: fmt.Sprintf(format, args...), Path: append([]any(nil), path...)})
+}
+
+// fail records the error a resolver returned
+func (e *executor) fail(path []any, err error) {
+	e.errorf(path, "%v", err)
+	if e.code != nil {
+		e.errs[len(e.errs)-1].Extensions = map[string]any{"code": e.code(err)}
+	}
+}
+
+// object resolves the selected fields of an object. Failed reports that a
+// non-null field came back null, so the object itself must be null.
+func (e *executor) object(ctx context.Context, obj *Object, source any, sel []selection, path []any) (result *orderedMap, failed bool) {
+	result = &orderedMap{}
+	fields, err := e.collect(obj, sel, nil, map[string]bool{})
+	if err != nil {
+		e.errorf(path, "%v", err)
+		return nil, true
+	}
+	for _, key := range fields.keys {
+		group := fields.values[key].([]*field)
+		f := group[0]
+		fieldPath := append(path[:len(path):len(path)], key)
+		if f.name == "__typename" {
+			result.set(key, obj.Name)
+			continue
+		}
+		def := obj.field(f.name)
+		value, failed := e.resolve(ctx, def, source, group, fieldPath)
+		if failed && isNonNull(def.Type) {
+			return nil, true
+		}
+		result.set(key, value)
+	}
+	return result, false
+}
+
+// resolve runs a field's resolver and completes its value
+func (e *executor) resolve(ctx context.Context, def *Field, source any, group []*field, path []any) (any, bool) {
+	args, err := e.arguments(def, group[0].args)
+	if err != nil {
+		e.errorf(path, "%v", err)
+		return nil, true
+	}
+	var value any
+	if def.Resolve != nil {
+		value, err = def.Resolve(ctx, ResolveParams{Source: source, Args: args})
+	} else {
+		value, err = defaultResolve(source, def.Name)
+	}
+	if err != nil {
+		e.fail(path, err)
+		return nil, true
+	}
+	var sel []selection
+	for _, f := range group {
+		sel = append(sel, f.selection...)
+	}
+	return e.complete(ctx, def.Type, sel, value, path)
+}
+
+// complete turns a resolved value into what the response holds for type t.
+// Failed reports a null caused by an error already recorded.
+func (e *executor) complete(ctx context.Context, t Type, sel []selection, value any, path []any) (any, bool) {
+	if nn, ok := t.(*NonNull); ok {
+		v, failed := e.complete(ctx, nn.Of, sel, value, path)
+		if v == nil && !failed {
+			e.errorf(path, "cannot return null for non-nullable field")
+		}
+		return v, v == nil
+	}
+	if _, isList := t.(*List); isNil(value) && !(isList && reflect.ValueOf(value).Kind() == reflect.Slice) {
+		return nil, false
+	}
+	switch t := t.(type) {
+	case *Scalar:
+		v, err := t.Serialize(deref(value))
+		if err != nil {
+			e.errorf(path, "%v", err)
+			return nil, true
+		}
+		return v, false
+	case *List:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.errorf(path, "expected a list, got %T", value)
+			return nil, true
+		}
+		items := make([]any, rv.Len())
+		for i := range items {
+			v, failed := e.complete(ctx, t.Of, sel, rv.Index(i).Interface(), append(path[:len(path):len(path)], i))
+			if failed && isNonNull(t.Of) {
+				return nil, true
+			}
+			items[i] = v
+		}
+		return items, false
+	case *Object:
+		obj, failed := e.object(ctx, t, value, sel, path)
+		if obj == nil {
+			return nil, failed
+		}
+		return obj, false
+	}
+	e.errorf(path, "unsupported type %s", t)
+	return nil, true
+}
+
+// collect groups the selected fields by response key in order, following
+// fragments and applying @skip and @include
+func (e *executor) collect(obj *Object, sel []selection, fields *orderedMap, seen map[string]bool) (*orderedMap, error) {
+	if fields == nil {
+		fields = &orderedMap{}
+	}
+	for _, s := range sel {
+		include, err := e.included(s.directives())
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+		switch s := s.(type) {
+		case *field:
+			key := s.responseKey()
+			group, _ := fields.get(key).([]*field)
+			if len(group) > 0 && group[0].name != s.name {
+				return nil, fmt.Errorf("%s names both %s and %s; alias one of them", key, group[0].name, s.name)
+			}
+			fields.set(key, append(group, s))
+		case *fragmentSpread:
+			if seen[s.name] {
+				continue
+			}
+			seen[s.name] = true
+			if _, err := e.collect(obj, e.doc.fragments[s.name].selection, fields, seen); err != nil {
+				return nil, err
+			}
+		case *inlineFragment:
+			if _, err := e.collect(obj, s.selection, fields, seen); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fields, nil
+}
+
+// included applies @skip(if:) and @include(if:)
+func (e *executor) included(dirs []*directive) (bool, error) {
+	for _, d := range dirs {
+		var cond any
+		for _, arg := range d.args {
+			if arg.name == "if" {
+				v, err := literalValue(arg.value, e.vars)
+				if err != nil {
+					return false, err
+				}
+				cond = v
+			}
+		}
+		b, ok := cond.(bool)
+		if !ok {
+			return false, fmt.Errorf("@%s needs a Boolean if argument", d.name)
+		}
+		if d.name == "skip" && b || d.name == "include" && !b {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// arguments coerces the field's arguments to their types, applying defaults
+func (e *executor) arguments(def *Field, given []*argument) (map[string]any, error) {
+	args := map[string]any{}
+	for _, arg := range def.Args {
+		var raw any
+		present := false
+		for _, g := range given {
+			if g.name != arg.Name {
+				continue
+			}
+			v, err := literalValue(g.value, e.vars)
+			if err != nil {
+				return nil, err
+			}
+			if ref, isVar := g.value.(variableRef); isVar {
+				if _, ok := e.vars[string(ref)]; !ok {
+					break // an absent variable leaves the argument unset
+				}
+			}
+			raw, present = v, true
+		}
+		if !present && arg.Default != nil {
+			raw, present = arg.Default, true
+		}
+		if !present || raw == nil {
+			if isNonNull(arg.Type) {
+				return nil, fmt.Errorf("argument %s of type %s is required", arg.Name, arg.Type)
+			}
+			if present {
+				args[arg.Name] = nil
+			}
+			continue
+		}
+		v, err := coerce(arg.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %w", arg.Name, err)
+		}
+		args[arg.Name] = v
+	}
+	return args, nil
+}
+
+// coerce turns an argument's value into the Go value resolvers get
+func coerce(t Type, v any) (any, error) {
+	if nn, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected %s, got null", t)
+		}
+		return coerce(nn.Of, v)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *Scalar:
+		return t.Coerce(v)
+	case *List:
+		items, ok := v.([]any)
+		if !ok {
+			// A single value stands for a list of one
+			items = []any{v}
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			c, err := coerce(t.Of, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s cannot be an argument", t)
+}
+
+// literalValue turns a value in the request into plain Go values as JSON
+// decoding gives them, substituting variables
+func literalValue(v value, vars map[string]any) (any, error) {
+	switch v := v.(type) {
+	case variableRef:
+		return vars[string(v)], nil
+	case nullValue:
+		return nil, nil
+	case enumValue:
+		return string(v), nil
+	case listValue:
+		out := make([]any, len(v))
+		for i, item := range v {
+			c, err := literalValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = c
+		}
+		return out, nil
+	case objectValue:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			c, err := literalValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = c
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// defaultResolve reads a field from its parent: a map key, or the struct
+// field whose json name is the field's name in snake_case
+func defaultResolve(source any, name string) (any, error) {
+	if source == nil {
+		return nil, nil
+	}
+	if m, ok := source.(map[string]any); ok {
+		return m[name], nil
+	}
+	rv := reflect.ValueOf(source)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot read %s from %T", name, source)
+	}
+	if fv, ok := structField(rv, snakeCase(name)); ok {
+		return fv.Interface(), nil
+	}
+	return nil, fmt.Errorf("%T has no field %s", source, name)
+}
+
+// structField finds the field with a json name, looking into embedded structs
+func structField(rv reflect.Value, jsonName string) (reflect.Value, bool) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if sf.Anonymous && tag == "" {
+			inner := rv.Field(i)
+			if inner.Kind() == reflect.Pointer {
+				if inner.IsNil() {
+					continue
+				}
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				if fv, ok := structField(inner, jsonName); ok {
+					return fv, true
+				}
+			}
+			continue
+		}
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		if tag == jsonName || tag == "" && strings.EqualFold(sf.Name, strings.ReplaceAll(jsonName, "_", "")) {
+			return rv.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// snakeCase turns createdAt into created_at
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isNonNull(t Type) bool {
+	_, ok := t.(*NonNull)
+	return ok
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// deref follows pointers to scalar values, except for the ones a scalar reads itself
+func deref(v any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	return rv.Interface()
+}
+
+// orderedMap is a JSON object that keeps its keys in the order of the query
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *orderedMap) get(key string) any {
+	return m.values[key]
+}
+
+func (m *orderedMap) set(key string, value any) {
+	if m.values == nil {
+		m.values = map[string]any{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
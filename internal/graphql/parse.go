@@ -0,0 +1,712 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed request: its operations and fragments
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// operation is a query or mutation
+type operation struct {
+	kind      string // query or mutation
+	name      string
+	variables []*variableDef
+	selection []selection
+}
+
+// variableDef declares a variable of an operation
+type variableDef struct {
+	name     string
+	typ      typeRef
+	defValue value // nil when there is no default
+}
+
+// typeRef is a type as written in a variable definition, such as [ID!]!
+type typeRef struct {
+	name    string   // named type; empty for a list
+	of      *typeRef // the list's element type
+	nonNull bool
+}
+
+func (t typeRef) String() string {
+	s := t.name
+	if t.of != nil {
+		s = "[" + t.of.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// fragment is a named fragment definition
+type fragment struct {
+	name      string
+	on        string
+	selection []selection
+}
+
+// selection is a field, a fragment spread, or an inline fragment
+type selection interface{ directives() []*directive }
+
+type field struct {
+	alias, name string
+	args        []*argument
+	dirs        []*directive
+	selection   []selection
+	line        int
+}
+
+type fragmentSpread struct {
+	name string
+	dirs []*directive
+	line int
+}
+
+type inlineFragment struct {
+	on        string // empty for no type condition
+	dirs      []*directive
+	selection []selection
+}
+
+func (f *field) directives() []*directive          { return f.dirs }
+func (f *fragmentSpread) directives() []*directive { return f.dirs }
+func (f *inlineFragment) directives() []*directive { return f.dirs }
+
+// responseKey is what the field is called in the response
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type argument struct {
+	name  string
+	value value
+}
+
+type directive struct {
+	name string
+	args []*argument
+}
+
+// value is a literal or a variable in the request
+type value interface{}
+
+type (
+	variableRef string
+	enumValue   string
+	listValue   []value
+	objectValue map[string]value
+	nullValue   struct{}
+)
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind int
+	text string
+	line int
+}
+
+// lexer splits a request into tokens, skipping whitespace, commas, and comments
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return token{kind: tokEOF, line: l.line}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	start, c := l.pos, l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{tokPunct, "...", l.line}, nil
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		l.pos++
+		return token{tokPunct, string(c), l.line}, nil
+	case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		for l.pos < len(l.src) && isNameByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{tokName, l.src[start:l.pos], l.line}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	return token{}, fmt.Errorf("line %d: unexpected character %q", l.line, c)
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+func (l *lexer) number() (token, error) {
+	start, kind := l.pos, tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return token{}, fmt.Errorf("line %d: invalid number", l.line)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		l.pos++
+		kind = tokFloat
+		if digits() == 0 {
+			return token{}, fmt.Errorf("line %d: invalid number", l.line)
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		kind = tokFloat
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, fmt.Errorf("line %d: invalid number", l.line)
+		}
+	}
+	return token{kind, l.src[start:l.pos], l.line}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		// The block ends at the first """ not escaped as \"""
+		end := 0
+		for {
+			i := strings.Index(l.src[l.pos+3+end:], `"""`)
+			if i < 0 {
+				return token{}, fmt.Errorf("line %d: unterminated block string", l.line)
+			}
+			end += i
+			if end == 0 || l.src[l.pos+3+end-1] != '\\' {
+				break
+			}
+			end += 3
+		}
+		text := l.src[l.pos+3 : l.pos+3+end]
+		line := l.line
+		l.line += strings.Count(text, "\n")
+		l.pos += end + 6
+		return token{tokString, blockString(text), line}, nil
+	}
+
+	var b strings.Builder
+	l.pos++
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return token{tokString, b.String(), l.line}, nil
+		case c == '\n':
+			return token{}, fmt.Errorf("line %d: unterminated string", l.line)
+		case c == '\\' && l.pos+1 < len(l.src):
+			l.pos++
+			switch e := l.src[l.pos]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 >= len(l.src) {
+					return token{}, fmt.Errorf("line %d: invalid unicode escape", l.line)
+				}
+				r, err := strconv.ParseUint(l.src[l.pos+1:l.pos+5], 16, 32)
+				if err != nil {
+					return token{}, fmt.Errorf("line %d: invalid unicode escape", l.line)
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return token{}, fmt.Errorf("line %d: invalid escape \\%c", l.line, e)
+			}
+			l.pos++
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+	return token{}, fmt.Errorf("line %d: unterminated string", l.line)
+}
+
+// blockString removes the common indentation of a block string's lines and
+// its leading and trailing blank lines
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, `\"""`, `"""`), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && (indent < 0 || len(line)-len(trimmed) < indent) {
+			indent = len(line) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		lines[i] = lines[i][min(indent, len(lines[i])):]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parser reads a document from the lexer's tokens, one token ahead
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lex: &lexer{src: src, line: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selection: sel})
+		case p.tok.kind == tokName && (p.tok.text == "query" || p.tok.text == "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.tok.kind == tokName && p.tok.text == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.fragments[f.name] != nil {
+				return nil, fmt.Errorf("fragment %s is defined more than once", f.name)
+			}
+			doc.fragments[f.name] = f
+		case p.tok.kind == tokName && p.tok.text == "subscription":
+			return nil, fmt.Errorf("line %d: subscriptions are not supported; use /api/events", p.tok.line)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("line %d: unexpected end of document", p.tok.line)
+	}
+	return fmt.Errorf("line %d: unexpected %q", p.tok.line, p.tok.text)
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.text}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.name = p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.peek(")") {
+			def, err := p.variableDef()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selection = sel
+	return op, nil
+}
+
+func (p *parser) variableDef() (*variableDef, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+	def := &variableDef{name: name, typ: typ}
+	if p.peek("=") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if def.defValue, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+func (p *parser) typeRef() (typeRef, error) {
+	var t typeRef
+	if p.peek("[") {
+		if err := p.advance(); err != nil {
+			return t, err
+		}
+		of, err := p.typeRef()
+		if err != nil {
+			return t, err
+		}
+		t.of = &of
+		if err := p.expect("]"); err != nil {
+			return t, err
+		}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return t, err
+		}
+		t.name = name
+	}
+	if p.peek("!") {
+		t.nonNull = true
+		return t, p.advance()
+	}
+	return t, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokName || p.tok.text != "on" {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	on, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &fragment{name: name, on: on, selection: sel}, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sel []selection
+	for !p.peek("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, s)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("line %d: empty selection set", p.tok.line)
+	}
+	return sel, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	line := p.tok.line
+	if p.peek("...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.text != "on" {
+			name := p.tok.text
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			dirs, err := p.directives()
+			if err != nil {
+				return nil, err
+			}
+			return &fragmentSpread{name: name, dirs: dirs, line: line}, nil
+		}
+		inline := &inlineFragment{}
+		if p.tok.kind == tokName {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			on, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			inline.on = on
+		}
+		var err error
+		if inline.dirs, err = p.directives(); err != nil {
+			return nil, err
+		}
+		if inline.selection, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+		return inline, nil
+	}
+
+	f := &field{line: line}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f.name = name
+	if p.peek(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		f.alias = name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if f.args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	if f.dirs, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.selection, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments() ([]*argument, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []*argument
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(false)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &argument{name: name, value: v})
+	}
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var dirs []*directive
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d := &directive{name: name}
+		if p.peek("(") {
+			if d.args, err = p.arguments(); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// value reads a literal; constant ones, such as defaults, may not hold variables
+func (p *parser) value(constant bool) (value, error) {
+	tok := p.tok
+	switch {
+	case p.peek("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return variableRef(name), err
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := listValue{}
+		for !p.peek("]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		obj := objectValue{}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if obj[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.advance()
+	case tok.kind == tokInt:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s is out of range", tok.line, tok.text)
+		}
+		return n, p.advance()
+	case tok.kind == tokFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid number %s", tok.line, tok.text)
+		}
+		return f, p.advance()
+	case tok.kind == tokString:
+		return tok.text, p.advance()
+	case tok.kind == tokName:
+		var v value
+		switch tok.text {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nullValue{}
+		default:
+			v = enumValue(tok.text)
+		}
+		return v, p.advance()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,236 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// describe writes a parsed document compactly, so tests can compare it to
+// the query they expect it to stand for
+func describe(doc *document) string {
+	var b strings.Builder
+	for _, op := range doc.operations {
+		b.WriteString(op.kind)
+		if op.name != "" {
+			b.WriteString(" " + op.name)
+		}
+		if len(op.variables) > 0 {
+			defs := make([]string, len(op.variables))
+			for i, def := range op.variables {
+				defs[i] = "$" + def.name + ":" + def.typ.String()
+				if def.defValue != nil {
+					defs[i] += "=" + describeValue(def.defValue)
+				}
+			}
+			b.WriteString("(" + strings.Join(defs, ",") + ")")
+		}
+		b.WriteString(describeSelection(op.selection))
+	}
+	names := make([]string, 0, len(doc.fragments))
+	for name := range doc.fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := doc.fragments[name]
+		b.WriteString(" fragment " + f.name + " on " + f.on + describeSelection(f.selection))
+	}
+	return b.String()
+}
+
+func describeSelection(sel []selection) string {
+	items := make([]string, len(sel))
+	for i, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			items[i] = s.name
+			if s.alias != "" {
+				items[i] = s.alias + ":" + s.name
+			}
+			items[i] += describeArgs(s.args) + describeDirectives(s.dirs)
+			if len(s.selection) > 0 {
+				items[i] += describeSelection(s.selection)
+			}
+		case *fragmentSpread:
+			items[i] = "..." + s.name + describeDirectives(s.dirs)
+		case *inlineFragment:
+			items[i] = "..."
+			if s.on != "" {
+				items[i] += " on " + s.on
+			}
+			items[i] += describeDirectives(s.dirs) + describeSelection(s.selection)
+		}
+	}
+	return "{" + strings.Join(items, " ") + "}"
+}
+
+func describeArgs(args []*argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+	items := make([]string, len(args))
+	for i, a := range args {
+		items[i] = a.name + ":" + describeValue(a.value)
+	}
+	return "(" + strings.Join(items, ",") + ")"
+}
+
+func describeDirectives(dirs []*directive) string {
+	var s string
+	for _, d := range dirs {
+		s += "@" + d.name + describeArgs(d.args)
+	}
+	return s
+}
+
+func describeValue(v value) string {
+	switch v := v.(type) {
+	case variableRef:
+		return "$" + string(v)
+	case enumValue:
+		return string(v)
+	case nullValue:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case listValue:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = describeValue(item)
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	case objectValue:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = k + ":" + describeValue(v[k])
+		}
+		return "{" + strings.Join(items, ",") + "}"
+	}
+	return fmt.Sprintf("%T(%v)", v, v)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "shorthand query",
+			query: "{ projects { name services { name } } }",
+			want:  "query{projects{name services{name}}}",
+		},
+		{
+			name:  "aliases",
+			query: `{ shop: project(id: 1) { name } blog: project(id: "2") { title: name } }`,
+			want:  `query{shop:project(id:int64(1)){name} blog:project(id:"2"){title:name}}`,
+		},
+		{
+			name:  "variables with defaults",
+			query: `query Services($id: ID!, $ids: [ID!]! = ["1", "2"], $limit: Int = 20, $on: Boolean) { service(id: $id) { deployments(limit: $limit) { id } } }`,
+			want:  `query Services($id:ID!,$ids:[ID!]!=["1","2"],$limit:Int=int64(20),$on:Boolean){service(id:$id){deployments(limit:$limit){id}}}`,
+		},
+		{
+			name: "fragments",
+			query: `query {
+				project(id: 1) { ...ProjectFields ... on Project { id } ... @include(if: $more) { notes } }
+			}
+			fragment ProjectFields on Project { name services { ...ServiceFields @skip(if: false) } }
+			fragment ServiceFields on Service { name }`,
+			want: `query{project(id:int64(1)){...ProjectFields ... on Project{id} ...@include(if:$more){notes}}}` +
+				` fragment ProjectFields on Project{name services{...ServiceFields@skip(if:bool(false))}}` +
+				` fragment ServiceFields on Service{name}`,
+		},
+		{
+			name:  "mutation",
+			query: `mutation Restart($id: ID!) @tag { restartService(id: $id) { status } }`,
+			want:  `mutation Restart($id:ID!){restartService(id:$id){status}}`,
+		},
+		{
+			name:  "several operations",
+			query: `query A { stats { uptime } } query B { projects { id } }`,
+			want:  `query A{stats{uptime}}query B{projects{id}}`,
+		},
+		{
+			name:  "literals",
+			query: `{ f(a: [1, -2.5, 3e2], o: {x: ENUM, y: null, z: {}}, t: true, s: "tab\there é \"q\"") }`,
+			want:  `query{f(a:[int64(1),float64(-2.5),float64(300)],o:{x:ENUM,y:null,z:{}},t:bool(true),s:"tab\there é \"q\"")}`,
+		},
+		{
+			name:  "block string",
+			query: "{ f(s: \"\"\"\n    first\n      indented\n    \\\"\"\" quoted\n  \"\"\") }",
+			want:  `query{f(s:"first\n  indented\n\"\"\" quoted")}`,
+		},
+		{
+			name:  "commas and comments",
+			query: "# list everything\n{ projects, { id, name } # the names\n }",
+			want:  "query{projects{id name}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.query)
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if got := describe(doc); got != tt.want {
+				t.Errorf("parse() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"", "the document has no operation"},
+		{"# only a comment", "the document has no operation"},
+		{"fragment F on Project { name }", "the document has no operation"},
+		{"{ }", "empty selection set"},
+		{"{ projects { name }", "unexpected end of document"},
+		{"{ project(id: ) { name } }", `unexpected ")"`},
+		{"{ project(id 1) { name } }", `unexpected "1"`},
+		{"query ($id ID) { a }", `unexpected "ID"`},
+		{"query ($id: [ID) { a }", `unexpected ")"`},
+		{"query ($id: ID = $other) { a }", `unexpected "$"`},
+		{"subscription { events }", "subscriptions are not supported"},
+		{"{ a: { b } }", `unexpected "{"`},
+		{"fragment F { name } { a }", `unexpected "{"`},
+		{"fragment F on P { a } fragment F on P { b } { a }", "fragment F is defined more than once"},
+		{"{ ... on { a } }", `unexpected "{"`},
+		{"{ a @ { b } }", `unexpected "{"`},
+		{`{ a(s: "open) }`, "unterminated string"},
+		{"{ a(s: \"two\nlines\") }", "unterminated string"},
+		{`{ a(s: """open) }`, "unterminated block string"},
+		{`{ a(s: "\q") }`, `invalid escape \q`},
+		{`{ a(s: "\u12") }`, "invalid unicode escape"},
+		{"{ a(n: 1.) }", "invalid number"},
+		{"{ a(n: 1e) }", "invalid number"},
+		{"{ a(n: -) }", "invalid number"},
+		{"{ a(n: 99999999999999999999) }", "99999999999999999999 is out of range"},
+		{"{ a } %", `unexpected character '%'`},
+		{"{\n  a\n  b(\n}", `line 4: unexpected "}"`},
+		{"{ a } garbage", `unexpected "garbage"`},
+	}
+
+	for _, tt := range tests {
+		doc, err := parse(tt.query)
+		if err == nil {
+			t.Errorf("parse(%q) = %s, want error %q", tt.query, describe(doc), tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parse(%q) error = %q, want %q", tt.query, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is a GraphQL output or argument type: a *Scalar, an *Object, or a
+// list or non-null wrapper of one
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Coerce turns an argument or variable into the Go
+// value resolvers get; Serialize turns a resolved value into JSON.
+type Scalar struct {
+	Name        string
+	Description string
+	Coerce      func(v any) (any, error)
+	Serialize   func(v any) (any, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Object is a type with fields. Fields may be set after the object is
+// created, so that objects can refer to each other.
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+func (o *Object) String() string { return o.Name }
+
+// field returns the object's field called name, or nil
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// List is a list of another type
+type List struct{ Of Type }
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+// NonNull is another type that is never null
+type NonNull struct{ Of Type }
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// ListOf returns a list of t
+func ListOf(t Type) *List { return &List{Of: t} }
+
+// NonNullOf returns t made non-null
+func NonNullOf(t Type) *NonNull { return &NonNull{Of: t} }
+
+// Field is a field of an object. Without Resolve, the field is read from
+// the parent value: a map key, or the struct field whose json name is the
+// field's name in snake_case (createdAt reads created_at). A nil slice is
+// an empty list.
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Arg
+	Resolve     ResolveFunc
+}
+
+// Arg is an argument of a field; Default is used when it is not given
+type Arg struct {
+	Name        string
+	Description string
+	Type        Type
+	Default     any
+}
+
+// ResolveFunc returns the value of a field
+type ResolveFunc func(ctx context.Context, p ResolveParams) (any, error)
+
+// ResolveParams is what a resolver gets: the value of the parent object and
+// the field's arguments, coerced to their types
+type ResolveParams struct {
+	Source any
+	Args   map[string]any
+}
+
+// Schema is the root types of an API; Mutation may be nil
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+}
+
+// Built-in scalars
+var (
+	Int = &Scalar{Name: "Int", Description: "A signed 32-bit integer",
+		Coerce: func(v any) (any, error) {
+			n, ok := toInt(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("%v is not an Int", v)
+			}
+			return int(n), nil
+		},
+		Serialize: func(v any) (any, error) {
+			if n, ok := toInt(v); ok {
+				return n, nil
+			}
+			return nil, fmt.Errorf("%v is not an Int", v)
+		}}
+	Float = &Scalar{Name: "Float", Description: "A double-precision number",
+		Coerce: func(v any) (any, error) {
+			if f, ok := toFloat(v); ok {
+				return f, nil
+			}
+			return nil, fmt.Errorf("%v is not a Float", v)
+		},
+		Serialize: func(v any) (any, error) {
+			if f, ok := toFloat(v); ok {
+				return f, nil
+			}
+			return nil, fmt.Errorf("%v is not a Float", v)
+		}}
+	String = &Scalar{Name: "String", Description: "UTF-8 text",
+		Coerce: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("%v is not a String", v)
+		},
+		Serialize: func(v any) (any, error) {
+			switch s := v.(type) {
+			case string:
+				return s, nil
+			case fmt.Stringer:
+				return s.String(), nil
+			}
+			return fmt.Sprint(v), nil
+		}}
+	Boolean = &Scalar{Name: "Boolean", Description: "true or false",
+		Coerce: func(v any) (any, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("%v is not a Boolean", v)
+		},
+		Serialize: func(v any) (any, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("%v is not a Boolean", v)
+		}}
+	// ID is given to resolvers as an int64, as every Servio ID is one, and
+	// written as a string
+	ID = &Scalar{Name: "ID", Description: "A numeric identifier, written as a string",
+		Coerce: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+					return n, nil
+				}
+			} else if n, ok := toInt(v); ok {
+				return n, nil
+			}
+			return nil, fmt.Errorf("%v is not an ID", v)
+		},
+		Serialize: func(v any) (any, error) {
+			if n, ok := toInt(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("%v is not an ID", v)
+		}}
+	// Time is written in RFC 3339
+	Time = &Scalar{Name: "Time", Description: "A time in RFC 3339",
+		Coerce: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				if t, err := time.Parse(time.RFC3339, s); err == nil {
+					return t, nil
+				}
+			}
+			return nil, fmt.Errorf("%v is not an RFC 3339 time", v)
+		},
+		Serialize: func(v any) (any, error) {
+			switch t := v.(type) {
+			case time.Time:
+				return t.Format(time.RFC3339), nil
+			case *time.Time:
+				return t.Format(time.RFC3339), nil
+			}
+			return nil, fmt.Errorf("%v is not a time", v)
+		}}
+)
+
+func toInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint32:
+		return int64(n), true
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	if n, ok := toInt(v); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// SDL writes the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	var objects []*Object
+	var scalars []*Scalar
+	var visit func(t Type)
+	visit = func(t Type) {
+		switch t := t.(type) {
+		case *List:
+			visit(t.Of)
+		case *NonNull:
+			visit(t.Of)
+		case *Scalar:
+			if !slices.Contains(scalars, t) {
+				scalars = append(scalars, t)
+			}
+		case *Object:
+			if slices.Contains(objects, t) {
+				return
+			}
+			objects = append(objects, t)
+			for _, f := range t.Fields {
+				visit(f.Type)
+				for _, a := range f.Args {
+					visit(a.Type)
+				}
+			}
+		}
+	}
+	visit(s.Query)
+	if s.Mutation != nil {
+		visit(s.Mutation)
+	}
+
+	description := func(text, indent string) {
+		if text != "" {
+			fmt.Fprintf(&b, "%s\"\"\"%s\"\"\"\n", indent, text)
+		}
+	}
+	for _, sc := range scalars {
+		switch sc {
+		case Int, Float, String, Boolean, ID:
+			continue
+		}
+		description(sc.Description, "")
+		fmt.Fprintf(&b, "scalar %s\n\n", sc.Name)
+	}
+	for _, o := range objects {
+		description(o.Description, "")
+		fmt.Fprintf(&b, "type %s {\n", o.Name)
+		for _, f := range o.Fields {
+			description(f.Description, "  ")
+			fmt.Fprintf(&b, "  %s", f.Name)
+			if len(f.Args) > 0 {
+				args := make([]string, len(f.Args))
+				for i, a := range f.Args {
+					args[i] = a.Name + ": " + a.Type.String()
+					if a.Default != nil {
+						args[i] += fmt.Sprintf(" = %v", a.Default)
+					}
+				}
+				fmt.Fprintf(&b, "(%s)", strings.Join(args, ", "))
+			}
+			fmt.Fprintf(&b, ": %s\n", f.Type)
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,119 @@
+package graphql
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScalarCoerce(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		scalar  *Scalar
+		in      any
+		want    any
+		wantErr bool
+	}{
+		{scalar: Int, in: int64(42), want: 42},
+		{scalar: Int, in: float64(42), want: 42}, // JSON variables
+		{scalar: Int, in: float64(4.5), wantErr: true},
+		{scalar: Int, in: int64(math.MaxInt32 + 1), wantErr: true},
+		{scalar: Int, in: "42", wantErr: true},
+		{scalar: Float, in: int64(2), want: 2.0},
+		{scalar: Float, in: "2", wantErr: true},
+		{scalar: String, in: "shop", want: "shop"},
+		{scalar: String, in: int64(1), wantErr: true},
+		{scalar: Boolean, in: true, want: true},
+		{scalar: Boolean, in: "true", wantErr: true},
+		{scalar: ID, in: "12", want: int64(12)},
+		{scalar: ID, in: int64(12), want: int64(12)},
+		{scalar: ID, in: float64(12), want: int64(12)},
+		{scalar: ID, in: "shop", wantErr: true},
+		{scalar: ID, in: true, wantErr: true},
+		{scalar: Time, in: "2026-03-01T12:00:00Z", want: at},
+		{scalar: Time, in: "2026-03-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.scalar.Coerce(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s.Coerce(%#v) = %#v, want an error", tt.scalar, tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.Coerce(%#v) = %#v, %v; want %#v", tt.scalar, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestScalarSerialize(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	tests := []struct {
+		scalar  *Scalar
+		in      any
+		want    any
+		wantErr bool
+	}{
+		{scalar: Int, in: 8080, want: int64(8080)},
+		{scalar: Int, in: "8080", wantErr: true},
+		{scalar: Float, in: float32(0.5), want: 0.5},
+		{scalar: String, in: "running", want: "running"},
+		{scalar: String, in: 3, want: "3"},
+		{scalar: Boolean, in: false, want: false},
+		{scalar: ID, in: int64(7), want: "7"},
+		{scalar: ID, in: "abc", want: "abc"},
+		{scalar: ID, in: 1.5, wantErr: true},
+		{scalar: Time, in: at, want: "2026-03-01T12:00:00+01:00"},
+		{scalar: Time, in: &at, want: "2026-03-01T12:00:00+01:00"},
+		{scalar: Time, in: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.scalar.Serialize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s.Serialize(%#v) = %#v, want an error", tt.scalar, tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.Serialize(%#v) = %#v, %v; want %#v", tt.scalar, tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSDL(t *testing.T) {
+	item := &Object{Name: "Item", Description: "A thing", Fields: []*Field{
+		{Name: "id", Type: NonNullOf(ID)},
+		{Name: "at", Type: Time},
+	}}
+	item.Fields = append(item.Fields, &Field{Name: "parent", Type: item})
+	schema := &Schema{Query: &Object{Name: "Query", Fields: []*Field{
+		{Name: "items", Type: NonNullOf(ListOf(NonNullOf(item))),
+			Args: []*Arg{{Name: "limit", Type: Int, Default: 20}, {Name: "after", Type: ID}}},
+	}}}
+
+	want := strings.Join([]string{
+		`"""A time in RFC 3339"""`,
+		"scalar Time",
+		"",
+		"type Query {",
+		"  items(limit: Int = 20, after: ID): [Item!]!",
+		"}",
+		"",
+		`"""A thing"""`,
+		"type Item {",
+		"  id: ID!",
+		"  at: Time",
+		"  parent: Item",
+		"}",
+		"",
+	}, "\n")
+	if got := schema.SDL(); got != want {
+		t.Errorf("SDL() =\n%s\nwant\n%s", got, want)
+	}
+}
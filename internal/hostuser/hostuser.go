@@ -0,0 +1,102 @@
+// Package hostuser provisions the system accounts services run as. A service
+// whose User does not exist on the host can have it created as a locked
+// system account, with its working directory as home and owned by it.
+package hostuser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"strings"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+)
+
+// ErrInvalidName is returned for names useradd would reject, or could take for an option
+var ErrInvalidName = errors.New("invalid user name")
+
+// namePattern is the portable user name useradd accepts by default
+var namePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// shells are tried in order for the login shell of new accounts
+var shells = []string{"/usr/sbin/nologin", "/sbin/nologin", "/bin/false"}
+
+// Exists reports whether a user of that name exists on this host
+func Exists(name string) bool {
+	_, err := user.Lookup(name)
+	return err == nil
+}
+
+// Needed reports whether a service running as name would need its user
+// created: root and the default "" never do
+func Needed(name string) bool {
+	return name != "" && name != "root" && !Exists(name)
+}
+
+// HomeDir is the home a new account gets: the service's working directory,
+// or /var/lib/<name> when it has none
+func HomeDir(name, workingDir string) string {
+	if workingDir == "" || workingDir == "/" {
+		return "/var/lib/" + name
+	}
+	return workingDir
+}
+
+// Create adds name as a system account with its own group, no login shell,
+// and a locked password (useradd leaves system accounts without one), then
+// creates home and hands everything in it to the account. A dry run records
+// the commands instead.
+func Create(ctx context.Context, name, home string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	args := []string{"useradd", "--system", "--user-group", "--no-create-home", "--home-dir", home, "--shell", shell(), name}
+	if output, err := audit.Run(ctx, audit.CategoryUser, "create-user", exec.CommandContext(ctx, args[0], args[1:]...)); err != nil {
+		return fmt.Errorf("failed to create user %s: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(home, 0755)
+	} else if err := os.MkdirAll(home, 0755); err != nil {
+		return fmt.Errorf("failed to create home directory %s: %w", home, err)
+	}
+	chown := exec.CommandContext(ctx, "chown", "-R", name+":"+name, "--", home)
+	if output, err := audit.Run(ctx, audit.CategoryUser, "chown-home", chown); err != nil {
+		return fmt.Errorf("failed to give %s to %s: %w: %s", home, name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CreateDir creates a service's missing working directory and, unless the
+// service runs as root, hands it to the service's user. A dry run records
+// the commands instead.
+func CreateDir(ctx context.Context, dir, owner string) error {
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(dir, 0755)
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create working directory %s: %w", dir, err)
+	}
+	if owner == "" || owner == "root" {
+		return nil
+	}
+	chown := exec.CommandContext(ctx, "chown", owner+":", "--", dir)
+	if output, err := audit.Run(ctx, audit.CategoryUser, "chown-dir", chown); err != nil {
+		return fmt.Errorf("failed to give %s to %s: %w: %s", dir, owner, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// shell returns the first no-login shell this host has
+func shell() string {
+	for _, path := range shells {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return shells[0]
+}
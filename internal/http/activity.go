@@ -0,0 +1,132 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"servio/internal/events"
+	"servio/internal/notify"
+	"servio/internal/storage"
+)
+
+const (
+	// activityPageSize is the page size of /api/activity without ?limit=
+	activityPageSize = 50
+	// dashboardActivityPageSize is how many entries a page of the dashboard's feed shows
+	dashboardActivityPageSize = 10
+)
+
+// activityItem is an activity feed entry with a line describing it
+type activityItem struct {
+	*storage.Activity
+	Summary string `json:"summary"`
+}
+
+// activityFeed is a page of the dashboard's activity feed
+type activityFeed struct {
+	Items  []activityItem
+	Total  int
+	Offset int
+	From   int // position of the first entry shown, counting from 1
+	To     int // position of the last
+	Prev   int // offset of the newer page, when Offset > 0
+	Next   int // offset of the older page, when More
+	More   bool
+	Error  string
+}
+
+// handleAPIActivity lists recent host actions and events, newest first
+// GET /api/activity?project_id=1&service_id=2&source=event&limit=50&offset=0
+func (s *Server) handleAPIActivity(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Limit == 0 {
+		opts.Limit = activityPageSize
+	}
+	q := r.URL.Query()
+	filter := storage.ActivityFilter{Source: q.Get("source"), Limit: opts.Limit, Offset: opts.Offset}
+	for name, dst := range map[string]*int64{"project_id": &filter.ProjectID, "service_id": &filter.ServiceID} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				jsonError(w, r, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = id
+		}
+	}
+
+	items, total, err := s.listActivity(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	setPaginationHeaders(w, total, opts)
+	jsonList(w, r, items)
+}
+
+// handleActivity renders a page of the dashboard's activity feed for htmx;
+// outside htmx it redirects to the dashboard showing that page
+// GET /activity?offset=10
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if !isHTMX(r) {
+		http.Redirect(w, r, basePath+"/?activity_offset="+strconv.Itoa(max(offset, 0)), http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	renderPartial(w, r, "dashboard.html", "activity-feed", s.activityFeed(r.Context(), offset))
+}
+
+// activityFeed loads the page of the dashboard's feed starting at offset
+func (s *Server) activityFeed(ctx context.Context, offset int) *activityFeed {
+	offset = max(offset, 0)
+	feed := &activityFeed{Offset: offset}
+	items, total, err := s.listActivity(ctx, storage.ActivityFilter{Limit: dashboardActivityPageSize, Offset: offset})
+	if err != nil {
+		feed.Error = err.Error()
+		return feed
+	}
+	feed.Items, feed.Total = items, total
+	feed.From, feed.To = offset+1, offset+len(items)
+	feed.Prev = max(offset-dashboardActivityPageSize, 0)
+	feed.Next = offset + dashboardActivityPageSize
+	feed.More = feed.Next < total
+	return feed
+}
+
+// listActivity reads a page of the feed and describes each entry
+func (s *Server) listActivity(ctx context.Context, filter storage.ActivityFilter) ([]activityItem, int, error) {
+	activity, total, err := s.store.ListActivity(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	items := make([]activityItem, 0, len(activity))
+	for _, a := range activity {
+		items = append(items, activityItem{Activity: a, Summary: activitySummary(a)})
+	}
+	return items, total, nil
+}
+
+// activitySummary describes an entry in one line: an action's command, or
+// an event's text as notification channels send it
+func activitySummary(a *storage.Activity) string {
+	if a.Source == storage.ActivityAction {
+		if a.Command == "" {
+			return a.Type + " " + a.Action
+		}
+		return a.Command
+	}
+	text, _ := notify.Describe(events.Event{
+		Type:      a.Type,
+		Time:      a.Time,
+		ProjectID: a.ProjectID,
+		ServiceID: a.ServiceID,
+		Data:      a.Data,
+	}, "", a.Service)
+	return text
+}
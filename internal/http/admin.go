@@ -0,0 +1,26 @@
+package http
+
+import (
+	"net/http"
+)
+
+// handleAPIIntegrity runs integrity and foreign key checks
+// GET /api/admin/integrity
+func (s *Server) handleAPIIntegrity(w http.ResponseWriter, r *http.Request) {
+	s.writeIntegrityReport(w, r, false)
+}
+
+// handleAPIIntegrityRepair runs the checks and deletes orphaned rows
+// POST /api/admin/integrity/repair
+func (s *Server) handleAPIIntegrityRepair(w http.ResponseWriter, r *http.Request) {
+	s.writeIntegrityReport(w, r, true)
+}
+
+func (s *Server) writeIntegrityReport(w http.ResponseWriter, r *http.Request, repair bool) {
+	report, err := s.store.CheckIntegrity(r.Context(), repair)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, report)
+}
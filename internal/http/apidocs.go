@@ -0,0 +1,436 @@
+package http
+
+import (
+	"net/http"
+
+	"servio/internal/agent"
+	"servio/internal/ansi"
+	"servio/internal/blueprints"
+	"servio/internal/doctor"
+	"servio/internal/envfile"
+	"servio/internal/graphql"
+	"servio/internal/logship"
+	"servio/internal/openapi"
+	"servio/internal/postgres"
+	"servio/internal/redis"
+	"servio/internal/stacks"
+	"servio/internal/storage"
+)
+
+// Shared parameter documentation
+var (
+	listParams = []openapi.Param{
+		{Name: "limit", Type: "integer", Description: "Maximum number of items (capped at 500)"},
+		{Name: "offset", Type: "integer", Description: "Number of items to skip"},
+		{Name: "sort", Description: "Sort column, prefix with - for descending (e.g. -created_at)"},
+		{Name: "fields", Description: "Comma-separated list of fields to include"},
+		{Name: "type", Description: "Service type (projects: having a service of this type)"},
+		{Name: "tag", Description: "Only items carrying this tag"},
+		{Name: "status", Description: "running, stopped, or not-installed (projects: having a service in this state)"},
+	}
+	limitParam        = openapi.Param{Name: "limit", Type: "integer", Description: "Maximum number of items"}
+	exportFormatParam = openapi.Param{Name: "format", Description: "csv or json (default)"}
+	dryRunParam       = openapi.Param{Name: "dry_run", Type: "boolean", Description: "Respond with the files and commands this would change ({dry_run, actions}) without changing anything; the X-Dry-Run header works too"}
+)
+
+// logFilterParam documents the repeatable filter parameter of log endpoints
+var logFilterParam = openapi.Param{Name: "filter", Description: "key=value matched against each line's level (error, warn, info, debug) or JSON fields, ignoring case; repeat to require several, as in filter=level=error&filter=request_id=abc. Dotted keys reach nested fields"}
+
+// nginxLogQueryParam documents searching nginx logs
+var nginxLogQueryParam = openapi.Param{Name: "q", Description: "Keep only lines containing this text, ignoring case"}
+
+// artifactParams documents narrowing the generated files kept in the database
+var artifactParams = []openapi.Param{
+	{Name: "project_id", Type: "integer"}, {Name: "service_id", Type: "integer"},
+	{Name: "category", Description: "systemd, nginx, or env"},
+}
+
+// lastEventIDParam documents resuming a stream; EventSource sends the Last-Event-ID header instead
+var lastEventIDParam = openapi.Param{Name: "last_event_id", Description: "Resume after this event ID, as the Last-Event-ID header does when EventSource reconnects"}
+
+// ansiParam documents the ansi parameter of log endpoints with its default
+func ansiParam(def string) openapi.Param {
+	return openapi.Param{Name: "ansi", Description: "Escape codes: strip, html (ansi-* classed spans), or raw (default " + def + ")"}
+}
+
+// apiRoutes documents every /api endpoint. Keep it in step with registerRoutes;
+// body schemas are generated from the listed types.
+var apiRoutes = []openapi.Route{
+	// Projects
+	{Method: http.MethodGet, Path: "/api/projects", Tag: "projects", Summary: "List projects", Params: listParams, Response: []*storage.Project{}},
+	{Method: http.MethodPost, Path: "/api/projects", Tag: "projects", Summary: "Create a project", Request: storage.CreateProjectRequest{}, Response: storage.Project{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/stacks", Tag: "projects", Summary: "List the stack templates a project can be created from", Response: []stacks.Template{}},
+	{Method: http.MethodPost, Path: "/api/stacks/{name}", Tag: "projects", Summary: "Create a project with a stack's services, ports, shared environment, and nginx routes, and queue a stack job installing them",
+		Request: stackRequest{}, Response: stackResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/projects/{id}", Tag: "projects", Summary: "Get a project with its services", Response: storage.Project{}},
+	{Method: http.MethodPut, Path: "/api/projects/{id}", Tag: "projects", Summary: "Update a project", Request: storage.UpdateProjectRequest{}, Response: storage.Project{}},
+	{Method: http.MethodPatch, Path: "/api/projects/{id}", Tag: "projects", Summary: "Change only the fields present in the body", Request: storage.PatchProjectRequest{}, Response: storage.Project{}},
+	{Method: http.MethodDelete, Path: "/api/projects/{id}", Tag: "projects", Summary: "Delete a project, uninstalling its services", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+
+	{Method: http.MethodPost, Path: "/api/projects/{id}/start", Tag: "projects", Summary: "Start all services in dependency order", Response: serviceActionResponse{}},
+	{Method: http.MethodPost, Path: "/api/projects/{id}/stop", Tag: "projects", Summary: "Stop all services, dependents first", Response: serviceActionResponse{}},
+	{Method: http.MethodPost, Path: "/api/projects/{id}/restart", Tag: "projects", Summary: "Restart all services in dependency order", Response: serviceActionResponse{}},
+	{Method: http.MethodPost, Path: "/api/projects/{id}/clone", Tag: "projects", Summary: "Copy the project and its services under a new name with fresh ports, optionally on another git branch, and queue a job installing the copies",
+		Request: cloneRequest{}, Response: cloneResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/api/projects/{id}/team", Tag: "projects", Summary: "Assign the project to a team (admin only)", Request: projectTeamRequest{}, Response: storage.Project{}},
+	{Method: http.MethodPut, Path: "/api/projects/{id}/host", Tag: "projects", Summary: "Run the project on an agent host, or on this server with host_id 0 (admin only)", Request: projectHostRequest{}, Response: storage.Project{}},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/logs/stream", Tag: "projects", Summary: "Stream the logs of all the project's services in time order, each event a JSON line labelled with its unit (Server-Sent Events)",
+		Params: []openapi.Param{{Name: "lines", Type: "integer", Description: "Recent lines to start with (default 100, at most 1000)"}, ansiParam(ansi.ModeStrip), logFilterParam, lastEventIDParam}, Stream: "text/event-stream"},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/cron-jobs", Tag: "projects", Summary: "List the project's cron jobs with their next five runs", Response: []*storage.CronJob{}},
+	{Method: http.MethodPost, Path: "/api/projects/{id}/cron-jobs", Tag: "projects", Summary: "Run a command on a crontab schedule as a systemd timer; projects on agent hosts cannot have cron jobs",
+		Params: []openapi.Param{dryRunParam}, Request: cronJobRequest{}, Response: storage.CronJob{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/cron-jobs/{job}", Tag: "projects", Summary: "Get a cron job with its next five runs and how its last run went", Response: storage.CronJob{}},
+	{Method: http.MethodPut, Path: "/api/projects/{id}/cron-jobs/{job}", Tag: "projects", Summary: "Update a cron job and reinstall its timer; the name cannot change",
+		Params: []openapi.Param{dryRunParam}, Request: cronJobRequest{}, Response: storage.CronJob{}},
+	{Method: http.MethodDelete, Path: "/api/projects/{id}/cron-jobs/{job}", Tag: "projects", Summary: "Remove a cron job's units, stopping a run in progress", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodPost, Path: "/api/projects/{id}/cron-jobs/{job}/run", Tag: "projects", Summary: "Start a run now without waiting for it; its outcome is recorded like a scheduled run's",
+		Params: []openapi.Param{dryRunParam}, Response: statusResponse{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/cron-jobs/{job}/logs", Tag: "projects", Summary: "What the cron job's runs logged",
+		Params: []openapi.Param{{Name: "lines", Type: "integer", Description: "How many of the most recent lines to return (default 1000, at most 10000)"}}, Response: logsResponse{}},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/database-backups", Tag: "projects", Summary: "Database backups of all the project's services, newest first", Response: []*storage.DatabaseBackup{}},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/budget", Tag: "projects", Summary: "Get the project's memory and CPU budget with what its slice uses now and which services it does not bound yet", Response: projectBudgetResponse{}},
+	{Method: http.MethodPut, Path: "/api/projects/{id}/budget", Tag: "projects", Summary: "Cap the memory and CPU the project's services use together by running them in a systemd slice; running services move into it when they next restart",
+		Request: projectBudgetRequest{}, Response: projectBudgetResponse{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodDelete, Path: "/api/projects/{id}/budget", Tag: "projects", Summary: "Lift the project's budget; running services leave its slice when they next restart", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/plan", Tag: "projects", Summary: "Preview every unit, drop-in, .env file, slice, and nginx site applying the project's configuration would write or delete, as unified diffs against the host; files holding secrets are listed without a diff", Response: projectPlanResponse{}},
+	{Method: http.MethodPost, Path: "/api/projects/{id}/plan/apply", Tag: "projects", Summary: "Apply the project's plan; with the reviewed plan's fingerprint, a plan that has changed since is refused with 409, and restart restarts running services whose files changed",
+		Request: applyPlanRequest{}, Response: applyPlanResponse{}},
+
+	// Services
+	{Method: http.MethodGet, Path: "/api/services", Tag: "services", Summary: "List services, optionally of one project",
+		Params:   append([]openapi.Param{{Name: "project_id", Type: "integer"}}, listParams...),
+		Response: []*storage.Service{}},
+	{Method: http.MethodPost, Path: "/api/services", Tag: "services", Summary: "Check a service against the host, create it, and queue its install job", Request: storage.CreateServiceRequest{}, Response: serviceJobResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/service-templates", Tag: "services", Summary: "List service templates with the placeholders each needs besides service, project, and port", Response: []serviceTemplateResponse{}},
+	{Method: http.MethodPost, Path: "/api/service-templates", Tag: "services", Summary: "Create a service template; its strings may hold {{name}} placeholders",
+		Request: serviceTemplateRequest{}, Response: serviceTemplateResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/service-templates/{id}", Tag: "services", Summary: "A service template", Response: serviceTemplateResponse{}},
+	{Method: http.MethodPut, Path: "/api/service-templates/{id}", Tag: "services", Summary: "Replace a service template; services created from it are not changed",
+		Request: serviceTemplateRequest{}, Response: serviceTemplateResponse{}},
+	{Method: http.MethodDelete, Path: "/api/service-templates/{id}", Tag: "services", Summary: "Delete a service template", Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/service-templates/{id}/services", Tag: "services", Summary: "Create a service from a template, filling in its placeholders, with the template's .env variables, and queue its install job",
+		Request: templateServiceRequest{}, Response: serviceJobResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/services/{id}/template", Tag: "services", Summary: "Save a service as a template; its name, project slug, and port become placeholders, and secret .env values are not saved",
+		Request: saveTemplateRequest{}, Response: serviceTemplateResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/services/actions", Tag: "services", Summary: "Start, stop, or restart many services concurrently", Request: serviceActionRequest{}, Response: serviceActionResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}", Tag: "services", Summary: "Get a service with its runtime status", Response: storage.Service{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}", Tag: "services", Summary: "Check changed fields against the host, update a service, and queue its reinstall job", Request: storage.UpdateServiceRequest{}, Response: serviceJobResponse{}},
+	{Method: http.MethodPatch, Path: "/api/services/{id}", Tag: "services", Summary: "Change only the fields present in the body and queue a reinstall job", Request: storage.PatchServiceRequest{}, Response: serviceJobResponse{}},
+	{Method: http.MethodDelete, Path: "/api/services/{id}", Tag: "services", Summary: "Uninstall and delete a service", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/start", Tag: "services", Summary: "Start a service", Response: statusResponse{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/stop", Tag: "services", Summary: "Stop a service", Response: statusResponse{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/restart", Tag: "services", Summary: "Restart a service", Response: statusResponse{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/install", Tag: "services", Summary: "Queue a job that writes the unit file, then enables and starts the service",
+		Params: []openapi.Param{{Name: "create_user", Type: "boolean", Description: "Create the service's user as a locked system account owning its working directory when it does not exist"}, dryRunParam}, Response: serviceJobResponse{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/api/services/{id}/provision", Tag: "services", Summary: "Queue a provision job that installs the blueprint's packages, logging each command's output, then writes, enables, and starts the unit",
+		Params: []openapi.Param{{Name: "create_user", Type: "boolean", Description: "Create the service's user as a locked system account owning its working directory when it does not exist"}, dryRunParam}, Response: serviceJobResponse{}, Status: http.StatusAccepted},
+	{Method: http.MethodPost, Path: "/api/services/{id}/scale", Tag: "services", Summary: "Run the service as this many instances on sequential ports from its own, keeping it running and its project's nginx site balancing across them",
+		Params: []openapi.Param{dryRunParam}, Request: scaleRequest{}, Response: storage.Service{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/instances", Tag: "services", Summary: "The service's instances with their units, ports and states; one for a service that is not scaled", Response: []serviceInstance{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/instances/{n}/restart", Tag: "services", Summary: "Restart one instance of the service", Response: statusResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/instances/{n}/logs", Tag: "services", Summary: "The most recent log lines of one instance since it last started, with line counts per level",
+		Params: []openapi.Param{
+			{Name: "lines", Type: "integer", Description: "How many of the most recent lines to return; truncated is set when older lines were left out"},
+			{Name: "since", Description: "Start from this long ago (30m, 2h, 7d) or from an RFC 3339 time instead of the instance's last start"},
+			{Name: "order", Description: "oldest (default) or newest first"},
+			ansiParam(ansi.ModeStrip),
+		}, Response: logsResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/app-metrics", Tag: "services", Summary: "Custom metrics the service pushed over StatsD or OTLP, per minute, one series per metric",
+		Params: []openapi.Param{
+			{Name: "from", Description: "RFC 3339 start (default: an hour before to)"},
+			{Name: "to", Description: "RFC 3339 end (default: now)"},
+			{Name: "name", Description: "Only this metric"},
+		},
+		Response: appMetricsResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/logs", Tag: "services", Summary: "The most recent log lines since the service last started, with line counts per level",
+		Params: []openapi.Param{
+			{Name: "lines", Type: "integer", Description: "How many of the most recent lines to return (default 1000, at most 10000); truncated is set when older lines were left out. Filters apply to these lines"},
+			{Name: "since", Description: "Start from this long ago (30m, 2h, 7d) or from an RFC 3339 time instead of the service's last start"},
+			{Name: "order", Description: "oldest (default) or newest first"},
+			ansiParam(ansi.ModeStrip), logFilterParam,
+			{Name: "structured", Type: "boolean", Description: "Add the parsed lines as entries, each with its level and, for JSON lines, message and fields"},
+		}, Response: logsResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/logs/stream", Tag: "services", Summary: "Stream logs (Server-Sent Events); event IDs are journal cursors", Params: []openapi.Param{ansiParam(ansi.ModeStrip), logFilterParam, lastEventIDParam}, Stream: "text/event-stream"},
+	{Method: http.MethodGet, Path: "/api/services/{id}/logs/download", Tag: "services", Summary: "Download the logs since the service last started as a text file", Params: []openapi.Param{ansiParam(ansi.ModeRaw)}, Stream: "text/plain"},
+	{Method: http.MethodGet, Path: "/api/services/{id}/support-bundle", Tag: "services", Summary: "Download a .tar.gz of the service's logs, its project's nginx logs, and the generated configs for a time range, with environment values redacted",
+		Params: []openapi.Param{
+			{Name: "from", Description: "RFC 3339 start time (default 24h before to)"},
+			{Name: "to", Description: "RFC 3339 end time (default now)"},
+		}, Stream: "application/gzip"},
+	{Method: http.MethodGet, Path: "/api/services/{id}/revisions", Tag: "services", Summary: "Configuration history, newest first", Response: []*storage.ServiceRevision{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/revisions/{rev}", Tag: "services", Summary: "Get a configuration revision", Response: storage.ServiceRevision{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/revisions/{rev}/revert", Tag: "services", Summary: "Restore the configuration from a revision", Response: storage.Service{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/audit", Tag: "services", Summary: "Host actions recorded for a service",
+		Params: []openapi.Param{{Name: "category", Description: "systemd, nginx, git, container, database, env, or user"}, limitParam}, Response: []*storage.AuditEntry{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/journal-retention", Tag: "services", Summary: "Get the service's journal retention policy", Response: storage.JournalRetention{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}/journal-retention", Tag: "services", Summary: "Move the service's logs into their own journal namespace with size and age limits, applied on its next restart and enforced hourly",
+		Request: journalRetentionRequest{}, Response: storage.JournalRetention{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodDelete, Path: "/api/services/{id}/journal-retention", Tag: "services", Summary: "Return the service's logs to the system journal", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/file-logging", Tag: "services", Summary: "Get the service's file logging settings", Response: storage.FileLogging{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}/file-logging", Tag: "services", Summary: "Send the service's output to a logrotate-rotated file under /var/log/servio instead of the journal, from its next restart",
+		Request: fileLoggingRequest{}, Response: storage.FileLogging{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodDelete, Path: "/api/services/{id}/file-logging", Tag: "services", Summary: "Return the service's output to the journal; the log files are kept", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/log-forwarding", Tag: "services", Summary: "Whether the service's journal is forwarded to the log sink", Response: logForwardingResponse{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}/log-forwarding", Tag: "services", Summary: "Turn forwarding of the service's journal on or off", Request: logForwardingRequest{}, Response: logForwardingResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/log-alerts", Tag: "services", Summary: "List the service's log alerts", Response: []*storage.LogAlert{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/log-alerts", Tag: "services", Summary: "Watch the service's log for a regular expression, firing a log.alert event and recording an incident when it matches more than threshold lines within interval seconds",
+		Request: logAlertRequest{}, Response: storage.LogAlert{}, Status: http.StatusCreated},
+	{Method: http.MethodPut, Path: "/api/services/{id}/log-alerts/{alert}", Tag: "services", Summary: "Update a log alert", Request: logAlertRequest{}, Response: storage.LogAlert{}},
+	{Method: http.MethodDelete, Path: "/api/services/{id}/log-alerts/{alert}", Tag: "services", Summary: "Delete a log alert; its incidents are kept", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/services/{id}/log-incidents", Tag: "services", Summary: "Times the service's log alerts fired, newest first",
+		Params: []openapi.Param{limitParam}, Response: []*storage.LogIncident{}},
+
+	// Databases
+	{Method: http.MethodGet, Path: "/api/services/{id}/postgres/databases", Tag: "databases", Summary: "List the databases of a postgres service with their owners and sizes", Response: []postgres.Database{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/postgres/databases", Tag: "databases", Summary: "Create a database, owned by postgres unless an owner role is given",
+		Params: []openapi.Param{dryRunParam}, Request: postgresDatabaseRequest{}, Response: statusResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/services/{id}/postgres/roles", Tag: "databases", Summary: "List the roles of a postgres service", Response: []postgres.Role{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/postgres/roles", Tag: "databases", Summary: "Create a login role; its password is generated unless given, and only returned here",
+		Params: []openapi.Param{dryRunParam}, Request: postgresRoleRequest{}, Response: postgresRoleResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/services/{id}/postgres/roles/{role}/password", Tag: "databases", Summary: "Reset a role's password; it is generated unless given, and only returned here",
+		Params: []openapi.Param{dryRunParam}, Request: postgresPasswordRequest{}, Response: postgresRoleResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/postgres/backups", Tag: "databases", Summary: "The service's database backups, newest first", Response: []*storage.DatabaseBackup{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/postgres/backups", Tag: "databases", Summary: "Queue a backup job that writes a pg_dump of a database next to Servio's own database",
+		Request: postgresBackupRequest{}, Response: storage.DatabaseBackup{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/api/services/{id}/postgres/backups/{backup}/download", Tag: "databases", Summary: "Download a succeeded backup's dump, for pg_restore", Stream: "application/octet-stream"},
+	{Method: http.MethodGet, Path: "/api/services/{id}/redis/info", Tag: "databases", Summary: "A redis service's INFO by section, with its memory use, cap, and eviction policy", Response: redis.Info{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/redis/keyspace", Tag: "databases", Summary: "The databases of a redis service that hold keys, with their key and TTL counts", Response: []redis.Keyspace{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/redis/flush", Tag: "databases", Summary: "Delete every key of one database; confirm must repeat the service's name",
+		Params: []openapi.Param{dryRunParam}, Request: redisFlushRequest{}, Response: statusResponse{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}/redis/memory", Tag: "databases", Summary: "Set maxmemory and maxmemory-policy now and save them with CONFIG REWRITE; persisted is false when the config file could not be written",
+		Params: []openapi.Param{dryRunParam}, Request: redisMemoryRequest{}, Response: redisMemoryResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/worker", Tag: "services", Summary: "A Celery or Sidekiq worker's concurrency and queues, with the jobs waiting in each when its broker is a redis on this server", Response: workerResponse{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}/worker", Tag: "services", Summary: "Set a worker's concurrency and queues and queue a job that regenerates its command and restarts it gracefully, letting running jobs finish",
+		Params: []openapi.Param{dryRunParam}, Request: blueprints.WorkerSettings{}, Response: serviceJobResponse{}, Status: http.StatusAccepted},
+
+	{Method: http.MethodGet, Path: "/api/services/{id}/env", Tag: "services", Summary: "A service's managed .env variables and those it inherits from its project, secret values masked, and where the file is written", Response: envResponse{}},
+	{Method: http.MethodPut, Path: "/api/services/{id}/env/vars/{key}", Tag: "services", Summary: "Create or replace a .env variable; secret values are stored encrypted and never returned",
+		Request: envVarRequest{}, Response: storage.EnvVar{}},
+	{Method: http.MethodDelete, Path: "/api/services/{id}/env/vars/{key}", Tag: "services", Summary: "Remove a .env variable", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/projects/{id}/env", Tag: "projects", Summary: "The .env variables every service of the project inherits, secret values masked", Response: projectEnvResponse{}},
+	{Method: http.MethodPut, Path: "/api/projects/{id}/env/vars/{key}", Tag: "projects", Summary: "Create or replace a variable written to every service's .env file unless the service sets the same key; secret values are stored encrypted",
+		Request: envVarRequest{}, Response: storage.EnvVar{}},
+	{Method: http.MethodDelete, Path: "/api/projects/{id}/env/vars/{key}", Tag: "projects", Summary: "Remove a shared .env variable", Status: http.StatusNoContent},
+	{Method: http.MethodPut, Path: "/api/services/{id}/env/path", Tag: "services", Summary: "Set where the .env file is written, such as a unit's EnvironmentFile; empty for .env in the working directory",
+		Request: envPathRequest{}, Response: envResponse{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/env/sync", Tag: "services", Summary: "Write the .env file now; deploys also write it before installing the unit",
+		Params: []openapi.Param{dryRunParam}, Response: envSyncResponse{}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/env/drift", Tag: "services", Summary: "Compare the .env file on disk with the managed variables, by name only", Response: envfile.Drift{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/exec", Tag: "services", Summary: "Run a one-off command with the service's user, working directory, and environment through systemd-run; output events carry {stream, line}, then a done event carries {exit_code, duration_ms, error}",
+		Params: []openapi.Param{dryRunParam}, Request: execRequest{}, Stream: "text/event-stream"},
+
+	// Deployments
+	{Method: http.MethodGet, Path: "/api/services/{id}/deployments", Tag: "deployments", Summary: "List deployments, newest first", Params: []openapi.Param{limitParam}, Response: []*storage.Deployment{}},
+	{Method: http.MethodPost, Path: "/api/services/{id}/deployments", Tag: "deployments", Summary: "Queue a deployment job", Response: storage.Deployment{}, Status: http.StatusAccepted, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/services/{id}/deployments/{dep}", Tag: "deployments", Summary: "Get a deployment including its log", Response: storage.Deployment{}},
+	{Method: http.MethodDelete, Path: "/api/services/{id}/deployments/{dep}", Tag: "deployments", Summary: "Delete a finished deployment record", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/jobs", Tag: "jobs", Summary: "List background jobs, newest first",
+		Params: []openapi.Param{
+			{Name: "project_id", Type: "integer"}, {Name: "service_id", Type: "integer"},
+			{Name: "status", Description: "queued, running, succeeded, or failed"}, limitParam,
+		},
+		Response: []*storage.Job{}},
+	{Method: http.MethodGet, Path: "/api/jobs/{id}", Tag: "jobs", Summary: "Get a job including its log", Response: storage.Job{}},
+	{Method: http.MethodGet, Path: "/api/jobs/{id}/log", Tag: "jobs", Summary: "Download a job's log as a text file, such as a provisioning job's transcript of commands and their output", Stream: "text/plain"},
+	{Method: http.MethodPost, Path: "/api/jobs/{id}/cancel", Tag: "jobs", Summary: "Cancel a queued or running job, which then fails with \"job cancelled\"", Response: storage.Job{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/api/jobs/{id}/stream", Tag: "jobs", Summary: "Stream a job's log as server-sent events, numbered from 1", Params: []openapi.Param{lastEventIDParam}, Stream: "text/event-stream"},
+
+	// Live events
+	{Method: http.MethodGet, Path: "/api/events", Tag: "events", Summary: "Stream service, job, deploy, and nginx events as server-sent events",
+		Params: []openapi.Param{
+			{Name: "types", Description: "Comma-separated event types to include (default all)"},
+			{Name: "project_id", Description: "Only events for this project"},
+			{Name: "service_id", Description: "Only events for this service"},
+		}, Stream: "text/event-stream"},
+
+	// Nginx
+	{Method: http.MethodGet, Path: "/api/nginx/{id}/preview", Tag: "nginx", Summary: "Preview the site config for a project, check that its domain resolves to the server, and list configs and units that conflict with it", Response: nginxPreviewResponse{}},
+	{Method: http.MethodPost, Path: "/api/nginx/{id}/save", Tag: "nginx", Summary: "Save a custom site config", Request: nginxConfigRequest{}, Response: statusResponse{}},
+	{Method: http.MethodPost, Path: "/api/nginx/{id}/deploy", Tag: "nginx", Summary: "Install the site config and reload nginx; refused with dns_mismatch when the domain does not resolve to the server, unless dns_check is warn or off", Response: statusResponse{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodPost, Path: "/api/nginx/{id}/remove", Tag: "nginx", Summary: "Remove the site config", Response: statusResponse{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/nginx/{id}/certificate", Tag: "nginx", Summary: "The project's Let's Encrypt certificate", Response: &storage.Certificate{}},
+	{Method: http.MethodPost, Path: "/api/nginx/{id}/certificate", Tag: "nginx", Summary: "Queue a certificate job issuing or renewing the certificate through a DNS-01 challenge",
+		Response: &storage.Job{}, Status: http.StatusAccepted, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodDelete, Path: "/api/nginx/{id}/certificate", Tag: "nginx", Summary: "Switch the site back to HTTP and delete the certificate", Status: http.StatusNoContent, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/nginx/{id}/protocols", Tag: "nginx", Summary: "Whether the site serves HTTP/2 and HTTP/3, and what the host's nginx supports", Response: siteProtocolsResponse{}},
+	{Method: http.MethodPut, Path: "/api/nginx/{id}/protocols", Tag: "nginx", Summary: "Turn HTTP/2 and HTTP/3 on or off for the site, refused when nginx lacks the module, and rewrite an installed site", Request: siteProtocolsRequest{}, Response: siteProtocolsResponse{}},
+	{Method: http.MethodGet, Path: "/api/nginx/{id}/logs/{kind}", Tag: "nginx", Summary: "The last lines of the site's access or error log (kind is access or error)",
+		Params: []openapi.Param{{Name: "lines", Type: "integer", Description: "How many of the most recent lines to read (default 1000, at most 10000); truncated is set when the file holds older ones"}, nginxLogQueryParam}, Response: nginxLogsResponse{}},
+	{Method: http.MethodGet, Path: "/api/nginx/{id}/logs/{kind}/stream", Tag: "nginx", Summary: "Follow the site's access or error log (Server-Sent Events)", Params: []openapi.Param{nginxLogQueryParam}, Stream: "text/event-stream"},
+
+	// Secrets
+	{Method: http.MethodGet, Path: "/api/secrets", Tag: "secrets", Summary: "List secrets (values masked)",
+		Params: []openapi.Param{{Name: "scope", Description: "global or project:{id}"}}, Response: []*storage.Secret{}},
+	{Method: http.MethodPost, Path: "/api/secrets", Tag: "secrets", Summary: "Create or replace a secret", Request: secretRequest{}, Response: storage.Secret{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/secrets/{id}", Tag: "secrets", Summary: "Get secret metadata (value masked)", Response: storage.Secret{}},
+	{Method: http.MethodPut, Path: "/api/secrets/{id}", Tag: "secrets", Summary: "Replace a secret's value", Request: secretRequest{}, Response: storage.Secret{}},
+	{Method: http.MethodDelete, Path: "/api/secrets/{id}", Tag: "secrets", Summary: "Delete a secret", Status: http.StatusNoContent},
+
+	// Webhooks
+	{Method: http.MethodGet, Path: "/api/webhooks", Tag: "webhooks", Summary: "List webhooks", Response: []*storage.Webhook{}},
+	{Method: http.MethodPost, Path: "/api/webhooks", Tag: "webhooks", Summary: "Create a webhook (the signing secret is returned once)", Request: webhookRequest{}, Response: webhookCreatedResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/webhooks/{id}", Tag: "webhooks", Summary: "Get a webhook", Response: storage.Webhook{}},
+	{Method: http.MethodPut, Path: "/api/webhooks/{id}", Tag: "webhooks", Summary: "Update a webhook", Request: webhookRequest{}, Response: storage.Webhook{}},
+	{Method: http.MethodDelete, Path: "/api/webhooks/{id}", Tag: "webhooks", Summary: "Delete a webhook and its deliveries", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/webhooks/{id}/deliveries", Tag: "webhooks", Summary: "List recent deliveries",
+		Params: []openapi.Param{{Name: "limit", Description: "Maximum deliveries to return (default 50)"}}, Response: []*storage.WebhookDelivery{}},
+	{Method: http.MethodPost, Path: "/api/webhooks/{id}/test", Tag: "webhooks", Summary: "Send a ping event", Response: storage.WebhookDelivery{}},
+
+	// Notification channels
+	{Method: http.MethodGet, Path: "/api/notification-channels", Tag: "notifications", Summary: "List notification channels", Response: []*storage.NotificationChannel{}},
+	{Method: http.MethodPost, Path: "/api/notification-channels", Tag: "notifications", Summary: "Create a Slack, Discord, Telegram, or webhook channel", Request: notificationChannelRequest{}, Response: storage.NotificationChannel{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/notification-channels/{id}", Tag: "notifications", Summary: "Get a notification channel", Response: storage.NotificationChannel{}},
+	{Method: http.MethodPut, Path: "/api/notification-channels/{id}", Tag: "notifications", Summary: "Update a notification channel", Request: notificationChannelRequest{}, Response: storage.NotificationChannel{}},
+	{Method: http.MethodDelete, Path: "/api/notification-channels/{id}", Tag: "notifications", Summary: "Delete a notification channel and its deliveries", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/notification-channels/{id}/deliveries", Tag: "notifications", Summary: "List recent deliveries",
+		Params: []openapi.Param{{Name: "limit", Description: "Maximum deliveries to return (default 50)"}}, Response: []*storage.NotificationDelivery{}},
+	{Method: http.MethodPost, Path: "/api/notification-channels/{id}/test", Tag: "notifications", Summary: "Send a test message", Response: storage.NotificationDelivery{}},
+
+	// Teams
+	{Method: http.MethodGet, Path: "/api/teams", Tag: "teams", Summary: "List teams (non-admins see their own)", Response: []*storage.Team{}},
+	{Method: http.MethodPost, Path: "/api/teams", Tag: "teams", Summary: "Create a team (admin only)", Request: teamRequest{}, Response: storage.Team{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/teams/{id}", Tag: "teams", Summary: "Get a team", Response: storage.Team{}},
+	{Method: http.MethodPut, Path: "/api/teams/{id}", Tag: "teams", Summary: "Rename a team and replace its members (admin only)", Request: teamRequest{}, Response: storage.Team{}},
+	{Method: http.MethodDelete, Path: "/api/teams/{id}", Tag: "teams", Summary: "Delete a team, unassigning its projects (admin only)", Status: http.StatusNoContent},
+
+	// Agent hosts
+	{Method: http.MethodGet, Path: "/api/hosts", Tag: "hosts", Summary: "List agent hosts with their last stats (admin only)", Response: []agent.HostStats{}},
+	{Method: http.MethodDelete, Path: "/api/hosts/{id}", Tag: "hosts", Summary: "Forget a host no project runs on (admin only)", Status: http.StatusNoContent},
+
+	// Wildcard certificates
+	{Method: http.MethodGet, Path: "/api/certificates", Tag: "certificates", Summary: "List wildcard certificates with the projects serving them (admin only)", Response: []*storage.WildcardCertificate{}},
+	{Method: http.MethodPost, Path: "/api/certificates", Tag: "certificates", Summary: "Queue a certificate job issuing a wildcard certificate for a domain and its subdomains, or retrying it (admin only)",
+		Request: wildcardRequest{}, Response: &storage.WildcardCertificate{}, Status: http.StatusAccepted, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodDelete, Path: "/api/certificates/{id}", Tag: "certificates", Summary: "Switch the sites serving a wildcard certificate back and delete it (admin only)", Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: agent.RegisterPath, Tag: "hosts", Summary: "Join as an agent; authenticated by the agent token as a bearer token instead of basic auth", Request: agent.Registration{}, Response: storage.Host{}},
+
+	// Settings
+	{Method: http.MethodGet, Path: "/api/settings", Tag: "settings", Summary: "List registered settings", Response: []*storage.Setting{}},
+	{Method: http.MethodGet, Path: "/api/settings/{key}", Tag: "settings", Summary: "Get a setting",
+		Params: []openapi.Param{{Name: "key", In: "path"}}, Response: settingResponse{}},
+	{Method: http.MethodPut, Path: "/api/settings/{key}", Tag: "settings", Summary: "Set a setting, validated against its type",
+		Params: []openapi.Param{{Name: "key", In: "path"}}, Request: settingRequest{}, Response: statusResponse{}},
+
+	// System
+	{Method: http.MethodGet, Path: "/api/stats", Tag: "system", Summary: "Host and per-service resource usage, with each agent host's for admins", Response: statsResponse{}},
+	{Method: http.MethodGet, Path: "/api/blueprints", Tag: "system", Summary: "Blueprint metadata; with type (and version) returns that blueprint's defaults",
+		Params:   []openapi.Param{{Name: "type"}, {Name: "version"}},
+		Response: []blueprints.BlueprintMetadata{}},
+	{Method: http.MethodGet, Path: "/api/blueprints/catalog", Tag: "system", Summary: "The blueprints of the remote catalog, from its signed index, with whether each is installed", Response: catalogResponse{}},
+	{Method: http.MethodPost, Path: "/api/blueprints/catalog/{type}/install", Tag: "system", Summary: "Fetch a blueprint's definition from the catalog, check it against the signed index, and install it; admin only",
+		Response: storage.BlueprintDefinition{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/api/blueprints/{type}", Tag: "system", Summary: "Uninstall a blueprint installed from the catalog that no service uses; admin only", Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/system-users/{name}", Tag: "system", Summary: "Whether a user exists on this server, for offering to create a missing service user", Response: systemUserResponse{}},
+	{Method: http.MethodGet, Path: "/api/search", Tag: "system", Summary: "Full-text search over projects and services",
+		Params: []openapi.Param{{Name: "q", Required: true}, limitParam}, Response: []*storage.SearchResult{}},
+	{Method: http.MethodGet, Path: "/api/export/inventory", Tag: "system", Summary: "Export every service with its port, status, and domain",
+		Params: []openapi.Param{exportFormatParam}, Response: []inventoryRow{}},
+	{Method: http.MethodGet, Path: "/api/export/metrics", Tag: "system", Summary: "Export recorded host and service usage for a time range",
+		Params: []openapi.Param{
+			exportFormatParam,
+			{Name: "from", Description: "RFC 3339 start time (default 24h before to)"},
+			{Name: "to", Description: "RFC 3339 end time (default now)"},
+			{Name: "service_id", Type: "integer", Description: "Only this service"},
+			{Name: "host", Type: "boolean", Description: "Only host samples"},
+		},
+		Response: []*storage.MetricSample{}},
+	{Method: http.MethodGet, Path: "/api/export/config", Tag: "system", Summary: "Export this server's services, units, .env files, nginx sites, and cron jobs as an Ansible playbook or Terraform configuration; secrets become variables",
+		Params: []openapi.Param{
+			{Name: "format", Description: "ansible (default) or terraform"},
+			{Name: "project_id", Type: "integer", Description: "Only this project"},
+		},
+		Stream: "text/plain"},
+	{Method: http.MethodGet, Path: "/api/audit", Tag: "system", Summary: "Audit trail of host actions",
+		Params: []openapi.Param{
+			{Name: "project_id", Type: "integer"}, {Name: "service_id", Type: "integer"},
+			{Name: "category", Description: "systemd, nginx, git, container, database, env, or user"}, limitParam,
+		},
+		Response: []*storage.AuditEntry{}},
+	{Method: http.MethodGet, Path: "/api/activity", Tag: "system", Summary: "Recent host actions and events (deploys, state changes, log alerts, cron runs), newest first",
+		Params: []openapi.Param{
+			{Name: "project_id", Type: "integer"}, {Name: "service_id", Type: "integer"},
+			{Name: "source", Description: "action (the audit trail) or event"},
+			{Name: "limit", Type: "integer", Description: "Maximum number of items (default 50, capped at 500)"},
+			{Name: "offset", Type: "integer", Description: "Number of items to skip"},
+		},
+		Response: []activityItem{}},
+	{Method: http.MethodGet, Path: "/api/system/doctor", Tag: "system", Summary: "Check host prerequisites: systemd, journald, nginx, git, sudo, and writable directories", Response: doctor.Report{}},
+	{Method: http.MethodGet, Path: "/api/system/journal", Tag: "system", Summary: "Journal disk usage in total and per service", Response: journalUsageResponse{}},
+	{Method: http.MethodPost, Path: "/api/system/journal/vacuum", Tag: "system", Summary: "Delete archived journal files beyond a size or age, in the system journal or a service's namespace",
+		Request: journalVacuumRequest{}, Response: journalVacuumResponse{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/system/log-forwarding", Tag: "system", Summary: "Log forwarding sink, tailed services, queue depth, and delivery counters", Response: logship.Status{}},
+	{Method: http.MethodGet, Path: "/api/system/artifacts", Tag: "system", Summary: "Generated unit files, drop-ins, nginx sites, env files, and configs kept in the database, without their content",
+		Params: artifactParams, Response: []*storage.Artifact{}},
+	{Method: http.MethodGet, Path: "/api/system/artifacts/content", Tag: "system", Summary: "The content last written to a generated file, decrypted",
+		Params: []openapi.Param{{Name: "path", Description: "Absolute path of the file"}}, Stream: "text/plain"},
+	{Method: http.MethodGet, Path: "/api/system/artifacts/archive", Tag: "system", Summary: "Download the kept files as a .tar.gz of their paths relative to /, to extract on a rebuilt host",
+		Params: artifactParams, Stream: "application/gzip"},
+	{Method: http.MethodPost, Path: "/api/system/reconcile", Tag: "system", Summary: "Install again the units, .env files, nginx sites, cron jobs, and kept files missing from the host or changed on it, and enable the units that were enabled; a dry run lists them without changing anything",
+		Request: reconcileRequest{}, Response: reconcileResponse{}, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodGet, Path: "/api/system/updates", Tag: "system", Summary: "Pending apt or dnf package updates as of the last refresh, and whether a reboot is required",
+		Params: []openapi.Param{{Name: "security", Type: "boolean", Description: "Only security updates"}}, Response: updatesResponse{}},
+	{Method: http.MethodPost, Path: "/api/system/updates", Tag: "system", Summary: "Queue a job that refreshes the package lists and applies the selected updates, or all (security) updates when none are selected",
+		Request: applyUpdatesRequest{}, Response: storage.Job{}, Status: http.StatusAccepted, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodPost, Path: "/api/system/updates/refresh", Tag: "system", Summary: "Download the package lists, then list pending updates",
+		Params: []openapi.Param{{Name: "security", Type: "boolean", Description: "Only security updates"}}, Response: updatesResponse{}},
+	{Method: http.MethodGet, Path: "/api/system/power", Tag: "system", Summary: "The host name reboots and shutdowns are confirmed with, and whether they are allowed", Response: powerInfo{}},
+	{Method: http.MethodPost, Path: "/api/system/reboot", Tag: "system", Summary: "Stop this server's services, then reboot the host; needs a recent re-authentication and the host name in confirm",
+		Request: powerRequest{}, Response: powerResponse{}, Status: http.StatusAccepted, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodPost, Path: "/api/system/shutdown", Tag: "system", Summary: "Stop this server's services, then power off the host; needs a recent re-authentication and the host name in confirm",
+		Request: powerRequest{}, Response: powerResponse{}, Status: http.StatusAccepted, Params: []openapi.Param{dryRunParam}},
+	{Method: http.MethodPost, Path: "/api/auth/reauthenticate", Tag: "system", Summary: "Enter the password again to allow one reboot or shutdown in the next 5 minutes", Request: reauthRequest{}, Response: reauthResponse{}},
+	{Method: http.MethodGet, Path: "/api/admin/integrity", Tag: "system", Summary: "Run database integrity checks", Response: storage.IntegrityReport{}},
+	{Method: http.MethodPost, Path: "/api/admin/integrity/repair", Tag: "system", Summary: "Run the checks and delete orphaned rows", Response: storage.IntegrityReport{}},
+	{Method: http.MethodPost, Path: "/graphql", Tag: "graphql", Summary: "Run a GraphQL query or mutation over projects, services, deployments, logs, and stats; 400 when the request cannot run, otherwise field errors are listed beside the data",
+		Request: graphql.Request{}, Response: graphql.Response{}},
+	{Method: http.MethodGet, Path: "/graphql", Tag: "graphql", Summary: "Run a GraphQL query (not a mutation) from query parameters",
+		Params:   []openapi.Param{{Name: "query", Required: true}, {Name: "operationName"}, {Name: "variables", Description: "A JSON object"}},
+		Response: graphql.Response{}},
+	{Method: http.MethodGet, Path: "/graphql/schema", Tag: "graphql", Summary: "The GraphQL schema in the schema definition language", Stream: "text/plain"},
+	{Method: http.MethodGet, Path: "/healthz", Tag: "system", Summary: "Liveness probe (no authentication)", Response: statusResponse{}},
+	{Method: http.MethodGet, Path: "/readyz", Tag: "system", Summary: "Readiness probe: database, systemd, and nginx (503 when not ready, no authentication)", Response: readinessResponse{}},
+}
+
+// apiDocument is built once; the route table is static
+var apiDocument = openapi.Build(openapi.Info{
+	Title:       "Servio API",
+	Version:     "1.0.0",
+	Description: "Manage projects, systemd services, deployments, and nginx sites.",
+}, apiRoutes, errorResponse{})
+
+// handleAPIOpenAPI serves the OpenAPI document
+// GET /api/openapi.json
+func (s *Server) handleAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if basePath != "" {
+		doc := *apiDocument
+		doc.Servers = []openapi.Server{{URL: basePath}}
+		jsonResponse(w, &doc)
+		return
+	}
+	jsonResponse(w, apiDocument)
+}
+
+// handleAPIDocs serves Swagger UI for the OpenAPI document
+// GET /api/docs
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
+
+// swaggerUIPage loads Swagger UI from a CDN so the binary doesn't embed its assets
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Servio API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
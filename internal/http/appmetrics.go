@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"servio/internal/appmetrics"
+	"servio/internal/storage"
+)
+
+// defaultAppMetricsRange is the window the service page shows
+const defaultAppMetricsRange = time.Hour
+
+// ListenAppMetrics starts receiving metrics that services push: StatsD on
+// the statsdAddr UDP port and OTLP/HTTP on otlpAddr. An empty address leaves
+// that listener off. Both are unauthenticated, so they belong on loopback or
+// a private interface. They stop on Shutdown.
+func (s *Server) ListenAppMetrics(statsdAddr, otlpAddr string) error {
+	if statsdAddr != "" {
+		conn, err := net.ListenPacket("udp", statsdAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for StatsD on %s: %w", statsdAddr, err)
+		}
+		go appmetrics.ServeStatsD(s.ctx, conn, s.appMetrics)
+		slog.Info("Receiving StatsD metrics", "addr", conn.LocalAddr().String())
+	}
+	if otlpAddr != "" {
+		ln, err := net.Listen("tcp", otlpAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for OTLP on %s: %w", otlpAddr, err)
+		}
+		srv := &http.Server{
+			Handler:      appmetrics.NewOTLPHandler(s.appMetrics),
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		}
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Warn("OTLP listener stopped", "error", err)
+			}
+		}()
+		go func() {
+			<-s.ctx.Done()
+			srv.Close()
+		}()
+		slog.Info("Receiving OTLP metrics", "addr", ln.Addr().String(), "path", appmetrics.OTLPPath)
+	}
+	return nil
+}
+
+// recordAppMetrics stores what services pushed since the last sample,
+// matching the metrics to services by name. Metrics naming no known service
+// are dropped.
+func (s *Server) recordAppMetrics(ctx context.Context, services []*storage.Service, now time.Time) {
+	aggregates, dropped := s.appMetrics.Flush()
+	if dropped > 0 {
+		slog.WarnContext(ctx, "Dropped app metrics over the series limit", "points", dropped)
+	}
+	ids := make(map[string]int64, len(services))
+	for _, sv := range services {
+		ids[sv.Name] = sv.ID
+	}
+	var samples []*storage.AppMetricSample
+	unknown := map[string]bool{}
+	for _, a := range aggregates {
+		id, ok := ids[a.Service]
+		if !ok {
+			unknown[a.Service] = true
+			continue
+		}
+		samples = append(samples, &storage.AppMetricSample{
+			ServiceID: id,
+			Name:      a.Name,
+			Kind:      a.Kind,
+			Value:     a.Value,
+			Count:     a.Count,
+			TakenAt:   now,
+		})
+	}
+	for name := range unknown {
+		slog.DebugContext(ctx, "Dropped app metrics of an unknown service", "service", name)
+	}
+
+	if len(samples) > 0 {
+		if err := s.store.RecordAppMetrics(ctx, samples); err != nil {
+			slog.WarnContext(ctx, "Failed to record app metrics", "error", err)
+		}
+	}
+	if _, err := s.store.PruneAppMetrics(ctx, now.Add(-metricsRetention)); err != nil {
+		slog.WarnContext(ctx, "Failed to prune app metrics", "error", err)
+	}
+}
+
+// handleAPIServiceAppMetrics returns the custom metrics a service pushed, one
+// series per metric with its latest value, over the last hour unless from
+// and to say otherwise
+// GET /api/services/{id}/app-metrics?from=&to=&name=
+func (s *Server) handleAPIServiceAppMetrics(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	filter := storage.AppMetricFilter{ServiceID: service.ID, Name: r.URL.Query().Get("name")}
+	var err error
+	if filter.From, filter.To, err = parseTimeRange(r, defaultAppMetricsRange); err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples, err := s.store.ListAppMetrics(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	resp := appMetricsResponse{Metrics: []*appMetricSeries{}}
+	var series *appMetricSeries
+	for _, m := range samples {
+		if series == nil || series.Name != m.Name {
+			series = &appMetricSeries{Name: m.Name}
+			resp.Metrics = append(resp.Metrics, series)
+		}
+		series.Kind, series.Latest = m.Kind, m.Value
+		series.Points = append(series.Points, appMetricPoint{TakenAt: m.TakenAt, Value: m.Value, Count: m.Count})
+	}
+	jsonResponse(w, resp)
+}
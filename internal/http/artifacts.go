@@ -0,0 +1,133 @@
+package http
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/storage"
+)
+
+// handleAPIListArtifacts lists the generated files kept in the database,
+// without their content
+// GET /api/system/artifacts?project_id=1&service_id=2&category=systemd
+func (s *Server) handleAPIListArtifacts(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseArtifactFilter(w, r)
+	if !ok {
+		return
+	}
+	artifacts, err := s.store.ListArtifacts(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, artifacts)
+}
+
+// handleAPIArtifactContent returns the content last written to a generated
+// file, decrypted
+// GET /api/system/artifacts/content?path=/etc/systemd/system/servio-app.service
+func (s *Server) handleAPIArtifactContent(w http.ResponseWriter, r *http.Request) {
+	artifact, err := s.store.GetArtifact(r.Context(), r.URL.Query().Get("path"))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if artifact == nil {
+		jsonError(w, r, "No generated file is kept for this path", http.StatusNotFound)
+		return
+	}
+	content, err := audit.FileContent(artifact, s.cipher)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(content)
+}
+
+// handleAPIArtifactArchive downloads the kept files as a .tar.gz of their
+// paths relative to /, so `tar -xzpf servio-files.tar.gz -C /` puts them back
+// on a rebuilt host
+// GET /api/system/artifacts/archive?project_id=1&service_id=2&category=systemd
+func (s *Server) handleAPIArtifactArchive(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseArtifactFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.WithContent = true
+	artifacts, err := s.store.ListArtifacts(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	// Decrypt everything first, so a bad key fails the request instead of
+	// cutting the archive short
+	contents := make([][]byte, len(artifacts))
+	for i, a := range artifacts {
+		if contents[i], err = audit.FileContent(a, s.cipher); err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="servio-files-%s.tar.gz"`, time.Now().UTC().Format("20060102-150405")))
+	if err := writeArtifactArchive(w, artifacts, contents); err != nil {
+		// The response has started, so all that is left is to log it
+		slog.WarnContext(r.Context(), "Failed to write generated files archive", "error", err)
+	}
+}
+
+// writeArtifactArchive writes files as a gzipped tar, each at its path
+// without the leading slash, with its mode and the time it was written
+func writeArtifactArchive(w http.ResponseWriter, artifacts []*storage.Artifact, contents [][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for i, a := range artifacts {
+		mode, err := strconv.ParseInt(a.Mode, 8, 64)
+		if err != nil {
+			mode = 0600
+		}
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(a.Path, "/"),
+			Mode:    mode,
+			Size:    int64(len(contents[i])),
+			ModTime: a.UpdatedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents[i]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// parseArtifactFilter reads the project_id, service_id, and category query
+// parameters, replying 400 when an ID is invalid
+func parseArtifactFilter(w http.ResponseWriter, r *http.Request) (storage.ArtifactFilter, bool) {
+	q := r.URL.Query()
+	filter := storage.ArtifactFilter{Category: q.Get("category")}
+	for name, dst := range map[string]*int64{"project_id": &filter.ProjectID, "service_id": &filter.ServiceID} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				jsonError(w, r, "Invalid "+name, http.StatusBadRequest)
+				return filter, false
+			}
+			*dst = id
+		}
+	}
+	return filter, true
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"servio/internal/storage"
+)
+
+// handleAPIAudit lists recorded host actions
+// GET /api/audit?project_id=1&service_id=2&category=systemd&limit=100
+func (s *Server) handleAPIAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.AuditFilter{Category: q.Get("category")}
+	for name, dst := range map[string]*int64{"project_id": &filter.ProjectID, "service_id": &filter.ServiceID} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				jsonError(w, r, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = id
+		}
+	}
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	filter.Limit = limit
+
+	entries, err := s.store.ListAuditEntries(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, entries)
+}
+
+// handleServiceAudit lists recorded host actions for a single service
+// GET /api/services/{id}/audit?category=git&limit=100
+func (s *Server) handleServiceAudit(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := s.store.ListAuditEntries(r.Context(), storage.AuditFilter{
+		ServiceID: service.ID,
+		Category:  r.URL.Query().Get("category"),
+		Limit:     limit,
+	})
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, entries)
+}
+
+// parseLimit reads ?limit=, capped at MaxListLimit. It writes the error response itself.
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		jsonError(w, r, "Invalid limit", http.StatusBadRequest)
+		return 0, false
+	}
+	if limit > storage.MaxListLimit {
+		limit = storage.MaxListLimit
+	}
+	return limit, true
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// basePath is the prefix every route, link, and asset URL lives under when
+// Servio is reverse-proxied below an existing location, e.g. "/servio". It is
+// empty when Servio is served at the root.
+var basePath string
+
+// SetBasePath serves every route under prefix (see config's -base-path, which
+// normalizes it to "/name" form). Call it before Start.
+func (s *Server) SetBasePath(prefix string) {
+	basePath = strings.TrimRight(prefix, "/")
+}
+
+// BasePath is a middleware that strips the base path from request URLs so
+// routes match as if served at the root. Requests outside the prefix get 404,
+// and the bare prefix redirects to the dashboard.
+func BasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if basePath == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == basePath {
+			target := basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		http.StripPrefix(basePath, next).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// projectBudgetRequest is the body for setting a project's budget
+type projectBudgetRequest struct {
+	MemoryMax string `json:"memory_max"` // e.g. 2G, or 25% of the host's memory
+	CPUQuota  string `json:"cpu_quota"`  // e.g. 150% for one and a half CPUs
+}
+
+// projectBudgetResponse is a project's budget, the slice enforcing it, and
+// what the slice uses now
+type projectBudgetResponse struct {
+	*storage.ProjectBudget
+	Slice string              `json:"slice"`
+	Usage *systemd.SliceUsage `json:"usage,omitempty"`
+	// Running services outside the slice until they are restarted
+	RestartRequired []string `json:"restart_required,omitempty"`
+	// Container services, whose containers their engine runs outside the slice
+	Unbounded []string `json:"unbounded,omitempty"`
+}
+
+// handleAPIGetProjectBudget returns a project's budget and what its services use
+// GET /api/projects/{id}/budget
+func (s *Server) handleAPIGetProjectBudget(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	budget, err := s.store.GetProjectBudget(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if budget == nil {
+		jsonError(w, r, "Project has no budget", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, s.budgetResponse(r.Context(), project, budget))
+}
+
+// handleAPISetProjectBudget caps the memory and CPU a project's services use
+// together by running them in a slice of their own. Running services move
+// into it when they next restart.
+// PUT /api/projects/{id}/budget {"memory_max","cpu_quota"}
+func (s *Server) handleAPISetProjectBudget(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req projectBudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := checkLocal(project, "project budgets"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	budget := systemd.Budget{MemoryMax: req.MemoryMax, CPUQuota: req.CPUQuota}
+	units, _ := sliceUnits(project)
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return systemd.SetProjectBudget(ctx, project.ID, budget, units)
+		})
+		return
+	}
+	if err := systemd.SetProjectBudget(r.Context(), project.ID, budget, units); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	stored := &storage.ProjectBudget{ProjectID: project.ID, MemoryMax: req.MemoryMax, CPUQuota: req.CPUQuota}
+	if err := s.store.SetProjectBudget(r.Context(), stored); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Project budget set", "project", project.Name, "memory_max", req.MemoryMax, "cpu_quota", req.CPUQuota)
+	jsonResponse(w, s.budgetResponse(r.Context(), project, stored))
+}
+
+// handleAPIDeleteProjectBudget lifts a project's budget. Running services
+// leave its slice, which no longer limits them, when they next restart.
+// DELETE /api/projects/{id}/budget
+func (s *Server) handleAPIDeleteProjectBudget(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if err := checkLocal(project, "project budgets"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	units, _ := sliceUnits(project)
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return systemd.RemoveProjectBudget(ctx, project.ID, units)
+		})
+		return
+	}
+	if err := systemd.RemoveProjectBudget(r.Context(), project.ID, units); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.DeleteProjectBudget(r.Context(), project.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// budgetResponse adds a budget's slice, its usage, and the services it does
+// not bound yet
+func (s *Server) budgetResponse(ctx context.Context, project *storage.Project, budget *storage.ProjectBudget) projectBudgetResponse {
+	slice := systemd.ProjectSlice(project.ID)
+	resp := projectBudgetResponse{ProjectBudget: budget, Slice: slice, Usage: systemd.ProjectSliceUsage(ctx, project.ID)}
+	units, containers := sliceUnits(project)
+	resp.Unbounded = containers
+	for _, unit := range units {
+		if s.svcManager.ActiveState(ctx, unit) == "active" && systemd.UnitSlice(ctx, unit) != slice {
+			resp.RestartRequired = append(resp.RestartRequired, unit)
+		}
+	}
+	return resp
+}
+
+// sliceUnits splits a project's services into the units its slice holds and
+// the container services it cannot
+func sliceUnits(project *storage.Project) (units, containers []string) {
+	for _, sv := range project.Services {
+		if sv.IsContainer() {
+			containers = append(containers, sv.Name)
+		} else {
+			units = append(units, sv.ServiceName())
+		}
+	}
+	return units, containers
+}
+
+// removeProjectBudget removes a deleted project's slice
+func (s *Server) removeProjectBudget(ctx context.Context, project *storage.Project) {
+	budget, err := s.store.GetProjectBudget(ctx, project.ID)
+	if err != nil || budget == nil || project.HostID != 0 {
+		return
+	}
+	if err := systemd.RemoveProjectBudget(ctx, project.ID, nil); err != nil {
+		slog.WarnContext(ctx, "Failed to remove project slice", "project", project.Name, "error", err)
+	}
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+
+	"servio/internal/audit"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// bulkActionWorkers bounds how many systemctl calls a bulk action runs at once
+const bulkActionWorkers = 4
+
+// serviceOp resolves a control action to its systemd operation
+func (s *Server) serviceOp(action string) (func(ctx context.Context, name string) error, bool) {
+	switch action {
+	case "start":
+		return s.svcManager.Start, true
+	case "stop":
+		return s.svcManager.Stop, true
+	case "restart":
+		return s.svcManager.Restart, true
+	}
+	return nil, false
+}
+
+// handleAPIServiceActions runs start, stop, or restart on many services concurrently
+// POST /api/services/actions {"ids":[1,2,3],"action":"restart"}
+func (s *Server) handleAPIServiceActions(w http.ResponseWriter, r *http.Request) {
+	var req serviceActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	op, ok := s.serviceOp(req.Action)
+	if !ok {
+		jsonError(w, r, "Action must be start, stop, or restart", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		jsonError(w, r, "ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > storage.MaxListLimit {
+		jsonError(w, r, "Too many ids", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]serviceActionResult, len(req.IDs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < bulkActionWorkers && i < len(req.IDs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = s.runServiceAction(r.Context(), req.IDs[idx], op)
+			}
+		}()
+	}
+	for idx := range req.IDs {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	jsonResponse(w, summarizeActions(req.Action, results))
+}
+
+// handleAPIProjectControl runs start, stop, or restart on every service of a project
+// in dependency order: dependencies first when starting, dependents first when stopping.
+// A service whose dependency failed to start is skipped.
+// POST /api/projects/{id}/start|stop|restart
+func (s *Server) handleAPIProjectControl(action string) projectHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+		op, _ := s.serviceOp(action)
+
+		ordered, deps, err := systemd.StartOrder(project.Services)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if action == "stop" {
+			slices.Reverse(ordered)
+		}
+
+		results := make([]serviceActionResult, 0, len(ordered))
+		failed := make(map[int64]string)
+		for _, sv := range ordered {
+			result := serviceActionResult{ServiceID: sv.ID, Name: sv.Name}
+			if action != "stop" {
+				if dep := firstFailed(deps[sv.ID], failed); dep != "" {
+					result.Skipped = true
+					result.Error = "dependency " + dep + " failed"
+					failed[sv.ID] = sv.Name
+					results = append(results, result)
+					continue
+				}
+			}
+
+			ctx := audit.WithTarget(r.Context(), project.ID, sv.ID)
+			if err := op(ctx, sv.ServiceName()); err != nil {
+				result.Error = err.Error()
+				failed[sv.ID] = sv.Name
+			} else {
+				result.OK = true
+			}
+			results = append(results, result)
+		}
+
+		jsonResponse(w, summarizeActions(action, results))
+	}
+}
+
+// firstFailed returns the name of the first dependency that failed, if any
+func firstFailed(deps []int64, failed map[int64]string) string {
+	for _, id := range deps {
+		if name, ok := failed[id]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// summarizeActions totals per-service results into a response
+func summarizeActions(action string, results []serviceActionResult) serviceActionResponse {
+	resp := serviceActionResponse{Action: action, Results: results}
+	for _, res := range results {
+		switch {
+		case res.OK:
+			resp.Succeeded++
+		case res.Skipped:
+			resp.Skipped++
+		default:
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+// runServiceAction applies op to one service, recording the outcome instead of failing the batch
+func (s *Server) runServiceAction(ctx context.Context, id int64, op func(context.Context, string) error) serviceActionResult {
+	result := serviceActionResult{ServiceID: id}
+
+	service, err := s.store.GetService(ctx, id)
+	if err != nil || service == nil {
+		result.Error = "service not found"
+		return result
+	}
+	result.Name = service.Name
+
+	if err := op(audit.WithTarget(ctx, service.ProjectID, service.ID), service.ServiceName()); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.OK = true
+	return result
+}
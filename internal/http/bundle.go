@@ -0,0 +1,191 @@
+package http
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"servio/internal/ansi"
+	"servio/internal/nginx"
+	"servio/internal/storage"
+	"servio/internal/tail"
+)
+
+// defaultBundleRange is how far back a support bundle reaches without ?from=
+const defaultBundleRange = 24 * time.Hour
+
+// bundleNginxLines is how much of the end of each nginx log a support bundle
+// searches for lines in its time range
+const bundleNginxLines = 100000
+
+// unitEnvironment matches the value of a unit's Environment= lines, which
+// support bundles redact since they hold the service's secrets
+var unitEnvironment = regexp.MustCompile(`(?m)^(Environment="?[^=\s"]+=)[^"\n]*`)
+
+// bundleFile is a file in a support bundle
+type bundleFile struct {
+	Name    string
+	Content string
+}
+
+// bundleManifest describes a support bundle; it is written as manifest.json
+type bundleManifest struct {
+	Service   string    `json:"service"`
+	Project   string    `json:"project"`
+	Unit      string    `json:"unit"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []string  `json:"files"`
+	Errors    []string  `json:"errors,omitempty"` // parts that could not be collected
+}
+
+// handleAPISupportBundle collects a service's logs, its project's nginx logs,
+// and the generated unit and site configs over a time range into a .tar.gz to
+// attach to bug reports. Parts that fail are listed in the manifest instead.
+// GET /api/services/{id}/support-bundle?from=&to=
+func (s *Server) handleAPISupportBundle(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	from, to, err := parseTimeRange(r, defaultBundleRange)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	project, err := s.store.GetProject(ctx, service.ProjectID)
+	if err != nil || project == nil {
+		jsonError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	files, errs := s.collectBundle(ctx, service, project, from, to)
+	unit := service.ServiceName()
+	manifest := bundleManifest{
+		Service:   service.Name,
+		Project:   project.Name,
+		Unit:      unit,
+		From:      from,
+		To:        to,
+		CreatedAt: time.Now(),
+		Files:     make([]string, 0, len(files)),
+		Errors:    errs,
+	}
+	for _, f := range files {
+		manifest.Files = append(manifest.Files, f.Name)
+	}
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	files = append([]bundleFile{{Name: "manifest.json", Content: string(data) + "\n"}}, files...)
+
+	dir := fmt.Sprintf("%s-support-%s", strings.TrimSuffix(unit, ".service"), to.UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, dir))
+	if err := writeBundle(w, dir, manifest.CreatedAt, files); err != nil {
+		// The response has started, so all that is left is to log it
+		slog.WarnContext(ctx, "Failed to write support bundle", "service", service.Name, "error", err)
+	}
+}
+
+// collectBundle gathers the files of a service's support bundle and
+// describes each part that could not be collected
+func (s *Server) collectBundle(ctx context.Context, service *storage.Service, project *storage.Project, from, to time.Time) ([]bundleFile, []string) {
+	var files []bundleFile
+	var errs []string
+	unit := service.ServiceName()
+	name := strings.TrimSuffix(unit, ".service")
+
+	const journalTime = "2006-01-02 15:04:05"
+	if logs, err := s.svcManager.GetLogsWithTimeRange(ctx, unit, from.Local().Format(journalTime), to.Local().Format(journalTime)); err != nil {
+		errs = append(errs, fmt.Sprintf("service logs: %v", err))
+	} else {
+		files = append(files, bundleFile{Name: "logs/" + name + ".log", Content: ansi.Strip(logs)})
+	}
+
+	if status, err := s.svcManager.Status(ctx, unit); err != nil {
+		errs = append(errs, fmt.Sprintf("status: %v", err))
+	} else {
+		files = append(files, bundleFile{Name: "status.txt", Content: status.Output})
+	}
+
+	if unitFile, err := s.svcManager.GenerateServiceFile(service); err != nil {
+		errs = append(errs, fmt.Sprintf("unit file: %v", err))
+	} else {
+		files = append(files, bundleFile{Name: "config/" + unit, Content: unitEnvironment.ReplaceAllString(unitFile, "${1}<redacted>")})
+	}
+
+	if project.Domain == "" {
+		return files, errs
+	}
+	if err := checkLocal(project, "nginx config and logs"); err != nil {
+		return files, append(errs, err.Error())
+	}
+	if site, err := s.nginxManager.GenerateSiteConfig(project); err != nil {
+		errs = append(errs, fmt.Sprintf("nginx config: %v", err))
+	} else {
+		files = append(files, bundleFile{Name: "config/nginx.conf", Content: site})
+	}
+	for _, kind := range []string{nginx.AccessLog, nginx.ErrorLog} {
+		logs, err := nginxLogRange(ctx, project, kind, from, to)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("nginx %s log: %v", kind, err))
+			continue
+		}
+		files = append(files, bundleFile{Name: "logs/nginx-" + kind + ".log", Content: logs})
+	}
+	return files, errs
+}
+
+// nginxLogRange returns the lines of a project's nginx log written between
+// from and to. Lines without a time, such as the rest of a multi-line error,
+// go with the line before them.
+func nginxLogRange(ctx context.Context, project *storage.Project, kind string, from, to time.Time) (string, error) {
+	path, err := nginx.LogPath(project, kind)
+	if err != nil {
+		return "", err
+	}
+	output, err := tail.Read(ctx, path, bundleNginxLines)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	keep := false
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if t, ok := nginx.LineTime(kind, line); ok {
+			keep = !t.Before(from) && !t.After(to)
+		}
+		if keep {
+			b.WriteString(line)
+		}
+	}
+	return b.String(), nil
+}
+
+// writeBundle writes files as a gzipped tar under dir
+func writeBundle(w io.Writer, dir string, modTime time.Time, files []bundleFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    dir + "/" + f.Name,
+			Mode:    0644,
+			Size:    int64(len(f.Content)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(f.Content)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
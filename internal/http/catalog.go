@@ -0,0 +1,173 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"servio/internal/blueprints"
+	"servio/internal/storage"
+)
+
+// catalogBlueprint is a blueprint the catalog offers, with how it stands here
+type catalogBlueprint struct {
+	blueprints.CatalogEntry
+	Builtin         bool `json:"builtin"`          // a built-in blueprint has the type; it cannot be installed
+	Installed       bool `json:"installed"`        // installed from the catalog
+	UpdateAvailable bool `json:"update_available"` // installed, but the catalog's file has changed since
+}
+
+// catalogResponse is the catalog's index as seen from this server
+type catalogResponse struct {
+	URL        string             `json:"url"`
+	Blueprints []catalogBlueprint `json:"blueprints"`
+}
+
+// catalog returns the catalog the settings configure
+func (s *Server) catalog(ctx context.Context) (*blueprints.Catalog, error) {
+	catalogURL, err := s.store.GetSetting(ctx, storage.SettingBlueprintCatalogURL)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.store.GetSetting(ctx, storage.SettingBlueprintCatalogKey)
+	if err != nil {
+		return nil, err
+	}
+	return blueprints.NewCatalog(catalogURL, key)
+}
+
+// loadBlueprintDefinitions registers the blueprints installed from the
+// catalog. Definitions that no longer parse are logged and skipped.
+func (s *Server) loadBlueprintDefinitions(ctx context.Context) error {
+	defs, err := s.store.ListBlueprintDefinitions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, def := range defs {
+		bp, err := blueprints.ParseDefinition([]byte(def.Definition))
+		if err != nil {
+			slog.ErrorContext(ctx, "Skipping installed blueprint", "type", def.Type, "error", err)
+			continue
+		}
+		if s.blueprints.IsBuiltin(bp.Type()) {
+			slog.WarnContext(ctx, "Skipping installed blueprint shadowing a built-in one", "type", def.Type)
+			continue
+		}
+		s.blueprints.Register(bp)
+	}
+	return nil
+}
+
+// handleAPIBlueprintCatalog fetches the catalog's signed index and lists its
+// blueprints with whether each is installed
+// GET /api/blueprints/catalog
+func (s *Server) handleAPIBlueprintCatalog(w http.ResponseWriter, r *http.Request) {
+	catalog, err := s.catalog(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	index, err := catalog.Index(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	defs, err := s.store.ListBlueprintDefinitions(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	installed := make(map[string]string, len(defs))
+	for _, def := range defs {
+		installed[def.Type] = def.SHA256
+	}
+
+	resp := catalogResponse{URL: catalog.URL.String(), Blueprints: make([]catalogBlueprint, len(index.Blueprints))}
+	for i, entry := range index.Blueprints {
+		sum, ok := installed[entry.Type]
+		resp.Blueprints[i] = catalogBlueprint{
+			CatalogEntry:    entry,
+			Builtin:         s.blueprints.IsBuiltin(entry.Type),
+			Installed:       ok,
+			UpdateAvailable: ok && sum != entry.SHA256,
+		}
+	}
+	jsonResponse(w, resp)
+}
+
+// handleAPIInstallCatalogBlueprint fetches a blueprint's definition from the
+// catalog, checks it against the signed index, and installs it, replacing an
+// earlier version. Services of the type pick it up on their next install.
+// POST /api/blueprints/catalog/{type}/install
+func (s *Server) handleAPIInstallCatalogBlueprint(w http.ResponseWriter, r *http.Request) {
+	blueprintType := r.PathValue("type")
+	if s.blueprints.IsBuiltin(blueprintType) {
+		jsonError(w, r, fmt.Sprintf("%s is a built-in blueprint", blueprintType), http.StatusConflict)
+		return
+	}
+	catalog, err := s.catalog(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	index, err := catalog.Index(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	entry, ok := index.Entry(blueprintType)
+	if !ok {
+		apiError(w, r, fmt.Errorf("%w: %s", blueprints.ErrNotInCatalog, blueprintType))
+		return
+	}
+	bp, data, source, err := catalog.Definition(r.Context(), entry)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	def := &storage.BlueprintDefinition{Type: bp.Type(), Definition: string(data), SHA256: hex.EncodeToString(sum[:]), Source: source}
+	if err := s.store.SaveBlueprintDefinition(r.Context(), def); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.blueprints.Register(bp)
+	slog.InfoContext(r.Context(), "Installed blueprint from catalog", "type", def.Type, "source", source)
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, def)
+}
+
+// handleAPIDeleteBlueprint uninstalls a blueprint installed from the catalog.
+// It refuses while services use it, since their units are generated from it.
+// DELETE /api/blueprints/{type}
+func (s *Server) handleAPIDeleteBlueprint(w http.ResponseWriter, r *http.Request) {
+	blueprintType := r.PathValue("type")
+	if s.blueprints.IsBuiltin(blueprintType) {
+		jsonError(w, r, fmt.Sprintf("%s is a built-in blueprint", blueprintType), http.StatusConflict)
+		return
+	}
+	if !s.blueprints.IsManaged(blueprintType) {
+		jsonError(w, r, "Blueprint not found", http.StatusNotFound)
+		return
+	}
+	n, err := s.store.CountServicesByType(r.Context(), blueprintType)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if n > 0 {
+		jsonError(w, r, fmt.Sprintf("%d services use the %s blueprint", n, blueprintType), http.StatusConflict)
+		return
+	}
+	if err := s.store.DeleteBlueprintDefinition(r.Context(), blueprintType); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.blueprints.Unregister(blueprintType)
+	w.WriteHeader(http.StatusNoContent)
+}
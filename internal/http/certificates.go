@@ -0,0 +1,230 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"servio/internal/acme"
+	"servio/internal/jobs"
+	"servio/internal/storage"
+)
+
+// certificateName is the certbot name of a project's certificate
+func certificateName(project *storage.Project) string {
+	return fmt.Sprintf("servio-%d", project.ID)
+}
+
+// SetCertificateDir sets the directory DNS provider credentials are kept in
+// for certbot's renewals. It is bound when the process starts.
+func (s *Server) SetCertificateDir(dir string) {
+	s.certDir = dir
+}
+
+// handleAPIGetCertificate returns the project's certificate
+// GET /api/nginx/{id}/certificate
+func (s *Server) handleAPIGetCertificate(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	cert, err := s.store.GetCertificate(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if cert == nil {
+		jsonError(w, r, "Project has no certificate", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, cert)
+}
+
+// handleAPIIssueCertificate queues a certificate job that issues or renews
+// the project's certificate with a DNS-01 challenge through the configured
+// DNS provider. The domain need not point at this server.
+// POST /api/nginx/{id}/certificate
+func (s *Server) handleAPIIssueCertificate(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if project.Domain == "" {
+		jsonError(w, r, "Project has no domain configured", http.StatusBadRequest)
+		return
+	}
+	if err := checkLocal(project, "certificates"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	domains := acme.Domains(project.Domain)
+	if len(domains) == 0 {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "domain", Message: "has no name a certificate can cover"}}})
+		return
+	}
+	req, err := s.acmeRequest(r.Context(), certificateName(project), domains)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			if err := acme.Issue(ctx, req, func(string) {}); err != nil {
+				return err
+			}
+			return s.reinstallSite(ctx, project, req.Name)
+		})
+		return
+	}
+
+	cert, err := s.store.GetCertificate(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if cert == nil {
+		cert = &storage.Certificate{ProjectID: project.ID}
+	}
+	cert.Name, cert.Domains, cert.Provider = req.Name, req.Domains, req.Provider
+	cert.Status, cert.Error = storage.JobQueued, ""
+
+	job, err := s.jobs.Enqueue(r.Context(), storage.Job{Kind: jobs.KindCertificate, ProjectID: project.ID},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			cert.JobID = job.ID
+			return s.issueCertificate(ctx, project.ID, cert, req, logf)
+		})
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	cert.JobID = job.ID
+	if err := s.store.SaveCertificate(r.Context(), cert); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, job)
+}
+
+// acmeRequest builds a certbot request from the acme_* settings, which it
+// checks. Credentials stay unresolved here; the job resolves them.
+func (s *Server) acmeRequest(ctx context.Context, name string, domains []string) (acme.Request, error) {
+	req := acme.Request{Name: name, Domains: domains, CredentialsDir: s.certDir}
+	var fields []storage.FieldError
+	settings := map[string]*string{
+		storage.SettingACMEEmail:          &req.Email,
+		storage.SettingACMEDNSProvider:    &req.Provider,
+		storage.SettingACMEDNSCredentials: &req.Credentials,
+	}
+	for key, value := range settings {
+		v, err := s.store.GetSetting(ctx, key)
+		if err != nil {
+			return req, err
+		}
+		*value = v
+	}
+	staging, err := s.store.GetSetting(ctx, storage.SettingACMEStaging)
+	if err != nil {
+		return req, err
+	}
+	req.Staging = staging == "true"
+
+	if req.Email == "" {
+		fields = append(fields, storage.FieldError{Field: storage.SettingACMEEmail, Message: "must be set to issue certificates"})
+	}
+	switch {
+	case req.Provider == "none":
+		fields = append(fields, storage.FieldError{Field: storage.SettingACMEDNSProvider, Message: "must name a DNS provider to issue certificates"})
+	case req.Provider != acme.Route53 && req.Credentials == "":
+		fields = append(fields, storage.FieldError{Field: storage.SettingACMEDNSCredentials, Message: "must hold the API token of " + req.Provider})
+	}
+	if len(fields) > 0 {
+		return req, &storage.ValidationError{Fields: fields}
+	}
+	return req, nil
+}
+
+// issueCertificate is the work of a certificate job. Once certbot has the
+// certificate, an installed site is rewritten to serve HTTPS with it.
+func (s *Server) issueCertificate(ctx context.Context, projectID int64, cert *storage.Certificate, req acme.Request, logf jobs.Logf) error {
+	err := func() error {
+		cert.Status = storage.JobRunning
+		if err := s.store.SaveCertificate(ctx, cert); err != nil {
+			return err
+		}
+		credentials, _, err := s.resolver.Resolve(ctx, &storage.Service{ProjectID: projectID}, req.Credentials)
+		if err != nil {
+			return err
+		}
+		req.Credentials = credentials
+
+		logf("requesting a certificate for %v through %s", req.Domains, req.Provider)
+		if err := acme.Issue(ctx, req, func(line string) { logf("%s", line) }); err != nil {
+			return err
+		}
+		expires, err := acme.Expiry(ctx, req.Name)
+		if err != nil {
+			return err
+		}
+		cert.ExpiresAt = &expires
+		logf("certificate %s expires %s; certbot renews it", req.Name, expires.Format(time.RFC3339))
+
+		project, err := s.store.GetProject(ctx, projectID)
+		if err != nil || project == nil {
+			return err
+		}
+		return s.reinstallSite(ctx, project, req.Name)
+	}()
+
+	cert.Status, cert.Error = storage.JobSucceeded, ""
+	if err != nil {
+		cert.Status, cert.Error = storage.JobFailed, err.Error()
+	}
+	if saveErr := s.store.SaveCertificate(ctx, cert); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// handleAPIDeleteCertificate switches an installed site back to plain HTTP,
+// or a wildcard certificate covering it, then deletes the certificate and
+// stops its renewal
+// DELETE /api/nginx/{id}/certificate
+func (s *Server) handleAPIDeleteCertificate(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	cert, err := s.store.GetCertificate(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if cert == nil {
+		jsonError(w, r, "Project has no certificate", http.StatusNotFound)
+		return
+	}
+	remove := func(ctx context.Context) error {
+		if err := s.reinstallSite(ctx, project, ""); err != nil {
+			return err
+		}
+		return acme.Delete(ctx, cert.Name)
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, remove)
+		return
+	}
+	// A certificate that never got issued has nothing to remove from the host
+	if cert.ExpiresAt != nil {
+		if err := remove(r.Context()); err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
+	if err := s.store.DeleteCertificate(r.Context(), project.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reinstallSite rewrites an installed site to serve the named certificate,
+// or whatever nginx.Manager.SiteCertificate picks when name is empty. Sites
+// not installed are left alone.
+func (s *Server) reinstallSite(ctx context.Context, project *storage.Project, name string) error {
+	if !s.nginxManager.SiteExists(project) || project.NginxRaw != "" {
+		return nil
+	}
+	site := *project
+	site.Certificate = name
+	return s.nginxManager.InstallSite(ctx, &site)
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"servio/internal/jobs"
+	"servio/internal/stacks"
+	"servio/internal/storage"
+)
+
+// cloneRequest is the body for copying a project
+type cloneRequest struct {
+	Name      string `json:"name"`
+	Domain    string `json:"domain"`     // the copy's nginx server name; none when empty
+	GitBranch string `json:"git_branch"` // checked out by services with a repository; each keeps its own when empty
+}
+
+// cloneResponse is the copy and the job installing its services
+type cloneResponse struct {
+	Project *storage.Project `json:"project"`
+	JobID   int64            `json:"job_id,omitempty"`
+}
+
+// handleAPICloneProject copies a project and its services under a new name,
+// with fresh ports, and queues a clone job that installs the copies
+// POST /api/projects/{id}/clone {"name","domain","git_branch"}
+func (s *Server) handleAPICloneProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req cloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	clone, jobID, err := s.cloneProject(r.Context(), project, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, cloneResponse{Project: clone, JobID: jobID})
+}
+
+// projectCopier rewrites the text of a project's configuration for its copy:
+// unit names and ports move to the copy's, and so do paths in commands and
+// unit files
+type projectCopier struct {
+	units   *strings.Replacer // servio-<name>.service and moved working directories
+	ports   map[string]string
+	portRe  *regexp.Regexp // nil when no service has a port
+	pathRe  *regexp.Regexp // /<old slug> starting a path element
+	newSlug string
+}
+
+// rewrite moves unit names and ports
+func (c *projectCopier) rewrite(text string) string {
+	text = c.units.Replace(text)
+	if c.portRe != nil {
+		text = c.portRe.ReplaceAllStringFunc(text, func(port string) string { return c.ports[port] })
+	}
+	return text
+}
+
+// rewritePaths also moves paths such as /srv/<slug>, for the fields that run
+// things; URLs in environments end in database names that must stay
+func (c *projectCopier) rewritePaths(text string) string {
+	return c.pathRe.ReplaceAllLiteralString(c.rewrite(text), "/"+c.newSlug)
+}
+
+// cloneProject creates the copy of src, removing it again if any part
+// fails. Failing to queue the job is logged rather than returned, as for
+// stacks; the job ID is then 0.
+func (s *Server) cloneProject(ctx context.Context, src *storage.Project, req *cloneRequest) (*storage.Project, int64, error) {
+	sources, err := s.store.ListServicesByProject(ctx, src.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	project, err := s.store.CreateProject(ctx, &storage.CreateProjectRequest{
+		Name:        req.Name,
+		Description: src.Description,
+		Domain:      req.Domain,
+		Notes:       src.Notes,
+		Tags:        src.Tags,
+		TeamID:      src.TeamID,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	// Deleting the project also deletes its services and secrets
+	fail := func(err error) (*storage.Project, int64, error) {
+		if derr := s.store.DeleteProject(context.WithoutCancel(ctx), project.ID); derr != nil {
+			slog.WarnContext(ctx, "Failed to remove partly cloned project", "project", project.Name, "error", derr)
+		}
+		return nil, 0, err
+	}
+
+	alloc, err := s.newPortAllocator(ctx, project.HostID)
+	if err != nil {
+		return fail(err)
+	}
+	oldSlug, newSlug := stacks.Slug(src.Name), stacks.Slug(project.Name)
+	copier := &projectCopier{
+		ports:   map[string]string{},
+		pathRe:  regexp.MustCompile("/" + regexp.QuoteMeta(oldSlug) + `\b`),
+		newSlug: newSlug,
+	}
+	names := make([]string, len(sources))
+	ports := make([]int, len(sources))
+	dirs := make([]string, len(sources))
+	var moved, oldPorts []string
+	for i, sv := range sources {
+		names[i] = newSlug + "-" + strings.TrimPrefix(sv.Name, oldSlug+"-")
+		moved = append(moved, sv.ServiceName(), "servio-"+names[i]+".service")
+		if sv.Port > 0 {
+			if ports[i], err = alloc.next(sv.Port); err != nil {
+				return fail(err)
+			}
+			copier.ports[strconv.Itoa(sv.Port)] = strconv.Itoa(ports[i])
+			oldPorts = append(oldPorts, strconv.Itoa(sv.Port))
+		}
+		// The copy must not check out its branch over the original's repository
+		dirs[i] = copier.pathRe.ReplaceAllLiteralString(sv.WorkingDir, "/"+newSlug)
+		if sv.GitRepoURL != "" && sv.WorkingDir != "" && dirs[i] == sv.WorkingDir {
+			dirs[i] = strings.TrimSuffix(sv.WorkingDir, "/") + "-" + newSlug
+			moved = append(moved, sv.WorkingDir, dirs[i])
+		}
+	}
+	copier.units = strings.NewReplacer(moved...)
+	if len(oldPorts) > 0 {
+		copier.portRe = regexp.MustCompile(`\b(` + strings.Join(oldPorts, "|") + `)\b`)
+	}
+
+	services := make([]*storage.Service, len(sources))
+	for i, sv := range sources {
+		branch := sv.GitBranch
+		if req.GitBranch != "" && sv.GitRepoURL != "" {
+			branch = req.GitBranch
+		}
+		services[i], err = s.store.CreateService(ctx, &storage.CreateServiceRequest{
+			ProjectID:   project.ID,
+			Name:        names[i],
+			Type:        sv.Type,
+			Version:     sv.Version,
+			Runtime:     sv.Runtime,
+			Image:       sv.Image,
+			Port:        ports[i],
+			GitRepoURL:  sv.GitRepoURL,
+			GitBranch:   branch,
+			GitFilter:   sv.GitFilter,
+			Command:     copier.rewritePaths(sv.Command),
+			WorkingDir:  dirs[i],
+			User:        sv.User,
+			Environment: copier.rewrite(sv.Environment),
+			AutoRestart: sv.AutoRestart,
+			Config:      copier.rewrite(sv.Config),
+			SystemdRaw:  copier.rewritePaths(sv.SystemdRaw),
+			NginxRaw:    copier.rewrite(sv.NginxRaw),
+			Notes:       sv.Notes,
+			Tags:        sv.Tags,
+		})
+		if err != nil {
+			return fail(err)
+		}
+		if err := s.copyEnvVars(ctx, sv.ID, services[i].ID, copier); err != nil {
+			return fail(err)
+		}
+	}
+
+	shared, err := s.store.ListProjectEnvVars(ctx, src.ID)
+	if err != nil {
+		return fail(err)
+	}
+	for _, v := range shared {
+		value := v.Value
+		if !v.Secret {
+			value = copier.rewrite(value)
+		}
+		copied := &storage.EnvVar{ProjectID: project.ID, Key: v.Key, Value: value, Secret: v.Secret, Ciphertext: v.Ciphertext}
+		if err := s.store.SetProjectEnvVar(ctx, copied); err != nil {
+			return fail(err)
+		}
+	}
+
+	secrets, err := s.store.ListSecrets(ctx, storage.ProjectScope(src.ID))
+	if err != nil {
+		return fail(err)
+	}
+	for _, listed := range secrets {
+		secret, err := s.store.GetSecret(ctx, listed.ID)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := s.store.CreateSecret(ctx, secret.Key, storage.ProjectScope(project.ID), secret.Ciphertext); err != nil {
+			return fail(err)
+		}
+	}
+
+	// A custom site is kept with the copy's ports, domain, and log files;
+	// without a domain the copy has no site
+	if src.NginxRaw != "" && project.Domain != "" {
+		config := copier.rewrite(src.NginxRaw)
+		if src.Domain != "" {
+			config = strings.ReplaceAll(config, src.Domain, project.Domain)
+		}
+		config = strings.ReplaceAll(config, "/var/log/nginx/"+src.Name+".", "/var/log/nginx/"+project.Name+".")
+		if _, err := s.store.UpdateProjectNginxRaw(ctx, project.ID, config); err != nil {
+			return fail(err)
+		}
+	}
+
+	if project, err = s.store.GetProject(ctx, project.ID); err != nil || project == nil {
+		return fail(fmt.Errorf("failed to reload project: %w", err))
+	}
+	job, err := s.jobs.Enqueue(ctx, storage.Job{Kind: jobs.KindClone, ProjectID: project.ID},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			return s.setupServices(ctx, services, func(int) bool { return false }, logf)
+		})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to queue clone setup", "project", project.Name, "error", err)
+		return project, 0, nil
+	}
+	return project, job.ID, nil
+}
+
+// copyEnvVars copies a service's managed variables to its copy. Secrets keep
+// their ciphertext; plain values are rewritten like the rest of its configuration.
+func (s *Server) copyEnvVars(ctx context.Context, from, to int64, copier *projectCopier) error {
+	vars, err := s.store.ListEnvVars(ctx, from)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		value := v.Value
+		if !v.Secret {
+			value = copier.rewrite(value)
+		}
+		copied := &storage.EnvVar{ServiceID: to, Key: v.Key, Value: value, Secret: v.Secret, Ciphertext: v.Ciphertext}
+		if err := s.store.SetEnvVar(ctx, copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"servio/internal/config"
+	"servio/internal/dryrun"
+	"servio/internal/logging"
+	"servio/internal/oidc"
+)
+
+// Configure applies the configuration the server starts with and starts the
+// app metrics listeners. Backups and ACME certificates are kept next to the
+// database.
+func (s *Server) Configure(cfg *config.Config) error {
+	s.SetSocketPermissions(cfg.SocketMode, cfg.SocketGroup)
+	if cfg.AgentCA != "" {
+		if err := s.TrustAgentCA(cfg.AgentCA); err != nil {
+			return fmt.Errorf("failed to load the agent CA bundle: %w", err)
+		}
+	}
+	if err := s.configureReloadable(cfg); err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		slog.Warn("Dry-run mode: unit files, nginx sites, and commands are logged, not applied")
+	}
+	s.SetBackupDir(filepath.Join(filepath.Dir(cfg.DBPath), "backups"))
+	s.SetCertificateDir(filepath.Join(filepath.Dir(cfg.DBPath), "acme"))
+	s.SetBasePath(cfg.BasePath)
+	if err := s.ListenAppMetrics(cfg.StatsDAddr, cfg.OTLPAddr); err != nil {
+		return fmt.Errorf("failed to start the metrics listeners: %w", err)
+	}
+	if cfg.Dev {
+		if err := s.EnableDevMode(DefaultDevDir); err != nil {
+			return fmt.Errorf("failed to enable dev mode: %w", err)
+		}
+		slog.Warn("Dev mode: serving templates and static files from disk", "dir", DefaultDevDir)
+	}
+	if cfg.TLSCert != "" {
+		if err := s.ConfigureTLS(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA); err != nil {
+			return fmt.Errorf("failed to configure TLS: %w", err)
+		}
+	}
+	return nil
+}
+
+// configureReloadable applies the settings Reload can change while running
+func (s *Server) configureReloadable(cfg *config.Config) error {
+	s.SetCredentials(cfg.Username, cfg.Password)
+	s.SetAdmins(cfg.Admins)
+	s.SetAgentToken(cfg.AgentToken)
+	s.SetRateLimit(cfg.RateLimit, cfg.RateLimits)
+	s.SetNginxDirs(cfg.NginxSitesDir, cfg.NginxEnabledDir)
+	dryrun.SetGlobal(cfg.DryRun)
+	if err := s.configureSSO(cfg); err != nil {
+		return fmt.Errorf("failed to configure single sign-on: %w", err)
+	}
+	return nil
+}
+
+// configureSSO enables signing in through the configured identity provider,
+// or disables it when none is set
+func (s *Server) configureSSO(cfg *config.Config) error {
+	if cfg.OIDCIssuer == "" {
+		s.SetSSO(nil, "", nil)
+		return nil
+	}
+	provider, err := oidc.New(oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		GroupsClaim:  cfg.OIDCGroupsClaim,
+	})
+	if err != nil {
+		return err
+	}
+	s.SetSSO(provider, cfg.OIDCRedirectURL, cfg.OIDCGroups)
+	slog.Info("Single sign-on enabled", "provider", provider.Name(), "mappings", len(cfg.OIDCGroups))
+	return nil
+}
+
+// Reload re-reads the configuration and applies the settings that can change
+// without a restart, returning the configuration now in effect. In-flight
+// requests and managed services are unaffected; on error the current
+// configuration stays in place.
+func (s *Server) Reload(cfg *config.Config, logLevel *slog.LevelVar) *config.Config {
+	next, err := config.Reload()
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return cfg
+	}
+
+	logLevel.Set(logging.ParseLevel(next.LogLevel))
+	if err := s.configureReloadable(next); err != nil {
+		slog.Error("Failed to reconfigure single sign-on, keeping the current settings", "error", err)
+	}
+	if restart := config.RestartOnly(cfg, next); len(restart) > 0 {
+		slog.Warn("Some changed settings only take effect after a restart", "settings", restart)
+	}
+
+	slog.Info("Configuration reloaded", "file", next.ConfigFile, "log_level", next.LogLevel)
+	return next
+}
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"servio/internal/nginx"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// projectConflicts finds the nginx sites and systemd units on this server
+// that clash with a project's site and its services' units
+func (s *Server) projectConflicts(project *storage.Project) ([]nginx.Conflict, []systemd.UnitConflict) {
+	var ports []int
+	var units []systemd.UnitConflict
+	for _, service := range project.Services {
+		if service.Port != 0 {
+			ports = append(ports, service.Port)
+		}
+		if service.IsContainer() {
+			continue
+		}
+		generated, err := s.svcManager.GenerateServiceFile(service)
+		if err != nil {
+			continue
+		}
+		units = append(units, systemd.UnitConflicts(service, generated)...)
+	}
+	return s.nginxManager.Conflicts(project, ports), units
+}
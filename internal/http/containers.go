@@ -0,0 +1,43 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"servio/internal/container"
+	"servio/internal/monitor"
+	"servio/internal/storage"
+)
+
+// checkSystemd returns an error wrapping container.ErrSystemdOnly when a
+// service runs as a container, for features built on systemd or the journal
+func checkSystemd(service *storage.Service, feature string) error {
+	if service.IsContainer() {
+		return fmt.Errorf("%s: %w", feature, container.ErrSystemdOnly)
+	}
+	return nil
+}
+
+// localStats reads the host's stats and those of the given services that run
+// on it. The monitor package reads units from systemd; containers are read
+// from their engine.
+func (s *Server) localStats(ctx context.Context, services []*storage.Service) monitor.Stats {
+	var units, containers []string
+	for _, sv := range services {
+		if sv.IsContainer() {
+			containers = append(containers, sv.ServiceName())
+		} else {
+			units = append(units, sv.ServiceName())
+		}
+	}
+	stats := monitor.GetStats(units...)
+	if s.containers == nil || len(containers) == 0 {
+		return stats
+	}
+	if stats.Services == nil {
+		stats.Services = map[string]monitor.ServiceStat{}
+	}
+	maps.Copy(stats.Services, s.containers.Stats(ctx, containers))
+	return stats
+}
@@ -0,0 +1,288 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/cron"
+	"servio/internal/logparse"
+	"servio/internal/storage"
+)
+
+const (
+	// cronNextRuns is how many upcoming runs are listed per job
+	cronNextRuns = 5
+	// maxCronTimeout bounds a cron job's timeout to a week
+	maxCronTimeout = 7 * 86400
+)
+
+// cronJobName is the pattern cron job names must match; they are part of the unit names
+var cronJobName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// cronJobRequest is the body for creating or updating a cron job
+type cronJobRequest struct {
+	Name        string `json:"name"` // fixed once created
+	Schedule    string `json:"schedule"`
+	Command     string `json:"command"`
+	WorkingDir  string `json:"working_dir"`
+	User        string `json:"user"` // defaults to root
+	Environment string `json:"environment"`
+	Timeout     int    `json:"timeout"`           // seconds, 0 for no limit
+	Enabled     *bool  `json:"enabled,omitempty"` // defaults to true
+}
+
+// validate fills in defaults and checks each field
+func (req *cronJobRequest) validate() error {
+	if strings.TrimSpace(req.User) == "" {
+		req.User = "root"
+	}
+
+	var fields []storage.FieldError
+	if !cronJobName.MatchString(req.Name) {
+		fields = append(fields, storage.FieldError{Field: "name", Message: "must be 1-64 letters, digits, dots, dashes, or underscores"})
+	}
+	if schedule, err := cron.Parse(req.Schedule); err != nil {
+		fields = append(fields, storage.FieldError{Field: "schedule", Message: err.Error()})
+	} else if schedule.Next(time.Now()).IsZero() {
+		fields = append(fields, storage.FieldError{Field: "schedule", Message: "never runs"})
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		fields = append(fields, storage.FieldError{Field: "command", Message: "is required"})
+	} else if strings.ContainsAny(req.Command, "\r\n") {
+		fields = append(fields, storage.FieldError{Field: "command", Message: "must be a single line; put longer scripts in a file"})
+	}
+	if req.WorkingDir != "" && !strings.HasPrefix(req.WorkingDir, "/") {
+		fields = append(fields, storage.FieldError{Field: "working_dir", Message: "must be an absolute path"})
+	}
+	if req.Timeout < 0 || req.Timeout > maxCronTimeout {
+		fields = append(fields, storage.FieldError{Field: "timeout", Message: "must be between 0 and " + strconv.Itoa(maxCronTimeout) + " seconds"})
+	}
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// apply copies the request onto a job, keeping its name
+func (req *cronJobRequest) apply(job *storage.CronJob) {
+	job.Schedule = strings.TrimSpace(req.Schedule)
+	job.Command = req.Command
+	job.WorkingDir = req.WorkingDir
+	job.User = req.User
+	job.Environment = req.Environment
+	job.Timeout = req.Timeout
+	if req.Enabled != nil {
+		job.Enabled = *req.Enabled
+	}
+}
+
+// handleAPIListCronJobs lists a project's cron jobs with their next runs
+// GET /api/projects/{id}/cron-jobs
+func (s *Server) handleAPIListCronJobs(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	jobs, err := s.store.ListCronJobs(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	cron.WithNextRuns(jobs, cronNextRuns)
+	jsonResponse(w, jobs)
+}
+
+// handleAPIGetCronJob returns a cron job with its next runs
+// GET /api/projects/{id}/cron-jobs/{job}
+func (s *Server) handleAPIGetCronJob(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	job, ok := s.loadCronJob(w, r, project)
+	if !ok {
+		return
+	}
+	cron.WithNextRuns([]*storage.CronJob{job}, cronNextRuns)
+	jsonResponse(w, job)
+}
+
+// handleAPICreateCronJob adds a cron job to a project and installs its timer.
+// Cron jobs run on this server, so projects on agent hosts cannot have them.
+// POST /api/projects/{id}/cron-jobs {"name","schedule","command","working_dir","user","environment","timeout","enabled"}
+func (s *Server) handleAPICreateCronJob(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req cronJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := checkLocal(project, "cron jobs"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	job := &storage.CronJob{ProjectID: project.ID, Name: req.Name, Enabled: true}
+	req.apply(job)
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return cron.Install(ctx, job, s.resolver)
+		})
+		return
+	}
+	if err := s.store.CreateCronJob(r.Context(), job); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := cron.Install(r.Context(), job, s.resolver); err != nil {
+		// Keep no job whose timer is not installed
+		cron.Remove(r.Context(), job)
+		s.store.DeleteCronJob(r.Context(), job.ID)
+		apiError(w, r, err)
+		return
+	}
+
+	cron.WithNextRuns([]*storage.CronJob{job}, cronNextRuns)
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, job)
+}
+
+// handleAPIUpdateCronJob replaces a cron job's settings and reinstalls its
+// timer; a run in progress finishes with the old settings
+// PUT /api/projects/{id}/cron-jobs/{job} {"schedule","command","working_dir","user","environment","timeout","enabled"}
+func (s *Server) handleAPIUpdateCronJob(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	job, ok := s.loadCronJob(w, r, project)
+	if !ok {
+		return
+	}
+
+	var req cronJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = job.Name
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	req.apply(job)
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return cron.Install(ctx, job, s.resolver)
+		})
+		return
+	}
+	if err := cron.Install(r.Context(), job, s.resolver); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.UpdateCronJob(r.Context(), job); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	cron.WithNextRuns([]*storage.CronJob{job}, cronNextRuns)
+	jsonResponse(w, job)
+}
+
+// handleAPIDeleteCronJob removes a cron job's units, stopping a run in
+// progress, and deletes it
+// DELETE /api/projects/{id}/cron-jobs/{job}
+func (s *Server) handleAPIDeleteCronJob(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	job, ok := s.loadCronJob(w, r, project)
+	if !ok {
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return cron.Remove(ctx, job)
+		})
+		return
+	}
+	if err := cron.Remove(r.Context(), job); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.DeleteCronJob(r.Context(), job.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIRunCronJob starts a run of a cron job now, disabled or not. Its
+// outcome is recorded like a scheduled run's.
+// POST /api/projects/{id}/cron-jobs/{job}/run
+func (s *Server) handleAPIRunCronJob(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	job, ok := s.loadCronJob(w, r, project)
+	if !ok {
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return cron.RunNow(ctx, job)
+		})
+		return
+	}
+	if err := cron.RunNow(r.Context(), job); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, statusResponse{Status: "started"})
+}
+
+// handleAPICronJobLogs returns the last lines a cron job's runs logged
+// GET /api/projects/{id}/cron-jobs/{job}/logs?lines=1000
+func (s *Server) handleAPICronJobLogs(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	job, ok := s.loadCronJob(w, r, project)
+	if !ok {
+		return
+	}
+	limit, err := parseLogLines(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := s.svcManager.GetLogEntries(r.Context(), job.UnitName()+".service", "", limit)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	lines := make([]logparse.Line, len(entries))
+	for i, e := range entries {
+		lines[i] = logparse.Parse(e.Line()).WithPriority(e.Priority)
+	}
+	jsonResponse(w, logsResponse{Logs: joinLines(lines), Counts: countLevels(lines)})
+}
+
+// removeCronJobs removes the units of a project's cron jobs before the
+// project is deleted; the rows go with the project
+func (s *Server) removeCronJobs(ctx context.Context, project *storage.Project) {
+	jobs, err := s.store.ListCronJobs(ctx, project.ID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list cron jobs", "project_id", project.ID, "error", err)
+		return
+	}
+	for _, job := range jobs {
+		if err := cron.Remove(ctx, job); err != nil {
+			slog.WarnContext(ctx, "Failed to remove cron job", "job", job.Name, "error", err)
+		}
+	}
+}
+
+// loadCronJob reads the {job} cron job of the project. It writes the error response itself.
+func (s *Server) loadCronJob(w http.ResponseWriter, r *http.Request, project *storage.Project) (*storage.CronJob, bool) {
+	id, err := pathID(r, "job")
+	if err != nil {
+		jsonError(w, r, "Invalid cron job ID", http.StatusBadRequest)
+		return nil, false
+	}
+	job, err := s.store.GetCronJob(r.Context(), id)
+	if err != nil || job == nil || job.ProjectID != project.ID {
+		jsonError(w, r, "Cron job not found", http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
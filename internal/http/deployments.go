@@ -0,0 +1,84 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"servio/internal/storage"
+)
+
+// handleListDeployments lists a service's deployments, newest first
+// GET /api/services/{id}/deployments?limit=20
+func (s *Server) handleListDeployments(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	deployments, err := s.store.ListDeployments(r.Context(), service.ID, limit)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, deployments)
+}
+
+// handleStartDeployment starts a new deployment in the background
+// POST /api/services/{id}/deployments
+func (s *Server) handleStartDeployment(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error { return s.deployer.DryRun(ctx, service) })
+		return
+	}
+	deployment, err := s.deployer.Start(r.Context(), service)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, deployment)
+}
+
+// handleGetDeployment returns a deployment including its log
+// GET /api/services/{id}/deployments/{dep}
+func (s *Server) handleGetDeployment(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if deployment, ok := s.loadDeployment(w, r, service); ok {
+		jsonResponse(w, deployment)
+	}
+}
+
+// handleDeleteDeployment deletes a finished deployment record
+// DELETE /api/services/{id}/deployments/{dep}
+func (s *Server) handleDeleteDeployment(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	deployment, ok := s.loadDeployment(w, r, service)
+	if !ok {
+		return
+	}
+	if deployment.FinishedAt == nil {
+		jsonError(w, r, "Deployment is still in progress", http.StatusConflict)
+		return
+	}
+	if err := s.store.DeleteDeployment(r.Context(), deployment.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadDeployment reads the {dep} deployment of the service. It writes the error response itself.
+func (s *Server) loadDeployment(w http.ResponseWriter, r *http.Request, service *storage.Service) (*storage.Deployment, bool) {
+	depID, err := pathID(r, "dep")
+	if err != nil {
+		jsonError(w, r, "Invalid deployment ID", http.StatusBadRequest)
+		return nil, false
+	}
+	deployment, err := s.store.GetDeployment(r.Context(), depID)
+	if err != nil {
+		apiError(w, r, err)
+		return nil, false
+	}
+	if deployment == nil || deployment.ServiceID != service.ID {
+		jsonError(w, r, "Deployment not found", http.StatusNotFound)
+		return nil, false
+	}
+	return deployment, true
+}
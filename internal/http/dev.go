@@ -0,0 +1,106 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateFS is where templates are parsed from on every render: the embedded
+// copy, or the source tree in dev mode
+var templateFS fs.FS = templatesFS
+
+// devMode turns template failures into detailed error pages
+var devMode bool
+
+// DefaultDevDir is where dev mode finds templates/ and static/ when run from the repository root
+const DefaultDevDir = "internal/http"
+
+// EnableDevMode serves templates and static files from dir on disk instead of
+// the embedded copies, so edits show up on the next request, and renders
+// template errors as pages with the failing source lines
+func (s *Server) EnableDevMode(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "templates", "layout.html")); err != nil {
+		return fmt.Errorf("dev mode needs the source tree (run from the repository root): %w", err)
+	}
+	templateFS = os.DirFS(dir)
+	devMode = true
+	s.static = devStatic(http.Dir(filepath.Join(dir, "static")))
+	return nil
+}
+
+// devStatic serves static files straight from disk and tells browsers to
+// revalidate every time, ignoring the ?v= cache busting
+func devStatic(root http.FileSystem) http.Handler {
+	files := http.StripPrefix("/static/", http.FileServer(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// templateErrorLocation finds the file and line in errors such as
+// "template: project_form.html:12:5: executing ..."
+var templateErrorLocation = regexp.MustCompile(`template: ([\w.-]+\.html):(\d+)`)
+
+// devErrorContext is how many lines are shown around the failing template line
+const devErrorContext = 5
+
+var devErrorTemplate = template.Must(template.New("dev-error").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Template error</title>
+<style>
+body { font-family: ui-monospace, monospace; margin: 2rem; background: #1e1e2e; color: #cdd6f4; }
+h1 { color: #f38ba8; font-size: 1.2rem; }
+pre { background: #11111b; padding: 1rem; overflow-x: auto; }
+.line { display: block; }
+.fail { background: #45273a; color: #f38ba8; }
+</style></head>
+<body>
+<h1>Failed to render {{.Template}}</h1>
+<pre>{{.Error}}</pre>
+{{if .Lines}}<h2>{{.File}}</h2>
+<pre>{{range .Lines}}<span class="line{{if .Fail}} fail{{end}}">{{printf "%4d" .Number}}  {{.Text}}</span>{{end}}</pre>{{end}}
+</body></html>`))
+
+type devErrorLine struct {
+	Number int
+	Text   string
+	Fail   bool
+}
+
+// renderDevError writes a page describing a template failure, quoting the
+// template source around the line the error points at
+func renderDevError(w http.ResponseWriter, tmplName string, err error) {
+	data := struct {
+		Template, Error, File string
+		Lines                 []devErrorLine
+	}{Template: tmplName, Error: err.Error()}
+
+	if m := templateErrorLocation.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		if src, readErr := fs.ReadFile(templateFS, "templates/"+m[1]); readErr == nil {
+			data.File = m[1]
+			lines := strings.Split(string(src), "\n")
+			for n := max(line-devErrorContext, 1); n <= min(line+devErrorContext, len(lines)); n++ {
+				data.Lines = append(data.Lines, devErrorLine{Number: n, Text: lines[n-1], Fail: n == line})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if execErr := devErrorTemplate.Execute(&buf, data); execErr != nil {
+		http.Error(w, errors.Join(err, execErr).Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"servio/internal/nginx"
+	"servio/internal/storage"
+)
+
+// dnsCheckTimeout bounds the lookups of one DNS check
+const dnsCheckTimeout = 5 * time.Second
+
+// checkDomainDNS checks that a project's domain resolves to the server its
+// site is deployed on: this server, or the agent host it runs on. It also
+// returns the dns_check mode; the check is nil when the project has no domain
+// or the mode is off.
+func (s *Server) checkDomainDNS(ctx context.Context, project *storage.Project) (*nginx.DNSCheck, string, error) {
+	mode, err := s.store.GetSetting(ctx, storage.SettingDNSCheck)
+	if err != nil {
+		return nil, "", err
+	}
+	if mode == "off" || project.Domain == "" {
+		return nil, mode, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	ips, ipErr := s.serverIPs(ctx, project)
+	check := nginx.CheckDNS(ctx, net.DefaultResolver, project.Domain, ips)
+	if ipErr != nil && check.Status == nginx.DNSUnverified {
+		check.Problem = ipErr.Error()
+	}
+	if project.HostID == 0 {
+		// Agents choose whether their sites listen on IPv6 themselves
+		ipv6, err := s.store.GetSetting(ctx, storage.SettingNginxIPv6)
+		if err != nil {
+			return nil, "", err
+		}
+		nginx.CheckIPv6(check, ips, ipv6 == "true")
+	}
+	return check, mode, nil
+}
+
+// serverIPs returns the public addresses of the server a project's site is
+// deployed on. An agent host's are those its URL resolves to.
+func (s *Server) serverIPs(ctx context.Context, project *storage.Project) ([]net.IP, error) {
+	if project.HostID == 0 {
+		configured, err := s.store.GetSetting(ctx, storage.SettingPublicIP)
+		if err != nil {
+			return nil, err
+		}
+		return nginx.PublicIPs(configured)
+	}
+
+	host, err := s.store.GetHost(ctx, project.HostID)
+	if err != nil {
+		return nil, err
+	}
+	if host == nil {
+		return nil, fmt.Errorf("host %d not found", project.HostID)
+	}
+	u, err := url.Parse(host.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of host %s: %w", host.Name, err)
+	}
+	ips, err := nginx.LookupPublicIPs(ctx, net.DefaultResolver, u.Hostname())
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("the URL of host %s has no public address", host.Name)
+	}
+	return ips, err
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"servio/internal/dryrun"
+)
+
+// dryRunHeader (or the dry_run query parameter) asks for a plan instead of changes
+const dryRunHeader = "X-Dry-Run"
+
+// dryRunRoutes support dry runs, as "METHOD pattern" with path.Match patterns.
+// Any other write with the flag set is rejected rather than silently performed.
+var dryRunRoutes = map[string][]string{
+	http.MethodPost:   {"/api/services/*/install", "/api/services/*/provision", "/api/services/*/scale", "/api/services/*/deployments", "/api/nginx/*/deploy", "/api/nginx/*/remove", "/api/nginx/*/certificate", "/api/certificates", "/api/system/journal/vacuum", "/api/system/reconcile", "/api/system/updates", "/api/system/reboot", "/api/system/shutdown", "/api/projects/*/cron-jobs", "/api/projects/*/cron-jobs/*/run", "/api/services/*/postgres/databases", "/api/services/*/postgres/roles", "/api/services/*/postgres/roles/*/password", "/api/services/*/redis/flush", "/api/services/*/env/sync", "/api/services/*/exec"},
+	http.MethodPut:    {"/api/services/*/journal-retention", "/api/services/*/file-logging", "/api/projects/*/cron-jobs/*", "/api/services/*/redis/memory", "/api/services/*/worker", "/api/projects/*/budget"},
+	http.MethodDelete: {"/api/projects/*", "/api/nginx/*/certificate", "/api/projects/*/cron-jobs/*", "/api/services/*", "/api/services/*/journal-retention", "/api/services/*/file-logging", "/api/projects/*/budget"},
+}
+
+// isDryRun reports whether the request asks for a dry run. An unparsable value
+// counts as a dry run, since guessing "no" could change a production host.
+func isDryRun(r *http.Request) bool {
+	v := r.Header.Get(dryRunHeader)
+	if v == "" {
+		v = r.URL.Query().Get("dry_run")
+	}
+	if v == "" {
+		return false
+	}
+	on, err := strconv.ParseBool(v)
+	return on || err != nil
+}
+
+// DryRun rejects dry runs of writes that cannot honor them
+func DryRun(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && isDryRun(r) &&
+			!matchAny(dryRunRoutes[r.Method], r.URL.Path) {
+			jsonError(w, r, "Dry run is not supported for this endpoint", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondDryRun runs op against a plan and responds with the actions it recorded
+func respondDryRun(w http.ResponseWriter, r *http.Request, op func(ctx context.Context) error) {
+	plan := &dryrun.Plan{}
+	if err := op(dryrun.WithPlan(r.Context(), plan)); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, dryRunResponse{DryRun: true, Actions: plan.Actions()})
+}
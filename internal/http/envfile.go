@@ -0,0 +1,230 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"servio/internal/envfile"
+	"servio/internal/storage"
+)
+
+// envVarRequest is the body for setting a variable
+type envVarRequest struct {
+	Value  string `json:"value"`
+	Secret bool   `json:"secret"`
+}
+
+// envPathRequest is the body for setting where the file goes
+type envPathRequest struct {
+	Path string `json:"path"` // absolute; empty for .env in the working directory
+}
+
+// envResponse is a service's variables, secrets masked, and where they are written
+type envResponse struct {
+	Path   string            `json:"path"` // empty when the service has nowhere to write it
+	Custom bool              `json:"custom_path"`
+	Vars   []*storage.EnvVar `json:"vars"`
+	// Inherited are the project's variables the service does not set itself
+	Inherited []*storage.EnvVar `json:"inherited"`
+	WrittenAt *time.Time        `json:"written_at"`
+}
+
+// projectEnvResponse is the variables a project's services share, secrets masked
+type projectEnvResponse struct {
+	Vars []*storage.EnvVar `json:"vars"`
+}
+
+// envSyncResponse reports where the file was written
+type envSyncResponse struct {
+	Status string `json:"status"`
+	Path   string `json:"path,omitempty"`
+}
+
+// handleAPIServiceEnv returns a service's managed variables and those it
+// inherits from its project, with secrets masked
+// GET /api/services/{id}/env
+func (s *Server) handleAPIServiceEnv(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	vars, err := s.store.ListEnvVars(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	file, err := s.store.GetEnvFile(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	shared, err := s.store.ListProjectEnvVars(r.Context(), service.ProjectID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	inherited := []*storage.EnvVar{}
+	for _, v := range shared {
+		if !slices.ContainsFunc(vars, func(own *storage.EnvVar) bool { return own.Key == v.Key }) {
+			inherited = append(inherited, v)
+		}
+	}
+	envfile.Mask(vars)
+	envfile.Mask(inherited)
+	resp := envResponse{Vars: vars, Inherited: inherited}
+	resp.Path, _ = envfile.Path(service, file)
+	if file != nil {
+		resp.Custom, resp.WrittenAt = file.Path != "", file.WrittenAt
+	}
+	jsonResponse(w, resp)
+}
+
+// handleAPISetEnvVar creates or replaces a variable; secrets are stored encrypted
+// PUT /api/services/{id}/env/vars/{key} {"value","secret"}
+func (s *Server) handleAPISetEnvVar(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	key := r.PathValue("key")
+	if err := envfile.CheckKey(key); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	var req envVarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	v := &storage.EnvVar{ServiceID: service.ID, Key: key, Value: req.Value, Secret: req.Secret}
+	if err := s.envFiles.Encrypt(v); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.SetEnvVar(r.Context(), v); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	envfile.Mask([]*storage.EnvVar{v})
+	jsonResponse(w, v)
+}
+
+// handleAPIDeleteEnvVar removes a variable
+// DELETE /api/services/{id}/env/vars/{key}
+func (s *Server) handleAPIDeleteEnvVar(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if err := s.store.DeleteEnvVar(r.Context(), service.ID, r.PathValue("key")); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIProjectEnv returns the variables shared by a project's services
+// with secrets masked
+// GET /api/projects/{id}/env
+func (s *Server) handleAPIProjectEnv(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	vars, err := s.store.ListProjectEnvVars(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	envfile.Mask(vars)
+	jsonResponse(w, projectEnvResponse{Vars: vars})
+}
+
+// handleAPISetProjectEnvVar creates or replaces a variable every service of
+// the project inherits; it reaches their files when they are next written
+// PUT /api/projects/{id}/env/vars/{key} {"value","secret"}
+func (s *Server) handleAPISetProjectEnvVar(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	key := r.PathValue("key")
+	if err := envfile.CheckKey(key); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	var req envVarRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	v := &storage.EnvVar{ProjectID: project.ID, Key: key, Value: req.Value, Secret: req.Secret}
+	if err := s.envFiles.Encrypt(v); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.SetProjectEnvVar(r.Context(), v); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	envfile.Mask([]*storage.EnvVar{v})
+	jsonResponse(w, v)
+}
+
+// handleAPIDeleteProjectEnvVar removes a shared variable
+// DELETE /api/projects/{id}/env/vars/{key}
+func (s *Server) handleAPIDeleteProjectEnvVar(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if err := s.store.DeleteProjectEnvVar(r.Context(), project.ID, r.PathValue("key")); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPISetEnvPath sets where a service's file is written, such as the
+// path a unit's EnvironmentFile= reads
+// PUT /api/services/{id}/env/path {"path"}
+func (s *Server) handleAPISetEnvPath(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req envPathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Path != "" {
+		if !filepath.IsAbs(req.Path) {
+			apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "path", Message: "must be an absolute path"}}})
+			return
+		}
+		req.Path = filepath.Clean(req.Path)
+	}
+	if err := s.store.SetEnvFilePath(r.Context(), service.ID, req.Path); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.handleAPIServiceEnv(w, r, service)
+}
+
+// handleAPISyncEnv writes a service's file now rather than on its next deploy
+// POST /api/services/{id}/env/sync
+func (s *Server) handleAPISyncEnv(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if err := s.checkServiceLocal(r.Context(), service, ".env files"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			_, err := s.envFiles.Sync(ctx, service)
+			return err
+		})
+		return
+	}
+	path, err := s.envFiles.Sync(r.Context(), service)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if path == "" {
+		jsonResponse(w, envSyncResponse{Status: "skipped"})
+		return
+	}
+	jsonResponse(w, envSyncResponse{Status: "written", Path: path})
+}
+
+// handleAPIEnvDrift compares the file on disk with the managed variables
+// GET /api/services/{id}/env/drift
+func (s *Server) handleAPIEnvDrift(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if err := s.checkServiceLocal(r.Context(), service, ".env files"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	drift, err := s.envFiles.Drift(r.Context(), service)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, drift)
+}
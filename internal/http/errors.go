@@ -0,0 +1,193 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"servio/internal/acme"
+	"servio/internal/agent"
+	"servio/internal/ansi"
+	"servio/internal/blueprints"
+	"servio/internal/container"
+	"servio/internal/deploy"
+	"servio/internal/envfile"
+	"servio/internal/hostuser"
+	"servio/internal/i18n"
+	"servio/internal/iac"
+	"servio/internal/jobs"
+	"servio/internal/logparse"
+	"servio/internal/nginx"
+	"servio/internal/postgres"
+	"servio/internal/redis"
+	"servio/internal/secrets"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+	"servio/internal/sysupdate"
+)
+
+// Machine-readable error codes returned in errorResponse.Code
+const (
+	codeBadRequest         = "bad_request"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeNotFound           = "not_found"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeConflict           = "conflict"
+	codeValidationFailed   = "validation_failed"
+	codeTooManyRequests    = "too_many_requests"
+	codeTooLarge           = "payload_too_large"
+	codeInternal           = "internal_error"
+	codeUnavailable        = "service_unavailable"
+	codePortConflict       = "port_conflict"
+	codeDeployInProgress   = "deploy_in_progress"
+	codeDependencyCycle    = "dependency_cycle"
+	codeSystemdFailed      = "systemd_failed"
+	codeNginxConfigInvalid = "nginx_config_invalid"
+	codeQueueFull          = "queue_full"
+	codeTimeout            = "timeout"
+	codeAgentFailed        = "agent_failed"
+	codeLocalOnly          = "local_only"
+	codeSystemdOnly        = "systemd_only"
+	codeContainerFailed    = "container_failed"
+	codeDNSMismatch        = "dns_mismatch"
+	codePostgresFailed     = "postgres_failed"
+	codeRedisFailed        = "redis_failed"
+	codeSecretProvider     = "secret_provider_failed"
+	codePackagesFailed     = "package_manager_failed"
+	codeReauthRequired     = "reauthentication_required"
+	codeCertbotFailed      = "certbot_failed"
+	codeCatalogFailed      = "catalog_failed"
+)
+
+// statusCodes is the default code for responses that don't name a more specific one
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeBadRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusConflict:              codeConflict,
+	http.StatusUnprocessableEntity:   codeValidationFailed,
+	http.StatusTooManyRequests:       codeTooManyRequests,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusGatewayTimeout:        codeTimeout,
+	http.StatusInternalServerError:   codeInternal,
+	http.StatusServiceUnavailable:    codeUnavailable,
+}
+
+// errorMapping maps a domain error to the HTTP status and code it is reported with
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// errorMappings is checked in order with errors.Is; the first match wins
+var errorMappings = []errorMapping{
+	{storage.ErrValidation, http.StatusUnprocessableEntity, codeValidationFailed},
+	{storage.ErrPortConflict, http.StatusConflict, codePortConflict},
+	{storage.ErrHostInUse, http.StatusConflict, codeConflict},
+	{storage.ErrInvalidSort, http.StatusBadRequest, codeBadRequest},
+	{ansi.ErrInvalidMode, http.StatusBadRequest, codeBadRequest},
+	{logparse.ErrInvalidFilter, http.StatusBadRequest, codeBadRequest},
+	{storage.ErrInvalidScope, http.StatusBadRequest, codeBadRequest},
+	{storage.ErrInvalidActivitySource, http.StatusBadRequest, codeBadRequest},
+	{storage.ErrUnknownSetting, http.StatusNotFound, codeNotFound},
+	{storage.ErrInvalidSetting, http.StatusUnprocessableEntity, codeValidationFailed},
+	{secrets.ErrSecretNotFound, http.StatusUnprocessableEntity, codeValidationFailed},
+	{secrets.ErrInvalidReference, http.StatusUnprocessableEntity, codeValidationFailed},
+	{secrets.ErrProviderFailed, http.StatusBadGateway, codeSecretProvider},
+	{deploy.ErrDeployInProgress, http.StatusConflict, codeDeployInProgress},
+	{systemd.ErrInvalidRetention, http.StatusUnprocessableEntity, codeValidationFailed},
+	{systemd.ErrInvalidLogRotation, http.StatusUnprocessableEntity, codeValidationFailed},
+	{systemd.ErrInvalidBudget, http.StatusUnprocessableEntity, codeValidationFailed},
+	{systemd.ErrDependencyCycle, http.StatusConflict, codeDependencyCycle},
+	{systemd.ErrCommandFailed, http.StatusInternalServerError, codeSystemdFailed},
+	{nginx.ErrConfigTest, http.StatusUnprocessableEntity, codeNginxConfigInvalid},
+	{nginx.ErrDNSMismatch, http.StatusUnprocessableEntity, codeDNSMismatch},
+	{nginx.ErrUnknownLog, http.StatusNotFound, codeNotFound},
+	{nginx.ErrInvalidUpstreamHost, http.StatusUnprocessableEntity, codeValidationFailed},
+	{nginx.ErrProtocolUnsupported, http.StatusUnprocessableEntity, codeValidationFailed},
+	{jobs.ErrQueueFull, http.StatusServiceUnavailable, codeQueueFull},
+	{jobs.ErrNotActive, http.StatusConflict, codeConflict},
+	{agent.ErrLocalOnly, http.StatusConflict, codeLocalOnly},
+	{agent.ErrAgent, http.StatusBadGateway, codeAgentFailed},
+	{container.ErrSystemdOnly, http.StatusConflict, codeSystemdOnly},
+	{errExecUnsupported, http.StatusConflict, codeSystemdOnly},
+	{container.ErrEngine, http.StatusInternalServerError, codeContainerFailed},
+	{postgres.ErrInvalidName, http.StatusUnprocessableEntity, codeValidationFailed},
+	{postgres.ErrNotPostgres, http.StatusConflict, codeConflict},
+	{postgres.ErrCommandFailed, http.StatusInternalServerError, codePostgresFailed},
+	{redis.ErrInvalidConfig, http.StatusUnprocessableEntity, codeValidationFailed},
+	{redis.ErrNotRedis, http.StatusConflict, codeConflict},
+	{redis.ErrCommandFailed, http.StatusInternalServerError, codeRedisFailed},
+	{blueprints.ErrNotWorker, http.StatusConflict, codeConflict},
+	{blueprints.ErrInvalidWorkerSettings, http.StatusUnprocessableEntity, codeValidationFailed},
+	{blueprints.ErrCatalogNotConfigured, http.StatusConflict, codeConflict},
+	{blueprints.ErrNotInCatalog, http.StatusNotFound, codeNotFound},
+	{blueprints.ErrCatalogUnavailable, http.StatusBadGateway, codeCatalogFailed},
+	{blueprints.ErrBadSignature, http.StatusBadGateway, codeCatalogFailed},
+	{blueprints.ErrInvalidDefinition, http.StatusBadGateway, codeCatalogFailed},
+	{envfile.ErrInvalidKey, http.StatusUnprocessableEntity, codeValidationFailed},
+	{envfile.ErrNoPath, http.StatusConflict, codeConflict},
+	{iac.ErrUnknownFormat, http.StatusBadRequest, codeBadRequest},
+	{hostuser.ErrInvalidName, http.StatusUnprocessableEntity, codeValidationFailed},
+	{sysupdate.ErrUnsupported, http.StatusConflict, codeConflict},
+	{sysupdate.ErrInvalidPackage, http.StatusUnprocessableEntity, codeValidationFailed},
+	{sysupdate.ErrCommandFailed, http.StatusInternalServerError, codePackagesFailed},
+	{errReauthRequired, http.StatusForbidden, codeReauthRequired},
+	{errServiceNotFound, http.StatusNotFound, codeNotFound},
+	{acme.ErrNoDomains, http.StatusUnprocessableEntity, codeValidationFailed},
+	{acme.ErrNoCredentials, http.StatusUnprocessableEntity, codeValidationFailed},
+	{acme.ErrUnknownProvider, http.StatusUnprocessableEntity, codeValidationFailed},
+	{acme.ErrCommandFailed, http.StatusInternalServerError, codeCertbotFailed},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, codeTimeout},
+}
+
+// classifyError returns the HTTP status and code for err
+func classifyError(err error) (int, string) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m.status, m.code
+		}
+	}
+	return http.StatusInternalServerError, codeInternal
+}
+
+// apiError reports err with the status and code of the domain error it wraps.
+// Validation errors also list the rejected fields.
+func apiError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := classifyError(err)
+	resp := errorResponse{Code: code, Error: err.Error()}
+
+	var verr *storage.ValidationError
+	if errors.As(err, &verr) {
+		resp.Details = verr.Fields
+	}
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "API request failed", "status", status, "code", code, "error", err)
+	}
+	writeError(w, r, status, resp)
+}
+
+// jsonError reports a message with the default code for status
+func jsonError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = codeInternal
+	}
+	writeError(w, r, status, errorResponse{Code: code, Error: message})
+}
+
+// writeError sends resp with Message set to Error translated into the
+// request's language, as Localize put it in the context; Error stays
+// English for clients that match on it
+func writeError(w http.ResponseWriter, r *http.Request, status int, resp errorResponse) {
+	resp.Message = i18n.T(i18n.FromContext(r.Context()), resp.Error)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/events"
+	"servio/internal/storage"
+)
+
+// handleAPIEvents streams bus events (service status transitions, job status
+// changes, deploys, nginx) as Server-Sent Events named after the event type.
+// Events are dropped for clients that fall too far behind.
+// GET /api/events?types=service.started,job.updated&project_id=1&service_id=2
+func (s *Server) handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	var types []string
+	if raw := q.Get("types"); raw != "" {
+		types = strings.Split(raw, ",")
+		for _, t := range types {
+			if !slices.Contains(events.Types, t) {
+				jsonError(w, r, "Unknown event type: "+t, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	projectID, _ := strconv.ParseInt(q.Get("project_id"), 10, 64)
+	serviceID, _ := strconv.ParseInt(q.Get("service_id"), 10, 64)
+
+	// Team-scoped users only receive events for their teams' projects
+	_, scoped := storage.TeamScopeFromContext(r.Context())
+	visible := make(map[int64]bool)
+
+	stream := make(chan events.Event, 64)
+	unsubscribe := s.events.Subscribe(func(e events.Event) {
+		if len(types) > 0 && !slices.Contains(types, e.Type) {
+			return
+		}
+		if (projectID != 0 && e.ProjectID != projectID) || (serviceID != 0 && e.ServiceID != serviceID) {
+			return
+		}
+		select {
+		case stream <- e:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	startSSE(w, flusher)
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			ssePing(w, flusher)
+		case e := <-stream:
+			if scoped && !s.projectVisible(r.Context(), e.ProjectID, visible) {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// errExecUnsupported is returned for one-off commands on a server whose
+// services do not run under systemd: mock, supervisor, OpenRC, and runit mode
+var errExecUnsupported = errors.New("one-off commands need services to run under systemd")
+
+// execRequest is a one-off command to run in a service's context
+type execRequest struct {
+	Command string `json:"command"`
+	Input   string `json:"input"`   // written to the command's stdin
+	Timeout int    `json:"timeout"` // seconds; 600 when zero
+}
+
+// execLine is a line a one-off command printed
+type execLine struct {
+	Stream string `json:"stream"` // stdout or stderr
+	Line   string `json:"line"`
+}
+
+// execDone ends the stream of a one-off command
+type execDone struct {
+	ExitCode   int    `json:"exit_code"` // -1 when the command was stopped
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleAPIServiceExec runs a one-off command such as a migration with the
+// service's user, working directory, and environment, and streams its
+// output over SSE as "output" events carrying an execLine, then a "done"
+// event carrying an execDone. The command is stopped when the client
+// disconnects or its timeout passes.
+// POST /api/services/{id}/exec {"command","input","timeout"}
+func (s *Server) handleAPIServiceExec(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req execRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var fields []storage.FieldError
+	if strings.TrimSpace(req.Command) == "" {
+		fields = append(fields, storage.FieldError{Field: "command", Message: "is required"})
+	}
+	if limit := int(systemd.MaxExecTimeout.Seconds()); req.Timeout < 0 || req.Timeout > limit {
+		fields = append(fields, storage.FieldError{Field: "timeout", Message: fmt.Sprintf("must be between 0 and %d seconds", limit)})
+	}
+	if len(fields) > 0 {
+		apiError(w, r, &storage.ValidationError{Fields: fields})
+		return
+	}
+	if err := checkSystemd(service, "exec"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "exec"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if s.units == nil {
+		apiError(w, r, errExecUnsupported)
+		return
+	}
+	// A scaled service's instances share its template; the first stands in for all
+	unit := service.ServiceName()
+	if service.Scaled() {
+		unit = service.InstanceName(1)
+	}
+	if !s.svcManager.ServiceExists(unit) {
+		jsonError(w, r, "Service is not installed", http.StatusConflict)
+		return
+	}
+	command := systemd.ExecRequest{Command: req.Command, Input: req.Input, Timeout: time.Duration(req.Timeout) * time.Second}
+
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			_, err := systemd.Exec(ctx, unit, command, func(string, string) {})
+			return err
+		})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The command's lines are written from this goroutine only; Exec stops
+	// the command once the client leaves and the request is cancelled
+	ctx := r.Context()
+	lines := make(chan execLine, 64)
+	done := make(chan execDone, 1)
+	go func() {
+		result, err := systemd.Exec(ctx, unit, command, func(stream, line string) {
+			select {
+			case lines <- execLine{Stream: stream, Line: line}:
+			case <-ctx.Done():
+			}
+		})
+		end := execDone{ExitCode: result.ExitCode, DurationMs: result.Duration.Milliseconds()}
+		if err != nil {
+			end.Error = err.Error()
+		}
+		close(lines)
+		done <- end
+	}()
+
+	startSSE(w, flusher)
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ssePing(w, flusher)
+		case line, ok := <-lines:
+			if !ok {
+				data, _ := json.Marshal(<-done)
+				fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+				flusher.Flush()
+				return
+			}
+			data, _ := json.Marshal(line)
+			fmt.Fprintf(w, "event: output\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servio/internal/storage"
+)
+
+// defaultMetricsRange is the export window when no from is given
+const defaultMetricsRange = 24 * time.Hour
+
+// inventoryRow is one service in the inventory export
+type inventoryRow struct {
+	ID      int64  `json:"id"`
+	Project string `json:"project"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Port    int    `json:"port,omitempty"`
+	Status  string `json:"status"`
+	Domain  string `json:"domain,omitempty"`
+}
+
+// handleAPIExportInventory dumps every service with its current status
+// GET /api/export/inventory?format=csv|json
+func (s *Server) handleAPIExportInventory(w http.ResponseWriter, r *http.Request) {
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	projects, err := s.store.ListProjects(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	rows := []inventoryRow{}
+	for _, p := range projects {
+		services, err := s.store.ListServicesByProject(r.Context(), p.ID)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		for _, sv := range services {
+			rows = append(rows, inventoryRow{
+				ID:      sv.ID,
+				Project: p.Name,
+				Name:    sv.Name,
+				Type:    sv.Type,
+				Port:    sv.Port,
+				Status:  s.serviceStatus(r.Context(), sv),
+				Domain:  p.Domain,
+			})
+		}
+	}
+
+	if format == "json" {
+		jsonResponse(w, rows)
+		return
+	}
+	records := [][]string{{"id", "project", "name", "type", "port", "status", "domain"}}
+	for _, row := range rows {
+		records = append(records, []string{
+			strconv.FormatInt(row.ID, 10), row.Project, row.Name, row.Type, strconv.Itoa(row.Port), row.Status, row.Domain,
+		})
+	}
+	writeCSV(w, "servio-inventory", records)
+}
+
+// handleAPIExportMetrics dumps host and service samples recorded in a time range
+// GET /api/export/metrics?format=csv|json&from=2024-05-01T00:00:00Z&to=...&service_id=3
+func (s *Server) handleAPIExportMetrics(w http.ResponseWriter, r *http.Request) {
+	format, ok := exportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	var filter storage.MetricFilter
+	var err error
+	if filter.From, filter.To, err = parseTimeRange(r, defaultMetricsRange); err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("service_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			jsonError(w, r, "Invalid service_id", http.StatusBadRequest)
+			return
+		}
+		filter.ServiceID = id
+	}
+	if q.Get("host") == "true" {
+		filter.ServiceID = -1
+	}
+
+	samples, err := s.store.ListMetricSamples(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	if format == "json" {
+		jsonResponse(w, samples)
+		return
+	}
+	formatFloat := func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }
+	records := [][]string{{"taken_at", "service_id", "service", "cpu_percent", "memory_percent", "memory_mb", "disk_percent"}}
+	for _, m := range samples {
+		records = append(records, []string{
+			m.TakenAt.UTC().Format(time.RFC3339), strconv.FormatInt(m.ServiceID, 10), m.Service,
+			formatFloat(m.CPUPercent), formatFloat(m.MemoryPercent), formatFloat(m.MemoryMB), formatFloat(m.DiskPercent),
+		})
+	}
+	writeCSV(w, "servio-metrics", records)
+}
+
+// parseTimeRange reads ?from= and ?to= as RFC 3339 times. to defaults to now
+// and from to def before to.
+func parseTimeRange(r *http.Request, def time.Duration) (from, to time.Time, err error) {
+	q := r.URL.Query()
+	to = time.Now()
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, errors.New("Invalid to: want an RFC 3339 time")
+		}
+	}
+	from = to.Add(-def)
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return from, to, errors.New("Invalid from: want an RFC 3339 time")
+		}
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// exportFormat reads ?format=, defaulting to json. It writes the error response itself.
+func exportFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		return "json", true
+	case "csv":
+		return "csv", true
+	default:
+		jsonError(w, r, "Invalid format: want csv or json", http.StatusBadRequest)
+		return "", false
+	}
+}
+
+// writeCSV sends records as a CSV download named after base and today's date
+func writeCSV(w http.ResponseWriter, base string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, base, time.Now().Format("20060102")))
+	csv.NewWriter(w).WriteAll(records)
+}
@@ -0,0 +1,224 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/logparse"
+	"servio/internal/storage"
+)
+
+// serviceStatusFilters maps ?status= values to the states reported by serviceStatus
+var serviceStatusFilters = map[string]string{
+	"running":       "running",
+	"stopped":       "stopped",
+	"not-installed": "not installed",
+}
+
+// dashboardFilter echoes the active filters back into the dashboard form
+type dashboardFilter struct {
+	Type   string
+	Tag    string
+	Status string
+}
+
+// parseStatusFilter reads ?status=running|stopped|not-installed
+func parseStatusFilter(r *http.Request) (string, error) {
+	v := r.URL.Query().Get("status")
+	if v == "" {
+		return "", nil
+	}
+	status, ok := serviceStatusFilters[v]
+	if !ok {
+		return "", fmt.Errorf("invalid status: %s (want running, stopped, or not-installed)", v)
+	}
+	return status, nil
+}
+
+// listProjects applies the storage filters and, when status is set, keeps only
+// projects with a service in that state. Unit state lives in systemd rather than
+// the database, so a status filter loads every candidate before cutting the page.
+func (s *Server) listProjects(ctx context.Context, opts storage.ListOptions, status string) ([]*storage.Project, int, error) {
+	if status == "" {
+		return s.store.ListProjectsPage(ctx, opts)
+	}
+
+	all := opts
+	all.Limit, all.Offset = 0, 0
+	projects, _, err := s.store.ListProjectsPage(ctx, all)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var matched []*storage.Project
+	for _, p := range projects {
+		services, err := s.store.ListServicesByProject(ctx, p.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, sv := range services {
+			if s.cachedServiceStatus(ctx, sv) == status {
+				matched = append(matched, p)
+				break
+			}
+		}
+	}
+	return paginate(matched, opts), len(matched), nil
+}
+
+// listServices is listProjects for services; matches carry their status
+func (s *Server) listServices(ctx context.Context, projectID int64, opts storage.ListOptions, status string) ([]*storage.Service, int, error) {
+	if status == "" {
+		return s.store.ListServicesPage(ctx, projectID, opts)
+	}
+
+	all := opts
+	all.Limit, all.Offset = 0, 0
+	services, _, err := s.store.ListServicesPage(ctx, projectID, all)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matched := []*storage.Service{}
+	for _, sv := range services {
+		if sv.Status = s.cachedServiceStatus(ctx, sv); sv.Status == status {
+			matched = append(matched, sv)
+		}
+	}
+	return paginate(matched, opts), len(matched), nil
+}
+
+// paginate cuts the opts page window out of an already filtered list
+func paginate[T any](items []T, opts storage.ListOptions) []T {
+	start := min(max(opts.Offset, 0), len(items))
+	items = items[start:]
+	if opts.Limit > 0 {
+		items = items[:min(opts.Limit, storage.MaxListLimit, len(items))]
+	}
+	if items == nil {
+		items = []T{}
+	}
+	return items
+}
+
+// parseLogFilters reads repeated ?filter=key=value parameters for logs
+func parseLogFilters(r *http.Request) ([]logparse.Filter, error) {
+	return logparse.ParseFilters(r.URL.Query()["filter"])
+}
+
+// filterLines keeps the lines that match every filter
+func filterLines(lines []logparse.Line, filters []logparse.Filter) []logparse.Line {
+	if len(filters) == 0 {
+		return lines
+	}
+	matched := []logparse.Line{}
+	for _, l := range lines {
+		if logparse.Match(l, filters) {
+			matched = append(matched, l)
+		}
+	}
+	return matched
+}
+
+// joinLines rebuilds the text of lines, one per line
+func joinLines(lines []logparse.Line) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.Raw + "\n")
+	}
+	return b.String()
+}
+
+// countLevels counts lines by level
+func countLevels(lines []logparse.Line) logLevelCounts {
+	var counts logLevelCounts
+	for _, l := range lines {
+		switch l.Level {
+		case logparse.LevelError:
+			counts.Error++
+		case logparse.LevelWarn:
+			counts.Warn++
+		case logparse.LevelDebug:
+			counts.Debug++
+		default:
+			counts.Info++
+		}
+	}
+	return counts
+}
+
+// Bounds on how many log lines one request returns
+const (
+	defaultLogLines = 1000
+	maxLogLines     = 10000
+)
+
+// logWindow is the slice of a service's logs a request asks for: the most
+// recent Lines since Since (zero means since the service last started),
+// newest first when Newest is set
+type logWindow struct {
+	Lines  int
+	Since  time.Time
+	Newest bool
+}
+
+// parseLogWindow reads ?lines=N, ?since= (a duration such as 30m or 2d, or an
+// RFC 3339 time), and ?order=oldest|newest
+func parseLogWindow(r *http.Request) (logWindow, error) {
+	q := r.URL.Query()
+	lines, err := parseLogLines(r)
+	if err != nil {
+		return logWindow{}, err
+	}
+	w := logWindow{Lines: lines}
+	if v := q.Get("since"); v != "" {
+		since, err := parseSince(v, time.Now())
+		if err != nil {
+			return w, err
+		}
+		w.Since = since
+	}
+	switch v := q.Get("order"); v {
+	case "", "oldest":
+	case "newest":
+		w.Newest = true
+	default:
+		return w, fmt.Errorf("invalid order: %s (want oldest or newest)", v)
+	}
+	return w, nil
+}
+
+// parseLogLines reads ?lines=N, defaulting to defaultLogLines and capped at maxLogLines
+func parseLogLines(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("lines")
+	if v == "" {
+		return defaultLogLines, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid lines: %s (want a positive number)", v)
+	}
+	return min(n, maxLogLines), nil
+}
+
+// parseSince turns a duration before now, with d for days, or an RFC 3339
+// time into the time logs should start from
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	}
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid since: %s (want a duration such as 1h or 2d, or an RFC 3339 time)", v)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"servio/internal/storage"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "30m", want: now.Add(-30 * time.Minute)},
+		{in: "1h30m", want: now.Add(-90 * time.Minute)},
+		{in: "2d", want: now.Add(-48 * time.Hour)},
+		{in: "2026-03-01T08:00:00+02:00", want: time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)},
+		{in: "0s", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "0d", wantErr: true},
+		{in: "1.5d", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "yesterday", wantErr: true},
+		{in: "2026-03-01", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSince(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseLogWindow(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    logWindow
+		wantErr bool
+	}{
+		{query: "", want: logWindow{Lines: defaultLogLines}},
+		{query: "lines=50&order=newest", want: logWindow{Lines: 50, Newest: true}},
+		{query: "lines=999999&order=oldest", want: logWindow{Lines: maxLogLines}},
+		{query: "since=2026-03-01T00:00:00Z", want: logWindow{Lines: defaultLogLines, Since: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}},
+		{query: "lines=0", wantErr: true},
+		{query: "lines=ten", wantErr: true},
+		{query: "order=random", wantErr: true},
+		{query: "since=soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/services/1/logs?"+tt.query, nil)
+		got, err := parseLogWindow(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLogWindow(%q) = %+v, want an error", tt.query, got)
+			}
+			continue
+		}
+		if err != nil || got.Lines != tt.want.Lines || !got.Since.Equal(tt.want.Since) || got.Newest != tt.want.Newest {
+			t.Errorf("parseLogWindow(%q) = %+v, %v; want %+v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseListOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    storage.ListOptions
+		wantErr bool
+	}{
+		{query: "", want: storage.ListOptions{}},
+		{query: "sort=-name&limit=20&offset=40", want: storage.ListOptions{Sort: "-name", Limit: 20, Offset: 40}},
+		{query: "type=+web+&tag=+Prod+", want: storage.ListOptions{Type: "web", Tag: "prod"}},
+		{query: "limit=-1", wantErr: true},
+		{query: "limit=all", wantErr: true},
+		{query: "offset=-5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/services?"+tt.query, nil)
+		got, err := parseListOptions(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseListOptions(%q) = %+v, want an error", tt.query, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseListOptions(%q) = %+v, %v; want %+v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseStatusFilter(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "", want: ""},
+		{query: "status=running", want: "running"},
+		{query: "status=not-installed", want: "not installed"},
+		{query: "status=not+installed", wantErr: true},
+		{query: "status=failed", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/services?"+tt.query, nil)
+		got, err := parseStatusFilter(r)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStatusFilter(%q) = %q, %v; want %q", tt.query, got, err, tt.want)
+		}
+	}
+}
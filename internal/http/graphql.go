@@ -0,0 +1,311 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/graphql"
+	"servio/internal/monitor"
+	"servio/internal/storage"
+)
+
+// graphqlPath serves the GraphQL API; graphqlSchemaPath serves its schema
+const (
+	graphqlPath       = "/graphql"
+	graphqlSchemaPath = "/graphql/schema"
+)
+
+// graphqlServiceStat is a service's resource use in the Stats type, which
+// lists services rather than keying them by unit
+type graphqlServiceStat struct {
+	Unit string `json:"unit"`
+	monitor.ServiceStat
+}
+
+// graphqlStatsKey holds a request's stats once read, so that asking for the
+// stats of many services reads the host once
+type graphqlStatsKey struct{}
+
+type graphqlStats struct {
+	read  bool
+	stats statsResponse
+}
+
+// cachedStats returns s.stats for the GraphQL request of ctx
+func (s *Server) cachedStats(ctx context.Context) statsResponse {
+	cache, _ := ctx.Value(graphqlStatsKey{}).(*graphqlStats)
+	if cache == nil {
+		return s.stats(ctx)
+	}
+	if !cache.read {
+		cache.stats, cache.read = s.stats(ctx), true
+	}
+	return cache.stats
+}
+
+// graphqlSchema builds the GraphQL schema. Resolvers read through the store
+// with the request's context, so users limited to their teams see only
+// their teams' projects, as with the REST API.
+func (s *Server) graphqlSchema() *graphql.Schema {
+	nonNull := graphql.NonNullOf
+	listOf := func(t graphql.Type) graphql.Type { return nonNull(graphql.ListOf(nonNull(t))) }
+	field := func(name string, t graphql.Type) *graphql.Field { return &graphql.Field{Name: name, Type: t} }
+	idArg := []*graphql.Arg{{Name: "id", Type: nonNull(graphql.ID)}}
+
+	project := &graphql.Object{Name: "Project", Description: "A group of services deployed together"}
+	service := &graphql.Object{Name: "Service", Description: "A managed process, database, or container"}
+	deployment := &graphql.Object{Name: "Deployment", Description: "A pull, build, and restart of a service", Fields: []*graphql.Field{
+		field("id", nonNull(graphql.ID)),
+		field("serviceId", nonNull(graphql.ID)),
+		field("commit", graphql.String),
+		field("status", nonNull(graphql.String)),
+		field("actor", nonNull(graphql.String)),
+		field("log", graphql.String),
+		{Name: "jobId", Type: graphql.ID,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return optionalID(p.Source.(*storage.Deployment).JobID), nil
+			}},
+		field("startedAt", graphql.Time),
+		field("finishedAt", graphql.Time),
+		field("createdAt", nonNull(graphql.Time)),
+	}}
+	logLine := &graphql.Object{Name: "LogLine", Description: "A line of a service's log", Fields: []*graphql.Field{
+		field("time", graphql.String),
+		field("level", nonNull(graphql.String)),
+		field("message", nonNull(graphql.String)),
+		field("raw", nonNull(graphql.String)),
+	}}
+	serviceStat := &graphql.Object{Name: "ServiceStat", Description: "A service's resource use", Fields: []*graphql.Field{
+		field("unit", nonNull(graphql.String)),
+		{Name: "cpuUsage", Description: "Percent of one CPU", Type: nonNull(graphql.Float)},
+		{Name: "memoryUsage", Description: "In MB", Type: nonNull(graphql.Float)},
+		field("activeState", nonNull(graphql.String)),
+	}}
+	stats := &graphql.Object{Name: "Stats", Description: "This server's resources and its services' use of them", Fields: []*graphql.Field{
+		{Name: "cpuUsage", Description: "Percent", Type: nonNull(graphql.Float)},
+		{Name: "memoryUsage", Description: "Percent", Type: nonNull(graphql.Float)},
+		{Name: "memoryTotal", Description: "In GB", Type: nonNull(graphql.Float)},
+		{Name: "memoryUsed", Description: "In GB", Type: nonNull(graphql.Float)},
+		{Name: "diskUsage", Description: "Percent", Type: nonNull(graphql.Float)},
+		{Name: "diskTotal", Description: "In GB", Type: nonNull(graphql.Float)},
+		{Name: "diskUsed", Description: "In GB", Type: nonNull(graphql.Float)},
+		field("uptime", nonNull(graphql.String)),
+		field("osName", nonNull(graphql.String)),
+		field("osVersion", nonNull(graphql.String)),
+		{Name: "services", Description: "Including those on agent hosts", Type: listOf(serviceStat),
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				stats := p.Source.(statsResponse)
+				list := make([]graphqlServiceStat, 0, len(stats.Services))
+				for unit, stat := range stats.Services {
+					list = append(list, graphqlServiceStat{Unit: unit, ServiceStat: stat})
+				}
+				sort.Slice(list, func(i, j int) bool { return list[i].Unit < list[j].Unit })
+				return list, nil
+			}},
+	}}
+
+	project.Fields = []*graphql.Field{
+		field("id", nonNull(graphql.ID)),
+		field("name", nonNull(graphql.String)),
+		field("description", nonNull(graphql.String)),
+		field("domain", graphql.String),
+		field("notes", graphql.String),
+		field("tags", listOf(graphql.String)),
+		{Name: "hostId", Description: "The agent host running the project; null for this server", Type: graphql.ID,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return optionalID(p.Source.(*storage.Project).HostID), nil
+			}},
+		field("createdAt", nonNull(graphql.Time)),
+		field("updatedAt", nonNull(graphql.Time)),
+		{Name: "services", Type: listOf(service),
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.store.ListServicesByProject(ctx, p.Source.(*storage.Project).ID)
+			}},
+	}
+	service.Fields = []*graphql.Field{
+		field("id", nonNull(graphql.ID)),
+		field("projectId", nonNull(graphql.ID)),
+		field("name", nonNull(graphql.String)),
+		field("type", nonNull(graphql.String)),
+		field("version", graphql.String),
+		field("runtime", graphql.String),
+		field("image", graphql.String),
+		field("port", graphql.Int),
+		field("gitRepoUrl", graphql.String),
+		field("gitBranch", graphql.String),
+		field("gitFilter", graphql.String),
+		field("command", nonNull(graphql.String)),
+		field("workingDir", nonNull(graphql.String)),
+		field("user", nonNull(graphql.String)),
+		field("environment", nonNull(graphql.String)),
+		field("autoRestart", nonNull(graphql.Boolean)),
+		field("tags", listOf(graphql.String)),
+		field("notes", graphql.String),
+		field("replicas", graphql.Int),
+		field("createdAt", nonNull(graphql.Time)),
+		field("updatedAt", nonNull(graphql.Time)),
+		{Name: "status", Description: "running, stopped, or not installed", Type: nonNull(graphql.String),
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.cachedServiceStatus(ctx, p.Source.(*storage.Service)), nil
+			}},
+		{Name: "project", Type: project,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.store.GetProject(ctx, p.Source.(*storage.Service).ProjectID)
+			}},
+		{Name: "deployments", Description: "Newest first", Type: listOf(deployment),
+			Args: []*graphql.Arg{{Name: "limit", Type: graphql.Int, Default: 20}},
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				limit, _ := p.Args["limit"].(int)
+				limit = min(max(limit, 0), storage.MaxListLimit)
+				return s.store.ListDeployments(ctx, p.Source.(*storage.Service).ID, limit)
+			}},
+		{Name: "logs", Description: "The most recent lines since the service last started", Type: listOf(logLine),
+			Args: []*graphql.Arg{{Name: "lines", Type: graphql.Int, Default: 100}},
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				lines, _ := p.Args["lines"].(int)
+				lines = min(max(lines, 1), maxLogLines)
+				logs, _, err := s.serviceLogs(ctx, p.Source.(*storage.Service), time.Time{}, lines)
+				return logs, err
+			}},
+		{Name: "stats", Description: "Null while the service is not running", Type: serviceStat,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				unit := p.Source.(*storage.Service).ServiceName()
+				stat, ok := s.cachedStats(ctx).Services[unit]
+				if !ok {
+					return nil, nil
+				}
+				return graphqlServiceStat{Unit: unit, ServiceStat: stat}, nil
+			}},
+	}
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "projects", Type: listOf(project),
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.store.ListProjects(ctx)
+			}},
+		{Name: "project", Type: project, Args: idArg,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.store.GetProject(ctx, p.Args["id"].(int64))
+			}},
+		{Name: "services", Type: listOf(service),
+			Args: []*graphql.Arg{{Name: "projectId", Description: "Only this project's services", Type: graphql.ID}},
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				projectID, _ := p.Args["projectId"].(int64)
+				services, _, err := s.store.ListServicesPage(ctx, projectID, storage.ListOptions{})
+				return services, err
+			}},
+		{Name: "service", Type: service, Args: idArg,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.store.GetService(ctx, p.Args["id"].(int64))
+			}},
+		{Name: "stats", Type: nonNull(stats),
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				return s.cachedStats(ctx), nil
+			}},
+	}}
+
+	// control runs a unit action on a service and returns it with its new status
+	control := func(name, description string, op func(ctx context.Context, unit string) error) *graphql.Field {
+		return &graphql.Field{Name: name, Description: description, Type: nonNull(service), Args: idArg,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				svc, err := s.graphqlService(ctx, p.Args["id"].(int64))
+				if err != nil {
+					return nil, err
+				}
+				ctx = audit.WithTarget(ctx, svc.ProjectID, svc.ID)
+				if err := op(ctx, svc.ServiceName()); err != nil {
+					return nil, err
+				}
+				// Refreshes the cached status, as the REST actions do
+				s.serviceStatus(ctx, svc)
+				return svc, nil
+			}}
+	}
+	mutation := &graphql.Object{Name: "Mutation", Fields: []*graphql.Field{
+		control("startService", "Starts a service's unit", s.svcManager.Start),
+		control("stopService", "Stops a service's unit", s.svcManager.Stop),
+		control("restartService", "Restarts a service's unit", s.svcManager.Restart),
+		{Name: "deployService", Description: "Starts a deployment in the background", Type: nonNull(deployment), Args: idArg,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (any, error) {
+				svc, err := s.graphqlService(ctx, p.Args["id"].(int64))
+				if err != nil {
+					return nil, err
+				}
+				return s.deployer.Start(audit.WithTarget(ctx, svc.ProjectID, svc.ID), svc)
+			}},
+	}}
+
+	return &graphql.Schema{Query: query, Mutation: mutation}
+}
+
+// optionalID is null for the zero ID that stands for none
+func optionalID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// errServiceNotFound is the GraphQL mutations' error for a service that does
+// not exist or is outside the user's teams
+var errServiceNotFound = errors.New("service not found")
+
+func (s *Server) graphqlService(ctx context.Context, id int64) (*storage.Service, error) {
+	svc, err := s.store.GetService(ctx, id)
+	if err == nil && svc == nil {
+		err = errServiceNotFound
+	}
+	return svc, err
+}
+
+// handleGraphQL runs a GraphQL query or mutation. GET takes the request in
+// query parameters (variables as JSON) and runs queries only. Requests that
+// cannot run, such as ones that fail to parse, answer 400; once running,
+// field errors are reported in errors next to the data that could be read.
+// POST /graphql {"query","operationName","variables"}
+// GET /graphql?query=&operationName=&variables=
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	opts := graphql.Options{Code: func(err error) string {
+		_, code := classifyError(err)
+		return code
+	}}
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				jsonError(w, r, "Invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+		opts.QueryOnly = true
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Query == "" {
+		jsonError(w, r, "query is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphqlStatsKey{}, &graphqlStats{})
+	resp := graphql.Execute(ctx, s.graphql, req, opts)
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGraphQLSchema returns the GraphQL schema in the schema definition language
+// GET /graphql/schema
+func (s *Server) handleGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(s.graphql.SDL()))
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// TestGraphQLTeamScope checks that users limited to their teams see the same
+// projects and services through GraphQL as through the REST API
+func TestGraphQLTeamScope(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.New(filepath.Join(t.TempDir(), "servio.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := NewServer("127.0.0.1:0", store, systemd.NewMockManager(systemd.NewManager()), nil)
+	s.SetCredentials("admin", "secret")
+	handler := s.httpServer.Handler
+
+	red := &storage.Team{Name: "red", Members: []string{"alice"}}
+	blue := &storage.Team{Name: "blue", Members: []string{"bob"}}
+	for _, team := range []*storage.Team{red, blue} {
+		if err := store.CreateTeam(ctx, team); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := map[string]int64{} // project and service IDs by name
+	for _, p := range []struct {
+		name string
+		team *storage.Team
+	}{{"shop", red}, {"blog", blue}, {"shared", nil}} {
+		project, err := store.CreateProject(ctx, &storage.CreateProjectRequest{Name: p.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.team != nil {
+			if _, err := store.SetProjectTeam(ctx, project.ID, p.team.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		svc, err := store.CreateService(ctx, &storage.CreateServiceRequest{ProjectID: project.ID, Name: p.name + "-api", Type: "custom", Command: "/bin/true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[p.name], ids[svc.Name] = project.ID, svc.ID
+	}
+
+	// do sends a request as user: the basic auth user for "admin", else a
+	// client certificate user
+	do := func(user, method, path string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if user == "admin" {
+			r.SetBasicAuth("admin", "secret")
+		} else {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: user}}
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	graphql := func(user, query string) map[string]json.RawMessage {
+		body, _ := json.Marshal(map[string]string{"query": query})
+		w := do(user, http.MethodPost, "/graphql", body)
+		var resp struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Data == nil {
+			t.Fatalf("%s: %s: %d %s", user, query, w.Code, w.Body)
+		}
+		return resp.Data
+	}
+	names := func(raw []byte) []string {
+		var items []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &items); err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		list := []string{}
+		for _, item := range items {
+			list = append(list, item.Name)
+		}
+		sort.Strings(list)
+		return list
+	}
+
+	tests := []struct {
+		user     string
+		projects []string
+		services []string
+	}{
+		{"admin", []string{"blog", "shared", "shop"}, []string{"blog-api", "shared-api", "shop-api"}},
+		{"alice", []string{"shop"}, []string{"shop-api"}},
+		{"bob", []string{"blog"}, []string{"blog-api"}},
+		{"carol", []string{}, []string{}}, // in no team
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			rest := do(tt.user, http.MethodGet, "/api/projects", nil)
+			if rest.Code != http.StatusOK {
+				t.Fatalf("GET /api/projects: %d %s", rest.Code, rest.Body)
+			}
+			data := graphql(tt.user, "{ projects { name } services { name } }")
+			if got := names(rest.Body.Bytes()); !reflect.DeepEqual(got, tt.projects) {
+				t.Errorf("REST projects = %v, want %v", got, tt.projects)
+			}
+			if got := names(data["projects"]); !reflect.DeepEqual(got, tt.projects) {
+				t.Errorf("GraphQL projects = %v, want %v", got, tt.projects)
+			}
+
+			rest = do(tt.user, http.MethodGet, "/api/services", nil)
+			if got := names(rest.Body.Bytes()); !reflect.DeepEqual(got, tt.services) {
+				t.Errorf("REST services = %v, want %v", got, tt.services)
+			}
+			if got := names(data["services"]); !reflect.DeepEqual(got, tt.services) {
+				t.Errorf("GraphQL services = %v, want %v", got, tt.services)
+			}
+
+			// Lookups by ID find only visible projects and services, and a
+			// service's project follows the same rule
+			for _, name := range []string{"shop", "blog", "shared"} {
+				visible := slices.Contains(tt.projects, name)
+				rest := do(tt.user, http.MethodGet, fmt.Sprintf("/api/projects/%d", ids[name]), nil)
+				if (rest.Code == http.StatusOK) != visible {
+					t.Errorf("GET /api/projects/%d (%s) = %d, visible %v", ids[name], name, rest.Code, visible)
+				}
+				data := graphql(tt.user, fmt.Sprintf(`{ project(id: "%d") { name } service(id: "%d") { name project { name } } }`, ids[name], ids[name+"-api"]))
+				if got := string(data["project"]) != "null"; got != visible {
+					t.Errorf("GraphQL project(%s) = %s, visible %v", name, data["project"], visible)
+				}
+				if got := string(data["service"]) != "null"; got != visible {
+					t.Errorf("GraphQL service(%s-api) = %s, visible %v", name, data["service"], visible)
+				}
+			}
+		})
+	}
+
+	// Mutations are refused on other teams' services as REST actions are
+	rest := do("alice", http.MethodPost, fmt.Sprintf("/api/services/%d/start", ids["blog-api"]), nil)
+	if rest.Code != http.StatusNotFound {
+		t.Errorf("REST start of another team's service = %d, want 404", rest.Code)
+	}
+	body, _ := json.Marshal(map[string]string{"query": fmt.Sprintf(`mutation { startService(id: "%d") { name } }`, ids["blog-api"])})
+	w := do("alice", http.MethodPost, "/graphql", body)
+	if !strings.Contains(w.Body.String(), errServiceNotFound.Error()) || !strings.Contains(w.Body.String(), `"not_found"`) {
+		t.Errorf("GraphQL start of another team's service = %s, want service not found", w.Body)
+	}
+}
@@ -1,19 +1,32 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
-
-	"servio/internal/git"
+	"time"
+
+	"servio/internal/ansi"
+	"servio/internal/audit"
+	"servio/internal/cron"
+	"servio/internal/events"
+	"servio/internal/i18n"
+	"servio/internal/logparse"
 	"servio/internal/monitor"
+	"servio/internal/nginx"
+	"servio/internal/redis"
+	"servio/internal/stacks"
 	"servio/internal/storage"
 )
 
@@ -24,18 +37,48 @@ var templatesFS embed.FS
 var staticFS embed.FS
 
 // render parses and executes a template with the layout
-func render(w http.ResponseWriter, tmplName string, data interface{}) {
-	tmpl, err := template.ParseFS(templatesFS, "templates/layout.html", "templates/icons.html", "templates/"+tmplName)
+func render(w http.ResponseWriter, r *http.Request, tmplName string, data interface{}) {
+	renderPartial(w, r, tmplName, tmplName, data)
+}
+
+// renderPartial executes one named template defined by a page (or the layout)
+// without the surrounding page, for htmx requests that swap part of a page.
+// Its text is translated into the request's language (see Localize).
+func renderPartial(w http.ResponseWriter, r *http.Request, tmplName, name string, data interface{}) {
+	patterns := []string{"templates/layout.html", "templates/icons.html"}
+	if tmplName != "layout.html" {
+		patterns = append(patterns, "templates/"+tmplName)
+	}
+	tmpl, err := template.New(tmplName).Funcs(templateFuncs).Funcs(localeFuncs(i18n.FromContext(r.Context()))).ParseFS(templateFS, patterns...)
 	if err != nil {
+		if devMode {
+			renderDevError(w, tmplName, err)
+			return
+		}
 		http.Error(w, fmt.Sprintf("Failed to parse templates: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if err := tmpl.ExecuteTemplate(w, tmplName, data); err != nil {
+	if devMode {
+		// Buffer so a failure halfway through replaces the page instead of truncating it
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+			renderDevError(w, tmplName, err)
+			return
+		}
+		buf.WriteTo(w)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// isHTMX reports whether a request was made by htmx and expects a partial response
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
 // getStaticFS returns the static file system
 func getStaticFS() fs.FS {
 	sub, _ := fs.Sub(staticFS, "static")
@@ -44,15 +87,24 @@ func getStaticFS() fs.FS {
 
 // ================== UI Handlers ==================
 
+// handleDashboard renders the dashboard. htmx requests (the pulse bar on every
+// page) get only the host stats widget.
 func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
+	if isHTMX(r) {
+		renderPartial(w, r, "layout.html", "stats-widget", monitor.GetStats())
 		return
 	}
 
-	distro, _ := s.store.GetSetting(r.Context(), "distro")
+	distro, _ := s.store.GetSetting(r.Context(), storage.SettingDistro)
+	refreshSeconds, _ := s.store.GetSettingInt(r.Context(), storage.SettingDashboardRefreshSeconds)
+
+	// Filters come from the same query parameters as /api/projects; paging does not apply
+	opts, _ := parseListOptions(r)
+	opts.Limit, opts.Offset = 0, 0
+	status, _ := parseStatusFilter(r)
+	activityOffset, _ := strconv.Atoi(r.URL.Query().Get("activity_offset"))
 
-	projects, err := s.store.ListProjects(r.Context())
+	projects, _, err := s.listProjects(r.Context(), opts, status)
 	if err != nil {
 		http.Error(w, "Failed to load projects", http.StatusInternalServerError)
 		return
@@ -61,757 +113,1207 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	// Get summary for each project (using first service status if available)
 	for _, p := range projects {
 		services, _ := s.store.ListServicesByProject(r.Context(), p.ID)
+		for _, sv := range services {
+			sv.Status = s.cachedServiceStatus(r.Context(), sv)
+		}
 		p.Services = services
 	}
 
+	// Host names for the badges of projects on agent hosts
+	hostNames := map[int64]string{}
+	if hosts, err := s.store.ListHosts(r.Context()); err == nil {
+		for _, h := range hosts {
+			hostNames[h.ID] = h.Name
+		}
+	}
+
 	data := map[string]interface{}{
 		"Projects": projects,
+		"Hosts":    hostNames,
 		"Stats":    monitor.GetStats(),
 		"Title":    "Dashboard",
 		"Distro":   distro,
+		"Refresh":  refreshSeconds,
+		"Filter":   dashboardFilter{Type: opts.Type, Tag: opts.Tag, Status: r.URL.Query().Get("status")},
+		"Types":    s.blueprints.AllMetadata(),
+		"Activity": s.activityFeed(r.Context(), activityOffset),
 	}
 
-	render(w, "dashboard.html", data)
+	render(w, r, "dashboard.html", data)
 }
 
-func (s *Server) handleAPISettings(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleAPISettingsList lists every registered setting with its type, default, and current value
+// GET /api/settings
+func (s *Server) handleAPISettingsList(w http.ResponseWriter, r *http.Request) {
+	settings, err := s.store.ListSettings(r.Context())
+	if err != nil {
+		apiError(w, r, err)
 		return
 	}
+	jsonResponse(w, settings)
+}
 
-	key := strings.TrimPrefix(r.URL.Path, "/api/settings/")
-	if key == "" {
-		jsonError(w, "Missing setting key", http.StatusBadRequest)
+// handleAPIGetSetting reads a single setting
+// GET /api/settings/{key}
+func (s *Server) handleAPIGetSetting(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, ok := storage.LookupSetting(key); !ok {
+		jsonError(w, r, "Unknown setting", http.StatusNotFound)
 		return
 	}
 
-	var value string
-	// Check if it's a form or JSON
-	r.ParseForm()
-	value = r.FormValue("value")
-	if value == "" {
-		// Try to read from JSON body
-		var body struct {
-			Value string `json:"value"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
-			value = body.Value
-		}
+	value, err := s.store.GetSetting(r.Context(), key)
+	if err != nil {
+		apiError(w, r, err)
+		return
 	}
+	jsonResponse(w, settingResponse{Key: key, Value: value})
+}
 
-	// Special case for distro if sent as "distro" instead of "value"
-	if value == "" {
-		value = r.FormValue(key)
+// handleAPISetSetting updates a single setting
+// POST/PUT /api/settings/{key}  - JSON {"value": ...} or form value (the dashboard posts the key itself as the field)
+func (s *Server) handleAPISetSetting(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, ok := storage.LookupSetting(key); !ok {
+		jsonError(w, r, "Unknown setting", http.StatusNotFound)
+		return
 	}
 
-	if value == "" {
-		jsonError(w, "Missing setting value", http.StatusBadRequest)
+	value, ok := settingValue(r, key)
+	if !ok {
+		jsonError(w, r, "Missing setting value", http.StatusBadRequest)
 		return
 	}
 
+	if key == storage.SettingNginxUpstreamHost {
+		if _, err := nginx.UpstreamHost(value); err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
 	if err := s.store.SetSetting(r.Context(), key, value); err != nil {
-		jsonError(w, "Failed to save setting", http.StatusInternalServerError)
+		apiError(w, r, err)
 		return
 	}
 
 	// Reconfigure manager if distro changed
-	if key == "distro" {
+	if key == storage.SettingDistro {
 		s.nginxManager.Configure(value)
 	}
+	if key == storage.SettingNginxIPv6 || key == storage.SettingNginxUpstreamHost {
+		if err := s.loadNginxNetwork(r.Context()); err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
+	if strings.HasPrefix(key, "log_forward_") {
+		s.logShipper.Reconfigure()
+	}
 
 	if r.Header.Get("Accept") == "application/json" || r.Header.Get("Content-Type") == "application/json" {
-		jsonResponse(w, map[string]string{"status": "saved"})
+		jsonResponse(w, statusResponse{Status: "saved"})
 	} else {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, basePath+"/", http.StatusSeeOther)
 	}
 }
 
-func (s *Server) handleNewProject(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		data := map[string]interface{}{
-			"Title":   "New Project",
-			"Project": &storage.Project{},
-		}
-		render(w, "project_form.html", data)
-		return
+// loadNginxNetwork applies the nginx_ipv6 and nginx_upstream_host settings
+// to sites generated from now on; installed sites change when next deployed
+func (s *Server) loadNginxNetwork(ctx context.Context) error {
+	ipv6, err := s.store.GetSetting(ctx, storage.SettingNginxIPv6)
+	if err != nil {
+		return err
 	}
+	upstreamHost, err := s.store.GetSetting(ctx, storage.SettingNginxUpstreamHost)
+	if err != nil {
+		return err
+	}
+	return s.nginxManager.SetNetwork(ipv6 == "true", upstreamHost)
+}
 
-	if r.Method == http.MethodPost {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Invalid form data", http.StatusBadRequest)
-			return
-		}
-
-		req := &storage.CreateProjectRequest{
-			Name:        r.FormValue("name"),
-			Description: r.FormValue("description"),
-			Domain:      r.FormValue("domain"),
+// settingValue extracts the submitted value from a JSON body or form.
+// JSON values may be strings or any other JSON type (numbers, bools, objects).
+func settingValue(r *http.Request, key string) (string, bool) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body settingRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Value) == 0 {
+			return "", false
 		}
-
-		project, err := s.store.CreateProject(r.Context(), req)
-		if err != nil {
-			data := map[string]interface{}{
-				"Title":   "New Project",
-				"Project": req,
-				"Error":   err.Error(),
-			}
-			render(w, "project_form.html", data)
-			return
+		var str string
+		if err := json.Unmarshal(body.Value, &str); err == nil {
+			return str, true
 		}
-
-		http.Redirect(w, r, "/projects/"+strconv.FormatInt(project.ID, 10), http.StatusSeeOther)
-		return
+		return string(body.Value), true
 	}
 
-	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	r.ParseForm()
+	if value := r.FormValue("value"); value != "" {
+		return value, true
+	}
+	// The dashboard setup form posts e.g. distro=ubuntu
+	if value := r.FormValue(key); value != "" {
+		return value, true
+	}
+	return "", false
 }
 
-func (s *Server) handleProjectDetail(w http.ResponseWriter, r *http.Request) {
-	// Extract ID from path: /projects/{id} or /projects/{id}/action
-	path := strings.TrimPrefix(r.URL.Path, "/projects/")
-	parts := strings.Split(path, "/")
-
-	id, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		http.NotFound(w, r)
-		return
+func (s *Server) handleNewProject(w http.ResponseWriter, r *http.Request) {
+	teams, _ := s.store.ListTeams(r.Context())
+	data := map[string]interface{}{
+		"Title":   "New Project",
+		"Project": &storage.Project{},
+		"Teams":   teams,
+		"Stacks":  stacks.All(),
 	}
+	render(w, r, "project_form.html", data)
+}
 
-	project, err := s.store.GetProject(r.Context(), id)
-	if err != nil || project == nil {
-		http.NotFound(w, r)
+func (s *Server) handleCreateProject(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
 		return
 	}
 
-	// Handle actions (Legacy support for single-service projects or project-level actions)
-	if len(parts) > 1 && r.Method == http.MethodPost {
-		action := parts[1]
-		if action == "delete" {
-			// Delete project and all its services
-			for _, sv := range project.Services {
-				if err := s.svcManager.UninstallService(r.Context(), sv.ServiceName()); err != nil {
-					slog.Warn("Failed to uninstall service", "service", sv.Name, "error", err)
-				}
-			}
-
-			if err := s.store.DeleteProject(r.Context(), id); err != nil {
-				slog.Error("Failed to delete project", "project_id", id, "error", err)
-				http.Redirect(w, r, fmt.Sprintf("/projects/%d?error=%s", id, "Failed to delete project: "+err.Error()), http.StatusSeeOther)
-				return
-			}
+	teamID, _ := strconv.ParseInt(r.FormValue("team_id"), 10, 64)
+	req := &storage.CreateProjectRequest{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+		Domain:      r.FormValue("domain"),
+		Notes:       r.FormValue("notes"),
+		Tags:        storage.ParseTags(r.FormValue("tags")),
+		TeamID:      teamID,
+	}
 
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
+	var project *storage.Project
+	var jobID int64
+	var err error
+	if name := r.FormValue("stack"); name != "" {
+		var tmpl *stacks.Template
+		if tmpl, err = stacks.Get(name); err == nil {
+			project, _, jobID, err = s.createStack(r.Context(), tmpl, &stackRequest{
+				Name:        req.Name,
+				Description: req.Description,
+				Domain:      req.Domain,
+				GitRepoURL:  r.FormValue("git_repo_url"),
+				Tags:        req.Tags,
+				TeamID:      req.TeamID,
+			})
 		}
+	} else {
+		project, err = s.store.CreateProject(r.Context(), req)
 	}
-
-	// Handle edit form
-	if len(parts) > 1 && parts[1] == "edit" {
-		if r.Method == http.MethodGet {
-			data := map[string]interface{}{
-				"Title":   "Edit Project",
-				"Project": project,
-				"Edit":    true,
-			}
-			render(w, "project_form.html", data)
-			return
+	if err != nil {
+		teams, _ := s.store.ListTeams(r.Context())
+		data := map[string]interface{}{
+			"Title":   "New Project",
+			"Project": req,
+			"Teams":   teams,
+			"Stacks":  stacks.All(),
+			"Stack":   r.FormValue("stack"),
+			"RepoURL": r.FormValue("git_repo_url"),
+			"Error":   err.Error(),
 		}
+		render(w, r, "project_form.html", data)
+		return
+	}
 
-		if r.Method == http.MethodPost {
-			if err := r.ParseForm(); err != nil {
-				http.Error(w, "Invalid form data", http.StatusBadRequest)
-				return
-			}
+	http.Redirect(w, r, jobURL(project.ID, jobID), http.StatusSeeOther)
+}
 
-			req := &storage.UpdateProjectRequest{
-				Name:        r.FormValue("name"),
-				Description: r.FormValue("description"),
-				Domain:      r.FormValue("domain"),
-			}
+// projectPageBackups is how many of the latest database backups the project page lists
+const projectPageBackups = 10
 
-			project, err = s.store.UpdateProject(r.Context(), id, req)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+func (s *Server) handleProjectDetail(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	for _, sv := range project.Services {
+		sv.Status = s.cachedServiceStatus(r.Context(), sv)
+		s.prepareServiceCard(r.Context(), sv)
+	}
 
-			http.Redirect(w, r, fmt.Sprintf("/projects/%d", id), http.StatusSeeOther)
-			return
-		}
+	alerts := pageAlerts{Error: r.URL.Query().Get("error")}
+	alerts.FixService, _ = strconv.ParseInt(r.URL.Query().Get("fix_service"), 10, 64)
+	if job := s.followedJob(r, project); job != nil {
+		alerts = jobAlerts(job)
 	}
 
-	// Get status for each service
-	for _, sv := range project.Services {
-		status, _ := s.svcManager.Status(r.Context(), sv.ServiceName())
-		if status.Active {
-			sv.Status = "running"
-		} else if s.svcManager.ServiceExists(sv.ServiceName()) {
-			sv.Status = "stopped"
-		} else {
-			sv.Status = "not installed"
-		}
-
-		// Generate default systemd config for display if raw is empty
-		if sv.SystemdRaw == "" {
-			generated, err := s.svcManager.GenerateServiceFile(sv)
-			if err == nil {
-				sv.SystemdRaw = generated
-			}
-		}
+	cronJobs, err := s.store.ListCronJobs(r.Context(), project.ID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to list cron jobs", "project_id", project.ID, "error", err)
+	}
+	cron.WithNextRuns(cronJobs, 1)
+
+	backups, err := s.store.ListDatabaseBackups(r.Context(), project.ID, 0)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to list database backups", "project_id", project.ID, "error", err)
+	}
+	if len(backups) > projectPageBackups {
+		backups = backups[:projectPageBackups]
 	}
 
 	data := map[string]interface{}{
-		"Title":      project.Name,
-		"Project":    project,
-		"Error":      r.URL.Query().Get("error"),
-		"FixService": r.URL.Query().Get("fix_service"),
+		"Title":         project.Name,
+		"Project":       project,
+		"Alerts":        alerts,
+		"CronJobs":      cronJobs,
+		"Backups":       backups,
+		"RedisPolicies": redis.Policies,
 	}
+	render(w, r, "project_detail.html", data)
+}
 
-	render(w, "project_detail.html", data)
+func (s *Server) handleEditProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	data := map[string]interface{}{
+		"Title":   "Edit Project",
+		"Project": project,
+		"Edit":    true,
+	}
+	render(w, r, "project_form.html", data)
 }
 
-// ================== API Handlers ==================
+func (s *Server) handleUpdateProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
 
-func (s *Server) handleAPIProjects(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		projects, err := s.store.ListProjects(r.Context())
-		if err != nil {
-			jsonError(w, "Failed to list projects", http.StatusInternalServerError)
-			return
-		}
+	req := &storage.UpdateProjectRequest{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+		Domain:      r.FormValue("domain"),
+		Notes:       r.FormValue("notes"),
+		Tags:        storage.ParseTags(r.FormValue("tags")),
+	}
 
-		jsonResponse(w, projects)
+	if _, err := s.store.UpdateProject(r.Context(), project.ID, req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	case http.MethodPost:
-		var req storage.CreateProjectRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			jsonError(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+	http.Redirect(w, r, projectURL(project.ID, nil), http.StatusSeeOther)
+}
 
-		project, err := s.store.CreateProject(r.Context(), &req)
-		if err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
-			return
+// handleDeleteProject uninstalls every service and cron job of the project and deletes it
+func (s *Server) handleDeleteProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	for _, sv := range project.Services {
+		if err := s.svcManager.UninstallService(r.Context(), sv.ServiceName()); err != nil {
+			slog.WarnContext(r.Context(), "Failed to uninstall service", "service", sv.Name, "error", err)
 		}
+	}
+	s.removeCronJobs(r.Context(), project)
 
-		w.WriteHeader(http.StatusCreated)
-		jsonResponse(w, project)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if err := s.store.DeleteProject(r.Context(), project.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete project", "project_id", project.ID, "error", err)
+		http.Redirect(w, r, projectURL(project.ID, url.Values{"error": {"Failed to delete project: " + err.Error()}}), http.StatusSeeOther)
+		return
 	}
+
+	http.Redirect(w, r, basePath+"/", http.StatusSeeOther)
 }
 
-func (s *Server) handleAPIProject(w http.ResponseWriter, r *http.Request) {
-	// Parse path: /api/projects/{id} or /api/projects/{id}/action
-	path := strings.TrimPrefix(r.URL.Path, "/api/projects/")
-	parts := strings.Split(path, "/")
+// ================== API Handlers ==================
+
+// handleAPIListProjects lists projects
+// GET /api/projects?limit=&offset=&sort=&fields=&type=&tag=&status=
+func (s *Server) handleAPIListProjects(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := parseStatusFilter(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	id, err := strconv.ParseInt(parts[0], 10, 64)
+	projects, total, err := s.listProjects(r.Context(), opts, status)
 	if err != nil {
-		jsonError(w, "Invalid project ID", http.StatusBadRequest)
+		apiError(w, r, err)
 		return
 	}
 
-	project, err := s.store.GetProject(r.Context(), id)
-	if err != nil || project == nil {
-		jsonError(w, "Project not found", http.StatusNotFound)
+	setPaginationHeaders(w, total, opts)
+	jsonList(w, r, projects)
+}
+
+// handleAPICreateProject creates a project
+// POST /api/projects
+func (s *Server) handleAPICreateProject(w http.ResponseWriter, r *http.Request) {
+	var req storage.CreateProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Handle actions (Project-level, e.g., bulk actions might go here later)
-	if len(parts) > 1 {
-		jsonError(w, "Project actions not supported at this level", http.StatusBadRequest)
+	project, err := s.store.CreateProject(r.Context(), &req)
+	if err != nil {
+		apiError(w, r, err)
 		return
 	}
 
-	// CRUD operations
-	switch r.Method {
-	case http.MethodGet:
-		jsonResponse(w, project)
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, project)
+}
 
-	case http.MethodPut:
-		var req storage.UpdateProjectRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			jsonError(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
+// handleAPIGetProject returns a project with its services
+// GET /api/projects/{id}
+func (s *Server) handleAPIGetProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	jsonResponse(w, project)
+}
 
-		project, err = s.store.UpdateProject(r.Context(), id, &req)
-		if err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+// handleAPIUpdateProject updates a project
+// PUT /api/projects/{id}
+func (s *Server) handleAPIUpdateProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req storage.UpdateProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	project, err := s.store.UpdateProject(r.Context(), project.ID, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
 
-		jsonResponse(w, project)
+	jsonResponse(w, project)
+}
 
-	case http.MethodDelete:
-		// Delete all services first
-		for _, sv := range project.Services {
-			s.svcManager.UninstallService(r.Context(), sv.ServiceName())
-		}
-		if err := s.store.DeleteProject(r.Context(), id); err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
+// handleAPIPatchProject changes only the fields present in the body
+// PATCH /api/projects/{id}
+func (s *Server) handleAPIPatchProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var patch storage.PatchProjectRequest
+	if err := decodePatch(r, &patch); err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	req := patch.Apply(project)
+	project, err := s.store.UpdateProject(r.Context(), project.ID, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	jsonResponse(w, project)
+}
+
+// handleAPIDeleteProject uninstalls a project's services and cron jobs and deletes it
+// DELETE /api/projects/{id}
+func (s *Server) handleAPIDeleteProject(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			for _, sv := range project.Services {
+				s.svcManager.UninstallService(ctx, sv.ServiceName())
+			}
+			s.removeCronJobs(ctx, project)
+			return nil
+		})
+		return
+	}
+	for _, sv := range project.Services {
+		s.svcManager.UninstallService(r.Context(), sv.ServiceName())
+	}
+	s.removeCronJobs(r.Context(), project)
+	s.removeProjectBudget(r.Context(), project)
+	if err := s.store.DeleteProject(r.Context(), project.ID); err != nil {
+		apiError(w, r, err)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleLogStream handles SSE log streaming
+// handleLogStream handles SSE log streaming, keeping only lines that match
+// the filters when any are given. Each journal line carries its cursor as the
+// event ID, so a client that reconnects with Last-Event-ID resumes after the
+// last line it received.
+// GET /api/services/{id}/logs/stream?ansi=&filter=key=value&last_event_id=
 func (s *Server) handleLogStream(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	mode, err := ansi.ParseMode(r.URL.Query().Get("ansi"), ansi.ModeStrip)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	filters, err := parseLogFilters(r)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		jsonError(w, "Streaming not supported", http.StatusInternalServerError)
+		jsonError(w, r, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	logChan, err := s.svcManager.StreamLogs(ctx, service.ServiceName())
+	startSSE(w, flusher)
+	logChan, err := s.svcManager.StreamLogs(ctx, service.ServiceName(), lastEventID(r))
 	if err != nil {
 		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
 		flusher.Flush()
 		return
 	}
 
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case line, ok := <-logChan:
+		case <-ticker.C:
+			ssePing(w, flusher)
+		case entry, ok := <-logChan:
 			if !ok {
 				return
 			}
-			fmt.Fprintf(w, "data: %s\n\n", line)
+			line := entry.Line()
+			if len(filters) > 0 && !logparse.Match(logparse.Parse(line).WithPriority(entry.Priority), filters) {
+				continue
+			}
+			if entry.Cursor != "" {
+				fmt.Fprintf(w, "id: %s\n", entry.Cursor)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", ansi.Apply(mode, line))
 			flusher.Flush()
 		}
 	}
 }
 
-func (s *Server) handleAPIServices(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		projectIDStr := r.URL.Query().Get("project_id")
-		if projectIDStr == "" {
-			jsonError(w, "project_id is required", http.StatusBadRequest)
-			return
-		}
-		projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
-		if err != nil {
-			jsonError(w, "invalid project_id", http.StatusBadRequest)
-			return
-		}
-		services, err := s.store.ListServicesByProject(r.Context(), projectID)
-		if err != nil {
-			jsonError(w, "failed to list services", http.StatusInternalServerError)
-			return
-		}
-		jsonResponse(w, services)
+const (
+	// projectLogLines is how many recent lines a project log stream starts with by default
+	projectLogLines = 100
+	// maxProjectLogLines caps the lines parameter
+	maxProjectLogLines = 1000
+)
 
-	case http.MethodPost:
-		var req storage.CreateServiceRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			jsonError(w, "invalid request body", http.StatusBadRequest)
+// handleProjectLogStream streams the logs of all of a project's services as
+// one time-ordered stream. Each event is a JSON line with its unit, and the
+// level and fields of JSON messages. Event IDs are the lines' times in
+// microseconds; a client reconnecting with Last-Event-ID gets the lines after
+// that time instead of the backlog.
+// GET /api/projects/{id}/logs/stream?lines=&ansi=&filter=key=value&last_event_id=
+func (s *Server) handleProjectLogStream(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	lines := projectLogLines
+	if v := r.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			jsonError(w, r, "Invalid lines", http.StatusBadRequest)
 			return
 		}
-
-		service, err := s.store.CreateService(r.Context(), &req)
+		lines = min(n, maxProjectLogLines)
+	}
+	var after time.Time
+	if id := lastEventID(r); id != "" {
+		usec, err := strconv.ParseInt(id, 10, 64)
 		if err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+			jsonError(w, r, "Invalid Last-Event-ID", http.StatusBadRequest)
 			return
 		}
-
-		// Install the systemd service
-		if err := s.svcManager.InstallService(r.Context(), service); err != nil {
-			slog.Warn("Failed to install service", "error", err, "service", service.Name)
-		}
-
-		w.WriteHeader(http.StatusCreated)
-		jsonResponse(w, service)
-
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		after = time.UnixMicro(usec)
 	}
-}
-
-func (s *Server) handleAPIService(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/services/")
-	parts := strings.Split(path, "/")
-
-	id, err := strconv.ParseInt(parts[0], 10, 64)
+	mode, err := ansi.ParseMode(r.URL.Query().Get("ansi"), ansi.ModeStrip)
 	if err != nil {
-		jsonError(w, "Invalid service ID", http.StatusBadRequest)
+		apiError(w, r, err)
 		return
 	}
-
-	service, err := s.store.GetService(r.Context(), id)
-	if err != nil || service == nil {
-		jsonError(w, "Service not found", http.StatusNotFound)
+	filters, err := parseLogFilters(r)
+	if err != nil {
+		apiError(w, r, err)
 		return
 	}
-
-	// Handle actions
-	if len(parts) > 1 {
-		action := strings.Join(parts[1:], "/")
-		switch action {
-		case "start":
-			if err := s.svcManager.Start(r.Context(), service.ServiceName()); err != nil {
-				jsonError(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			jsonResponse(w, map[string]string{"status": "started"})
-		case "stop":
-			if err := s.svcManager.Stop(r.Context(), service.ServiceName()); err != nil {
-				jsonError(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			jsonResponse(w, map[string]string{"status": "stopped"})
-		case "restart":
-			if err := s.svcManager.Restart(r.Context(), service.ServiceName()); err != nil {
-				jsonError(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			jsonResponse(w, map[string]string{"status": "restarted"})
-		case "logs":
-			startTime, _ := s.svcManager.GetStartTime(r.Context(), service.ServiceName())
-			if startTime == "" {
-				startTime = service.CreatedAt.Format("2006-01-02 15:04:05")
-			}
-			logs, err := s.svcManager.GetLogsWithTimeRange(r.Context(), service.ServiceName(), startTime, "")
-			if err != nil {
-				jsonError(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			jsonResponse(w, map[string]string{"logs": logs})
-		case "logs/stream":
-			s.handleLogStream(w, r, service)
-		default:
-			jsonError(w, "Unknown action", http.StatusBadRequest)
-		}
+	services, err := s.store.ListServicesByProject(r.Context(), project.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if len(services) == 0 {
+		jsonError(w, r, "Project has no services", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, r, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
-	switch r.Method {
-	case http.MethodGet:
-		status, _ := s.svcManager.Status(r.Context(), service.ServiceName())
-		if status.Active {
-			service.Status = "running"
-		} else if s.svcManager.ServiceExists(service.ServiceName()) {
-			service.Status = "stopped"
-		} else {
-			service.Status = "not installed"
-		}
-		jsonResponse(w, service)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 
-	case http.MethodPut:
-		var req storage.UpdateServiceRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			jsonError(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
-		service, err = s.store.UpdateService(r.Context(), id, &req)
-		if err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
-			return
+	startSSE(w, flusher)
+	units := make([]string, len(services))
+	names := make(map[string]string, len(services))
+	for i, svc := range services {
+		units[i] = svc.ServiceName()
+		names[units[i]] = svc.Name
+		// Lines of a scaled service's instances come with the instance's unit
+		for n := 1; svc.Scaled() && n <= svc.Replicas; n++ {
+			names[svc.InstanceName(n)] = svc.Name
 		}
-		s.svcManager.InstallService(r.Context(), service)
-		jsonResponse(w, service)
+	}
+	logChan, err := s.svcManager.StreamUnitLogs(ctx, units, lines, after)
+	if err != nil {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+		flusher.Flush()
+		return
+	}
 
-	case http.MethodDelete:
-		s.svcManager.UninstallService(r.Context(), service.ServiceName())
-		if err := s.store.DeleteService(r.Context(), id); err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
+			ssePing(w, flusher)
+		case line, ok := <-logChan:
+			if !ok {
+				return
+			}
+			parsed := logparse.ParseMessage(line.Message).WithPriority(line.Priority)
+			if !logparse.Match(parsed, filters) {
+				continue
+			}
+			line.Message = ansi.Apply(mode, line.Message)
+			data, _ := json.Marshal(projectLogLine{LogLine: line, Service: names[line.Unit], Level: parsed.Level, Fields: parsed.Fields})
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", line.Time.UnixMicro(), data)
+			flusher.Flush()
 		}
-		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func (s *Server) handleAPIStats(w http.ResponseWriter, r *http.Request) {
-	projects, _ := s.store.ListProjects(r.Context())
-	var serviceNames []string
-	for _, p := range projects {
-		services, _ := s.store.ListServicesByProject(r.Context(), p.ID)
-		for _, svc := range services {
-			serviceNames = append(serviceNames, svc.ServiceName())
+// handleAPIListServices lists services, across all projects unless project_id is given
+// GET /api/services?project_id=1&limit=&offset=&sort=&fields=&type=&tag=&status=
+func (s *Server) handleAPIListServices(w http.ResponseWriter, r *http.Request) {
+	var projectID int64
+	if v := r.URL.Query().Get("project_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			jsonError(w, r, "invalid project_id", http.StatusBadRequest)
+			return
 		}
+		projectID = id
 	}
-
-	jsonResponse(w, monitor.GetStats(serviceNames...))
-}
-
-func (s *Server) handleNewService(w http.ResponseWriter, r *http.Request) {
-	projectIDStr := r.URL.Query().Get("project_id")
-	if projectIDStr == "" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	projectID, _ := strconv.ParseInt(projectIDStr, 10, 64)
-
-	if r.Method == http.MethodGet {
-		data := map[string]interface{}{
-			"Title":     "Add Service",
-			"ProjectID": projectID,
-			"Service":   &storage.Service{AutoRestart: true},
-		}
-		render(w, "service_form.html", data)
+	status, err := parseStatusFilter(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	services, total, err := s.listServices(r.Context(), projectID, opts, status)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	setPaginationHeaders(w, total, opts)
+	jsonList(w, r, services)
+}
+
+// handleAPICreateService creates a service and installs its systemd unit
+// POST /api/services
+func (s *Server) handleAPICreateService(w http.ResponseWriter, r *http.Request) {
+	var req storage.CreateServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkHostPort(r.Context(), req.ProjectID, req.Port, nil); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	opts := preflightOptions{createUser: req.CreateUser, createDir: req.CreateWorkingDir}
+	if err := s.preflightService(r.Context(), serviceCreate(&req), nil, opts); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	service, err := s.store.CreateService(r.Context(), &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	// Install the systemd service in the background
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: s.enqueueInstall(r, service, setupSteps{createUser: req.CreateUser, createDir: req.CreateWorkingDir})})
+}
+
+// handleAPIGetService returns a service with its runtime status
+// GET /api/services/{id}
+func (s *Server) handleAPIGetService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	service.Status = s.serviceStatus(r.Context(), service)
+	jsonResponse(w, service)
+}
+
+// handleAPIUpdateService updates a service and reinstalls its systemd unit
+// PUT /api/services/{id}
+func (s *Server) handleAPIUpdateService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req storage.UpdateServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkHostPort(r.Context(), service.ProjectID, req.Port, service); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	opts := preflightOptions{createUser: req.CreateUser, createDir: req.CreateWorkingDir}
+	if err := s.preflightService(r.Context(), serviceUpdate(service, &req), service, opts); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	service, err := s.store.UpdateService(r.Context(), service.ID, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: s.enqueueInstall(r, service, setupSteps{createUser: req.CreateUser, createDir: req.CreateWorkingDir})})
+}
+
+// handleAPIPatchService changes only the fields present in the body and reinstalls the unit
+// PATCH /api/services/{id}
+func (s *Server) handleAPIPatchService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var patch storage.PatchServiceRequest
+	if err := decodePatch(r, &patch); err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := patch.Apply(service)
+	if err := s.checkHostPort(r.Context(), service.ProjectID, req.Port, service); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.preflightService(r.Context(), serviceUpdate(service, &req), service, preflightOptions{}); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	service, err := s.store.UpdateService(r.Context(), service.ID, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: s.enqueueInstall(r, service, setupSteps{})})
+}
+
+// handleAPIDeleteService uninstalls and deletes a service
+// DELETE /api/services/{id}
+func (s *Server) handleAPIDeleteService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return s.svcManager.UninstallService(ctx, service.ServiceName())
+		})
+		return
+	}
+	s.svcManager.UninstallService(r.Context(), service.ServiceName())
+	if err := s.store.DeleteService(r.Context(), service.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIInstallService (re)writes the unit file, then enables and starts the service in a job.
+// With create_user, a missing service user is created first.
+// POST /api/services/{id}/install?create_user=true
+func (s *Server) handleAPIInstallService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	steps := setupSteps{start: true}
+	steps.createUser, _ = strconv.ParseBool(r.URL.Query().Get("create_user"))
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return s.setupService(ctx, service, steps, func(string, ...interface{}) {})
+		})
 		return
 	}
+	jobID, err := s.enqueueSetup(r.Context(), service, steps)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: jobID})
+}
 
-	if r.Method == http.MethodPost {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Invalid form data", http.StatusBadRequest)
+// handleAPIProvisionService queues a provision job: the blueprint's packages
+// are installed, then the unit is written, enabled, and started. The job's
+// log carries each command with its output; follow it at /api/jobs/{id}/stream.
+// POST /api/services/{id}/provision?create_user=true
+func (s *Server) handleAPIProvisionService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if !s.blueprints.IsManaged(service.Type) {
+		jsonError(w, r, fmt.Sprintf("Service type %q has no blueprint to provision", service.Type), http.StatusConflict)
+		return
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "provisioning"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	steps := setupSteps{dependencies: true, start: true}
+	steps.createUser, _ = strconv.ParseBool(r.URL.Query().Get("create_user"))
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return s.setupService(ctx, service, steps, func(string, ...interface{}) {})
+		})
+		return
+	}
+	jobID, err := s.enqueueSetup(r.Context(), service, steps)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: jobID})
+}
+
+// handleAPIServiceControl runs a systemd control operation and reports the new state
+// POST /api/services/{id}/start|stop|restart
+func (s *Server) handleAPIServiceControl(status string, op func(ctx context.Context, name string) error) serviceHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+		if err := op(r.Context(), service.ServiceName()); err != nil {
+			apiError(w, r, err)
 			return
 		}
+		// Refreshes the cached status, so pages show the change before the watcher polls
+		s.serviceStatus(r.Context(), service)
+		jsonResponse(w, statusResponse{Status: status})
+	}
+}
 
-		port, _ := strconv.Atoi(r.FormValue("port"))
+// handleAPIServiceLogs returns a service's most recent log lines, since it
+// last started unless since says otherwise, with the number of lines at each
+// level. Filters keep the lines whose level or JSON fields match;
+// structured=true adds the parsed lines.
+// GET /api/services/{id}/logs?lines=&since=&order=&ansi=&filter=key=value&structured=
+func (s *Server) handleAPIServiceLogs(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	window, err := parseLogWindow(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ansi.ParseMode(r.URL.Query().Get("ansi"), ansi.ModeStrip)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	filters, err := parseLogFilters(r)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	structured := false
+	if v := r.URL.Query().Get("structured"); v != "" {
+		if structured, err = strconv.ParseBool(v); err != nil {
+			jsonError(w, r, "Invalid structured", http.StatusBadRequest)
+			return
+		}
+	}
+	lines, truncated, err := s.serviceLogs(r.Context(), service, window.Since, window.Lines)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
 
-		req := &storage.CreateServiceRequest{
-			ProjectID:   projectID,
-			Name:        r.FormValue("name"),
-			Type:        r.FormValue("type"),
-			Version:     r.FormValue("version"),
-			Port:        port,
-			GitRepoURL:  r.FormValue("git_repo_url"),
-			Command:     r.FormValue("command"),
-			WorkingDir:  r.FormValue("working_dir"),
-			User:        r.FormValue("user"),
-			Environment: r.FormValue("environment"),
-			AutoRestart: r.FormValue("auto_restart") == "on",
-			Config:      "",
-			SystemdRaw:  r.FormValue("systemd_raw"),
-			NginxRaw:    r.FormValue("nginx_raw"),
+	lines = filterLines(lines, filters)
+	if window.Newest {
+		slices.Reverse(lines)
+	}
+	resp := logsResponse{Logs: ansi.Apply(mode, joinLines(lines)), Counts: countLevels(lines), Truncated: truncated}
+	if structured {
+		for i := range lines {
+			lines[i].Message = ansi.Apply(mode, lines[i].Message)
+			lines[i].Raw = ansi.Apply(mode, lines[i].Raw)
 		}
+		resp.Entries = lines
+	}
+	jsonResponse(w, resp)
+}
 
-		service, err := s.store.CreateService(r.Context(), req)
-		if err != nil {
-			data := map[string]interface{}{
-				"Title":     "Add Service",
-				"ProjectID": projectID,
-				"Service":   req,
-				"Error":     err.Error(),
-			}
-			render(w, "service_form.html", data)
-			return
+// handleAPIDownloadServiceLogs serves the logs since the service last started
+// as a text file, with escape codes kept unless ansi says otherwise
+// GET /api/services/{id}/logs/download?ansi=
+func (s *Server) handleAPIDownloadServiceLogs(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	mode, err := ansi.ParseMode(r.URL.Query().Get("ansi"), ansi.ModeRaw)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	lines, _, err := s.serviceLogs(r.Context(), service, time.Time{}, 0)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	contentType := "text/plain; charset=utf-8"
+	if mode == ansi.ModeHTML {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.log"`, strings.TrimSuffix(service.ServiceName(), ".service")))
+	io.WriteString(w, ansi.Apply(mode, joinLines(lines)))
+}
+
+// serviceLogs returns the service's log lines since the given time, or since
+// it last started (or was created, when it is not running) when since is
+// zero, classified by level with the help of each entry's journal priority.
+// A positive limit keeps the most recent lines; truncated reports whether
+// older ones were left out.
+func (s *Server) serviceLogs(ctx context.Context, service *storage.Service, since time.Time, limit int) (lines []logparse.Line, truncated bool, err error) {
+	return s.unitLogs(ctx, service, service.ServiceName(), since, limit)
+}
+
+// unitLogs is serviceLogs for one of the service's units, such as an
+// instance of a scaled service
+func (s *Server) unitLogs(ctx context.Context, service *storage.Service, unit string, since time.Time, limit int) (lines []logparse.Line, truncated bool, err error) {
+	sinceArg := since.Local().Format("2006-01-02 15:04:05")
+	if since.IsZero() {
+		sinceArg, _ = s.svcManager.GetStartTime(ctx, unit)
+		if sinceArg == "" {
+			sinceArg = service.CreatedAt.Format("2006-01-02 15:04:05")
 		}
+	}
+	// One line more than asked for shows whether there are older ones
+	fetch := limit
+	if limit > 0 {
+		fetch++
+	}
+	entries, err := s.svcManager.GetLogEntries(ctx, unit, sinceArg, fetch)
+	if err != nil {
+		return nil, false, err
+	}
+	if limit > 0 && len(entries) > limit {
+		entries, truncated = entries[len(entries)-limit:], true
+	}
+	lines = make([]logparse.Line, len(entries))
+	for i, e := range entries {
+		lines[i] = logparse.Parse(e.Line()).WithPriority(e.Priority)
+	}
+	return lines, truncated, nil
+}
 
-		// Clone git repository if URL is provided
-		if service.GitRepoURL != "" && service.WorkingDir != "" {
-			if err := git.CloneRepository(service.GitRepoURL, service.WorkingDir); err != nil {
-				slog.Error("Failed to clone repository", "error", err, "service", service.Name)
+// handleAPIStats reports this server's resources and, for admins, those of
+// each agent host. Services on agent hosts are reported from the host's last
+// reading.
+// GET /api/stats
+func (s *Server) handleAPIStats(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, s.stats(r.Context()))
+}
+
+// stats is what handleAPIStats reports for the team scope of ctx
+func (s *Server) stats(ctx context.Context) statsResponse {
+	projects, _ := s.store.ListProjects(ctx)
+	var local []*storage.Service
+	remote := map[string]int64{} // unit -> host
+	for _, p := range projects {
+		services, _ := s.store.ListServicesByProject(ctx, p.ID)
+		for _, svc := range services {
+			if p.HostID != 0 {
+				remote[svc.ServiceName()] = p.HostID
+				continue
 			}
+			local = append(local, svc)
 		}
+	}
 
-		// Install the systemd service
-		if err := s.svcManager.InstallService(r.Context(), service); err != nil {
-			slog.Warn("Failed to install service", "error", err, "service", service.Name)
+	resp := statsResponse{Stats: s.localStats(ctx, local)}
+	hosts := s.hosts.Stats(ctx)
+	for _, h := range hosts {
+		if h.Stats == nil {
+			continue
 		}
+		for unit, stat := range h.Stats.Services {
+			if remote[unit] != h.Host.ID {
+				continue
+			}
+			if resp.Services == nil {
+				resp.Services = map[string]monitor.ServiceStat{}
+			}
+			resp.Services[unit] = stat
+		}
+	}
+	if _, scoped := storage.TeamScopeFromContext(ctx); !scoped {
+		resp.Hosts = hosts
+	}
+	return resp
+}
 
-		http.Redirect(w, r, fmt.Sprintf("/projects/%d", projectID), http.StatusSeeOther)
+func (s *Server) handleNewService(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.ParseInt(r.URL.Query().Get("project_id"), 10, 64)
+	if err != nil {
+		http.Redirect(w, r, basePath+"/", http.StatusSeeOther)
 		return
 	}
-}
 
-func (s *Server) handleServiceDetail(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/services/")
-	parts := strings.Split(path, "/")
+	data := map[string]interface{}{
+		"Title":     "Add Service",
+		"ProjectID": projectID,
+		"Service":   &storage.Service{AutoRestart: true},
+	}
+	templateID, _ := strconv.ParseInt(r.URL.Query().Get("template"), 10, 64)
+	if t := s.addFormTemplates(r.Context(), data, templateID, nil); t != nil {
+		data["Service"] = templateForm(t)
+	}
+	render(w, r, "service_form.html", data)
+}
 
-	id, err := strconv.ParseInt(parts[0], 10, 64)
+func (s *Server) handleCreateService(w http.ResponseWriter, r *http.Request) {
+	projectID, err := strconv.ParseInt(r.URL.Query().Get("project_id"), 10, 64)
 	if err != nil {
-		http.NotFound(w, r)
+		http.Redirect(w, r, basePath+"/", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
 		return
 	}
 
-	service, err := s.store.GetService(r.Context(), id)
-	if err != nil || service == nil {
-		http.NotFound(w, r)
+	port, _ := strconv.Atoi(r.FormValue("port"))
+
+	req := &storage.CreateServiceRequest{
+		ProjectID:   projectID,
+		Name:        r.FormValue("name"),
+		Type:        r.FormValue("type"),
+		Version:     r.FormValue("version"),
+		Runtime:     r.FormValue("runtime"),
+		Image:       r.FormValue("image"),
+		Port:        port,
+		GitRepoURL:  r.FormValue("git_repo_url"),
+		GitBranch:   r.FormValue("git_branch"),
+		GitFilter:   r.FormValue("git_filter"),
+		Command:     r.FormValue("command"),
+		WorkingDir:  r.FormValue("working_dir"),
+		User:        r.FormValue("user"),
+		Environment: r.FormValue("environment"),
+		AutoRestart: r.FormValue("auto_restart") == "on",
+		Config:      "",
+		SystemdRaw:  r.FormValue("systemd_raw"),
+		NginxRaw:    r.FormValue("nginx_raw"),
+		Notes:       r.FormValue("notes"),
+		Tags:        storage.ParseTags(r.FormValue("tags")),
+		CreateUser:  r.FormValue("create_user") == "on",
+
+		CreateWorkingDir: r.FormValue("create_working_dir") == "on",
+	}
+
+	// A service started from a template is filled in from it
+	create, vars, err := s.formTemplateService(r, req)
+	var service *storage.Service
+	if err == nil {
+		service, err = s.createService(r.Context(), create, vars)
+	}
+	if err != nil {
+		data := map[string]interface{}{
+			"Title":       "Add Service",
+			"ProjectID":   projectID,
+			"Service":     req,
+			"Error":       err.Error(),
+			"FieldErrors": formFieldErrors(err),
+		}
+		templateID, _ := strconv.ParseInt(r.FormValue("template_id"), 10, 64)
+		s.addFormTemplates(r.Context(), data, templateID, formParams(r))
+		render(w, r, "service_form.html", data)
 		return
 	}
 
-	// Handle actions
-	if len(parts) > 1 && r.Method == http.MethodPost {
-		action := parts[1]
-		var actionErr error
-
-		switch action {
-		case "start":
-			actionErr = s.svcManager.Start(r.Context(), service.ServiceName())
-		case "stop":
-			actionErr = s.svcManager.Stop(r.Context(), service.ServiceName())
-		case "restart":
-			actionErr = s.svcManager.Restart(r.Context(), service.ServiceName())
-		case "install":
-			actionErr = s.svcManager.InstallService(r.Context(), service)
-			if actionErr == nil {
-				s.svcManager.Enable(r.Context(), service.ServiceName())
-				actionErr = s.svcManager.Start(r.Context(), service.ServiceName())
-			}
-		case "provision":
-			// Install dependencies using blueprint
-			bp, ok := s.blueprints.Get(service.Type)
-			if !ok {
-				actionErr = fmt.Errorf("no blueprint found for service type '%s'", service.Type)
-			} else {
-				actionErr = bp.InstallDependencies(r.Context(), service.Version)
-				if actionErr == nil {
-					// After provisioning, try to install and start
-					actionErr = s.svcManager.InstallService(r.Context(), service)
-					if actionErr == nil {
-						s.svcManager.Enable(r.Context(), service.ServiceName())
-						actionErr = s.svcManager.Start(r.Context(), service.ServiceName())
-					}
-				}
-			}
-		case "uninstall":
-			actionErr = s.svcManager.UninstallService(r.Context(), service.ServiceName())
-		case "delete":
-			s.svcManager.UninstallService(r.Context(), service.ServiceName())
-			s.store.DeleteService(r.Context(), id)
-			http.Redirect(w, r, fmt.Sprintf("/projects/%d", service.ProjectID), http.StatusSeeOther)
-			return
+	// Clone the repository and install the unit in the background
+	http.Redirect(w, r, jobURL(projectID, s.enqueueInstall(r, service, setupSteps{clone: true, createUser: req.CreateUser, createDir: req.CreateWorkingDir})), http.StatusSeeOther)
+}
+
+// handleServiceDetail has no page of its own; services are shown on their project.
+// htmx requests get the service card instead, plus the alerts for ?job= if given.
+func (s *Server) handleServiceDetail(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if isHTMX(r) {
+		var alerts *pageAlerts
+		if job := s.followedServiceJob(r, service); job != nil {
+			a := jobAlerts(job)
+			alerts = &a
 		}
+		s.renderServiceCard(w, r, service, alerts)
+		return
+	}
+	http.Redirect(w, r, projectURL(service.ProjectID, nil), http.StatusSeeOther)
+}
+
+// handleServiceAction runs a lifecycle action from the project page. htmx
+// requests get the updated service card and alerts to swap in place; plain
+// form posts are redirected back to the project page.
+// POST /services/{id}/{action} - start, stop, restart, install, provision, uninstall, delete
+func (s *Server) handleServiceAction(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var actionErr error
+	var jobID int64
+	deleted := false
+
+	switch r.PathValue("action") {
+	case "start":
+		actionErr = s.svcManager.Start(r.Context(), service.ServiceName())
+	case "stop":
+		actionErr = s.svcManager.Stop(r.Context(), service.ServiceName())
+	case "restart":
+		actionErr = s.svcManager.Restart(r.Context(), service.ServiceName())
+	case "install":
+		jobID, actionErr = s.enqueueSetup(r.Context(), service, setupSteps{start: true})
+	case "provision":
+		// Install dependencies using blueprint, then install and start
+		jobID, actionErr = s.enqueueSetup(r.Context(), service, setupSteps{dependencies: true, start: true})
+	case "uninstall":
+		actionErr = s.svcManager.UninstallService(r.Context(), service.ServiceName())
+	case "delete":
+		s.svcManager.UninstallService(r.Context(), service.ServiceName())
+		actionErr = s.store.DeleteService(r.Context(), service.ID)
+		deleted = actionErr == nil
+	default:
+		http.NotFound(w, r)
+		return
+	}
 
+	if isHTMX(r) {
+		var alerts pageAlerts
 		if actionErr != nil {
-			// Include service_id if the error is fixable via provisioning
-			errStr := actionErr.Error()
-			if strings.Contains(errStr, "not found") || strings.Contains(errStr, "does not exist") {
-				http.Redirect(w, r, fmt.Sprintf("/projects/%d?error=%s&fix_service=%d", service.ProjectID, errStr, id), http.StatusSeeOther)
-			} else {
-				http.Redirect(w, r, fmt.Sprintf("/projects/%d?error=%s", service.ProjectID, errStr), http.StatusSeeOther)
+			alerts.Error = actionErr.Error()
+			if fixableError(actionErr.Error()) {
+				alerts.FixService = service.ID
 			}
-			return
+		} else if jobID != 0 {
+			alerts.Job, _ = s.store.GetJob(r.Context(), jobID)
+		}
+		if deleted {
+			service = nil
 		}
+		s.renderServiceCard(w, r, service, &alerts)
+		return
+	}
 
-		http.Redirect(w, r, fmt.Sprintf("/projects/%d", service.ProjectID), http.StatusSeeOther)
+	if actionErr != nil {
+		query := url.Values{"error": {actionErr.Error()}}
+		// Include fix_service if the error is fixable via provisioning
+		if fixableError(actionErr.Error()) {
+			query.Set("fix_service", strconv.FormatInt(service.ID, 10))
+		}
+		http.Redirect(w, r, projectURL(service.ProjectID, query), http.StatusSeeOther)
 		return
 	}
 
-	// Handle edit form
-	if len(parts) > 1 && parts[1] == "edit" {
-		if r.Method == http.MethodGet {
-			// If command is empty but we have a blueprint, generate it for display
-			if service.Command == "" && service.Type != "" && service.Type != "custom" {
-				if bpInterface, ok := s.blueprints.Get(service.Type); ok {
-					if bp, ok := bpInterface.(interface {
-						GenerateCommand(*storage.Service) string
-					}); ok {
-						service.Command = bp.GenerateCommand(service)
-					}
-				}
-			}
+	if !deleted {
+		// The project page reads cached statuses; this one changed just now
+		s.serviceStatus(r.Context(), service)
+	}
+	http.Redirect(w, r, jobURL(service.ProjectID, jobID), http.StatusSeeOther)
+}
 
-			data := map[string]interface{}{
-				"Title":     "Edit Service",
-				"ProjectID": service.ProjectID,
-				"Service":   service,
-				"Edit":      true,
+func (s *Server) handleEditService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	// If command is empty but we have a blueprint, generate it for display
+	if service.Command == "" && service.Type != "" && service.Type != "custom" {
+		if bpInterface, ok := s.blueprints.Get(service.Type); ok {
+			if bp, ok := bpInterface.(interface {
+				GenerateCommand(*storage.Service) string
+			}); ok {
+				service.Command = bp.GenerateCommand(service)
 			}
-			render(w, "service_form.html", data)
-			return
 		}
+	}
 
-		if r.Method == http.MethodPost {
-			slog.Info("Updating service", "service_id", id, "name", service.Name)
-
-			if err := r.ParseForm(); err != nil {
-				http.Error(w, "Invalid form data", http.StatusBadRequest)
-				return
-			}
-			port, _ := strconv.Atoi(r.FormValue("port"))
-
-			// For blueprint-managed services, only clear the command if it matches what the blueprint would generate.
-			// This allows users to manually override the command while still keeping it dynamic by default.
-			command := r.FormValue("command")
-			if bpInterface, ok := s.blueprints.Get(service.Type); ok {
-				if bp, ok := bpInterface.(interface {
-					GenerateCommand(*storage.Service) string
-				}); ok {
-					// Create a temporary service object with the NEW values to see what the generated command WOULD be
-					tempSvc := *service
-					tempSvc.Port = port
-					generatedCmd := bp.GenerateCommand(&tempSvc)
-
-					if command == generatedCmd {
-						slog.Info("Command matches blueprint, clearing for dynamic generation", "service", service.Name)
-						command = ""
-					}
-				}
-			}
+	data := map[string]interface{}{
+		"Title":     "Edit Service",
+		"ProjectID": service.ProjectID,
+		"Service":   service,
+		"Edit":      true,
+	}
+	render(w, r, "service_form.html", data)
+}
 
-			req := &storage.UpdateServiceRequest{
-				Name:        r.FormValue("name"),
-				Port:        port,
-				GitRepoURL:  r.FormValue("git_repo_url"),
-				Command:     command,
-				WorkingDir:  r.FormValue("working_dir"),
-				User:        r.FormValue("user"),
-				Environment: r.FormValue("environment"),
-				AutoRestart: r.FormValue("auto_restart") == "on",
-				Config:      "",
-				SystemdRaw:  r.FormValue("systemd_raw"),
-				NginxRaw:    r.FormValue("nginx_raw"),
-			}
+func (s *Server) handleUpdateService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	slog.InfoContext(r.Context(), "Updating service", "service_id", service.ID, "name", service.Name)
 
-			service, err = s.store.UpdateService(r.Context(), id, req)
-			if err != nil {
-				slog.Error("Failed to update service", "error", err)
-				data := map[string]interface{}{
-					"Title":     "Edit Service",
-					"ProjectID": service.ProjectID,
-					"Service":   req,
-					"Error":     err.Error(),
-					"Edit":      true,
-				}
-				render(w, "service_form.html", data)
-				return
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+	port, _ := strconv.Atoi(r.FormValue("port"))
+
+	// For blueprint-managed services, only clear the command if it matches what the blueprint would generate.
+	// This allows users to manually override the command while still keeping it dynamic by default.
+	command := r.FormValue("command")
+	if bpInterface, ok := s.blueprints.Get(service.Type); ok {
+		if bp, ok := bpInterface.(interface {
+			GenerateCommand(*storage.Service) string
+		}); ok {
+			// Create a temporary service object with the NEW values to see what the generated command WOULD be
+			tempSvc := *service
+			tempSvc.Port = port
+			generatedCmd := bp.GenerateCommand(&tempSvc)
+
+			if command == generatedCmd {
+				slog.InfoContext(r.Context(), "Command matches blueprint, clearing for dynamic generation", "service", service.Name)
+				command = ""
 			}
+		}
+	}
 
-			// Reinstall the service with updated configuration and restart it
-			slog.Info("Reinstalling and restarting service after update", "service", service.Name)
-			if err := s.svcManager.InstallService(r.Context(), service); err != nil {
-				slog.Warn("Failed to reinstall service", "error", err)
-			}
-			if err := s.svcManager.Restart(r.Context(), service.ServiceName()); err != nil {
-				slog.Warn("Failed to restart service after update", "error", err)
-			}
+	req := &storage.UpdateServiceRequest{
+		Name:        r.FormValue("name"),
+		Image:       r.FormValue("image"),
+		Port:        port,
+		GitRepoURL:  r.FormValue("git_repo_url"),
+		GitBranch:   r.FormValue("git_branch"),
+		GitFilter:   r.FormValue("git_filter"),
+		Command:     command,
+		WorkingDir:  r.FormValue("working_dir"),
+		User:        r.FormValue("user"),
+		Environment: r.FormValue("environment"),
+		AutoRestart: r.FormValue("auto_restart") == "on",
+		Config:      "",
+		SystemdRaw:  r.FormValue("systemd_raw"),
+		NginxRaw:    r.FormValue("nginx_raw"),
+		Notes:       r.FormValue("notes"),
+		Tags:        storage.ParseTags(r.FormValue("tags")),
+		CreateUser:  r.FormValue("create_user") == "on",
+
+		CreateWorkingDir: r.FormValue("create_working_dir") == "on",
+	}
 
-			http.Redirect(w, r, fmt.Sprintf("/projects/%d", service.ProjectID), http.StatusSeeOther)
-			return
+	current := service
+	err := s.checkHostPort(r.Context(), current.ProjectID, req.Port, current)
+	if err == nil {
+		opts := preflightOptions{createUser: req.CreateUser, createDir: req.CreateWorkingDir}
+		err = s.preflightService(r.Context(), serviceUpdate(current, req), current, opts)
+	}
+	if err == nil {
+		service, err = s.store.UpdateService(r.Context(), current.ID, req)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update service", "error", err)
+		data := map[string]interface{}{
+			"Title":       "Edit Service",
+			"ProjectID":   current.ProjectID,
+			"Service":     req,
+			"Error":       err.Error(),
+			"FieldErrors": formFieldErrors(err),
+			"Edit":        true,
 		}
+		render(w, r, "service_form.html", data)
+		return
 	}
 
-	// For now, if no action, just redirect to project
-	http.Redirect(w, r, fmt.Sprintf("/projects/%d", service.ProjectID), http.StatusSeeOther)
+	// Reinstall the service with updated configuration and restart it
+	slog.InfoContext(r.Context(), "Reinstalling and restarting service after update", "service", service.Name)
+	http.Redirect(w, r, jobURL(service.ProjectID, s.enqueueInstall(r, service, setupSteps{restart: true, createUser: req.CreateUser, createDir: req.CreateWorkingDir})), http.StatusSeeOther)
 }
 
 // handleAPIBlueprints returns metadata for all registered blueprints
 // GET /api/blueprints - returns list of all blueprints with versions
 // GET /api/blueprints?type=postgres&version=16 - returns defaults for specific blueprint
 func (s *Server) handleAPIBlueprints(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// If type is specified, return defaults for that blueprint
 	bpType := r.URL.Query().Get("type")
 	if bpType != "" {
 		version := r.URL.Query().Get("version")
 		defaults, ok := s.blueprints.GetDefaults(bpType, version)
 		if !ok {
-			jsonError(w, "Blueprint not found", http.StatusNotFound)
+			jsonError(w, r, "Blueprint not found", http.StatusNotFound)
 			return
 		}
 		jsonResponse(w, defaults)
@@ -822,114 +1324,358 @@ func (s *Server) handleAPIBlueprints(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, s.blueprints.AllMetadata())
 }
 
-// handleAPINginx handles Nginx site config operations for a project
-// POST /api/nginx/{project_id}/deploy - Generate and install Nginx config
-// POST /api/nginx/{project_id}/remove - Remove Nginx config
-// GET /api/nginx/{project_id}/preview - Preview generated config
-func (s *Server) handleAPINginx(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/nginx/")
-	parts := strings.Split(path, "/")
-
-	if len(parts) < 2 {
-		jsonError(w, "Invalid path", http.StatusBadRequest)
+// handleAPINginxPreview previews the generated site config for a project and
+// checks that its domain points at the server. Projects on an agent host are
+// previewed as their agent would install them.
+// GET /api/nginx/{id}/preview
+func (s *Server) handleAPINginxPreview(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	config, err := s.nginxManager.GenerateSiteConfig(project)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defaultConfig, _ := s.nginxManager.GenerateDefaultConfig(project)
+	resp := nginxPreviewResponse{
+		Config:        config,
+		DefaultConfig: defaultConfig,
+		Path:          s.nginxManager.SiteConfigPath(project),
+		Installed:     s.nginxManager.SiteExists(project),
+		IsCustomized:  project.NginxRaw != "",
+	}
+	if project.HostID != 0 {
+		client, err := s.hosts.Client(r.Context(), project.HostID)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		preview, err := client.PreviewSite(r.Context(), project)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		resp.Config, resp.Path, resp.Installed = preview.Config, preview.Path, preview.Installed
+	} else {
+		resp.Conflicts, resp.UnitConflicts = s.projectConflicts(project)
+	}
+	if resp.DNS, _, err = s.checkDomainDNS(r.Context(), project); err != nil {
+		apiError(w, r, err)
 		return
 	}
+	jsonResponse(w, resp)
+}
 
-	projectID, err := strconv.ParseInt(parts[0], 10, 64)
+// installSite installs a project's site on the host it runs on
+func (s *Server) installSite(ctx context.Context, project *storage.Project) error {
+	client, err := s.hosts.Client(ctx, project.HostID)
 	if err != nil {
-		jsonError(w, "Invalid project ID", http.StatusBadRequest)
-		return
+		return err
+	}
+	if client != nil {
+		return client.InstallSite(ctx, project)
 	}
+	return s.nginxManager.InstallSite(ctx, project)
+}
 
-	action := parts[1]
+// uninstallSite removes a project's site from the host it runs on
+func (s *Server) uninstallSite(ctx context.Context, project *storage.Project) error {
+	client, err := s.hosts.Client(ctx, project.HostID)
+	if err != nil {
+		return err
+	}
+	if client != nil {
+		return client.RemoveSite(ctx, project)
+	}
+	return s.nginxManager.UninstallSite(ctx, project)
+}
 
-	project, err := s.store.GetProject(r.Context(), projectID)
-	if err != nil || project == nil {
-		jsonError(w, "Project not found", http.StatusNotFound)
-		return
+// serviceStatics returns the output directories of a service's asset builds
+// for its project's generated site
+func (s *Server) serviceStatics(service *storage.Service) []nginx.Static {
+	var statics []nginx.Static
+	for _, b := range s.blueprints.AssetBuilds(service) {
+		statics = append(statics, nginx.Static{Path: b.URLPath, Dir: b.Output, SPA: b.SPA})
 	}
+	return statics
+}
 
-	switch action {
-	case "preview":
-		if r.Method != http.MethodGet {
-			jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleAPINginxDeploy generates and installs the site config. Unless the
+// dns_check setting says otherwise, a domain that does not resolve to the
+// server is refused.
+// POST /api/nginx/{id}/deploy
+func (s *Server) handleAPINginxDeploy(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if project.Domain == "" {
+		jsonError(w, r, "Project has no domain configured", http.StatusBadRequest)
+		return
+	}
+	check, mode, err := s.checkDomainDNS(r.Context(), project)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := check.Err(); err != nil {
+		if mode == "enforce" {
+			apiError(w, r, err)
 			return
 		}
-		config, err := s.nginxManager.GenerateSiteConfig(project)
+		slog.WarnContext(r.Context(), "Deploying nginx site whose domain does not point at the server", "project", project.Name, "error", err)
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error { return s.installSite(ctx, project) })
+		return
+	}
+	if err := s.installSite(r.Context(), project); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to deploy nginx config", "error", err, "project", project.Name)
+		apiError(w, r, err)
+		return
+	}
+	s.events.Publish(events.Event{Type: events.NginxDeployed, ProjectID: project.ID, Data: map[string]interface{}{
+		"project": project.Name,
+		"domain":  project.Domain,
+	}})
+	jsonResponse(w, statusResponse{Status: "deployed", Domain: project.Domain})
+}
+
+// handleAPINginxRemove removes the site config
+// POST /api/nginx/{id}/remove
+func (s *Server) handleAPINginxRemove(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error { return s.uninstallSite(ctx, project) })
+		return
+	}
+	if err := s.uninstallSite(r.Context(), project); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, statusResponse{Status: "removed"})
+}
+
+// handleAPINginxSave stores a custom site config on the project
+// POST /api/nginx/{id}/save
+func (s *Server) handleAPINginxSave(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var body nginxConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.store.UpdateProjectNginxRaw(r.Context(), project.ID, body.Config); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, statusResponse{Status: "saved"})
+}
+
+// handleAPISearch runs a full-text search across projects and services
+// GET /api/search?q=port+8001&limit=20
+func (s *Server) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		jsonError(w, r, "Missing search query", http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	results, err := s.store.Search(r.Context(), query, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Search failed", "query", query, "error", err)
+		jsonError(w, r, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, results)
+}
+
+// ================== Helpers ==================
+
+func jsonResponse(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// projectHandlerFunc and serviceHandlerFunc receive the entity named by the {id} path wildcard
+type (
+	projectHandlerFunc func(http.ResponseWriter, *http.Request, *storage.Project)
+	serviceHandlerFunc func(http.ResponseWriter, *http.Request, *storage.Service)
+)
+
+// pathID parses a numeric path wildcard such as {id}
+func pathID(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(r.PathValue(name), 10, 64)
+}
+
+// apiProject loads the project for an API route and attributes audited actions to it
+func (s *Server) apiProject(next projectHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
 		if err != nil {
-			jsonError(w, err.Error(), http.StatusBadRequest)
+			jsonError(w, r, "Invalid project ID", http.StatusBadRequest)
 			return
 		}
-		defaultConfig, _ := s.nginxManager.GenerateDefaultConfig(project)
-		jsonResponse(w, map[string]interface{}{
-			"config":         config,
-			"default_config": defaultConfig,
-			"path":           s.nginxManager.SiteConfigPath(project),
-			"installed":      s.nginxManager.SiteExists(project),
-			"is_customized":  project.NginxRaw != "",
-		})
-
-	case "deploy":
-		if r.Method != http.MethodPost {
-			jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		project, err := s.store.GetProject(r.Context(), id)
+		if err != nil || project == nil {
+			jsonError(w, r, "Project not found", http.StatusNotFound)
 			return
 		}
-		if project.Domain == "" {
-			jsonError(w, "Project has no domain configured", http.StatusBadRequest)
+		next(w, r.WithContext(audit.WithTarget(r.Context(), project.ID, 0)), project)
+	}
+}
+
+// apiService loads the service for an API route and attributes audited actions to it
+func (s *Server) apiService(next serviceHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			jsonError(w, r, "Invalid service ID", http.StatusBadRequest)
 			return
 		}
-		if err := s.nginxManager.InstallSite(r.Context(), project); err != nil {
-			slog.Error("Failed to deploy nginx config", "error", err, "project", project.Name)
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+		service, err := s.store.GetService(r.Context(), id)
+		if err != nil || service == nil {
+			jsonError(w, r, "Service not found", http.StatusNotFound)
 			return
 		}
-		jsonResponse(w, map[string]string{"status": "deployed", "domain": project.Domain})
+		next(w, r.WithContext(audit.WithTarget(r.Context(), service.ProjectID, service.ID)), service)
+	}
+}
 
-	case "remove":
-		if r.Method != http.MethodPost {
-			jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+// uiProject is apiProject for HTML pages, answering with a plain 404
+func (s *Server) uiProject(next projectHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.NotFound(w, r)
 			return
 		}
-		if err := s.nginxManager.UninstallSite(r.Context(), project); err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+		project, err := s.store.GetProject(r.Context(), id)
+		if err != nil || project == nil {
+			http.NotFound(w, r)
 			return
 		}
-		jsonResponse(w, map[string]string{"status": "removed"})
+		next(w, r.WithContext(audit.WithTarget(r.Context(), project.ID, 0)), project)
+	}
+}
 
-	case "save":
-		if r.Method != http.MethodPost {
-			jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		var body struct {
-			Config string `json:"config"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			jsonError(w, "Invalid request body", http.StatusBadRequest)
+// uiService is apiService for HTML pages, answering with a plain 404
+func (s *Server) uiService(next serviceHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathID(r, "id")
+		if err != nil {
+			http.NotFound(w, r)
 			return
 		}
-		// Save the custom config to the project's NginxRaw field
-		_, err := s.store.UpdateProjectNginxRaw(r.Context(), project.ID, body.Config)
-		if err != nil {
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+		service, err := s.store.GetService(r.Context(), id)
+		if err != nil || service == nil {
+			http.NotFound(w, r)
 			return
 		}
-		jsonResponse(w, map[string]string{"status": "saved"})
+		next(w, r.WithContext(audit.WithTarget(r.Context(), service.ProjectID, service.ID)), service)
+	}
+}
 
-	default:
-		jsonError(w, "Unknown action", http.StatusBadRequest)
+// projectURL builds the project page URL, optionally with a query string
+func projectURL(id int64, query url.Values) string {
+	u := basePath + "/projects/" + strconv.FormatInt(id, 10)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
 	}
+	return u
 }
 
-// ================== Helpers ==================
+// serviceStatus asks systemd whether a service's unit is running, stopped,
+// or not installed, and updates the status cache
+func (s *Server) serviceStatus(ctx context.Context, service *storage.Service) string {
+	status, _ := s.svcManager.Status(ctx, service.ServiceName())
+	result := "not installed"
+	switch {
+	case status.Active:
+		result = "running"
+	case s.svcManager.ServiceExists(service.ServiceName()):
+		result = "stopped"
+	}
+	s.statuses.set(service.ID, result)
+	return result
+}
 
-func jsonResponse(w http.ResponseWriter, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
+// decodePatch decodes a PATCH body, rejecting unknown fields so a misspelled
+// field is reported instead of silently changing nothing
+func decodePatch(r *http.Request, patch interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patch); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
 }
 
-func jsonError(w http.ResponseWriter, message string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+// parseListOptions reads ?limit=, ?offset=, ?sort= and the ?type= and ?tag= filters from the query string
+func parseListOptions(r *http.Request) (storage.ListOptions, error) {
+	q := r.URL.Query()
+	opts := storage.ListOptions{
+		Sort: q.Get("sort"),
+		Type: strings.TrimSpace(q.Get("type")),
+		Tag:  strings.ToLower(strings.TrimSpace(q.Get("tag"))),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return opts, fmt.Errorf("invalid limit: %s", v)
+		}
+		opts.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return opts, fmt.Errorf("invalid offset: %s", v)
+		}
+		opts.Offset = offset
+	}
+
+	return opts, nil
+}
+
+// setPaginationHeaders exposes the total row count so list bodies can stay plain arrays
+func setPaginationHeaders(w http.ResponseWriter, total int, opts storage.ListOptions) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if opts.Limit > 0 {
+		limit := opts.Limit
+		if limit > storage.MaxListLimit {
+			limit = storage.MaxListLimit
+		}
+		w.Header().Set("X-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-Offset", strconv.Itoa(opts.Offset))
+	}
+}
+
+// jsonList writes a list response, honoring ?fields=id,name for sparse field selection
+func jsonList(w http.ResponseWriter, r *http.Request, data interface{}) {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		jsonResponse(w, data)
+		return
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		jsonError(w, r, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		jsonError(w, r, "Field selection is only supported on lists", http.StatusBadRequest)
+		return
+	}
+
+	wanted := strings.Split(fields, ",")
+	sparse := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		picked := make(map[string]json.RawMessage, len(wanted))
+		for _, f := range wanted {
+			f = strings.TrimSpace(f)
+			if v, ok := item[f]; ok {
+				picked[f] = v
+			}
+		}
+		sparse = append(sparse, picked)
+	}
+
+	jsonResponse(w, sparse)
 }
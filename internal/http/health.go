@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/agent"
+	"servio/internal/doctor"
+	"servio/internal/systemd"
+)
+
+// readinessTimeout bounds each readiness check so a hung dependency fails the probe instead of stalling it
+const readinessTimeout = 2 * time.Second
+
+// publicPaths are served without authentication so monitors and unit health
+// checks can reach them. Agent registration checks the agent token itself,
+// and signing in through SSO must work before there is a session.
+var publicPaths = map[string]bool{
+	"/healthz":         true,
+	"/readyz":          true,
+	agent.RegisterPath: true,
+	ssoLoginPath:       true,
+	ssoCallbackPath:    true,
+	ssoLogoutPath:      true,
+}
+
+// handleHealthz reports that the process is up and serving requests
+// GET /healthz
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, statusResponse{Status: "ok"})
+}
+
+// handleReadyz checks the database, systemd, and the nginx binary, answering 503 if any fails
+// GET /readyz
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) error{
+		"database": s.store.Ping,
+		"systemd":  s.svcManager.Ping,
+		"nginx": func(context.Context) error {
+			if !s.nginxManager.IsInstalled() {
+				return errors.New("nginx binary not found")
+			}
+			return nil
+		},
+	}
+
+	resp := readinessResponse{Status: "ok", Checks: make(map[string]readinessCheck, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+
+			result := readinessCheck{Status: "ok"}
+			if err := check(ctx); err != nil {
+				result = readinessCheck{Status: "failed", Error: err.Error()}
+			}
+			mu.Lock()
+			resp.Checks[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, c := range resp.Checks {
+		if c.Status != "ok" {
+			resp.Status = "unavailable"
+		}
+	}
+	if resp.Status != "ok" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	jsonResponse(w, resp)
+}
+
+// handleAPIDoctor checks the host prerequisites from the server's point of
+// view: its user, capabilities, and sudo rights. It also checks that no two
+// services of a host share a port.
+// GET /api/system/doctor
+func (s *Server) handleAPIDoctor(w http.ResponseWriter, r *http.Request) {
+	dirs := append([]string{systemd.ServiceDir}, s.nginxManager.SitesDirs()...)
+	report := doctor.Run(r.Context(), doctor.Options{Dirs: dirs})
+	report.Add(s.checkServicePorts(r.Context()))
+	jsonResponse(w, report)
+}
+
+// checkServicePorts reports the ports several services of one host are
+// configured with, which also keep the unique port index from being created
+func (s *Server) checkServicePorts(ctx context.Context) doctor.Result {
+	result := doctor.Result{Name: "service ports"}
+	conflicts, err := s.store.PortConflicts(ctx)
+	if err != nil {
+		result.Status, result.Detail = doctor.StatusFail, err.Error()
+		return result
+	}
+	if len(conflicts) == 0 {
+		result.Status, result.Detail = doctor.StatusPass, "every service port is unique on its host"
+		return result
+	}
+	details := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		host := c.Host
+		if c.HostID == 0 {
+			host = "this server"
+		}
+		details[i] = fmt.Sprintf("port %d on %s: %s", c.Port, host, strings.Join(c.Services, ", "))
+	}
+	result.Status, result.Detail = doctor.StatusFail, strings.Join(details, "; ")
+	result.Fix = "change the ports so each is used once per host, then restart servio to create the unique port index"
+	return result
+}
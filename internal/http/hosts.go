@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"servio/internal/agent"
+	"servio/internal/storage"
+)
+
+// agentPingTimeout bounds the check that a joining agent can be reached
+const agentPingTimeout = 10 * time.Second
+
+// minAgentTokenLength is the shortest token an agent may register with
+const minAgentTokenLength = 16
+
+// hostName is the pattern agent host names must match
+var hostName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// projectHostRequest is the body for assigning a project to a host
+type projectHostRequest struct {
+	HostID int64 `json:"host_id"` // 0 moves the project back to this server
+}
+
+// TrustAgentCA makes the server trust agent certificates signed by a CA in
+// the bundle caFile, besides the system's. Call it before Start.
+func (s *Server) TrustAgentCA(caFile string) error {
+	return s.hosts.TrustCA(caFile)
+}
+
+// SetAgentToken sets the token agents must present to join; "" refuses
+// every agent. It is safe to call while the server is running.
+func (s *Server) SetAgentToken(token string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	auth := *s.auth.Load()
+	auth.agentToken = token
+	s.auth.Store(&auth)
+}
+
+// validateRegistration checks an agent's name, URL, and token
+func validateRegistration(reg *agent.Registration) error {
+	var fields []storage.FieldError
+	if !hostName.MatchString(reg.Name) {
+		fields = append(fields, storage.FieldError{Field: "name", Message: "must be 1-63 letters, digits, dots, dashes, or underscores"})
+	}
+	if u, err := url.Parse(reg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields = append(fields, storage.FieldError{Field: "url", Message: "must be an http or https URL"})
+	}
+	if len(reg.Token) < minAgentTokenLength {
+		fields = append(fields, storage.FieldError{Field: "token", Message: fmt.Sprintf("must be at least %d characters", minAgentTokenLength)})
+	}
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// handleAPIRegisterAgent adds a host, or updates the one with the same name,
+// when its agent joins. It is authenticated by the agent token rather than
+// basic auth (see publicPaths), and the agent must answer on its URL. An
+// existing host is only updated by an agent presenting the secret it was
+// registered with, so the shared token cannot take over a host; an admin
+// deletes the host to register a new agent under its name.
+// POST /api/agents/register {"name","url","token"}
+func (s *Server) handleAPIRegisterAgent(w http.ResponseWriter, r *http.Request) {
+	expected := s.auth.Load().agentToken
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if expected == "" {
+		jsonError(w, r, "Agent registration is disabled; set SERVIO_AGENT_TOKEN", http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		jsonError(w, r, "Invalid agent token", http.StatusUnauthorized)
+		return
+	}
+
+	var reg agent.Registration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateRegistration(&reg); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	existing, err := s.store.GetHostByName(r.Context(), reg.Name)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if existing != nil {
+		secret, err := s.cipher.Decrypt(existing.Ciphertext)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(reg.Token), []byte(secret)) != 1 {
+			slog.WarnContext(r.Context(), "Agent refused: host registered with another secret", "host", reg.Name, "url", reg.URL)
+			jsonError(w, r, fmt.Sprintf("Host %s is registered by another agent; delete it to register this one", reg.Name), http.StatusConflict)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), agentPingTimeout)
+	defer cancel()
+	if err := s.hosts.NewClient(reg.Name, reg.URL, reg.Token).Ping(ctx); err != nil {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "url", Message: "agent cannot be reached: " + err.Error()}}})
+		return
+	}
+
+	ciphertext, err := s.cipher.Encrypt(reg.Token)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	host := &storage.Host{Name: reg.Name, URL: reg.URL, Ciphertext: ciphertext}
+	if err := s.store.RegisterHost(r.Context(), host); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.hosts.Reconfigure()
+	slog.InfoContext(r.Context(), "Agent joined", "host", host.Name, "url", host.URL)
+	jsonResponse(w, host)
+}
+
+// handleAPIListHosts lists the agent hosts with their last stats
+// GET /api/hosts
+func (s *Server) handleAPIListHosts(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, s.hosts.Stats(r.Context()))
+}
+
+// handleAPIDeleteHost forgets a host once no project runs on it. The agent
+// keeps running until it is stopped on the host.
+// DELETE /api/hosts/{id}
+func (s *Server) handleAPIDeleteHost(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid host ID", http.StatusBadRequest)
+		return
+	}
+	host, err := s.store.GetHost(r.Context(), id)
+	if err != nil || host == nil {
+		jsonError(w, r, "Host not found", http.StatusNotFound)
+		return
+	}
+	if err := s.store.DeleteHost(r.Context(), host.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.hosts.Reconfigure()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPISetProjectHost assigns a project to a host. Installed units and
+// sites stay where they are: remove them before moving the project and
+// install them again after.
+// PUT /api/projects/{id}/host {"host_id"}
+func (s *Server) handleAPISetProjectHost(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req projectHostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	project, err := s.store.SetProjectHost(r.Context(), project.ID, req.HostID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.hosts.Reconfigure()
+	jsonResponse(w, project)
+}
+
+// checkLocal returns an error wrapping agent.ErrLocalOnly when a project runs
+// on an agent host, for features that only work on this server
+func checkLocal(project *storage.Project, feature string) error {
+	if project.HostID != 0 {
+		return fmt.Errorf("%s: %w", feature, agent.ErrLocalOnly)
+	}
+	return nil
+}
+
+// checkServiceLocal is checkLocal for the project of a service
+func (s *Server) checkServiceLocal(ctx context.Context, service *storage.Service, feature string) error {
+	project, err := s.store.GetProject(ctx, service.ProjectID)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return nil
+	}
+	return checkLocal(project, feature)
+}
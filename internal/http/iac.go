@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"servio/internal/cron"
+	"servio/internal/envfile"
+	"servio/internal/iac"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// configExtensions are the file extensions of configuration exports, by format
+var configExtensions = map[string]string{
+	iac.FormatAnsible:   "yml",
+	iac.FormatTerraform: "tf",
+}
+
+// handleAPIExportConfig renders the services on this server, their .env
+// files, nginx sites, and cron jobs as an Ansible playbook or Terraform configuration
+// GET /api/export/config?format=ansible|terraform&project_id=1
+func (s *Server) handleAPIExportConfig(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = iac.FormatAnsible
+	}
+	if _, ok := configExtensions[format]; !ok {
+		apiError(w, r, fmt.Errorf("%w: %q (want %s or %s)", iac.ErrUnknownFormat, format, iac.FormatAnsible, iac.FormatTerraform))
+		return
+	}
+
+	var projects []*storage.Project
+	if v := q.Get("project_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			jsonError(w, r, "Invalid project_id", http.StatusBadRequest)
+			return
+		}
+		project, err := s.store.GetProject(r.Context(), id)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if project == nil {
+			jsonError(w, r, "Project not found", http.StatusNotFound)
+			return
+		}
+		projects = []*storage.Project{project}
+	} else {
+		var err error
+		if projects, err = s.store.ListProjects(r.Context()); err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
+
+	host, err := s.exportHost(r.Context(), projects)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	out, err := iac.Render(format, host)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="servio-config-%s.%s"`, time.Now().Format("20060102"), configExtensions[format]))
+	w.Write([]byte(out))
+}
+
+// exportHost collects what Servio manages for projects on this server.
+// Projects on agent hosts and container services are noted as skipped.
+func (s *Server) exportHost(ctx context.Context, projects []*storage.Project) (*iac.Host, error) {
+	host := &iac.Host{}
+	for _, project := range projects {
+		if project.HostID != 0 {
+			host.Skip("project %s, which runs on agent host %d", project.Name, project.HostID)
+			continue
+		}
+		services, err := s.store.ListServicesByProject(ctx, project.ID)
+		if err != nil {
+			return nil, err
+		}
+		project.Services = services
+		for _, service := range services {
+			if err := s.exportService(ctx, host, service); err != nil {
+				return nil, fmt.Errorf("%s: %w", service.Name, err)
+			}
+		}
+		if err := s.exportSite(host, project); err != nil {
+			return nil, fmt.Errorf("%s: %w", project.Name, err)
+		}
+		if err := s.exportCronJobs(ctx, host, project); err != nil {
+			return nil, fmt.Errorf("%s: %w", project.Name, err)
+		}
+	}
+	return host, nil
+}
+
+// exportService adds a service's packages, checkout, unit, and .env file
+func (s *Server) exportService(ctx context.Context, host *iac.Host, service *storage.Service) error {
+	if service.IsContainer() {
+		host.Skip("service %s, which runs as a container", service.Name)
+		return nil
+	}
+	host.AddPackages(service.Type)
+	if service.Type == "postgres" {
+		host.Skip("the databases and roles of %s; restore them from a backup", service.Name)
+	}
+	if service.GitRepoURL != "" && service.WorkingDir != "" {
+		host.AddRepo(iac.Repo{URL: service.GitRepoURL, Branch: service.GitBranch, Dir: service.WorkingDir})
+	}
+
+	unit, err := s.svcManager.GenerateServiceFile(service)
+	if err != nil {
+		return fmt.Errorf("failed to generate unit: %w", err)
+	}
+	host.Files = append(host.Files, unitFile(host, service.ServiceName(), unit))
+	host.Enable = append(host.Enable, service.ServiceName())
+	if service.Scaled() {
+		host.Skip("all but one instance of %s, which runs %d; scale it again once applied", service.Name, service.Replicas)
+	}
+
+	vars, err := s.envFiles.Vars(ctx, service)
+	if err != nil || len(vars) == 0 {
+		return err
+	}
+	file, err := s.store.GetEnvFile(ctx, service.ID)
+	if err != nil {
+		return err
+	}
+	path, err := envfile.Path(service, file)
+	if errors.Is(err, envfile.ErrNoPath) {
+		host.Skip("the .env file of %s, which has no working directory", service.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	values := make([]*storage.EnvVar, len(vars))
+	for i, v := range vars {
+		value := v.Value
+		if v.Secret {
+			value = host.Var("env_"+service.Name+"_"+v.Key, v.Key+" in the .env file of "+service.Name)
+		} else {
+			value = host.Parameterize(value)
+		}
+		values[i] = &storage.EnvVar{Key: v.Key, Value: value}
+	}
+	host.Files = append(host.Files, iac.File{Path: path, Content: envfile.Render(values), Owner: service.User, Mode: "0600"})
+	return nil
+}
+
+// exportSite adds a project's nginx site if it is installed
+func (s *Server) exportSite(host *iac.Host, project *storage.Project) error {
+	if project.Domain == "" || !s.nginxManager.SiteExists(project) {
+		return nil
+	}
+	config, err := s.nginxManager.GenerateSiteConfig(project)
+	if err != nil {
+		return fmt.Errorf("failed to generate site: %w", err)
+	}
+	path := s.nginxManager.SiteConfigPath(project)
+	host.Nginx = true
+	host.Files = append(host.Files, iac.File{Path: path, Content: config, Mode: "0644", Site: true})
+	if dirs := s.nginxManager.SitesDirs(); len(dirs) > 1 {
+		host.Links = append(host.Links, iac.Link{Path: filepath.Join(dirs[1], filepath.Base(path)), Target: path})
+	}
+	return nil
+}
+
+// exportCronJobs adds the units of a project's cron jobs, enabling the
+// timers of those that are enabled
+func (s *Server) exportCronJobs(ctx context.Context, host *iac.Host, project *storage.Project) error {
+	jobs, err := s.store.ListCronJobs(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		service, timer, err := cron.Units(job)
+		if err != nil {
+			host.Skip("cron job %s: %v", job.Name, err)
+			continue
+		}
+		name := job.UnitName()
+		host.Files = append(host.Files,
+			unitFile(host, name+".service", service),
+			iac.File{Path: filepath.Join(systemd.ServiceDir, name+".timer"), Content: timer, Mode: "0644"})
+		if job.Enabled {
+			host.Enable = append(host.Enable, name+".timer")
+		}
+	}
+	return nil
+}
+
+// unitFile is a unit with its secret references made variables; like
+// installed units holding secrets, it is readable only by root
+func unitFile(host *iac.Host, name, content string) iac.File {
+	content = host.Parameterize(content)
+	mode := "0644"
+	if iac.HasVars(content) {
+		mode = "0600"
+	}
+	return iac.File{Path: filepath.Join(systemd.ServiceDir, name), Content: content, Mode: mode}
+}
@@ -0,0 +1,382 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/agent"
+	"servio/internal/blueprints"
+	"servio/internal/git"
+	"servio/internal/hostuser"
+	"servio/internal/jobs"
+	"servio/internal/storage"
+)
+
+// jobStreamPoll is how often a job stream re-reads the job in case it missed the final event
+const jobStreamPoll = 5 * time.Second
+
+// jobStatuses are the accepted values of the ?status= filter on job listings
+var jobStatuses = map[string]bool{
+	storage.JobQueued:    true,
+	storage.JobRunning:   true,
+	storage.JobSucceeded: true,
+	storage.JobFailed:    true,
+}
+
+// handleAPIListJobs lists background jobs, newest first
+// GET /api/jobs?project_id=1&service_id=2&status=running&limit=50
+func (s *Server) handleAPIListJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.JobFilter{Status: q.Get("status")}
+	if filter.Status != "" && !jobStatuses[filter.Status] {
+		jsonError(w, r, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	for name, dst := range map[string]*int64{"project_id": &filter.ProjectID, "service_id": &filter.ServiceID} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				jsonError(w, r, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = id
+		}
+	}
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	filter.Limit = limit
+
+	list, err := s.store.ListJobs(r.Context(), filter)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, list)
+}
+
+// handleAPIGetJob returns a job with the log captured so far
+// GET /api/jobs/{id}
+func (s *Server) handleAPIGetJob(w http.ResponseWriter, r *http.Request) {
+	if job, ok := s.loadJob(w, r); ok {
+		jsonResponse(w, job)
+	}
+}
+
+// handleAPIJobLog downloads a job's log as a text file, such as the
+// transcript of a provisioning job's package installs
+// GET /api/jobs/{id}/log
+func (s *Server) handleAPIJobLog(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="job-%d-%s.log"`, job.ID, job.Kind))
+	io.WriteString(w, job.Log)
+}
+
+// handleAPICancelJob cancels a queued or running job. A running job stops
+// once the command it is running has been killed, so the returned job may
+// still be running; its stream ends when it has failed.
+// POST /api/jobs/{id}/cancel
+func (s *Server) handleAPICancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	if err := s.jobs.Cancel(job.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Job cancelled", "job_id", job.ID, "kind", job.Kind)
+	if job, ok = s.loadJob(w, r); ok {
+		w.WriteHeader(http.StatusAccepted)
+		jsonResponse(w, job)
+	}
+}
+
+// handleAPIJobStream streams a job's log over SSE: the lines logged so far,
+// then live lines, then a final "done" event carrying the finished job. Log
+// events are numbered from 1, so a client reconnecting with Last-Event-ID
+// only gets the lines after the last one it received.
+// GET /api/jobs/{id}/stream?last_event_id=
+func (s *Server) handleAPIJobStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the stored log so no line falls between the two
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	events, cancel := s.jobs.Subscribe(id)
+	defer cancel()
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+
+	resume, _ := strconv.Atoi(lastEventID(r))
+	startSSE(w, flusher)
+	sent := 0
+	for _, line := range strings.Split(strings.TrimSuffix(job.Log, "\n"), "\n") {
+		if line != "" {
+			if sent++; sent > resume {
+				fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", sent, line)
+			}
+		}
+	}
+	sent = max(sent, resume)
+	flusher.Flush()
+
+	// Events can be dropped for slow readers, so also re-check the job
+	// periodically, which doubles as the heartbeat
+	ticker := time.NewTicker(jobStreamPoll)
+	defer ticker.Stop()
+
+	for job.FinishedAt == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if job, ok = s.loadJob(w, r); !ok {
+				return
+			}
+			ssePing(w, flusher)
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Line != "" && event.Seq > sent {
+				sent = event.Seq
+				fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", sent, event.Line)
+				flusher.Flush()
+			}
+			if event.Status == storage.JobSucceeded || event.Status == storage.JobFailed {
+				if job, ok = s.loadJob(w, r); !ok {
+					return
+				}
+			}
+		}
+	}
+
+	data, _ := json.Marshal(job)
+	fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+	flusher.Flush()
+}
+
+// loadJob reads the {id} job. It writes the error response itself.
+func (s *Server) loadJob(w http.ResponseWriter, r *http.Request) (*storage.Job, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid job ID", http.StatusBadRequest)
+		return nil, false
+	}
+	job, err := s.store.GetJob(r.Context(), id)
+	if err != nil {
+		apiError(w, r, err)
+		return nil, false
+	}
+	if job == nil {
+		jsonError(w, r, "Job not found", http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
+
+// setupSteps selects what a service setup job does around writing the unit file
+type setupSteps struct {
+	clone        bool // clone the git repository first
+	dependencies bool // install the blueprint's dependencies (provisioning)
+	createUser   bool // create the service's user when it does not exist
+	createDir    bool // create the working directory when it does not exist
+	start        bool // enable and start the unit
+	restart      bool // restart the unit to pick up changes
+	graceful     bool // stop a running unit, waiting out its stop timeout, then start it
+}
+
+// enqueueInstall queues a setup job after a service was saved. Failing to queue
+// is logged rather than failing the request, since the change itself was stored;
+// the returned job ID is then 0.
+func (s *Server) enqueueInstall(r *http.Request, service *storage.Service, steps setupSteps) int64 {
+	jobID, err := s.enqueueSetup(r.Context(), service, steps)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed to queue service install", "service", service.Name, "error", err)
+	}
+	return jobID
+}
+
+// enqueueSetup queues an install (or, with dependencies, a provision) job for a service
+func (s *Server) enqueueSetup(ctx context.Context, service *storage.Service, steps setupSteps) (int64, error) {
+	kind := jobs.KindInstall
+	if steps.dependencies {
+		kind = jobs.KindProvision
+	}
+	job := storage.Job{Kind: kind, ProjectID: service.ProjectID, ServiceID: service.ID}
+
+	queued, err := s.jobs.Enqueue(ctx, job, func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+		return s.setupService(ctx, service, steps, logf)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return queued.ID, nil
+}
+
+// setupService runs the steps of a setup job; a dry run calls it directly with a plan in ctx
+func (s *Server) setupService(ctx context.Context, service *storage.Service, steps setupSteps, logf jobs.Logf) error {
+	// Cancelled while queued: writing the unit below does not heed ctx
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if steps.clone && service.GitRepoURL != "" && service.WorkingDir != "" {
+		logf("cloning %s into %s", service.GitRepoURL, service.WorkingDir)
+		if err := git.CloneRepository(ctx, service.GitRepoURL, service.WorkingDir, git.CloneOptions{Branch: service.GitBranch, Filter: service.GitFilter}); err != nil {
+			return err
+		}
+		if git.UsesLFS(service.WorkingDir) {
+			logf("fetched the Git LFS files of %s", service.WorkingDir)
+		}
+	}
+	if steps.dependencies {
+		bp, ok := s.blueprints.Get(service.Type)
+		if !ok {
+			return fmt.Errorf("no blueprint found for service type '%s'", service.Type)
+		}
+		logf("installing dependencies for %s %s", service.Type, service.Version)
+		if err := bp.InstallDependencies(blueprints.WithOutput(ctx, provisionOutput(logf)), service.Version); err != nil {
+			return err
+		}
+	}
+	// After the clone and packages: the clone is handed to the new user,
+	// and packages such as postgresql bring their own
+	if steps.createUser && hostuser.Needed(service.User) {
+		if err := s.createServiceUser(ctx, service, logf); err != nil {
+			return err
+		}
+	}
+	// After the user, who is given the directory
+	if _, err := os.Stat(service.WorkingDir); steps.createDir && service.WorkingDir != "" && errors.Is(err, os.ErrNotExist) {
+		logf("creating working directory %s", service.WorkingDir)
+		if err := hostuser.CreateDir(ctx, service.WorkingDir, service.User); err != nil {
+			return err
+		}
+	}
+
+	// Asked before the unit is rewritten, which may change how it stops
+	running := steps.graceful && s.svcManager.ActiveState(ctx, service.ServiceName()) == "active"
+
+	logf("installing unit %s", service.ServiceName())
+	if err := s.svcManager.InstallService(ctx, service); err != nil {
+		return err
+	}
+
+	switch {
+	case steps.start:
+		logf("enabling and starting %s", service.ServiceName())
+		s.svcManager.Enable(ctx, service.ServiceName())
+		return s.svcManager.Start(ctx, service.ServiceName())
+	case steps.restart:
+		logf("restarting %s", service.ServiceName())
+		return s.svcManager.Restart(ctx, service.ServiceName())
+	case running:
+		logf("stopping %s; it finishes its running jobs first", service.ServiceName())
+		if err := s.svcManager.Stop(ctx, service.ServiceName()); err != nil {
+			return err
+		}
+		logf("starting %s", service.ServiceName())
+		return s.svcManager.Start(ctx, service.ServiceName())
+	}
+	return nil
+}
+
+// provisionOutput logs what provisioning runs: each command after "$", and
+// the lines it prints after "|" from stdout and "!" from stderr
+func provisionOutput(logf jobs.Logf) blueprints.Output {
+	return func(stream, line string) {
+		switch stream {
+		case "command":
+			logf("$ %s", line)
+		case "stderr":
+			logf("! %s", line)
+		default:
+			logf("| %s", line)
+		}
+	}
+}
+
+// createServiceUser creates a service's user as a locked system account
+// owning its working directory. Agents do not provision accounts, so
+// projects on agent hosts need the user created there by hand.
+func (s *Server) createServiceUser(ctx context.Context, service *storage.Service, logf jobs.Logf) error {
+	project, err := s.store.GetProject(ctx, service.ProjectID)
+	if err != nil {
+		return err
+	}
+	if project != nil && project.HostID != 0 {
+		return fmt.Errorf("creating system users: %w", agent.ErrLocalOnly)
+	}
+	home := hostuser.HomeDir(service.User, service.WorkingDir)
+	logf("creating system user %s with home %s", service.User, home)
+	return hostuser.Create(ctx, service.User, home)
+}
+
+// setupServices installs a project's services in order for project-wide jobs,
+// cloning each working directory's repository once and starting the services
+// start reports
+func (s *Server) setupServices(ctx context.Context, services []*storage.Service, start func(i int) bool, logf jobs.Logf) error {
+	cloned := map[string]bool{}
+	for i, service := range services {
+		steps := setupSteps{start: start(i)}
+		if service.GitRepoURL != "" && !cloned[service.WorkingDir] {
+			steps.clone, cloned[service.WorkingDir] = true, true
+		}
+		if err := s.setupService(ctx, service, steps, logf); err != nil {
+			return fmt.Errorf("%s: %w", service.Name, err)
+		}
+	}
+	return nil
+}
+
+// jobURL links to a project page that follows the given job (none when jobID is 0)
+func jobURL(projectID, jobID int64) string {
+	if jobID == 0 {
+		return projectURL(projectID, nil)
+	}
+	return projectURL(projectID, url.Values{"job": {strconv.FormatInt(jobID, 10)}})
+}
+
+// followedJob returns the job named by ?job= when it belongs to the project
+func (s *Server) followedJob(r *http.Request, project *storage.Project) *storage.Job {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	job, err := s.store.GetJob(r.Context(), id)
+	if err != nil || job == nil || job.ProjectID != project.ID {
+		return nil
+	}
+	return job
+}
+
+// fixableError reports whether an install error looks like a missing
+// dependency that provisioning the service's blueprint would install
+func fixableError(msg string) bool {
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist")
+}
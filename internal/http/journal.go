@@ -0,0 +1,221 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+const (
+	// journalRetentionInterval is how often retention policies are enforced
+	journalRetentionInterval = time.Hour
+	// journalUsageTimeout bounds measuring every service's journal
+	journalUsageTimeout = 30 * time.Second
+)
+
+// handleAPIJournalUsage reports journal disk usage in total and per service
+// GET /api/system/journal
+func (s *Server) handleAPIJournalUsage(w http.ResponseWriter, r *http.Request) {
+	total, namespaces, err := systemd.JournalDiskUsage()
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	services, err := s.allServices(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	policies, err := s.store.ListJournalRetentions(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	byService := make(map[int64]*storage.JournalRetention, len(policies))
+	for _, p := range policies {
+		byService[p.ServiceID] = p
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), journalUsageTimeout)
+	defer cancel()
+	resp := journalUsageResponse{TotalBytes: total, SystemBytes: namespaces[""], Services: []serviceJournalUsage{}}
+	for _, sv := range services {
+		usage := serviceJournalUsage{ServiceID: sv.ID, Service: sv.Name, Unit: sv.ServiceName(), Retention: byService[sv.ID]}
+		if usage.Retention != nil {
+			usage.Namespace = systemd.JournalNamespace(sv.ServiceName())
+		}
+		if usage.Bytes, err = systemd.UnitJournalUsage(ctx, sv.ServiceName(), namespaces); err != nil {
+			usage.Error = err.Error()
+		}
+		resp.Services = append(resp.Services, usage)
+	}
+	jsonResponse(w, resp)
+}
+
+// handleAPIJournalVacuum deletes archived journal files beyond the given
+// limits, in the system journal or in one service's namespace
+// POST /api/system/journal/vacuum
+func (s *Server) handleAPIJournalVacuum(w http.ResponseWriter, r *http.Request) {
+	var req journalVacuumRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	retention := systemd.Retention{MaxSize: req.MaxSize, MaxAge: req.MaxAge}
+	if err := retention.Validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	namespace := ""
+	if req.ServiceID != 0 {
+		service, err := s.store.GetService(r.Context(), req.ServiceID)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if service == nil {
+			jsonError(w, r, "Service not found", http.StatusNotFound)
+			return
+		}
+		policy, err := s.store.GetJournalRetention(r.Context(), service.ID)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if policy == nil {
+			// Without a namespace the service's entries share files with every other unit
+			apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{
+				{Field: "service_id", Message: "has no retention policy, so its logs are in the system journal"},
+			}})
+			return
+		}
+		namespace = systemd.JournalNamespace(service.ServiceName())
+	}
+
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			_, err := systemd.VacuumJournal(ctx, namespace, retention)
+			return err
+		})
+		return
+	}
+	output, err := systemd.VacuumJournal(r.Context(), namespace, retention)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, journalVacuumResponse{Namespace: namespace, Output: output})
+}
+
+// handleAPIGetJournalRetention returns a service's retention policy
+// GET /api/services/{id}/journal-retention
+func (s *Server) handleAPIGetJournalRetention(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	policy, err := s.store.GetJournalRetention(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if policy == nil {
+		jsonError(w, r, "Service has no retention policy", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, policy)
+}
+
+// handleAPISetJournalRetention gives a service its own journal namespace with
+// the given limits. It takes effect when the service next restarts.
+// PUT /api/services/{id}/journal-retention
+func (s *Server) handleAPISetJournalRetention(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req journalRetentionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "journal retention"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := checkSystemd(service, "journal retention"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	retention := systemd.Retention{MaxSize: req.MaxSize, MaxAge: req.MaxAge}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return systemd.SetJournalRetention(ctx, service.ServiceName(), retention)
+		})
+		return
+	}
+	if err := systemd.SetJournalRetention(r.Context(), service.ServiceName(), retention); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	policy := &storage.JournalRetention{ServiceID: service.ID, MaxSize: req.MaxSize, MaxAge: req.MaxAge}
+	if err := s.store.SetJournalRetention(r.Context(), policy); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, policy)
+}
+
+// handleAPIDeleteJournalRetention returns a service's logs to the system journal
+// DELETE /api/services/{id}/journal-retention
+func (s *Server) handleAPIDeleteJournalRetention(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return systemd.RemoveJournalRetention(ctx, service.ServiceName())
+		})
+		return
+	}
+	if err := systemd.RemoveJournalRetention(r.Context(), service.ServiceName()); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.DeleteJournalRetention(r.Context(), service.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// enforceJournalRetention vacuums each service's namespace to its policy every
+// journalRetentionInterval. journald applies the same limits when it rotates;
+// this also trims journals that grew before the policy was set.
+func (s *Server) enforceJournalRetention(ctx context.Context) {
+	ticker := time.NewTicker(journalRetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.applyJournalRetention(ctx)
+		}
+	}
+}
+
+// applyJournalRetention runs one vacuum per retention policy
+func (s *Server) applyJournalRetention(ctx context.Context) {
+	policies, err := s.store.ListJournalRetentions(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list journal retention policies", "error", err)
+		return
+	}
+	for _, p := range policies {
+		service, err := s.store.GetService(ctx, p.ServiceID)
+		if err != nil || service == nil {
+			continue
+		}
+		namespace := systemd.JournalNamespace(service.ServiceName())
+		if _, err := systemd.VacuumJournal(ctx, namespace, systemd.Retention{MaxSize: p.MaxSize, MaxAge: p.MaxAge}); err != nil {
+			slog.WarnContext(ctx, "Failed to enforce journal retention", "service", service.Name, "error", err)
+		}
+	}
+}
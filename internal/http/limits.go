@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+)
+
+// routeClass sets how long a request may run and how large its body may be
+type routeClass struct {
+	name    string
+	timeout time.Duration // handler deadline; 0 leaves streams open until the client leaves
+	maxBody int64
+}
+
+var (
+	crudRoute   = routeClass{name: "crud", timeout: 15 * time.Second, maxBody: 1 << 20}
+	longRoute   = routeClass{name: "long", timeout: 5 * time.Minute, maxBody: 1 << 20}
+	streamRoute = routeClass{name: "stream", maxBody: 4 << 10}
+	execRoute   = routeClass{name: "exec", maxBody: 1 << 20}
+)
+
+// writeGrace is how long after the handler deadline the response may still be
+// written, so a handler that gives up can report the timeout
+const writeGrace = 5 * time.Second
+
+// streamRoutes are held open for as long as the client listens
+var streamRoutes = []string{
+	"/ws",
+	"/api/events",
+	"/api/services/*/logs/stream",
+	"/api/projects/*/logs/stream",
+	"/api/nginx/*/logs/*/stream",
+	"/api/jobs/*/stream",
+}
+
+// execRoutes stream a one-off command's output until it exits, which has its
+// own timeout, and take its stdin in the body
+var execRoutes = []string{
+	"/api/services/*/exec",
+}
+
+// longRoutes wait on systemctl or nginx, possibly for many units at once.
+// The UI patterns only match POSTs; GETs of those pages are ordinary.
+var longRoutes = []string{
+	"/api/projects/*/start",
+	"/api/projects/*/stop",
+	"/api/projects/*/restart",
+	"/api/services/actions",
+	"/api/services/*/start",
+	"/api/services/*/stop",
+	"/api/services/*/restart",
+	"/api/services/*/scale",
+	"/api/services/*/instances/*/restart",
+	"/api/nginx/*/deploy",
+	"/api/nginx/*/remove",
+	"/api/webhooks/*/test",
+	"/api/admin/integrity/repair",
+	"/api/system/reconcile",
+	"/api/system/updates/refresh",
+	"/api/system/reboot",
+	"/api/system/shutdown",
+	"/projects/*/delete",
+	"/services/*/*",
+}
+
+// classifyRoute picks the route class for a request by its path (patterns use path.Match)
+func classifyRoute(r *http.Request) routeClass {
+	if matchAny(streamRoutes, r.URL.Path) {
+		return streamRoute
+	}
+	if matchAny(execRoutes, r.URL.Path) {
+		return execRoute
+	}
+	if matchAny(longRoutes, r.URL.Path) && r.Method == http.MethodPost {
+		return longRoute
+	}
+	return crudRoute
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Limits is a middleware that applies the request's route class: it caps the
+// body size, gives the handler a context deadline, and moves the connection's
+// write deadline to match, replacing the server-wide WriteTimeout. Streams get
+// no deadline at all.
+func Limits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := classifyRoute(r)
+
+		if r.ContentLength > class.maxBody {
+			jsonError(w, r, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, class.maxBody)
+
+		rc := http.NewResponseController(w)
+		if class.timeout == 0 {
+			rc.SetWriteDeadline(time.Time{})
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(class.timeout + writeGrace))
+		ctx, cancel := context.WithTimeout(r.Context(), class.timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
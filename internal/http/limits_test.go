@@ -0,0 +1,30 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyRoute(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   routeClass
+	}{
+		{"GET", "/api/projects", crudRoute},
+		{"GET", "/api/events", streamRoute},
+		{"GET", "/api/services/1/logs/stream", streamRoute},
+		{"GET", "/api/projects/1/logs/stream", streamRoute},
+		{"GET", "/api/nginx/1/logs/access/stream", streamRoute},
+		{"GET", "/api/nginx/1/logs/access", crudRoute},
+		{"POST", "/api/services/1/restart", longRoute},
+		{"GET", "/api/services/1/restart", crudRoute},
+		{"POST", "/api/services/1/exec", execRoute},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := classifyRoute(r); got != tt.want {
+			t.Errorf("classifyRoute(%s %s) = %s, want %s", tt.method, tt.path, got.name, tt.want.name)
+		}
+	}
+}
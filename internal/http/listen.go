@@ -0,0 +1,93 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixPrefix marks a listen address as a unix domain socket path
+const unixPrefix = "unix:"
+
+// defaultSocketMode lets the owner and group (e.g. a local nginx) connect
+const defaultSocketMode os.FileMode = 0660
+
+// SetSocketPermissions sets the mode and owning group applied when listening
+// on a unix socket. An empty group keeps the process's group.
+func (s *Server) SetSocketPermissions(mode os.FileMode, group string) {
+	s.socketMode = mode
+	s.socketGroup = group
+}
+
+// listen opens the server's listener: a TCP address, or a unix socket for
+// addresses of the form unix:/path/to.sock
+func (s *Server) listen() (net.Listener, error) {
+	path, ok := strings.CutPrefix(s.addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", s.addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("missing socket path in %q", s.addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, s.socketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if s.socketGroup != "" {
+		if err := chownGroup(path, s.socketGroup); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes a socket left behind by a previous run. It refuses
+// to remove anything that is not a socket or that still accepts connections.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// chownGroup gives a group ownership of path
+func chownGroup(path, group string) error {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return fmt.Errorf("failed to look up socket group: %w", err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q for group %s", g.Gid, group)
+	}
+	if err := os.Chown(path, -1, gid); err != nil {
+		return fmt.Errorf("failed to set socket group: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"servio/internal/i18n"
+)
+
+// localeCookie remembers the language picked with ?lang=
+const localeCookie = "servio_lang"
+
+// localeCookieLifetime is how long a picked language is remembered
+const localeCookieLifetime = 365 * 24 * time.Hour
+
+// Localize picks the language of the response: ?lang= (which is remembered
+// in a cookie), then that cookie, then Accept-Language. It is put in the
+// request context, where templates and writeError read it, and announced
+// in Content-Language.
+func Localize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := i18n.Match(r.Header.Get("Accept-Language"))
+		if c, err := r.Cookie(localeCookie); err == nil {
+			if tag, ok := i18n.Supported(c.Value); ok {
+				locale = tag
+			}
+		}
+		if tag, ok := i18n.Supported(r.URL.Query().Get("lang")); ok {
+			locale = tag
+			http.SetCookie(w, &http.Cookie{
+				Name:     localeCookie,
+				Value:    tag,
+				Path:     basePath + "/",
+				MaxAge:   int(localeCookieLifetime.Seconds()),
+				Secure:   isSecure(r),
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		w.Header().Set("Content-Language", locale)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
+	})
+}
+
+// localeFuncs are the template functions bound to the request's language:
+// t translates a message (with fmt arguments), lang is the language tag,
+// and locales lists the languages for the picker
+func localeFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(message string, args ...interface{}) string {
+			return i18n.T(locale, message, args...)
+		},
+		"lang":    func() string { return locale },
+		"locales": i18n.Locales,
+	}
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+const (
+	// defaultLogAlertInterval is a log alert's interval, in seconds, when none is given
+	defaultLogAlertInterval = 60
+	// maxLogAlertInterval bounds a log alert's interval to a day
+	maxLogAlertInterval = 86400
+)
+
+// logAlertRequest is the body for creating or updating a log alert
+type logAlertRequest struct {
+	Name      string `json:"name"` // defaults to the pattern
+	Pattern   string `json:"pattern"`
+	Threshold int    `json:"threshold"`         // fire on more matches than this within the interval
+	Interval  int    `json:"interval"`          // seconds, default 60
+	Enabled   *bool  `json:"enabled,omitempty"` // defaults to true
+}
+
+// validate fills in defaults and checks the pattern, threshold, and interval
+func (req *logAlertRequest) validate() error {
+	if req.Interval == 0 {
+		req.Interval = defaultLogAlertInterval
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = req.Pattern
+	}
+
+	var fields []storage.FieldError
+	if req.Pattern == "" {
+		fields = append(fields, storage.FieldError{Field: "pattern", Message: "is required"})
+	} else if _, err := regexp.Compile(req.Pattern); err != nil {
+		fields = append(fields, storage.FieldError{Field: "pattern", Message: "is not a valid regular expression: " + err.Error()})
+	}
+	if req.Threshold < 0 {
+		fields = append(fields, storage.FieldError{Field: "threshold", Message: "must not be negative"})
+	}
+	if req.Interval < 1 || req.Interval > maxLogAlertInterval {
+		fields = append(fields, storage.FieldError{Field: "interval", Message: "must be between 1 and " + strconv.Itoa(maxLogAlertInterval) + " seconds"})
+	}
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// apply copies the request onto an alert
+func (req *logAlertRequest) apply(alert *storage.LogAlert) {
+	alert.Name = req.Name
+	alert.Pattern = req.Pattern
+	alert.Threshold = req.Threshold
+	alert.Interval = req.Interval
+	if req.Enabled != nil {
+		alert.Enabled = *req.Enabled
+	}
+}
+
+// handleAPIListLogAlerts lists a service's log alerts
+// GET /api/services/{id}/log-alerts
+func (s *Server) handleAPIListLogAlerts(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	alerts, err := s.store.ListLogAlerts(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, alerts)
+}
+
+// handleAPICreateLogAlert adds a log alert to a service
+// POST /api/services/{id}/log-alerts {"name","pattern","threshold","interval","enabled"}
+func (s *Server) handleAPICreateLogAlert(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req logAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	alert := &storage.LogAlert{ServiceID: service.ID, Enabled: true}
+	req.apply(alert)
+	if err := s.store.CreateLogAlert(r.Context(), alert); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.logAlerts.Reconfigure()
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, alert)
+}
+
+// handleAPIUpdateLogAlert replaces a log alert's settings. Its recent
+// matches are forgotten.
+// PUT /api/services/{id}/log-alerts/{alert} {"name","pattern","threshold","interval","enabled"}
+func (s *Server) handleAPIUpdateLogAlert(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	alert, ok := s.loadLogAlert(w, r, service)
+	if !ok {
+		return
+	}
+
+	var req logAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	req.apply(alert)
+	if err := s.store.UpdateLogAlert(r.Context(), alert); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.logAlerts.Reconfigure()
+	jsonResponse(w, alert)
+}
+
+// handleAPIDeleteLogAlert deletes a log alert; its incidents are kept
+// DELETE /api/services/{id}/log-alerts/{alert}
+func (s *Server) handleAPIDeleteLogAlert(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	alert, ok := s.loadLogAlert(w, r, service)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteLogAlert(r.Context(), alert.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.logAlerts.Reconfigure()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIListLogIncidents lists the times a service's log alerts fired, newest first
+// GET /api/services/{id}/log-incidents?limit=50
+func (s *Server) handleAPIListLogIncidents(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	incidents, err := s.store.ListLogIncidents(r.Context(), service.ID, limit)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, incidents)
+}
+
+// loadLogAlert reads the {alert} log alert of the service. It writes the error response itself.
+func (s *Server) loadLogAlert(w http.ResponseWriter, r *http.Request, service *storage.Service) (*storage.LogAlert, bool) {
+	id, err := pathID(r, "alert")
+	if err != nil {
+		jsonError(w, r, "Invalid log alert ID", http.StatusBadRequest)
+		return nil, false
+	}
+	alert, err := s.store.GetLogAlert(r.Context(), id)
+	if err != nil || alert == nil || alert.ServiceID != service.ID {
+		jsonError(w, r, "Log alert not found", http.StatusNotFound)
+		return nil, false
+	}
+	return alert, true
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// handleAPIGetFileLogging returns a service's file logging settings
+// GET /api/services/{id}/file-logging
+func (s *Server) handleAPIGetFileLogging(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	f, err := s.store.GetFileLogging(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if f == nil {
+		jsonError(w, r, "Service logs to the journal", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, f)
+}
+
+// handleAPISetFileLogging sends a service's output to a rotated log file. It
+// takes effect when the service next restarts.
+// PUT /api/services/{id}/file-logging
+func (s *Server) handleAPISetFileLogging(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req fileLoggingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "file logging"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := checkSystemd(service, "file logging"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	rotation := systemd.LogRotation{Rotate: req.Rotate, Frequency: req.Frequency, MaxSize: req.MaxSize}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return systemd.SetFileLogging(ctx, service.ServiceName(), rotation)
+		})
+		return
+	}
+	if err := systemd.SetFileLogging(r.Context(), service.ServiceName(), rotation); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	rotation = rotation.WithDefaults()
+	f := &storage.FileLogging{
+		ServiceID: service.ID,
+		Path:      systemd.LogFilePath(service.ServiceName()),
+		Rotate:    rotation.Rotate,
+		Frequency: rotation.Frequency,
+		MaxSize:   rotation.MaxSize,
+	}
+	if err := s.store.SetFileLogging(r.Context(), f); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, f)
+}
+
+// handleAPIDeleteFileLogging returns a service's output to the journal
+// DELETE /api/services/{id}/file-logging
+func (s *Server) handleAPIDeleteFileLogging(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return systemd.RemoveFileLogging(ctx, service.ServiceName())
+		})
+		return
+	}
+	if err := systemd.RemoveFileLogging(r.Context(), service.ServiceName()); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.DeleteFileLogging(r.Context(), service.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
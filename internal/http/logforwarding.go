@@ -0,0 +1,65 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"servio/internal/storage"
+)
+
+// handleAPILogForwardingStatus reports the log shipper's sink and counters
+// GET /api/system/log-forwarding
+func (s *Server) handleAPILogForwardingStatus(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, s.logShipper.Status())
+}
+
+// handleAPIGetLogForwarding reports whether a service's journal is forwarded
+// GET /api/services/{id}/log-forwarding
+func (s *Server) handleAPIGetLogForwarding(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	f, err := s.store.GetLogForwarding(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, newLogForwardingResponse(f))
+}
+
+// handleAPISetLogForwarding turns forwarding of a service's journal on or off.
+// Entries logged while it is on are shipped from the moment it was turned on.
+// PUT /api/services/{id}/log-forwarding
+func (s *Server) handleAPISetLogForwarding(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req logForwardingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var f *storage.LogForwarding
+	var err error
+	if req.Enabled {
+		if err := s.checkServiceLocal(r.Context(), service, "log forwarding"); err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if err := checkSystemd(service, "log forwarding"); err != nil {
+			apiError(w, r, err)
+			return
+		}
+		f, err = s.store.EnableLogForwarding(r.Context(), service.ID)
+	} else {
+		err = s.store.DisableLogForwarding(r.Context(), service.ID)
+	}
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	s.logShipper.Reconfigure()
+	jsonResponse(w, newLogForwardingResponse(f))
+}
+
+func newLogForwardingResponse(f *storage.LogForwarding) logForwardingResponse {
+	if f == nil {
+		return logForwardingResponse{}
+	}
+	return logForwardingResponse{Enabled: true, Cursor: f.Cursor, EnabledAt: &f.EnabledAt}
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownRenderer converts runbook notes to HTML. Raw HTML in the source is
+// not rendered and dangerous link schemes are dropped (goldmark's safe default).
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// templateFuncs are available to every page template
+var templateFuncs = template.FuncMap{
+	"markdown": renderMarkdown,
+	"base":     func() string { return basePath }, // prefix for absolute links, see basepath.go
+	// fieldError is the message a form shows beside an input, from formFieldErrors
+	"fieldError": func(fields map[string]string, field string) string { return fields[field] },
+}
+
+// renderMarkdown renders markdown to HTML for display in templates
+func renderMarkdown(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(source), &buf); err != nil {
+		slog.Warn("Failed to render markdown", "error", err)
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(buf.String())
+}
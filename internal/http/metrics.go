@@ -0,0 +1,81 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"time"
+
+	"servio/internal/monitor"
+	"servio/internal/storage"
+)
+
+const (
+	// metricsInterval is how often host and service usage is sampled for history
+	metricsInterval = time.Minute
+	// metricsRetention is how long samples are kept before they are pruned
+	metricsRetention = 30 * 24 * time.Hour
+)
+
+// recordMetrics samples host and service resource usage every metricsInterval
+// so it can be exported for a time range later
+func (s *Server) recordMetrics(ctx context.Context) {
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sampleMetrics(ctx)
+		}
+	}
+}
+
+// sampleMetrics records one host sample and one per service, stores the
+// metrics services pushed since the last sample, and drops samples older
+// than metricsRetention
+func (s *Server) sampleMetrics(ctx context.Context) {
+	services, err := s.allServices(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list services for metrics", "error", err)
+	}
+	stats := s.localStats(ctx, services)
+	// Services on agent hosts are sampled from the host's last reading
+	for _, h := range s.hosts.Stats(ctx) {
+		if h.Stats == nil || len(h.Stats.Services) == 0 {
+			continue
+		}
+		if stats.Services == nil {
+			stats.Services = map[string]monitor.ServiceStat{}
+		}
+		maps.Copy(stats.Services, h.Stats.Services)
+	}
+	now := time.Now()
+	samples := []*storage.MetricSample{{
+		CPUPercent:    stats.CPUUsage,
+		MemoryPercent: stats.MemoryUsage,
+		MemoryMB:      stats.MemoryUsed * 1024,
+		DiskPercent:   stats.DiskUsage,
+		TakenAt:       now,
+	}}
+	for _, sv := range services {
+		if st, ok := stats.Services[sv.ServiceName()]; ok {
+			samples = append(samples, &storage.MetricSample{
+				ServiceID:  sv.ID,
+				Service:    sv.Name,
+				CPUPercent: st.CPUUsage,
+				MemoryMB:   st.MemoryUsage,
+				TakenAt:    now,
+			})
+		}
+	}
+
+	if err := s.store.RecordMetricSamples(ctx, samples); err != nil {
+		slog.WarnContext(ctx, "Failed to record metrics", "error", err)
+	}
+	if _, err := s.store.PruneMetricSamples(ctx, now.Add(-metricsRetention)); err != nil {
+		slog.WarnContext(ctx, "Failed to prune metrics", "error", err)
+	}
+	s.recordAppMetrics(ctx, services, now)
+}
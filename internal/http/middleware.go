@@ -1,51 +1,168 @@
 package http
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
 	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
-	"os"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"servio/internal/logging"
+	"servio/internal/storage"
 )
 
-// BasicAuth is a middleware that requires HTTP basic authentication
-func BasicAuth(next http.Handler) http.Handler {
-	username := os.Getenv("SERVIO_USERNAME")
-	password := os.Getenv("SERVIO_PASSWORD")
+// authSettings are the credentials and admins checked by BasicAuth and
+// TeamScope. They are replaced as a whole, so a config reload never exposes
+// a half-applied set to in-flight requests.
+type authSettings struct {
+	username   string
+	password   string
+	admins     map[string]bool // users besides username who are not limited by team
+	agentToken string          // token agents join with; "" refuses them
+	sso        *ssoSettings    // sign-in through an identity provider; nil when off
+}
+
+// SetCredentials replaces the basic auth username and password. It is safe
+// to call while the server is running.
+func (s *Server) SetCredentials(username, password string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
 
 	if username == "" || password == "" {
 		slog.Warn("Basic Auth credentials not set")
 	}
+	auth := *s.auth.Load()
+	auth.username, auth.password = username, password
+	s.auth.Store(&auth)
+}
 
+// BasicAuth is a middleware that requires HTTP basic authentication. Requests
+// authenticated by a verified client certificate (mutual TLS) or carrying an
+// SSO session skip the password, and with SSO on, browsers asking for a page
+// are sent to the identity provider instead of being challenged.
+func (s *Server) BasicAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user, ok := clientCertUser(r); ok && !strings.HasPrefix(user, ssoActorPrefix) {
+			logUser(r, user)
+			next.ServeHTTP(w, r.WithContext(storage.WithActor(r.Context(), user)))
+			return
+		}
+
 		user, pass, ok := r.BasicAuth()
+		auth := s.auth.Load()
 
-		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
-			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+		if !ok && auth.sso != nil {
+			if sess, valid := s.sessionUser(r); valid {
+				logUser(r, sess.User)
+				ctx := context.WithValue(storage.WithActor(r.Context(), sess.User), ssoUserKey{}, sess.Admin)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != ssoBasicPath {
+				http.Redirect(w, r, basePath+ssoLoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(auth.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(auth.password)) != 1 {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Servio"`)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				jsonError(w, r, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// Attribute any changes made by this request to the authenticated user
+		logUser(r, user)
+		next.ServeHTTP(w, r.WithContext(storage.WithActor(r.Context(), user)))
 	})
 }
 
-// Logger is a middleware that logs HTTP requests
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern limits which client-supplied IDs are trusted, so proxies can
+// pass theirs through without letting clients inject arbitrary log content
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID is a middleware that assigns every request an ID, reusing a valid
+// X-Request-ID from the client, and echoes it in the response headers
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// logUserKey holds where Logger reads the user BasicAuth accepted a request
+// from, as Logger runs outside BasicAuth so rejected requests are logged too
+type logUserKey struct{}
+
+// logUser reports the user a request was authenticated as to Logger
+func logUser(r *http.Request, user string) {
+	if slot, ok := r.Context().Value(logUserKey{}).(*string); ok {
+		*slot = user
+	}
+}
+
+// Logger is a middleware that writes a structured access log line per
+// request, with the basic auth, client certificate, or SSO user it was
+// accepted from
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Create response wrapper to capture status code
+		// Create response wrapper to capture status code and size
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
-		next.ServeHTTP(wrapped, r)
+		user := new(string)
+		next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), logUserKey{}, user)))
 
-		slog.Info("Request handled",
+		// Probes hit the health endpoints every few seconds; keep them out of the default log level
+		level := slog.LevelInfo
+		if publicPaths[r.URL.Path] {
+			level = slog.LevelDebug
+		}
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "Request handled",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", wrapped.statusCode,
+			"bytes", wrapped.bytes,
 			"duration", time.Since(start),
+			"user", *user,
 			"remote_addr", r.RemoteAddr,
 		)
 	})
@@ -55,8 +172,9 @@ func Logger(next http.Handler) http.Handler {
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, X-Dry-Run")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Limit, X-Offset, X-Request-ID")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -67,10 +185,127 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
+// gzipWriters reuses compressors across responses
+var gzipWriters = sync.Pool{New: func() interface{} {
+	gz, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+	return gz
+}}
+
+// gzipMinSize is the smallest Content-Length worth compressing when the handler declares one
+const gzipMinSize = 1024
+
+// Gzip is a middleware that compresses HTML, JSON, and other text responses
+// for clients that accept gzip. Event streams, WebSocket upgrades, and
+// responses that already set Content-Encoding (static assets) pass through.
+func Gzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter decides whether to compress when the header is written,
+// once the handler has set Content-Type
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	if g.compressible(code) {
+		h := g.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) compressible(code int) bool {
+	h := g.Header()
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < gzipMinSize {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/javascript",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Close flushes any compressed output and returns the compressor to the pool
+func (g *gzipResponseWriter) Close() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Close()
+	gzipWriters.Put(g.gz)
+	g.gz = nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Flush sends buffered compressed data to the client
+func (g *gzipResponseWriter) Flush() {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// responseWriter wraps http.ResponseWriter to capture status code and body size
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -78,9 +313,30 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Flush implements the http.Flusher interface to allow streaming
 func (rw *responseWriter) Flush() {
 	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
 	}
 }
+
+// Hijack implements the http.Hijacker interface so connections can be upgraded to WebSocket
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
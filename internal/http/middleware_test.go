@@ -0,0 +1,56 @@
+package http
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggerUser(t *testing.T) {
+	s := &Server{}
+	s.auth.Store(&authSettings{username: "admin", password: "secret"})
+	handler := Logger(s.BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	tests := []struct {
+		name     string
+		password string
+		cert     string // common name of a verified client certificate
+		want     string
+	}{
+		{"accepted", "secret", "", "admin"},
+		{"rejected", "wrong", "", ""},
+		{"client certificate", "", "deploy-bot", "deploy-bot"},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/api/projects", nil)
+		if tt.password != "" {
+			r.SetBasicAuth("admin", tt.password)
+		}
+		if tt.cert != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.cert}}
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		var line struct {
+			User string `json:"user"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%s: access log %q: %v", tt.name, buf.String(), err)
+		}
+		if line.User != tt.want {
+			t.Errorf("%s: logged user %q, want %q", tt.name, line.User, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"servio/internal/logparse"
+	"servio/internal/nginx"
+	"servio/internal/storage"
+	"servio/internal/tail"
+)
+
+// readNginxLog returns the last lines of a project's nginx access or error
+// log that contain query, ignoring case. truncated reports whether the file
+// holds older lines than were read.
+func readNginxLog(ctx context.Context, project *storage.Project, kind string, lines int, query string) (path string, matched []string, truncated bool, err error) {
+	if err := checkLocal(project, "nginx logs"); err != nil {
+		return "", nil, false, err
+	}
+	if path, err = nginx.LogPath(project, kind); err != nil {
+		return "", nil, false, err
+	}
+	// One line more than asked for shows whether there are older ones
+	output, err := tail.Read(ctx, path, lines+1)
+	if err != nil {
+		return "", nil, false, err
+	}
+	all := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if output == "" {
+		all = nil
+	}
+	if len(all) > lines {
+		all, truncated = all[len(all)-lines:], true
+	}
+	matched = []string{}
+	for _, line := range all {
+		if containsFold(line, query) {
+			matched = append(matched, line)
+		}
+	}
+	return path, matched, truncated, nil
+}
+
+// containsFold reports whether s contains substr, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// handleAPINginxLogs returns the last lines of a project's nginx access or
+// error log, keeping those that contain q
+// GET /api/nginx/{id}/logs/{kind}?lines=&q=
+func (s *Server) handleAPINginxLogs(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	lines, err := parseLogLines(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path, matched, truncated, err := readNginxLog(r.Context(), project, r.PathValue("kind"), lines, r.URL.Query().Get("q"))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	var logs strings.Builder
+	for _, line := range matched {
+		logs.WriteString(line + "\n")
+	}
+	jsonResponse(w, nginxLogsResponse{Path: path, Logs: logs.String(), Truncated: truncated})
+}
+
+// handleAPINginxLogStream follows a project's nginx access or error log over
+// SSE, keeping lines that contain q. Log files have no cursors, so a
+// reconnecting client continues with new lines.
+// GET /api/nginx/{id}/logs/{kind}/stream?q=
+func (s *Server) handleAPINginxLogStream(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if err := checkLocal(project, "nginx logs"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	path, err := nginx.LogPath(project, r.PathValue("kind"))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, r, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	startSSE(w, flusher)
+	lines, err := tail.Follow(ctx, path)
+	if err != nil {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+		flusher.Flush()
+		return
+	}
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ssePing(w, flusher)
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if containsFold(line, query) {
+				fmt.Fprintf(w, "data: %s\n\n", line)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// handleNginxLogs renders a project's nginx access or error log into the
+// logs modal's panel; outside htmx it redirects to the project
+// GET /projects/{id}/nginx-logs/{kind}?q=
+func (s *Server) handleNginxLogs(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, projectURL(project.ID, nil), http.StatusSeeOther)
+		return
+	}
+
+	kind := r.PathValue("kind")
+	query := r.URL.Query().Get("q")
+	data := map[string]interface{}{"Filtered": query != ""}
+	_, matched, truncated, err := readNginxLog(r.Context(), project, kind, defaultLogLines, query)
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+	rows := make([]logRow, len(matched))
+	for i, line := range matched {
+		rows[i] = logRow{Line: logparse.Line{Raw: line, Level: nginx.LineLevel(kind, line)}, HTML: template.HTML(template.HTMLEscapeString(line))}
+	}
+	data["Lines"] = rows
+	if truncated {
+		data["Truncated"] = defaultLogLines
+	}
+	renderPartial(w, r, "project_detail.html", "log-panel", data)
+}
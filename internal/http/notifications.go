@@ -0,0 +1,246 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"servio/internal/events"
+	"servio/internal/notify"
+	"servio/internal/storage"
+)
+
+// notificationChannelRequest is the body for creating or updating a notification channel
+type notificationChannelRequest struct {
+	Name         string   `json:"name"`
+	Kind         string   `json:"kind"`                // slack, discord, telegram, or webhook
+	URL          string   `json:"url,omitempty"`       // incoming webhook URL for slack, discord, and webhook; kept on update when empty
+	BotToken     string   `json:"bot_token,omitempty"` // telegram; kept on update when empty
+	ChatID       string   `json:"chat_id,omitempty"`   // telegram chat ID or @channel
+	Events       []string `json:"events"`              // deploy.finished, service.crashed, and log.alert when empty
+	ProjectID    int64    `json:"project_id,omitempty"`
+	FailuresOnly bool     `json:"failures_only"`
+	Template     string   `json:"template,omitempty"`
+	Enabled      *bool    `json:"enabled,omitempty"` // defaults to true
+}
+
+// validate checks the fields, requiring the credentials a new channel of
+// its kind needs
+func (req *notificationChannelRequest) validate(creating bool) error {
+	var fields []storage.FieldError
+	check := func(ok bool, field, msg string) {
+		if !ok {
+			fields = append(fields, storage.FieldError{Field: field, Message: msg})
+		}
+	}
+	check(strings.TrimSpace(req.Name) != "", "name", "is required")
+	check(slices.Contains(storage.NotifyKinds, req.Kind), "kind", "must be one of "+strings.Join(storage.NotifyKinds, ", "))
+	if req.Kind == storage.NotifyTelegram {
+		check(req.BotToken != "" || !creating, "bot_token", "is required for telegram")
+		check(req.ChatID != "", "chat_id", "is required for telegram")
+		check(!strings.ContainsAny(req.BotToken, "/?#"), "bot_token", "is not a bot token")
+	} else if req.URL != "" || creating {
+		u, err := url.Parse(req.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	}
+	for _, e := range req.Events {
+		check(slices.Contains(events.Types, e), "events", "unknown event "+strconv.Quote(e))
+	}
+	if req.Template != "" {
+		if err := notify.CheckTemplate(req.Template); err != nil {
+			check(false, "template", err.Error())
+		}
+	}
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// applyNotificationChannel copies the request onto a channel, encrypting
+// new credentials if any are set
+func (s *Server) applyNotificationChannel(r *http.Request, req *notificationChannelRequest, channel *storage.NotificationChannel) error {
+	if req.ProjectID != 0 {
+		project, err := s.store.GetProject(r.Context(), req.ProjectID)
+		if err != nil {
+			return err
+		}
+		if project == nil {
+			return &storage.ValidationError{Fields: []storage.FieldError{{Field: "project_id", Message: "project not found"}}}
+		}
+	}
+	if channel.Kind != req.Kind && channel.ID != 0 && req.URL == "" && req.BotToken == "" {
+		return &storage.ValidationError{Fields: []storage.FieldError{{Field: "kind", Message: "changing the kind needs new credentials"}}}
+	}
+
+	channel.Name = strings.TrimSpace(req.Name)
+	channel.Kind = req.Kind
+	channel.Events = req.Events
+	if len(channel.Events) == 0 {
+		channel.Events = notify.DefaultEvents
+	}
+	channel.ProjectID = req.ProjectID
+	channel.FailuresOnly = req.FailuresOnly
+	channel.Template = req.Template
+	if req.Enabled != nil {
+		channel.Enabled = *req.Enabled
+	}
+
+	channel.ChatID = ""
+	credential := req.URL
+	if req.Kind == storage.NotifyTelegram {
+		channel.ChatID = req.ChatID
+		channel.Target = "chat " + req.ChatID
+		credential = req.BotToken
+	} else if req.URL != "" {
+		u, _ := url.Parse(req.URL)
+		channel.Target = u.Host
+	}
+	if credential != "" {
+		ciphertext, err := s.cipher.Encrypt(credential)
+		if err != nil {
+			return err
+		}
+		channel.Ciphertext = ciphertext
+	}
+	return nil
+}
+
+// handleAPIListNotificationChannels lists notification channels (URLs and tokens are never returned)
+// GET /api/notification-channels
+func (s *Server) handleAPIListNotificationChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := s.store.ListNotificationChannels(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, channels)
+}
+
+// handleAPICreateNotificationChannel creates a notification channel
+// POST /api/notification-channels {"name","kind","url","bot_token","chat_id","events","project_id","failures_only","template","enabled"}
+func (s *Server) handleAPICreateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	var req notificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(true); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	channel := &storage.NotificationChannel{Enabled: true}
+	if err := s.applyNotificationChannel(r, &req, channel); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.CreateNotificationChannel(r.Context(), channel); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, channel)
+}
+
+// handleAPIGetNotificationChannel returns a notification channel
+// GET /api/notification-channels/{id}
+func (s *Server) handleAPIGetNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	if channel, ok := s.loadNotificationChannel(w, r); ok {
+		jsonResponse(w, channel)
+	}
+}
+
+// handleAPIUpdateNotificationChannel replaces a notification channel's
+// settings, and its URL or bot token when one is given
+// PUT /api/notification-channels/{id} {"name","kind","url","bot_token","chat_id","events","project_id","failures_only","template","enabled"}
+func (s *Server) handleAPIUpdateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	channel, ok := s.loadNotificationChannel(w, r)
+	if !ok {
+		return
+	}
+
+	var req notificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(false); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.applyNotificationChannel(r, &req, channel); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.UpdateNotificationChannel(r.Context(), channel); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, channel)
+}
+
+// handleAPIDeleteNotificationChannel deletes a notification channel and its delivery history
+// DELETE /api/notification-channels/{id}
+func (s *Server) handleAPIDeleteNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	channel, ok := s.loadNotificationChannel(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteNotificationChannel(r.Context(), channel.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIListNotificationDeliveries lists a channel's recent deliveries, newest first
+// GET /api/notification-channels/{id}/deliveries?limit=50
+func (s *Server) handleAPIListNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	channel, ok := s.loadNotificationChannel(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	deliveries, err := s.store.ListNotificationDeliveries(r.Context(), channel.ID, limit)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, deliveries)
+}
+
+// handleAPITestNotificationChannel sends a test message with a single attempt and returns the delivery
+// POST /api/notification-channels/{id}/test
+func (s *Server) handleAPITestNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	channel, ok := s.loadNotificationChannel(w, r)
+	if !ok {
+		return
+	}
+	delivery, err := s.notifier.Test(r.Context(), channel)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, delivery)
+}
+
+// loadNotificationChannel reads the {id} channel. It writes the error response itself.
+func (s *Server) loadNotificationChannel(w http.ResponseWriter, r *http.Request) (*storage.NotificationChannel, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid notification channel ID", http.StatusBadRequest)
+		return nil, false
+	}
+	channel, err := s.store.GetNotificationChannel(r.Context(), id)
+	if err != nil || channel == nil {
+		jsonError(w, r, "Notification channel not found", http.StatusNotFound)
+		return nil, false
+	}
+	return channel, true
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servio/internal/ansi"
+	"servio/internal/logparse"
+	"servio/internal/storage"
+)
+
+// pageAlerts is the data for the "page-alerts" block of the project page.
+// OOB marks it for an htmx out-of-band swap alongside another partial.
+type pageAlerts struct {
+	Error      string
+	FixService int64
+	Job        *storage.Job
+	OOB        bool
+}
+
+// logRow is a line of the log panel. JSON lines render from their fields;
+// others render HTML with their colors.
+type logRow struct {
+	logparse.Line
+	HTML template.HTML
+}
+
+// jobAlerts reports a followed job: a notice while it runs, or its error once it fails
+func jobAlerts(job *storage.Job) pageAlerts {
+	alerts := pageAlerts{Job: job}
+	if job != nil && job.Status == storage.JobFailed {
+		alerts.Error = fmt.Sprintf("%s job #%d failed: %s", job.Kind, job.ID, job.Error)
+		if fixableError(job.Error) {
+			alerts.FixService = job.ServiceID
+		}
+	}
+	return alerts
+}
+
+// cardLogLines is how many recent log lines a service card counts errors in
+const cardLogLines = 1000
+
+// prepareServiceCard fills in the fields the service card displays but does
+// not store. Status is asked of systemd unless the caller already set it.
+func (s *Server) prepareServiceCard(ctx context.Context, service *storage.Service) {
+	if service.Status == "" {
+		service.Status = s.serviceStatus(ctx, service)
+	}
+	if service.Status != "not installed" {
+		if lines, _, err := s.serviceLogs(ctx, service, time.Time{}, cardLogLines); err == nil {
+			service.ErrorCount = countLevels(lines).Error
+		}
+	}
+
+	// Generate default systemd config for display if raw is empty
+	if service.SystemdRaw == "" {
+		if generated, err := s.svcManager.GenerateServiceFile(service); err == nil {
+			service.SystemdRaw = generated
+		}
+	}
+}
+
+// renderServiceCard answers an htmx request with a freshly rendered service
+// card, followed by the page alerts (swapped out of band) unless alerts is nil.
+// A nil service (one that was just deleted) renders no card, so it is removed.
+func (s *Server) renderServiceCard(w http.ResponseWriter, r *http.Request, service *storage.Service, alerts *pageAlerts) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if service != nil {
+		s.prepareServiceCard(r.Context(), service)
+		renderPartial(w, r, "project_detail.html", "service-card", service)
+	}
+	if alerts != nil {
+		alerts.OOB = true
+		renderPartial(w, r, "project_detail.html", "page-alerts", alerts)
+	}
+}
+
+// handleServiceLogs renders the log panel shown in the project page's logs
+// modal; outside htmx it redirects to the project
+// GET /services/{id}/logs
+func (s *Server) handleServiceLogs(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, projectURL(service.ProjectID, nil), http.StatusSeeOther)
+		return
+	}
+
+	data := map[string]interface{}{}
+	filters, err := parseLogFilters(r)
+	if err != nil {
+		data["Error"] = err.Error()
+		renderPartial(w, r, "project_detail.html", "log-panel", data)
+		return
+	}
+	lines, truncated, err := s.serviceLogs(r.Context(), service, time.Time{}, defaultLogLines)
+	if err != nil {
+		data["Error"] = err.Error()
+	}
+
+	// Colors become ansi-* spans; the text is escaped along the way
+	var rows []logRow
+	for _, l := range filterLines(lines, filters) {
+		row := logRow{Line: l}
+		if l.Fields != nil {
+			row.Message = ansi.Strip(l.Message)
+		} else {
+			row.HTML = template.HTML(ansi.HTML(l.Raw))
+		}
+		rows = append(rows, row)
+	}
+	data["Lines"] = rows
+	data["Filtered"] = len(filters) > 0
+	if truncated {
+		data["Truncated"] = defaultLogLines
+	}
+	renderPartial(w, r, "project_detail.html", "log-panel", data)
+}
+
+// followedServiceJob returns the job named by ?job= when it belongs to the service
+func (s *Server) followedServiceJob(r *http.Request, service *storage.Service) *storage.Job {
+	id, err := strconv.ParseInt(r.URL.Query().Get("job"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	job, err := s.store.GetJob(r.Context(), id)
+	if err != nil || job == nil || job.ServiceID != service.ID {
+		return nil
+	}
+	return job
+}
@@ -0,0 +1,361 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+
+	"servio/internal/dryrun"
+	"servio/internal/events"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// Changes a plan makes to a file
+const (
+	planCreate  = "create"
+	planUpdate  = "update"
+	planDelete  = "delete"
+	planSymlink = "symlink"
+	planMkdir   = "mkdir"
+)
+
+// Parts of a project a plan file belongs to besides its services, which are
+// named by their unit
+const (
+	planPartSite  = "nginx"
+	planPartSlice = "budget"
+)
+
+// planFile is a file a project's plan would write, link, or delete
+type planFile struct {
+	Path   string `json:"path"`
+	Change string `json:"change"` // create, update, delete, symlink, or mkdir
+	Mode   string `json:"mode,omitempty"`
+	Target string `json:"target,omitempty"` // what a symlink points to
+	// Part is the service unit the file is written for, nginx for the
+	// project's site, or budget for its slice
+	Part string `json:"part"`
+	// Diff is a unified diff against the file on disk; files holding
+	// secrets have none
+	Diff      string `json:"diff,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+
+	sum string // hashes what the file will hold, for the fingerprint
+}
+
+// projectPlanResponse lists what applying a project's configuration to the
+// host would change
+type projectPlanResponse struct {
+	ProjectID int64      `json:"project_id"`
+	Files     []planFile `json:"files"`
+	Unchanged int        `json:"unchanged"` // files that already match
+	Commands  []string   `json:"commands"`  // run when applying, such as systemctl daemon-reload
+	// Fingerprint identifies this plan; passing it to apply refuses to
+	// apply a plan that has changed since
+	Fingerprint string `json:"fingerprint"`
+
+	paths []string // of every planned file, changed or not
+}
+
+// applyPlanRequest is the body for applying a project's plan
+type applyPlanRequest struct {
+	Fingerprint string `json:"fingerprint"` // optional; from the reviewed plan
+	Restart     bool   `json:"restart"`     // restart running services whose files changed
+}
+
+// applyPlanResponse is the plan that was applied and the services restarted
+type applyPlanResponse struct {
+	*projectPlanResponse
+	Restarted []string `json:"restarted"`
+}
+
+// handleAPIProjectPlan shows every file applying the project's
+// configuration would change, as diffs against the host
+// GET /api/projects/{id}/plan
+func (s *Server) handleAPIProjectPlan(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if err := checkLocal(project, "change plans"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	plan, err := s.projectPlan(r.Context(), project)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, plan)
+}
+
+// handleAPIApplyProjectPlan writes the files of the project's plan. With a
+// fingerprint, a plan that no longer matches it is refused rather than
+// applied unreviewed.
+// POST /api/projects/{id}/plan/apply {"fingerprint","restart"}
+func (s *Server) handleAPIApplyProjectPlan(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req applyPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := checkLocal(project, "change plans"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	plan, err := s.projectPlan(r.Context(), project)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if req.Fingerprint != "" && req.Fingerprint != plan.Fingerprint {
+		jsonError(w, r, "The plan has changed since it was reviewed; review it again", http.StatusConflict)
+		return
+	}
+
+	restarted, err := s.applyProjectPlan(r.Context(), project, plan, req.Restart)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Applied project plan", "project", project.Name, "files", len(plan.Files), "restarted", len(restarted))
+	jsonResponse(w, applyPlanResponse{projectPlanResponse: plan, Restarted: restarted})
+}
+
+// projectPlan dry-runs installing each of the project's services with its
+// .env file, its budget's slice, and its nginx site, and compares the files
+// they would write with the ones on disk
+func (s *Server) projectPlan(ctx context.Context, project *storage.Project) (*projectPlanResponse, error) {
+	resp := &projectPlanResponse{ProjectID: project.ID, Files: []planFile{}, Commands: []string{}}
+	byPath := make(map[string]int) // path → index in files; later writes replace earlier ones
+	var files []planFile
+
+	add := func(part string, actions []dryrun.Action, service *storage.Service, envPath string) error {
+		for _, a := range actions {
+			if a.Type == dryrun.ActionRun {
+				if !slices.Contains(resp.Commands, a.Command) {
+					resp.Commands = append(resp.Commands, a.Command)
+				}
+				continue
+			}
+			file, err := s.planFile(ctx, a, part, service, envPath)
+			if err != nil {
+				return err
+			}
+			if i, ok := byPath[a.Path]; ok {
+				files[i] = file
+			} else {
+				byPath[a.Path] = len(files)
+				files = append(files, file)
+			}
+		}
+		return nil
+	}
+
+	for _, service := range project.Services {
+		plan := &dryrun.Plan{}
+		planCtx := dryrun.WithPlan(ctx, plan)
+		if err := s.svcManager.InstallService(planCtx, service); err != nil {
+			return nil, fmt.Errorf("%s: %w", service.Name, err)
+		}
+		envPath, err := s.envFiles.Sync(planCtx, service)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", service.Name, err)
+		}
+		if err := add(service.ServiceName(), plan.Actions(), service, envPath); err != nil {
+			return nil, err
+		}
+	}
+
+	budget, err := s.store.GetProjectBudget(ctx, project.ID)
+	if err != nil {
+		return nil, err
+	}
+	if budget != nil {
+		plan := &dryrun.Plan{}
+		units, _ := sliceUnits(project)
+		if err := systemd.SetProjectBudget(dryrun.WithPlan(ctx, plan), project.ID, systemd.Budget{MemoryMax: budget.MemoryMax, CPUQuota: budget.CPUQuota}, units); err != nil {
+			return nil, err
+		}
+		if err := add(planPartSlice, plan.Actions(), nil, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if project.Domain != "" {
+		plan := &dryrun.Plan{}
+		if err := s.nginxManager.InstallSite(dryrun.WithPlan(ctx, plan), project); err != nil {
+			return nil, err
+		}
+		if err := add(planPartSite, plan.Actions(), nil, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := sha256.New()
+	for _, file := range files {
+		resp.paths = append(resp.paths, file.Path)
+		if file.Change == "" {
+			resp.Unchanged++
+			continue
+		}
+		resp.Files = append(resp.Files, file)
+		fmt.Fprintf(sum, "%s\x00%s\x00%s\x00%s\x00%s\n", file.Path, file.Change, file.Mode, file.Target, file.sum)
+	}
+	resp.Fingerprint = hex.EncodeToString(sum.Sum(nil))[:16]
+	return resp, nil
+}
+
+// planFile compares one planned action with the disk. Its Change is ""
+// when the file already matches. Units and .env files holding secrets are
+// compared with their resolved contents, which are never shown.
+func (s *Server) planFile(ctx context.Context, a dryrun.Action, part string, service *storage.Service, envPath string) (planFile, error) {
+	file := planFile{Path: a.Path, Mode: a.Mode, Target: a.Target, Part: part}
+	switch a.Type {
+	case dryrun.ActionMkdir:
+		file.Change = planMkdir
+		return file, nil
+	case dryrun.ActionSymlink:
+		if target, err := os.Readlink(a.Path); err != nil || target != a.Target {
+			file.Change = planSymlink
+		}
+		return file, nil
+	}
+
+	info, err := os.Stat(a.Path)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+	if a.Type == dryrun.ActionRemove {
+		if !exists {
+			return file, nil
+		}
+		file.Change, file.Mode = planDelete, ""
+		file.Sensitive = info.Mode().Perm() == 0600
+		if !file.Sensitive {
+			old, err := os.ReadFile(a.Path)
+			if err != nil {
+				return file, err
+			}
+			file.Diff = dryrun.Diff(a.Path, string(old), "")
+		}
+		return file, nil
+	}
+
+	// A write, whose planned content shows secrets unresolved or masked
+	want, shown := a.Content, a.Content
+	switch {
+	case service != nil && a.Path == envPath:
+		if want, err = s.envFiles.Contents(ctx, service); err != nil {
+			return file, err
+		}
+		file.Sensitive = true
+	case service != nil && s.resolver != nil:
+		resolved, substituted, err := s.resolver.Resolve(ctx, service, a.Content)
+		if err != nil {
+			return file, err
+		}
+		if substituted {
+			// Written readable only by root, as InstallService does
+			want, file.Mode, file.Sensitive = resolved, "0600", true
+		}
+	}
+	sum := sha256.Sum256([]byte(want))
+	file.sum = hex.EncodeToString(sum[:])
+
+	var old []byte
+	if exists {
+		if old, err = os.ReadFile(a.Path); err != nil {
+			return file, err
+		}
+	}
+	switch {
+	case !exists:
+		file.Change = planCreate
+	case string(old) != want || fmt.Sprintf("%04o", info.Mode().Perm()) != file.Mode:
+		file.Change = planUpdate
+	default:
+		return file, nil
+	}
+	if !file.Sensitive {
+		file.Diff = dryrun.Diff(a.Path, string(old), shown)
+	}
+	return file, nil
+}
+
+// applyProjectPlan makes the plan's changes, redoing each part of the
+// project it touches, and restarts the running services whose files changed
+// when restart is set
+func (s *Server) applyProjectPlan(ctx context.Context, project *storage.Project, plan *projectPlanResponse, restart bool) ([]string, error) {
+	changed := make(map[string]bool)
+	for _, file := range plan.Files {
+		changed[file.Part] = true
+	}
+
+	restarted := []string{}
+	for _, service := range project.Services {
+		if !changed[service.ServiceName()] {
+			continue
+		}
+		if err := s.svcManager.InstallService(ctx, service); err != nil {
+			return restarted, fmt.Errorf("%s: %w", service.Name, err)
+		}
+		if _, err := s.envFiles.Sync(ctx, service); err != nil {
+			return restarted, fmt.Errorf("%s: %w", service.Name, err)
+		}
+	}
+	if changed[planPartSlice] {
+		budget, err := s.store.GetProjectBudget(ctx, project.ID)
+		if err != nil {
+			return restarted, err
+		}
+		if budget != nil {
+			units, _ := sliceUnits(project)
+			if err := systemd.SetProjectBudget(ctx, project.ID, systemd.Budget{MemoryMax: budget.MemoryMax, CPUQuota: budget.CPUQuota}, units); err != nil {
+				return restarted, err
+			}
+		}
+	}
+	if changed[planPartSite] {
+		check, mode, err := s.checkDomainDNS(ctx, project)
+		if err != nil {
+			return restarted, err
+		}
+		if err := check.Err(); err != nil && mode == "enforce" {
+			return restarted, err
+		}
+		if err := s.nginxManager.InstallSite(ctx, project); err != nil {
+			return restarted, err
+		}
+		s.events.Publish(events.Event{Type: events.NginxDeployed, ProjectID: project.ID, Data: map[string]interface{}{
+			"project": project.Name,
+			"domain":  project.Domain,
+		}})
+	}
+
+	if !restart {
+		return restarted, nil
+	}
+	for _, service := range project.Services {
+		name := service.ServiceName()
+		if !changed[name] && !changed[planPartSlice] {
+			continue
+		}
+		if s.svcManager.ActiveState(ctx, name) != "active" {
+			continue
+		}
+		if err := s.svcManager.Restart(ctx, name); err != nil {
+			return restarted, fmt.Errorf("%s: %w", service.Name, err)
+		}
+		s.serviceStatus(ctx, service)
+		restarted = append(restarted, service.Name)
+	}
+	return restarted, nil
+}
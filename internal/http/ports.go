@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"fmt"
+
+	"servio/internal/monitor"
+	"servio/internal/storage"
+)
+
+// portAllocator hands out ports that no service of a host is configured with
+// and nothing on that host is bound to
+type portAllocator struct {
+	used  map[int]bool
+	bound func(port int) (bool, error)
+}
+
+// newPortAllocator starts from the ports the host's services are configured with now
+func (s *Server) newPortAllocator(ctx context.Context, hostID int64) (*portAllocator, error) {
+	used, err := s.store.UsedPorts(ctx, hostID)
+	if err != nil {
+		return nil, err
+	}
+	bound := func(port int) (bool, error) { return s.portBound(ctx, hostID, port) }
+	return &portAllocator{used: used, bound: bound}, nil
+}
+
+// next returns the first free port at or above preferred and reserves it
+func (a *portAllocator) next(preferred int) (int, error) {
+	for port := preferred; port <= 65535; port++ {
+		if a.used[port] {
+			continue
+		}
+		bound, err := a.bound(port)
+		if err != nil {
+			return 0, err
+		}
+		if !bound {
+			a.used[port] = true
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no free port at or above %d", storage.ErrPortConflict, preferred)
+}
+
+// portBound reports whether something holds a TCP port on a host. This
+// server probes its own ports; an agent host's agent probes its ports.
+func (s *Server) portBound(ctx context.Context, hostID int64, port int) (bool, error) {
+	client, err := s.hosts.Client(ctx, hostID)
+	if err != nil {
+		return false, err
+	}
+	if client == nil {
+		return monitor.PortInUse(port), nil
+	}
+	return client.PortInUse(ctx, port)
+}
+
+// checkHostPort rejects ports that are already bound on the project's host by
+// something other than the service being edited (whose own listener naturally
+// holds its current port)
+func (s *Server) checkHostPort(ctx context.Context, projectID int64, port int, current *storage.Service) error {
+	if port <= 0 || (current != nil && current.Port == port) {
+		return nil
+	}
+	project, err := s.store.GetProject(ctx, projectID)
+	if err != nil || project == nil {
+		return err // the store reports a missing project
+	}
+	bound, err := s.portBound(ctx, project.HostID, port)
+	if err != nil {
+		return err
+	}
+	if bound {
+		where := "this host"
+		if project.HostID != 0 {
+			where = "the project's host"
+		}
+		return fmt.Errorf("%w: port %d is already bound by another process on %s", storage.ErrPortConflict, port, where)
+	}
+	return nil
+}
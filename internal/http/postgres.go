@@ -0,0 +1,343 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"servio/internal/jobs"
+	"servio/internal/postgres"
+	"servio/internal/storage"
+)
+
+// postgresDatabaseRequest is the body for creating a database
+type postgresDatabaseRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"` // defaults to postgres
+}
+
+// postgresRoleRequest is the body for creating a role
+type postgresRoleRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"` // generated when empty
+	CreateDB bool   `json:"create_db"`
+}
+
+// postgresPasswordRequest is the body for resetting a role's password
+type postgresPasswordRequest struct {
+	Password string `json:"password"` // generated when empty
+}
+
+// postgresRoleResponse is a role's name and password, which is not shown again
+type postgresRoleResponse struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// postgresBackupRequest is the body for backing up a database
+type postgresBackupRequest struct {
+	Database string `json:"database"`
+}
+
+// handleAPIPostgresDatabases lists the databases of a postgres service with their sizes
+// GET /api/services/{id}/postgres/databases
+func (s *Server) handleAPIPostgresDatabases(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	pg, ok := s.loadPostgres(w, r, service)
+	if !ok {
+		return
+	}
+	databases, err := pg.ListDatabases(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, databases)
+}
+
+// handleAPICreatePostgresDatabase creates a database
+// POST /api/services/{id}/postgres/databases {"name","owner"}
+func (s *Server) handleAPICreatePostgresDatabase(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req postgresDatabaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var fields []storage.FieldError
+	if err := postgres.CheckName(req.Name); err != nil {
+		fields = append(fields, storage.FieldError{Field: "name", Message: err.Error()})
+	}
+	if req.Owner != "" {
+		if err := postgres.CheckName(req.Owner); err != nil {
+			fields = append(fields, storage.FieldError{Field: "owner", Message: err.Error()})
+		}
+	}
+	if len(fields) > 0 {
+		apiError(w, r, &storage.ValidationError{Fields: fields})
+		return
+	}
+
+	pg, ok := s.loadPostgres(w, r, service)
+	if !ok {
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return pg.CreateDatabase(ctx, req.Name, req.Owner)
+		})
+		return
+	}
+	if err := pg.CreateDatabase(r.Context(), req.Name, req.Owner); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, statusResponse{Status: "created"})
+}
+
+// handleAPIPostgresRoles lists the roles of a postgres service
+// GET /api/services/{id}/postgres/roles
+func (s *Server) handleAPIPostgresRoles(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	pg, ok := s.loadPostgres(w, r, service)
+	if !ok {
+		return
+	}
+	roles, err := pg.ListRoles(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, roles)
+}
+
+// handleAPICreatePostgresRole creates a login role, generating its password
+// unless one is given. The password is only returned here.
+// POST /api/services/{id}/postgres/roles {"name","password","create_db"}
+func (s *Server) handleAPICreatePostgresRole(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req postgresRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := postgres.CheckName(req.Name); err != nil {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "name", Message: err.Error()}}})
+		return
+	}
+	pg, ok := s.loadPostgres(w, r, service)
+	if !ok {
+		return
+	}
+	password, err := rolePassword(req.Password)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return pg.CreateRole(ctx, req.Name, password, req.CreateDB)
+		})
+		return
+	}
+	if err := pg.CreateRole(r.Context(), req.Name, password, req.CreateDB); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, postgresRoleResponse{Name: req.Name, Password: password})
+}
+
+// handleAPIResetPostgresPassword sets a role's password, generating one
+// unless it is given. The password is only returned here.
+// POST /api/services/{id}/postgres/roles/{role}/password {"password"}
+func (s *Server) handleAPIResetPostgresPassword(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	role := r.PathValue("role")
+	if err := postgres.CheckName(role); err != nil {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "role", Message: err.Error()}}})
+		return
+	}
+	var req postgresPasswordRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	pg, ok := s.loadPostgres(w, r, service)
+	if !ok {
+		return
+	}
+	password, err := rolePassword(req.Password)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return pg.SetPassword(ctx, role, password)
+		})
+		return
+	}
+	if err := pg.SetPassword(r.Context(), role, password); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, postgresRoleResponse{Name: role, Password: password})
+}
+
+// handleAPIPostgresBackups lists the service's database backups, newest first
+// GET /api/services/{id}/postgres/backups
+func (s *Server) handleAPIPostgresBackups(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	backups, err := s.store.ListDatabaseBackups(r.Context(), service.ProjectID, service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, backups)
+}
+
+// handleAPIProjectDatabaseBackups lists the backups of every database service of a project, newest first
+// GET /api/projects/{id}/database-backups
+func (s *Server) handleAPIProjectDatabaseBackups(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	backups, err := s.store.ListDatabaseBackups(r.Context(), project.ID, 0)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, backups)
+}
+
+// handleAPICreatePostgresBackup queues a backup job that dumps a database with
+// pg_dump into the backup directory
+// POST /api/services/{id}/postgres/backups {"database"}
+func (s *Server) handleAPICreatePostgresBackup(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req postgresBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := postgres.CheckName(req.Database); err != nil {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "database", Message: err.Error()}}})
+		return
+	}
+	pg, ok := s.loadPostgres(w, r, service)
+	if !ok {
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.dump", service.Name, req.Database, time.Now().UTC().Format("20060102-150405"))
+	backup := &storage.DatabaseBackup{
+		ProjectID: service.ProjectID,
+		ServiceID: service.ID,
+		Database:  req.Database,
+		Path:      filepath.Join(s.backupDir, fmt.Sprint(service.ProjectID), name),
+		Status:    storage.JobQueued,
+	}
+	if err := s.store.CreateDatabaseBackup(r.Context(), backup); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	job, err := s.jobs.Enqueue(r.Context(), storage.Job{Kind: jobs.KindBackup, ProjectID: service.ProjectID, ServiceID: service.ID},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			backup.JobID = job.ID
+			return s.runBackup(ctx, pg, backup, logf)
+		})
+	if err != nil {
+		s.finishBackup(r.Context(), backup, 0, fmt.Errorf("backup not started: %w", err))
+		apiError(w, r, err)
+		return
+	}
+	backup.JobID = job.ID
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, backup)
+}
+
+// handleAPIDownloadPostgresBackup sends a backup's dump file
+// GET /api/services/{id}/postgres/backups/{backup}/download
+func (s *Server) handleAPIDownloadPostgresBackup(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	id, err := pathID(r, "backup")
+	if err != nil {
+		jsonError(w, r, "Invalid backup ID", http.StatusBadRequest)
+		return
+	}
+	backup, err := s.store.GetDatabaseBackup(r.Context(), id)
+	if err != nil || backup == nil || backup.ServiceID != service.ID {
+		jsonError(w, r, "Backup not found", http.StatusNotFound)
+		return
+	}
+	if backup.Status != storage.JobSucceeded {
+		jsonError(w, r, "Backup has not succeeded", http.StatusConflict)
+		return
+	}
+	f, err := os.Open(backup.Path)
+	if err != nil {
+		jsonError(w, r, "Backup file is gone: "+backup.Path, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(backup.Path)))
+	http.ServeContent(w, r, filepath.Base(backup.Path), backup.CreatedAt, f)
+}
+
+// runBackup is the work of a backup job
+func (s *Server) runBackup(ctx context.Context, pg *postgres.Server, backup *storage.DatabaseBackup, logf jobs.Logf) error {
+	if ctx.Err() != nil {
+		err := context.Cause(ctx)
+		s.finishBackup(ctx, backup, 0, err)
+		return err
+	}
+	logf("dumping database %s to %s", backup.Database, backup.Path)
+	backup.Status = storage.JobRunning
+	size, err := pg.Dump(ctx, backup.Database, backup.Path)
+	if err == nil {
+		logf("wrote %d bytes", size)
+	} else if ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	s.finishBackup(ctx, backup, size, err)
+	return err
+}
+
+// finishBackup records how a backup ended
+func (s *Server) finishBackup(ctx context.Context, backup *storage.DatabaseBackup, size int64, err error) {
+	finished := time.Now()
+	backup.FinishedAt = &finished
+	backup.SizeBytes = size
+	backup.Status = storage.JobSucceeded
+	if err != nil {
+		backup.Status, backup.Error = storage.JobFailed, err.Error()
+	}
+	if err := s.store.FinishDatabaseBackup(context.WithoutCancel(ctx), backup); err != nil {
+		slog.WarnContext(ctx, "Failed to record database backup", "backup_id", backup.ID, "error", err)
+	}
+}
+
+// loadPostgres returns the postgres instance of a service. Only postgres
+// services on this server qualify; it writes the error response itself.
+func (s *Server) loadPostgres(w http.ResponseWriter, r *http.Request, service *storage.Service) (*postgres.Server, bool) {
+	if service.Type != postgres.ServiceType {
+		apiError(w, r, fmt.Errorf("%s is a %s service: %w", service.Name, service.Type, postgres.ErrNotPostgres))
+		return nil, false
+	}
+	if err := checkSystemd(service, "postgres administration"); err != nil {
+		apiError(w, r, err)
+		return nil, false
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "postgres administration"); err != nil {
+		apiError(w, r, err)
+		return nil, false
+	}
+	return postgres.ForService(service), true
+}
+
+// rolePassword returns the given password, or a generated one when it is empty
+func rolePassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	return postgres.GeneratePassword()
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+const (
+	// reauthWindow is how long a re-authentication allows power actions
+	reauthWindow = 5 * time.Minute
+	// powerDelay gives the response time to reach the client before the host goes down
+	powerDelay = 2 * time.Second
+)
+
+// errReauthRequired is returned for power actions without a recent re-authentication
+var errReauthRequired = errors.New("re-authenticate with POST /api/auth/reauthenticate, or SSO users at /auth/login?reauth=1, first")
+
+// reauthRequest is the body of a re-authentication
+type reauthRequest struct {
+	Password string `json:"password"`
+}
+
+// reauthResponse says until when the re-authentication allows power actions
+type reauthResponse struct {
+	User      string    `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// powerRequest confirms a power action by naming the host it applies to
+type powerRequest struct {
+	Confirm string `json:"confirm"` // the host name, as GET /api/system/power reports it
+}
+
+// powerResponse reports the services stopped before a power action
+type powerResponse struct {
+	Action   string                `json:"action"`
+	Hostname string                `json:"hostname"`
+	Stopped  serviceActionResponse `json:"stopped"`
+}
+
+// powerInfo is what a client needs to confirm a power action
+type powerInfo struct {
+	Hostname string `json:"hostname"`
+	Enabled  bool   `json:"enabled"` // false unless services run under systemd
+}
+
+// SetPowerControl allows or refuses reboot and shutdown requests. Servers
+// not running services under systemd refuse them: mock and supervisor mode,
+// so a development machine is never powered off, and OpenRC and runit hosts,
+// where systemctl would fail after every service was stopped.
+func (s *Server) SetPowerControl(enabled bool) {
+	s.powerControl = enabled
+}
+
+// handleAPIReauthenticate checks the password again and allows the user's
+// power actions for reauthWindow
+// POST /api/auth/reauthenticate {"password"}
+func (s *Server) handleAPIReauthenticate(w http.ResponseWriter, r *http.Request) {
+	var req reauthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	auth := s.auth.Load()
+	if auth.password == "" || subtle.ConstantTimeCompare([]byte(req.Password), []byte(auth.password)) != 1 {
+		audit.Log(r.Context(), audit.CategoryAuth, "reauthenticate", "reauthenticate", "", errors.New("wrong password"), 0)
+		jsonError(w, r, "Wrong password", http.StatusForbidden)
+		return
+	}
+
+	user := storage.ActorFromContext(r.Context())
+	now := time.Now()
+	s.reauthAt.Store(user, now)
+	audit.Log(r.Context(), audit.CategoryAuth, "reauthenticate", "reauthenticate", "", nil, 0)
+	jsonResponse(w, reauthResponse{User: user, ExpiresAt: now.Add(reauthWindow)})
+}
+
+// recentlyReauthenticated reports whether the request's user re-authenticated within reauthWindow
+func (s *Server) recentlyReauthenticated(r *http.Request) bool {
+	at, ok := s.reauthAt.Load(storage.ActorFromContext(r.Context()))
+	return ok && time.Since(at.(time.Time)) < reauthWindow
+}
+
+// handleAPIPowerInfo returns the host name power actions must be confirmed with
+// GET /api/system/power
+func (s *Server) handleAPIPowerInfo(w http.ResponseWriter, r *http.Request) {
+	hostname, _ := os.Hostname()
+	jsonResponse(w, powerInfo{Hostname: hostname, Enabled: s.powerControl})
+}
+
+// handleAPIPower stops the services of this server's projects, then reboots
+// or shuts down the host shortly after responding. It needs a re-authentication
+// within the last reauthWindow and the host name in confirm.
+// POST /api/system/reboot|shutdown {"confirm"}
+func (s *Server) handleAPIPower(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req powerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !s.recentlyReauthenticated(r) {
+			apiError(w, r, errReauthRequired)
+			return
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		if req.Confirm != hostname {
+			apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "confirm", Message: "must be the host name " + hostname}}})
+			return
+		}
+
+		if isDryRun(r) {
+			respondDryRun(w, r, func(ctx context.Context) error {
+				s.stopAllServices(ctx)
+				return systemd.Power(ctx, action)
+			})
+			return
+		}
+		if !s.powerControl {
+			jsonError(w, r, "Power controls are disabled on this server", http.StatusConflict)
+			return
+		}
+
+		slog.WarnContext(r.Context(), "Host power action requested", "action", action, "user", storage.ActorFromContext(r.Context()))
+		stopped := s.stopAllServices(r.Context())
+		// One re-authentication allows one power action
+		s.reauthAt.Delete(storage.ActorFromContext(r.Context()))
+
+		ctx := context.WithoutCancel(r.Context())
+		time.AfterFunc(powerDelay, func() {
+			if err := systemd.Power(ctx, action); err != nil {
+				slog.ErrorContext(ctx, "Host power action failed", "action", action, "error", err)
+			}
+		})
+		w.WriteHeader(http.StatusAccepted)
+		jsonResponse(w, powerResponse{Action: action, Hostname: hostname, Stopped: stopped})
+	}
+}
+
+// stopAllServices stops the running services of every project on this
+// server, each project's dependents before their dependencies, so they shut
+// down cleanly before the host does. Projects on agent hosts keep running.
+func (s *Server) stopAllServices(ctx context.Context) serviceActionResponse {
+	var results []serviceActionResult
+	projects, err := s.store.ListProjects(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list projects to stop", "error", err)
+	}
+	for _, project := range projects {
+		if project.HostID != 0 {
+			continue
+		}
+		services, err := s.store.ListServicesByProject(ctx, project.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list services to stop", "project", project.Name, "error", err)
+			continue
+		}
+		// A dependency cycle only loses the ordering
+		if ordered, _, err := systemd.StartOrder(services); err == nil {
+			services = ordered
+		}
+		slices.Reverse(services)
+
+		for _, sv := range services {
+			if s.svcManager.ActiveState(ctx, sv.ServiceName()) != "active" {
+				continue
+			}
+			result := serviceActionResult{ServiceID: sv.ID, Name: sv.Name}
+			if err := s.svcManager.Stop(audit.WithTarget(ctx, project.ID, sv.ID), sv.ServiceName()); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.OK = true
+			}
+			results = append(results, result)
+		}
+	}
+	if results == nil {
+		results = []serviceActionResult{}
+	}
+	return summarizeActions("stop", results)
+}
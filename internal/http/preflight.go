@@ -0,0 +1,199 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"servio/internal/envfile"
+	"servio/internal/hostuser"
+	"servio/internal/storage"
+)
+
+// preflightOptions are what a request allows the install to fix on its own
+type preflightOptions struct {
+	createUser bool // the user is created when it does not exist
+	createDir  bool // the working directory is created when it does not exist
+}
+
+// preflightService checks a service against this host before it is saved, so
+// problems come back as field errors instead of failing its install job or
+// systemd start. On updates, current is the saved service and only changed
+// fields are checked, so a service whose host changed can still be edited.
+// Services on agent hosts and raw units only have their environment checked.
+func (s *Server) preflightService(ctx context.Context, service, current *storage.Service, opts preflightOptions) error {
+	changed := func(field func(*storage.Service) string) bool {
+		return current == nil || field(service) != field(current)
+	}
+	var fields []storage.FieldError
+	add := func(field, format string, args ...interface{}) {
+		fields = append(fields, storage.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if changed(func(sv *storage.Service) string { return sv.Environment }) {
+		for _, msg := range checkEnvironment(service.Environment, !service.IsContainer()) {
+			add("environment", "%s", msg)
+		}
+	}
+
+	project, err := s.store.GetProject(ctx, service.ProjectID)
+	if err != nil {
+		return err
+	}
+	local := project == nil || project.HostID == 0
+	if !local || service.IsContainer() || service.SystemdRaw != "" {
+		return fieldsError(fields)
+	}
+
+	user := func(sv *storage.Service) string { return sv.User }
+	if changed(user) && hostuser.Needed(service.User) && !opts.createUser {
+		add("user", "%s does not exist on this server; set create_user to create it", service.User)
+	}
+
+	dir := service.WorkingDir
+	dirPending := false // created or cloned by the install
+	if dir != "" && dir != "/" {
+		info, err := os.Stat(dir)
+		switch {
+		case err == nil && !info.IsDir():
+			add("working_dir", "%s is not a directory", dir)
+		case err == nil:
+		case !errors.Is(err, os.ErrNotExist):
+			add("working_dir", "cannot be read: %v", err)
+		case service.GitRepoURL != "" || opts.createDir || (opts.createUser && hostuser.Needed(service.User)):
+			dirPending = true
+		case changed(func(sv *storage.Service) string { return sv.WorkingDir }):
+			add("working_dir", "%s does not exist; set create_working_dir to create it", dir)
+		}
+	}
+
+	command := func(sv *storage.Service) string { return sv.Command + "\x00" + sv.WorkingDir }
+	if service.Command != "" && changed(command) {
+		if msg := s.checkExecutable(service, dirPending); msg != "" {
+			add("command", "%s", msg)
+		}
+	}
+	return fieldsError(fields)
+}
+
+// checkExecutable returns why the program a service's command starts cannot
+// run, or "" when it can. Relative programs of services without a blueprint
+// run from the working directory, as the unit generator writes them;
+// programs in a working directory the install has yet to create or clone are
+// not checked.
+func (s *Server) checkExecutable(service *storage.Service, dirPending bool) string {
+	first := strings.Fields(service.Command)[0]
+	exe := first
+	if !filepath.IsAbs(exe) {
+		if s.blueprints.IsManaged(service.Type) {
+			if _, err := exec.LookPath(exe); err != nil {
+				return exe + " is not on the PATH; give its full path"
+			}
+			return ""
+		}
+		dir := service.WorkingDir
+		if dir == "" {
+			dir = "/"
+		}
+		exe = filepath.Join(dir, exe)
+	}
+	if dirPending && service.WorkingDir != "" && strings.HasPrefix(exe, filepath.Clean(service.WorkingDir)+"/") {
+		return ""
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		msg := exe + " does not exist"
+		if !filepath.IsAbs(first) {
+			msg += "; relative commands run from the working directory"
+			if path, err := exec.LookPath(first); err == nil {
+				msg += ", so use " + path
+			}
+		}
+		return msg
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return exe + " is not executable"
+	}
+	return ""
+}
+
+// checkEnvironment returns what is wrong with each line of a service's
+// KEY=value environment. Values of systemd units are written between double
+// quotes, so they cannot hold any.
+func checkEnvironment(environment string, quoted bool) []string {
+	var problems []string
+	for i, line := range strings.Split(environment, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("line %d: expected KEY=value", i+1))
+		case envfile.CheckKey(key) != nil:
+			problems = append(problems, fmt.Sprintf("line %d: %q must start with a letter or underscore and hold only letters, digits, and underscores", i+1, key))
+		case quoted && strings.Contains(value, `"`):
+			problems = append(problems, fmt.Sprintf("line %d: the value of %s may not contain double quotes", i+1, key))
+		}
+	}
+	return problems
+}
+
+// fieldsError returns the field errors as a *storage.ValidationError, or nil when there are none
+func fieldsError(fields []storage.FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &storage.ValidationError{Fields: fields}
+}
+
+// formFieldErrors returns the field errors of err by field for a form to
+// show beside its inputs, or nil when err has none
+func formFieldErrors(err error) map[string]string {
+	var verr *storage.ValidationError
+	if !errors.As(err, &verr) {
+		return nil
+	}
+	fields := make(map[string]string, len(verr.Fields))
+	for _, f := range verr.Fields {
+		if _, ok := fields[f.Field]; !ok {
+			fields[f.Field] = f.Message
+		} else {
+			fields[f.Field] += "; " + f.Message
+		}
+	}
+	return fields
+}
+
+// serviceUpdate returns service as req would save it, for preflightService
+func serviceUpdate(service *storage.Service, req *storage.UpdateServiceRequest) *storage.Service {
+	updated := *service
+	updated.GitRepoURL = req.GitRepoURL
+	updated.Command = req.Command
+	updated.WorkingDir = req.WorkingDir
+	updated.User = req.User
+	updated.Environment = req.Environment
+	updated.SystemdRaw = req.SystemdRaw
+	return &updated
+}
+
+// serviceCreate returns the service req would create, for preflightService
+func serviceCreate(req *storage.CreateServiceRequest) *storage.Service {
+	return &storage.Service{
+		ProjectID:   req.ProjectID,
+		Type:        req.Type,
+		Runtime:     req.Runtime,
+		GitRepoURL:  req.GitRepoURL,
+		Command:     req.Command,
+		WorkingDir:  req.WorkingDir,
+		User:        req.User,
+		Environment: req.Environment,
+		SystemdRaw:  req.SystemdRaw,
+	}
+}
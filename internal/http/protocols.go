@@ -0,0 +1,77 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"servio/internal/nginx"
+	"servio/internal/storage"
+)
+
+// siteProtocolsRequest is the body for choosing a site's protocols
+type siteProtocolsRequest struct {
+	HTTP2 bool `json:"http2"`
+	HTTP3 bool `json:"http3"`
+}
+
+// siteProtocolsResponse is what a site asks for and what nginx supports
+type siteProtocolsResponse struct {
+	HTTP2     bool            `json:"http2"`
+	HTTP3     bool            `json:"http3"`
+	Supported nginx.Protocols `json:"supported"`
+	// Active is whether the site serves HTTPS, without which neither applies
+	Active bool `json:"active"`
+}
+
+// handleAPIGetSiteProtocols reports the project's HTTP/2 and HTTP/3 toggles
+// and what the host's nginx supports
+// GET /api/nginx/{id}/protocols
+func (s *Server) handleAPIGetSiteProtocols(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	if err := checkLocal(project, "site protocols"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, s.siteProtocols(r, project))
+}
+
+// handleAPISetSiteProtocols turns HTTP/2 and HTTP/3 on or off for the
+// project's site, refusing what the host's nginx cannot serve, and rewrites
+// the site when it is installed
+// PUT /api/nginx/{id}/protocols {"http2","http3"}
+func (s *Server) handleAPISetSiteProtocols(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req siteProtocolsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := checkLocal(project, "site protocols"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.nginxManager.Protocols(r.Context()).Check(req.HTTP2, req.HTTP3); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	project, err := s.store.SetProjectProtocols(r.Context(), project.ID, req.HTTP2, req.HTTP3)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.reinstallSite(r.Context(), project, project.Certificate); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to rewrite nginx site", "project", project.Name, "error", err)
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, s.siteProtocols(r, project))
+}
+
+func (s *Server) siteProtocols(r *http.Request, project *storage.Project) siteProtocolsResponse {
+	return siteProtocolsResponse{
+		HTTP2:     project.HTTP2,
+		HTTP3:     project.HTTP3,
+		Supported: s.nginxManager.Protocols(r.Context()),
+		Active:    project.NginxRaw == "" && s.nginxManager.SiteCertificate(project) != "",
+	}
+}
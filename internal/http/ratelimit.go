@@ -0,0 +1,121 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/storage"
+)
+
+// rateLimitWindow is the fixed window API request budgets are counted over
+const rateLimitWindow = time.Minute
+
+// rateLimiter caps API requests per client in fixed one-minute windows. A
+// client is the user a request was authenticated as (basic auth user, client
+// certificate name, or sso:<name>), or ip:<address> for requests to public
+// paths such as agent registration.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int            // default budget per window; 0 disables limiting
+	overrides map[string]int // per-client budgets; 0 exempts the client
+	start     time.Time
+	counts    map[string]int
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{counts: make(map[string]int)}
+}
+
+// SetRateLimit sets how many API requests each client may make per minute.
+// Overrides map client names to their own budget; a budget of 0 means unlimited.
+func (s *Server) SetRateLimit(limit int, overrides map[string]int) {
+	s.limiter.mu.Lock()
+	defer s.limiter.mu.Unlock()
+	s.limiter.limit = limit
+	s.limiter.overrides = overrides
+}
+
+// take counts a request from client and reports its budget, what is left of
+// it and when the window resets. ok is false once the budget is exhausted.
+func (l *rateLimiter) take(client string, now time.Time) (limit, remaining int, reset time.Time, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit = l.limit
+	if n, found := l.overrides[client]; found {
+		limit = n
+	}
+	if limit <= 0 {
+		return 0, 0, time.Time{}, true
+	}
+
+	if now.Sub(l.start) >= rateLimitWindow {
+		l.start = now.Truncate(rateLimitWindow)
+		clear(l.counts)
+	}
+	reset = l.start.Add(rateLimitWindow)
+
+	if l.counts[client] >= limit {
+		return limit, 0, reset, false
+	}
+	l.counts[client]++
+	return limit, limit - l.counts[client], reset, true
+}
+
+// RateLimit is a middleware that enforces the limiter's per-client budget on
+// API and GraphQL requests, advertising it in RateLimit-* headers and answering 429 when
+// it runs out. It must run after BasicAuth so the client is known.
+func (l *rateLimiter) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != graphqlPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, remaining, reset, ok := l.take(rateLimitClient(r), time.Now())
+		if limit > 0 {
+			resetIn := strconv.Itoa(int(time.Until(reset).Round(time.Second).Seconds()))
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			h.Set("RateLimit-Reset", resetIn)
+			if !ok {
+				h.Set("Retry-After", resetIn)
+				jsonError(w, r, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitClient names the client whose budget a request counts against
+func rateLimitClient(r *http.Request) string {
+	if publicPaths[r.URL.Path] {
+		return "ip:" + clientAddr(r)
+	}
+	return storage.ActorFromContext(r.Context())
+}
+
+// clientAddr returns the address a request came from. Behind a reverse proxy
+// on the same host, connecting over loopback or the unix socket, that is the
+// last address the proxy added to X-Forwarded-For.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+				return last
+			}
+		}
+	}
+	return host
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"servio/internal/storage"
+)
+
+func TestRateLimitClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		actor      string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"authenticated", "deploy-bot", "203.0.113.7:5000", nil, "deploy-bot"},
+		{"remote", "", "203.0.113.7:5000", nil, "ip:203.0.113.7"},
+		{"remote ignores forwarded", "", "203.0.113.7:5000", []string{"198.51.100.1"}, "ip:203.0.113.7"},
+		{"local proxy", "", "127.0.0.1:5000", []string{"198.51.100.1, 198.51.100.2"}, "ip:198.51.100.2"},
+		{"local proxy, several headers", "", "[::1]:5000", []string{"198.51.100.1", "198.51.100.3"}, "ip:198.51.100.3"},
+		{"unix socket", "", "@", []string{"198.51.100.4"}, "ip:198.51.100.4"},
+		{"local without proxy", "", "127.0.0.1:5000", nil, "ip:127.0.0.1"},
+	}
+	for _, tt := range tests {
+		path := "/api/agents/register"
+		if tt.actor != "" {
+			path = "/api/projects"
+		}
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.actor != "" {
+			r = r.WithContext(storage.WithActor(r.Context(), tt.actor))
+		}
+		if got := rateLimitClient(r); got != tt.want {
+			t.Errorf("%s: rateLimitClient = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
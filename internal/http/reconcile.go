@@ -0,0 +1,330 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/cron"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// Where reconcile takes the content of a file it writes from
+const (
+	reconcileFromConfig = "config" // generated from the database, as installing does
+	reconcileFromKept   = "kept"   // what was last written, kept in artifacts
+)
+
+// planPartCron is the part of the files of a project's cron jobs
+const planPartCron = "cron"
+
+// reconcileRequest is the body for reconciling the host
+type reconcileRequest struct {
+	Start bool `json:"start"` // also start enabled units that are not running
+}
+
+// reconcileFile is a file reconciling wrote, or would write in a dry run
+type reconcileFile struct {
+	Path    string `json:"path"`
+	Change  string `json:"change"` // create, update, delete, symlink, or mkdir, as in plans
+	Source  string `json:"source"` // config or kept
+	Project string `json:"project,omitempty"`
+	// Part is the service unit, nginx, budget, or cron for files generated
+	// from the config, and the audit category for kept ones
+	Part string `json:"part"`
+}
+
+// reconcileResponse lists what reconciling the host changed, or would
+// change in a dry run
+type reconcileResponse struct {
+	DryRun  bool            `json:"dry_run"`
+	Files   []reconcileFile `json:"files"`
+	Enabled []string        `json:"enabled"` // units enabled again
+	Started []string        `json:"started"`
+	Skipped []string        `json:"skipped"` // projects on agent hosts
+	Errors  []string        `json:"errors"`  // parts that failed; the others still ran
+}
+
+// handleAPIReconcile installs again the units, .env files, nginx sites, and
+// enablements missing from the host or changed on it, so a fresh host given
+// a restored database runs what the old one did
+// POST /api/system/reconcile {"start"}
+func (s *Server) handleAPIReconcile(w http.ResponseWriter, r *http.Request) {
+	var req reconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	resp := s.reconcile(r.Context(), !isDryRun(r), req.Start)
+	if !resp.DryRun {
+		slog.InfoContext(r.Context(), "Reconciled host", "files", len(resp.Files), "enabled", len(resp.Enabled), "started", len(resp.Started), "errors", len(resp.Errors))
+	}
+	jsonResponse(w, resp)
+}
+
+// reconcile walks every project on this server. Each part of a project that
+// was installed, which its kept files or the files on disk tell, has its
+// plan applied; cron jobs missing their units are installed again. Kept
+// files no plan covers, such as journald configs, are then written back
+// where they are missing or changed, and units that were enabled are
+// enabled again. Nothing is changed unless apply is set.
+func (s *Server) reconcile(ctx context.Context, apply, start bool) *reconcileResponse {
+	resp := &reconcileResponse{DryRun: !apply, Files: []reconcileFile{}, Enabled: []string{}, Started: []string{}, Skipped: []string{}, Errors: []string{}}
+	fail := func(format string, args ...interface{}) {
+		resp.Errors = append(resp.Errors, fmt.Sprintf(format, args...))
+	}
+
+	kept, err := s.store.ListArtifacts(ctx, storage.ArtifactFilter{WithContent: true})
+	if err != nil {
+		fail("%v", err)
+		return resp
+	}
+	projects, err := s.store.ListProjects(ctx)
+	if err != nil {
+		fail("%v", err)
+		return resp
+	}
+
+	covered := make(map[string]bool) // paths the projects' plans write
+	for _, p := range projects {
+		if p.HostID != 0 {
+			resp.Skipped = append(resp.Skipped, p.Name)
+			continue
+		}
+		project, err := s.store.GetProject(ctx, p.ID)
+		if err != nil {
+			fail("%s: %v", p.Name, err)
+			continue
+		}
+		if err := s.reconcileProject(ctx, project, kept, covered, apply, resp); err != nil {
+			fail("%s: %v", project.Name, err)
+		}
+	}
+
+	s.restoreKeptFiles(ctx, kept, covered, apply, resp, fail)
+	s.reconcileEnablements(ctx, apply, start, resp, fail)
+	return resp
+}
+
+// reconcileProject applies the parts of a project's plan that were
+// installed, and installs its cron jobs whose units are missing
+func (s *Server) reconcileProject(ctx context.Context, project *storage.Project, kept []*storage.Artifact, covered map[string]bool, apply bool, resp *reconcileResponse) error {
+	plan, err := s.projectPlan(ctx, project)
+	if err != nil {
+		return err
+	}
+	for _, path := range plan.paths {
+		covered[path] = true
+	}
+
+	// A budget lives in the database alone; services and the site were
+	// installed if a file of theirs was kept or is on disk
+	installed := map[string]bool{planPartSlice: true}
+	for _, service := range project.Services {
+		name := service.ServiceName()
+		installed[name] = s.svcManager.ServiceExists(name)
+		for _, a := range kept {
+			if a.ServiceID == service.ID && a.Category == audit.CategorySystemd {
+				installed[name] = true
+				break
+			}
+		}
+	}
+	if project.Domain != "" {
+		sitePath := s.nginxManager.SiteConfigPath(project)
+		_, err := os.Stat(sitePath)
+		installed[planPartSite] = err == nil
+		for _, a := range kept {
+			if a.Path == sitePath {
+				installed[planPartSite] = true
+			}
+		}
+	}
+
+	var files []planFile
+	for _, f := range plan.Files {
+		if installed[f.Part] {
+			files = append(files, f)
+			resp.Files = append(resp.Files, reconcileFile{Path: f.Path, Change: f.Change, Source: reconcileFromConfig, Project: project.Name, Part: f.Part})
+		}
+	}
+	if apply && len(files) > 0 {
+		plan.Files = files
+		if _, err := s.applyProjectPlan(ctx, project, plan, false); err != nil {
+			return err
+		}
+	}
+
+	jobs, err := s.store.ListCronJobs(ctx, project.ID)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		var missing []string
+		for _, path := range []string{filepath.Join(systemd.ServiceDir, job.UnitName()+".service"), filepath.Join(systemd.ServiceDir, job.UnitName()+".timer")} {
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				missing = append(missing, path)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		for _, path := range missing {
+			covered[path] = true
+			resp.Files = append(resp.Files, reconcileFile{Path: path, Change: planCreate, Source: reconcileFromConfig, Project: project.Name, Part: planPartCron})
+		}
+		if apply {
+			if err := cron.Install(audit.WithTarget(ctx, project.ID, 0), job, s.resolver); err != nil {
+				return fmt.Errorf("cron job %s: %w", job.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreKeptFiles writes back the kept files no plan covers where they are
+// missing or changed, then reloads systemd and nginx as they need
+func (s *Server) restoreKeptFiles(ctx context.Context, kept []*storage.Artifact, covered map[string]bool, apply bool, resp *reconcileResponse, fail func(string, ...interface{})) {
+	reload := make(map[string]bool) // by category
+	for _, a := range kept {
+		if covered[a.Path] {
+			continue
+		}
+		content, err := audit.FileContent(a, s.cipher)
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		change, err := keptFileChange(a, content)
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		if change == "" {
+			continue
+		}
+		resp.Files = append(resp.Files, reconcileFile{Path: a.Path, Change: change, Source: reconcileFromKept, Part: a.Category})
+		if !apply {
+			continue
+		}
+		if err := s.restoreKeptFile(audit.WithTarget(ctx, a.ProjectID, a.ServiceID), a, content); err != nil {
+			fail("%v", err)
+			continue
+		}
+		reload[a.Category] = true
+	}
+
+	if reload[audit.CategorySystemd] {
+		if err := s.svcManager.Reload(ctx); err != nil {
+			fail("%v", err)
+		}
+	}
+	if reload[audit.CategoryNginx] {
+		err := s.nginxManager.TestConfig(ctx)
+		if err == nil {
+			err = s.nginxManager.Reload(ctx)
+		}
+		if err != nil {
+			fail("%v", err)
+		}
+	}
+}
+
+// keptFileChange compares a kept file with the disk: create when it is
+// missing, update when its content or mode differs, and "" when it matches
+func keptFileChange(a *storage.Artifact, content []byte) (string, error) {
+	info, err := os.Stat(a.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return planCreate, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	old, err := os.ReadFile(a.Path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(old, content) || fmt.Sprintf("%04o", info.Mode().Perm()) != a.Mode {
+		return planUpdate, nil
+	}
+	return "", nil
+}
+
+// restoreKeptFile writes a kept file back with its mode. A .env file goes
+// back to its service's user, as envfile writes it.
+func (s *Server) restoreKeptFile(ctx context.Context, a *storage.Artifact, content []byte) error {
+	mode, err := strconv.ParseUint(a.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %q of %s", a.Mode, a.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(a.Path), err)
+	}
+	start := time.Now()
+	err = os.WriteFile(a.Path, content, os.FileMode(mode))
+	if err == nil {
+		// WriteFile keeps the mode of an existing file
+		err = os.Chmod(a.Path, os.FileMode(mode))
+	}
+	audit.Log(ctx, a.Category, "restore-file", "write "+a.Path, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", a.Path, err)
+	}
+	if a.Category != audit.CategoryEnv || a.ServiceID == 0 {
+		return nil
+	}
+	if service, err := s.store.GetService(ctx, a.ServiceID); err == nil && service != nil && service.User != "" && service.User != "root" {
+		if output, err := exec.Command("chown", service.User, a.Path).CombinedOutput(); err != nil {
+			slog.WarnContext(ctx, "Failed to chown env file", "path", a.Path, "user", service.User, "error", err, "output", string(output))
+		}
+	}
+	return nil
+}
+
+// reconcileEnablements enables the units Servio enabled that are not, and
+// with start, starts the ones that are not running
+func (s *Server) reconcileEnablements(ctx context.Context, apply, start bool, resp *reconcileResponse, fail func(string, ...interface{})) {
+	units, err := s.store.ListEnabledUnits(ctx)
+	if err != nil {
+		fail("%v", err)
+		return
+	}
+	for _, u := range units {
+		ctx := audit.WithTarget(ctx, u.ProjectID, u.ServiceID)
+		status, err := s.svcManager.Status(ctx, u.Unit)
+		if err != nil {
+			fail("%s: %v", u.Unit, err)
+			continue
+		}
+		if !status.Enabled {
+			resp.Enabled = append(resp.Enabled, u.Unit)
+			if apply {
+				if err := s.svcManager.Enable(ctx, u.Unit); err != nil {
+					fail("%s: %v", u.Unit, err)
+					continue
+				}
+			}
+		}
+		if start && !status.Active {
+			resp.Started = append(resp.Started, u.Unit)
+			if apply {
+				if err := s.svcManager.Start(ctx, u.Unit); err != nil {
+					fail("%s: %v", u.Unit, err)
+				}
+			}
+		}
+	}
+}
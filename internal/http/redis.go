@@ -0,0 +1,155 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"servio/internal/redis"
+	"servio/internal/storage"
+)
+
+// maxRedisDB is the highest database number accepted; redis defaults to 16 databases
+const maxRedisDB = 255
+
+// redisFlushRequest is the body for flushing a database. Confirm must repeat
+// the service's name.
+type redisFlushRequest struct {
+	DB      int    `json:"db"`
+	Confirm string `json:"confirm"`
+}
+
+// redisMemoryRequest is the body for setting the memory cap and eviction policy
+type redisMemoryRequest struct {
+	MaxMemory string `json:"maxmemory"` // e.g. 256mb, 0 for no limit
+	Policy    string `json:"policy"`    // maxmemory-policy, e.g. allkeys-lru
+}
+
+// redisMemoryResponse reports the new memory settings and whether redis saved them to its config file
+type redisMemoryResponse struct {
+	MaxMemory string `json:"maxmemory"`
+	Policy    string `json:"policy"`
+	Persisted bool   `json:"persisted"`
+	Warning   string `json:"warning,omitempty"`
+}
+
+// handleAPIRedisInfo returns a redis service's INFO with its memory figures parsed
+// GET /api/services/{id}/redis/info
+func (s *Server) handleAPIRedisInfo(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	rs, ok := s.loadRedis(w, r, service)
+	if !ok {
+		return
+	}
+	info, err := rs.Info(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, info)
+}
+
+// handleAPIRedisKeyspace lists the databases of a redis service that hold keys
+// GET /api/services/{id}/redis/keyspace
+func (s *Server) handleAPIRedisKeyspace(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	rs, ok := s.loadRedis(w, r, service)
+	if !ok {
+		return
+	}
+	keyspace, err := rs.Keyspace(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, keyspace)
+}
+
+// handleAPIRedisFlush deletes every key of one database
+// POST /api/services/{id}/redis/flush {"db","confirm"}
+func (s *Server) handleAPIRedisFlush(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req redisFlushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var fields []storage.FieldError
+	if req.DB < 0 || req.DB > maxRedisDB {
+		fields = append(fields, storage.FieldError{Field: "db", Message: fmt.Sprintf("must be between 0 and %d", maxRedisDB)})
+	}
+	if req.Confirm != service.Name {
+		fields = append(fields, storage.FieldError{Field: "confirm", Message: "must be the service's name, " + service.Name})
+	}
+	if len(fields) > 0 {
+		apiError(w, r, &storage.ValidationError{Fields: fields})
+		return
+	}
+
+	rs, ok := s.loadRedis(w, r, service)
+	if !ok {
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return rs.Flush(ctx, req.DB)
+		})
+		return
+	}
+	if err := rs.Flush(r.Context(), req.DB); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, statusResponse{Status: "flushed"})
+}
+
+// handleAPISetRedisMemory sets a redis service's maxmemory and
+// maxmemory-policy at runtime and saves them to its config file if it can
+// PUT /api/services/{id}/redis/memory {"maxmemory","policy"}
+func (s *Server) handleAPISetRedisMemory(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req redisMemoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.MaxMemory = strings.ToLower(strings.TrimSpace(req.MaxMemory))
+	if err := redis.CheckMemoryPolicy(req.MaxMemory, req.Policy); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	rs, ok := s.loadRedis(w, r, service)
+	if !ok {
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			_, err := rs.SetMemoryPolicy(ctx, req.MaxMemory, req.Policy)
+			return err
+		})
+		return
+	}
+	persisted, err := rs.SetMemoryPolicy(r.Context(), req.MaxMemory, req.Policy)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	resp := redisMemoryResponse{MaxMemory: req.MaxMemory, Policy: req.Policy, Persisted: persisted}
+	if !persisted {
+		resp.Warning = "CONFIG REWRITE failed, so the settings last until redis restarts; see the audit trail"
+	}
+	jsonResponse(w, resp)
+}
+
+// loadRedis returns the redis instance of a service. Only redis services on
+// this server qualify; it writes the error response itself.
+func (s *Server) loadRedis(w http.ResponseWriter, r *http.Request, service *storage.Service) (*redis.Server, bool) {
+	if service.Type != redis.ServiceType {
+		apiError(w, r, fmt.Errorf("%s is a %s service: %w", service.Name, service.Type, redis.ErrNotRedis))
+		return nil, false
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "redis operations"); err != nil {
+		apiError(w, r, err)
+		return nil, false
+	}
+	return redis.ForService(service), true
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"servio/internal/ansi"
+	"servio/internal/storage"
+)
+
+// maxReplicas caps how many instances a service can be scaled to
+const maxReplicas = 32
+
+// scaleRequest sets how many instances a service runs
+type scaleRequest struct {
+	Replicas int `json:"replicas"`
+}
+
+// serviceInstance is one instance of a service, as its unit reports it
+type serviceInstance struct {
+	Instance int    `json:"instance"`
+	Unit     string `json:"unit"`
+	Port     int    `json:"port,omitempty"`
+	Status   string `json:"status"` // systemctl is-active: active, inactive, failed, ...
+}
+
+// handleAPIScaleService sets how many instances a service runs, each on the
+// next port up from the service's. Instances are added or stopped and
+// removed, and running services keep running; the project's nginx site, when
+// installed, is rewritten to balance across the instances.
+// POST /api/services/{id}/scale {"replicas"}
+func (s *Server) handleAPIScaleService(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req scaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkServiceLocal(r.Context(), service, "scaling"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := checkSystemd(service, "scaling"); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	switch {
+	case req.Replicas < 1 || req.Replicas > maxReplicas:
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "replicas", Message: fmt.Sprintf("must be between 1 and %d", maxReplicas)}}})
+		return
+	case req.Replicas > 1 && service.Port <= 0:
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "replicas", Message: "needs the service to have a port, which instances count up from"}}})
+		return
+	}
+
+	scaled := *service
+	scaled.Replicas = req.Replicas
+	if req.Replicas == 1 {
+		scaled.Replicas = 0
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return s.scaleService(ctx, service, &scaled)
+		})
+		return
+	}
+	if max(service.Replicas, 1) == req.Replicas {
+		jsonResponse(w, service)
+		return
+	}
+
+	previous := *service
+	if err := s.store.SetServiceReplicas(r.Context(), service, scaled.Replicas); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.scaleService(r.Context(), &previous, service); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, service)
+}
+
+// scaleService moves a service's units from previous to scaled. A running
+// service is stopped only when it changes between one unit and instances.
+func (s *Server) scaleService(ctx context.Context, previous, scaled *storage.Service) error {
+	name := scaled.ServiceName()
+	running := s.svcManager.ActiveState(ctx, name) == "active"
+	if running && previous.Scaled() != scaled.Scaled() {
+		if err := s.svcManager.Stop(ctx, name); err != nil {
+			return err
+		}
+	}
+	if err := s.svcManager.InstallService(ctx, scaled); err != nil {
+		return err
+	}
+	if running {
+		if err := s.svcManager.Start(ctx, name); err != nil {
+			return err
+		}
+		// Starting an active service's unit leaves new instances stopped
+		for n := 1; scaled.Scaled() && n <= scaled.Replicas; n++ {
+			if err := s.svcManager.Start(ctx, scaled.InstanceName(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	project, err := s.store.GetProject(ctx, scaled.ProjectID)
+	if err != nil || project == nil {
+		return err
+	}
+	for i, svc := range project.Services {
+		if svc.ID == scaled.ID {
+			project.Services[i] = scaled
+		}
+	}
+	return s.reinstallSite(ctx, project, project.Certificate)
+}
+
+// instances lists a service's instances: its one unit, or one per replica
+func instances(service *storage.Service) []serviceInstance {
+	if !service.Scaled() {
+		return []serviceInstance{{Instance: 1, Unit: service.ServiceName(), Port: service.Port}}
+	}
+	list := make([]serviceInstance, service.Replicas)
+	for i, port := range service.InstancePorts() {
+		list[i] = serviceInstance{Instance: i + 1, Unit: service.InstanceName(i + 1), Port: port}
+	}
+	return list
+}
+
+// handleAPIListInstances lists a service's instances with their state
+// GET /api/services/{id}/instances
+func (s *Server) handleAPIListInstances(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	list := instances(service)
+	for i := range list {
+		list[i].Status = s.svcManager.ActiveState(r.Context(), list[i].Unit)
+	}
+	jsonResponse(w, list)
+}
+
+// serviceInstanceFrom returns the instance of a service a route names
+func serviceInstanceFrom(r *http.Request, service *storage.Service) (serviceInstance, bool) {
+	n, err := strconv.Atoi(r.PathValue("n"))
+	list := instances(service)
+	if err != nil || n < 1 || n > len(list) {
+		return serviceInstance{}, false
+	}
+	return list[n-1], true
+}
+
+// handleAPIRestartInstance restarts one instance of a service
+// POST /api/services/{id}/instances/{n}/restart
+func (s *Server) handleAPIRestartInstance(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	instance, ok := serviceInstanceFrom(r, service)
+	if !ok {
+		jsonError(w, r, "Instance not found", http.StatusNotFound)
+		return
+	}
+	if err := s.svcManager.Restart(r.Context(), instance.Unit); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, statusResponse{Status: "restarted"})
+}
+
+// handleAPIInstanceLogs returns one instance's most recent log lines, since
+// it last started unless since says otherwise
+// GET /api/services/{id}/instances/{n}/logs?lines=&since=&order=&ansi=
+func (s *Server) handleAPIInstanceLogs(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	instance, ok := serviceInstanceFrom(r, service)
+	if !ok {
+		jsonError(w, r, "Instance not found", http.StatusNotFound)
+		return
+	}
+	window, err := parseLogWindow(r)
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ansi.ParseMode(r.URL.Query().Get("ansi"), ansi.ModeStrip)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	lines, truncated, err := s.unitLogs(r.Context(), service, instance.Unit, window.Since, window.Lines)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if window.Newest {
+		slices.Reverse(lines)
+	}
+	jsonResponse(w, logsResponse{Logs: ansi.Apply(mode, joinLines(lines)), Counts: countLevels(lines), Truncated: truncated})
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+
+	"servio/internal/storage"
+)
+
+// handleListRevisions lists a service's configuration history, newest first
+// GET /api/services/{id}/revisions
+func (s *Server) handleListRevisions(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	revisions, err := s.store.ListServiceRevisions(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, revisions)
+}
+
+// handleGetRevision returns a single revision
+// GET /api/services/{id}/revisions/{rev}
+func (s *Server) handleGetRevision(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	if revision, ok := s.loadRevision(w, r, service); ok {
+		jsonResponse(w, revision)
+	}
+}
+
+// handleRevertRevision restores the configuration from a revision
+// POST /api/services/{id}/revisions/{rev}/revert
+func (s *Server) handleRevertRevision(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	revision, ok := s.loadRevision(w, r, service)
+	if !ok {
+		return
+	}
+
+	// The revert is applied as a regular update, so it is recorded as a new revision.
+	// Notes are documentation rather than configuration and are kept as they are,
+	// as are tags when the revision predates them.
+	req := revision.Snapshot
+	req.Notes = service.Notes
+	if req.Tags == nil {
+		req.Tags = service.Tags
+	}
+
+	updated, err := s.store.UpdateService(r.Context(), service.ID, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Reverted service configuration", "service", updated.Name, "revision", revision.ID)
+	if err := s.svcManager.InstallService(r.Context(), updated); err != nil {
+		slog.WarnContext(r.Context(), "Failed to reinstall service after revert", "error", err, "service", updated.Name)
+	}
+
+	jsonResponse(w, updated)
+}
+
+// loadRevision reads the {rev} revision of the service. It writes the error response itself.
+func (s *Server) loadRevision(w http.ResponseWriter, r *http.Request, service *storage.Service) (*storage.ServiceRevision, bool) {
+	revID, err := pathID(r, "rev")
+	if err != nil {
+		jsonError(w, r, "Invalid revision ID", http.StatusBadRequest)
+		return nil, false
+	}
+	revision, err := s.store.GetServiceRevision(r.Context(), revID)
+	if err != nil {
+		apiError(w, r, err)
+		return nil, false
+	}
+	if revision == nil || revision.ServiceID != service.ID {
+		jsonError(w, r, "Revision not found", http.StatusNotFound)
+		return nil, false
+	}
+	return revision, true
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"servio/internal/secrets"
+	"servio/internal/storage"
+)
+
+// secretRequest is the body for creating or updating a secret
+type secretRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Scope string `json:"scope"`
+}
+
+// handleAPIListSecrets lists secrets with their values masked
+// GET /api/secrets?scope=project:1
+func (s *Server) handleAPIListSecrets(w http.ResponseWriter, r *http.Request) {
+	list, err := s.store.ListSecrets(r.Context(), r.URL.Query().Get("scope"))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, list)
+}
+
+// handleAPICreateSecret creates or replaces a secret
+// POST /api/secrets {"key","value","scope"}
+func (s *Server) handleAPICreateSecret(w http.ResponseWriter, r *http.Request) {
+	var req secretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !secrets.KeyPattern.MatchString(req.Key) {
+		jsonError(w, r, "Secret key must contain only letters, digits, and underscores", http.StatusBadRequest)
+		return
+	}
+	if req.Value == "" {
+		jsonError(w, r, "Secret value is required", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = storage.SecretScopeGlobal
+	}
+	projectID, err := storage.ParseScope(req.Scope)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if projectID != 0 {
+		if project, err := s.store.GetProject(r.Context(), projectID); err != nil || project == nil {
+			jsonError(w, r, "Project not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	ciphertext, err := s.cipher.Encrypt(req.Value)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	secret, err := s.store.CreateSecret(r.Context(), req.Key, req.Scope, ciphertext)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, secret)
+}
+
+// handleAPIGetSecret returns secret metadata with the value masked
+// GET /api/secrets/{id}
+func (s *Server) handleAPIGetSecret(w http.ResponseWriter, r *http.Request) {
+	if secret, ok := s.loadSecret(w, r); ok {
+		jsonResponse(w, secret)
+	}
+}
+
+// handleAPIUpdateSecret replaces a secret's value
+// PUT /api/secrets/{id} {"value"}
+func (s *Server) handleAPIUpdateSecret(w http.ResponseWriter, r *http.Request) {
+	secret, ok := s.loadSecret(w, r)
+	if !ok {
+		return
+	}
+
+	var req secretRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Value == "" {
+		jsonError(w, r, "Secret value is required", http.StatusBadRequest)
+		return
+	}
+	ciphertext, err := s.cipher.Encrypt(req.Value)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	secret, err = s.store.UpdateSecret(r.Context(), secret.ID, ciphertext)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, secret)
+}
+
+// handleAPIDeleteSecret deletes a secret
+// DELETE /api/secrets/{id}
+func (s *Server) handleAPIDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	secret, ok := s.loadSecret(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteSecret(r.Context(), secret.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadSecret reads the {id} secret. It writes the error response itself.
+func (s *Server) loadSecret(w http.ResponseWriter, r *http.Request) (*storage.Secret, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid secret ID", http.StatusBadRequest)
+		return nil, false
+	}
+	secret, err := s.store.GetSecret(r.Context(), id)
+	if err != nil || secret == nil {
+		jsonError(w, r, "Secret not found", http.StatusNotFound)
+		return nil, false
+	}
+	return secret, true
+}
@@ -2,13 +2,32 @@ package http
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"servio/internal/activity"
+	"servio/internal/agent"
+	"servio/internal/appmetrics"
 	"servio/internal/blueprints"
+	"servio/internal/container"
+	"servio/internal/cron"
+	"servio/internal/deploy"
+	"servio/internal/envfile"
+	"servio/internal/events"
+	"servio/internal/graphql"
+	"servio/internal/jobs"
+	"servio/internal/logalert"
+	"servio/internal/logship"
 	"servio/internal/nginx"
+	"servio/internal/notify"
+	"servio/internal/secrets"
 	"servio/internal/storage"
 	"servio/internal/systemd"
+	"servio/internal/webhooks"
 )
 
 // Server represents the HTTP server
@@ -19,6 +38,39 @@ type Server struct {
 	svcManager   systemd.ServiceManager
 	blueprints   *blueprints.Registry
 	nginxManager *nginx.Manager
+	cipher       *secrets.Cipher
+	deployer     *deploy.Deployer
+	jobs         *jobs.Runner
+	events       *events.Bus
+	webhooks     *webhooks.Dispatcher
+	notifier     *notify.Dispatcher
+	activity     *activity.Recorder
+	logShipper   *logship.Shipper
+	logAlerts    *logalert.Watcher
+	cronJobs     *cron.Watcher
+	resolver     systemd.SecretResolver // resolves ${secret:NAME} in units this server writes itself
+	envFiles     *envfile.Manager
+	hosts        *agent.Registry
+	containers   *container.Router // nil in mock mode
+	static       http.Handler      // embedded assets, or files on disk in dev mode
+	limiter      *rateLimiter
+	statuses     *statusCache
+	units        *systemd.UnitWatcher // nil unless services run under systemd
+	stateChanged chan struct{}        // wakes the state watcher when units reports a change
+	auth         atomic.Pointer[authSettings]
+	authMu       sync.Mutex // serializes SetCredentials, SetAdmins, and SetSSO
+	reauthAt     sync.Map   // user → when they last re-authenticated, for power actions
+	powerControl bool       // reboot and shutdown are allowed (only under systemd)
+	socketMode   os.FileMode
+	socketGroup  string
+	backupDir    string // where database backups are written
+	certDir      string // where DNS provider credentials for certbot are kept
+	graphql      *graphql.Schema
+	appMetrics   *appmetrics.Aggregator // metrics services push, until the next sample stores them
+
+	// ctx scopes background work (webhook delivery, the state watcher) and is cancelled on Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // blueprintAdapter wraps blueprints.Registry to match systemd.BlueprintProvider
@@ -34,76 +86,389 @@ func (a *blueprintAdapter) IsManaged(serviceType string) bool {
 	return a.registry.IsManaged(serviceType)
 }
 
-// NewServer creates a new HTTP server
-func NewServer(addr string, store storage.Store, svcManager systemd.ServiceManager) *Server {
+// NewServer creates a new HTTP server. svcManager manages this server's
+// units; units of projects on agent hosts are sent to their agents.
+func NewServer(addr string, store storage.Store, svcManager systemd.ServiceManager, cipher *secrets.Cipher) *Server {
+	local := svcManager
+	hosts := agent.NewRegistry(store, cipher)
+	resolver := secrets.NewResolver(store, cipher)
+	svcManager = agent.NewRouter(local, hosts, resolver)
+
+	bus := events.NewBus()
+	runner := jobs.NewRunner(store, jobs.DefaultWorkers, bus)
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Server{
 		addr:         addr,
 		store:        store,
 		svcManager:   svcManager,
 		blueprints:   blueprints.NewRegistry(),
 		nginxManager: nginx.NewManager(),
+		cipher:       cipher,
+		deployer:     deploy.NewDeployer(store, svcManager, runner, bus),
+		jobs:         runner,
+		events:       bus,
+		webhooks:     webhooks.NewDispatcher(store, cipher),
+		notifier:     notify.NewDispatcher(store, cipher),
+		activity:     activity.NewRecorder(store),
+		logShipper:   logship.New(store),
+		logAlerts:    logalert.New(store, svcManager, bus),
+		cronJobs:     cron.NewWatcher(store, bus),
+		resolver:     resolver,
+		envFiles:     envfile.NewManager(store, cipher, resolver),
+		hosts:        hosts,
+		static:       newStaticAssets(getStaticFS()),
+		limiter:      newRateLimiter(),
+		statuses:     newStatusCache(),
+		appMetrics:   appmetrics.NewAggregator(),
+		stateChanged: make(chan struct{}, 1),
+		socketMode:   defaultSocketMode,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	s.auth.Store(&authSettings{username: os.Getenv("SERVIO_USERNAME"), password: os.Getenv("SERVIO_PASSWORD")})
+	bus.Subscribe(s.webhooks.Handle)
+	bus.Subscribe(s.notifier.Handle)
+	bus.Subscribe(s.activity.Handle)
+	s.deployer.SetEnvSyncer(s.envFiles)
+	s.deployer.SetAssetBuilder(s.blueprints)
+	s.nginxManager.SetStatics(s.serviceStatics)
+	s.graphql = s.graphqlSchema()
+
+	if router, ok := local.(*container.Router); ok {
+		s.containers = router
 	}
 
 	// Set blueprints on the service manager if it supports it
-	if mgr, ok := svcManager.(*systemd.Manager); ok {
+	if mgr, ok := local.(interface {
+		SetBlueprints(systemd.BlueprintProvider)
+	}); ok {
 		adapter := &blueprintAdapter{registry: s.blueprints}
 		mgr.SetBlueprints(adapter)
 	}
 
 	// Initial distro configuration from settings
-	if distro, err := store.GetSetting(context.Background(), "distro"); err == nil && distro != "" {
+	if distro, err := store.GetSetting(context.Background(), storage.SettingDistro); err == nil && distro != "" {
 		s.nginxManager.Configure(distro)
 	}
+	if err := s.loadNginxNetwork(context.Background()); err != nil {
+		slog.Error("Failed to load nginx network settings", "error", err)
+	}
+	if err := s.loadWildcards(context.Background()); err != nil {
+		slog.Error("Failed to load wildcard certificates", "error", err)
+	}
+	if err := s.loadBlueprintDefinitions(context.Background()); err != nil {
+		slog.Error("Failed to load installed blueprints", "error", err)
+	}
 
 	mux := http.NewServeMux()
 	s.registerRoutes(mux)
 
 	s.httpServer = &http.Server{
 		Addr:         addr,
-		Handler:      BasicAuth(Logger(CORS(mux))),
+		Handler:      BasePath(RequestID(Localize(Logger(Limits(Gzip(s.BasicAuth(s.TeamScope(s.limiter.RateLimit(CORS(DryRun(mux))))))))))),
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: 15 * time.Second, // replaced per request by Limits
 		IdleTimeout:  60 * time.Second,
 	}
 
 	return s
 }
 
-// registerRoutes sets up all routes
+// SetNginxDirs overrides where nginx sites are written (see nginx.Manager.SetDirs).
+// It is safe to call while the server is running.
+func (s *Server) SetNginxDirs(sitesDir, enabledDir string) {
+	s.nginxManager.SetDirs(sitesDir, enabledDir)
+}
+
+// SetBackupDir sets the directory database backups are written to, one
+// subdirectory per project. It is bound when the process starts.
+func (s *Server) SetBackupDir(dir string) {
+	s.backupDir = dir
+}
+
+// registerRoutes sets up all routes. Patterns carry the method, so the mux
+// answers 405 for unsupported methods; {id} wildcards are resolved by the
+// apiProject/apiService (or uiProject/uiService) loaders.
 func (s *Server) registerRoutes(mux *http.ServeMux) {
 	// UI routes
-	mux.HandleFunc("/", s.handleDashboard)
-	mux.HandleFunc("/projects/new", s.handleNewProject)
-	mux.HandleFunc("/projects/", s.handleProjectDetail)
-	mux.HandleFunc("/services/new", s.handleNewService)
-	mux.HandleFunc("/services/", s.handleServiceDetail)
-
-	// API routes
-	mux.HandleFunc("/api/projects", s.handleAPIProjects)
-	mux.HandleFunc("/api/projects/", s.handleAPIProject)
-	mux.HandleFunc("/api/services", s.handleAPIServices)
-	mux.HandleFunc("/api/services/", s.handleAPIService)
-	mux.HandleFunc("/api/stats", s.handleAPIStats)
-	mux.HandleFunc("/api/blueprints", s.handleAPIBlueprints)
-	mux.HandleFunc("/api/nginx/", s.handleAPINginx)
-	mux.HandleFunc("/api/settings/", s.handleAPISettings)
-
-	// Static assets with no-cache headers
-	staticHandler := http.StripPrefix("/static/", http.FileServer(http.FS(getStaticFS())))
-	mux.Handle("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		w.Header().Set("Pragma", "no-cache")
-		w.Header().Set("Expires", "0")
-		staticHandler.ServeHTTP(w, r)
-	}))
+	mux.HandleFunc("GET /{$}", s.handleDashboard)
+	mux.HandleFunc("GET /projects/new", s.handleNewProject)
+	mux.HandleFunc("POST /projects/new", s.handleCreateProject)
+	mux.HandleFunc("GET /projects/{id}", s.uiProject(s.handleProjectDetail))
+	mux.HandleFunc("GET /projects/{id}/edit", s.uiProject(s.handleEditProject))
+	mux.HandleFunc("POST /projects/{id}/edit", s.uiProject(s.handleUpdateProject))
+	mux.HandleFunc("POST /projects/{id}/delete", s.uiProject(s.handleDeleteProject))
+	mux.HandleFunc("GET /services/new", s.handleNewService)
+	mux.HandleFunc("POST /services/new", s.handleCreateService)
+	mux.HandleFunc("GET /services/{id}", s.uiService(s.handleServiceDetail))
+	mux.HandleFunc("GET /services/{id}/edit", s.uiService(s.handleEditService))
+	mux.HandleFunc("POST /services/{id}/edit", s.uiService(s.handleUpdateService))
+	mux.HandleFunc("GET /projects/{id}/nginx-logs/{kind}", s.uiProject(s.handleNginxLogs))
+	mux.HandleFunc("GET /services/{id}/logs", s.uiService(s.handleServiceLogs))
+	mux.HandleFunc("GET /activity", s.handleActivity)
+	mux.HandleFunc("POST /services/{id}/{action}", s.uiService(s.handleServiceAction))
+
+	// Projects
+	mux.HandleFunc("GET /api/projects", s.handleAPIListProjects)
+	mux.HandleFunc("POST /api/projects", s.handleAPICreateProject)
+	mux.HandleFunc("GET /api/stacks", s.handleAPIListStacks)
+	mux.HandleFunc("POST /api/stacks/{name}", s.handleAPICreateStack)
+	mux.HandleFunc("GET /api/projects/{id}", s.apiProject(s.handleAPIGetProject))
+	mux.HandleFunc("PUT /api/projects/{id}", s.apiProject(s.handleAPIUpdateProject))
+	mux.HandleFunc("PATCH /api/projects/{id}", s.apiProject(s.handleAPIPatchProject))
+	mux.HandleFunc("DELETE /api/projects/{id}", s.apiProject(s.handleAPIDeleteProject))
+	mux.HandleFunc("POST /api/projects/{id}/start", s.apiProject(s.handleAPIProjectControl("start")))
+	mux.HandleFunc("POST /api/projects/{id}/stop", s.apiProject(s.handleAPIProjectControl("stop")))
+	mux.HandleFunc("POST /api/projects/{id}/restart", s.apiProject(s.handleAPIProjectControl("restart")))
+	mux.HandleFunc("POST /api/projects/{id}/clone", s.apiProject(s.handleAPICloneProject))
+	mux.HandleFunc("PUT /api/projects/{id}/team", s.apiProject(s.handleAPISetProjectTeam))
+	mux.HandleFunc("PUT /api/projects/{id}/host", s.apiProject(s.handleAPISetProjectHost))
+	mux.HandleFunc("GET /api/projects/{id}/logs/stream", s.apiProject(s.handleProjectLogStream))
+	mux.HandleFunc("GET /api/projects/{id}/cron-jobs", s.apiProject(s.handleAPIListCronJobs))
+	mux.HandleFunc("POST /api/projects/{id}/cron-jobs", s.apiProject(s.handleAPICreateCronJob))
+	mux.HandleFunc("GET /api/projects/{id}/cron-jobs/{job}", s.apiProject(s.handleAPIGetCronJob))
+	mux.HandleFunc("PUT /api/projects/{id}/cron-jobs/{job}", s.apiProject(s.handleAPIUpdateCronJob))
+	mux.HandleFunc("DELETE /api/projects/{id}/cron-jobs/{job}", s.apiProject(s.handleAPIDeleteCronJob))
+	mux.HandleFunc("POST /api/projects/{id}/cron-jobs/{job}/run", s.apiProject(s.handleAPIRunCronJob))
+	mux.HandleFunc("GET /api/projects/{id}/cron-jobs/{job}/logs", s.apiProject(s.handleAPICronJobLogs))
+	mux.HandleFunc("GET /api/projects/{id}/database-backups", s.apiProject(s.handleAPIProjectDatabaseBackups))
+	mux.HandleFunc("GET /api/projects/{id}/budget", s.apiProject(s.handleAPIGetProjectBudget))
+	mux.HandleFunc("PUT /api/projects/{id}/budget", s.apiProject(s.handleAPISetProjectBudget))
+	mux.HandleFunc("DELETE /api/projects/{id}/budget", s.apiProject(s.handleAPIDeleteProjectBudget))
+	mux.HandleFunc("GET /api/projects/{id}/plan", s.apiProject(s.handleAPIProjectPlan))
+	mux.HandleFunc("POST /api/projects/{id}/plan/apply", s.apiProject(s.handleAPIApplyProjectPlan))
+
+	// Service templates
+	mux.HandleFunc("GET /api/service-templates", s.handleAPIListServiceTemplates)
+	mux.HandleFunc("POST /api/service-templates", s.handleAPICreateServiceTemplate)
+	mux.HandleFunc("GET /api/service-templates/{id}", s.handleAPIGetServiceTemplate)
+	mux.HandleFunc("PUT /api/service-templates/{id}", s.handleAPIUpdateServiceTemplate)
+	mux.HandleFunc("DELETE /api/service-templates/{id}", s.handleAPIDeleteServiceTemplate)
+	mux.HandleFunc("POST /api/service-templates/{id}/services", s.handleAPICreateTemplateService)
+
+	// Services
+	mux.HandleFunc("GET /api/services", s.handleAPIListServices)
+	mux.HandleFunc("POST /api/services", s.handleAPICreateService)
+	mux.HandleFunc("POST /api/services/actions", s.handleAPIServiceActions)
+	mux.HandleFunc("GET /api/services/{id}", s.apiService(s.handleAPIGetService))
+	mux.HandleFunc("PUT /api/services/{id}", s.apiService(s.handleAPIUpdateService))
+	mux.HandleFunc("PATCH /api/services/{id}", s.apiService(s.handleAPIPatchService))
+	mux.HandleFunc("DELETE /api/services/{id}", s.apiService(s.handleAPIDeleteService))
+	mux.HandleFunc("POST /api/services/{id}/start", s.apiService(s.handleAPIServiceControl("started", s.svcManager.Start)))
+	mux.HandleFunc("POST /api/services/{id}/stop", s.apiService(s.handleAPIServiceControl("stopped", s.svcManager.Stop)))
+	mux.HandleFunc("POST /api/services/{id}/restart", s.apiService(s.handleAPIServiceControl("restarted", s.svcManager.Restart)))
+	mux.HandleFunc("POST /api/services/{id}/install", s.apiService(s.handleAPIInstallService))
+	mux.HandleFunc("POST /api/services/{id}/template", s.apiService(s.handleAPISaveServiceTemplate))
+	mux.HandleFunc("POST /api/services/{id}/provision", s.apiService(s.handleAPIProvisionService))
+	mux.HandleFunc("POST /api/services/{id}/scale", s.apiService(s.handleAPIScaleService))
+	mux.HandleFunc("GET /api/services/{id}/instances", s.apiService(s.handleAPIListInstances))
+	mux.HandleFunc("POST /api/services/{id}/instances/{n}/restart", s.apiService(s.handleAPIRestartInstance))
+	mux.HandleFunc("GET /api/services/{id}/instances/{n}/logs", s.apiService(s.handleAPIInstanceLogs))
+	mux.HandleFunc("GET /api/services/{id}/logs", s.apiService(s.handleAPIServiceLogs))
+	mux.HandleFunc("GET /api/services/{id}/app-metrics", s.apiService(s.handleAPIServiceAppMetrics))
+	mux.HandleFunc("GET /api/services/{id}/logs/stream", s.apiService(s.handleLogStream))
+	mux.HandleFunc("GET /api/services/{id}/logs/download", s.apiService(s.handleAPIDownloadServiceLogs))
+	mux.HandleFunc("GET /api/services/{id}/support-bundle", s.apiService(s.handleAPISupportBundle))
+	mux.HandleFunc("GET /api/services/{id}/revisions", s.apiService(s.handleListRevisions))
+	mux.HandleFunc("GET /api/services/{id}/revisions/{rev}", s.apiService(s.handleGetRevision))
+	mux.HandleFunc("POST /api/services/{id}/revisions/{rev}/revert", s.apiService(s.handleRevertRevision))
+	mux.HandleFunc("GET /api/services/{id}/deployments", s.apiService(s.handleListDeployments))
+	mux.HandleFunc("POST /api/services/{id}/deployments", s.apiService(s.handleStartDeployment))
+	mux.HandleFunc("GET /api/services/{id}/deployments/{dep}", s.apiService(s.handleGetDeployment))
+	mux.HandleFunc("DELETE /api/services/{id}/deployments/{dep}", s.apiService(s.handleDeleteDeployment))
+
+	// Background jobs
+	mux.HandleFunc("GET /api/jobs", s.handleAPIListJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", s.handleAPIGetJob)
+	mux.HandleFunc("GET /api/jobs/{id}/log", s.handleAPIJobLog)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.handleAPICancelJob)
+	mux.HandleFunc("GET /api/jobs/{id}/stream", s.handleAPIJobStream)
+
+	// Live events
+	mux.HandleFunc("GET /api/events", s.handleAPIEvents)
+	mux.HandleFunc("GET /api/services/{id}/audit", s.apiService(s.handleServiceAudit))
+	mux.HandleFunc("GET /api/services/{id}/journal-retention", s.apiService(s.handleAPIGetJournalRetention))
+	mux.HandleFunc("PUT /api/services/{id}/journal-retention", s.apiService(s.handleAPISetJournalRetention))
+	mux.HandleFunc("DELETE /api/services/{id}/journal-retention", s.apiService(s.handleAPIDeleteJournalRetention))
+	mux.HandleFunc("GET /api/services/{id}/file-logging", s.apiService(s.handleAPIGetFileLogging))
+	mux.HandleFunc("PUT /api/services/{id}/file-logging", s.apiService(s.handleAPISetFileLogging))
+	mux.HandleFunc("DELETE /api/services/{id}/file-logging", s.apiService(s.handleAPIDeleteFileLogging))
+	mux.HandleFunc("GET /api/services/{id}/log-forwarding", s.apiService(s.handleAPIGetLogForwarding))
+	mux.HandleFunc("PUT /api/services/{id}/log-forwarding", s.apiService(s.handleAPISetLogForwarding))
+	mux.HandleFunc("GET /api/services/{id}/log-alerts", s.apiService(s.handleAPIListLogAlerts))
+	mux.HandleFunc("POST /api/services/{id}/log-alerts", s.apiService(s.handleAPICreateLogAlert))
+	mux.HandleFunc("PUT /api/services/{id}/log-alerts/{alert}", s.apiService(s.handleAPIUpdateLogAlert))
+	mux.HandleFunc("DELETE /api/services/{id}/log-alerts/{alert}", s.apiService(s.handleAPIDeleteLogAlert))
+	mux.HandleFunc("GET /api/services/{id}/log-incidents", s.apiService(s.handleAPIListLogIncidents))
+	mux.HandleFunc("GET /api/services/{id}/postgres/databases", s.apiService(s.handleAPIPostgresDatabases))
+	mux.HandleFunc("POST /api/services/{id}/postgres/databases", s.apiService(s.handleAPICreatePostgresDatabase))
+	mux.HandleFunc("GET /api/services/{id}/postgres/roles", s.apiService(s.handleAPIPostgresRoles))
+	mux.HandleFunc("POST /api/services/{id}/postgres/roles", s.apiService(s.handleAPICreatePostgresRole))
+	mux.HandleFunc("POST /api/services/{id}/postgres/roles/{role}/password", s.apiService(s.handleAPIResetPostgresPassword))
+	mux.HandleFunc("GET /api/services/{id}/postgres/backups", s.apiService(s.handleAPIPostgresBackups))
+	mux.HandleFunc("POST /api/services/{id}/postgres/backups", s.apiService(s.handleAPICreatePostgresBackup))
+	mux.HandleFunc("GET /api/services/{id}/postgres/backups/{backup}/download", s.apiService(s.handleAPIDownloadPostgresBackup))
+	mux.HandleFunc("GET /api/services/{id}/redis/info", s.apiService(s.handleAPIRedisInfo))
+	mux.HandleFunc("GET /api/services/{id}/redis/keyspace", s.apiService(s.handleAPIRedisKeyspace))
+	mux.HandleFunc("POST /api/services/{id}/redis/flush", s.apiService(s.handleAPIRedisFlush))
+	mux.HandleFunc("PUT /api/services/{id}/redis/memory", s.apiService(s.handleAPISetRedisMemory))
+	mux.HandleFunc("GET /api/services/{id}/worker", s.apiService(s.handleAPIGetWorker))
+	mux.HandleFunc("PUT /api/services/{id}/worker", s.apiService(s.handleAPIScaleWorker))
+
+	// Managed .env files
+	mux.HandleFunc("GET /api/services/{id}/env", s.apiService(s.handleAPIServiceEnv))
+	mux.HandleFunc("PUT /api/services/{id}/env/vars/{key}", s.apiService(s.handleAPISetEnvVar))
+	mux.HandleFunc("DELETE /api/services/{id}/env/vars/{key}", s.apiService(s.handleAPIDeleteEnvVar))
+	mux.HandleFunc("GET /api/projects/{id}/env", s.apiProject(s.handleAPIProjectEnv))
+	mux.HandleFunc("PUT /api/projects/{id}/env/vars/{key}", s.apiProject(s.handleAPISetProjectEnvVar))
+	mux.HandleFunc("DELETE /api/projects/{id}/env/vars/{key}", s.apiProject(s.handleAPIDeleteProjectEnvVar))
+	mux.HandleFunc("PUT /api/services/{id}/env/path", s.apiService(s.handleAPISetEnvPath))
+	mux.HandleFunc("POST /api/services/{id}/env/sync", s.apiService(s.handleAPISyncEnv))
+	mux.HandleFunc("GET /api/services/{id}/env/drift", s.apiService(s.handleAPIEnvDrift))
+	mux.HandleFunc("POST /api/services/{id}/exec", s.apiService(s.handleAPIServiceExec))
+
+	// Nginx
+	mux.HandleFunc("GET /api/nginx/{id}/preview", s.apiProject(s.handleAPINginxPreview))
+	mux.HandleFunc("POST /api/nginx/{id}/save", s.apiProject(s.handleAPINginxSave))
+	mux.HandleFunc("POST /api/nginx/{id}/deploy", s.apiProject(s.handleAPINginxDeploy))
+	mux.HandleFunc("POST /api/nginx/{id}/remove", s.apiProject(s.handleAPINginxRemove))
+	mux.HandleFunc("GET /api/nginx/{id}/certificate", s.apiProject(s.handleAPIGetCertificate))
+	mux.HandleFunc("POST /api/nginx/{id}/certificate", s.apiProject(s.handleAPIIssueCertificate))
+	mux.HandleFunc("DELETE /api/nginx/{id}/certificate", s.apiProject(s.handleAPIDeleteCertificate))
+	mux.HandleFunc("GET /api/nginx/{id}/protocols", s.apiProject(s.handleAPIGetSiteProtocols))
+	mux.HandleFunc("PUT /api/nginx/{id}/protocols", s.apiProject(s.handleAPISetSiteProtocols))
+	mux.HandleFunc("GET /api/nginx/{id}/logs/{kind}", s.apiProject(s.handleAPINginxLogs))
+	mux.HandleFunc("GET /api/nginx/{id}/logs/{kind}/stream", s.apiProject(s.handleAPINginxLogStream))
+
+	// Secrets
+	mux.HandleFunc("GET /api/secrets", s.handleAPIListSecrets)
+	mux.HandleFunc("POST /api/secrets", s.handleAPICreateSecret)
+	mux.HandleFunc("GET /api/secrets/{id}", s.handleAPIGetSecret)
+	mux.HandleFunc("PUT /api/secrets/{id}", s.handleAPIUpdateSecret)
+	mux.HandleFunc("DELETE /api/secrets/{id}", s.handleAPIDeleteSecret)
+
+	// Webhooks
+	mux.HandleFunc("GET /api/webhooks", s.handleAPIListWebhooks)
+	mux.HandleFunc("POST /api/webhooks", s.handleAPICreateWebhook)
+	mux.HandleFunc("GET /api/webhooks/{id}", s.handleAPIGetWebhook)
+	mux.HandleFunc("PUT /api/webhooks/{id}", s.handleAPIUpdateWebhook)
+	mux.HandleFunc("DELETE /api/webhooks/{id}", s.handleAPIDeleteWebhook)
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", s.handleAPIListWebhookDeliveries)
+	mux.HandleFunc("POST /api/webhooks/{id}/test", s.handleAPITestWebhook)
+
+	// Notification channels
+	mux.HandleFunc("GET /api/notification-channels", s.handleAPIListNotificationChannels)
+	mux.HandleFunc("POST /api/notification-channels", s.handleAPICreateNotificationChannel)
+	mux.HandleFunc("GET /api/notification-channels/{id}", s.handleAPIGetNotificationChannel)
+	mux.HandleFunc("PUT /api/notification-channels/{id}", s.handleAPIUpdateNotificationChannel)
+	mux.HandleFunc("DELETE /api/notification-channels/{id}", s.handleAPIDeleteNotificationChannel)
+	mux.HandleFunc("GET /api/notification-channels/{id}/deliveries", s.handleAPIListNotificationDeliveries)
+	mux.HandleFunc("POST /api/notification-channels/{id}/test", s.handleAPITestNotificationChannel)
+
+	// Teams (writes are admin-only, see TeamScope)
+	mux.HandleFunc("GET /api/teams", s.handleAPIListTeams)
+	mux.HandleFunc("POST /api/teams", s.handleAPICreateTeam)
+	mux.HandleFunc("GET /api/teams/{id}", s.handleAPIGetTeam)
+	mux.HandleFunc("PUT /api/teams/{id}", s.handleAPIUpdateTeam)
+	mux.HandleFunc("DELETE /api/teams/{id}", s.handleAPIDeleteTeam)
+
+	// Agent hosts (admin-only, see TeamScope); agents join with the agent token, see publicPaths
+	mux.HandleFunc("GET /api/hosts", s.handleAPIListHosts)
+	mux.HandleFunc("DELETE /api/hosts/{id}", s.handleAPIDeleteHost)
+	mux.HandleFunc("POST "+agent.RegisterPath, s.handleAPIRegisterAgent)
+
+	// Wildcard certificates shared by project sites
+	mux.HandleFunc("GET /api/certificates", s.handleAPIListWildcardCertificates)
+	mux.HandleFunc("POST /api/certificates", s.handleAPIIssueWildcardCertificate)
+	mux.HandleFunc("DELETE /api/certificates/{id}", s.handleAPIDeleteWildcardCertificate)
+
+	// Settings (the dashboard form POSTs)
+	mux.HandleFunc("GET /api/settings", s.handleAPISettingsList)
+	mux.HandleFunc("GET /api/settings/{key}", s.handleAPIGetSetting)
+	mux.HandleFunc("PUT /api/settings/{key}", s.handleAPISetSetting)
+	mux.HandleFunc("POST /api/settings/{key}", s.handleAPISetSetting)
+
+	// System
+	mux.HandleFunc("GET /api/stats", s.handleAPIStats)
+	mux.HandleFunc("GET /api/blueprints", s.handleAPIBlueprints)
+	mux.HandleFunc("GET /api/blueprints/catalog", s.handleAPIBlueprintCatalog)
+	mux.HandleFunc("POST /api/blueprints/catalog/{type}/install", s.handleAPIInstallCatalogBlueprint)
+	mux.HandleFunc("DELETE /api/blueprints/{type}", s.handleAPIDeleteBlueprint)
+	mux.HandleFunc("GET /api/system-users/{name}", s.handleAPIGetSystemUser)
+	mux.HandleFunc("GET /api/search", s.handleAPISearch)
+	mux.HandleFunc("GET /api/export/inventory", s.handleAPIExportInventory)
+	mux.HandleFunc("GET /api/export/metrics", s.handleAPIExportMetrics)
+	mux.HandleFunc("GET /api/export/config", s.handleAPIExportConfig)
+	mux.HandleFunc("GET /api/audit", s.handleAPIAudit)
+	mux.HandleFunc("GET /api/activity", s.handleAPIActivity)
+	mux.HandleFunc("GET /api/system/doctor", s.handleAPIDoctor)
+	mux.HandleFunc("GET /api/system/journal", s.handleAPIJournalUsage)
+	mux.HandleFunc("POST /api/system/journal/vacuum", s.handleAPIJournalVacuum)
+	mux.HandleFunc("GET /api/system/log-forwarding", s.handleAPILogForwardingStatus)
+	mux.HandleFunc("GET /api/system/artifacts", s.handleAPIListArtifacts)
+	mux.HandleFunc("GET /api/system/artifacts/content", s.handleAPIArtifactContent)
+	mux.HandleFunc("GET /api/system/artifacts/archive", s.handleAPIArtifactArchive)
+	mux.HandleFunc("POST /api/system/reconcile", s.handleAPIReconcile)
+	mux.HandleFunc("GET /api/system/updates", s.handleAPIListUpdates)
+	mux.HandleFunc("POST /api/system/updates", s.handleAPIApplyUpdates)
+	mux.HandleFunc("POST /api/system/updates/refresh", s.handleAPIRefreshUpdates)
+	mux.HandleFunc("GET /api/system/power", s.handleAPIPowerInfo)
+	mux.HandleFunc("POST /api/system/reboot", s.handleAPIPower(systemd.PowerReboot))
+	mux.HandleFunc("POST /api/system/shutdown", s.handleAPIPower(systemd.PowerShutdown))
+	mux.HandleFunc("POST /api/auth/reauthenticate", s.handleAPIReauthenticate)
+	mux.HandleFunc("GET "+ssoLoginPath, s.handleSSOLogin)
+	mux.HandleFunc("GET "+ssoCallbackPath, s.handleSSOCallback)
+	mux.HandleFunc("POST "+ssoLogoutPath, s.handleSSOLogout)
+	mux.HandleFunc("GET "+ssoBasicPath, s.handleBasicLogin)
+	mux.HandleFunc("GET /api/admin/integrity", s.handleAPIIntegrity)
+	mux.HandleFunc("POST /api/admin/integrity/repair", s.handleAPIIntegrityRepair)
+	mux.HandleFunc("GET /api/openapi.json", s.handleAPIOpenAPI)
+	mux.HandleFunc("GET /api/docs", s.handleAPIDocs)
+
+	// Multiplexed logs, deploy output, and status changes
+	mux.HandleFunc("GET /ws", s.handleWebSocket)
+
+	// GraphQL over the same data as the API (see graphql.go)
+	mux.HandleFunc("GET "+graphqlPath, s.handleGraphQL)
+	mux.HandleFunc("POST "+graphqlPath, s.handleGraphQL)
+	mux.HandleFunc("GET "+graphqlSchemaPath, s.handleGraphQLSchema)
+
+	// Health (unauthenticated, see publicPaths)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	// Static assets, hashed and pre-compressed (see static.go), or read from disk in dev mode (dev.go)
+	mux.HandleFunc("GET /static/", func(w http.ResponseWriter, r *http.Request) { s.static.ServeHTTP(w, r) })
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP(S) server on a TCP address or unix socket (see listen)
 func (s *Server) Start() error {
-	return s.httpServer.ListenAndServe()
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	go s.webhooks.Run(s.ctx)
+	go s.notifier.Run(s.ctx)
+	go s.activity.Run(s.ctx)
+	if s.units != nil {
+		go s.units.Run(s.ctx)
+	}
+	go s.watchServiceStates(s.ctx)
+	go s.recordMetrics(s.ctx)
+	go s.enforceJournalRetention(s.ctx)
+	go s.logShipper.Run(s.ctx)
+	go s.logAlerts.Run(s.ctx)
+	go s.cronJobs.Run(s.ctx)
+	go s.hosts.Run(s.ctx)
+	if s.httpServer.TLSConfig != nil {
+		// The certificate is already loaded into TLSConfig (see ConfigureTLS)
+		return s.httpServer.ServeTLS(ln, "", "")
+	}
+	return s.httpServer.Serve(ln)
 }
 
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
 	return s.httpServer.Shutdown(ctx)
 }
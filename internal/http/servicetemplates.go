@@ -0,0 +1,520 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"servio/internal/envfile"
+	"servio/internal/stacks"
+	"servio/internal/storage"
+)
+
+// templatePlaceholder matches the {{name}} placeholders of a service template
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Placeholders filled in for every service created from a template
+const (
+	placeholderService = "service" // the new service's name
+	placeholderProject = "project" // its project's slug
+	placeholderPort    = "port"    // its port
+)
+
+// maxTemplateName bounds the length of a template's name
+const maxTemplateName = 64
+
+// serviceTemplateRequest is the body for creating or replacing a template
+type serviceTemplateRequest struct {
+	Name        string                      `json:"name"`
+	Description string                      `json:"description"`
+	Spec        storage.ServiceTemplateSpec `json:"spec"`
+}
+
+// saveTemplateRequest is the body for saving a service as a template
+type saveTemplateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// templateServiceRequest is the body for creating a service from a template
+type templateServiceRequest struct {
+	ProjectID int64             `json:"project_id"`
+	Name      string            `json:"name"`
+	Port      int               `json:"port"`   // 0 for the first free port from the template's
+	Params    map[string]string `json:"params"` // values for the template's own placeholders
+	// Create the user or working directory when missing, as for POST /api/services
+	CreateUser       bool `json:"create_user,omitempty"`
+	CreateWorkingDir bool `json:"create_working_dir,omitempty"`
+}
+
+// serviceTemplateResponse is a template with the placeholders it needs filled in
+type serviceTemplateResponse struct {
+	*storage.ServiceTemplate
+	Params []string `json:"params"` // besides service, project, and port
+}
+
+// validate trims the request and checks its name and variables
+func (req *serviceTemplateRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Spec.Type == "" {
+		req.Spec.Type = "custom"
+	}
+	var fields []storage.FieldError
+	if req.Name == "" || len(req.Name) > maxTemplateName {
+		fields = append(fields, storage.FieldError{Field: "name", Message: fmt.Sprintf("must be 1-%d characters", maxTemplateName)})
+	}
+	for _, v := range req.Spec.EnvVars {
+		if err := envfile.CheckKey(v.Key); err != nil {
+			fields = append(fields, storage.FieldError{Field: "spec.env_vars", Message: err.Error()})
+		}
+	}
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// templateParams lists the placeholders of spec other than the built-in
+// ones, in order of first use
+func templateParams(spec *storage.ServiceTemplateSpec) []string {
+	params := []string{}
+	for _, field := range templateFields(spec) {
+		for _, m := range templatePlaceholder.FindAllStringSubmatch(*field, -1) {
+			if name := m[1]; !isBuiltinPlaceholder(name) && !slices.Contains(params, name) {
+				params = append(params, name)
+			}
+		}
+	}
+	return params
+}
+
+func isBuiltinPlaceholder(name string) bool {
+	return name == placeholderService || name == placeholderProject || name == placeholderPort
+}
+
+// templateFields returns the fields of spec that may hold placeholders
+func templateFields(spec *storage.ServiceTemplateSpec) []*string {
+	fields := []*string{&spec.Version, &spec.Image, &spec.GitRepoURL, &spec.GitBranch, &spec.Command, &spec.WorkingDir,
+		&spec.User, &spec.Environment, &spec.Config, &spec.SystemdRaw, &spec.NginxRaw}
+	for i := range spec.EnvVars {
+		fields = append(fields, &spec.EnvVars[i].Value)
+	}
+	return fields
+}
+
+// fillTemplate replaces the placeholders of spec with values, reporting the
+// ones without a value as a validation error
+func fillTemplate(spec *storage.ServiceTemplateSpec, values map[string]string) error {
+	var missing []storage.FieldError
+	for _, field := range templateFields(spec) {
+		*field = templatePlaceholder.ReplaceAllStringFunc(*field, func(placeholder string) string {
+			name := templatePlaceholder.FindStringSubmatch(placeholder)[1]
+			value, ok := values[name]
+			if !ok && !slices.ContainsFunc(missing, func(f storage.FieldError) bool { return f.Field == "params."+name }) {
+				missing = append(missing, storage.FieldError{Field: "params." + name, Message: "is required by the template"})
+			}
+			return value
+		})
+	}
+	if len(missing) > 0 {
+		return &storage.ValidationError{Fields: missing}
+	}
+	return nil
+}
+
+// templateFromService captures a service as a template spec. Its name, its
+// project's slug, and its port become placeholders wherever they appear as
+// words, and secret variables become a placeholder named after their key,
+// so no secret value is saved.
+func templateFromService(service *storage.Service, project *storage.Project, vars []*storage.EnvVar) storage.ServiceTemplateSpec {
+	type rewrite struct {
+		re          *regexp.Regexp
+		placeholder string
+	}
+	word := func(s string) *regexp.Regexp { return regexp.MustCompile(`\b` + regexp.QuoteMeta(s) + `\b`) }
+	// The service's name usually starts with the slug, so it goes first
+	rewrites := []rewrite{{word(service.Name), "{{" + placeholderService + "}}"}}
+	if slug := stacks.Slug(project.Name); slug != service.Name {
+		rewrites = append(rewrites, rewrite{word(slug), "{{" + placeholderProject + "}}"})
+	}
+	if service.Port > 0 {
+		rewrites = append(rewrites, rewrite{word(strconv.Itoa(service.Port)), "{{" + placeholderPort + "}}"})
+	}
+	parameterize := func(text string) string {
+		for _, r := range rewrites {
+			text = r.re.ReplaceAllLiteralString(text, r.placeholder)
+		}
+		return text
+	}
+
+	spec := storage.ServiceTemplateSpec{
+		Type:        service.Type,
+		Version:     service.Version,
+		Runtime:     service.Runtime,
+		Image:       service.Image,
+		Port:        service.Port,
+		GitRepoURL:  service.GitRepoURL,
+		GitBranch:   service.GitBranch,
+		GitFilter:   service.GitFilter,
+		Command:     parameterize(service.Command),
+		WorkingDir:  parameterize(service.WorkingDir),
+		User:        service.User,
+		Environment: parameterize(service.Environment),
+		AutoRestart: service.AutoRestart,
+		Config:      parameterize(service.Config),
+		SystemdRaw:  parameterize(service.SystemdRaw),
+		NginxRaw:    parameterize(service.NginxRaw),
+		Tags:        service.Tags,
+	}
+	for _, v := range vars {
+		value := "{{" + v.Key + "}}"
+		if !v.Secret {
+			value = parameterize(v.Value)
+		}
+		spec.EnvVars = append(spec.EnvVars, storage.TemplateEnvVar{Key: v.Key, Value: value, Secret: v.Secret})
+	}
+	return spec
+}
+
+// serviceFromTemplate fills in spec for a service named name in project and
+// returns the service to create with its managed variables, secrets encrypted
+func (s *Server) serviceFromTemplate(spec storage.ServiceTemplateSpec, project *storage.Project, name string, port int, params map[string]string) (*storage.CreateServiceRequest, []*storage.EnvVar, error) {
+	spec.EnvVars = slices.Clone(spec.EnvVars)
+	values := map[string]string{
+		placeholderService: name,
+		placeholderProject: stacks.Slug(project.Name),
+		placeholderPort:    strconv.Itoa(port),
+	}
+	for key, value := range params {
+		if !isBuiltinPlaceholder(key) {
+			values[key] = value
+		}
+	}
+	if err := fillTemplate(&spec, values); err != nil {
+		return nil, nil, err
+	}
+
+	req := &storage.CreateServiceRequest{
+		ProjectID:   project.ID,
+		Name:        name,
+		Type:        spec.Type,
+		Version:     spec.Version,
+		Runtime:     spec.Runtime,
+		Image:       spec.Image,
+		Port:        port,
+		GitRepoURL:  spec.GitRepoURL,
+		GitBranch:   spec.GitBranch,
+		GitFilter:   spec.GitFilter,
+		Command:     spec.Command,
+		WorkingDir:  spec.WorkingDir,
+		User:        spec.User,
+		Environment: spec.Environment,
+		AutoRestart: spec.AutoRestart,
+		Config:      spec.Config,
+		SystemdRaw:  spec.SystemdRaw,
+		NginxRaw:    spec.NginxRaw,
+		Tags:        spec.Tags,
+	}
+	vars := make([]*storage.EnvVar, len(spec.EnvVars))
+	for i, tv := range spec.EnvVars {
+		vars[i] = &storage.EnvVar{Key: tv.Key, Value: tv.Value, Secret: tv.Secret}
+		if err := s.envFiles.Encrypt(vars[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return req, vars, nil
+}
+
+// createService checks a new service against the host and creates it with
+// its managed variables, removing it again if a variable cannot be stored
+func (s *Server) createService(ctx context.Context, req *storage.CreateServiceRequest, vars []*storage.EnvVar) (*storage.Service, error) {
+	if err := s.checkHostPort(ctx, req.ProjectID, req.Port, nil); err != nil {
+		return nil, err
+	}
+	opts := preflightOptions{createUser: req.CreateUser, createDir: req.CreateWorkingDir}
+	if err := s.preflightService(ctx, serviceCreate(req), nil, opts); err != nil {
+		return nil, err
+	}
+	service, err := s.store.CreateService(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vars {
+		v.ServiceID = service.ID
+		if err := s.store.SetEnvVar(ctx, v); err != nil {
+			if derr := s.store.DeleteService(context.WithoutCancel(ctx), service.ID); derr != nil {
+				slog.WarnContext(ctx, "Failed to remove service after its variables failed", "service", service.Name, "error", derr)
+			}
+			return nil, err
+		}
+	}
+	return service, nil
+}
+
+// handleAPIListServiceTemplates lists the service templates
+// GET /api/service-templates
+func (s *Server) handleAPIListServiceTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.store.ListServiceTemplates(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	resp := make([]serviceTemplateResponse, len(templates))
+	for i, t := range templates {
+		resp[i] = serviceTemplateResponse{ServiceTemplate: t, Params: templateParams(&t.Spec)}
+	}
+	jsonResponse(w, resp)
+}
+
+// handleAPIGetServiceTemplate returns a template and the placeholders it needs
+// GET /api/service-templates/{id}
+func (s *Server) handleAPIGetServiceTemplate(w http.ResponseWriter, r *http.Request) {
+	if t, ok := s.loadServiceTemplate(w, r); ok {
+		jsonResponse(w, serviceTemplateResponse{ServiceTemplate: t, Params: templateParams(&t.Spec)})
+	}
+}
+
+// handleAPICreateServiceTemplate creates a template from scratch
+// POST /api/service-templates {"name","description","spec"}
+func (s *Server) handleAPICreateServiceTemplate(w http.ResponseWriter, r *http.Request) {
+	var req serviceTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	t := &storage.ServiceTemplate{Name: req.Name, Description: req.Description, Spec: req.Spec}
+	if err := s.store.CreateServiceTemplate(r.Context(), t); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, serviceTemplateResponse{ServiceTemplate: t, Params: templateParams(&t.Spec)})
+}
+
+// handleAPIUpdateServiceTemplate replaces a template; services created from
+// it are not changed
+// PUT /api/service-templates/{id} {"name","description","spec"}
+func (s *Server) handleAPIUpdateServiceTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.loadServiceTemplate(w, r)
+	if !ok {
+		return
+	}
+	var req serviceTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	t.Name, t.Description, t.Spec = req.Name, req.Description, req.Spec
+	if err := s.store.UpdateServiceTemplate(r.Context(), t); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, serviceTemplateResponse{ServiceTemplate: t, Params: templateParams(&t.Spec)})
+}
+
+// handleAPIDeleteServiceTemplate removes a template
+// DELETE /api/service-templates/{id}
+func (s *Server) handleAPIDeleteServiceTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.loadServiceTemplate(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteServiceTemplate(r.Context(), t.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPISaveServiceTemplate saves a service as a template
+// POST /api/services/{id}/template {"name","description"}
+func (s *Server) handleAPISaveServiceTemplate(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var req saveTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	project, err := s.store.GetProject(r.Context(), service.ProjectID)
+	if err != nil || project == nil {
+		jsonError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+	vars, err := s.store.ListEnvVars(r.Context(), service.ID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	full := serviceTemplateRequest{Name: req.Name, Description: req.Description, Spec: templateFromService(service, project, vars)}
+	if err := full.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	t := &storage.ServiceTemplate{Name: full.Name, Description: full.Description, Spec: full.Spec}
+	if err := s.store.CreateServiceTemplate(r.Context(), t); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, serviceTemplateResponse{ServiceTemplate: t, Params: templateParams(&t.Spec)})
+}
+
+// handleAPICreateTemplateService creates a service from a template and
+// queues its install, as POST /api/services does
+// POST /api/service-templates/{id}/services {"project_id","name","port","params"}
+func (s *Server) handleAPICreateTemplateService(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.loadServiceTemplate(w, r)
+	if !ok {
+		return
+	}
+	var req templateServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	project, err := s.store.GetProject(r.Context(), req.ProjectID)
+	if err != nil || project == nil {
+		jsonError(w, r, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	port := req.Port
+	if port == 0 && t.Spec.Port > 0 {
+		alloc, err := s.newPortAllocator(r.Context(), project.HostID)
+		if err == nil {
+			port, err = alloc.next(t.Spec.Port)
+		}
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
+	create, vars, err := s.serviceFromTemplate(t.Spec, project, req.Name, port, req.Params)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	create.CreateUser, create.CreateWorkingDir = req.CreateUser, req.CreateWorkingDir
+	service, err := s.createService(r.Context(), create, vars)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Created service from template", "service", service.Name, "template", t.Name)
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: s.enqueueInstall(r, service, setupSteps{clone: true, createUser: req.CreateUser, createDir: req.CreateWorkingDir})})
+}
+
+// addFormTemplates offers the templates on the Add Service form, with the
+// one chosen by id and the values given for its parameters, and returns the
+// chosen one
+func (s *Server) addFormTemplates(ctx context.Context, data map[string]interface{}, id int64, values map[string]string) *storage.ServiceTemplate {
+	templates, err := s.store.ListServiceTemplates(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list service templates", "error", err)
+		return nil
+	}
+	data["Templates"] = templates
+	for _, t := range templates {
+		if t.ID == id {
+			data["Template"], data["TemplateParams"], data["ParamValues"] = t, templateParams(&t.Spec), values
+			return t
+		}
+	}
+	return nil
+}
+
+// templateForm fills in the Add Service form from a template, leaving its
+// placeholders for the form to fill in on submit
+func templateForm(t *storage.ServiceTemplate) *storage.Service {
+	return &storage.Service{
+		Type:        t.Spec.Type,
+		Version:     t.Spec.Version,
+		Runtime:     t.Spec.Runtime,
+		Image:       t.Spec.Image,
+		Port:        t.Spec.Port,
+		Command:     t.Spec.Command,
+		WorkingDir:  t.Spec.WorkingDir,
+		User:        t.Spec.User,
+		Environment: t.Spec.Environment,
+		AutoRestart: t.Spec.AutoRestart,
+		SystemdRaw:  t.Spec.SystemdRaw,
+		Tags:        t.Spec.Tags,
+	}
+}
+
+// formParams returns the param_NAME values of a submitted Add Service form
+func formParams(r *http.Request) map[string]string {
+	params := map[string]string{}
+	for key, values := range r.PostForm {
+		if name, ok := strings.CutPrefix(key, "param_"); ok && len(values) > 0 {
+			params[name] = values[0]
+		}
+	}
+	return params
+}
+
+// formTemplateService fills in the template the Add Service form was
+// started from with the form's fields and parameters, keeping what the form
+// does not show, such as the repository and nginx snippet. Without a
+// template, req is returned as is.
+func (s *Server) formTemplateService(r *http.Request, req *storage.CreateServiceRequest) (*storage.CreateServiceRequest, []*storage.EnvVar, error) {
+	id, _ := strconv.ParseInt(r.FormValue("template_id"), 10, 64)
+	if id == 0 {
+		return req, nil, nil
+	}
+	t, err := s.store.GetServiceTemplate(r.Context(), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t == nil {
+		return nil, nil, &storage.ValidationError{Fields: []storage.FieldError{{Field: "template", Message: "no longer exists"}}}
+	}
+	project, err := s.store.GetProject(r.Context(), req.ProjectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if project == nil {
+		return nil, nil, &storage.ValidationError{Fields: []storage.FieldError{{Field: "project_id", Message: "project not found"}}}
+	}
+
+	spec := t.Spec
+	spec.Type, spec.Version, spec.Runtime, spec.Image = req.Type, req.Version, req.Runtime, req.Image
+	spec.Command, spec.WorkingDir, spec.User, spec.Environment = req.Command, req.WorkingDir, req.User, req.Environment
+	spec.AutoRestart, spec.SystemdRaw, spec.Tags = req.AutoRestart, req.SystemdRaw, req.Tags
+	create, vars, err := s.serviceFromTemplate(spec, project, req.Name, req.Port, formParams(r))
+	if err != nil {
+		return nil, nil, err
+	}
+	create.Notes, create.CreateUser, create.CreateWorkingDir = req.Notes, req.CreateUser, req.CreateWorkingDir
+	return create, vars, nil
+}
+
+// loadServiceTemplate loads the template named by the route's {id}
+func (s *Server) loadServiceTemplate(w http.ResponseWriter, r *http.Request) (*storage.ServiceTemplate, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid template ID", http.StatusBadRequest)
+		return nil, false
+	}
+	t, err := s.store.GetServiceTemplate(r.Context(), id)
+	if err != nil || t == nil {
+		jsonError(w, r, "Template not found", http.StatusNotFound)
+		return nil, false
+	}
+	return t, true
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// sseHeartbeat is how often an event stream sends a ": ping" comment, so
+	// proxies and load balancers do not close it while it is idle
+	sseHeartbeat = 15 * time.Second
+	// sseRetry is how long EventSource waits before reconnecting a dropped stream
+	sseRetry = 3 * time.Second
+)
+
+// startSSE sends the headers of an event stream and the reconnection delay
+func startSSE(w http.ResponseWriter, flusher http.Flusher) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	flusher.Flush()
+}
+
+// ssePing sends the heartbeat comment
+func ssePing(w http.ResponseWriter, flusher http.Flusher) {
+	fmt.Fprint(w, ": ping\n\n")
+	flusher.Flush()
+}
+
+// lastEventID returns the ID of the last event a reconnecting client received:
+// the Last-Event-ID header EventSource sends, or ?last_event_id= for the first
+// connection of a client resuming on its own
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("last_event_id")
+}
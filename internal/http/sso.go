@@ -0,0 +1,356 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/oidc"
+	"servio/internal/storage"
+)
+
+const (
+	// sessionCookie holds a signed-in SSO user, sealed with the secrets cipher
+	sessionCookie = "servio_session"
+	// loginCookie carries a sign-in's state, nonce, and PKCE verifier to the callback
+	loginCookie = "servio_login"
+
+	sessionLifetime = 12 * time.Hour
+	loginLifetime   = 10 * time.Minute
+
+	// roleAdmin in an SSO mapping grants access to every project
+	roleAdmin = "admin"
+)
+
+// SSO paths; all but /auth/basic are served without authentication
+const (
+	ssoLoginPath    = "/auth/login"
+	ssoCallbackPath = "/auth/callback"
+	ssoLogoutPath   = "/auth/logout"
+	ssoBasicPath    = "/auth/basic"
+)
+
+// ssoSettings configure sign-in through an identity provider
+type ssoSettings struct {
+	provider    *oidc.Provider
+	redirectURL string            // "" derives it from each request
+	groups      map[string]string // group, email, or @domain → "admin" or a team name
+}
+
+// session is what the session cookie holds
+type session struct {
+	User    string    `json:"user"`
+	Admin   bool      `json:"admin,omitempty"`
+	Expires time.Time `json:"exp"`
+}
+
+// pendingLogin is what the login cookie holds between /auth/login and the callback
+type pendingLogin struct {
+	oidc.Login
+	Next    string    `json:"next"`
+	Reauth  bool      `json:"reauth,omitempty"`
+	Expires time.Time `json:"exp"`
+}
+
+// ssoActorPrefix namespaces SSO users' names, so a provider account never
+// acts as the basic auth user, someone in -admins, or a client certificate,
+// nor inherits their team memberships
+const ssoActorPrefix = "sso:"
+
+// ssoUserKey marks a request with an SSO session; it holds whether the user
+// is mapped to admin
+type ssoUserKey struct{}
+
+// SetSSO enables signing in through provider, allowing the users groups maps
+// (see config.Config.OIDCGroups). A nil provider disables it. It is safe to
+// call while the server is running.
+func (s *Server) SetSSO(provider *oidc.Provider, redirectURL string, groups map[string]string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	auth := *s.auth.Load()
+	auth.sso = nil
+	if provider != nil {
+		auth.sso = &ssoSettings{provider: provider, redirectURL: redirectURL, groups: groups}
+	}
+	s.auth.Store(&auth)
+}
+
+// sessionUser returns the user of a valid session cookie
+func (s *Server) sessionUser(r *http.Request) (*session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	var sess session
+	// Sessions started before SSO names were namespaced sign in again
+	if !s.openCookie(cookie.Value, &sess) || time.Now().After(sess.Expires) || !strings.HasPrefix(sess.User, ssoActorPrefix) {
+		return nil, false
+	}
+	return &sess, true
+}
+
+// handleSSOLogin sends the browser to the identity provider. With reauth=1
+// the provider is asked for the user's credentials again, which allows
+// power actions as POST /api/auth/reauthenticate does for the password.
+// GET /auth/login?next=/projects/1&reauth=1
+func (s *Server) handleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	sso := s.auth.Load().sso
+	if sso == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	login, err := oidc.NewLogin()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	pending := pendingLogin{
+		Login:   *login,
+		Next:    safeNext(r.URL.Query().Get("next")),
+		Reauth:  r.URL.Query().Get("reauth") == "1",
+		Expires: time.Now().Add(loginLifetime),
+	}
+	target, err := sso.provider.AuthURL(r.Context(), login, s.redirectURL(r, sso), pending.Reauth)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to start sign-in", "error", err)
+		http.Error(w, "The identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	value, err := s.sealCookie(pending)
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	s.setCookie(w, r, loginCookie, value, loginLifetime)
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleSSOCallback finishes a sign-in: it checks the provider's answer,
+// maps the user's groups to admin or teams, provisions their team
+// memberships, and starts a session
+// GET /auth/callback?code=...&state=...
+func (s *Server) handleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	auth := s.auth.Load()
+	if auth.sso == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	var pending pendingLogin
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil || !s.openCookie(cookie.Value, &pending) || time.Now().After(pending.Expires) ||
+		pending.State == "" || r.URL.Query().Get("state") != pending.State {
+		http.Error(w, "The sign-in expired or did not start here; try again", http.StatusBadRequest)
+		return
+	}
+	s.setCookie(w, r, loginCookie, "", -1)
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		http.Error(w, "The identity provider refused the sign-in: "+msg, http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	id, err := auth.sso.provider.Exchange(ctx, &pending.Login, r.URL.Query().Get("code"), s.redirectURL(r, auth.sso))
+	if err != nil {
+		slog.WarnContext(ctx, "SSO sign-in failed", "error", err)
+		audit.Log(ctx, audit.CategoryAuth, "sso-login", "sign in", "", err, 0)
+		if errors.Is(err, oidc.ErrRejected) {
+			http.Error(w, "Sign-in rejected", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "The identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	user := ssoActorPrefix + id.User
+	ctx = storage.WithActor(ctx, user)
+	admin, teams, ok := mapSSOUser(auth.sso.groups, id)
+	if !ok {
+		audit.Log(ctx, audit.CategoryAuth, "sso-login", "sign in", strings.Join(id.Groups, ","), errors.New("no access mapped"), 0)
+		http.Error(w, fmt.Sprintf("%s has no access to this Servio", id.User), http.StatusForbidden)
+		return
+	}
+	if err := s.provisionTeams(ctx, auth.sso.groups, user, teams); err != nil {
+		slog.ErrorContext(ctx, "Failed to provision SSO user's teams", "user", user, "error", err)
+		http.Error(w, "Failed to provision team membership", http.StatusInternalServerError)
+		return
+	}
+
+	value, err := s.sealCookie(session{User: user, Admin: admin, Expires: time.Now().Add(sessionLifetime)})
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+	s.setCookie(w, r, sessionCookie, value, sessionLifetime)
+	if pending.Reauth {
+		s.reauthAt.Store(user, time.Now())
+	}
+	audit.Log(ctx, audit.CategoryAuth, "sso-login", "sign in", fmt.Sprintf("admin=%t teams=%s", admin, strings.Join(teams, ",")), nil, 0)
+	slog.InfoContext(ctx, "SSO user signed in", "user", user, "admin", admin, "teams", teams)
+	http.Redirect(w, r, basePath+pending.Next, http.StatusSeeOther)
+}
+
+// handleSSOLogout ends the session
+// POST /auth/logout
+func (s *Server) handleSSOLogout(w http.ResponseWriter, r *http.Request) {
+	if sess, ok := s.sessionUser(r); ok {
+		s.reauthAt.Delete(sess.User)
+		audit.Log(storage.WithActor(r.Context(), sess.User), audit.CategoryAuth, "sso-logout", "sign out", "", nil, 0)
+	}
+	s.setCookie(w, r, sessionCookie, "", -1)
+	if strings.HasPrefix(r.Header.Get("Accept"), "application/json") {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, basePath+"/", http.StatusSeeOther)
+}
+
+// handleBasicLogin lets basic auth users sign in from a browser when pages
+// redirect to the identity provider: BasicAuth challenges for it as before
+// GET /auth/basic?next=/projects/1
+func (s *Server) handleBasicLogin(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, basePath+safeNext(r.URL.Query().Get("next")), http.StatusSeeOther)
+}
+
+// mapSSOUser applies the group mappings to a user: ok is false when none
+// matches, so they may not sign in
+func mapSSOUser(groups map[string]string, id *oidc.Identity) (admin bool, teams []string, ok bool) {
+	matches := slices.Clone(id.Groups)
+	if id.Email != "" {
+		matches = append(matches, id.Email)
+		if _, domain, found := strings.Cut(id.Email, "@"); found {
+			matches = append(matches, "@"+domain)
+		}
+	}
+	for _, match := range matches {
+		role, mapped := groups[match]
+		switch {
+		case !mapped:
+			continue
+		case role == roleAdmin:
+			admin = true
+		case !slices.Contains(teams, role):
+			teams = append(teams, role)
+		}
+		ok = true
+	}
+	slices.Sort(teams)
+	return admin, teams, ok
+}
+
+// provisionTeams makes user a member of exactly the mapped teams in teams,
+// creating missing ones; teams no mapping names are left as they are
+func (s *Server) provisionTeams(ctx context.Context, groups map[string]string, user string, teams []string) error {
+	mapped := make(map[string]bool)
+	for _, role := range groups {
+		if role != roleAdmin {
+			mapped[role] = true
+		}
+	}
+	existing, err := s.store.ListTeams(ctx)
+	if err != nil {
+		return err
+	}
+	for _, team := range existing {
+		if !mapped[team.Name] {
+			continue
+		}
+		want, member := slices.Contains(teams, team.Name), slices.Contains(team.Members, user)
+		switch {
+		case want && !member:
+			team.Members = append(team.Members, user)
+		case !want && member:
+			team.Members = slices.DeleteFunc(team.Members, func(m string) bool { return m == user })
+		default:
+			continue
+		}
+		if err := s.store.UpdateTeam(ctx, team); err != nil {
+			return err
+		}
+	}
+	for _, name := range teams {
+		if slices.ContainsFunc(existing, func(t *storage.Team) bool { return t.Name == name }) {
+			continue
+		}
+		if err := s.store.CreateTeam(ctx, &storage.Team{Name: name, Members: []string{user}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redirectURL is the callback the provider sends the browser back to
+func (s *Server) redirectURL(r *http.Request, sso *ssoSettings) string {
+	if sso.redirectURL != "" {
+		return sso.redirectURL
+	}
+	scheme := "http"
+	if isSecure(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + basePath + ssoCallbackPath
+}
+
+// isSecure reports whether the browser reached Servio over HTTPS, directly
+// or through a proxy
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// safeNext keeps a post-login redirect on this server
+func safeNext(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || u.Host != "" || strings.Contains(next, `\`) {
+		return "/"
+	}
+	return next
+}
+
+// setCookie sets an HttpOnly cookie scoped to Servio's path; a negative
+// lifetime deletes it
+func (s *Server) setCookie(w http.ResponseWriter, r *http.Request, name, value string, lifetime time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     basePath + "/",
+		MaxAge:   int(lifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sealCookie encrypts v for a cookie, so it can neither be read nor forged
+func (s *Server) sealCookie(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := s.cipher.Encrypt(string(data))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openCookie decrypts a value sealCookie made
+func (s *Server) openCookie(value string, v interface{}) bool {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return false
+	}
+	data, err := s.cipher.Decrypt(sealed)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal([]byte(data), v) == nil
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"servio/internal/jobs"
+	"servio/internal/nginx"
+	"servio/internal/postgres"
+	"servio/internal/stacks"
+	"servio/internal/storage"
+)
+
+// stackDatabaseTimeout bounds the wait for a stack's postgres to accept connections
+const stackDatabaseTimeout = 60 * time.Second
+
+// stackRequest is the body for creating a project from a stack
+type stackRequest struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Domain      string       `json:"domain"`       // routed by a generated nginx site when set
+	GitRepoURL  string       `json:"git_repo_url"` // cloned for the stack's app services
+	Tags        storage.Tags `json:"tags"`
+	TeamID      int64        `json:"team_id,omitempty"`
+}
+
+// stackResponse is the new project, where nginx sends requests, and the job setting up its services
+type stackResponse struct {
+	Project *storage.Project `json:"project"`
+	Routes  []stacks.Route   `json:"routes"`
+	JobID   int64            `json:"job_id,omitempty"`
+}
+
+// handleAPIListStacks lists the stack templates
+// GET /api/stacks
+func (s *Server) handleAPIListStacks(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, stacks.All())
+}
+
+// handleAPICreateStack creates a project with a stack's services and queues
+// a stack job that installs them
+// POST /api/stacks/{name} {"name","domain","git_repo_url",...}
+func (s *Server) handleAPICreateStack(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := stacks.Get(r.PathValue("name"))
+	if err != nil {
+		jsonError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	var req stackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	project, plan, jobID, err := s.createStack(r.Context(), tmpl, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, stackResponse{Project: project, Routes: plan.Routes, JobID: jobID})
+}
+
+// createStack creates the project, its services, the database password
+// secret, and the nginx site, removing the project again if any of it fails.
+// Failing to queue the job is logged rather than returned, as for single
+// services; the job ID is then 0.
+func (s *Server) createStack(ctx context.Context, tmpl *stacks.Template, req *stackRequest) (*storage.Project, *stacks.Plan, int64, error) {
+	project, err := s.store.CreateProject(ctx, &storage.CreateProjectRequest{
+		Name:        req.Name,
+		Description: req.Description,
+		Domain:      req.Domain,
+		Tags:        req.Tags,
+		TeamID:      req.TeamID,
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// Deleting the project also deletes its services and secrets
+	fail := func(err error) (*storage.Project, *stacks.Plan, int64, error) {
+		if derr := s.store.DeleteProject(context.WithoutCancel(ctx), project.ID); derr != nil {
+			slog.WarnContext(ctx, "Failed to remove partly created stack", "project", project.Name, "error", derr)
+		}
+		return nil, nil, 0, err
+	}
+
+	alloc, err := s.newPortAllocator(ctx, project.HostID)
+	if err != nil {
+		return fail(err)
+	}
+	plan, err := tmpl.Build(project, req.GitRepoURL, alloc.next)
+	if err != nil {
+		return fail(err)
+	}
+	services := make([]*storage.Service, len(plan.Services))
+	for i := range plan.Services {
+		if services[i], err = s.store.CreateService(ctx, &plan.Services[i].Request); err != nil {
+			return fail(err)
+		}
+	}
+
+	var password string
+	if plan.Database != "" {
+		if password, err = postgres.GeneratePassword(); err != nil {
+			return fail(err)
+		}
+		ciphertext, err := s.cipher.Encrypt(password)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err = s.store.CreateSecret(ctx, stacks.PasswordSecret, storage.ProjectScope(project.ID), ciphertext); err != nil {
+			return fail(err)
+		}
+	}
+
+	if project.Domain != "" && len(plan.Routes) > 0 {
+		routes := make([]nginx.Route, len(plan.Routes))
+		for i, route := range plan.Routes {
+			routes[i] = nginx.Route{Path: route.Path, Port: route.Port}
+		}
+		config, err := s.nginxManager.GenerateRoutedConfig(project, routes)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := s.store.UpdateProjectNginxRaw(ctx, project.ID, config); err != nil {
+			return fail(err)
+		}
+	}
+
+	if project, err = s.store.GetProject(ctx, project.ID); err != nil || project == nil {
+		return fail(fmt.Errorf("failed to reload project: %w", err))
+	}
+	job, err := s.jobs.Enqueue(ctx, storage.Job{Kind: jobs.KindStack, ProjectID: project.ID},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			return s.runStack(ctx, plan, services, password, logf)
+		})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to queue stack setup", "project", project.Name, "error", err)
+		return project, plan, 0, nil
+	}
+	return project, plan, job.ID, nil
+}
+
+// runStack is the work of a stack job: it installs the services in order,
+// starting the backing ones, and creates the database role and database
+// once postgres is up. App services are installed but not started until
+// they are deployed.
+func (s *Server) runStack(ctx context.Context, plan *stacks.Plan, services []*storage.Service, password string, logf jobs.Logf) error {
+	start := func(i int) bool { return plan.Services[i].Start }
+	if err := s.setupServices(ctx, services, start, logf); err != nil {
+		return err
+	}
+	for i, service := range services {
+		if plan.Services[i].Database {
+			if err := createStackDatabase(ctx, postgres.ForService(service), plan.Database, password, logf); err != nil {
+				return fmt.Errorf("%s: %w", service.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createStackDatabase waits for postgres to accept connections, then creates
+// a login role named name with password and a database of the same name it owns
+func createStackDatabase(ctx context.Context, pg *postgres.Server, name, password string, logf jobs.Logf) error {
+	logf("waiting for postgres on port %d", pg.Port)
+	deadline := time.Now().Add(stackDatabaseTimeout)
+	for {
+		_, err := pg.ListDatabases(ctx)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("postgres did not accept connections within %s: %w", stackDatabaseTimeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	logf("creating role and database %s", name)
+	if err := pg.CreateRole(ctx, name, password, false); err != nil {
+		return err
+	}
+	return pg.CreateDatabase(ctx, name, name)
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// staticAsset is an embedded file with its ETag and, when it shrinks, a gzipped copy
+type staticAsset struct {
+	contentType string
+	etag        string
+	data        []byte
+	gzipped     []byte
+}
+
+// staticAssets serves the embedded static FS from memory. Files are hashed and
+// compressed once at startup: responses carry a strong ETag, and clients that
+// accept gzip get the pre-compressed bytes.
+type staticAssets struct {
+	files   map[string]*staticAsset
+	modTime time.Time
+}
+
+// newStaticAssets loads every file in fsys. It is only given the embedded
+// static FS, which cannot fail to read.
+func newStaticAssets(fsys fs.FS) *staticAssets {
+	assets := &staticAssets{files: make(map[string]*staticAsset), modTime: time.Now()}
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		asset := &staticAsset{
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			etag:        hex.EncodeToString(sum[:8]),
+			data:        data,
+		}
+		if asset.contentType == "" {
+			asset.contentType = http.DetectContentType(data)
+		}
+
+		var buf bytes.Buffer
+		gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		gz.Write(data)
+		gz.Close()
+		if buf.Len() < len(data) {
+			asset.gzipped = buf.Bytes()
+		}
+
+		assets.files[name] = asset
+		return nil
+	})
+	return assets
+}
+
+// ServeHTTP serves /static/{name}. Requests with a ?v= version (as the layout
+// links them) may be cached indefinitely, since a changed file gets a new
+// version; anything else must be revalidated with its ETag.
+func (a *staticAssets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	asset, ok := a.files[strings.TrimPrefix(r.URL.Path, "/static/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", asset.contentType)
+	h.Set("Vary", "Accept-Encoding")
+	if r.URL.Query().Get("v") != "" {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	// Each encoding is a distinct representation, so it gets its own ETag
+	body := asset.data
+	if asset.gzipped != nil && acceptsGzip(r) {
+		body = asset.gzipped
+		h.Set("Content-Encoding", "gzip")
+		h.Set("ETag", `"`+asset.etag+`-gz"`)
+	} else {
+		h.Set("ETag", `"`+asset.etag+`"`)
+	}
+
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(body))
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(coding, "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
@@ -1,6 +1,10 @@
 // Servio - JavaScript Application
 // Servio - JavaScript Application
 
+// basePath is the -base-path prefix ("" at the root); every URL below starts with it
+const basePath =
+  document.querySelector('meta[name="servio-base-path"]')?.content || "";
+
 // Robust syntax highlighter that avoids nesting by using placeholders
 function highlightContent(text, mode) {
   if (!text) return "";
@@ -53,6 +57,10 @@ document.addEventListener("DOMContentLoaded", function () {
   initThemeToggle();
   initDashboardRefresh();
   initCodeHighlighting();
+  initSearch();
+
+  // Content swapped in by htmx (service cards) needs highlighting too
+  document.body.addEventListener("htmx:afterSettle", () => initCodeHighlighting());
 });
 
 // Auto-highlight any element with class 'code-block' and 'data-language'
@@ -175,7 +183,7 @@ function initLogControls() {
         refreshBtn.disabled = true;
         refreshBtn.textContent = "Loading...";
 
-        const response = await fetch(`/api/projects/${projectId}/logs`);
+        const response = await fetch(`${basePath}/api/projects/${projectId}/logs`);
         const data = await response.json();
 
         if (data.logs) {
@@ -206,7 +214,7 @@ function initLogControls() {
       eventSource.close();
     }
 
-    eventSource = new EventSource(`/api/projects/${projectId}/logs/stream`);
+    eventSource = new EventSource(`${basePath}/api/projects/${projectId}/logs/stream`);
     isStreaming = true;
 
     streamBtn.textContent = "Stop Stream";
@@ -220,9 +228,13 @@ function initLogControls() {
       scrollToBottom(logOutput);
     };
 
+    // EventSource reconnects on its own and the server resumes after the
+    // last line received; only a stream the server refused is given up on
     eventSource.onerror = function (error) {
-      console.error("SSE Error:", error);
-      stopStreaming();
+      if (eventSource.readyState === EventSource.CLOSED) {
+        console.error("SSE Error:", error);
+        stopStreaming();
+      }
     };
   }
 
@@ -379,80 +391,104 @@ function scrollToBottom(element) {
   element.scrollTop = element.scrollHeight;
 }
 
-// Auto-refresh stats and status on dashboard
+// Open the live event stream and dispatch events to handlers keyed by event
+// type. EventSource reconnects on its own if the stream drops.
+function subscribeEvents(params, handlers) {
+  const query = params ? "?" + new URLSearchParams(params) : "";
+  const source = new EventSource(basePath + "/api/events" + query);
+  Object.entries(handlers).forEach(([type, handler]) => {
+    source.addEventListener(type, (e) => handler(JSON.parse(e.data)));
+  });
+  return source;
+}
+
+// Live service stats and status on the dashboard. The pulse bar refreshes
+// itself through htmx (see the stats-widget template).
 function initDashboardRefresh() {
-  const pulseBar = document.querySelector(".pulse-bar");
-  if (!pulseBar && document.querySelectorAll(".service-card").length === 0)
-    return;
+  const dashboard = document.querySelector(".dashboard");
+  if (!dashboard) return;
+
+  // Service metrics have no events, so they are still sampled at the configured
+  // dashboard_refresh_seconds (default 2s) for a "pulse" feel
+  const seconds = parseInt(dashboard.dataset.refreshSeconds, 10) || 2;
+
+  let projects = [];
+  let stats = {};
+  const render = () => {
+    updateProjectCards(projects, stats);
+    updateHostStrip(stats.hosts);
+  };
 
-  // Refresh every 2 seconds for a "pulse" feel
-  setInterval(async function () {
+  const refreshStats = async function () {
     try {
-      // Update Stats
-      const statsRes = await fetch("/api/stats");
-      const stats = await statsRes.json();
-      updatePulseBar(stats);
-
-      // Update Projects (if on dashboard)
-      const projectsRes = await fetch("/api/projects");
-      const projects = await projectsRes.json();
-      updateProjectCards(projects, stats);
+      const statsRes = await fetch(basePath + "/api/stats");
+      stats = await statsRes.json();
+      render();
     } catch (error) {
-      console.error("Failed to refresh dashboard:", error);
+      console.error("Failed to refresh stats:", error);
     }
-  }, 2000);
-}
+  };
 
-function updatePulseBar(stats) {
-  // Update CPU
-  const cpuVal = stats.cpu_usage || 0;
-  const cpuFill = document.getElementById("cpu-fill");
-  const cpuText = document.getElementById("cpu-value");
-  if (cpuFill) {
-    cpuFill.style.width = `${cpuVal}%`;
-    cpuFill.classList.toggle("warning", cpuVal > 70);
-    cpuFill.classList.toggle("danger", cpuVal > 90);
-  }
-  if (cpuText) cpuText.textContent = `${Math.round(cpuVal)}%`;
-
-  // Update Memory
-  const memVal = stats.memory_usage || 0;
-  const memFill = document.getElementById("mem-fill");
-  const memText = document.getElementById("mem-value");
-  if (memFill) {
-    memFill.style.width = `${memVal}%`;
-    memFill.classList.toggle("warning", memVal > 70);
-    memFill.classList.toggle("danger", memVal > 90);
-  }
-  if (memText && stats.memory_total) {
-    memText.textContent = `${stats.memory_used.toFixed(1)} / ${stats.memory_total.toFixed(1)} GB`;
-  } else if (memText) {
-    memText.textContent = `${Math.round(memVal)}%`;
-  }
+  // Reload the projects (keeping the active filters) whenever the stream
+  // (re)connects, since events may have been missed while it was down
+  const refreshProjects = async function () {
+    try {
+      const projectsRes = await fetch(basePath + "/api/projects" + window.location.search);
+      projects = await projectsRes.json();
+      render();
+    } catch (error) {
+      console.error("Failed to refresh projects:", error);
+    }
+  };
 
-  // Update Disk
-  const diskVal = stats.disk_usage || 0;
-  const diskFill = document.getElementById("disk-fill");
-  const diskText = document.getElementById("disk-value");
-  if (diskFill) {
-    diskFill.style.width = `${diskVal}%`;
-    diskFill.classList.toggle("warning", diskVal > 80);
-    diskFill.classList.toggle("danger", diskVal > 95);
-  }
-  if (diskText && stats.disk_total) {
-    diskText.textContent = `${stats.disk_used.toFixed(0)} / ${stats.disk_total.toFixed(0)} GB`;
-  } else if (diskText) {
-    diskText.textContent = `${Math.round(diskVal)}%`;
-  }
+  const onStatus = (event) => {
+    projects.forEach((project) => {
+      (project.services || []).forEach((svc) => {
+        if (svc.id === event.service_id) svc.status = event.data.status;
+      });
+    });
+    render();
+  };
 
-  const uptimeText = document.getElementById("uptime-value");
-  if (uptimeText) uptimeText.textContent = stats.uptime;
+  refreshStats();
+  setInterval(refreshStats, seconds * 1000);
 
-  // Update OS Name
-  const osText = document.getElementById("os-name-badge");
-  if (osText && stats.os_name) {
-    osText.textContent = `${stats.os_name} ${stats.os_version || ""}`.trim();
+  const source = subscribeEvents(null, {
+    "service.started": onStatus,
+    "service.stopped": onStatus,
+    "service.crashed": onStatus,
+    "job.updated": (event) => {
+      if (event.data.status === "succeeded" || event.data.status === "failed") refreshProjects();
+    },
+  });
+  source.addEventListener("open", refreshProjects);
+}
+
+// Agent hosts (only reported to admins) with their load, or why they are offline
+function updateHostStrip(hosts) {
+  const strip = document.getElementById("host-strip");
+  if (!strip) return;
+  if (!hosts || hosts.length === 0) {
+    strip.hidden = true;
+    return;
   }
+
+  strip.innerHTML = hosts
+    .map((h) => {
+      const name = escapeHtml(h.host.name);
+      if (!h.online) {
+        return `<div class="host-chip offline" title="${escapeHtml(h.error || "offline")}">
+          <span class="dot status-failed"></span><span>${name}</span><span class="host-detail">offline</span>
+        </div>`;
+      }
+      const s = h.stats;
+      return `<div class="host-chip">
+        <span class="dot status-running"></span><span>${name}</span>
+        <span class="host-detail">⚡ ${s.cpu_usage.toFixed(1)}% · 💾 ${s.memory_usage.toFixed(0)}% · 💿 ${s.disk_usage.toFixed(0)}%</span>
+      </div>`;
+    })
+    .join("");
+  strip.hidden = false;
 }
 
 function updateProjectCards(projects, stats) {
@@ -498,3 +534,79 @@ function updateProjectCards(projects, stats) {
   });
 }
 
+
+// Global search box in the navbar
+function initSearch() {
+  const input = document.getElementById("search-input");
+  const results = document.getElementById("search-results");
+  if (!input || !results) return;
+
+  let timer = null;
+
+  input.addEventListener("input", function () {
+    clearTimeout(timer);
+    const q = input.value.trim();
+    if (!q) {
+      results.innerHTML = "";
+      results.classList.remove("open");
+      return;
+    }
+    timer = setTimeout(() => runSearch(q), 200);
+  });
+
+  input.addEventListener("keydown", function (e) {
+    if (e.key === "Escape") {
+      input.value = "";
+      results.classList.remove("open");
+    } else if (e.key === "Enter") {
+      const first = results.querySelector("a");
+      if (first) window.location.href = first.href;
+    }
+  });
+
+  document.addEventListener("click", function (e) {
+    if (!e.target.closest("#nav-search")) {
+      results.classList.remove("open");
+    }
+  });
+
+  async function runSearch(q) {
+    try {
+      const res = await fetch(`${basePath}/api/search?q=${encodeURIComponent(q)}`);
+      const data = await res.json();
+      renderResults(Array.isArray(data) ? data : []);
+    } catch (error) {
+      console.error("Search failed:", error);
+    }
+  }
+
+  function renderResults(items) {
+    if (items.length === 0) {
+      results.innerHTML = '<div class="search-empty">No matches</div>';
+    } else {
+      results.innerHTML = items
+        .map((item) => {
+          const href =
+            item.kind === "service"
+              ? `${basePath}/projects/${item.project_id}#service-${item.id}`
+              : `${basePath}/projects/${item.project_id}`;
+          return `<a class="search-result" href="${href}">
+            <span class="search-kind">${escapeHtml(item.kind)}</span>
+            <span class="search-name">${escapeHtml(item.name)}</span>
+            <span class="search-snippet">${escapeHtml(item.snippet || "")}</span>
+          </a>`;
+        })
+        .join("");
+    }
+    results.classList.add("open");
+  }
+}
+
+function escapeHtml(text) {
+  return String(text)
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;")
+    .replace(/'/g, "&#039;");
+}
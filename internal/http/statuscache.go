@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"sync"
+
+	"servio/internal/storage"
+)
+
+// statusCache holds the last known status of every service. The state watcher
+// refreshes it in the background, so pages listing many services render
+// without asking systemd about each one.
+type statusCache struct {
+	mu       sync.RWMutex
+	statuses map[int64]string // service ID → running, stopped, or not installed
+}
+
+func newStatusCache() *statusCache {
+	return &statusCache{statuses: make(map[int64]string)}
+}
+
+func (c *statusCache) get(serviceID int64) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status, ok := c.statuses[serviceID]
+	return status, ok
+}
+
+func (c *statusCache) set(serviceID int64, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses[serviceID] = status
+}
+
+// retain drops the statuses of services that no longer exist
+func (c *statusCache) retain(services []*storage.Service) {
+	keep := make(map[int64]bool, len(services))
+	for _, sv := range services {
+		keep[sv.ID] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.statuses {
+		if !keep[id] {
+			delete(c.statuses, id)
+		}
+	}
+}
+
+// cachedServiceStatus returns a service's status as the state watcher last
+// saw it, asking systemd only about services it has yet to see
+func (s *Server) cachedServiceStatus(ctx context.Context, service *storage.Service) string {
+	if status, ok := s.statuses.get(service.ID); ok {
+		return status
+	}
+	return s.serviceStatus(ctx, service)
+}
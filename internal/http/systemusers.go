@@ -0,0 +1,21 @@
+package http
+
+import (
+	"net/http"
+
+	"servio/internal/hostuser"
+)
+
+// systemUserResponse says whether a service user exists on this server
+type systemUserResponse struct {
+	Name   string `json:"name"`
+	Exists bool   `json:"exists"`
+}
+
+// handleAPIGetSystemUser reports whether a user exists, so forms can offer
+// to create a missing service user
+// GET /api/system-users/{name}
+func (s *Server) handleAPIGetSystemUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	jsonResponse(w, systemUserResponse{Name: name, Exists: name == "root" || hostuser.Exists(name)})
+}
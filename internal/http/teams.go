@@ -0,0 +1,241 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// teamRequest is the body for creating or updating a team
+type teamRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"` // basic auth users or client certificate names
+}
+
+// validate trims the name and members and rejects blank ones
+func (req *teamRequest) validate() error {
+	var fields []storage.FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		fields = append(fields, storage.FieldError{Field: "name", Message: "is required"})
+	}
+	members := []string{}
+	for _, m := range req.Members {
+		if m = strings.TrimSpace(m); m == "" {
+			fields = append(fields, storage.FieldError{Field: "members", Message: "must not be blank"})
+			continue
+		}
+		members = append(members, m)
+	}
+	req.Members = members
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// projectTeamRequest is the body for assigning a project to a team
+type projectTeamRequest struct {
+	TeamID int64 `json:"team_id"` // 0 unassigns the project
+}
+
+// SetAdmins names the users, besides the basic auth user, who see every
+// project and may manage teams and host-wide settings. The names are basic
+// auth or client certificate users; SSO users are admins only by their
+// group mapping. It is safe to call while the server is running.
+func (s *Server) SetAdmins(names []string) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	auth := *s.auth.Load()
+	auth.admins = make(map[string]bool, len(names))
+	for _, name := range names {
+		auth.admins[name] = true
+	}
+	s.auth.Store(&auth)
+}
+
+// TeamScope is a middleware that limits users who are not admins to the
+// projects of the teams they belong to (see storage.WithTeamScope) and keeps
+// them away from host-wide configuration. It must run after BasicAuth.
+func (s *Server) TeamScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := storage.ActorFromContext(r.Context())
+		auth := s.auth.Load()
+		ssoAdmin, sso := r.Context().Value(ssoUserKey{}).(bool)
+		if publicPaths[r.URL.Path] || ssoAdmin || !sso && (user == auth.username || auth.admins[user]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if adminOnly(r) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				jsonError(w, r, "Admin access required", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		teamIDs, err := s.store.TeamIDsForMember(r.Context(), user)
+		if err != nil {
+			jsonError(w, r, "Failed to load team membership", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(storage.WithTeamScope(r.Context(), teamIDs)))
+	})
+}
+
+// adminOnly reports whether a request manages the host or teams rather than
+// a team's own projects
+func adminOnly(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/webhooks"),
+		strings.HasPrefix(path, "/api/notification-channels"),
+		strings.HasPrefix(path, "/api/secrets"),
+		strings.HasPrefix(path, "/api/admin/"),
+		strings.HasPrefix(path, "/api/system/"),
+		strings.HasPrefix(path, "/api/hosts"),
+		strings.HasPrefix(path, "/api/certificates"):
+		return true
+	case strings.HasPrefix(path, "/api/projects/") && (strings.HasSuffix(path, "/team") || strings.HasSuffix(path, "/host")):
+		return true
+	case strings.HasPrefix(path, "/api/services/") && strings.HasSuffix(path, "/template"):
+		// Templates are shared by every team
+		return true
+	case strings.HasPrefix(path, "/api/service-templates"):
+		return r.Method != http.MethodGet && !strings.HasSuffix(path, "/services")
+	case strings.HasPrefix(path, "/api/teams"), strings.HasPrefix(path, "/api/settings"), strings.HasPrefix(path, "/api/blueprints"):
+		return r.Method != http.MethodGet
+	}
+	return false
+}
+
+// projectVisible reports whether the team scope of ctx can see a project,
+// remembering answers in seen for the life of a stream
+func (s *Server) projectVisible(ctx context.Context, projectID int64, seen map[int64]bool) bool {
+	if visible, ok := seen[projectID]; ok {
+		return visible
+	}
+	project, err := s.store.GetProject(ctx, projectID)
+	seen[projectID] = err == nil && project != nil
+	return seen[projectID]
+}
+
+// handleAPIListTeams lists teams with their members; other users see only their own teams
+// GET /api/teams
+func (s *Server) handleAPIListTeams(w http.ResponseWriter, r *http.Request) {
+	teams, err := s.store.ListTeams(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, teams)
+}
+
+// handleAPICreateTeam creates a team
+// POST /api/teams {"name","members"}
+func (s *Server) handleAPICreateTeam(w http.ResponseWriter, r *http.Request) {
+	var req teamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	team := &storage.Team{Name: req.Name, Members: req.Members}
+	if err := s.store.CreateTeam(r.Context(), team); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, team)
+}
+
+// handleAPIGetTeam returns a team
+// GET /api/teams/{id}
+func (s *Server) handleAPIGetTeam(w http.ResponseWriter, r *http.Request) {
+	if team, ok := s.loadTeam(w, r); ok {
+		jsonResponse(w, team)
+	}
+}
+
+// handleAPIUpdateTeam renames a team and replaces its members
+// PUT /api/teams/{id} {"name","members"}
+func (s *Server) handleAPIUpdateTeam(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.loadTeam(w, r)
+	if !ok {
+		return
+	}
+
+	var req teamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	team.Name, team.Members = req.Name, req.Members
+	if err := s.store.UpdateTeam(r.Context(), team); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, team)
+}
+
+// handleAPIDeleteTeam deletes a team; its projects become unassigned
+// DELETE /api/teams/{id}
+func (s *Server) handleAPIDeleteTeam(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.loadTeam(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteTeam(r.Context(), team.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPISetProjectTeam assigns a project to a team
+// PUT /api/projects/{id}/team {"team_id"}
+func (s *Server) handleAPISetProjectTeam(w http.ResponseWriter, r *http.Request, project *storage.Project) {
+	var req projectTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	project, err := s.store.SetProjectTeam(r.Context(), project.ID, req.TeamID)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, project)
+}
+
+// loadTeam reads the {id} team. It writes the error response itself.
+func (s *Server) loadTeam(w http.ResponseWriter, r *http.Request) (*storage.Team, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid team ID", http.StatusBadRequest)
+		return nil, false
+	}
+	team, err := s.store.GetTeam(r.Context(), id)
+	if err != nil || team == nil {
+		jsonError(w, r, "Team not found", http.StatusNotFound)
+		return nil, false
+	}
+	return team, true
+}
@@ -0,0 +1,260 @@
+package http
+
+import (
+	"encoding/json"
+	"time"
+
+	"servio/internal/agent"
+	"servio/internal/deploy"
+	"servio/internal/dryrun"
+	"servio/internal/logparse"
+	"servio/internal/monitor"
+	"servio/internal/nginx"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// API request and response bodies. Handlers encode and decode these named
+// types so the OpenAPI document (see apidocs.go) is generated from the same
+// definitions the API actually uses.
+
+// errorResponse is the body of every API error. Error stays a plain message so
+// older clients that only read it keep working; Code is stable for programs.
+type errorResponse struct {
+	Code    string               `json:"code"`
+	Error   string               `json:"error"`
+	Message string               `json:"message"` // Error in the request's language
+	Details []storage.FieldError `json:"details,omitempty"`
+}
+
+// serviceJobResponse is a saved service plus the background job installing it
+type serviceJobResponse struct {
+	*storage.Service
+	JobID int64 `json:"job_id,omitempty"`
+}
+
+// webhookCreatedResponse is a new webhook plus its signing secret, which is not shown again
+type webhookCreatedResponse struct {
+	*storage.Webhook
+	Secret string `json:"secret"`
+}
+
+// dryRunResponse lists the file changes and commands an operation would have made
+type dryRunResponse struct {
+	DryRun  bool            `json:"dry_run"`
+	Actions []dryrun.Action `json:"actions"`
+}
+
+// statsResponse is this server's stats with the last reading of each agent host
+type statsResponse struct {
+	monitor.Stats
+	Hosts []agent.HostStats `json:"hosts,omitempty"`
+}
+
+// statusResponse acknowledges an action
+type statusResponse struct {
+	Status string `json:"status"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// readinessResponse reports the result of each readiness check
+type readinessResponse struct {
+	Status string                    `json:"status"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+// readinessCheck is the outcome of a single readiness check
+type readinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// logsResponse carries journal output for a service and how many of its
+// lines are at each level; Entries holds the parsed lines when structured
+// output is asked for. Truncated is set when older lines in the window were
+// cut by the line limit.
+type logsResponse struct {
+	Logs      string          `json:"logs"`
+	Counts    logLevelCounts  `json:"counts"`
+	Truncated bool            `json:"truncated"`
+	Entries   []logparse.Line `json:"entries,omitempty"`
+}
+
+// nginxLogsResponse carries the tail of a project's nginx log
+type nginxLogsResponse struct {
+	Path      string `json:"path"`
+	Logs      string `json:"logs"`
+	Truncated bool   `json:"truncated"`
+}
+
+// logLevelCounts counts log lines by level
+type logLevelCounts struct {
+	Error int `json:"error"`
+	Warn  int `json:"warn"`
+	Info  int `json:"info"`
+	Debug int `json:"debug"`
+}
+
+// settingResponse is a single setting's current value
+type settingResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// settingRequest sets a setting; value may be any JSON type
+type settingRequest struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// nginxPreviewResponse shows the generated and effective nginx config for a project
+type nginxPreviewResponse struct {
+	Config        string `json:"config"`
+	DefaultConfig string `json:"default_config"`
+	Path          string `json:"path"`
+	Installed     bool   `json:"installed"`
+	IsCustomized  bool   `json:"is_customized"`
+
+	DNS *nginx.DNSCheck `json:"dns,omitempty"` // absent when dns_check is off
+
+	// Configs and units on the server that clash with the project's; only
+	// checked for projects on this server
+	Conflicts     []nginx.Conflict       `json:"conflicts,omitempty"`
+	UnitConflicts []systemd.UnitConflict `json:"unit_conflicts,omitempty"`
+}
+
+// nginxConfigRequest saves a custom nginx config for a project
+type nginxConfigRequest struct {
+	Config string `json:"config"`
+}
+
+// wsRequest is a client message on /ws
+type wsRequest struct {
+	Type      string `json:"type"`  // subscribe or unsubscribe
+	Topic     string `json:"topic"` // logs, deploy, or status
+	ServiceID int64  `json:"service_id"`
+	ANSI      string `json:"ansi,omitempty"` // logs only: strip (default), html, or raw
+}
+
+// wsMessage is a server message on /ws. Type is the topic for stream data
+// (log, deploy, status) or subscribed, unsubscribed, error for replies.
+type wsMessage struct {
+	Type       string        `json:"type"`
+	Topic      string        `json:"topic,omitempty"`
+	ServiceID  int64         `json:"service_id,omitempty"`
+	Line       string        `json:"line,omitempty"`
+	Status     string        `json:"status,omitempty"`
+	Deployment *deploy.Event `json:"deployment,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// serviceActionRequest runs one control action on several services
+type serviceActionRequest struct {
+	IDs    []int64 `json:"ids"`
+	Action string  `json:"action"` // start, stop, or restart
+}
+
+// serviceActionResult is the outcome of an action on one service
+type serviceActionResult struct {
+	ServiceID int64  `json:"service_id"`
+	Name      string `json:"name,omitempty"`
+	OK        bool   `json:"ok"`
+	Skipped   bool   `json:"skipped,omitempty"` // a dependency failed, so it was not attempted
+	Error     string `json:"error,omitempty"`
+}
+
+// serviceActionResponse reports per-service outcomes, in request order for
+// bulk actions and in execution order for project actions
+type serviceActionResponse struct {
+	Action    string                `json:"action"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Skipped   int                   `json:"skipped,omitempty"`
+	Results   []serviceActionResult `json:"results"`
+}
+
+// journalUsageResponse reports journal disk usage in bytes
+type journalUsageResponse struct {
+	TotalBytes  int64                 `json:"total_bytes"`
+	SystemBytes int64                 `json:"system_bytes"` // the system journal, shared by units without a policy
+	Services    []serviceJournalUsage `json:"services"`
+}
+
+// serviceJournalUsage is the journal one service holds
+type serviceJournalUsage struct {
+	ServiceID int64                     `json:"service_id"`
+	Service   string                    `json:"service"`
+	Unit      string                    `json:"unit"`
+	Namespace string                    `json:"namespace,omitempty"`
+	Bytes     int64                     `json:"bytes"`
+	Retention *storage.JournalRetention `json:"retention,omitempty"`
+	Error     string                    `json:"error,omitempty"`
+}
+
+// journalVacuumRequest trims the system journal, or one service's namespace when ServiceID is set
+type journalVacuumRequest struct {
+	MaxSize   string `json:"max_size,omitempty"` // journald size syntax, e.g. 500M
+	MaxAge    string `json:"max_age,omitempty"`  // journald time syntax, e.g. 7d
+	ServiceID int64  `json:"service_id,omitempty"`
+}
+
+// journalVacuumResponse is journalctl's report of what it deleted
+type journalVacuumResponse struct {
+	Namespace string `json:"namespace,omitempty"`
+	Output    string `json:"output"`
+}
+
+// journalRetentionRequest sets a service's retention policy; at least one limit is required
+type journalRetentionRequest struct {
+	MaxSize string `json:"max_size,omitempty"`
+	MaxAge  string `json:"max_age,omitempty"`
+}
+
+// fileLoggingRequest turns on file logging for a service; empty fields take
+// the defaults (rotate 7, daily)
+type fileLoggingRequest struct {
+	Rotate    int    `json:"rotate,omitempty"`
+	Frequency string `json:"frequency,omitempty"`
+	MaxSize   string `json:"max_size,omitempty"`
+}
+
+// logForwardingRequest turns forwarding of a service's journal on or off
+type logForwardingRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// logForwardingResponse reports whether a service's journal is forwarded
+type logForwardingResponse struct {
+	Enabled   bool       `json:"enabled"`
+	Cursor    string     `json:"cursor,omitempty"` // the last entry delivered
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+}
+
+// projectLogLine is an event of a project's merged log stream, with the
+// line's level and, when the message is a JSON object, its fields
+type projectLogLine struct {
+	systemd.LogLine
+	Service string                 `json:"service,omitempty"`
+	Level   string                 `json:"level"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// appMetricsResponse lists the custom metrics a service pushed
+type appMetricsResponse struct {
+	Metrics []*appMetricSeries `json:"metrics"`
+}
+
+// appMetricSeries is one metric's per-minute samples, oldest first
+type appMetricSeries struct {
+	Name   string           `json:"name"`
+	Kind   string           `json:"kind"` // counter, gauge, or timer
+	Latest float64          `json:"latest"`
+	Points []appMetricPoint `json:"points"`
+}
+
+// appMetricPoint is a minute of a metric: a counter's total, a gauge's last
+// value, or a timer's mean of count observations
+type appMetricPoint struct {
+	TakenAt time.Time `json:"taken_at"`
+	Value   float64   `json:"value"`
+	Count   int64     `json:"count"`
+}
@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"servio/internal/jobs"
+	"servio/internal/storage"
+	"servio/internal/sysupdate"
+)
+
+// updatesResponse lists the host's pending package updates
+type updatesResponse struct {
+	Manager string             `json:"manager"` // apt or dnf
+	Updates []sysupdate.Update `json:"updates"`
+	Reboot  sysupdate.Reboot   `json:"reboot"`
+}
+
+// applyUpdatesRequest selects the updates an update job applies
+type applyUpdatesRequest struct {
+	Packages     []string `json:"packages"`      // every pending update when empty
+	SecurityOnly bool     `json:"security_only"` // only security updates
+}
+
+// handleAPIListUpdates lists pending package updates as of the last refresh,
+// and whether the host needs a reboot for updates already installed
+// GET /api/system/updates?security=true
+func (s *Server) handleAPIListUpdates(w http.ResponseWriter, r *http.Request) {
+	securityOnly, _ := strconv.ParseBool(r.URL.Query().Get("security"))
+	s.respondUpdates(w, r, securityOnly, false)
+}
+
+// handleAPIRefreshUpdates downloads the package lists, then lists pending updates
+// POST /api/system/updates/refresh?security=true
+func (s *Server) handleAPIRefreshUpdates(w http.ResponseWriter, r *http.Request) {
+	securityOnly, _ := strconv.ParseBool(r.URL.Query().Get("security"))
+	s.respondUpdates(w, r, securityOnly, true)
+}
+
+func (s *Server) respondUpdates(w http.ResponseWriter, r *http.Request, securityOnly, refresh bool) {
+	manager, err := sysupdate.Detect()
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if refresh {
+		if err := sysupdate.Refresh(r.Context(), manager); err != nil {
+			apiError(w, r, err)
+			return
+		}
+	}
+	updates, err := sysupdate.List(r.Context(), manager, securityOnly)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, updatesResponse{Manager: manager, Updates: updates, Reboot: sysupdate.RebootRequired(r.Context(), manager)})
+}
+
+// handleAPIApplyUpdates queues a job that refreshes the package lists and
+// applies the selected updates, logging the package manager's output as it
+// runs; follow it with the job stream
+// POST /api/system/updates {"packages","security_only"}
+func (s *Server) handleAPIApplyUpdates(w http.ResponseWriter, r *http.Request) {
+	var req applyUpdatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := sysupdate.CheckPackages(req.Packages); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	manager, err := sysupdate.Detect()
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return s.applyUpdates(ctx, manager, &req, func(string, ...interface{}) {})
+		})
+		return
+	}
+
+	job, err := s.jobs.Enqueue(r.Context(), storage.Job{Kind: jobs.KindUpdate},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			return s.applyUpdates(ctx, manager, &req, logf)
+		})
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, job)
+}
+
+// applyUpdates runs an update job: refresh, upgrade, then report whether a reboot is needed
+func (s *Server) applyUpdates(ctx context.Context, manager string, req *applyUpdatesRequest, logf jobs.Logf) error {
+	logf("refreshing %s package lists", manager)
+	if err := sysupdate.Refresh(ctx, manager); err != nil {
+		return err
+	}
+	switch {
+	case len(req.Packages) > 0:
+		logf("upgrading %s", strings.Join(req.Packages, " "))
+	case req.SecurityOnly:
+		logf("applying security updates")
+	default:
+		logf("applying all updates")
+	}
+	if err := sysupdate.Apply(ctx, manager, req.Packages, req.SecurityOnly, func(line string) { logf("%s", line) }); err != nil {
+		return err
+	}
+	if reboot := sysupdate.RebootRequired(ctx, manager); reboot.Required {
+		if len(reboot.Packages) > 0 {
+			logf("reboot required by %s", strings.Join(reboot.Packages, " "))
+		} else {
+			logf("reboot required")
+		}
+	}
+	return nil
+}
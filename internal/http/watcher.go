@@ -0,0 +1,94 @@
+package http
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"servio/internal/events"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+// SetUnitWatcher has the state watcher woken by units' changes, so status
+// changes reach the cache and the events stream as systemd signals them.
+// The server runs the watcher from Start.
+func (s *Server) SetUnitWatcher(units *systemd.UnitWatcher) {
+	s.units = units
+	units.OnChange(func(unit, state string) {
+		select {
+		case s.stateChanged <- struct{}{}:
+		default: // a pass is already pending
+		}
+	})
+}
+
+// watchServiceStates reads the systemd state of every service whenever the
+// unit watcher reports a change, and at the dashboard refresh interval for
+// services it cannot follow (containers, agent hosts, or every service while
+// D-Bus is unavailable). It keeps the status cache current and publishes
+// service.started when a unit becomes active, service.crashed when it enters
+// the failed state, and service.stopped when it goes inactive. The first
+// observation of a service only records its state, so restarting servio does
+// not replay events.
+func (s *Server) watchServiceStates(ctx context.Context) {
+	last := make(map[int64]string)
+	for {
+		services, err := s.allServices(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to list services for state watcher", "error", err)
+		} else {
+			s.statuses.retain(services)
+		}
+		for _, sv := range services {
+			state := s.svcManager.ActiveState(ctx, sv.ServiceName())
+			if state == "" {
+				// Unknown, e.g. its agent host is down; keep the last state
+				continue
+			}
+			switch {
+			case state == "active":
+				s.statuses.set(sv.ID, "running")
+			case s.svcManager.ServiceExists(sv.ServiceName()):
+				s.statuses.set(sv.ID, "stopped")
+			default:
+				s.statuses.set(sv.ID, "not installed")
+			}
+			prev, seen := last[sv.ID]
+			last[sv.ID] = state
+			if !seen || state == prev {
+				continue
+			}
+
+			e := events.Event{ProjectID: sv.ProjectID, ServiceID: sv.ID, Data: map[string]interface{}{
+				"service":        sv.Name,
+				"state":          state,
+				"previous_state": prev,
+				"status":         "stopped",
+			}}
+			switch state {
+			case "active":
+				e.Type = events.ServiceStarted
+				e.Data["status"] = "running"
+			case "failed":
+				e.Type = events.ServiceCrashed
+			case "inactive":
+				e.Type = events.ServiceStopped
+			default:
+				continue
+			}
+			s.events.Publish(e)
+		}
+
+		seconds, _ := s.store.GetSettingInt(ctx, storage.SettingDashboardRefreshSeconds)
+		if seconds <= 0 {
+			seconds = 2
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stateChanged:
+		case <-time.After(time.Duration(seconds) * time.Second):
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"servio/internal/events"
+	"servio/internal/storage"
+)
+
+// webhookRequest is the body for creating or updating a webhook
+type webhookRequest struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`            // empty subscribes to every event
+	Secret  string   `json:"secret,omitempty"`  // generated on create when empty; kept on update when empty
+	Enabled *bool    `json:"enabled,omitempty"` // defaults to true
+}
+
+// validate checks the URL and event names
+func (req *webhookRequest) validate() error {
+	var fields []storage.FieldError
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields = append(fields, storage.FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(events.Types, e) {
+			fields = append(fields, storage.FieldError{Field: "events", Message: "unknown event " + strconv.Quote(e)})
+		}
+	}
+	if len(fields) > 0 {
+		return &storage.ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// applyWebhook copies the request onto a webhook, encrypting a new secret if one is set
+func (s *Server) applyWebhook(req *webhookRequest, hook *storage.Webhook) error {
+	hook.URL = req.URL
+	hook.Events = req.Events
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	if req.Secret != "" {
+		ciphertext, err := s.cipher.Encrypt(req.Secret)
+		if err != nil {
+			return err
+		}
+		hook.Ciphertext = ciphertext
+	}
+	return nil
+}
+
+// handleAPIListWebhooks lists webhooks (secrets are never returned)
+// GET /api/webhooks
+func (s *Server) handleAPIListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := s.store.ListWebhooks(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, hooks)
+}
+
+// handleAPICreateWebhook creates a webhook. The signing secret is returned
+// in this response only.
+// POST /api/webhooks {"url","events","secret","enabled"}
+func (s *Server) handleAPICreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if req.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			apiError(w, r, err)
+			return
+		}
+		req.Secret = hex.EncodeToString(buf)
+	}
+
+	hook := &storage.Webhook{Enabled: true}
+	if err := s.applyWebhook(&req, hook); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.CreateWebhook(r.Context(), hook); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, webhookCreatedResponse{Webhook: hook, Secret: req.Secret})
+}
+
+// handleAPIGetWebhook returns a webhook
+// GET /api/webhooks/{id}
+func (s *Server) handleAPIGetWebhook(w http.ResponseWriter, r *http.Request) {
+	if hook, ok := s.loadWebhook(w, r); ok {
+		jsonResponse(w, hook)
+	}
+}
+
+// handleAPIUpdateWebhook replaces a webhook's URL, events, and enabled flag,
+// and its secret when one is given
+// PUT /api/webhooks/{id} {"url","events","secret","enabled"}
+func (s *Server) handleAPIUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.applyWebhook(&req, hook); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.store.UpdateWebhook(r.Context(), hook); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, hook)
+}
+
+// handleAPIDeleteWebhook deletes a webhook and its delivery history
+// DELETE /api/webhooks/{id}
+func (s *Server) handleAPIDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.DeleteWebhook(r.Context(), hook.ID); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIListWebhookDeliveries lists a webhook's recent deliveries, newest first
+// GET /api/webhooks/{id}/deliveries?limit=50
+func (s *Server) handleAPIListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	deliveries, err := s.store.ListWebhookDeliveries(r.Context(), hook.ID, limit)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, deliveries)
+}
+
+// handleAPITestWebhook sends a ping event with a single attempt and returns the delivery
+// POST /api/webhooks/{id}/test
+func (s *Server) handleAPITestWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := s.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	delivery, err := s.webhooks.Test(r.Context(), hook)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	jsonResponse(w, delivery)
+}
+
+// loadWebhook reads the {id} webhook. It writes the error response itself.
+func (s *Server) loadWebhook(w http.ResponseWriter, r *http.Request) (*storage.Webhook, bool) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid webhook ID", http.StatusBadRequest)
+		return nil, false
+	}
+	hook, err := s.store.GetWebhook(r.Context(), id)
+	if err != nil || hook == nil {
+		jsonError(w, r, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	return hook, true
+}
@@ -0,0 +1,295 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"servio/internal/acme"
+	"servio/internal/jobs"
+	"servio/internal/nginx"
+	"servio/internal/storage"
+)
+
+// wildcardRequest names the domain of a wildcard certificate
+type wildcardRequest struct {
+	Domain string `json:"domain"` // e.g. example.com, for example.com and *.example.com
+}
+
+// wildcardName is the certbot name of a domain's wildcard certificate
+func wildcardName(domain string) string {
+	return "servio-wildcard-" + domain
+}
+
+// loadWildcards hands the issued wildcard certificates to the nginx manager,
+// so generated sites they cover serve them
+func (s *Server) loadWildcards(ctx context.Context) error {
+	certs, err := s.store.ListWildcardCertificates(ctx)
+	if err != nil {
+		return err
+	}
+	var wildcards []nginx.Wildcard
+	for _, c := range certs {
+		if c.ExpiresAt != nil {
+			wildcards = append(wildcards, nginx.Wildcard{Domain: c.Domain, Name: c.Name})
+		}
+	}
+	s.nginxManager.SetWildcards(wildcards)
+	return nil
+}
+
+// wildcardSites returns the projects on this server whose generated site a
+// wildcard certificate of domain covers, leaving out those with a
+// certificate of their own
+func (s *Server) wildcardSites(ctx context.Context, domain string) ([]*storage.Project, error) {
+	projects, err := s.store.ListProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var covered []*storage.Project
+	for _, project := range projects {
+		if project.HostID != 0 || project.NginxRaw != "" || project.Certificate != "" {
+			continue
+		}
+		names := acme.Domains(project.Domain)
+		all := len(names) > 0
+		for _, name := range names {
+			all = all && acme.WildcardCovers(domain, name)
+		}
+		if all {
+			covered = append(covered, project)
+		}
+	}
+	return covered, nil
+}
+
+// handleAPIListWildcardCertificates lists the wildcard certificates with the
+// projects whose sites serve them
+// GET /api/certificates
+func (s *Server) handleAPIListWildcardCertificates(w http.ResponseWriter, r *http.Request) {
+	certs, err := s.store.ListWildcardCertificates(r.Context())
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	for _, c := range certs {
+		if c.ExpiresAt == nil {
+			continue
+		}
+		sites, err := s.wildcardSites(r.Context(), c.Domain)
+		if err != nil {
+			apiError(w, r, err)
+			return
+		}
+		for _, project := range sites {
+			if s.nginxManager.SiteCertificate(project) == c.Name {
+				c.Projects = append(c.Projects, project.Name)
+			}
+		}
+	}
+	jsonResponse(w, certs)
+}
+
+// handleAPIIssueWildcardCertificate queues a certificate job issuing a
+// certificate for a domain and all its subdomains through the DNS-01
+// challenge, which wildcards require. Posting a domain again retries it.
+// POST /api/certificates {"domain"}
+func (s *Server) handleAPIIssueWildcardCertificate(w http.ResponseWriter, r *http.Request) {
+	var body wildcardRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	domain := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(body.Domain)), "*.")
+	if err := acme.CheckDomain(domain); err != nil {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "domain", Message: "must be a host name such as example.com"}}})
+		return
+	}
+	req, err := s.acmeRequest(r.Context(), wildcardName(domain), acme.WildcardDomains(domain))
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if isDryRun(r) {
+		respondDryRun(w, r, func(ctx context.Context) error {
+			if err := acme.Issue(ctx, req, func(string) {}); err != nil {
+				return err
+			}
+			sites, err := s.wildcardSites(ctx, domain)
+			if err != nil {
+				return err
+			}
+			for _, project := range sites {
+				if err := s.reinstallSite(ctx, project, req.Name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return
+	}
+
+	cert, err := s.store.GetWildcardCertificateByDomain(r.Context(), domain)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if cert == nil {
+		cert = &storage.WildcardCertificate{Domain: domain, Projects: []string{}}
+	}
+	cert.Name, cert.Provider = req.Name, req.Provider
+	cert.Status, cert.Error = storage.JobQueued, ""
+	if err := s.store.SaveWildcardCertificate(r.Context(), cert); err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	job, err := s.jobs.Enqueue(r.Context(), storage.Job{Kind: jobs.KindCertificate},
+		func(ctx context.Context, job *storage.Job, logf jobs.Logf) error {
+			cert.JobID = job.ID
+			return s.issueWildcardCertificate(ctx, cert, req, logf)
+		})
+	if err != nil {
+		cert.Status, cert.Error = storage.JobFailed, err.Error()
+		s.store.SaveWildcardCertificate(r.Context(), cert)
+		apiError(w, r, err)
+		return
+	}
+	cert.JobID = job.ID
+	if err := s.store.SaveWildcardCertificate(r.Context(), cert); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, cert)
+}
+
+// issueWildcardCertificate is the work of a wildcard certificate job. Once
+// certbot has the certificate, the installed sites it covers are rewritten
+// to serve it.
+func (s *Server) issueWildcardCertificate(ctx context.Context, cert *storage.WildcardCertificate, req acme.Request, logf jobs.Logf) error {
+	err := func() error {
+		cert.Status = storage.JobRunning
+		if err := s.store.SaveWildcardCertificate(ctx, cert); err != nil {
+			return err
+		}
+		credentials, _, err := s.resolver.Resolve(ctx, &storage.Service{}, req.Credentials)
+		if err != nil {
+			return err
+		}
+		req.Credentials = credentials
+
+		logf("requesting a certificate for %v through %s", req.Domains, req.Provider)
+		if err := acme.Issue(ctx, req, func(line string) { logf("%s", line) }); err != nil {
+			return err
+		}
+		expires, err := acme.Expiry(ctx, req.Name)
+		if err != nil {
+			return err
+		}
+		cert.ExpiresAt = &expires
+		logf("certificate %s expires %s; certbot renews it", req.Name, expires.Format(time.RFC3339))
+		return nil
+	}()
+
+	cert.Status, cert.Error = storage.JobSucceeded, ""
+	if err != nil {
+		cert.Status, cert.Error = storage.JobFailed, err.Error()
+	}
+	if saveErr := s.store.SaveWildcardCertificate(ctx, cert); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.loadWildcards(ctx); err != nil {
+		return err
+	}
+	sites, err := s.wildcardSites(ctx, cert.Domain)
+	if err != nil {
+		return err
+	}
+	for _, project := range sites {
+		if !s.nginxManager.SiteExists(project) || s.nginxManager.SiteCertificate(project) != cert.Name {
+			continue
+		}
+		logf("switching the site of %s to HTTPS", project.Name)
+		if err := s.nginxManager.InstallSite(ctx, project); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleAPIDeleteWildcardCertificate switches the installed sites a wildcard
+// certificate covers back to plain HTTP, or another wildcard covering them,
+// then deletes it and stops its renewal
+// DELETE /api/certificates/{id}
+func (s *Server) handleAPIDeleteWildcardCertificate(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		jsonError(w, r, "Invalid certificate ID", http.StatusBadRequest)
+		return
+	}
+	cert, err := s.store.GetWildcardCertificate(r.Context(), id)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if cert == nil {
+		jsonError(w, r, "Certificate not found", http.StatusNotFound)
+		return
+	}
+
+	// A certificate that never got issued has nothing to remove from the host
+	if cert.ExpiresAt != nil {
+		if err := s.removeWildcard(r.Context(), cert); err != nil {
+			// Sites go back to what the stored certificates say
+			if err := s.loadWildcards(r.Context()); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to reload wildcard certificates", "error", err)
+			}
+			apiError(w, r, err)
+			return
+		}
+	}
+	if err := s.store.DeleteWildcardCertificate(r.Context(), id); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := s.loadWildcards(r.Context()); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeWildcard stops sites from serving a wildcard certificate, then
+// deletes it with certbot
+func (s *Server) removeWildcard(ctx context.Context, cert *storage.WildcardCertificate) error {
+	certs, err := s.store.ListWildcardCertificates(ctx)
+	if err != nil {
+		return err
+	}
+	var others []nginx.Wildcard
+	for _, c := range certs {
+		if c.ID != cert.ID && c.ExpiresAt != nil {
+			others = append(others, nginx.Wildcard{Domain: c.Domain, Name: c.Name})
+		}
+	}
+	s.nginxManager.SetWildcards(others)
+
+	sites, err := s.wildcardSites(ctx, cert.Domain)
+	if err != nil {
+		return err
+	}
+	for _, project := range sites {
+		if err := s.reinstallSite(ctx, project, ""); err != nil {
+			return err
+		}
+	}
+	return acme.Delete(ctx, cert.Name)
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"servio/internal/blueprints"
+	"servio/internal/redis"
+	"servio/internal/storage"
+)
+
+// workerQueue is one queue a worker consumes, with its waiting jobs when the broker can be read
+type workerQueue struct {
+	Name    string `json:"name"`
+	Pending *int64 `json:"pending,omitempty"`
+}
+
+// workerResponse is a worker's concurrency and queues. StatsError says why
+// the queues have no pending counts: only a redis broker on this server,
+// named by the service's environment, can be read.
+type workerResponse struct {
+	Concurrency int           `json:"concurrency"`
+	Queues      []workerQueue `json:"queues"`
+	Command     string        `json:"command"` // the command the settings generate
+	StatsError  string        `json:"stats_error,omitempty"`
+}
+
+// workerBlueprint returns the blueprint of a worker service
+func (s *Server) workerBlueprint(service *storage.Service) (blueprints.Worker, error) {
+	bp, ok := s.blueprints.Get(service.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no blueprint", blueprints.ErrNotWorker, service.Name)
+	}
+	worker, ok := bp.(blueprints.Worker)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is a %s service", blueprints.ErrNotWorker, service.Name, service.Type)
+	}
+	return worker, nil
+}
+
+// queueLengths reads the waiting jobs of a worker's queues from its broker
+func (s *Server) queueLengths(ctx context.Context, worker blueprints.Worker, service *storage.Service, queues []string) (map[string]int64, error) {
+	brokerURL := worker.BrokerURL(service)
+	if brokerURL == "" {
+		return nil, fmt.Errorf("the environment names no broker")
+	}
+	brokerURL, _, err := s.resolver.Resolve(ctx, service, brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkServiceLocal(ctx, service, "queue stats"); err != nil {
+		return nil, err
+	}
+	rs, err := redis.ForURL(brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(queues))
+	for i, q := range queues {
+		keys[i] = worker.QueueKey(q)
+	}
+	lengths, err := rs.ListLengths(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	byQueue := make(map[string]int64, len(queues))
+	for _, q := range queues {
+		byQueue[q] = lengths[worker.QueueKey(q)]
+	}
+	return byQueue, nil
+}
+
+// handleAPIGetWorker returns a worker's concurrency and queues, with the jobs
+// waiting in each when its broker is a redis on this server
+// GET /api/services/{id}/worker
+func (s *Server) handleAPIGetWorker(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	worker, err := s.workerBlueprint(service)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	settings := worker.WorkerSettings(service)
+	resp := workerResponse{Concurrency: settings.Concurrency, Queues: make([]workerQueue, len(settings.Queues)), Command: worker.GenerateCommand(service)}
+	lengths, err := s.queueLengths(r.Context(), worker, service, settings.Queues)
+	if err != nil {
+		resp.StatsError = err.Error()
+	}
+	for i, q := range settings.Queues {
+		resp.Queues[i].Name = q
+		if n, ok := lengths[q]; ok {
+			resp.Queues[i].Pending = &n
+		}
+	}
+	jsonResponse(w, resp)
+}
+
+// handleAPIScaleWorker sets a worker's concurrency and queues and queues a
+// job that regenerates its command and restarts it gracefully: the worker
+// gets TERM and finishes its running jobs before it starts again. A command
+// edited by hand is not overwritten; clearing it lets the blueprint generate it.
+// PUT /api/services/{id}/worker {"concurrency", "queues"}
+func (s *Server) handleAPIScaleWorker(w http.ResponseWriter, r *http.Request, service *storage.Service) {
+	var settings blueprints.WorkerSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		jsonError(w, r, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	worker, err := s.workerBlueprint(service)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		apiError(w, r, err)
+		return
+	}
+	if service.Command != "" && service.Command != worker.GenerateCommand(service) {
+		apiError(w, r, &storage.ValidationError{Fields: []storage.FieldError{{Field: "command", Message: "is set by hand; clear it so the worker's command is generated from its settings"}}})
+		return
+	}
+	config, err := blueprints.ApplyWorkerSettings(service, settings)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+
+	command := ""
+	patch := storage.PatchServiceRequest{Command: &command, Config: &config}
+	req := patch.Apply(service)
+	steps := setupSteps{graceful: true}
+	if isDryRun(r) {
+		scaled := *service
+		scaled.Command, scaled.Config = command, config
+		respondDryRun(w, r, func(ctx context.Context) error {
+			return s.setupService(ctx, &scaled, steps, func(string, ...any) {})
+		})
+		return
+	}
+	service, err = s.store.UpdateService(r.Context(), service.ID, &req)
+	if err != nil {
+		apiError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, serviceJobResponse{Service: service, JobID: s.enqueueInstall(r, service, steps)})
+}
@@ -0,0 +1,310 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"servio/internal/ansi"
+	"servio/internal/storage"
+)
+
+// WebSocket timing and limits
+const (
+	wsWriteWait     = 10 * time.Second
+	wsPongWait      = 60 * time.Second
+	wsPingPeriod    = wsPongWait * 9 / 10
+	wsMaxMessage    = 4096
+	wsMaxSubscribed = 32
+)
+
+// WebSocket topics a client can subscribe to
+const (
+	wsTopicLogs   = "logs"   // journal lines for a service
+	wsTopicDeploy = "deploy" // deployment status changes and log lines for a service
+	wsTopicStatus = "status" // running/stopped changes for a service, or all services when service_id is 0
+)
+
+// The default origin check rejects cross-site pages, which basic auth alone would not
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// wsSession multiplexes subscriptions over one connection. A single writer
+// goroutine owns the connection; subscriptions hand it messages via send.
+type wsSession struct {
+	srv    *Server
+	conn   *websocket.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   chan wsMessage
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+}
+
+// handleWebSocket upgrades to a WebSocket carrying logs, deploy output, and status changes
+// GET /ws - then send {"type":"subscribe","topic":"logs","service_id":1}
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response
+		slog.DebugContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	sess := &wsSession{
+		srv:    s,
+		conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+		send:   make(chan wsMessage, 256),
+		subs:   make(map[string]context.CancelFunc),
+	}
+
+	sess.wg.Add(1)
+	go sess.writeLoop()
+
+	sess.readLoop()
+	cancel()
+	sess.wg.Wait()
+}
+
+// readLoop handles subscribe/unsubscribe messages until the client goes away
+func (ws *wsSession) readLoop() {
+	ws.conn.SetReadLimit(wsMaxMessage)
+	ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var req wsRequest
+		if err := ws.conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.DebugContext(ws.ctx, "WebSocket closed", "error", err)
+			}
+			return
+		}
+
+		switch req.Type {
+		case "subscribe":
+			ws.subscribe(req)
+		case "unsubscribe":
+			ws.unsubscribe(req)
+		default:
+			ws.emit(wsMessage{Type: "error", Error: fmt.Sprintf("unknown message type %q", req.Type)})
+		}
+	}
+}
+
+// writeLoop is the only writer on the connection; it also keeps it alive with pings
+func (ws *wsSession) writeLoop() {
+	defer ws.wg.Done()
+	// On a write failure, end the session and unblock readLoop
+	fail := func() {
+		ws.cancel()
+		ws.conn.Close()
+	}
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ws.ctx.Done():
+			ws.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+			return
+		case msg := <-ws.send:
+			ws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := ws.conn.WriteJSON(msg); err != nil {
+				fail()
+				return
+			}
+		case <-ticker.C:
+			if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				fail()
+				return
+			}
+		}
+	}
+}
+
+// emit queues a message for the writer, giving up once the session ends
+func (ws *wsSession) emit(msg wsMessage) bool {
+	select {
+	case ws.send <- msg:
+		return true
+	case <-ws.ctx.Done():
+		return false
+	}
+}
+
+func (ws *wsSession) subscribe(req wsRequest) {
+	var stream func(context.Context, *storage.Service)
+	switch req.Topic {
+	case wsTopicLogs:
+		mode, err := ansi.ParseMode(req.ANSI, ansi.ModeStrip)
+		if err != nil {
+			ws.emit(wsMessage{Type: "error", Topic: req.Topic, ServiceID: req.ServiceID, Error: err.Error()})
+			return
+		}
+		stream = func(ctx context.Context, service *storage.Service) { ws.streamLogs(ctx, service, mode) }
+	case wsTopicDeploy:
+		stream = ws.streamDeploy
+	case wsTopicStatus:
+		stream = ws.streamStatus
+	default:
+		ws.emit(wsMessage{Type: "error", Topic: req.Topic, Error: "unknown topic"})
+		return
+	}
+
+	// Only status accepts service_id 0, meaning every service
+	var service *storage.Service
+	if req.ServiceID != 0 || req.Topic != wsTopicStatus {
+		sv, err := ws.srv.store.GetService(ws.ctx, req.ServiceID)
+		if err != nil || sv == nil {
+			ws.emit(wsMessage{Type: "error", Topic: req.Topic, ServiceID: req.ServiceID, Error: "service not found"})
+			return
+		}
+		service = sv
+	}
+
+	key := fmt.Sprintf("%s:%d", req.Topic, req.ServiceID)
+	ws.mu.Lock()
+	if _, ok := ws.subs[key]; ok {
+		ws.mu.Unlock()
+		ws.emit(wsMessage{Type: "subscribed", Topic: req.Topic, ServiceID: req.ServiceID})
+		return
+	}
+	if len(ws.subs) >= wsMaxSubscribed {
+		ws.mu.Unlock()
+		ws.emit(wsMessage{Type: "error", Topic: req.Topic, ServiceID: req.ServiceID, Error: "too many subscriptions"})
+		return
+	}
+	ctx, cancel := context.WithCancel(ws.ctx)
+	ws.subs[key] = cancel
+	ws.mu.Unlock()
+
+	ws.emit(wsMessage{Type: "subscribed", Topic: req.Topic, ServiceID: req.ServiceID})
+
+	ws.wg.Add(1)
+	go func() {
+		defer ws.wg.Done()
+		stream(ctx, service)
+	}()
+}
+
+func (ws *wsSession) unsubscribe(req wsRequest) {
+	key := fmt.Sprintf("%s:%d", req.Topic, req.ServiceID)
+	ws.mu.Lock()
+	cancel, ok := ws.subs[key]
+	delete(ws.subs, key)
+	ws.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	ws.emit(wsMessage{Type: "unsubscribed", Topic: req.Topic, ServiceID: req.ServiceID})
+}
+
+// streamLogs follows the service's journal, handling escape codes per mode
+func (ws *wsSession) streamLogs(ctx context.Context, service *storage.Service, mode string) {
+	entries, err := ws.srv.svcManager.StreamLogs(ctx, service.ServiceName(), "")
+	if err != nil {
+		ws.emit(wsMessage{Type: "error", Topic: wsTopicLogs, ServiceID: service.ID, Error: err.Error()})
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry, ok := <-entries:
+			if !ok {
+				return
+			}
+			if !ws.emit(wsMessage{Type: "log", ServiceID: service.ID, Line: ansi.Apply(mode, entry.Line())}) {
+				return
+			}
+		}
+	}
+}
+
+// streamDeploy forwards deployment progress for the service
+func (ws *wsSession) streamDeploy(ctx context.Context, service *storage.Service) {
+	events, cancel := ws.srv.deployer.Subscribe(service.ID)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if !ws.emit(wsMessage{Type: "deploy", ServiceID: service.ID, Deployment: &event}) {
+				return
+			}
+		}
+	}
+}
+
+// streamStatus polls unit state at the dashboard refresh interval and reports changes.
+// A nil service watches every service.
+func (ws *wsSession) streamStatus(ctx context.Context, service *storage.Service) {
+	seconds, _ := ws.srv.store.GetSettingInt(ctx, storage.SettingDashboardRefreshSeconds)
+	if seconds <= 0 {
+		seconds = 2
+	}
+	ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+	defer ticker.Stop()
+
+	last := make(map[int64]string)
+	for {
+		services := []*storage.Service{service}
+		if service == nil {
+			var err error
+			if services, err = ws.srv.allServices(ctx); err != nil {
+				slog.WarnContext(ctx, "Failed to list services for status stream", "error", err)
+			}
+		}
+		for _, sv := range services {
+			status := ws.srv.cachedServiceStatus(ctx, sv)
+			if last[sv.ID] == status {
+				continue
+			}
+			last[sv.ID] = status
+			if !ws.emit(wsMessage{Type: "status", ServiceID: sv.ID, Status: status}) {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// allServices lists the services of every project
+func (s *Server) allServices(ctx context.Context) ([]*storage.Service, error) {
+	projects, err := s.store.ListProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var services []*storage.Service
+	for _, p := range projects {
+		list, err := s.store.ListServicesByProject(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, list...)
+	}
+	return services, nil
+}
@@ -0,0 +1,152 @@
+// Package i18n translates the dashboard and API error messages. Messages are
+// keyed by their English text: a catalog in locales/ maps each message to its
+// translation in one language, and anything a catalog lacks falls back to the
+// English key, so a partial catalog is still usable.
+//
+// Adding a language is adding locales/<tag>.json, where tag is a BCP 47
+// language tag such as de or pt-BR; it is embedded in the binary.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language messages are written in, used when the client
+// accepts none of the catalogs
+const Default = "en"
+
+// nameKey is the catalog entry holding the language's name in itself, shown
+// in the language picker
+const nameKey = "_name"
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// catalogs maps lower-cased language tags to their messages
+var catalogs = loadCatalogs()
+
+// Locale is a language a catalog is available for
+type Locale struct {
+	Tag  string `json:"tag"`
+	Name string `json:"name"`
+}
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := localesFS.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: %v", err))
+	}
+	loaded := make(map[string]map[string]string, len(files))
+	for _, f := range files {
+		data, err := localesFS.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: %v", err))
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			// Catalogs are embedded, so a broken one is a build mistake
+			panic(fmt.Sprintf("i18n: locales/%s: %v", f.Name(), err))
+		}
+		loaded[strings.ToLower(strings.TrimSuffix(f.Name(), ".json"))] = messages
+	}
+	return loaded
+}
+
+// Locales lists the available languages, sorted by tag
+func Locales() []Locale {
+	locales := make([]Locale, 0, len(catalogs))
+	for tag, messages := range catalogs {
+		name := messages[nameKey]
+		if name == "" {
+			name = tag
+		}
+		locales = append(locales, Locale{Tag: tag, Name: name})
+	}
+	sort.Slice(locales, func(i, j int) bool { return locales[i].Tag < locales[j].Tag })
+	return locales
+}
+
+// Supported returns the catalog tag for tag, falling back from a regional
+// variant such as de-AT to its language, and false when there is none
+func Supported(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", false
+	}
+	if _, ok := catalogs[tag]; ok {
+		return tag, true
+	}
+	if base, _, ok := strings.Cut(tag, "-"); ok {
+		if _, ok := catalogs[base]; ok {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+// Match picks the catalog for an Accept-Language header, e.g.
+// "de-CH, fr;q=0.8, en;q=0.5", preferring higher weights and then earlier
+// entries, or Default when none is available
+func Match(acceptLanguage string) string {
+	type choice struct {
+		tag    string
+		weight float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			w, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = w
+		}
+		if weight > 0 {
+			choices = append(choices, choice{tag: tag, weight: weight})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].weight > choices[j].weight })
+	for _, c := range choices {
+		if tag, ok := Supported(c.tag); ok {
+			return tag
+		}
+	}
+	return Default
+}
+
+// T translates message into locale. With args, the translation is a format
+// for fmt.Sprintf, so translated messages keep the English one's verbs.
+func T(locale, message string, args ...interface{}) string {
+	text := message
+	if translated := catalogs[strings.ToLower(locale)][message]; translated != "" {
+		text = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+type localeKey struct{}
+
+// WithLocale makes locale the language of messages for ctx
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// FromContext returns the language set by WithLocale, or Default
+func FromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return locale
+	}
+	return Default
+}
@@ -0,0 +1,70 @@
+package i18n
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"DE", "de"},
+		{"de-AT", "de"},
+		{"de-CH, fr;q=0.8, en;q=0.5", "de"},
+		{"fr, de;q=0.9, en;q=0.8", "de"},
+		{"en;q=0.5, de;q=0.7", "de"},
+		{"en, de", "en"},             // equal weights keep the client's order
+		{"de;q=0.5, en;q=0.5", "de"}, // likewise
+		{"de;q=0, en;q=0.1", "en"},   // q=0 refuses a language
+		{"de;q=abc, en;q=0.2", "en"}, // malformed weights are skipped
+		{" de-de ; q=0.9 ,fr", "de"}, // spacing
+		{"fr, ja", "en"},             // nothing available
+		{"*", "en"},
+		{"pt-BR, pt;q=0.9", "en"},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.header); got != tt.want {
+			t.Errorf("Match(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSupported(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"de", "de", true},
+		{" De-at ", "de", true},
+		{"en-US", "en", true},
+		{"deu", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := Supported(tt.tag); got != tt.want || ok != tt.ok {
+			t.Errorf("Supported(%q) = %q, %v; want %q, %v", tt.tag, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// verbs matches fmt verbs, which translations must keep in order
+var verbs = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]+)?[a-zA-Z%]`)
+
+func TestCatalogVerbs(t *testing.T) {
+	for tag, messages := range catalogs {
+		for message, translated := range messages {
+			if message == nameKey || translated == "" {
+				continue
+			}
+			if want, got := verbs.FindAllString(message, -1), verbs.FindAllString(translated, -1); !slices.Equal(got, want) {
+				t.Errorf("%s: %q translates %v as %v in %q", tag, message, want, got, translated)
+			}
+		}
+	}
+}
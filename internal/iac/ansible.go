@@ -0,0 +1,121 @@
+package iac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jinjaEscapes keeps Ansible from templating what files contain
+var jinjaEscapes = strings.NewReplacer("{{", "{{ '{{' }}", "{%", "{{ '{%' }}", "{#", "{{ '{#' }}")
+
+// renderAnsible writes a playbook for the hosts in servio_hosts (default
+// all). Secret variables have no default, so a run without them fails
+// before changing anything.
+func renderAnsible(h *Host) string {
+	var b strings.Builder
+	b.WriteString(header(h, "#"))
+	b.WriteString("- name: Servio services\n")
+	b.WriteString("  hosts: \"{{ servio_hosts | default('all') }}\"\n")
+	b.WriteString("  become: true\n")
+	if len(h.Variables) > 0 {
+		b.WriteString("  vars:\n")
+		for _, v := range h.Variables {
+			hint := strings.ReplaceAll("Set "+v.Name+": "+v.Description, "'", `\'`)
+			fmt.Fprintf(&b, "    %s: %s\n", v.Name, strconv.Quote("{{ undef(hint='"+hint+"') }}"))
+		}
+	}
+	b.WriteString("  tasks:\n")
+
+	task := func(name, module string, args ...string) {
+		fmt.Fprintf(&b, "    - name: %s\n      %s:\n", yamlString(name), module)
+		for i := 0; i < len(args); i += 2 {
+			fmt.Fprintf(&b, "        %s: %s\n", args[i], args[i+1])
+		}
+	}
+
+	packages := h.Packages
+	if h.Nginx {
+		packages = append([]string{"nginx"}, packages...)
+	}
+	if len(packages) > 0 {
+		fmt.Fprintf(&b, "    - name: Install packages\n      ansible.builtin.package:\n        name:\n")
+		for _, p := range packages {
+			fmt.Fprintf(&b, "          - %s\n", yamlString(p))
+		}
+		b.WriteString("        state: present\n")
+	}
+	for _, r := range h.Repos {
+		args := []string{"repo", yamlString(r.URL), "dest", yamlString(r.Dir)}
+		if r.Branch != "" {
+			args = append(args, "version", yamlString(r.Branch))
+		}
+		task("Check out "+r.Dir, "ansible.builtin.git", args...)
+	}
+	for _, f := range h.Files {
+		task("Write "+f.Path, "ansible.builtin.copy",
+			"dest", yamlString(f.Path),
+			"content", yamlBlock(f.Content),
+			"owner", yamlString(orRoot(f.Owner)),
+			"mode", yamlString(f.Mode))
+		if HasVars(f.Content) {
+			b.WriteString("      no_log: true\n")
+		}
+		if f.Site {
+			b.WriteString("      notify: Reload nginx\n")
+		} else if strings.HasPrefix(f.Path, "/etc/systemd/") {
+			b.WriteString("      notify: Reload systemd\n")
+		}
+	}
+	for _, l := range h.Links {
+		task("Enable "+l.Target, "ansible.builtin.file", "src", yamlString(l.Target), "dest", yamlString(l.Path), "state", "link")
+		b.WriteString("      notify: Reload nginx\n")
+	}
+	b.WriteString("    - name: Apply unit and site changes\n      ansible.builtin.meta: flush_handlers\n")
+	enable := h.Enable
+	if h.Nginx {
+		enable = append([]string{"nginx.service"}, enable...)
+	}
+	for _, unit := range enable {
+		task("Enable and start "+unit, "ansible.builtin.systemd_service", "name", yamlString(unit), "enabled", "true", "state", "started")
+	}
+
+	b.WriteString("  handlers:\n")
+	b.WriteString("    - name: Reload systemd\n      ansible.builtin.systemd_service:\n        daemon_reload: true\n")
+	if h.Nginx {
+		b.WriteString("    - name: Reload nginx\n      ansible.builtin.shell: nginx -t && systemctl reload nginx\n")
+	}
+	return b.String()
+}
+
+// yamlString double-quotes s, escaping Jinja delimiters
+func yamlString(s string) string {
+	return strconv.Quote(jinjaEscapes.Replace(s))
+}
+
+// yamlBlock writes content as a literal block scalar with variables as
+// Jinja references. Its first line starts with an escape or text, never
+// spaces, so no indentation indicator is needed.
+func yamlBlock(content string) string {
+	content = jinjaEscapes.Replace(strings.TrimSuffix(content, "\n"))
+	content = varPattern.ReplaceAllString(content, "{{ $1 }}")
+	if strings.HasPrefix(content, " ") || content == "" {
+		content = "{{ '' }}" + content
+	}
+	var b strings.Builder
+	b.WriteString("|\n")
+	for _, line := range strings.Split(content, "\n") {
+		if line != "" {
+			b.WriteString("          " + line)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func orRoot(owner string) string {
+	if owner == "" {
+		return "root"
+	}
+	return owner
+}
@@ -0,0 +1,171 @@
+// Package iac renders what Servio manages on a host as infrastructure as
+// code: an Ansible playbook or a Terraform configuration that installs the
+// same packages, repository checkouts, units, .env files, and nginx sites.
+// Secrets never appear in the output; they become variables to supply.
+package iac
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"servio/internal/secrets"
+)
+
+// Export formats
+const (
+	FormatAnsible   = "ansible"
+	FormatTerraform = "terraform"
+)
+
+// ErrUnknownFormat is wrapped for formats other than FormatAnsible and FormatTerraform
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// typePackages are the packages each service type runs on, as Debian and
+// Ubuntu name them; commands installing more (pip, npm) are left to the app
+var typePackages = map[string][]string{
+	"django":   {"python3", "python3-pip"},
+	"python":   {"python3", "python3-pip"},
+	"node":     {"nodejs", "npm"},
+	"postgres": {"postgresql"},
+	"redis":    {"redis-server"},
+}
+
+// File is a file to write
+type File struct {
+	Path    string
+	Content string
+	Owner   string // "" for root
+	Mode    string // octal, e.g. "0644"
+	Site    bool   // an nginx site, reloaded when it changes
+}
+
+// Repo is a git checkout
+type Repo struct {
+	URL    string
+	Branch string // "" for the remote's default
+	Dir    string
+}
+
+// Link is a symlink, such as a site in nginx's sites-enabled
+type Link struct {
+	Path   string
+	Target string
+}
+
+// Variable is a value the output needs but must not contain
+type Variable struct {
+	Name        string
+	Description string
+}
+
+// Host is the state to export. Build it with the Add methods, which keep
+// lists free of duplicates, and Parameterize file contents holding secrets.
+type Host struct {
+	Packages  []string
+	Repos     []Repo
+	Files     []File
+	Links     []Link
+	Enable    []string // units to enable and start
+	Nginx     bool     // nginx is installed and reloaded
+	Variables []Variable
+	Skipped   []string // what could not be exported, and why
+}
+
+// varPattern matches the placeholders Var puts in file contents
+var varPattern = regexp.MustCompile(`__SERVIO_VAR_([a-z][a-z0-9_]*)__`)
+
+// identPattern matches the runs of characters replaced in variable and resource names
+var identPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// AddPackages adds the packages a service type needs
+func (h *Host) AddPackages(serviceType string) {
+	h.AddPackage(typePackages[serviceType]...)
+}
+
+// AddPackage adds packages by name
+func (h *Host) AddPackage(names ...string) {
+	for _, name := range names {
+		if !slices.Contains(h.Packages, name) {
+			h.Packages = append(h.Packages, name)
+		}
+	}
+}
+
+// AddRepo adds a checkout, once per directory
+func (h *Host) AddRepo(repo Repo) {
+	if !slices.ContainsFunc(h.Repos, func(r Repo) bool { return r.Dir == repo.Dir }) {
+		h.Repos = append(h.Repos, repo)
+		h.AddPackage("git")
+	}
+}
+
+// Skip records something that is left out of the export
+func (h *Host) Skip(format string, args ...interface{}) {
+	h.Skipped = append(h.Skipped, fmt.Sprintf(format, args...))
+}
+
+// Var declares a variable and returns the placeholder standing for its value
+// in file contents; each format puts in its own reference
+func (h *Host) Var(name, description string) string {
+	name = Ident(name)
+	if !slices.ContainsFunc(h.Variables, func(v Variable) bool { return v.Name == name }) {
+		h.Variables = append(h.Variables, Variable{Name: name, Description: description})
+	}
+	return "__SERVIO_VAR_" + name + "__"
+}
+
+// Parameterize replaces the secret references in content with variables
+func (h *Host) Parameterize(content string) string {
+	return secrets.ReplaceReferences(content, func(scheme, ref string) string {
+		if scheme == "secret" {
+			return h.Var("secret_"+ref, "Servio secret "+ref)
+		}
+		return h.Var(scheme+"_"+ref, scheme+":"+ref)
+	})
+}
+
+// HasVars reports whether content refers to variables
+func HasVars(content string) bool {
+	return varPattern.MatchString(content)
+}
+
+// Ident turns a name into a lowercase identifier both formats accept
+func Ident(name string) string {
+	ident := strings.Trim(identPattern.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if ident == "" || ident[0] < 'a' || ident[0] > 'z' {
+		ident = "v_" + ident
+	}
+	return ident
+}
+
+// Render formats h as a playbook or a Terraform configuration
+func Render(format string, h *Host) (string, error) {
+	switch format {
+	case FormatAnsible:
+		return renderAnsible(h), nil
+	case FormatTerraform:
+		return renderTerraform(h), nil
+	}
+	return "", fmt.Errorf("%w: %q (want %s or %s)", ErrUnknownFormat, format, FormatAnsible, FormatTerraform)
+}
+
+// header is the comment both formats start with
+func header(h *Host, prefix string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s Generated by Servio. Apply it to a fresh host to rebuild what Servio manages.\n", prefix)
+	if len(h.Variables) > 0 {
+		fmt.Fprintf(&b, "%s Secrets are not included; supply the variables below.\n", prefix)
+	}
+	for _, skipped := range h.Skipped {
+		fmt.Fprintf(&b, "%s Not exported: %s\n", prefix, skipped)
+	}
+	return b.String()
+}
+
+// shellQuote quotes an argument for /bin/sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
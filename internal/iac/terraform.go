@@ -0,0 +1,135 @@
+package iac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// heredocEnd closes file contents; a line of content equal to it would end them early
+const heredocEnd = "SERVIO_EOF"
+
+// hclEscapes keeps Terraform from interpolating what strings contain
+var hclEscapes = strings.NewReplacer("${", "$${", "%{", "%%{")
+
+// renderTerraform writes a configuration applied on the host itself: the
+// local provider writes the files, and local-exec runs the package manager,
+// git, and systemctl
+func renderTerraform(h *Host) string {
+	var b strings.Builder
+	b.WriteString(header(h, "#"))
+	b.WriteString(`terraform {
+  required_version = ">= 1.4"
+  required_providers {
+    local = {
+      source = "hashicorp/local"
+    }
+  }
+}
+`)
+	for _, v := range h.Variables {
+		fmt.Fprintf(&b, "\nvariable %q {\n  description = %s\n  type        = string\n  sensitive   = true\n}\n", v.Name, hclString(v.Description))
+	}
+
+	names := map[string]int{}
+	resource := func(kind, name string) string {
+		ident := Ident(name)
+		if names[kind+ident]++; names[kind+ident] > 1 {
+			ident += "_" + strconv.Itoa(names[kind+ident])
+		}
+		return ident
+	}
+	var deps []string
+
+	packages := h.Packages
+	if h.Nginx {
+		packages = append([]string{"nginx"}, packages...)
+	}
+	if len(packages) > 0 {
+		list := strings.Join(packages, " ")
+		command := "if command -v apt-get >/dev/null; then apt-get update && apt-get install -y " + list + "; else dnf install -y " + list + "; fi"
+		fmt.Fprintf(&b, "\nresource \"terraform_data\" \"packages\" {\n  input = %s\n  provisioner \"local-exec\" {\n    command = %s\n  }\n}\n", hclList(packages), hclString(command))
+		deps = append(deps, "terraform_data.packages")
+	}
+	for _, r := range h.Repos {
+		name := resource("repo", "repo_"+r.Dir)
+		clone := "git clone"
+		if r.Branch != "" {
+			clone += " --branch " + shellQuote(r.Branch)
+		}
+		clone += " -- " + shellQuote(r.URL) + " " + shellQuote(r.Dir)
+		command := "test -d " + shellQuote(r.Dir+"/.git") + " || " + clone
+		fmt.Fprintf(&b, "\nresource \"terraform_data\" %q {\n  input = %s\n", name, hclList([]string{r.URL, r.Branch, r.Dir}))
+		if len(packages) > 0 {
+			b.WriteString("  depends_on = [terraform_data.packages]\n")
+		}
+		fmt.Fprintf(&b, "  provisioner \"local-exec\" {\n    command = %s\n  }\n}\n", hclString(command))
+		deps = append(deps, "terraform_data."+name)
+	}
+
+	var triggers, chowns []string
+	for _, f := range h.Files {
+		kind := "local_file"
+		if f.Mode == "0600" || HasVars(f.Content) {
+			kind = "local_sensitive_file"
+		}
+		name := resource(kind, f.Path)
+		fmt.Fprintf(&b, "\nresource %q %q {\n  filename             = %s\n  file_permission      = %q\n  directory_permission = \"0755\"\n  content              = <<%s\n%s%s\n}\n",
+			kind, name, hclString(f.Path), f.Mode, heredocEnd, heredoc(f.Content), heredocEnd)
+		deps = append(deps, kind+"."+name)
+		triggers = append(triggers, kind+"."+name+".content_sha256")
+		if f.Owner != "" && f.Owner != "root" {
+			chowns = append(chowns, "chown "+shellQuote(f.Owner)+" "+shellQuote(f.Path))
+		}
+	}
+
+	// One step applies everything written: owners, nginx links, the daemon
+	// reload, and enabling the units, rerun whenever a file changes
+	steps := chowns
+	for _, l := range h.Links {
+		steps = append(steps, "ln -sfn "+shellQuote(l.Target)+" "+shellQuote(l.Path))
+	}
+	steps = append(steps, "systemctl daemon-reload")
+	enable := h.Enable
+	if h.Nginx {
+		enable = append([]string{"nginx.service"}, enable...)
+	}
+	if len(enable) > 0 {
+		quoted := make([]string, len(enable))
+		for i, unit := range enable {
+			quoted[i] = shellQuote(unit)
+		}
+		steps = append(steps, "systemctl enable --now "+strings.Join(quoted, " "))
+	}
+	if h.Nginx {
+		steps = append(steps, "nginx -t && systemctl reload nginx")
+	}
+	fmt.Fprintf(&b, "\nresource \"terraform_data\" \"apply\" {\n  triggers_replace = [%s]\n  depends_on       = [%s]\n  provisioner \"local-exec\" {\n    command = %s\n  }\n}\n",
+		strings.Join(triggers, ", "), strings.Join(deps, ", "), hclString(strings.Join(steps, " && ")))
+	return b.String()
+}
+
+// hclString quotes s as a Terraform string without interpolation
+func hclString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + hclEscapes.Replace(r.Replace(s)) + `"`
+}
+
+// hclList quotes each of items into a Terraform list
+func hclList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = hclString(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// heredoc escapes content for a heredoc, with variables interpolated, ending
+// it with a newline
+func heredoc(content string) string {
+	content = varPattern.ReplaceAllString(hclEscapes.Replace(content), "$${var.$1}")
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content
+}
@@ -0,0 +1,349 @@
+// Package jobs runs long-running operations (installs, provisioning, deploys)
+// on a bounded worker pool so HTTP requests can return immediately. Every job
+// is recorded as a storage.Job with its status and captured log.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/events"
+	"servio/internal/storage"
+)
+
+// Job kinds
+const (
+	KindInstall     = "install"
+	KindProvision   = "provision"
+	KindDeploy      = "deploy"
+	KindBackup      = "backup"
+	KindStack       = "stack"
+	KindClone       = "clone"
+	KindUpdate      = "update"
+	KindCertificate = "certificate"
+)
+
+// DefaultWorkers is the number of jobs run concurrently
+const DefaultWorkers = 2
+
+// queueSize bounds how many jobs may wait for a worker
+const queueSize = 64
+
+// ResourcePackages is held by jobs running the package manager, which takes
+// a lock of its own that a second apt or dnf would fail on
+const ResourcePackages = "packages"
+
+// policy bounds the jobs of one kind
+type policy struct {
+	timeout  time.Duration // how long a job may run before it is cancelled
+	resource string        // held while the job runs; no two jobs hold one at once
+}
+
+// policies are the bounds of each kind; other kinds get defaultPolicy
+var policies = map[string]policy{
+	KindInstall:     {timeout: 15 * time.Minute},
+	KindProvision:   {timeout: 45 * time.Minute, resource: ResourcePackages},
+	KindDeploy:      {timeout: 30 * time.Minute},
+	KindBackup:      {timeout: 2 * time.Hour},
+	KindStack:       {timeout: time.Hour},
+	KindClone:       {timeout: time.Hour},
+	KindUpdate:      {timeout: time.Hour, resource: ResourcePackages},
+	KindCertificate: {timeout: 15 * time.Minute},
+}
+
+var defaultPolicy = policy{timeout: 30 * time.Minute}
+
+var (
+	// ErrQueueFull is returned when too many jobs are already waiting
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrCancelled ends a job cancelled through Cancel
+	ErrCancelled = errors.New("job cancelled")
+	// ErrTimeout ends a job that ran longer than its kind allows
+	ErrTimeout = errors.New("job timed out")
+	// ErrNotActive is returned when cancelling a job that is neither queued nor running
+	ErrNotActive = errors.New("job is not queued or running")
+)
+
+// Logf appends a line to the job log
+type Logf func(format string, args ...interface{})
+
+// Func is the work of a job. The job's log and status are maintained by the
+// runner; a returned error marks the job failed. ctx is cancelled when the
+// job is cancelled or times out. A job cancelled while queued is still run,
+// with ctx already cancelled, so work should check ctx before starting
+// anything it does not cover.
+type Func func(ctx context.Context, job *storage.Job, logf Logf) error
+
+// Event is a progress update from a job: a status change or a log line.
+// Seq numbers log lines from 1 so streams can skip lines they already sent.
+type Event struct {
+	JobID  int64  `json:"job_id"`
+	Status string `json:"status"`
+	Seq    int    `json:"seq,omitempty"`
+	Line   string `json:"line,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type task struct {
+	ctx    context.Context
+	job    *storage.Job
+	fn     Func
+	policy policy
+	cancel context.CancelCauseFunc // set once the task runs
+}
+
+// Runner queues jobs and runs them, at most workers at a time, oldest first
+// among those whose resource is free
+type Runner struct {
+	store   storage.Store
+	events  *events.Bus
+	workers int
+
+	mu      sync.Mutex
+	pending []*task
+	running map[int64]*task
+	held    map[string]bool // resources of running jobs
+
+	subMu       sync.Mutex
+	subscribers map[int64]map[chan Event]struct{} // keyed by job ID
+}
+
+// NewRunner creates a Runner that runs up to workers jobs at once
+func NewRunner(store storage.Store, workers int, bus *events.Bus) *Runner {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return &Runner{
+		store:       store,
+		events:      bus,
+		workers:     workers,
+		running:     make(map[int64]*task),
+		held:        make(map[string]bool),
+		subscribers: make(map[int64]map[chan Event]struct{}),
+	}
+}
+
+// Enqueue records a job (Kind and the project/service it targets must be set)
+// and schedules fn to run in the background. It returns the queued job; the
+// worker updates its own copy. The job outlives the request: it keeps the actor
+// and audit target of ctx but not its cancellation.
+func (r *Runner) Enqueue(ctx context.Context, job storage.Job, fn Func) (*storage.Job, error) {
+	job.Status = storage.JobQueued
+	if err := r.store.CreateJob(ctx, &job); err != nil {
+		return nil, err
+	}
+	queued := job
+	r.announce(&queued)
+
+	p, ok := policies[job.Kind]
+	if !ok {
+		p = defaultPolicy
+	}
+	runCtx := context.WithoutCancel(audit.WithTarget(ctx, job.ProjectID, job.ServiceID))
+	r.mu.Lock()
+	full := len(r.pending) >= queueSize
+	if !full {
+		r.pending = append(r.pending, &task{ctx: runCtx, job: &job, fn: fn, policy: p})
+	}
+	r.mu.Unlock()
+
+	if full {
+		r.finish(ctx, &queued, ErrQueueFull)
+		return nil, ErrQueueFull
+	}
+	r.dispatch()
+	return &queued, nil
+}
+
+// Cancel cancels a queued or running job by cancelling its context with
+// ErrCancelled. A running job fails when its work returns, which commands it
+// runs do as soon as they are killed. A queued job starts at once, outside
+// the worker and resource limits, with its context already cancelled, so work
+// that keeps records of its own can close them.
+func (r *Runner) Cancel(jobID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.running[jobID]; ok {
+		t.cancel(ErrCancelled)
+		return nil
+	}
+	for i, t := range r.pending {
+		if t.job.ID == jobID {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			t.policy.resource = ""
+			r.start(t, ErrCancelled)
+			return nil
+		}
+	}
+	return ErrNotActive
+}
+
+// dispatch starts the oldest queued jobs whose resources are free while
+// workers are idle. Jobs cancelled while queued count against the workers
+// until they return.
+func (r *Runner) dispatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < len(r.pending) && len(r.running) < r.workers; {
+		t := r.pending[i]
+		if t.policy.resource != "" && r.held[t.policy.resource] {
+			i++
+			continue
+		}
+		r.pending = append(r.pending[:i], r.pending[i+1:]...)
+		if t.policy.resource != "" {
+			r.held[t.policy.resource] = true
+		}
+		r.start(t, nil)
+	}
+}
+
+// start runs a task taken off the queue, its context cancelled with cause
+// from the outset when that is set; r.mu must be held
+func (r *Runner) start(t *task, cause error) {
+	var ctx context.Context
+	ctx, t.cancel = context.WithCancelCause(t.ctx)
+	if cause != nil {
+		t.cancel(cause)
+	}
+	r.running[t.job.ID] = t
+	go r.run(ctx, t)
+}
+
+// done releases a finished job's worker and resource for the next ones
+func (r *Runner) done(t *task) {
+	r.mu.Lock()
+	delete(r.running, t.job.ID)
+	if t.policy.resource != "" {
+		delete(r.held, t.policy.resource)
+	}
+	r.mu.Unlock()
+	t.cancel(nil)
+	r.dispatch()
+}
+
+// Subscribe streams events for a job until cancel is called. Slow subscribers
+// miss events rather than stalling the job; the stored log is authoritative.
+func (r *Runner) Subscribe(jobID int64) (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+
+	r.subMu.Lock()
+	if r.subscribers[jobID] == nil {
+		r.subscribers[jobID] = make(map[chan Event]struct{})
+	}
+	r.subscribers[jobID][ch] = struct{}{}
+	r.subMu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			r.subMu.Lock()
+			delete(r.subscribers[jobID], ch)
+			if len(r.subscribers[jobID]) == 0 {
+				delete(r.subscribers, jobID)
+			}
+			r.subMu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// publish delivers an event to the job's subscribers without blocking
+func (r *Runner) publish(event Event) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	for ch := range r.subscribers[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// run executes a job, saving its log after every line so pollers see
+// progress. The job is saved with the task's context, which outlives ctx.
+func (r *Runner) run(ctx context.Context, t *task) {
+	defer r.done(t)
+	job := t.job
+
+	started := time.Now()
+	job.Status = storage.JobRunning
+	job.StartedAt = &started
+	if err := r.store.UpdateJob(t.ctx, job); err != nil {
+		slog.WarnContext(ctx, "Failed to mark job running", "job_id", job.ID, "error", err)
+	}
+	r.publish(Event{JobID: job.ID, Status: job.Status})
+	r.announce(job)
+
+	var log strings.Builder
+	seq := 0
+	logf := func(format string, args ...interface{}) {
+		seq++
+		line := fmt.Sprintf("[%s] %s", time.Now().Format(time.TimeOnly), fmt.Sprintf(format, args...))
+		log.WriteString(line + "\n")
+		job.Log = log.String()
+		if err := r.store.UpdateJob(t.ctx, job); err != nil {
+			slog.WarnContext(ctx, "Failed to save job log", "job_id", job.ID, "error", err)
+		}
+		r.publish(Event{JobID: job.ID, Status: job.Status, Seq: seq, Line: line})
+	}
+
+	runCtx, cancel := context.WithTimeoutCause(ctx, t.policy.timeout, fmt.Errorf("%w after %s", ErrTimeout, t.policy.timeout))
+	defer cancel()
+	err := r.execute(runCtx, job, t.fn, logf)
+	if err != nil && runCtx.Err() != nil {
+		// The cancellation says more than the error of whatever it interrupted
+		err = context.Cause(runCtx)
+	}
+	r.finish(t.ctx, job, err)
+}
+
+// finish records a job's outcome: failed with err, or succeeded
+func (r *Runner) finish(ctx context.Context, job *storage.Job, err error) {
+	finished := time.Now()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status = storage.JobFailed
+		job.Error = err.Error()
+		slog.WarnContext(ctx, "Job failed", "job_id", job.ID, "kind", job.Kind, "error", err)
+	} else {
+		job.Status = storage.JobSucceeded
+		slog.InfoContext(ctx, "Job succeeded", "job_id", job.ID, "kind", job.Kind, "duration", finished.Sub(*job.StartedAt))
+	}
+
+	if err := r.store.UpdateJob(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "Failed to save job result", "job_id", job.ID, "error", err)
+	}
+	r.publish(Event{JobID: job.ID, Status: job.Status, Error: job.Error})
+	r.announce(job)
+}
+
+// announce publishes a job status change on the events bus
+func (r *Runner) announce(job *storage.Job) {
+	data := map[string]interface{}{
+		"job_id": job.ID,
+		"kind":   job.Kind,
+		"status": job.Status,
+	}
+	if job.Error != "" {
+		data["error"] = job.Error
+	}
+	r.events.Publish(events.Event{Type: events.JobUpdated, ProjectID: job.ProjectID, ServiceID: job.ServiceID, Data: data})
+}
+
+// execute runs fn, turning a panic into a job failure so one bad job cannot take down a worker
+func (r *Runner) execute(ctx context.Context, job *storage.Job, fn Func, logf Logf) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return fn(ctx, job, logf)
+}
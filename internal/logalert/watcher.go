@@ -0,0 +1,260 @@
+// Package logalert watches the logs of services with log alert rules. A
+// follower per service matches each new line against the service's rules; a
+// rule that matches more than its threshold within its interval records an
+// incident and publishes a log.alert event, which webhooks deliver.
+package logalert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/ansi"
+	"servio/internal/events"
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+const (
+	// syncInterval is how often the rules are re-read
+	syncInterval = 30 * time.Second
+	// retryBase is the delay before following a log again; it doubles up to retryMax
+	retryBase = time.Second
+	retryMax  = time.Minute
+	// maxSample bounds the line kept with an incident
+	maxSample = 1024
+)
+
+// rule is an enabled alert with its recent matches
+type rule struct {
+	alert   *storage.LogAlert
+	re      *regexp.Regexp
+	matches []time.Time // within the last interval, oldest first
+	fired   time.Time
+}
+
+// observe records a match at t and reports whether the rule fires: it has
+// matched more than its threshold within the interval and has not fired
+// within the interval
+func (r *rule) observe(t time.Time) bool {
+	interval := time.Duration(r.alert.Interval) * time.Second
+	cutoff := t.Add(-interval)
+	i := 0
+	for i < len(r.matches) && !r.matches[i].After(cutoff) {
+		i++
+	}
+	r.matches = append(r.matches[i:], t)
+	if len(r.matches) <= r.alert.Threshold || (!r.fired.IsZero() && t.Sub(r.fired) < interval) {
+		return false
+	}
+	r.fired = t
+	return true
+}
+
+// follower is the running follower of one service
+type follower struct {
+	cancel  context.CancelFunc
+	version string // the rules it was started with; see rulesVersion
+}
+
+// Watcher follows the logs of services with enabled log alert rules
+type Watcher struct {
+	store       storage.Store
+	svcManager  systemd.ServiceManager
+	events      *events.Bus
+	reconfigure chan struct{}
+
+	mu        sync.Mutex
+	followers map[int64]follower
+}
+
+// New creates a Watcher. Call Run to start watching.
+func New(store storage.Store, svcManager systemd.ServiceManager, bus *events.Bus) *Watcher {
+	return &Watcher{
+		store:       store,
+		svcManager:  svcManager,
+		events:      bus,
+		reconfigure: make(chan struct{}, 1),
+		followers:   make(map[int64]follower),
+	}
+}
+
+// Reconfigure asks Run to re-read the rules now rather than at the next
+// sync. It never blocks.
+func (w *Watcher) Reconfigure() {
+	select {
+	case w.reconfigure <- struct{}{}:
+	default:
+	}
+}
+
+// Run watches logs until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	defer w.stopAll()
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		w.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.reconfigure:
+		}
+	}
+}
+
+// sync starts, restarts, or stops followers to match the enabled rules. A
+// follower whose rules changed starts over, forgetting its recent matches.
+func (w *Watcher) sync(ctx context.Context) {
+	alerts, err := w.store.ListLogAlerts(ctx, 0)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list log alerts", "error", err)
+		return
+	}
+	wanted := map[int64][]*storage.LogAlert{}
+	for _, a := range alerts {
+		if a.Enabled {
+			wanted[a.ServiceID] = append(wanted[a.ServiceID], a)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, f := range w.followers {
+		if rules, ok := wanted[id]; !ok || rulesVersion(rules) != f.version {
+			f.cancel()
+			delete(w.followers, id)
+		}
+	}
+	for id, alerts := range wanted {
+		if _, ok := w.followers[id]; ok {
+			continue
+		}
+		service, err := w.store.GetService(ctx, id)
+		if err != nil || service == nil {
+			continue
+		}
+		rules := make([]*rule, 0, len(alerts))
+		for _, a := range alerts {
+			re, err := regexp.Compile(a.Pattern)
+			if err != nil {
+				// Patterns are checked when saved, so this only happens to rows edited by hand
+				slog.WarnContext(ctx, "Skipping log alert with invalid pattern", "alert_id", a.ID, "error", err)
+				continue
+			}
+			rules = append(rules, &rule{alert: a, re: re})
+		}
+		followCtx, cancel := context.WithCancel(ctx)
+		w.followers[id] = follower{cancel: cancel, version: rulesVersion(alerts)}
+		go w.follow(followCtx, service, rules)
+	}
+}
+
+// rulesVersion identifies a set of rules, changing when any is added,
+// removed, or edited
+func rulesVersion(alerts []*storage.LogAlert) string {
+	parts := make([]string, len(alerts))
+	for i, a := range alerts {
+		parts[i] = fmt.Sprintf("%d@%d", a.ID, a.UpdatedAt.UnixNano())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// stopAll stops every follower
+func (w *Watcher) stopAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, f := range w.followers {
+		f.cancel()
+		delete(w.followers, id)
+	}
+}
+
+// follow matches a service's new log lines against its rules, following the
+// log again with a backoff when it ends. Lines logged before the follower
+// started are skipped, so a restart does not fire alerts again.
+func (w *Watcher) follow(ctx context.Context, service *storage.Service, rules []*rule) {
+	unit := service.ServiceName()
+	start := time.Now()
+	cursor := ""
+	delay := retryBase
+	for {
+		entries, err := w.svcManager.StreamLogs(ctx, unit, cursor)
+		if err == nil {
+			for e := range entries {
+				if e.Cursor != "" {
+					cursor = e.Cursor
+				}
+				if !e.Time.IsZero() && e.Time.Before(start) {
+					continue
+				}
+				w.match(ctx, service, rules, e)
+				delay = retryBase
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to follow logs for alerts", "unit", unit, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMax)
+	}
+}
+
+// match checks a log entry against each rule and fires those it tips over
+func (w *Watcher) match(ctx context.Context, service *storage.Service, rules []*rule, e systemd.JournalEntry) {
+	message := ansi.Strip(e.Message)
+	at := e.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	for _, r := range rules {
+		if r.re.MatchString(message) && r.observe(at) {
+			w.fire(ctx, service, r, message, at)
+		}
+	}
+}
+
+// fire records an incident for a rule and publishes it as a log.alert event
+func (w *Watcher) fire(ctx context.Context, service *storage.Service, r *rule, sample string, at time.Time) {
+	if len(sample) > maxSample {
+		sample = strings.ToValidUTF8(sample[:maxSample], "")
+	}
+	incident := &storage.LogIncident{
+		AlertID:   r.alert.ID,
+		ServiceID: service.ID,
+		Alert:     r.alert.Name,
+		Pattern:   r.alert.Pattern,
+		Matches:   len(r.matches),
+		Sample:    sample,
+		FiredAt:   at,
+	}
+	if err := w.store.CreateLogIncident(ctx, incident); err != nil {
+		slog.ErrorContext(ctx, "Failed to record log incident", "alert_id", r.alert.ID, "error", err)
+	}
+	slog.WarnContext(ctx, "Log alert fired", "service", service.Name, "alert", r.alert.Name, "matches", incident.Matches)
+	w.events.Publish(events.Event{Type: events.LogAlert, ProjectID: service.ProjectID, ServiceID: service.ID, Data: map[string]interface{}{
+		"incident_id": incident.ID,
+		"alert_id":    r.alert.ID,
+		"alert":       r.alert.Name,
+		"pattern":     r.alert.Pattern,
+		"matches":     incident.Matches,
+		"interval":    r.alert.Interval,
+		"sample":      sample,
+	}})
+}
@@ -0,0 +1,250 @@
+// Package logparse splits journal lines into their parts, parses messages
+// that applications log as JSON objects, and classifies each line as an
+// error, warning, info, or debug line, so logs can be filtered by field
+// (level=error, request_id=abc) and shown as structured rows.
+package logparse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidFilter is wrapped by ParseFilters errors
+var ErrInvalidFilter = errors.New("invalid log filter")
+
+// journalLine matches journalctl's short-iso format: TIME HOST PROCESS: MESSAGE
+var journalLine = regexp.MustCompile(`^(\d\S*) (\S+) ([^:\s]+): (.*)$`)
+
+// Keys applications commonly use for the message and level, in order of preference
+var (
+	messageKeys = []string{"msg", "message", "@message", "event"}
+	levelKeys   = []string{"level", "lvl", "severity", "log.level", "@level"}
+)
+
+// Levels lines are classified into
+const (
+	LevelError = "error"
+	LevelWarn  = "warn"
+	LevelInfo  = "info"
+	LevelDebug = "debug"
+)
+
+// Patterns that mark plain-text lines as errors or warnings
+var (
+	errorPattern = regexp.MustCompile(`(?i)\b(error|err|fatal|panic|critical|exception|traceback|failed|failure)\b`)
+	warnPattern  = regexp.MustCompile(`(?i)\b(warn|warning|deprecated)\b`)
+)
+
+// Line is a parsed journal line. Fields is set when the message is a JSON object.
+type Line struct {
+	Time    string                 `json:"time,omitempty"`
+	Host    string                 `json:"host,omitempty"`
+	Process string                 `json:"process,omitempty"`
+	Message string                 `json:"message"`
+	Level   string                 `json:"level"` // error, warn, info, or debug
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	Raw     string                 `json:"raw"`
+
+	appLevel string // the level the application logged, if any
+}
+
+// Field is a key and its value rendered as text
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Parse splits a journal line and parses a JSON message. Lines that are not
+// in journal format, such as "-- No entries --", keep the whole line as the
+// message.
+func Parse(raw string) Line {
+	m := journalLine.FindStringSubmatch(raw)
+	if m == nil {
+		return ParseMessage(raw)
+	}
+	l := ParseMessage(m[4])
+	l.Time, l.Host, l.Process, l.Raw = m[1], m[2], m[3], raw
+	return l
+}
+
+// ParseMessage parses a bare message, such as a journal entry's MESSAGE field
+func ParseMessage(message string) Line {
+	l := Line{Raw: message, Message: message}
+	var fields map[string]interface{}
+	if msg := strings.TrimSpace(message); strings.HasPrefix(msg, "{") && json.Unmarshal([]byte(msg), &fields) == nil {
+		l.Fields = fields
+		if key, ok := firstKey(fields, messageKeys); ok {
+			l.Message = text(fields[key])
+		}
+		if key, ok := firstKey(fields, levelKeys); ok {
+			l.appLevel = text(fields[key])
+		}
+	}
+	return l.WithPriority(-1)
+}
+
+// WithPriority classifies the line again knowing its journal priority
+// (0 emerg to 7 debug), which journald records for each entry
+func (l Line) WithPriority(priority int) Line {
+	l.Level = classify(l.appLevel, l.Message, priority)
+	return l
+}
+
+// classify picks a line's level: a journal priority of err or worse, then the
+// level the application logged, then a warning priority, then patterns in the
+// message. priority is -1 when unknown.
+func classify(appLevel, message string, priority int) string {
+	if priority >= 0 && priority <= 3 {
+		return LevelError
+	}
+	if level := normalizeLevel(appLevel); level != "" {
+		return level
+	}
+	switch {
+	case priority == 4:
+		return LevelWarn
+	case errorPattern.MatchString(message):
+		return LevelError
+	case warnPattern.MatchString(message):
+		return LevelWarn
+	case priority == 7:
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+// normalizeLevel maps the level names and numbers logging libraries use
+// (including pino and bunyan's 10 to 60) to a Level, or "" if unrecognized
+func normalizeLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "error", "err", "fatal", "critical", "crit", "panic", "emerg", "emergency", "alert", "severe":
+		return LevelError
+	case "warn", "warning":
+		return LevelWarn
+	case "info", "information", "informational", "notice":
+		return LevelInfo
+	case "debug", "trace", "verbose", "fine":
+		return LevelDebug
+	}
+	if n, err := strconv.Atoi(level); err == nil && n >= 10 {
+		switch {
+		case n >= 50:
+			return LevelError
+		case n >= 40:
+			return LevelWarn
+		case n >= 30:
+			return LevelInfo
+		}
+		return LevelDebug
+	}
+	return ""
+}
+
+// Get returns the text of a field. Dotted keys reach into nested objects
+// when there is no field with the literal key. "level" is the line's
+// classified level, so it also matches plain-text lines; "message" matches
+// the keys applications use for it.
+func (l Line) Get(key string) (string, bool) {
+	switch key {
+	case "level":
+		return l.Level, true
+	case "message", "msg":
+		return l.Message, l.Fields != nil
+	}
+	if v, ok := l.Fields[key]; ok {
+		return text(v), true
+	}
+	var cur interface{} = l.Fields
+	for _, part := range strings.Split(key, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if cur, ok = obj[part]; !ok {
+			return "", false
+		}
+	}
+	return text(cur), true
+}
+
+// Extra returns the fields other than the message and level, sorted by key
+func (l Line) Extra() []Field {
+	skip := map[string]bool{}
+	if key, ok := firstKey(l.Fields, messageKeys); ok {
+		skip[key] = true
+	}
+	if key, ok := firstKey(l.Fields, levelKeys); ok {
+		skip[key] = true
+	}
+	extra := make([]Field, 0, len(l.Fields))
+	for key, v := range l.Fields {
+		if !skip[key] {
+			extra = append(extra, Field{Key: key, Value: text(v)})
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Key < extra[j].Key })
+	return extra
+}
+
+// Filter matches lines whose field equals a value, ignoring case
+type Filter struct {
+	Key   string
+	Value string
+}
+
+// ParseFilters parses "key=value" filters
+func ParseFilters(values []string) ([]Filter, error) {
+	filters := make([]Filter, 0, len(values))
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%w: %q must be key=value", ErrInvalidFilter, v)
+		}
+		filters = append(filters, Filter{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+	return filters, nil
+}
+
+// Match reports whether the line passes every filter. Lines without JSON
+// fields only match level filters.
+func Match(l Line, filters []Filter) bool {
+	for _, f := range filters {
+		v, ok := l.Get(f.Key)
+		if !ok || !strings.EqualFold(v, f.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// firstKey returns the first of keys present in fields
+func firstKey(fields map[string]interface{}, keys []string) (string, bool) {
+	for _, key := range keys {
+		if _, ok := fields[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// text renders a JSON value: strings as is, numbers without exponents, and
+// objects and arrays as compact JSON
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,125 @@
+package logparse
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw     string
+		time    string
+		process string
+		message string
+		level   string
+	}{
+		{
+			raw:     "2026-03-01T12:00:00+0000 web1 servio-api[812]: listening on :8080",
+			time:    "2026-03-01T12:00:00+0000",
+			process: "servio-api[812]",
+			message: "listening on :8080",
+			level:   LevelInfo,
+		},
+		{
+			raw:     `2026-03-01T12:00:01+0000 web1 node[9]: {"level":"warn","msg":"slow query","ms":812}`,
+			time:    "2026-03-01T12:00:01+0000",
+			process: "node[9]",
+			message: "slow query",
+			level:   LevelWarn,
+		},
+		{raw: "-- No entries --", message: "-- No entries --", level: LevelInfo},
+		{raw: "panic: runtime error: index out of range", message: "panic: runtime error: index out of range", level: LevelError},
+		{raw: "Request failed with status 500", message: "Request failed with status 500", level: LevelError},
+		{raw: "errors=0 processed=10", message: "errors=0 processed=10", level: LevelInfo}, // words, not substrings
+		{raw: "DEPRECATED: use --config", message: "DEPRECATED: use --config", level: LevelWarn},
+		// The application's level wins over words in the message
+		{raw: `{"level":"info","message":"retrying after error"}`, message: "retrying after error", level: LevelInfo},
+		{raw: `{"severity":"CRITICAL","event":"disk full"}`, message: "disk full", level: LevelError},
+		{raw: `{"level":50,"msg":"pino error"}`, message: "pino error", level: LevelError},
+		{raw: `{"level":20,"msg":"pino debug"}`, message: "pino debug", level: LevelDebug},
+		{raw: `{"msg":"no level"}`, message: "no level", level: LevelInfo},
+		{raw: `{"count":3}`, message: `{"count":3}`, level: LevelInfo},
+		{raw: `{not json`, message: `{not json`, level: LevelInfo},
+	}
+	for _, tt := range tests {
+		l := Parse(tt.raw)
+		if l.Time != tt.time || l.Process != tt.process || l.Message != tt.message || l.Level != tt.level || l.Raw != tt.raw {
+			t.Errorf("Parse(%q) = time %q, process %q, message %q, level %q; want %q, %q, %q, %q",
+				tt.raw, l.Time, l.Process, l.Message, l.Level, tt.time, tt.process, tt.message, tt.level)
+		}
+	}
+}
+
+func TestWithPriority(t *testing.T) {
+	tests := []struct {
+		message  string
+		priority int
+		want     string
+	}{
+		{"all good", 3, LevelError},
+		{`{"level":"info","msg":"x"}`, 2, LevelError}, // err or worse always wins
+		{`{"level":"debug","msg":"x"}`, 4, LevelDebug},
+		{"all good", 4, LevelWarn},
+		{"connection error", 6, LevelError},
+		{"tick", 7, LevelDebug},
+		{"tick", 6, LevelInfo},
+	}
+	for _, tt := range tests {
+		if got := ParseMessage(tt.message).WithPriority(tt.priority).Level; got != tt.want {
+			t.Errorf("ParseMessage(%q).WithPriority(%d) level = %q, want %q", tt.message, tt.priority, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    []Filter
+		wantErr bool
+	}{
+		{values: nil, want: []Filter{}},
+		{values: []string{"level=error", " request_id = abc "}, want: []Filter{{"level", "error"}, {"request_id", "abc"}}},
+		{values: []string{"url=/a?b=c"}, want: []Filter{{"url", "/a?b=c"}}},
+		{values: []string{"empty="}, want: []Filter{{"empty", ""}}},
+		{values: []string{"level"}, wantErr: true},
+		{values: []string{"=error"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilters(tt.values)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("ParseFilters(%q) error = %v, want ErrInvalidFilter", tt.values, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFilters(%q) = %v, %v; want %v", tt.values, got, err, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	line := ParseMessage(`{"level":"error","msg":"failed","http":{"status":500},"user.id":7,"ok":false}`)
+	plain := ParseMessage("connection failed")
+	tests := []struct {
+		line    Line
+		filters []Filter
+		want    bool
+	}{
+		{line, nil, true},
+		{line, []Filter{{"level", "ERROR"}}, true},
+		{line, []Filter{{"http.status", "500"}}, true},
+		{line, []Filter{{"user.id", "7"}}, true}, // a literal dotted key
+		{line, []Filter{{"ok", "false"}}, true},
+		{line, []Filter{{"message", "failed"}, {"level", "warn"}}, false},
+		{line, []Filter{{"missing", ""}}, false},
+		{plain, []Filter{{"level", "error"}}, true},
+		{plain, []Filter{{"message", "connection failed"}}, false}, // plain lines match only level
+	}
+	for _, tt := range tests {
+		if got := Match(tt.line, tt.filters); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.line.Raw, tt.filters, got, tt.want)
+		}
+	}
+}
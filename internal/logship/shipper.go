@@ -0,0 +1,358 @@
+// Package logship forwards the journal of selected services to a log store:
+// Loki, a remote syslog server, or Elasticsearch. A tailer per service follows
+// journalctl into a bounded queue that is sent in batches. When the sink is
+// slow or down the queue fills and the tailers stop reading, so journald
+// buffers the backlog; each service's cursor is saved once its entries are
+// delivered, so a restart resumes where shipping stopped.
+package logship
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"servio/internal/storage"
+	"servio/internal/systemd"
+)
+
+const (
+	// queueSize bounds how many entries wait to be sent
+	queueSize = 2048
+	// maxBatch is the most entries sent in one request
+	maxBatch = 500
+	// flushInterval is the longest an entry waits for its batch to fill
+	flushInterval = 2 * time.Second
+	// syncInterval is how often settings and the forwarded services are re-read
+	syncInterval = 30 * time.Second
+	// retryBase is the delay before retrying a failed batch; it doubles up to retryMax
+	retryBase = time.Second
+	retryMax  = time.Minute
+)
+
+// levels names syslog severities
+var levels = [...]string{"emerg", "alert", "crit", "error", "warning", "notice", "info", "debug"}
+
+// Entry is a journal entry of a forwarded service
+type Entry struct {
+	systemd.JournalEntry
+	ServiceID int64
+	Service   string
+	Project   string
+	Unit      string
+}
+
+// Level names the entry's priority
+func (e Entry) Level() string {
+	if e.Priority >= 0 && e.Priority < len(levels) {
+		return levels[e.Priority]
+	}
+	return "info"
+}
+
+// Status reports what the shipper is doing
+type Status struct {
+	Sink        string     `json:"sink"`
+	Target      string     `json:"target,omitempty"` // the sink URL without credentials
+	Services    []int64    `json:"services"`         // services being tailed
+	Queued      int        `json:"queued"`
+	Sent        int64      `json:"sent"`
+	Dropped     int64      `json:"dropped"` // entries in batches the sink rejected
+	Retries     int64      `json:"retries"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LastSentAt  *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// Shipper tails forwarded services and sends their entries to the sink
+type Shipper struct {
+	store       storage.Store
+	queue       chan Entry
+	reconfigure chan struct{}
+
+	mu      sync.Mutex
+	cfg     Config
+	sink    Sink
+	tailers map[int64]context.CancelFunc
+	status  Status
+
+	sent, dropped, retries atomic.Int64
+}
+
+// New creates a Shipper. Call Run to start forwarding.
+func New(store storage.Store) *Shipper {
+	return &Shipper{
+		store:       store,
+		queue:       make(chan Entry, queueSize),
+		reconfigure: make(chan struct{}, 1),
+		tailers:     make(map[int64]context.CancelFunc),
+		cfg:         Config{Sink: SinkNone},
+	}
+}
+
+// Reconfigure asks Run to re-read the settings and forwarded services now
+// rather than at the next sync. It never blocks.
+func (s *Shipper) Reconfigure() {
+	select {
+	case s.reconfigure <- struct{}{}:
+	default:
+	}
+}
+
+// Run forwards entries until ctx is cancelled
+func (s *Shipper) Run(ctx context.Context) {
+	go s.send(ctx)
+	defer s.stopAll()
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		s.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.reconfigure:
+		}
+	}
+}
+
+// Status returns a snapshot of the shipper's state
+func (s *Shipper) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Sink = s.cfg.Sink
+	if u, err := url.Parse(s.cfg.URL); err == nil && s.cfg.URL != "" {
+		status.Target = u.Redacted()
+	}
+	status.Services = []int64{}
+	for id := range s.tailers {
+		status.Services = append(status.Services, id)
+	}
+	sort.Slice(status.Services, func(i, j int) bool { return status.Services[i] < status.Services[j] })
+	status.Queued = len(s.queue)
+	status.Sent, status.Dropped, status.Retries = s.sent.Load(), s.dropped.Load(), s.retries.Load()
+	return status
+}
+
+// sync applies the sink settings and starts or stops tailers to match the
+// forwarded services
+func (s *Shipper) sync(ctx context.Context) {
+	cfg, err := s.loadConfig(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read log forwarding settings", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	if cfg != s.cfg {
+		if s.sink != nil {
+			s.sink.Close()
+		}
+		s.cfg, s.sink = cfg, nil
+		sink, err := newSink(cfg)
+		if err != nil {
+			s.failed(err)
+			slog.WarnContext(ctx, "Log forwarding disabled", "error", err)
+		} else {
+			s.sink = sink
+			s.status.LastError, s.status.LastErrorAt = "", nil
+		}
+	}
+	enabled := s.sink != nil
+	s.mu.Unlock()
+
+	wanted := map[int64]*storage.LogForwarding{}
+	if enabled {
+		forwarded, err := s.store.ListLogForwarding(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to list forwarded services", "error", err)
+			return
+		}
+		for _, f := range forwarded {
+			wanted[f.ServiceID] = f
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, cancel := range s.tailers {
+		if wanted[id] == nil {
+			cancel()
+			delete(s.tailers, id)
+		}
+	}
+	for id, f := range wanted {
+		if _, ok := s.tailers[id]; ok {
+			continue
+		}
+		service, err := s.store.GetService(ctx, id)
+		if err != nil || service == nil {
+			continue
+		}
+		project, err := s.store.GetProject(ctx, service.ProjectID)
+		if err != nil || project == nil {
+			continue
+		}
+		tailCtx, cancel := context.WithCancel(ctx)
+		s.tailers[id] = cancel
+		go s.tail(tailCtx, Entry{ServiceID: id, Service: service.Name, Project: project.Name, Unit: service.ServiceName()}, f.Cursor)
+	}
+}
+
+// loadConfig reads the sink settings
+func (s *Shipper) loadConfig(ctx context.Context) (Config, error) {
+	var cfg Config
+	var err error
+	if cfg.Sink, err = s.store.GetSetting(ctx, storage.SettingLogForwardSink); err != nil {
+		return cfg, err
+	}
+	if cfg.URL, err = s.store.GetSetting(ctx, storage.SettingLogForwardURL); err != nil {
+		return cfg, err
+	}
+	if cfg.Index, err = s.store.GetSetting(ctx, storage.SettingLogForwardIndex); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// stopAll stops every tailer
+func (s *Shipper) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, cancel := range s.tailers {
+		cancel()
+		delete(s.tailers, id)
+	}
+}
+
+// tail follows one service's journal into the queue, restarting journalctl
+// with a backoff when it exits. Sending blocks while the queue is full.
+func (s *Shipper) tail(ctx context.Context, template Entry, cursor string) {
+	delay := retryBase
+	for {
+		entries, err := systemd.FollowJournal(ctx, template.Unit, cursor)
+		if err == nil {
+			for je := range entries {
+				e := template
+				e.JournalEntry = je
+				select {
+				case s.queue <- e:
+					cursor = je.Cursor
+					delay = retryBase
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to follow journal for forwarding", "unit", template.Unit, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMax)
+	}
+}
+
+// send batches queued entries and delivers them
+func (s *Shipper) send(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) < maxBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		s.deliver(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// deliver sends a batch, retrying with a backoff until it is accepted or
+// rejected. Retrying holds up the queue, which is the backpressure on the
+// tailers. Cursors are saved only for delivered or rejected entries.
+func (s *Shipper) deliver(ctx context.Context, batch []Entry) {
+	delay := retryBase
+	for {
+		s.mu.Lock()
+		sink := s.sink
+		s.mu.Unlock()
+		if sink == nil {
+			return // forwarding was turned off; the entries are sent again if it is turned back on
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := sink.Send(sendCtx, batch)
+		cancel()
+		if err == nil {
+			s.sent.Add(int64(len(batch)))
+			now := time.Now()
+			s.mu.Lock()
+			s.status.LastSentAt = &now
+			s.mu.Unlock()
+			s.saveCursors(ctx, batch)
+			return
+		}
+
+		s.mu.Lock()
+		s.failed(err)
+		s.mu.Unlock()
+		if errors.Is(err, ErrRejected) {
+			s.dropped.Add(int64(len(batch)))
+			slog.WarnContext(ctx, "Log sink rejected batch, dropping it", "entries", len(batch), "error", err)
+			s.saveCursors(ctx, batch)
+			return
+		}
+
+		s.retries.Add(1)
+		slog.WarnContext(ctx, "Failed to forward logs, retrying", "entries", len(batch), "retry_in", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMax)
+	}
+}
+
+// failed records err as the last error; the caller must hold mu
+func (s *Shipper) failed(err error) {
+	now := time.Now()
+	s.status.LastError, s.status.LastErrorAt = err.Error(), &now
+}
+
+// saveCursors records the last entry of each service in the batch
+func (s *Shipper) saveCursors(ctx context.Context, batch []Entry) {
+	last := map[int64]string{}
+	for _, e := range batch {
+		last[e.ServiceID] = e.Cursor
+	}
+	for id, cursor := range last {
+		if err := s.store.SetLogForwardingCursor(context.WithoutCancel(ctx), id, cursor); err != nil {
+			slog.WarnContext(ctx, "Failed to save log forwarding cursor", "service_id", id, "error", err)
+		}
+	}
+}
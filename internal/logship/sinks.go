@@ -0,0 +1,330 @@
+package logship
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink kinds, the values of the log_forward_sink setting
+const (
+	SinkNone          = "none"
+	SinkLoki          = "loki"
+	SinkSyslog        = "syslog"
+	SinkElasticsearch = "elasticsearch"
+)
+
+const (
+	// sendTimeout bounds delivering one batch
+	sendTimeout = 30 * time.Second
+	// maxErrorBody is how much of a failed response body is kept in the error
+	maxErrorBody = 512
+)
+
+var (
+	// ErrInvalidSink is wrapped by errors for unusable sink settings
+	ErrInvalidSink = errors.New("invalid log sink")
+	// ErrRejected is wrapped by send errors that retrying will not fix; the
+	// batch is dropped
+	ErrRejected = errors.New("log sink rejected the batch")
+)
+
+// Sink delivers batches of entries to a log store
+type Sink interface {
+	Send(ctx context.Context, entries []Entry) error
+	Close() error
+}
+
+// Config selects and addresses a sink
+type Config struct {
+	Sink  string
+	URL   string
+	Index string // Elasticsearch only
+}
+
+// newSink builds the sink cfg describes, or nil for SinkNone
+func newSink(cfg Config) (Sink, error) {
+	if cfg.Sink == SinkNone || cfg.Sink == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: log_forward_url %q is not a URL", ErrInvalidSink, cfg.URL)
+	}
+	client := &http.Client{Timeout: sendTimeout}
+
+	switch cfg.Sink {
+	case SinkLoki:
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("%w: Loki needs an http:// or https:// push URL", ErrInvalidSink)
+		}
+		return &lokiSink{client: client, url: u}, nil
+	case SinkElasticsearch:
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("%w: Elasticsearch needs an http:// or https:// URL", ErrInvalidSink)
+		}
+		if cfg.Index == "" {
+			return nil, fmt.Errorf("%w: log_forward_index is empty", ErrInvalidSink)
+		}
+		return &elasticSink{client: client, url: u, index: cfg.Index}, nil
+	case SinkSyslog:
+		if u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tls" {
+			return nil, fmt.Errorf("%w: syslog needs a udp://, tcp://, or tls:// address", ErrInvalidSink)
+		}
+		if u.Port() == "" {
+			return nil, fmt.Errorf("%w: syslog address %q has no port", ErrInvalidSink, u.Host)
+		}
+		return &syslogSink{network: u.Scheme, addr: u.Host}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown sink %q", ErrInvalidSink, cfg.Sink)
+}
+
+// post sends an HTTP request with the URL's user info as basic auth. A 4xx
+// response other than 429 wraps ErrRejected.
+func post(ctx context.Context, client *http.Client, u *url.URL, contentType string, body []byte) ([]byte, error) {
+	target := *u
+	target.User = nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "servio-logship")
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return respBody, nil
+	}
+
+	err = fmt.Errorf("sink responded %s", resp.Status)
+	if snippet := bytes.TrimSpace(respBody); len(snippet) > 0 {
+		if len(snippet) > maxErrorBody {
+			snippet = snippet[:maxErrorBody]
+		}
+		err = fmt.Errorf("%w: %s", err, snippet)
+	}
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	return nil, err
+}
+
+// lokiSink pushes to Loki's /loki/api/v1/push, one stream per unit, host, and level
+type lokiSink struct {
+	client *http.Client
+	url    *url.URL
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) Send(ctx context.Context, entries []Entry) error {
+	streams := []*lokiStream{}
+	byKey := map[string]*lokiStream{}
+	for _, e := range entries {
+		key := e.Unit + "\x00" + e.Hostname + "\x00" + e.Level()
+		stream, ok := byKey[key]
+		if !ok {
+			labels := map[string]string{
+				"job": "servio", "project": e.Project, "service": e.Service,
+				"unit": e.Unit, "host": e.Hostname, "level": e.Level(),
+			}
+			for name, value := range labels {
+				if value == "" {
+					delete(labels, name) // Loki rejects empty label values
+				}
+			}
+			stream = &lokiStream{Stream: labels}
+			byKey[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Message})
+	}
+	body, err := json.Marshal(map[string]interface{}{"streams": streams})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	_, err = post(ctx, s.client, s.url, "application/json", body)
+	return err
+}
+
+func (s *lokiSink) Close() error { return nil }
+
+// elasticSink writes documents with the _bulk API
+type elasticSink struct {
+	client *http.Client
+	url    *url.URL
+	index  string
+}
+
+// elasticDocument is the document indexed for an entry
+type elasticDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Message   string    `json:"message"`
+	Level     string    `json:"level"`
+	Priority  int       `json:"priority"`
+	Project   string    `json:"project"`
+	Service   string    `json:"service"`
+	Unit      string    `json:"unit"`
+	Host      string    `json:"host"`
+	PID       string    `json:"pid,omitempty"`
+}
+
+func (s *elasticSink) Send(ctx context.Context, entries []Entry) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	action := map[string]map[string]string{"index": {"_index": s.index}}
+	for _, e := range entries {
+		enc.Encode(action)
+		enc.Encode(elasticDocument{
+			Timestamp: e.Time, Message: e.Message, Level: e.Level(), Priority: e.Priority,
+			Project: e.Project, Service: e.Service, Unit: e.Unit, Host: e.Hostname, PID: e.PID,
+		})
+	}
+
+	target := *s.url
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/_bulk"
+	respBody, err := post(ctx, s.client, &target, "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// _bulk answers 200 even when documents fail; retrying would duplicate the rest
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil || !result.Errors {
+		return nil
+	}
+	failed, reason := 0, ""
+	for _, item := range result.Items {
+		for _, op := range item {
+			if op.Error != nil {
+				failed++
+				reason = op.Error.Type + ": " + op.Error.Reason
+			}
+		}
+	}
+	return fmt.Errorf("%w: %d of %d documents failed, last %s", ErrRejected, failed, len(entries), reason)
+}
+
+func (s *elasticSink) Close() error { return nil }
+
+// syslogSink writes RFC 5424 messages, one datagram each over UDP and
+// octet-counted (RFC 6587) over TCP and TLS. The connection is kept open and
+// redialed after a failure.
+type syslogSink struct {
+	network string
+	addr    string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// syslogFacility is user-level messages
+const syslogFacility = 1
+
+func (s *syslogSink) Send(ctx context.Context, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := s.dial(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetWriteDeadline(deadline)
+	} else {
+		s.conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+	}
+
+	for _, e := range entries {
+		msg := s.format(e)
+		if s.network != "udp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		if _, err := io.WriteString(s.conn, msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to write to syslog %s: %w", s.addr, err)
+		}
+	}
+	return nil
+}
+
+// format renders an entry as an RFC 5424 message
+func (s *syslogSink) format(e Entry) string {
+	host := e.Hostname
+	if host == "" {
+		host = "-"
+	}
+	pid := e.PID
+	if pid == "" {
+		pid = "-"
+	}
+	return fmt.Sprintf(`<%d>1 %s %s %s %s - [servio@32473 project="%s" service="%s"] %s`,
+		syslogFacility*8+e.Priority, e.Time.UTC().Format(time.RFC3339Nano), host,
+		strings.TrimSuffix(e.Unit, ".service"), pid, sdEscape(e.Project), sdEscape(e.Service), e.Message)
+}
+
+// sdEscape escapes the characters RFC 5424 reserves in structured data values
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+func (s *syslogSink) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: sendTimeout}
+	var conn net.Conn
+	var err error
+	if s.network == "tls" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, s.network, s.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog %s: %w", s.addr, err)
+	}
+	return conn, nil
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
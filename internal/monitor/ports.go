@@ -0,0 +1,24 @@
+package monitor
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// PortInUse reports whether a TCP port is already bound on the host.
+// A port that cannot be probed (e.g. a privileged port when not running as root)
+// is reported as free, since there is no evidence of a conflict.
+func PortInUse(port int) bool {
+	if port <= 0 || port > 65535 {
+		return false
+	}
+
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	ln.Close()
+	return false
+}
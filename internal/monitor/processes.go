@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessSource reports the main PID of a unit, 0 when it runs none, and the
+// unit's state, for service managers other than systemd such as
+// systemd.ProcessManager
+type ProcessSource func(serviceName string) (int, string)
+
+var (
+	processMu     sync.RWMutex
+	processSource ProcessSource
+)
+
+// SetProcessSource makes GetStats read services through src and ps instead
+// of systemctl; nil goes back to systemctl
+func SetProcessSource(src ProcessSource) {
+	processMu.Lock()
+	defer processMu.Unlock()
+	processSource = src
+}
+
+// getProcessServiceStats reads the state of every unit from src, and the
+// memory and CPU of those with a process from one ps call
+func getProcessServiceStats(src ProcessSource, serviceNames []string) map[string]ServiceStat {
+	stats := make(map[string]ServiceStat, len(serviceNames))
+	pids := make(map[string]string)
+	for _, name := range serviceNames {
+		pid, state := src(name)
+		stats[name] = ServiceStat{ActiveState: state}
+		if pid > 0 {
+			pids[strconv.Itoa(pid)] = name
+		}
+	}
+	if len(pids) == 0 {
+		return stats
+	}
+
+	list := make([]string, 0, len(pids))
+	for pid := range pids {
+		list = append(list, pid)
+	}
+	out, err := exec.Command("ps", "-o", "pid=,rss=,time=", "-p", strings.Join(list, ",")).Output()
+	if err != nil && len(out) == 0 {
+		return stats
+	}
+	now := time.Now()
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		name, ok := pids[fields[0]]
+		if !ok {
+			continue
+		}
+		s := stats[name]
+		rssKB, _ := strconv.ParseFloat(fields[1], 64)
+		s.MemoryUsage = rssKB / 1024 // MB
+		s.CPUUsage = cpuPercent(name+"@"+fields[0], parseCPUTime(fields[2]), now)
+		stats[name] = s
+	}
+	return stats
+}
+
+// cpuPercent works out the CPU use of key from the CPU time it has used,
+// which restarts with its process, since it was last read
+func cpuPercent(key string, cpuNS uint64, now time.Time) float64 {
+	var usage float64
+	if entry, ok := serviceCPUMap.Load(key); ok {
+		e := entry.(*cpuEntry)
+		diffTime := now.Sub(e.lastTime).Nanoseconds()
+		if diffTime > 0 && cpuNS >= e.lastNS {
+			usage = (float64(cpuNS-e.lastNS) / float64(diffTime)) * 100
+		}
+	}
+	serviceCPUMap.Store(key, &cpuEntry{lastNS: cpuNS, lastTime: now})
+	return usage
+}
+
+// parseCPUTime reads ps's cumulative CPU time in nanoseconds: [dd-]hh:mm:ss
+// on Linux and mm:ss.ss on macOS
+func parseCPUTime(s string) uint64 {
+	var days float64
+	if d, rest, ok := strings.Cut(s, "-"); ok {
+		days, _ = strconv.ParseFloat(d, 64)
+		s = rest
+	}
+	var secs float64
+	for _, part := range strings.Split(s, ":") {
+		v, _ := strconv.ParseFloat(part, 64)
+		secs = secs*60 + v
+	}
+	return uint64((days*86400 + secs) * float64(time.Second))
+}
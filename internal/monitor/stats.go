@@ -43,13 +43,13 @@ func GetStats(serviceNames ...string) Stats {
 func getLinuxStats(serviceNames ...string) Stats {
 	cpu := getLinuxCPU()
 	memUsage, memTotal, memUsed := getLinuxMemory()
-	diskUsage, diskTotal, diskUsed := getLinuxDisk()
+	diskUsage, diskTotal, diskUsed := getDisk("/")
 	uptime := getLinuxUptime()
 	osName, osVer := getLinuxOSInfo()
 
 	var services map[string]ServiceStat
 	if len(serviceNames) > 0 {
-		services = getLinuxServiceStats(serviceNames)
+		services = getServiceStats(serviceNames)
 	}
 
 	return Stats{
@@ -68,30 +68,49 @@ func getLinuxStats(serviceNames ...string) Stats {
 }
 
 func getMacStats(serviceNames ...string) Stats {
-	services := make(map[string]ServiceStat)
-	for _, name := range serviceNames {
-		services[name] = ServiceStat{
-			MemoryUsage: 45.0 + float64(time.Now().Unix()%10),
-			CPUUsage:    1.2 + float64(time.Now().Unix()%5),
-			ActiveState: "active",
-		}
+	memUsage, memTotal, memUsed := getMacMemory()
+	// The root volume is a read-only system snapshot; data lives on its own volume
+	diskUsage, diskTotal, diskUsed := getDisk("/System/Volumes/Data")
+	if diskTotal == 0 {
+		diskUsage, diskTotal, diskUsed = getDisk("/")
+	}
+	osName, osVer := getMacOSInfo()
+
+	var services map[string]ServiceStat
+	if len(serviceNames) > 0 {
+		services = getServiceStats(serviceNames)
 	}
 
 	return Stats{
 		CPUUsage:    getMacCPU(),
-		MemoryUsage: 45.0,
-		MemoryTotal: 16.0,
-		MemoryUsed:  7.2,
-		DiskUsage:   getMacDisk(),
-		DiskTotal:   512.0,
-		DiskUsed:    128.0,
+		MemoryUsage: memUsage,
+		MemoryTotal: memTotal,
+		MemoryUsed:  memUsed,
+		DiskUsage:   diskUsage,
+		DiskTotal:   diskTotal,
+		DiskUsed:    diskUsed,
 		Uptime:      getMacUptime(),
-		OSName:      "macOS",
-		OSVersion:   "14.0",
+		OSName:      osName,
+		OSVersion:   osVer,
 		Services:    services,
 	}
 }
 
+// getServiceStats reads services through the process source when one is
+// set, and from systemd otherwise, which macOS does not have
+func getServiceStats(serviceNames []string) map[string]ServiceStat {
+	processMu.RLock()
+	src := processSource
+	processMu.RUnlock()
+	if src != nil {
+		return getProcessServiceStats(src, serviceNames)
+	}
+	if runtime.GOOS == "darwin" {
+		return nil
+	}
+	return getLinuxServiceStats(serviceNames)
+}
+
 // --- Linux Implementations ---
 
 func getLinuxCPU() float64 {
@@ -180,8 +199,10 @@ func getLinuxMemory() (float64, float64, float64) {
 	return (float64(used) / float64(total)) * 100, totalGB, usedGB
 }
 
-func getLinuxDisk() (float64, float64, float64) {
-	out, err := exec.Command("df", "-k", "/").Output()
+// getDisk reads the usage of the filesystem holding path from df, whose
+// first columns are the same on Linux and macOS
+func getDisk(path string) (float64, float64, float64) {
+	out, err := exec.Command("df", "-k", path).Output()
 	if err != nil {
 		return 0, 0, 0
 	}
@@ -235,7 +256,7 @@ func getLinuxOSInfo() (string, string) {
 	return name, version
 }
 
-// --- Mac Implementations (Simulated/Basic) ---
+// --- Mac Implementations ---
 
 func getMacCPU() float64 {
 	// Use top command for more accurate CPU on Mac
@@ -274,13 +295,53 @@ func getMacCPU() float64 {
 	return usage
 }
 
-func getMacMemory() float64 {
-	// Very simple heuristic for Mac
-	return 45.0
+// getMacMemory counts memory as used the way Activity Monitor does: active,
+// wired, and compressed pages, from vm_stat
+func getMacMemory() (float64, float64, float64) {
+	out, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+	if err != nil {
+		return 0, 0, 0
+	}
+	total, _ := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	out, err = exec.Command("vm_stat").Output()
+	if err != nil || total == 0 {
+		return 0, 0, 0
+	}
+
+	// Mach Virtual Memory Statistics: (page size of 16384 bytes)
+	// Pages active:                           123456.
+	pageSize := 4096.0
+	var pages float64
+	for _, line := range strings.Split(string(out), "\n") {
+		if _, rest, ok := strings.Cut(line, "page size of "); ok {
+			if size, err := strconv.ParseFloat(strings.Fields(rest)[0], 64); err == nil {
+				pageSize = size
+			}
+			continue
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Pages active", "Pages wired down", "Pages occupied by compressor":
+			n, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(val), "."), 64)
+			pages += n
+		}
+	}
+	used := min(pages*pageSize, total)
+	return used / total * 100, total / 1024 / 1024 / 1024, used / 1024 / 1024 / 1024
 }
 
-func getMacDisk() float64 {
-	return 25.0
+func getMacOSInfo() (string, string) {
+	name, version := "macOS", "unknown"
+	if out, err := exec.Command("sw_vers", "-productName").Output(); err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		name = strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("sw_vers", "-productVersion").Output(); err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		version = strings.TrimSpace(string(out))
+	}
+	return name, version
 }
 
 func getMacUptime() string {
@@ -326,63 +387,102 @@ type cpuEntry struct {
 	lastTime time.Time
 }
 
-func getLinuxServiceStats(serviceNames []string) map[string]ServiceStat {
-	stats := make(map[string]ServiceStat)
-
-	for _, name := range serviceNames {
-		// systemctl show servio-NAME.service -p CPUUsageNS,MemoryCurrent,ActiveState
-		out, err := exec.Command("systemctl", "show", name, "-p", "CPUUsageNS,MemoryCurrent,ActiveState").Output()
-		if err != nil {
-			continue
-		}
-
-		lines := strings.Split(string(out), "\n")
-		var s ServiceStat
-		var cpuNS uint64
-		var memBytes uint64
+const (
+	// serviceStatsBatch is how many units one systemctl call reads
+	serviceStatsBatch = 64
+	// serviceStatsWorkers is how many systemctl calls run at once
+	serviceStatsWorkers = 4
+)
 
-		for _, line := range lines {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) < 2 {
-				continue
+// getLinuxServiceStats reads the state, memory, and CPU of every unit with
+// one systemctl call per batch of units, running batches concurrently
+func getLinuxServiceStats(serviceNames []string) map[string]ServiceStat {
+	stats := make(map[string]ServiceStat, len(serviceNames))
+	var mu sync.Mutex
+	batches := make(chan []string)
+
+	var wg sync.WaitGroup
+	workers := min(serviceStatsWorkers, (len(serviceNames)+serviceStatsBatch-1)/serviceStatsBatch)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				batchStats := showServiceStats(batch)
+				mu.Lock()
+				for name, stat := range batchStats {
+					stats[name] = stat
+				}
+				mu.Unlock()
 			}
-			val := parts[1]
-			switch parts[0] {
-			case "ActiveState":
-				s.ActiveState = val
-			case "MemoryCurrent":
-				if val != "[not set]" {
-					v, _ := strconv.ParseUint(val, 10, 64)
-					memBytes = v
+		}()
+	}
+	for start := 0; start < len(serviceNames); start += serviceStatsBatch {
+		batches <- serviceNames[start:min(start+serviceStatsBatch, len(serviceNames))]
+	}
+	close(batches)
+	wg.Wait()
+	return stats
+}
+
+// showServiceStats reads a batch of units with one systemctl call, which
+// prints their properties in the order asked, separated by blank lines. When
+// the call fails, such as for a malformed name, each unit is read on its own
+// so the others still report.
+func showServiceStats(names []string) map[string]ServiceStat {
+	stats := make(map[string]ServiceStat, len(names))
+	args := append([]string{"show", "-p", "ActiveState,MemoryCurrent,CPUUsageNSec"}, names...)
+	out, err := exec.Command("systemctl", args...).Output()
+	if err != nil {
+		if len(names) > 1 {
+			for _, name := range names {
+				for n, stat := range showServiceStats([]string{name}) {
+					stats[n] = stat
 				}
-			case "CPUUsageNS":
-				v, _ := strconv.ParseUint(val, 10, 64)
-				cpuNS = v
 			}
 		}
+		return stats
+	}
 
-		// Calculate CPU percentage
-		now := time.Now()
-		if entry, ok := serviceCPUMap.Load(name); ok {
-			e := entry.(*cpuEntry)
-			diffNS := cpuNS - e.lastNS
-			diffTime := now.Sub(e.lastTime).Nanoseconds()
+	now := time.Now()
+	blocks := strings.Split(strings.TrimSpace(string(out)), "\n\n")
+	for i, block := range blocks {
+		if i >= len(names) {
+			break
+		}
+		stats[names[i]] = parseServiceStat(names[i], block, now)
+	}
+	return stats
+}
 
-			if diffTime > 0 {
-				// CPU usage as percentage (100% = 1 core fully used)
-				s.CPUUsage = (float64(diffNS) / float64(diffTime)) * 100
+// parseServiceStat reads one unit's properties, working out its CPU use from
+// the time it used since it was last read
+func parseServiceStat(name, block string, now time.Time) ServiceStat {
+	var s ServiceStat
+	var cpuNS, memBytes uint64
+	for _, line := range strings.Split(block, "\n") {
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ActiveState":
+			s.ActiveState = val
+		case "MemoryCurrent":
+			// [not set] and the maximum uint64 both mean unknown, left at 0
+			if v, err := strconv.ParseUint(val, 10, 64); err == nil && v != 1<<64-1 {
+				memBytes = v
+			}
+		case "CPUUsageNSec":
+			if v, err := strconv.ParseUint(val, 10, 64); err == nil && v != 1<<64-1 {
+				cpuNS = v
 			}
 		}
-
-		// Update cache
-		serviceCPUMap.Store(name, &cpuEntry{
-			lastNS:   cpuNS,
-			lastTime: now,
-		})
-
-		s.MemoryUsage = float64(memBytes) / 1024 / 1024 // MB
-		stats[name] = s
 	}
 
-	return stats
+	// CPU usage as percentage (100% = 1 core fully used); the counter restarts with the unit
+	s.CPUUsage = cpuPercent(name, cpuNS, now)
+
+	s.MemoryUsage = float64(memBytes) / 1024 / 1024 // MB
+	return s
 }
@@ -0,0 +1,308 @@
+package nginx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// ConfDir is where nginx reads its main config and conf.d from
+var ConfDir = "/etc/nginx"
+
+// Conflict is a server block nginx loads that clashes with a project's site
+type Conflict struct {
+	Kind       string `json:"kind"` // server_name or listen
+	Path       string `json:"path"`
+	Line       int    `json:"line"`
+	ServerName string `json:"server_name,omitempty"`
+	Port       int    `json:"port"`
+	Managed    bool   `json:"managed"` // the site of another Servio project
+	Message    string `json:"message"`
+}
+
+// serverBlock is what a server block of an nginx config answers for
+type serverBlock struct {
+	line  int
+	names []string
+	ports []int
+}
+
+// Conflicts scans the configs nginx loads for server blocks that answer for
+// the same server name on the same port as the project's site, which nginx
+// warns about and then ignores, and for servers listening on ports the
+// project's services bind, which keep those services from starting. The
+// project's own site is skipped; includes are not followed.
+func (m *Manager) Conflicts(project *storage.Project, servicePorts []int) []Conflict {
+	config, err := m.GenerateSiteConfig(project)
+	if err != nil {
+		return nil
+	}
+	site := parseServers(config)
+	own := m.SiteConfigPath(project)
+
+	var conflicts []Conflict
+	for _, path := range m.loadedConfigs() {
+		if path == own || resolves(path, own) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		managed := strings.HasPrefix(string(data), "# Managed by Servio")
+		for _, other := range parseServers(string(data)) {
+			for _, port := range other.ports {
+				for _, ours := range site {
+					if name := sharedName(ours, other, port); name != "" {
+						conflicts = append(conflicts, Conflict{
+							Kind: "server_name", Path: path, Line: other.line, ServerName: name, Port: port, Managed: managed,
+							Message: fmt.Sprintf("%s:%d also serves %s on port %d; nginx ignores one of the two sites", path, other.line, name, port),
+						})
+					}
+				}
+				for _, servicePort := range servicePorts {
+					if port == servicePort {
+						conflicts = append(conflicts, Conflict{
+							Kind: "listen", Path: path, Line: other.line, Port: port, Managed: managed,
+							Message: fmt.Sprintf("%s:%d listens on port %d, which a service of this project binds", path, other.line, port),
+						})
+					}
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+// withConflicts adds the conflicts found before installing a site to why
+// installing it failed
+func withConflicts(err error, conflicts []Conflict) error {
+	if len(conflicts) == 0 {
+		return err
+	}
+	messages := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		messages[i] = c.Message
+	}
+	return fmt.Errorf("%w; conflicting configs: %s", err, strings.Join(messages, "; "))
+}
+
+// servicePorts returns the ports a project's services bind
+func servicePorts(project *storage.Project) []int {
+	var ports []int
+	for _, service := range project.Services {
+		if service.Port != 0 {
+			ports = append(ports, service.Port)
+		}
+	}
+	return ports
+}
+
+// loadedConfigs lists the config files nginx loads by default: its main
+// config, conf.d, and the enabled sites
+func (m *Manager) loadedConfigs() []string {
+	paths := []string{filepath.Join(ConfDir, "nginx.conf")}
+	confD, _ := filepath.Glob(filepath.Join(ConfDir, "conf.d", "*.conf"))
+	paths = append(paths, confD...)
+
+	available, enabled := m.dirs()
+	var sites []string
+	if enabled != "" {
+		sites, _ = filepath.Glob(filepath.Join(enabled, "*"))
+	} else if available != filepath.Join(ConfDir, "conf.d") {
+		sites, _ = filepath.Glob(filepath.Join(available, "*.conf"))
+	}
+	return append(paths, sites...)
+}
+
+// resolves reports whether path is a link to target
+func resolves(path, target string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	want, err := filepath.EvalSymlinks(target)
+	return err == nil && resolved == want
+}
+
+// sharedName returns a server name both blocks answer for on port, or ""
+func sharedName(a, b serverBlock, port int) string {
+	if !hasPort(a.ports, port) {
+		return ""
+	}
+	for _, name := range a.names {
+		if name == "_" || name == "" {
+			continue
+		}
+		for _, other := range b.names {
+			if strings.EqualFold(name, other) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func hasPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// parseServers returns the server blocks of an nginx config with their
+// names and ports; blocks without a listen directive listen on 80. Servers
+// of stream blocks are skipped, as they do not serve names.
+func parseServers(config string) []serverBlock {
+	var (
+		blocks  []serverBlock
+		stack   []string // names of the open blocks
+		current *serverBlock
+		words   []string
+		start   int
+	)
+	for _, tok := range tokenize(config) {
+		switch tok.text {
+		case "{":
+			name := ""
+			if len(words) > 0 {
+				name = words[0]
+			}
+			if name == "server" && current == nil && !inBlock(stack, "stream") {
+				current = &serverBlock{line: start}
+			}
+			stack = append(stack, name)
+			words = nil
+		case "}":
+			if len(stack) > 0 {
+				if stack[len(stack)-1] == "server" && current != nil && innermostServer(stack) {
+					if len(current.ports) == 0 {
+						current.ports = []int{80}
+					}
+					blocks = append(blocks, *current)
+					current = nil
+				}
+				stack = stack[:len(stack)-1]
+			}
+			words = nil
+		case ";":
+			if current != nil && len(words) > 1 && len(stack) > 0 && stack[len(stack)-1] == "server" {
+				switch words[0] {
+				case "listen":
+					if port, ok := listenPort(words[1]); ok && !hasPort(current.ports, port) {
+						current.ports = append(current.ports, port)
+					}
+				case "server_name":
+					current.names = append(current.names, words[1:]...)
+				}
+			}
+			words = nil
+		default:
+			if len(words) == 0 {
+				start = tok.line
+			}
+			words = append(words, tok.text)
+		}
+	}
+	return blocks
+}
+
+func inBlock(stack []string, name string) bool {
+	for _, s := range stack {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// innermostServer reports whether the block being closed is the only server
+// block open, so a nested one does not end the block being read
+func innermostServer(stack []string) bool {
+	n := 0
+	for _, s := range stack {
+		if s == "server" {
+			n++
+		}
+	}
+	return n == 1
+}
+
+// listenPort returns the port of a listen directive's address: 80, *:80,
+// [::]:443, or 127.0.0.1 (port 80). Unix sockets have none.
+func listenPort(address string) (int, bool) {
+	if strings.HasPrefix(address, "unix:") {
+		return 0, false
+	}
+	if i := strings.LastIndex(address, ":"); i >= 0 && !strings.HasSuffix(address, "]") {
+		address = address[i+1:]
+	}
+	port, err := strconv.Atoi(address)
+	if err != nil {
+		return 80, true
+	}
+	return port, true
+}
+
+// token is a word or one of { } ; of an nginx config
+type token struct {
+	text string
+	line int
+}
+
+// tokenize splits an nginx config into tokens, dropping comments and
+// unquoting quoted words
+func tokenize(config string) []token {
+	var (
+		tokens []token
+		word   strings.Builder
+		line   = 1
+		quote  rune
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, token{text: word.String(), line: line})
+			word.Reset()
+		}
+	}
+	comment := false
+	for _, r := range config {
+		switch {
+		case r == '\n':
+			if quote != 0 {
+				word.WriteRune(r)
+			} else {
+				flush()
+			}
+			comment = false
+			line++
+		case comment:
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			flush()
+			comment = true
+		case r == '{' || r == '}' || r == ';':
+			flush()
+			tokens = append(tokens, token{text: string(r), line: line})
+		case r == ' ' || r == '\t' || r == '\r':
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
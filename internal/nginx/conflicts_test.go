@@ -0,0 +1,84 @@
+package nginx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseServers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []serverBlock
+	}{
+		{
+			name:   "default port",
+			config: "server {\n  server_name shop.example www.shop.example;\n}\n",
+			want:   []serverBlock{{line: 1, names: []string{"shop.example", "www.shop.example"}, ports: []int{80}}},
+		},
+		{
+			name: "listen addresses",
+			config: `server {
+				listen 443 ssl;
+				listen [::]:443 ssl;
+				listen 127.0.0.1:8443;
+				listen unix:/run/nginx.sock;
+				server_name "quoted.example"; # a comment
+			}`,
+			want: []serverBlock{{line: 1, names: []string{"quoted.example"}, ports: []int{443, 8443}}},
+		},
+		{
+			name: "inside http with locations",
+			config: `http {
+				server {
+					listen 8080;
+					location / { listen 9; server_name not.this; }
+				}
+				server { listen 81; }
+			}`,
+			want: []serverBlock{
+				{line: 2, ports: []int{8080}},
+				{line: 6, ports: []int{81}},
+			},
+		},
+		{
+			name:   "stream servers",
+			config: "stream { server { listen 5432; } }\nserver { listen 80; }",
+			want:   []serverBlock{{line: 2, ports: []int{80}}},
+		},
+		{
+			name:   "unbalanced",
+			config: "server { listen 80;",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseServers(tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseServers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	tests := []struct {
+		address string
+		port    int
+		ok      bool
+	}{
+		{"8080", 8080, true},
+		{"127.0.0.1:8080", 8080, true},
+		{"[::]:443", 443, true},
+		{"[::1]", 80, true},
+		{"localhost", 80, true},
+		{"unix:/run/nginx.sock", 0, false},
+	}
+
+	for _, tt := range tests {
+		if port, ok := listenPort(tt.address); port != tt.port || ok != tt.ok {
+			t.Errorf("listenPort(%q) = %d, %v; want %d, %v", tt.address, port, ok, tt.port, tt.ok)
+		}
+	}
+}
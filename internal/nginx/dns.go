@@ -0,0 +1,201 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// ErrDNSMismatch is wrapped when a site's domain does not resolve to the server it is deployed on
+var ErrDNSMismatch = errors.New("domain does not point at this server")
+
+// DNS check outcomes
+const (
+	DNSOK         = "ok"
+	DNSMismatch   = "mismatch"
+	DNSUnverified = "unverified" // the server's public address is unknown, or a lookup failed
+)
+
+// DNSCheck reports whether each name of a site resolves to the server
+type DNSCheck struct {
+	Status    string      `json:"status"` // ok, mismatch, or unverified
+	ServerIPs []string    `json:"server_ips"`
+	Names     []NameCheck `json:"names"`
+	Problem   string      `json:"problem,omitempty"` // why the check is unverified, if it is
+	// Warnings do not fail the check, e.g. a name IPv6 clients cannot reach
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// NameCheck is the outcome for one server_name
+type NameCheck struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`         // its A and AAAA records
+	Problem   string   `json:"problem,omitempty"` // what to fix, when it does not point at the server
+}
+
+// Err returns an ErrDNSMismatch naming what to fix, or nil unless the check
+// found a mismatch. A nil check has no error.
+func (c *DNSCheck) Err() error {
+	if c == nil || c.Status != DNSMismatch {
+		return nil
+	}
+	var problems []string
+	for _, name := range c.Names {
+		if name.Problem != "" {
+			problems = append(problems, name.Problem)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDNSMismatch, strings.Join(problems, "; "))
+}
+
+// CheckDNS resolves each name in domain (a server_name list) and compares its
+// addresses with the server's. Wildcard and regex names are skipped. Every
+// record must be one of serverIPs, since clients pick any of them; with no
+// serverIPs the check is unverified.
+func CheckDNS(ctx context.Context, resolver *net.Resolver, domain string, serverIPs []net.IP) *DNSCheck {
+	check := &DNSCheck{Status: DNSOK, ServerIPs: ipStrings(serverIPs), Names: []NameCheck{}}
+	if len(serverIPs) == 0 {
+		check.Status = DNSUnverified
+		check.Problem = "the server's public IP is unknown; set the public_ip setting"
+	}
+	server := strings.Join(check.ServerIPs, ", ")
+
+	for _, name := range strings.Fields(domain) {
+		if strings.ContainsAny(name, "*~") || name == "_" {
+			continue
+		}
+		result := NameCheck{Name: name, Addresses: []string{}}
+		addrs, err := resolver.LookupIPAddr(ctx, name)
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			result.Problem = fmt.Sprintf("%s has no A or AAAA record", name)
+			if len(serverIPs) > 0 {
+				result.Problem += ", add one pointing to " + server
+			}
+		case err != nil:
+			// A resolver failure says nothing about the records
+			if check.Status != DNSMismatch {
+				check.Status = DNSUnverified
+			}
+			check.Problem = fmt.Sprintf("failed to resolve %s: %v", name, err)
+		default:
+			var wrong []string
+			for _, addr := range addrs {
+				result.Addresses = append(result.Addresses, addr.IP.String())
+				if !slices.ContainsFunc(serverIPs, addr.IP.Equal) {
+					wrong = append(wrong, recordType(addr.IP)+" record points to "+addr.IP.String())
+				}
+			}
+			if len(wrong) > 0 && len(serverIPs) > 0 {
+				result.Problem = fmt.Sprintf("%s %s, server is %s", name, strings.Join(wrong, " and "), server)
+			}
+		}
+		if result.Problem != "" {
+			check.Status = DNSMismatch
+		}
+		check.Names = append(check.Names, result)
+	}
+	return check
+}
+
+// CheckIPv6 adds what a site's IPv6 listening means for its names to check:
+// with ipv6 off, an AAAA record pointing at the server is a mismatch, since
+// IPv6 clients would find nothing listening there; with it on, a name
+// without an AAAA record is a warning when the server has an IPv6 address
+func CheckIPv6(check *DNSCheck, serverIPs []net.IP, ipv6 bool) {
+	var serverV6 []string
+	for _, ip := range serverIPs {
+		if ip.To4() == nil {
+			serverV6 = append(serverV6, ip.String())
+		}
+	}
+	if len(serverV6) == 0 {
+		return
+	}
+	for i, name := range check.Names {
+		hasAAAA := slices.ContainsFunc(name.Addresses, func(addr string) bool {
+			return net.ParseIP(addr).To4() == nil
+		})
+		switch {
+		case !ipv6 && hasAAAA && name.Problem == "":
+			check.Names[i].Problem = fmt.Sprintf("%s has an AAAA record but the site does not listen on IPv6; enable nginx_ipv6 or remove the record", name.Name)
+			check.Status = DNSMismatch
+		case ipv6 && !hasAAAA && len(name.Addresses) > 0:
+			check.Warnings = append(check.Warnings, fmt.Sprintf("%s has no AAAA record, so IPv6 clients cannot reach it; add one pointing to %s", name.Name, strings.Join(serverV6, ", ")))
+		}
+	}
+}
+
+// PublicIPs returns the server's public addresses: the configured list
+// (comma- or space-separated) when given, otherwise the public unicast
+// addresses of its interfaces. A server behind NAT has none of its own.
+func PublicIPs(configured string) ([]net.IP, error) {
+	if fields := strings.FieldsFunc(configured, func(r rune) bool { return r == ',' || r == ' ' }); len(fields) > 0 {
+		ips := make([]net.IP, 0, len(fields))
+		for _, field := range fields {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid public IP %q", field)
+			}
+			ips = append(ips, ip)
+		}
+		return ips, nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && isPublic(ipnet.IP) {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// LookupPublicIPs resolves host (a name or address) to its public addresses
+func LookupPublicIPs(ctx context.Context, resolver *net.Resolver, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if isPublic(ip) {
+			return []net.IP{ip}, nil
+		}
+		return nil, nil
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if isPublic(addr.IP) {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips, nil
+}
+
+// isPublic reports whether ip is a globally routable unicast address
+func isPublic(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+func recordType(ip net.IP) string {
+	if ip.To4() != nil {
+		return "A"
+	}
+	return "AAAA"
+}
+
+func ipStrings(ips []net.IP) []string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return s
+}
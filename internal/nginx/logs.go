@@ -0,0 +1,105 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"servio/internal/logparse"
+	"servio/internal/storage"
+)
+
+// LogDir holds the access and error logs the generated site configs write
+var LogDir = "/var/log/nginx"
+
+// Logs a site writes
+const (
+	AccessLog = "access"
+	ErrorLog  = "error"
+)
+
+// ErrUnknownLog is wrapped by LogPath errors for logs a site does not have
+var ErrUnknownLog = errors.New("unknown nginx log")
+
+// accessStatus finds the status code after the request in the combined log format
+var accessStatus = regexp.MustCompile(`" ([1-5][0-9]{2}) `)
+
+// accessTime finds the time of an access log line: [02/Jan/2006:15:04:05 -0700]
+var accessTime = regexp.MustCompile(`\[([0-9]{2}/[A-Za-z]{3}/[0-9]{4}:[0-9:]{8} [+-][0-9]{4})\]`)
+
+// errorLogLevel finds the severity of an error log line:
+// 2024/01/02 03:04:05 [error] 12#12: *3 connect() failed ...
+var errorLogLevel = regexp.MustCompile(`^\S+ \S+ \[([a-z]+)\]`)
+
+// LogPath returns the file a project's site writes its access or error log
+// to, as named in the generated config. Custom configs may log elsewhere.
+func LogPath(project *storage.Project, kind string) (string, error) {
+	if kind != AccessLog && kind != ErrorLog {
+		return "", fmt.Errorf("%w: %q (want %s or %s)", ErrUnknownLog, kind, AccessLog, ErrorLog)
+	}
+	path := filepath.Join(LogDir, project.Name+"."+kind+".log")
+	if filepath.Dir(path) != filepath.Clean(LogDir) {
+		return "", fmt.Errorf("%w: project name %q is not a file name", ErrUnknownLog, project.Name)
+	}
+	return path, nil
+}
+
+// LineLevel classifies a log line with the levels of logparse: access log
+// lines by response status (5xx errors, 4xx warnings), and error log lines
+// by the severity nginx wrote
+func LineLevel(kind, line string) string {
+	if kind == AccessLog {
+		switch status := accessStatusCode(line); {
+		case status >= 500:
+			return logparse.LevelError
+		case status >= 400:
+			return logparse.LevelWarn
+		}
+		return logparse.LevelInfo
+	}
+	m := errorLogLevel.FindStringSubmatch(line)
+	if m == nil {
+		return logparse.ParseMessage(line).Level
+	}
+	switch m[1] {
+	case "emerg", "alert", "crit", "error":
+		return logparse.LevelError
+	case "warn":
+		return logparse.LevelWarn
+	case "debug":
+		return logparse.LevelDebug
+	}
+	return logparse.LevelInfo
+}
+
+// LineTime returns when a log line was written. Error log times are in the
+// server's local time zone.
+func LineTime(kind, line string) (time.Time, bool) {
+	if kind == AccessLog {
+		m := accessTime.FindStringSubmatch(line)
+		if m == nil {
+			return time.Time{}, false
+		}
+		t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+		return t, err == nil
+	}
+	if len(line) < len("2006/01/02 15:04:05") {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006/01/02 15:04:05", line[:len("2006/01/02 15:04:05")], time.Local)
+	return t, err == nil
+}
+
+// accessStatusCode returns the response status of an access log line, or 0
+// if the line is not in the combined format
+func accessStatusCode(line string) int {
+	m := accessStatus.FindStringSubmatch(line)
+	if m == nil {
+		return 0
+	}
+	status, _ := strconv.Atoi(m[1])
+	return status
+}
@@ -0,0 +1,31 @@
+package nginx
+
+import (
+	"testing"
+
+	"servio/internal/logparse"
+)
+
+func TestLineLevel(t *testing.T) {
+	tests := []struct {
+		kind string
+		line string
+		want string
+	}{
+		{AccessLog, `1.2.3.4 - - [02/Jan/2026:15:04:05 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/8.0"`, logparse.LevelInfo},
+		{AccessLog, `1.2.3.4 - - [02/Jan/2026:15:04:05 +0000] "GET /x HTTP/1.1" 404 153 "-" "curl/8.0"`, logparse.LevelWarn},
+		{AccessLog, `1.2.3.4 - - [02/Jan/2026:15:04:05 +0000] "GET /api HTTP/1.1" 502 157 "-" "curl/8.0"`, logparse.LevelError},
+		{AccessLog, "not an access log line", logparse.LevelInfo},
+		{ErrorLog, "2026/01/02 15:04:05 [error] 12#12: *3 connect() failed", logparse.LevelError},
+		{ErrorLog, "2026/01/02 15:04:05 [crit] 12#12: *3 SSL_do_handshake() failed", logparse.LevelError},
+		{ErrorLog, "2026/01/02 15:04:05 [warn] 12#12: conflicting server name", logparse.LevelWarn},
+		{ErrorLog, "2026/01/02 15:04:05 [notice] 12#12: signal process started", logparse.LevelInfo},
+		{ErrorLog, "2026/01/02 15:04:05 [debug] 12#12: epoll add event", logparse.LevelDebug},
+	}
+
+	for _, tt := range tests {
+		if got := LineLevel(tt.kind, tt.line); got != tt.want {
+			t.Errorf("LineLevel(%q, %q) = %q, want %q", tt.kind, tt.line, got, tt.want)
+		}
+	}
+}
@@ -2,13 +2,21 @@ package nginx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"servio/internal/acme"
+	"servio/internal/audit"
+	"servio/internal/dryrun"
 	"servio/internal/storage"
 )
 
@@ -31,34 +39,219 @@ var (
 	NginxBinary = "nginx"
 )
 
+// ErrConfigTest is wrapped when `nginx -t` rejects the configuration
+var ErrConfigTest = errors.New("nginx config test failed")
+
 // Manager handles Nginx site configuration
 type Manager struct {
+	mu                sync.RWMutex // paths may change while sites are being written
+	distro            string
+	customSitesDir    string // set by SetDirs; overrides the distro's layout
+	customEnabledDir  string
 	sitesAvailableDir string
 	sitesEnabledDir   string
+	wildcards         []Wildcard // shared certificates sites may serve, set by SetWildcards
+	protocols         Protocols  // last detection of what nginx supports
+	protocolsAt       time.Time
+	ipv6              bool         // generated sites also listen on [::]
+	upstreamHost      string       // what proxy_pass targets; DefaultUpstreamHost when ""
+	statics           StaticSource // set by SetStatics
+}
+
+// DefaultUpstreamHost is where generated sites reach services unless
+// SetNetwork says otherwise
+const DefaultUpstreamHost = "127.0.0.1"
+
+// ErrInvalidUpstreamHost is wrapped for upstream hosts nginx cannot proxy to
+var ErrInvalidUpstreamHost = errors.New("invalid upstream host")
+
+// Wildcard is an issued certificate for Domain and its subdomains, shared
+// by every site it covers
+type Wildcard struct {
+	Domain string
+	Name   string // certbot certificate name
 }
 
 // NewManager creates a new Nginx manager
 func NewManager() *Manager {
-	m := &Manager{
-		sitesAvailableDir: "/etc/nginx/conf.d",
-		sitesEnabledDir:   "",
-	}
+	m := &Manager{}
+	m.applyDirs()
 	return m
 }
 
 // Configure sets paths based on the provided distro
 func (m *Manager) Configure(distro string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.distro = distro
+	m.applyDirs()
 	if distro == "ubuntu" || distro == "debian" {
-		m.sitesAvailableDir = "/etc/nginx/sites-available"
-		m.sitesEnabledDir = "/etc/nginx/sites-enabled"
 		slog.Info("Distro set to Ubuntu/Debian, using sites-available pattern")
 	} else {
+		slog.Info("Distro set to Amazon Linux/RHEL, using conf.d pattern")
+	}
+}
+
+// SetDirs overrides the distro's site directories. An empty sitesDir restores
+// the distro default; enabledDir may be empty for a conf.d-style layout.
+func (m *Manager) SetDirs(sitesDir, enabledDir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.customSitesDir, m.customEnabledDir = sitesDir, enabledDir
+	m.applyDirs()
+}
+
+// applyDirs resolves the site directories; the caller must hold mu or own m
+func (m *Manager) applyDirs() {
+	switch {
+	case m.customSitesDir != "":
+		m.sitesAvailableDir = m.customSitesDir
+		m.sitesEnabledDir = m.customEnabledDir
+	case m.distro == "ubuntu" || m.distro == "debian":
+		m.sitesAvailableDir = "/etc/nginx/sites-available"
+		m.sitesEnabledDir = "/etc/nginx/sites-enabled"
+	default:
 		m.sitesAvailableDir = "/etc/nginx/conf.d"
 		m.sitesEnabledDir = ""
-		slog.Info("Distro set to Amazon Linux/RHEL, using conf.d pattern")
 	}
 }
 
+// SetNetwork sets whether generated sites listen on IPv6 as well as IPv4,
+// and the host their proxy_pass targets reach services on: an IPv4 or IPv6
+// address, or a name such as localhost ("" for DefaultUpstreamHost). It is
+// safe to call while sites are being written.
+func (m *Manager) SetNetwork(ipv6 bool, upstreamHost string) error {
+	host, err := UpstreamHost(upstreamHost)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ipv6, m.upstreamHost = ipv6, host
+	return nil
+}
+
+// UpstreamHost validates an upstream host and returns it as nginx writes it,
+// with IPv6 addresses in brackets
+func UpstreamHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return DefaultUpstreamHost, nil
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+		return "[" + ip.String() + "]", nil
+	}
+	if !hostnamePattern.MatchString(host) {
+		return "", fmt.Errorf("%w %q: must be an IPv4 or IPv6 address or a host name", ErrInvalidUpstreamHost, host)
+	}
+	return host, nil
+}
+
+// hostnamePattern matches DNS names such as localhost or app.internal
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,62}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,62}[A-Za-z0-9])?)*$`)
+
+// network returns SetNetwork's settings
+func (m *Manager) network() (ipv6 bool, upstreamHost string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.upstreamHost == "" {
+		return m.ipv6, DefaultUpstreamHost
+	}
+	return m.ipv6, m.upstreamHost
+}
+
+// listen renders the listen directives of a server block on port, on
+// IPv6 as well when ipv6 is set
+func listen(port string, ipv6 bool) string {
+	directive := "listen " + port + ";"
+	if ipv6 {
+		directive += "\n    listen [::]:" + port + ";"
+	}
+	return directive
+}
+
+// Static is a directory of built files a generated site serves itself
+type Static struct {
+	Path string // location prefix, such as /static/
+	Dir  string
+	SPA  bool // paths without a file get the directory's index.html
+}
+
+// StaticSource returns the static directories of a service
+type StaticSource func(service *storage.Service) []Static
+
+// staticPathPattern and staticDirPattern keep statics from breaking out of
+// their location blocks
+var (
+	staticPathPattern = regexp.MustCompile(`^/([A-Za-z0-9._~-]+/)+$`)
+	staticDirPattern  = regexp.MustCompile(`^/[A-Za-z0-9._~@+/-]*$`)
+)
+
+// SetStatics sets where generated sites find the static directories of
+// their services. Without it, or when no service has any, sites serve
+// /var/www/static/ at /static/. It is safe to call while sites are being
+// written.
+func (m *Manager) SetStatics(source StaticSource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statics = source
+}
+
+// staticLocations renders the locations of a project's static directories,
+// leaving out paths a route or an earlier directory has
+func (m *Manager) staticLocations(project *storage.Project, routes []Route) []string {
+	m.mu.RLock()
+	source := m.statics
+	m.mu.RUnlock()
+
+	taken := map[string]bool{}
+	for _, route := range routes {
+		taken[route.Path] = true
+	}
+	var locations []string
+	for _, svc := range project.Services {
+		if source == nil {
+			break
+		}
+		for _, st := range source(svc) {
+			if !staticPathPattern.MatchString(st.Path) || !staticDirPattern.MatchString(st.Dir) || strings.Contains(st.Dir, "..") {
+				slog.Warn("Skipping static directory nginx cannot serve", "service", svc.Name, "path", st.Path, "dir", st.Dir)
+				continue
+			}
+			if taken[st.Path] {
+				continue
+			}
+			taken[st.Path] = true
+			dir := strings.TrimSuffix(st.Dir, "/") + "/"
+			if st.SPA {
+				locations = append(locations, fmt.Sprintf(`    location %s {
+        alias %s;
+        try_files $uri $uri/ %sindex.html;
+    }`, st.Path, dir, st.Path))
+				continue
+			}
+			locations = append(locations, fmt.Sprintf(`    location %s {
+        alias %s;
+        expires 30d;
+        add_header Cache-Control "public";
+    }`, st.Path, dir))
+		}
+	}
+	if len(locations) > 0 {
+		return locations
+	}
+
+	// Static files location (common pattern)
+	return []string{`    location /static/ {
+        alias /var/www/static/;
+        expires 30d;
+        add_header Cache-Control "public, immutable";
+    }`}
+}
+
 // GenerateSiteConfig generates an Nginx site configuration for a project, respecting Project.NginxRaw if set
 func (m *Manager) GenerateSiteConfig(project *storage.Project) (string, error) {
 	if project.NginxRaw != "" {
@@ -67,35 +260,51 @@ func (m *Manager) GenerateSiteConfig(project *storage.Project) (string, error) {
 	return m.GenerateDefaultConfig(project)
 }
 
-// GenerateDefaultConfig generates the default Nginx site configuration
+// GenerateDefaultConfig generates the default Nginx site configuration,
+// proxying everything to the first of the project's services with a port,
+// balanced across its instances when it is scaled
 func (m *Manager) GenerateDefaultConfig(project *storage.Project) (string, error) {
-	if project.Domain == "" {
-		return "", fmt.Errorf("project has no domain configured")
-	}
-
-	// Build upstream blocks for services with ports
-	var upstreams []string
-	var locations []string
-	var primaryPort int
-
+	// Default to port 8000 if no services have ports configured
+	route := Route{Path: "/", Port: 8000}
 	for _, svc := range project.Services {
 		if svc.Port > 0 {
-			if primaryPort == 0 {
-				primaryPort = svc.Port
-			}
-			upstreams = append(upstreams, fmt.Sprintf(`    # %s
-    server 127.0.0.1:%d;`, svc.Name, svc.Port))
+			route.Port, route.Ports = svc.Port, svc.InstancePorts()
+			break
 		}
 	}
+	return m.GenerateRoutedConfig(project, []Route{route})
+}
 
-	// Default to port 8000 if no services have ports configured
-	if primaryPort == 0 {
-		primaryPort = 8000
+// Route proxies a location to a local port
+type Route struct {
+	Path string
+	Port int
+	// Ports, when there are several, are balanced across instead of Port
+	Ports []int
+}
+
+// GenerateRoutedConfig generates a site configuration proxying each route's
+// location to its port
+func (m *Manager) GenerateRoutedConfig(project *storage.Project, routes []Route) (string, error) {
+	if project.Domain == "" {
+		return "", fmt.Errorf("project has no domain configured")
 	}
 
-	// Default location proxies to primary service
-	locations = append(locations, fmt.Sprintf(`    location / {
-        proxy_pass http://127.0.0.1:%d;
+	ipv6, upstreamHost := m.network()
+	var upstreams, locations []string
+	for i, route := range routes {
+		target := fmt.Sprintf("%s:%d", upstreamHost, route.Port)
+		if len(route.Ports) > 1 {
+			// Upstream names are shared by every site, so they carry the project's
+			target = fmt.Sprintf("servio_%s_%d", sanitizeName(project.Name), i)
+			servers := make([]string, len(route.Ports))
+			for j, port := range route.Ports {
+				servers[j] = fmt.Sprintf("    server %s:%d;", upstreamHost, port)
+			}
+			upstreams = append(upstreams, fmt.Sprintf("upstream %s {\n%s\n}\n", target, strings.Join(servers, "\n")))
+		}
+		locations = append(locations, fmt.Sprintf(`    location %s {
+        proxy_pass http://%s;
         proxy_http_version 1.1;
         proxy_set_header Host $host;
         proxy_set_header X-Real-IP $remote_addr;
@@ -104,20 +313,25 @@ func (m *Manager) GenerateDefaultConfig(project *storage.Project) (string, error
         proxy_set_header Upgrade $http_upgrade;
         proxy_set_header Connection "upgrade";
         proxy_read_timeout 86400;
-    }`, primaryPort))
+    }`, route.Path, target))
+	}
 
-	// Static files location (common pattern)
-	locations = append(locations, `    location /static/ {
-        alias /var/www/static/;
-        expires 30d;
-        add_header Cache-Control "public, immutable";
-    }`)
+	locations = append(locations, m.staticLocations(project, routes)...)
+
+	// With a certificate, plain HTTP only redirects to HTTPS
+	listens := listen("80", ipv6)
+	cert := m.SiteCertificate(project)
+	if cert != "" {
+		listens = fmt.Sprintf(`%s
+    ssl_certificate %s;
+    ssl_certificate_key %s;`, strings.Join(m.tlsListens(project, ipv6), "\n    "), acme.CertPath(cert), acme.KeyPath(cert))
+	}
 
 	config := fmt.Sprintf(`# Managed by Servio - Project: %s
 # Generated: Do not edit manually, changes will be overwritten
-
+%s%s
 server {
-    listen 80;
+    %s
     server_name %s;
 
     # Security headers
@@ -125,8 +339,8 @@ server {
     add_header X-Content-Type-Options "nosniff" always;
 
     # Logging
-    access_log /var/log/nginx/%s.access.log;
-    error_log /var/log/nginx/%s.error.log;
+    access_log %s/%s.access.log;
+    error_log %s/%s.error.log;
 
 %s
 
@@ -136,15 +350,71 @@ server {
         root /usr/share/nginx/html;
     }
 }
-`, project.Name, project.Domain, project.Name, project.Name, strings.Join(locations, "\n\n"))
+`, project.Name, strings.Join(upstreams, ""), httpsRedirect(project, cert, ipv6), listens, project.Domain, LogDir, project.Name, LogDir, project.Name, strings.Join(locations, "\n\n"))
 
 	return config, nil
 }
 
+// httpsRedirect returns the plain HTTP server of a site with a certificate,
+// or nothing without one
+func httpsRedirect(project *storage.Project, cert string, ipv6 bool) string {
+	if cert == "" {
+		return ""
+	}
+	return fmt.Sprintf(`
+server {
+    %s
+    server_name %s;
+    return 301 https://$host$request_uri;
+}
+`, listen("80", ipv6), project.Domain)
+}
+
+// SetWildcards replaces the shared certificates sites may serve. It is safe
+// to call while sites are being written.
+func (m *Manager) SetWildcards(wildcards []Wildcard) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.wildcards = wildcards
+}
+
+// SiteCertificate returns the name of the certificate a project's site
+// serves: its own, else a wildcard covering every name of its domain, or ""
+// for plain HTTP
+func (m *Manager) SiteCertificate(project *storage.Project) string {
+	if project.Certificate != "" {
+		return project.Certificate
+	}
+	names := acme.Domains(project.Domain)
+	if len(names) == 0 {
+		return ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, w := range m.wildcards {
+		covered := true
+		for _, name := range names {
+			covered = covered && acme.WildcardCovers(w.Domain, name)
+		}
+		if covered {
+			return w.Name
+		}
+	}
+	return ""
+}
+
+// dirs returns the current sites-available (or conf.d) and sites-enabled directories
+func (m *Manager) dirs() (available, enabled string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sitesAvailableDir, m.sitesEnabledDir
+}
+
 // SiteConfigPath returns the path where the site config will be written
 func (m *Manager) SiteConfigPath(project *storage.Project) string {
 	filename := fmt.Sprintf("servio-%d-%s.conf", project.ID, sanitizeName(project.Name))
-	return filepath.Join(m.sitesAvailableDir, filename)
+	available, _ := m.dirs()
+	return filepath.Join(available, filename)
 }
 
 // InstallSite writes the site config and reloads Nginx
@@ -155,6 +425,23 @@ func (m *Manager) InstallSite(ctx context.Context, project *storage.Project) err
 	}
 
 	configPath := m.SiteConfigPath(project)
+	_, enabledDir := m.dirs()
+	conflicts := m.Conflicts(project, servicePorts(project))
+	for _, c := range conflicts {
+		slog.WarnContext(ctx, "Nginx site conflict", "project", project.Name, "conflict", c.Message)
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(filepath.Dir(configPath), 0755)
+		plan.Write(configPath, config, 0644)
+		if enabledDir != "" {
+			plan.Symlink(configPath, filepath.Join(enabledDir, filepath.Base(configPath)))
+		}
+		if err := m.TestConfig(ctx); err != nil {
+			return err
+		}
+		return m.Reload(ctx)
+	}
 
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
@@ -162,15 +449,18 @@ func (m *Manager) InstallSite(ctx context.Context, project *storage.Project) err
 	}
 
 	// Write config file
-	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+	writeStart := time.Now()
+	err = os.WriteFile(configPath, []byte(config), 0644)
+	audit.Log(ctx, audit.CategoryNginx, "write-site", "write "+configPath, "", err, time.Since(writeStart))
+	if err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 
 	slog.Info("Wrote nginx config", "path", configPath, "project", project.Name)
 
 	// Create symlink if using sites-enabled pattern
-	if m.sitesEnabledDir != "" {
-		enabledPath := filepath.Join(m.sitesEnabledDir, filepath.Base(configPath))
+	if enabledDir != "" {
+		enabledPath := filepath.Join(enabledDir, filepath.Base(configPath))
 		os.Remove(enabledPath) // Remove existing symlink
 		if err := os.Symlink(configPath, enabledPath); err != nil {
 			return fmt.Errorf("failed to create symlink: %w", err)
@@ -182,12 +472,14 @@ func (m *Manager) InstallSite(ctx context.Context, project *storage.Project) err
 	if err := m.TestConfig(ctx); err != nil {
 		// Rollback: remove the config
 		os.Remove(configPath)
-		return fmt.Errorf("nginx config test failed: %w", err)
+		return withConflicts(err, conflicts)
 	}
+	// Kept only once nginx accepts it, as a rejected config is rolled back
+	audit.WroteFile(ctx, audit.CategoryNginx, configPath, []byte(config), 0644)
 
 	// Reload Nginx
 	if err := m.Reload(ctx); err != nil {
-		return fmt.Errorf("failed to reload nginx: %w", err)
+		return withConflicts(fmt.Errorf("failed to reload nginx: %w", err), conflicts)
 	}
 
 	return nil
@@ -196,17 +488,30 @@ func (m *Manager) InstallSite(ctx context.Context, project *storage.Project) err
 // UninstallSite removes the site config and reloads Nginx
 func (m *Manager) UninstallSite(ctx context.Context, project *storage.Project) error {
 	configPath := m.SiteConfigPath(project)
+	_, enabledDir := m.dirs()
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		if enabledDir != "" {
+			plan.Remove(filepath.Join(enabledDir, filepath.Base(configPath)))
+		}
+		plan.Remove(configPath)
+		return m.Reload(ctx)
+	}
 
 	// Remove symlink if exists
-	if m.sitesEnabledDir != "" {
-		enabledPath := filepath.Join(m.sitesEnabledDir, filepath.Base(configPath))
+	if enabledDir != "" {
+		enabledPath := filepath.Join(enabledDir, filepath.Base(configPath))
 		os.Remove(enabledPath)
 	}
 
 	// Remove config file
+	removeStart := time.Now()
 	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		audit.Log(ctx, audit.CategoryNginx, "remove-site", "remove "+configPath, "", err, time.Since(removeStart))
 		return fmt.Errorf("failed to remove config: %w", err)
 	}
+	audit.Log(ctx, audit.CategoryNginx, "remove-site", "remove "+configPath, "", nil, time.Since(removeStart))
+	audit.RemovedFile(ctx, configPath)
 
 	slog.Info("Removed nginx config", "path", configPath, "project", project.Name)
 
@@ -217,9 +522,9 @@ func (m *Manager) UninstallSite(ctx context.Context, project *storage.Project) e
 // TestConfig tests the Nginx configuration
 func (m *Manager) TestConfig(ctx context.Context) error {
 	cmd := exec.CommandContext(ctx, "sudo", NginxBinary, "-t")
-	output, err := cmd.CombinedOutput()
+	output, err := audit.Run(ctx, audit.CategoryNginx, "test", cmd)
 	if err != nil {
-		return fmt.Errorf("config test failed: %s", string(output))
+		return fmt.Errorf("%w: %s", ErrConfigTest, string(output))
 	}
 	return nil
 }
@@ -227,13 +532,22 @@ func (m *Manager) TestConfig(ctx context.Context) error {
 // Reload reloads the Nginx configuration
 func (m *Manager) Reload(ctx context.Context) error {
 	cmd := exec.CommandContext(ctx, "sudo", "systemctl", "reload", "nginx")
-	if err := cmd.Run(); err != nil {
+	if _, err := audit.Run(ctx, audit.CategoryNginx, "reload", cmd); err != nil {
 		return fmt.Errorf("failed to reload nginx: %w", err)
 	}
 	slog.Info("Reloaded nginx")
 	return nil
 }
 
+// SitesDirs returns the directories site configs are written to
+func (m *Manager) SitesDirs() []string {
+	available, enabled := m.dirs()
+	if enabled == "" {
+		return []string{available}
+	}
+	return []string{available, enabled}
+}
+
 // IsInstalled checks if Nginx is installed
 func (m *Manager) IsInstalled() bool {
 	_, err := exec.LookPath(NginxBinary)
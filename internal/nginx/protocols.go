@@ -0,0 +1,160 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/storage"
+)
+
+// ErrProtocolUnsupported is wrapped when a site asks for HTTP/2 or HTTP/3
+// and the host's nginx cannot serve it
+var ErrProtocolUnsupported = errors.New("protocol not supported by nginx")
+
+// protocolsTTL is how long a detection is trusted; nginx is rarely upgraded
+// under a running Servio, but an upgrade should not need a restart
+const protocolsTTL = 10 * time.Minute
+
+// Protocols is what the host's nginx can serve besides HTTP/1.1, from
+// `nginx -V`
+type Protocols struct {
+	Version string `json:"version"` // e.g. 1.24.0; "" when nginx is missing
+	HTTP2   bool   `json:"http2"`   // built with http_v2_module
+	HTTP3   bool   `json:"http3"`   // built with http_v3_module, 1.25.0 or later
+	// HTTP2Directive is set from 1.25.1, which enables HTTP/2 with
+	// "http2 on;" and deprecates the http2 listen parameter
+	HTTP2Directive bool `json:"http2_directive"`
+}
+
+// Check returns an ErrProtocolUnsupported unless nginx supports what is asked for
+func (p Protocols) Check(http2, http3 bool) error {
+	switch {
+	case (http2 || http3) && p.Version == "":
+		return fmt.Errorf("%w: nginx was not found", ErrProtocolUnsupported)
+	case http2 && !p.HTTP2:
+		return fmt.Errorf("%w: nginx %s was built without http_v2_module", ErrProtocolUnsupported, p.Version)
+	case http3 && !p.HTTP3:
+		return fmt.Errorf("%w: HTTP/3 needs nginx 1.25 or later built with http_v3_module, this is %s", ErrProtocolUnsupported, p.Version)
+	}
+	return nil
+}
+
+var versionPattern = regexp.MustCompile(`nginx/(\d+)\.(\d+)\.(\d+)`)
+
+// parseProtocols reads the output of `nginx -V`
+func parseProtocols(output string) Protocols {
+	match := versionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return Protocols{}
+	}
+	var v [3]int
+	for i := range v {
+		v[i], _ = strconv.Atoi(match[i+1])
+	}
+	atLeast := func(major, minor, patch int) bool {
+		return v[0] > major || v[0] == major && (v[1] > minor || v[1] == minor && v[2] >= patch)
+	}
+	return Protocols{
+		Version:        fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2]),
+		HTTP2:          strings.Contains(output, "--with-http_v2_module"),
+		HTTP3:          strings.Contains(output, "--with-http_v3_module") && atLeast(1, 25, 0),
+		HTTP2Directive: atLeast(1, 25, 1),
+	}
+}
+
+// Protocols detects what the host's nginx supports, reusing the last
+// detection for protocolsTTL
+func (m *Manager) Protocols(ctx context.Context) Protocols {
+	m.mu.RLock()
+	cached, at := m.protocols, m.protocolsAt
+	m.mu.RUnlock()
+	if !at.IsZero() && time.Since(at) < protocolsTTL {
+		return cached
+	}
+
+	// nginx -V prints to stderr and needs no privileges
+	output, _ := exec.CommandContext(ctx, NginxBinary, "-V").CombinedOutput()
+	detected := parseProtocols(string(output))
+	m.mu.Lock()
+	m.protocols, m.protocolsAt = detected, time.Now()
+	m.mu.Unlock()
+	return detected
+}
+
+// tlsListens renders the listen directives of a site's HTTPS server block
+// with the protocols it asked for and nginx supports. QUIC listens take
+// reuseport unless another site already does, since nginx allows it once
+// per address and port.
+func (m *Manager) tlsListens(project *storage.Project, ipv6 bool) []string {
+	var protocols Protocols
+	if project.HTTP2 || project.HTTP3 {
+		protocols = m.Protocols(context.Background())
+	}
+	http2 := project.HTTP2 && protocols.HTTP2
+	http3 := project.HTTP3 && protocols.HTTP3
+
+	addresses := []string{"443"}
+	if ipv6 {
+		addresses = append(addresses, "[::]:443")
+	}
+	var lines []string
+	for _, address := range addresses {
+		if http2 && !protocols.HTTP2Directive {
+			lines = append(lines, "listen "+address+" ssl http2;")
+		} else {
+			lines = append(lines, "listen "+address+" ssl;")
+		}
+	}
+	if http3 {
+		reuseport := !m.otherSiteReusesPort(project)
+		for _, address := range addresses {
+			if reuseport {
+				lines = append(lines, "listen "+address+" quic reuseport;")
+			} else {
+				lines = append(lines, "listen "+address+" quic;")
+			}
+		}
+	}
+	if http2 && protocols.HTTP2Directive {
+		lines = append(lines, "http2 on;")
+	}
+	if http3 {
+		// Tells browsers that arrived over TCP where to find HTTP/3
+		lines = append(lines, `add_header Alt-Svc 'h3=":443"; ma=86400' always;`)
+	}
+	return lines
+}
+
+// reusePortPattern matches a QUIC listen on 443 that sets reuseport
+var reusePortPattern = regexp.MustCompile(`(?m)^\s*listen\s+(\[::\]:)?443\s+quic\b[^;]*\breuseport\b`)
+
+// otherSiteReusesPort reports whether a site other than project's already
+// listens on 443 for QUIC with reuseport
+func (m *Manager) otherSiteReusesPort(project *storage.Project) bool {
+	own := m.SiteConfigPath(project)
+	for _, dir := range m.SitesDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if path == own || filepath.Base(path) == filepath.Base(own) || entry.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err == nil && reusePortPattern.Match(data) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"servio/internal/events"
+	"servio/internal/storage"
+)
+
+// Message is what channel templates are executed with
+type Message struct {
+	Type    string                 // event type, or EventPing
+	Time    time.Time              // when the event happened
+	Project string                 // project name; "" for events outside projects
+	Service string                 // service name; "" for project-wide events
+	Status  string                 // the event's status, such as failed, when it has one
+	Data    map[string]interface{} // the event's details, as webhooks receive them
+	Text    string                 // the default message for the event
+}
+
+// Failed reports whether the event is a failure: a crash, a log alert, or
+// a deploy, job, or cron run that failed
+func (m *Message) Failed() bool {
+	switch m.Type {
+	case events.ServiceCrashed, events.LogAlert:
+		return true
+	}
+	return m.Status == storage.JobFailed // deployments and cron runs fail with the same status
+}
+
+// newMessage describes an event, with the default text filled in
+func newMessage(e events.Event, project, service string) *Message {
+	m := &Message{Type: e.Type, Time: e.Time, Project: project, Service: service, Data: e.Data}
+	if m.Data == nil {
+		m.Data = map[string]interface{}{}
+	}
+	if status, ok := m.Data["status"].(string); ok {
+		m.Status = status
+	}
+	if m.Service == "" {
+		m.Service = m.str("service")
+	}
+	m.Text = m.defaultText()
+	return m
+}
+
+// Describe returns the default text of an event and whether it is a failure,
+// for listing events outside channels, such as the activity feed
+func Describe(e events.Event, project, service string) (text string, failed bool) {
+	m := newMessage(e, project, service)
+	return m.Text, m.Failed()
+}
+
+// str returns a detail formatted as text, or "" when it is missing
+func (m *Message) str(key string) string {
+	if v, ok := m.Data[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// defaultText is the message sent by channels without a template
+func (m *Message) defaultText() string {
+	var b strings.Builder
+	if m.Project != "" {
+		fmt.Fprintf(&b, "[%s] ", m.Project)
+	}
+	switch m.Type {
+	case events.DeployFinished:
+		fmt.Fprintf(&b, "Deploy of %s %s", m.Service, m.Status)
+		if commit := m.str("commit"); commit != "" {
+			fmt.Fprintf(&b, " at %.12s", commit)
+		}
+		// Events read back from the database carry their numbers as float64
+		switch ms := m.Data["duration_ms"].(type) {
+		case int64:
+			fmt.Fprintf(&b, " in %s", (time.Duration(ms) * time.Millisecond).Round(time.Second))
+		case float64:
+			fmt.Fprintf(&b, " in %s", (time.Duration(ms) * time.Millisecond).Round(time.Second))
+		}
+		if err := m.str("error"); err != "" {
+			fmt.Fprintf(&b, ": %s", err)
+		}
+	case events.ServiceCrashed:
+		fmt.Fprintf(&b, "%s crashed (was %s)", m.Service, m.str("previous_state"))
+	case events.ServiceStarted:
+		fmt.Fprintf(&b, "%s started", m.Service)
+	case events.ServiceStopped:
+		fmt.Fprintf(&b, "%s stopped", m.Service)
+	case events.LogAlert:
+		fmt.Fprintf(&b, "Log alert %s on %s: %s lines matched %s within %ss", m.str("alert"), m.Service, m.str("matches"), m.str("pattern"), m.str("interval"))
+		if sample := m.str("sample"); sample != "" {
+			fmt.Fprintf(&b, "\n%s", sample)
+		}
+	case events.CronFinished:
+		fmt.Fprintf(&b, "Cron job %s %s (exit code %s)", m.str("name"), m.Status, m.str("exit_code"))
+	case events.JobUpdated:
+		fmt.Fprintf(&b, "%s job #%s %s", m.str("kind"), m.str("job_id"), m.Status)
+		if m.Service != "" {
+			fmt.Fprintf(&b, " for %s", m.Service)
+		}
+		if err := m.str("error"); err != "" {
+			fmt.Fprintf(&b, ": %s", err)
+		}
+	case events.NginxDeployed:
+		fmt.Fprintf(&b, "nginx site for %s deployed", m.str("domain"))
+	case EventPing:
+		fmt.Fprintf(&b, "Test notification from Servio to %s", m.str("channel"))
+	default:
+		b.WriteString(m.Type)
+	}
+	return b.String()
+}
+
+// parseTemplate parses a channel's message template
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("message").Option("missingkey=zero").Parse(text)
+}
+
+// render returns the text a channel sends for a message: its template
+// executed with the message, or the default text
+func render(channel *storage.NotificationChannel, m *Message) (string, error) {
+	if channel.Template == "" {
+		return m.Text, nil
+	}
+	tmpl, err := parseTemplate(channel.Template)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, m); err != nil {
+		return "", err
+	}
+	// Details an event lacks print as "<no value>" even with missingkey=zero
+	text := strings.TrimSpace(strings.ReplaceAll(b.String(), "<no value>", ""))
+	if text == "" {
+		return m.Text, nil
+	}
+	return text, nil
+}
+
+// CheckTemplate parses a message template and executes it with a sample
+// failed deploy, so templates that cannot render are rejected when saved
+func CheckTemplate(text string) error {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return err
+	}
+	sample := newMessage(events.Event{Type: events.DeployFinished, Time: time.Now(), Data: map[string]interface{}{
+		"deployment_id": int64(1),
+		"service":       "web",
+		"status":        storage.DeploymentFailed,
+		"commit":        "0123456789abcdef",
+		"duration_ms":   int64(42000),
+		"error":         "exit status 1",
+	}}, "shop", "")
+	return tmpl.Execute(&strings.Builder{}, sample)
+}
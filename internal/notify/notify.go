@@ -0,0 +1,186 @@
+// Package notify sends messages about events from the events bus to chat
+// channels (Slack, Discord, Telegram) and generic webhooks. Each channel
+// picks the event types it routes, optionally for one project or failures
+// only, and may format messages with its own template. This package only
+// picks channels and formats each kind's payload; internal/delivery sends it.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"servio/internal/delivery"
+	"servio/internal/events"
+	"servio/internal/secrets"
+	"servio/internal/storage"
+)
+
+// EventPing is sent by Test to check that a channel is reachable
+const EventPing = "ping"
+
+// DefaultEvents are routed to channels created without a list: deploys,
+// crashes, and log alerts
+var DefaultEvents = []string{events.DeployFinished, events.ServiceCrashed, events.LogAlert}
+
+// telegramAPI is where Telegram bot requests go
+const telegramAPI = "https://api.telegram.org"
+
+// maxLength is the longest message each kind accepts, in characters
+var maxLength = map[string]int{
+	storage.NotifySlack:    40000,
+	storage.NotifyDiscord:  2000,
+	storage.NotifyTelegram: 4096,
+	storage.NotifyWebhook:  40000,
+}
+
+// Dispatcher routes events to matching channels and records each delivery.
+// Subscribe its Handle to an events.Bus and call Run to start delivering.
+type Dispatcher struct {
+	*delivery.Dispatcher
+	store  storage.Store
+	cipher *secrets.Cipher
+}
+
+// NewDispatcher creates a Dispatcher
+func NewDispatcher(store storage.Store, cipher *secrets.Cipher) *Dispatcher {
+	d := &Dispatcher{store: store, cipher: cipher}
+	d.Dispatcher = delivery.NewDispatcher("notification", "servio-notify", d.dispatch)
+	return d
+}
+
+// dispatch starts a delivery for every enabled channel routing the event
+func (d *Dispatcher) dispatch(ctx context.Context, e events.Event) {
+	channels, err := d.store.ListNotificationChannels(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list notification channels", "event", e.Type, "error", err)
+		return
+	}
+
+	var m *Message
+	for _, channel := range channels {
+		if !channel.Wants(e.Type, e.ProjectID) {
+			continue
+		}
+		if m == nil {
+			m = d.message(ctx, e)
+		}
+		if channel.FailuresOnly && !m.Failed() {
+			continue
+		}
+		out, err := d.record(ctx, channel, &storage.NotificationDelivery{}, m, e)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record notification", "channel_id", channel.ID, "event", e.Type, "error", err)
+			continue
+		}
+		if out != nil {
+			d.Go(ctx, out)
+		}
+	}
+}
+
+// message describes an event with the names of its project and service
+func (d *Dispatcher) message(ctx context.Context, e events.Event) *Message {
+	var project, service string
+	if e.ProjectID != 0 {
+		if p, err := d.store.GetProject(ctx, e.ProjectID); err == nil && p != nil {
+			project = p.Name
+		}
+	}
+	if e.ServiceID != 0 {
+		if s, err := d.store.GetService(ctx, e.ServiceID); err == nil && s != nil {
+			service = s.Name
+		}
+	}
+	return newMessage(e, project, service)
+}
+
+// Test sends a ping message to a channel with a single attempt and returns the recorded delivery
+func (d *Dispatcher) Test(ctx context.Context, channel *storage.NotificationChannel) (*storage.NotificationDelivery, error) {
+	e := events.Event{
+		Type: EventPing,
+		Time: time.Now(),
+		Data: map[string]interface{}{"channel_id": channel.ID, "channel": channel.Name},
+	}
+	nd := &storage.NotificationDelivery{}
+	out, err := d.record(ctx, channel, nd, newMessage(e, "", ""), e)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		d.Send(ctx, out, 1)
+	}
+	return nd, nil
+}
+
+// record renders a channel's message into nd, stores it as a pending
+// delivery, and returns the request to send, or nil when it already failed,
+// as when its template fails to execute
+func (d *Dispatcher) record(ctx context.Context, channel *storage.NotificationChannel, nd *storage.NotificationDelivery, m *Message, e events.Event) (*delivery.Delivery, error) {
+	nd.ChannelID, nd.Event, nd.Status = channel.ID, m.Type, storage.DeliveryPending
+	text, err := render(channel, m)
+	if err != nil {
+		nd.Message, nd.Status = m.Text, storage.DeliveryFailed
+		nd.Error = fmt.Sprintf("failed to render template: %v", err)
+	} else {
+		nd.Message = truncate(text, maxLength[channel.Kind])
+	}
+	if err := d.store.CreateNotificationDelivery(ctx, nd); err != nil {
+		return nil, err
+	}
+	if nd.Status != storage.DeliveryPending {
+		return nil, nil
+	}
+
+	out := &delivery.Delivery{
+		State: &nd.DeliveryState,
+		Save:  func(ctx context.Context) error { return d.store.UpdateNotificationDelivery(ctx, nd) },
+		Log:   []any{"channel_id", channel.ID, "delivery_id", nd.ID, "event", nd.Event},
+	}
+	secret, err := d.cipher.Decrypt(channel.Ciphertext)
+	if err != nil {
+		d.Fail(ctx, out, fmt.Errorf("failed to decrypt channel credentials: %w", err))
+		return nil, nil
+	}
+	if out.URL, out.Body, err = request(channel, secret, nd.Message, e); err != nil {
+		d.Fail(ctx, out, err)
+		return nil, nil
+	}
+	return out, nil
+}
+
+// request returns the URL a channel's message is posted to and the JSON body
+// each kind expects
+func request(channel *storage.NotificationChannel, secret, text string, e events.Event) (string, []byte, error) {
+	target := secret
+	var payload interface{}
+	switch channel.Kind {
+	case storage.NotifySlack:
+		payload = map[string]string{"text": text}
+	case storage.NotifyDiscord:
+		payload = map[string]string{"content": text}
+	case storage.NotifyTelegram:
+		target = telegramAPI + "/bot" + secret + "/sendMessage"
+		payload = map[string]interface{}{"chat_id": channel.ChatID, "text": text, "disable_web_page_preview": true}
+	case storage.NotifyWebhook:
+		payload = map[string]interface{}{"text": text, "event": e}
+	default:
+		return "", nil, fmt.Errorf("unknown channel kind %q", channel.Kind)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	return target, body, nil
+}
+
+// truncate shortens text to at most n characters, marking the cut
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if n <= 0 || len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-3]) + "..."
+}
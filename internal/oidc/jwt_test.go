@@ -0,0 +1,283 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OIDC provider serving discovery and a key set that tests
+// can rotate
+type testIssuer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []jwk
+	fetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	iss := &testIssuer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(endpoints{
+			Issuer: iss.URL,
+			Auth:   iss.URL + "/auth",
+			Token:  iss.URL + "/token",
+			JWKS:   iss.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		defer iss.mu.Unlock()
+		iss.fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": iss.keys})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// publish adds a public key to the key set
+func (iss *testIssuer) publish(kid string, key crypto.PublicKey) {
+	j := jwk{Kid: kid, Use: "sig"}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		j.Kty = "EC"
+		j.Crv = key.Curve.Params().Name
+		j.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+		j.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.keys = append(iss.keys, j)
+}
+
+func (iss *testIssuer) fetched() int {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	return iss.fetches
+}
+
+// provider returns a discovered Provider for the issuer with client ID "servio"
+func (iss *testIssuer) provider(t *testing.T) (*Provider, *endpoints) {
+	t.Helper()
+	p, err := New(Config{Issuer: iss.URL, ClientID: "servio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := p.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, ep
+}
+
+func rsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func ecKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// compact joins a header, claims, and signature into a token
+func compact(t *testing.T, header, claims map[string]interface{}, sig []byte) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return segment(header) + "." + segment(claims) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// signJWT signs claims as the header's alg does, with r||s signatures for
+// ECDSA keys
+func signJWT(t *testing.T, header, claims map[string]interface{}, key crypto.Signer) string {
+	t.Helper()
+	unsigned := compact(t, header, claims, nil)
+	input := unsigned[:len(unsigned)-1]
+	hash, ok := algorithms[header["alg"].(string)]
+	if !ok {
+		hash = crypto.SHA256
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	iss := newTestIssuer(t)
+	rsaSigner, otherRSA := rsaKey(t), rsaKey(t)
+	ec256, ec384 := ecKey(t, elliptic.P256()), ecKey(t, elliptic.P384())
+	iss.publish("rsa", &rsaSigner.PublicKey)
+	iss.publish("ec256", &ec256.PublicKey)
+	iss.publish("ec384", &ec384.PublicKey)
+	p, _ := iss.provider(t)
+	claims := map[string]interface{}{"sub": "u1"}
+
+	header := func(alg, kid string) map[string]interface{} {
+		return map[string]interface{}{"alg": alg, "kid": kid, "typ": "JWT"}
+	}
+	// HS256 keyed with the RSA modulus: accepted by verifiers that let the
+	// token pick the algorithm for a public key
+	hmacToken := func() string {
+		unsigned := compact(t, header("HS256", "rsa"), claims, nil)
+		mac := hmac.New(sha256.New, rsaSigner.PublicKey.N.Bytes())
+		mac.Write([]byte(unsigned[:len(unsigned)-1]))
+		return unsigned[:len(unsigned)-1] + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	derToken := func() string {
+		unsigned := compact(t, header("ES256", "ec256"), claims, nil)
+		digest := sha256.Sum256([]byte(unsigned[:len(unsigned)-1]))
+		sig, err := ecdsa.SignASN1(rand.Reader, ec256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return unsigned[:len(unsigned)-1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	tampered := func() string {
+		token := signJWT(t, header("RS256", "rsa"), claims, rsaSigner)
+		parts := strings.Split(token, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+		return strings.Join(parts, ".")
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string // "" when the token is valid
+	}{
+		{"RS256", signJWT(t, header("RS256", "rsa"), claims, rsaSigner), ""},
+		{"RS512", signJWT(t, header("RS512", "rsa"), claims, rsaSigner), ""},
+		{"ES256", signJWT(t, header("ES256", "ec256"), claims, ec256), ""},
+		{"ES384", signJWT(t, header("ES384", "ec384"), claims, ec384), ""},
+		{"signed by another key", signJWT(t, header("RS256", "rsa"), claims, otherRSA), "invalid ID token signature"},
+		{"claims changed after signing", tampered(), "invalid ID token signature"},
+		{"alg none", compact(t, header("none", "rsa"), claims, nil), "unsupported ID token algorithm"},
+		{"HMAC with the public key", hmacToken(), "unsupported ID token algorithm"},
+		{"ES256 header on an RSA key", signJWT(t, header("ES256", "rsa"), claims, rsaSigner), "invalid ID token signature"},
+		{"RS256 header on an EC key", signJWT(t, header("RS256", "ec256"), claims, ec256), "invalid ID token signature"},
+		{"P-384 signature for a P-256 key", signJWT(t, header("ES384", "ec256"), claims, ec384), "invalid ID token signature"},
+		{"ASN.1 ECDSA signature", derToken(), "invalid ID token signature"},
+		{"no kid among several keys", signJWT(t, map[string]interface{}{"alg": "RS256"}, claims, rsaSigner), "no signing key"},
+		{"two segments", "e30.e30", "malformed ID token"},
+		{"header not JSON", "bm90IGpzb24.e30.c2ln", "malformed ID token header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.verifyJWT(context.Background(), tt.token)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyJWT() error = %v", err)
+				}
+				if got["sub"] != "u1" {
+					t.Errorf("claims = %v, want sub u1", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifyJWT() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	iss := newTestIssuer(t)
+	first, second := rsaKey(t), ecKey(t, elliptic.P256())
+	iss.publish("first", &first.PublicKey)
+	p, _ := iss.provider(t)
+	claims := map[string]interface{}{"sub": "u1"}
+
+	// The only key is used when the token names none
+	if _, err := p.verifyJWT(ctx, signJWT(t, map[string]interface{}{"alg": "RS256"}, claims, first)); err != nil {
+		t.Fatalf("token without kid: %v", err)
+	}
+	if n := iss.fetched(); n != 1 {
+		t.Fatalf("key set fetched %d times, want 1", n)
+	}
+
+	// The provider rotates; a token with the new kid shortly after the last
+	// fetch is refused without asking again
+	iss.publish("second", &second.PublicKey)
+	rotated := signJWT(t, map[string]interface{}{"alg": "ES256", "kid": "second"}, claims, second)
+	if _, err := p.verifyJWT(ctx, rotated); err == nil || !strings.Contains(err.Error(), "no signing key") {
+		t.Fatalf("unknown kid within minRefetch: error = %v", err)
+	}
+	if n := iss.fetched(); n != 1 {
+		t.Fatalf("key set fetched %d times within minRefetch, want 1", n)
+	}
+
+	// Later, the unknown kid refetches the set and finds the new key
+	p.keys.fetched = time.Now().Add(-2 * minRefetch)
+	if _, err := p.verifyJWT(ctx, rotated); err != nil {
+		t.Fatalf("rotated key after minRefetch: %v", err)
+	}
+	if n := iss.fetched(); n != 2 {
+		t.Fatalf("key set fetched %d times, want 2", n)
+	}
+	if _, err := p.verifyJWT(ctx, signJWT(t, map[string]interface{}{"alg": "RS256", "kid": "first"}, claims, first)); err != nil {
+		t.Errorf("key kept across the refetch: %v", err)
+	}
+
+	// A kid the provider never published is refused, still without a fetch
+	if _, err := p.verifyJWT(ctx, signJWT(t, map[string]interface{}{"alg": "RS256", "kid": "forged"}, claims, rsaKey(t))); err == nil {
+		t.Error("token with an unpublished kid accepted")
+	}
+	if n := iss.fetched(); n != 2 {
+		t.Errorf("key set fetched %d times, want 2", n)
+	}
+}
@@ -0,0 +1,408 @@
+// Package oidc signs users in through an OpenID Connect provider (Google,
+// Keycloak, Authentik, ...) with the authorization code flow and PKCE, or
+// through GitHub's OAuth apps, which have no ID tokens: the user and their
+// organizations and teams are read from GitHub's API instead.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitHub is the issuer that selects GitHub's OAuth endpoints
+const GitHub = "github"
+
+// requestTimeout bounds each call to the provider
+const requestTimeout = 10 * time.Second
+
+// ErrRejected is wrapped by errors about a response that must not be trusted,
+// such as an ID token with a bad signature or the wrong nonce
+var ErrRejected = errors.New("sign-in rejected")
+
+// Config describes the provider and how Servio is registered with it
+type Config struct {
+	Issuer       string // e.g. https://accounts.google.com, or "github"
+	ClientID     string
+	ClientSecret string
+	GroupsClaim  string // ID token or userinfo claim listing the user's groups; default "groups"
+}
+
+// Identity is a signed-in user
+type Identity struct {
+	Subject string   // the provider's stable ID for the user
+	User    string   // preferred_username, else email, else subject; the GitHub login
+	Email   string   // only when the provider verified it
+	Groups  []string // groups, or GitHub organizations and org/team slugs
+}
+
+// Provider talks to one identity provider. Its endpoints are discovered on
+// first use and rediscovered after a failure, so Servio starts while the
+// provider is down.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu        sync.Mutex
+	endpoints *endpoints
+	keys      *keySet
+}
+
+// endpoints are what discovery finds
+type endpoints struct {
+	Issuer   string `json:"issuer"`
+	Auth     string `json:"authorization_endpoint"`
+	Token    string `json:"token_endpoint"`
+	UserInfo string `json:"userinfo_endpoint"`
+	JWKS     string `json:"jwks_uri"`
+}
+
+// githubEndpoints are GitHub's OAuth app endpoints; UserInfo is its API
+var githubEndpoints = &endpoints{
+	Issuer:   GitHub,
+	Auth:     "https://github.com/login/oauth/authorize",
+	Token:    "https://github.com/login/oauth/access_token",
+	UserInfo: "https://api.github.com",
+}
+
+// New creates a Provider without contacting it
+func New(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("an issuer and a client ID are required")
+	}
+	if cfg.Issuer == "https://github.com" {
+		cfg.Issuer = GitHub
+	}
+	if cfg.Issuer != GitHub {
+		u, err := url.Parse(cfg.Issuer)
+		if err != nil || u.Scheme != "https" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+			return nil, fmt.Errorf("invalid issuer %q: must be an https URL or %q", cfg.Issuer, GitHub)
+		}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	p := &Provider{cfg: cfg, client: &http.Client{Timeout: requestTimeout}}
+	if cfg.Issuer == GitHub {
+		p.endpoints = githubEndpoints
+	}
+	return p, nil
+}
+
+// Name describes the provider for the login page, e.g. accounts.google.com
+func (p *Provider) Name() string {
+	if p.cfg.Issuer == GitHub {
+		return "GitHub"
+	}
+	u, _ := url.Parse(p.cfg.Issuer)
+	return u.Host
+}
+
+// discover fetches the provider's configuration once
+func (p *Provider) discover(ctx context.Context) (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	var ep endpoints
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, "", &ep); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(ep.Issuer, "/") != strings.TrimSuffix(p.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC discovery failed: the provider calls itself %q, not %q", ep.Issuer, p.cfg.Issuer)
+	}
+	if ep.Auth == "" || ep.Token == "" || ep.JWKS == "" {
+		return nil, errors.New("OIDC discovery failed: the provider lists no authorization, token, or key endpoint")
+	}
+	p.endpoints = &ep
+	p.keys = &keySet{url: ep.JWKS}
+	return p.endpoints, nil
+}
+
+// Login is what the callback needs to finish a sign-in; keep it with the
+// browser (Servio seals it in a cookie) until the provider redirects back
+type Login struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+}
+
+// NewLogin creates the random values of one sign-in
+func NewLogin() (*Login, error) {
+	values := make([]string, 3)
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &Login{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthURL returns where to send the browser to sign in. With fresh, the
+// provider is asked to check the user's credentials again even when they
+// have a session with it.
+func (p *Provider) AuthURL(ctx context.Context, login *Login, redirectURL string, fresh bool) (string, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"state":                 {login.State},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.cfg.Issuer == GitHub {
+		q.Set("scope", "read:user user:email read:org")
+		if fresh {
+			q.Set("prompt", "select_account")
+		}
+	} else {
+		q.Set("scope", "openid profile email")
+		q.Set("nonce", login.Nonce)
+		if fresh {
+			q.Set("prompt", "login")
+			q.Set("max_age", "0")
+		}
+	}
+	sep := "?"
+	if strings.Contains(ep.Auth, "?") {
+		sep = "&"
+	}
+	return ep.Auth + sep + q.Encode(), nil
+}
+
+// tokenResponse is the token endpoint's answer
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Exchange trades the code the provider redirected back with for the user's
+// identity, verifying the ID token against the provider's keys and the
+// login's nonce
+func (p *Provider) Exchange(ctx context.Context, login *Login, code, redirectURL string) (*Identity, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {login.Verifier},
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrRejected, token.Error, token.Description)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" && token.IDToken == "" {
+		return nil, fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	if p.cfg.Issuer == GitHub {
+		return p.githubIdentity(ctx, token.AccessToken)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: the provider returned no ID token", ErrRejected)
+	}
+	claims, err := p.verify(ctx, ep, token.IDToken, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims[p.cfg.GroupsClaim]; !ok && ep.UserInfo != "" && token.AccessToken != "" {
+		// Many providers only list groups at the userinfo endpoint
+		var info map[string]interface{}
+		if err := p.getJSON(ctx, ep.UserInfo, token.AccessToken, &info); err == nil && info["sub"] == claims["sub"] {
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+	return p.identity(claims), nil
+}
+
+// verify checks an ID token's signature, issuer, audience, expiry, and nonce
+func (p *Provider) verify(ctx context.Context, ep *endpoints, idToken, nonce string) (map[string]interface{}, error) {
+	claims, err := p.verifyJWT(ctx, idToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	now := time.Now()
+	const skew = time.Minute
+	switch {
+	case claims["iss"] != ep.Issuer:
+		return nil, fmt.Errorf("%w: ID token issued by %v", ErrRejected, claims["iss"])
+	case !audienceIncludes(claims["aud"], p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: ID token is for another client", ErrRejected)
+	case claims["azp"] != nil && claims["azp"] != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: ID token was issued to another client", ErrRejected)
+	case claims["nonce"] != nonce:
+		return nil, fmt.Errorf("%w: ID token nonce does not match the sign-in", ErrRejected)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(skew)) {
+		return nil, fmt.Errorf("%w: ID token has expired", ErrRejected)
+	}
+	if claims["sub"] == nil || claims["sub"] == "" {
+		return nil, fmt.Errorf("%w: ID token names no subject", ErrRejected)
+	}
+	return claims, nil
+}
+
+func audienceIncludes(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// identity reads the user from verified claims
+func (p *Provider) identity(claims map[string]interface{}) *Identity {
+	id := &Identity{}
+	id.Subject, _ = claims["sub"].(string)
+	if verified, _ := claims["email_verified"].(bool); verified {
+		id.Email, _ = claims["email"].(string)
+	}
+	id.User, _ = claims["preferred_username"].(string)
+	if id.User == "" {
+		id.User = id.Email
+	}
+	if id.User == "" {
+		id.User = id.Subject
+	}
+	switch groups := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				id.Groups = append(id.Groups, strings.TrimPrefix(name, "/")) // Keycloak's group paths
+			}
+		}
+	case string:
+		id.Groups = strings.Fields(groups)
+	}
+	return id
+}
+
+// githubIdentity reads the user, their verified primary email, their
+// organizations, and their teams as org/team from GitHub's API
+func (p *Provider) githubIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	api := githubEndpoints.UserInfo
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := p.getJSON(ctx, api+"/user", accessToken, &user); err != nil {
+		return nil, fmt.Errorf("failed to read the GitHub user: %w", err)
+	}
+	if user.Login == "" {
+		return nil, fmt.Errorf("%w: GitHub returned no user", ErrRejected)
+	}
+	id := &Identity{Subject: fmt.Sprint(user.ID), User: user.Login}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, api+"/user/emails", accessToken, &emails); err == nil {
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				id.Email = e.Email
+			}
+		}
+	}
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := p.getJSON(ctx, api+"/user/orgs", accessToken, &orgs); err != nil {
+		return nil, fmt.Errorf("failed to read GitHub organizations: %w", err)
+	}
+	for _, org := range orgs {
+		id.Groups = append(id.Groups, org.Login)
+	}
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := p.getJSON(ctx, api+"/user/teams", accessToken, &teams); err != nil {
+		return nil, fmt.Errorf("failed to read GitHub teams: %w", err)
+	}
+	for _, team := range teams {
+		id.Groups = append(id.Groups, team.Organization.Login+"/"+team.Slug)
+	}
+	return id, nil
+}
+
+// getJSON fetches a JSON document, with a bearer token when one is given
+func (p *Provider) getJSON(ctx context.Context, target, bearer string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
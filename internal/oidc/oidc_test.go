@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	key, otherKey := rsaKey(t), rsaKey(t)
+	iss.publish("k1", &key.PublicKey)
+	p, ep := iss.provider(t)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		claims  func(c map[string]interface{}) // changes valid claims
+		forged  bool                           // signed with a key the provider never published
+		wantErr string                         // "" when the token is accepted
+	}{
+		{name: "valid"},
+		{name: "audience array", claims: func(c map[string]interface{}) { c["aud"] = []interface{}{"other", "servio"} }},
+		{name: "audience array without us", claims: func(c map[string]interface{}) { c["aud"] = []interface{}{"other", "another"} }, wantErr: "another client"},
+		{name: "other audience", claims: func(c map[string]interface{}) { c["aud"] = "other" }, wantErr: "another client"},
+		{name: "no audience", claims: func(c map[string]interface{}) { delete(c, "aud") }, wantErr: "another client"},
+		{name: "azp us", claims: func(c map[string]interface{}) { c["aud"], c["azp"] = []interface{}{"servio", "api"}, "servio" }},
+		{name: "azp other", claims: func(c map[string]interface{}) { c["aud"], c["azp"] = []interface{}{"servio", "api"}, "api" }, wantErr: "issued to another client"},
+		{name: "other issuer", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example" }, wantErr: "issued by"},
+		{name: "issuer with trailing slash", claims: func(c map[string]interface{}) { c["iss"] = iss.URL + "/" }, wantErr: "issued by"},
+		{name: "nonce mismatch", claims: func(c map[string]interface{}) { c["nonce"] = "replayed" }, wantErr: "nonce"},
+		{name: "no nonce", claims: func(c map[string]interface{}) { delete(c, "nonce") }, wantErr: "nonce"},
+		{name: "expired", claims: func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, wantErr: "expired"},
+		{name: "expired within skew", claims: func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }},
+		{name: "no expiry", claims: func(c map[string]interface{}) { delete(c, "exp") }, wantErr: "expired"},
+		{name: "expiry as string", claims: func(c map[string]interface{}) { c["exp"] = "4102444800" }, wantErr: "expired"},
+		{name: "no subject", claims: func(c map[string]interface{}) { c["sub"] = "" }, wantErr: "no subject"},
+		{name: "forged signature", forged: true, wantErr: "invalid ID token signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]interface{}{
+				"iss":   iss.URL,
+				"aud":   "servio",
+				"sub":   "u1",
+				"nonce": "n1",
+				"iat":   now.Unix(),
+				"exp":   now.Add(5 * time.Minute).Unix(),
+			}
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			signer := key
+			if tt.forged {
+				signer = otherKey
+			}
+			token := signJWT(t, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims, signer)
+
+			got, err := p.verify(context.Background(), ep, token, "n1")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verify() error = %v", err)
+				}
+				if got["sub"] != "u1" {
+					t.Errorf("claims = %v, want sub u1", got)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify() error = %v, want ErrRejected with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+// Package openapi builds an OpenAPI 3 document from a table of routes.
+// Request and response schemas are generated from the Go types the handlers
+// actually encode and decode, so the spec follows model changes automatically.
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is the OpenAPI version emitted by Build
+const Version = "3.0.3"
+
+// Document is the root of an OpenAPI document
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Server is a base URL the paths are relative to
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem maps lowercase HTTP methods to operations
+type PathItem map[string]*Operation
+
+// Operation describes a single method on a path
+type Operation struct {
+	Summary     string              `json:"summary"`
+	OperationID string              `json:"operationId"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes a JSON request body
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response for a status code
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType wraps the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds reusable schemas and security schemes
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how clients authenticate
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// Param documents a path or query parameter of a Route.
+// Path parameters that are not documented explicitly default to integers.
+type Param struct {
+	Name        string
+	In          string // "query" (default) or "path"
+	Type        string // "string" (default), "integer", "boolean"
+	Description string
+	Required    bool
+}
+
+// Route documents one method + path of the API
+type Route struct {
+	Method   string
+	Path     string // e.g. /api/services/{id}/logs
+	Summary  string
+	Tag      string
+	Params   []Param
+	Request  interface{} // zero value of the decoded body type, nil for none
+	Response interface{} // zero value of the encoded body type, nil for none
+	Status   int         // success status, http.StatusOK when zero
+	Stream   string      // content type for non-JSON responses such as text/event-stream
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([a-zA-Z_]+)\}`)
+
+// Build generates the document for the given routes. errorBody is the type
+// every operation returns for non-success statuses.
+func Build(info Info, routes []Route, errorBody interface{}) *Document {
+	gen := newGenerator()
+	errorSchema := gen.schemaFor(typeOf(errorBody))
+
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas:         gen.components,
+			SecuritySchemes: map[string]SecurityScheme{"basicAuth": {Type: "http", Scheme: "basic"}},
+		},
+		Security: []map[string][]string{{"basicAuth": {}}},
+	}
+
+	for _, rt := range routes {
+		op := &Operation{
+			Summary:     rt.Summary,
+			OperationID: operationID(rt.Method, rt.Path),
+			Responses:   map[string]Response{},
+		}
+		if rt.Tag != "" {
+			op.Tags = []string{rt.Tag}
+		}
+		op.Parameters = parameters(rt)
+
+		if rt.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: gen.schemaFor(typeOf(rt.Request))}},
+			}
+		}
+
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := Response{Description: http.StatusText(status)}
+		switch {
+		case rt.Stream != "":
+			success.Content = map[string]MediaType{rt.Stream: {Schema: &Schema{Type: "string"}}}
+		case rt.Response != nil:
+			success.Content = map[string]MediaType{"application/json": {Schema: gen.schemaFor(typeOf(rt.Response))}}
+		}
+		op.Responses[strconv.Itoa(status)] = success
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+		}
+
+		item, ok := doc.Paths[rt.Path]
+		if !ok {
+			item = PathItem{}
+			doc.Paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	return doc
+}
+
+func parameters(rt Route) []Parameter {
+	documented := map[string]Param{}
+	for _, p := range rt.Params {
+		if p.In == "path" {
+			documented[p.Name] = p
+		}
+	}
+
+	var params []Parameter
+	for _, m := range pathParamPattern.FindAllStringSubmatch(rt.Path, -1) {
+		p, ok := documented[m[1]]
+		if !ok {
+			p = Param{Name: m[1], Type: "integer"}
+		}
+		params = append(params, Parameter{
+			Name: p.Name, In: "path", Required: true, Description: p.Description,
+			Schema: &Schema{Type: paramType(p.Type)},
+		})
+	}
+	for _, p := range rt.Params {
+		if p.In == "path" {
+			continue
+		}
+		params = append(params, Parameter{
+			Name: p.Name, In: "query", Required: p.Required, Description: p.Description,
+			Schema: &Schema{Type: paramType(p.Type)},
+		})
+	}
+	return params
+}
+
+func paramType(t string) string {
+	if t == "" {
+		return "string"
+	}
+	return t
+}
+
+// operationID derives a stable identifier such as getApiServicesIdLogs
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '{' || r == '}' || r == '_' || r == '-' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
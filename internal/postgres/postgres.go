@@ -0,0 +1,247 @@
+// Package postgres administers the databases and roles of postgres-blueprint
+// services with psql and pg_dump, run as the postgres user over its local
+// socket. SQL is sent on stdin, so passwords never appear in process lists or
+// the audit trail.
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// ServiceType is the blueprint type of services this package manages
+const ServiceType = "postgres"
+
+// defaultPort is the port postgres listens on unless the service's config sets db_port
+const defaultPort = 5432
+
+// fieldSeparator splits psql's unaligned output; it cannot occur in names
+const fieldSeparator = "\x1f"
+
+var (
+	// ErrCommandFailed is wrapped when psql or pg_dump exits non-zero
+	ErrCommandFailed = errors.New("postgres command failed")
+	// ErrInvalidName is wrapped for database and role names that are not plain identifiers
+	ErrInvalidName = errors.New("invalid name")
+	// ErrNotPostgres is wrapped when a service is not a postgres service
+	ErrNotPostgres = errors.New("not a postgres service")
+)
+
+// identifier is what database and role names must look like; quoting is
+// still applied, but plain names keep psql commands readable
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// Database is a database of the server with its size on disk
+type Database struct {
+	Name      string `json:"name"`
+	Owner     string `json:"owner"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// Role is a role of the server
+type Role struct {
+	Name      string `json:"name"`
+	Login     bool   `json:"login"`
+	Superuser bool   `json:"superuser"`
+	CreateDB  bool   `json:"create_db"`
+}
+
+// Server is the postgres instance of one service
+type Server struct {
+	Port int
+}
+
+// ForService returns the postgres instance a service runs, on the port in
+// its config's db_port
+func ForService(service *storage.Service) *Server {
+	port := defaultPort
+	var config struct {
+		Port int `json:"db_port"`
+	}
+	if json.Unmarshal([]byte(service.Config), &config) == nil && config.Port > 0 {
+		port = config.Port
+	}
+	return &Server{Port: port}
+}
+
+// CheckName returns an ErrInvalidName unless name is a plain identifier
+func CheckName(name string) error {
+	if !identifier.MatchString(name) {
+		return fmt.Errorf("%w: %q must start with a letter or underscore and hold only letters, digits, and underscores (at most 63)", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// ListDatabases returns the server's databases, except templates, by name
+func (s *Server) ListDatabases(ctx context.Context) ([]Database, error) {
+	rows, err := s.query(ctx, `SELECT datname, pg_get_userbyid(datdba), pg_database_size(datname)
+		FROM pg_database WHERE NOT datistemplate ORDER BY datname`)
+	if err != nil {
+		return nil, err
+	}
+	databases := make([]Database, 0, len(rows))
+	for _, row := range rows {
+		size, _ := strconv.ParseInt(row[2], 10, 64)
+		databases = append(databases, Database{Name: row[0], Owner: row[1], SizeBytes: size})
+	}
+	return databases, nil
+}
+
+// ListRoles returns the server's roles, except the pg_ built-ins, by name
+func (s *Server) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := s.query(ctx, `SELECT rolname, rolcanlogin, rolsuper, rolcreatedb
+		FROM pg_roles WHERE rolname NOT LIKE 'pg\_%' ORDER BY rolname`)
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]Role, 0, len(rows))
+	for _, row := range rows {
+		roles = append(roles, Role{Name: row[0], Login: row[1] == "t", Superuser: row[2] == "t", CreateDB: row[3] == "t"})
+	}
+	return roles, nil
+}
+
+// CreateDatabase creates a database owned by owner, or by postgres when owner is empty
+func (s *Server) CreateDatabase(ctx context.Context, name, owner string) error {
+	sql := "CREATE DATABASE " + quoteIdent(name)
+	if owner != "" {
+		sql += " OWNER " + quoteIdent(owner)
+	}
+	return s.exec(ctx, "create-database", sql, sql)
+}
+
+// CreateRole creates a login role with a password
+func (s *Server) CreateRole(ctx context.Context, name, password string, createDB bool) error {
+	options := " LOGIN"
+	if createDB {
+		options += " CREATEDB"
+	}
+	sql := "CREATE ROLE " + quoteIdent(name) + options + " PASSWORD "
+	return s.exec(ctx, "create-role", sql+quoteLiteral(password), sql+"'***'")
+}
+
+// SetPassword changes a role's password
+func (s *Server) SetPassword(ctx context.Context, role, password string) error {
+	sql := "ALTER ROLE " + quoteIdent(role) + " PASSWORD "
+	return s.exec(ctx, "reset-password", sql+quoteLiteral(password), sql+"'***'")
+}
+
+// Dump writes a custom-format pg_dump of a database, for pg_restore, to a
+// new file readable only by Servio, and returns its size. A failed dump
+// leaves no file behind.
+func (s *Server) Dump(ctx context.Context, database, path string) (int64, error) {
+	cmd := exec.CommandContext(ctx, "sudo", "-u", "postgres", "pg_dump", "-p", strconv.Itoa(s.Port), "-Fc", "-d", database)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(filepath.Dir(path), 0700)
+		plan.Run(append(cmd.Args, ">", path))
+		return 0, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer f.Close()
+
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = f, &stderr
+	start := time.Now()
+	err = cmd.Run()
+	audit.Log(ctx, audit.CategoryDatabase, "dump", strings.Join(cmd.Args, " ")+" > "+path, stderr.String(), err, time.Since(start))
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return 0, commandError("pg_dump "+database, stderr.String(), err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// GeneratePassword returns a random URL-safe password of 24 characters
+func GeneratePassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// psql builds a psql command reading SQL from stdin and stopping at the first error
+func (s *Server) psql(ctx context.Context, sql string, args ...string) *exec.Cmd {
+	args = append([]string{"-u", "postgres", "psql", "-X", "-q", "-v", "ON_ERROR_STOP=1", "-p", strconv.Itoa(s.Port), "-d", "postgres"}, args...)
+	cmd := exec.CommandContext(ctx, "sudo", args...)
+	cmd.Stdin = strings.NewReader(sql)
+	return cmd
+}
+
+// query runs a read-only statement and returns its rows. Reads are not audited.
+func (s *Server) query(ctx context.Context, sql string) ([][]string, error) {
+	cmd := s.psql(ctx, sql, "-A", "-t", "-F", fieldSeparator)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError("psql", stderr.String(), err)
+	}
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, fieldSeparator))
+		}
+	}
+	return rows, nil
+}
+
+// exec runs a statement that changes the server, auditing it as display
+func (s *Server) exec(ctx context.Context, action, sql, display string) error {
+	cmd := s.psql(ctx, sql)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(append(cmd.Args, "<<<", display))
+		return nil
+	}
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	audit.Log(ctx, audit.CategoryDatabase, action, strings.Join(cmd.Args, " ")+" <<< "+display, string(output), err, time.Since(start))
+	if err != nil {
+		return commandError("psql", string(output), err)
+	}
+	return nil
+}
+
+// commandError wraps a failed command's error with what it printed
+func commandError(command, output string, err error) error {
+	if output = strings.TrimSpace(output); output != "" {
+		return fmt.Errorf("%w: %s: %s - %w", ErrCommandFailed, command, output, err)
+	}
+	return fmt.Errorf("%w: %s: %w", ErrCommandFailed, command, err)
+}
+
+// quoteIdent quotes a database or role name
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes a string constant
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,382 @@
+// Package redis inspects and tunes redis-blueprint services over their TCP
+// port on this server, speaking the Redis protocol directly.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// ServiceType is the blueprint type of services this package manages
+const ServiceType = "redis"
+
+const (
+	// defaultPort is the port redis listens on unless the service sets one
+	defaultPort = 6379
+	// timeout bounds one conversation with the server when ctx has no deadline
+	timeout = 5 * time.Second
+	// maxBulk bounds a single reply string, which INFO stays far below
+	maxBulk = 16 << 20
+)
+
+var (
+	// ErrNotRedis is wrapped when a service is not a redis service
+	ErrNotRedis = errors.New("not a redis service")
+	// ErrCommandFailed is wrapped when redis cannot be reached or answers with an error
+	ErrCommandFailed = errors.New("redis command failed")
+	// ErrInvalidConfig is wrapped for maxmemory and policy values redis would reject
+	ErrInvalidConfig = errors.New("invalid redis setting")
+)
+
+// Policies are the maxmemory-policy values redis accepts
+var Policies = []string{
+	"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+	"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+}
+
+// memorySize is a maxmemory value: bytes with an optional unit, 0 for no limit
+var memorySize = regexp.MustCompile(`(?i)^[0-9]+(b|k|kb|m|mb|g|gb)?$`)
+
+// Info is the server's INFO output by section, with the memory figures parsed
+type Info struct {
+	Memory   Memory                       `json:"memory"`
+	Sections map[string]map[string]string `json:"sections"` // e.g. "server" -> "redis_version" -> "7.2.4"
+}
+
+// Memory is how much memory the server uses and how it is capped
+type Memory struct {
+	UsedBytes          int64   `json:"used_bytes"`
+	PeakBytes          int64   `json:"peak_bytes"`
+	MaxBytes           int64   `json:"max_bytes"` // 0 for no limit
+	Policy             string  `json:"policy"`
+	FragmentationRatio float64 `json:"fragmentation_ratio"`
+}
+
+// Keyspace summarizes one logical database
+type Keyspace struct {
+	DB      int   `json:"db"`
+	Keys    int64 `json:"keys"`
+	Expires int64 `json:"expires"` // keys with a TTL
+	AvgTTL  int64 `json:"avg_ttl_ms"`
+}
+
+// Server is the redis instance of one service
+type Server struct {
+	Port     int
+	Password string // sent with AUTH when set
+	DB       int    // selected on connecting
+}
+
+// ForService returns the redis instance a service runs, on its port or 6379
+func ForService(service *storage.Service) *Server {
+	port := defaultPort
+	if service.Port > 0 {
+		port = service.Port
+	}
+	return &Server{Port: port}
+}
+
+// ForURL returns the redis instance a redis:// URL names, such as a worker's
+// broker. Only instances on this server can be reached.
+func ForURL(rawURL string) (*Server, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" {
+		return nil, fmt.Errorf("%w: %q is not a redis:// URL", ErrInvalidConfig, rawURL)
+	}
+	if host := u.Hostname(); host != "127.0.0.1" && host != "localhost" && host != "::1" {
+		return nil, fmt.Errorf("%w: redis at %s is not on this server", ErrInvalidConfig, host)
+	}
+	s := &Server{Port: defaultPort}
+	if port := u.Port(); port != "" {
+		if s.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("%w: bad port %q", ErrInvalidConfig, port)
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("%w: bad database %q", ErrInvalidConfig, db)
+		}
+	}
+	if u.User != nil {
+		s.Password, _ = u.User.Password()
+		if s.Password == "" {
+			s.Password = u.User.Username()
+		}
+	}
+	return s, nil
+}
+
+// CheckMemoryPolicy returns an ErrInvalidConfig unless maxmemory and policy are values redis accepts
+func CheckMemoryPolicy(maxmemory, policy string) error {
+	if !memorySize.MatchString(maxmemory) {
+		return fmt.Errorf("%w: maxmemory %q must be a size such as 0, 268435456, 256mb, or 2gb", ErrInvalidConfig, maxmemory)
+	}
+	if !slices.Contains(Policies, policy) {
+		return fmt.Errorf("%w: maxmemory-policy %q must be one of %s", ErrInvalidConfig, policy, strings.Join(Policies, ", "))
+	}
+	return nil
+}
+
+// Info returns the server's INFO
+func (s *Server) Info(ctx context.Context) (*Info, error) {
+	sections, err := s.info(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	memory := sections["memory"]
+	info := &Info{Sections: sections, Memory: Memory{Policy: memory["maxmemory_policy"]}}
+	info.Memory.UsedBytes, _ = strconv.ParseInt(memory["used_memory"], 10, 64)
+	info.Memory.PeakBytes, _ = strconv.ParseInt(memory["used_memory_peak"], 10, 64)
+	info.Memory.MaxBytes, _ = strconv.ParseInt(memory["maxmemory"], 10, 64)
+	info.Memory.FragmentationRatio, _ = strconv.ParseFloat(memory["mem_fragmentation_ratio"], 64)
+	return info, nil
+}
+
+// Keyspace returns the databases that hold keys, by number
+func (s *Server) Keyspace(ctx context.Context) ([]Keyspace, error) {
+	sections, err := s.info(ctx, "keyspace")
+	if err != nil {
+		return nil, err
+	}
+	keyspace := []Keyspace{}
+	for name, value := range sections["keyspace"] {
+		db, err := strconv.Atoi(strings.TrimPrefix(name, "db"))
+		if err != nil || !strings.HasPrefix(name, "db") {
+			continue
+		}
+		ks := Keyspace{DB: db}
+		for _, field := range strings.Split(value, ",") {
+			k, v, _ := strings.Cut(field, "=")
+			n, _ := strconv.ParseInt(v, 10, 64)
+			switch k {
+			case "keys":
+				ks.Keys = n
+			case "expires":
+				ks.Expires = n
+			case "avg_ttl":
+				ks.AvgTTL = n
+			}
+		}
+		keyspace = append(keyspace, ks)
+	}
+	slices.SortFunc(keyspace, func(a, b Keyspace) int { return a.DB - b.DB })
+	return keyspace, nil
+}
+
+// ListLengths returns the length of each list key, 0 for keys that do not exist
+func (s *Server) ListLengths(ctx context.Context, keys []string) (map[string]int64, error) {
+	lengths := make(map[string]int64, len(keys))
+	err := s.session(ctx, func(c *conn) error {
+		for _, key := range keys {
+			reply, err := c.do("LLEN", key)
+			if err != nil {
+				return err
+			}
+			n, ok := reply.(int64)
+			if !ok {
+				return fmt.Errorf("unexpected LLEN reply %v", reply)
+			}
+			lengths[key] = n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lengths, nil
+}
+
+// Flush deletes every key of one database
+func (s *Server) Flush(ctx context.Context, db int) error {
+	return s.change(ctx, "flush", fmt.Sprintf("redis-cli -p %d -n %d FLUSHDB", s.Port, db), func(c *conn) error {
+		if _, err := c.do("SELECT", strconv.Itoa(db)); err != nil {
+			return err
+		}
+		_, err := c.do("FLUSHDB")
+		return err
+	})
+}
+
+// SetMemoryPolicy caps the server's memory and sets what it evicts at the
+// cap, then tries to save both to its config file with CONFIG REWRITE. It
+// reports whether they were saved; unsaved settings last until a restart.
+func (s *Server) SetMemoryPolicy(ctx context.Context, maxmemory, policy string) (bool, error) {
+	cli := fmt.Sprintf("redis-cli -p %d CONFIG SET", s.Port)
+	err := s.change(ctx, "set-memory-policy", cli+" maxmemory "+maxmemory+" maxmemory-policy "+policy, func(c *conn) error {
+		if _, err := c.do("CONFIG", "SET", "maxmemory", maxmemory); err != nil {
+			return err
+		}
+		_, err := c.do("CONFIG", "SET", "maxmemory-policy", policy)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	err = s.change(ctx, "rewrite-config", fmt.Sprintf("redis-cli -p %d CONFIG REWRITE", s.Port), func(c *conn) error {
+		_, err := c.do("CONFIG", "REWRITE")
+		return err
+	})
+	return err == nil, nil
+}
+
+// info runs INFO for a section, or the default ones, and splits its lines
+func (s *Server) info(ctx context.Context, section string) (map[string]map[string]string, error) {
+	args := []string{"INFO"}
+	if section != "" {
+		args = append(args, section)
+	}
+	var reply any
+	err := s.session(ctx, func(c *conn) error {
+		var err error
+		reply, err = c.do(args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	text, _ := reply.(string)
+
+	sections := map[string]map[string]string{}
+	current := map[string]string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "# "); ok {
+			current = map[string]string{}
+			sections[strings.ToLower(name)] = current
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			current[k] = v
+		}
+	}
+	return sections, nil
+}
+
+// change runs a command that changes the server, auditing it as display
+func (s *Server) change(ctx context.Context, action, display string, fn func(*conn) error) error {
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(strings.Fields(display))
+		return nil
+	}
+	start := time.Now()
+	err := s.session(ctx, fn)
+	audit.Log(ctx, audit.CategoryDatabase, action, display, "", err, time.Since(start))
+	return err
+}
+
+// session opens a connection for fn and closes it after
+func (s *Server) session(ctx context.Context, fn func(*conn) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCommandFailed, err)
+	}
+	defer nc.Close()
+	deadline, _ := ctx.Deadline()
+	nc.SetDeadline(deadline)
+
+	c := &conn{nc: nc, r: bufio.NewReader(nc)}
+	if s.Password != "" {
+		if _, err := c.do("AUTH", s.Password); err != nil {
+			return fmt.Errorf("%w: %w", ErrCommandFailed, err)
+		}
+	}
+	if s.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(s.DB)); err != nil {
+			return fmt.Errorf("%w: %w", ErrCommandFailed, err)
+		}
+	}
+	if err := fn(c); err != nil {
+		return fmt.Errorf("%w: %w", ErrCommandFailed, err)
+	}
+	return nil
+}
+
+// conn is one connection speaking RESP
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// do sends a command and reads its reply; an error reply is returned as an error
+func (c *conn) do(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.nc, b.String()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read parses one reply: simple strings and bulk strings become strings,
+// integers int64, arrays []any, and nil bulk strings nil
+func (c *conn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, errors.New(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > maxBulk {
+			return nil, fmt.Errorf("bad bulk length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("bad array length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the AES-256 key length in bytes
+const KeySize = 32
+
+// ErrInvalidCiphertext is returned when a stored value cannot be decrypted
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+// Cipher encrypts and decrypts secret values with AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a Cipher from a 32-byte key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secret key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Encrypt seals a plaintext value; the random nonce is prepended to the result
+func (c *Cipher) Encrypt(plaintext string) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, []byte(plaintext), nil), nil
+}
+
+// Decrypt opens a value produced by Encrypt
+func (c *Cipher) Decrypt(ciphertext []byte) (string, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return "", ErrInvalidCiphertext
+	}
+	plaintext, err := c.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return string(plaintext), nil
+}
+
+// LoadKey returns the master key used to encrypt secrets.
+// SERVIO_SECRET_KEY (base64) takes precedence; otherwise the key is read from path,
+// and generated there with 0600 permissions on first run.
+func LoadKey(path string) ([]byte, error) {
+	if encoded := os.Getenv("SERVIO_SECRET_KEY"); encoded != "" {
+		return decodeKey(encoded)
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return decodeKey(string(data))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write secret key: %w", err)
+	}
+	return key, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secret key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secret key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// fetchTimeout bounds one lookup in an external store
+const fetchTimeout = 30 * time.Second
+
+var (
+	// ErrInvalidReference is wrapped for external references a provider cannot parse
+	ErrInvalidReference = errors.New("invalid secret reference")
+	// ErrProviderFailed is wrapped when an external store cannot be reached or refuses a lookup
+	ErrProviderFailed = errors.New("secret provider failed")
+)
+
+// Provider fetches values from an external secret store. Resolve hands it
+// what follows the scheme in ${scheme:ref}; values are never stored by Servio.
+type Provider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// ProviderFunc adapts a function to Provider
+type ProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// Fetch calls f
+func (f ProviderFunc) Fetch(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// DefaultProviders are the external stores every Resolver knows, by scheme.
+// Each runs its store's CLI, which reads its usual environment and config for
+// the address and credentials.
+func DefaultProviders() map[string]Provider {
+	return map[string]Provider{
+		"vault": ProviderFunc(fetchVault),
+		"ssm":   ProviderFunc(fetchSSM),
+		"sops":  ProviderFunc(fetchSOPS),
+	}
+}
+
+// fetchVault reads one field of a Vault KV secret: vault:kv/app#db_pass.
+// The vault CLI works out whether the mount is KV version 1 or 2.
+func fetchVault(ctx context.Context, ref string) (string, error) {
+	path, field, err := splitField(ref, "vault:kv/path#field")
+	if err != nil {
+		return "", err
+	}
+	return run(ctx, "vault", "kv", "get", "-field="+field, path)
+}
+
+// fetchSSM reads an AWS Systems Manager parameter, decrypting SecureStrings:
+// ssm:/app/db_pass
+func fetchSSM(ctx context.Context, ref string) (string, error) {
+	return run(ctx, "aws", "ssm", "get-parameter", "--name="+ref, "--with-decryption",
+		"--query", "Parameter.Value", "--output", "text")
+}
+
+// fetchSOPS decrypts one value of a SOPS-encrypted YAML or JSON file, with
+// dots separating nested keys: sops:/etc/app/secrets.enc.yaml#db.password
+func fetchSOPS(ctx context.Context, ref string) (string, error) {
+	file, key, err := splitField(ref, "sops:/path/to/file#key")
+	if err != nil {
+		return "", err
+	}
+	var extract strings.Builder
+	for _, part := range strings.Split(key, ".") {
+		fmt.Fprintf(&extract, "[%q]", part)
+	}
+	return run(ctx, "sops", "--decrypt", "--extract", extract.String(), file)
+}
+
+// splitField splits ref at its last # into a location and a field, both required
+func splitField(ref, example string) (string, string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", fmt.Errorf("%w: %q needs a field after #, as in %s", ErrInvalidReference, ref, example)
+	}
+	return ref[:i], ref[i+1:], nil
+}
+
+// run executes a store's CLI and returns its output without the trailing
+// newline. stdout holds the secret, so only stderr goes into errors.
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(stdout.String(), "\n"), "\r"), nil
+}
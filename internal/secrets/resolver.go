@@ -0,0 +1,132 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// ErrSecretNotFound is returned when a referenced secret is not defined in any applicable scope
+var ErrSecretNotFound = errors.New("secret not found")
+
+// referencePattern matches ${scheme:ref} references in environment values and
+// unit files: ${secret:NAME} for Servio's own secrets, or a provider's scheme
+var referencePattern = regexp.MustCompile(`\$\{([a-z][a-z0-9]*):([^}\s]+)\}`)
+
+// localScheme is the scheme of secrets stored in Servio's database
+const localScheme = "secret"
+
+// KeyPattern validates secret names
+var KeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Resolver substitutes secret references with decrypted values from the
+// secrets table or fetched from external stores
+type Resolver struct {
+	store     storage.Store
+	cipher    *Cipher
+	providers map[string]Provider
+}
+
+// NewResolver creates a Resolver backed by the secrets table and the DefaultProviders
+func NewResolver(store storage.Store, cipher *Cipher) *Resolver {
+	return &Resolver{store: store, cipher: cipher, providers: DefaultProviders()}
+}
+
+// Register makes ${scheme:ref} references resolve through p, replacing any
+// provider already registered for scheme
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.providers[scheme] = p
+}
+
+// Resolve replaces every ${secret:NAME} and provider reference in content.
+// Project-scoped secrets take precedence over global ones, and references
+// with other schemes are left alone. The boolean reports whether anything
+// was substituted.
+func (r *Resolver) Resolve(ctx context.Context, service *storage.Service, content string) (string, bool, error) {
+	values := map[string]string{}
+	for _, m := range referencePattern.FindAllStringSubmatch(content, -1) {
+		if _, done := values[m[0]]; done || !r.resolves(m[1], m[2]) {
+			continue
+		}
+		value, err := r.fetch(ctx, service.ProjectID, m[1], m[2])
+		if err != nil {
+			return "", false, err
+		}
+		values[m[0]] = value
+	}
+	if len(values) == 0 {
+		return content, false, nil
+	}
+
+	resolved := referencePattern.ReplaceAllStringFunc(content, func(ref string) string {
+		if value, ok := values[ref]; ok {
+			return value
+		}
+		return ref
+	})
+	return resolved, true, nil
+}
+
+// resolves reports whether a reference is one Resolve substitutes
+func (r *Resolver) resolves(scheme, ref string) bool {
+	if scheme == localScheme {
+		return KeyPattern.MatchString(ref)
+	}
+	_, ok := r.providers[scheme]
+	return ok
+}
+
+// fetch returns the value of one reference
+func (r *Resolver) fetch(ctx context.Context, projectID int64, scheme, ref string) (string, error) {
+	if scheme == localScheme {
+		return r.lookup(ctx, projectID, ref)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("%w: %s:%s must not start with -", ErrInvalidReference, scheme, ref)
+	}
+	value, err := r.providers[scheme].Fetch(ctx, ref)
+	switch {
+	case errors.Is(err, ErrInvalidReference):
+		return "", err
+	case err != nil:
+		return "", fmt.Errorf("%w: %s:%s: %w", ErrProviderFailed, scheme, ref, err)
+	}
+	return value, nil
+}
+
+func (r *Resolver) lookup(ctx context.Context, projectID int64, name string) (string, error) {
+	for _, scope := range []string{storage.ProjectScope(projectID), storage.SecretScopeGlobal} {
+		secret, err := r.store.GetSecretByKey(ctx, name, scope)
+		if err != nil {
+			return "", fmt.Errorf("failed to load secret %q: %w", name, err)
+		}
+		if secret == nil {
+			continue
+		}
+		value, err := r.cipher.Decrypt(secret.Ciphertext)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt secret %q: %w", name, err)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: %q is referenced but not defined", ErrSecretNotFound, name)
+}
+
+// ReplaceReferences replaces each reference a Resolver with the
+// DefaultProviders would substitute with what replace returns for it, such as
+// a variable of an exported configuration
+func ReplaceReferences(content string, replace func(scheme, ref string) string) string {
+	providers := DefaultProviders()
+	return referencePattern.ReplaceAllStringFunc(content, func(m string) string {
+		sub := referencePattern.FindStringSubmatch(m)
+		scheme, ref := sub[1], sub[2]
+		if _, ok := providers[scheme]; ok || (scheme == localScheme && KeyPattern.MatchString(ref)) {
+			return replace(scheme, ref)
+		}
+		return m
+	})
+}
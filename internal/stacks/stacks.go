@@ -0,0 +1,183 @@
+// Package stacks defines application stacks: sets of services wired together
+// (ports, shared environment, nginx routes) that create a project in one action.
+package stacks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"servio/internal/storage"
+)
+
+// PasswordSecret is the project-scoped secret holding a stack's database
+// password, referenced from its DATABASE_URL
+const PasswordSecret = "DATABASE_PASSWORD"
+
+// ErrUnknownStack is wrapped for stack names with no template
+var ErrUnknownStack = errors.New("unknown stack")
+
+// Template describes a stack. String fields of its services and Env are Go
+// templates with .Project, .Slug, .Ident, and .Domain, and {{port "name"}}
+// for the port given to one of its services.
+type Template struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Description string            `json:"description"`
+	Services    []ServiceTemplate `json:"services"`   // in install order, backing services first
+	Env         string            `json:"shared_env"` // KEY=VALUE lines added to every app service
+}
+
+// ServiceTemplate is one service of a stack
+type ServiceTemplate struct {
+	Name        string `json:"name"` // appended to the project's slug, e.g. myapp-web
+	Type        string `json:"type"`
+	Version     string `json:"version,omitempty"`
+	Port        int    `json:"port,omitempty"` // preferred port, or 0 for none; the next free one is taken
+	Command     string `json:"command"`
+	WorkingDir  string `json:"working_dir"`
+	User        string `json:"user"`
+	Environment string `json:"environment,omitempty"`
+	Config      string `json:"config,omitempty"`
+	App         bool   `json:"app"`             // runs the project's code: gets the repository and the shared environment
+	Start       bool   `json:"start"`           // started as soon as it is installed
+	Database    bool   `json:"database"`        // a postgres service to create the stack's role and database in
+	Route       string `json:"route,omitempty"` // nginx location proxied to the service
+}
+
+// Route proxies an nginx location to a service's port
+type Route struct {
+	Path    string `json:"path"`
+	Service string `json:"service"`
+	Port    int    `json:"port"`
+}
+
+// Plan is a template rendered for one project
+type Plan struct {
+	Services []Service
+	Routes   []Route
+	Database string // role and database name, "" when the stack has none
+}
+
+// Service is a service to create, with what to do when installing it
+type Service struct {
+	Request  storage.CreateServiceRequest
+	Start    bool
+	Database bool
+}
+
+// slugPattern matches the runs of characters replaced in slugs
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Get returns the template named name
+func Get(name string) (*Template, error) {
+	for i := range templates {
+		if templates[i].Name == name {
+			return &templates[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownStack, name)
+}
+
+// All returns every template
+func All() []Template {
+	return slices.Clone(templates)
+}
+
+// Slug turns a project name into a name for services and paths: lowercase
+// letters, digits, and dashes
+func Slug(name string) string {
+	slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		return "app"
+	}
+	return slug
+}
+
+// Ident turns a project name into a postgres role and database name
+func Ident(name string) string {
+	ident := strings.ReplaceAll(Slug(name), "-", "_")
+	if ident[0] >= '0' && ident[0] <= '9' {
+		ident = "app_" + ident
+	}
+	return ident[:min(len(ident), 63)]
+}
+
+// Build renders t for project, taking each service's port from alloc and
+// pointing app services at repoURL
+func (t *Template) Build(project *storage.Project, repoURL string, alloc func(preferred int) (int, error)) (*Plan, error) {
+	data := struct {
+		Project, Slug, Ident, Domain string
+	}{project.Name, Slug(project.Name), Ident(project.Name), project.Domain}
+
+	ports := map[string]int{}
+	for _, st := range t.Services {
+		if st.Port == 0 {
+			continue
+		}
+		port, err := alloc(st.Port)
+		if err != nil {
+			return nil, err
+		}
+		ports[st.Name] = port
+	}
+	funcs := template.FuncMap{"port": func(name string) (int, error) {
+		port, ok := ports[name]
+		if !ok {
+			return 0, fmt.Errorf("stack %s has no service %q with a port", t.Name, name)
+		}
+		return port, nil
+	}}
+	render := func(field, text string) (string, error) {
+		tmpl, err := template.New(field).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	shared, err := render("shared_env", t.Env)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	for _, st := range t.Services {
+		req := storage.CreateServiceRequest{
+			ProjectID:   project.ID,
+			Name:        data.Slug + "-" + st.Name,
+			Type:        st.Type,
+			Version:     st.Version,
+			Port:        ports[st.Name],
+			User:        st.User,
+			AutoRestart: true,
+		}
+		for _, f := range []struct {
+			dst  *string
+			text string
+		}{{&req.Command, st.Command}, {&req.WorkingDir, st.WorkingDir}, {&req.Environment, st.Environment}, {&req.Config, st.Config}} {
+			if *f.dst, err = render(st.Name, f.text); err != nil {
+				return nil, err
+			}
+		}
+		if st.App {
+			req.GitRepoURL = repoURL
+			req.Environment = strings.TrimSpace(shared + "\n" + req.Environment)
+		}
+		plan.Services = append(plan.Services, Service{Request: req, Start: st.Start, Database: st.Database})
+		if st.Route != "" {
+			plan.Routes = append(plan.Routes, Route{Path: st.Route, Service: req.Name, Port: req.Port})
+		}
+		if st.Database {
+			plan.Database = data.Ident
+		}
+	}
+	return plan, nil
+}
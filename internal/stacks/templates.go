@@ -0,0 +1,62 @@
+package stacks
+
+// =============================================================================
+// BUILT-IN STACKS
+// =============================================================================
+// To add a stack, append a Template below. Backing services come first so
+// they are running before the app services that use them are installed.
+// =============================================================================
+
+// postgresCommand initializes the stack's own data directory on first start,
+// so several stacks can run postgres side by side on different ports
+const postgresCommand = `/bin/sh -c 'test -f /var/lib/pgsql/{{.Slug}}/PG_VERSION || /usr/bin/initdb -D /var/lib/pgsql/{{.Slug}}; exec /usr/bin/postgres -D /var/lib/pgsql/{{.Slug}} -p {{port "db"}}'`
+
+// redisCommand runs redis on the stack's port with a data directory of its own
+const redisCommand = `/bin/sh -c 'mkdir -p /var/lib/redis/{{.Slug}} && exec /usr/bin/redis-server --port {{port "redis"}} --bind 127.0.0.1 --dir /var/lib/redis/{{.Slug}}'`
+
+var templates = []Template{
+	{
+		Name:        "django",
+		DisplayName: "Django + Postgres + Redis + Celery",
+		Description: "Gunicorn serving a Django app, a Celery worker, PostgreSQL with the app's database and role, and Redis as cache and broker",
+		Services: []ServiceTemplate{
+			{Name: "db", Type: "postgres", Version: "16", Port: 5432, Command: postgresCommand, WorkingDir: "/var/lib/pgsql", User: "postgres",
+				Config: `{"db_port": {{port "db"}}}`, Start: true, Database: true},
+			{Name: "redis", Type: "redis", Version: "7", Port: 6379, Command: redisCommand, WorkingDir: "/var/lib/redis", User: "redis", Start: true},
+			{Name: "web", Type: "python", Port: 8000, Command: `/usr/bin/env gunicorn --workers 2 --bind 127.0.0.1:{{port "web"}} app.wsgi:application`,
+				WorkingDir: "/srv/{{.Slug}}", User: "www-data", App: true, Route: "/"},
+			{Name: "worker", Type: "celery", Version: "5.4", Config: `{"app": "app", "concurrency": 2, "queues": ["celery"]}`, WorkingDir: "/srv/{{.Slug}}", User: "www-data", App: true},
+		},
+		Env: `DJANGO_SETTINGS_MODULE=app.settings
+DATABASE_URL=postgres://{{.Ident}}:${secret:DATABASE_PASSWORD}@127.0.0.1:{{port "db"}}/{{.Ident}}
+REDIS_URL=redis://127.0.0.1:{{port "redis"}}/0
+CELERY_BROKER_URL=redis://127.0.0.1:{{port "redis"}}/1
+PYTHONUNBUFFERED=1`,
+	},
+	{
+		Name:        "nextjs",
+		DisplayName: "Next.js + Node API",
+		Description: "A Next.js frontend at / and a Node API at /api/, both run from one repository with npm",
+		Services: []ServiceTemplate{
+			{Name: "api", Type: "node", Port: 4000, Command: "/usr/bin/env npm run start:api", WorkingDir: "/srv/{{.Slug}}", User: "www-data",
+				Environment: `PORT={{port "api"}}`, App: true, Route: "/api/"},
+			{Name: "web", Type: "node", Port: 3000, Command: "/usr/bin/env npm run start", WorkingDir: "/srv/{{.Slug}}", User: "www-data",
+				Environment: `PORT={{port "web"}}`, App: true, Route: "/"},
+		},
+		Env: `NODE_ENV=production
+API_URL=http://127.0.0.1:{{port "api"}}`,
+	},
+	{
+		Name:        "node-postgres",
+		DisplayName: "Node API + Postgres",
+		Description: "A Node service with a PostgreSQL database and role of its own",
+		Services: []ServiceTemplate{
+			{Name: "db", Type: "postgres", Version: "16", Port: 5432, Command: postgresCommand, WorkingDir: "/var/lib/pgsql", User: "postgres",
+				Config: `{"db_port": {{port "db"}}}`, Start: true, Database: true},
+			{Name: "api", Type: "node", Port: 3000, Command: "/usr/bin/env npm run start", WorkingDir: "/srv/{{.Slug}}", User: "www-data",
+				Environment: `PORT={{port "api"}}`, App: true, Route: "/"},
+		},
+		Env: `NODE_ENV=production
+DATABASE_URL=postgres://{{.Ident}}:${secret:DATABASE_PASSWORD}@127.0.0.1:{{port "db"}}/{{.Ident}}`,
+	},
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidActivitySource is returned for a source other than ActivityAction or ActivityEvent
+var ErrInvalidActivitySource = errors.New("invalid activity source")
+
+// defaultActivityLimit is the page size of the activity feed when no limit is given
+const defaultActivityLimit = 50
+
+// RecordEvent persists an event for the activity feed
+func (s *Storage) RecordEvent(ctx context.Context, e *EventRecord) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event data: %w", err)
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO events (type, project_id, service_id, data, failed, created_at, created_us)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, e.Type, nullID(e.ProjectID), nullID(e.ServiceID), string(data), e.Failed, e.CreatedAt, e.CreatedAt.UnixMicro())
+	if err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+
+	e.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListActivity returns a page of audit entries and recorded events matching
+// the filter, newest first by created_us, along with how many match in all
+func (s *Storage) ListActivity(ctx context.Context, filter ActivityFilter) ([]*Activity, int, error) {
+	where, args := activityFilter(ctx, filter)
+	var parts []string
+	var partArgs []interface{}
+	if filter.Source == "" || filter.Source == ActivityAction {
+		parts = append(parts, `
+			SELECT '`+ActivityAction+`' AS source, id, COALESCE(project_id, 0) AS project_id, COALESCE(service_id, 0) AS service_id,
+				category AS type, action, actor, command, NOT success AS failed, error, '' AS data, created_us
+			FROM audit_entries`+where)
+		partArgs = append(partArgs, args...)
+	}
+	if filter.Source == "" || filter.Source == ActivityEvent {
+		parts = append(parts, `
+			SELECT '`+ActivityEvent+`' AS source, id, COALESCE(project_id, 0) AS project_id, COALESCE(service_id, 0) AS service_id,
+				type, '' AS action, '' AS actor, '' AS command, failed, '' AS error, data, created_us
+			FROM events`+where)
+		partArgs = append(partArgs, args...)
+	}
+	if len(parts) == 0 {
+		return nil, 0, fmt.Errorf("%w: %q", ErrInvalidActivitySource, filter.Source)
+	}
+	union := parts[0]
+	if len(parts) > 1 {
+		union += " UNION ALL " + parts[1]
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+union+")", partArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count activity: %w", err)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultActivityLimit
+	}
+	query := `
+		SELECT a.source, a.id, a.project_id, a.service_id, COALESCE(p.name, ''), COALESCE(sv.name, ''),
+			a.type, a.action, a.actor, a.command, a.failed, a.error, a.data, a.created_us
+		FROM (` + union + `) a
+		LEFT JOIN projects p ON p.id = a.project_id
+		LEFT JOIN services sv ON sv.id = a.service_id
+		ORDER BY a.created_us DESC, a.id DESC` + limitClause(ListOptions{Limit: limit, Offset: filter.Offset})
+	rows, err := s.db.QueryContext(ctx, query, partArgs...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list activity: %w", err)
+	}
+	defer rows.Close()
+
+	activity := []*Activity{}
+	for rows.Next() {
+		a := &Activity{}
+		var data string
+		var createdUS int64
+		if err := rows.Scan(&a.Source, &a.ID, &a.ProjectID, &a.ServiceID, &a.Project, &a.Service,
+			&a.Type, &a.Action, &a.Actor, &a.Command, &a.Failed, &a.Error, &data, &createdUS); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan activity: %w", err)
+		}
+		if data != "" {
+			if err := json.Unmarshal([]byte(data), &a.Data); err != nil {
+				return nil, 0, fmt.Errorf("failed to decode event %d: %w", a.ID, err)
+			}
+		}
+		a.Time = time.UnixMicro(createdUS)
+		activity = append(activity, a)
+	}
+
+	return activity, total, rows.Err()
+}
+
+// activityFilter renders the WHERE clause shared by both sides of the feed
+func activityFilter(ctx context.Context, filter ActivityFilter) (string, []interface{}) {
+	conds, args := scopeConds(ctx, "project_id")
+	if filter.ProjectID > 0 {
+		conds = append(conds, "project_id = ?")
+		args = append(args, filter.ProjectID)
+	}
+	if filter.ServiceID > 0 {
+		conds = append(conds, "service_id = ?")
+		args = append(args, filter.ServiceID)
+	}
+	return whereClause(conds), args
+}
+
+// PruneEvents deletes events recorded before cutoff and returns how many were removed
+func (s *Storage) PruneEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM events WHERE created_us < ?", cutoff.UnixMicro())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	return result.RowsAffected()
+}
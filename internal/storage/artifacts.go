@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// artifactColumns lists what artifact queries read, content last
+const artifactColumns = `path, category, COALESCE(project_id, 0), COALESCE(service_id, 0), mode, size, checksum, encrypted, updated_at`
+
+// SaveArtifact stores the content just written to a file, replacing what
+// was kept for its path. A write made outside any project keeps the
+// project and service the path was first written for.
+func (s *Storage) SaveArtifact(ctx context.Context, a *Artifact) error {
+	a.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO artifacts (path, category, project_id, service_id, mode, size, checksum, encrypted, content, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET
+			category = excluded.category,
+			project_id = COALESCE(excluded.project_id, artifacts.project_id),
+			service_id = COALESCE(excluded.service_id, artifacts.service_id),
+			mode = excluded.mode, size = excluded.size, checksum = excluded.checksum,
+			encrypted = excluded.encrypted, content = excluded.content, updated_at = excluded.updated_at
+	`, a.Path, a.Category, nullID(a.ProjectID), nullID(a.ServiceID), a.Mode, a.Size, a.Checksum, a.Encrypted, a.Content, a.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save artifact: %w", err)
+	}
+	return nil
+}
+
+// DeleteArtifacts forgets the file at path, and every file under it when
+// path is a directory
+func (s *Storage) DeleteArtifacts(ctx context.Context, path string) error {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	_, err := s.db.ExecContext(ctx, `DELETE FROM artifacts WHERE path = ? OR substr(path, 1, ?) = ?`, path, len(prefix), prefix)
+	if err != nil {
+		return fmt.Errorf("failed to delete artifacts: %w", err)
+	}
+	return nil
+}
+
+// GetArtifact returns what was kept for a path with its content, or nil
+func (s *Storage) GetArtifact(ctx context.Context, path string) (*Artifact, error) {
+	a, err := scanArtifact(s.db.QueryRowContext(ctx, `SELECT `+artifactColumns+`, content FROM artifacts WHERE path = ?`, path), true)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact: %w", err)
+	}
+	return a, nil
+}
+
+// ListArtifacts returns the files kept, by path, with their content only
+// when the filter asks for it
+func (s *Storage) ListArtifacts(ctx context.Context, filter ArtifactFilter) ([]*Artifact, error) {
+	query := `SELECT ` + artifactColumns
+	if filter.WithContent {
+		query += `, content`
+	}
+	query += ` FROM artifacts WHERE 1 = 1`
+	var args []interface{}
+	if filter.ProjectID > 0 {
+		query += " AND project_id = ?"
+		args = append(args, filter.ProjectID)
+	}
+	if filter.ServiceID > 0 {
+		query += " AND service_id = ?"
+		args = append(args, filter.ServiceID)
+	}
+	if filter.Category != "" {
+		query += " AND category = ?"
+		args = append(args, filter.Category)
+	}
+	query += " ORDER BY path"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	artifacts := []*Artifact{}
+	for rows.Next() {
+		a, err := scanArtifact(rows, filter.WithContent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan artifact: %w", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, rows.Err()
+}
+
+func scanArtifact(row rowScanner, withContent bool) (*Artifact, error) {
+	a := &Artifact{}
+	dest := []interface{}{&a.Path, &a.Category, &a.ProjectID, &a.ServiceID, &a.Mode, &a.Size, &a.Checksum, &a.Encrypted, &a.UpdatedAt}
+	if withContent {
+		dest = append(dest, &a.Content)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetUnitEnabled records that a unit was enabled, or forgets it once disabled
+func (s *Storage) SetUnitEnabled(ctx context.Context, unit string, projectID, serviceID int64, enabled bool) error {
+	var err error
+	if enabled {
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO enabled_units (unit, project_id, service_id, enabled_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(unit) DO UPDATE SET
+				project_id = COALESCE(excluded.project_id, enabled_units.project_id),
+				service_id = COALESCE(excluded.service_id, enabled_units.service_id),
+				enabled_at = excluded.enabled_at
+		`, unit, nullID(projectID), nullID(serviceID), time.Now())
+	} else {
+		_, err = s.db.ExecContext(ctx, `DELETE FROM enabled_units WHERE unit = ?`, unit)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record unit enablement: %w", err)
+	}
+	return nil
+}
+
+// ListEnabledUnits returns the units Servio enabled, by name
+func (s *Storage) ListEnabledUnits(ctx context.Context) ([]*EnabledUnit, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT unit, COALESCE(project_id, 0), COALESCE(service_id, 0), enabled_at FROM enabled_units ORDER BY unit`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list enabled units: %w", err)
+	}
+	defer rows.Close()
+
+	units := []*EnabledUnit{}
+	for rows.Next() {
+		u := &EnabledUnit{}
+		if err := rows.Scan(&u.Unit, &u.ProjectID, &u.ServiceID, &u.EnabledAt); err != nil {
+			return nil, fmt.Errorf("failed to scan enabled unit: %w", err)
+		}
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
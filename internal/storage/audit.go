@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// defaultAuditLimit caps audit queries when no limit is given
+const defaultAuditLimit = 100
+
+// CreateAuditEntry persists a host action
+func (s *Storage) CreateAuditEntry(ctx context.Context, e *AuditEntry) error {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_entries (project_id, service_id, actor, category, action, command, output, success, error, duration_ms, created_at, created_us)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, nullID(e.ProjectID), nullID(e.ServiceID), e.Actor, e.Category, e.Action, e.Command, e.Output, e.Success, e.Error, e.DurationMS, now, now.UnixMicro())
+	if err != nil {
+		return fmt.Errorf("failed to create audit entry: %w", err)
+	}
+
+	e.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListAuditEntries returns audit entries matching the filter, newest first
+func (s *Storage) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	query := `
+		SELECT id, COALESCE(project_id, 0), COALESCE(service_id, 0), actor, category, action, command, output, success, error, duration_ms, created_at
+		FROM audit_entries WHERE 1 = 1`
+	var args []interface{}
+
+	if cond, scopeArgs, scoped := teamScopeCond(ctx, "project_id"); scoped {
+		query += " AND " + cond
+		args = append(args, scopeArgs...)
+	}
+
+	if filter.ProjectID > 0 {
+		query += " AND project_id = ?"
+		args = append(args, filter.ProjectID)
+	}
+	if filter.ServiceID > 0 {
+		query += " AND service_id = ?"
+		args = append(args, filter.ServiceID)
+	}
+	if filter.Category != "" {
+		query += " AND category = ?"
+		args = append(args, filter.Category)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		e := &AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.ProjectID, &e.ServiceID, &e.Actor, &e.Category, &e.Action, &e.Command,
+			&e.Output, &e.Success, &e.Error, &e.DurationMS, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// nullID stores zero IDs as NULL so unattributed entries don't look like they belong to ID 0
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
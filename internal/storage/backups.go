@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const databaseBackupColumns = "id, project_id, service_id, database, path, size_bytes, status, error, actor, job_id, created_at, finished_at"
+
+// CreateDatabaseBackup inserts a backup; the actor comes from ctx
+func (s *Storage) CreateDatabaseBackup(ctx context.Context, b *DatabaseBackup) error {
+	b.CreatedAt = time.Now()
+	b.Actor = ActorFromContext(ctx)
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO database_backups (project_id, service_id, database, path, status, actor, job_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, b.ProjectID, b.ServiceID, b.Database, b.Path, b.Status, b.Actor, b.JobID, b.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create database backup: %w", err)
+	}
+	b.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetDatabaseBackup retrieves a backup by ID
+func (s *Storage) GetDatabaseBackup(ctx context.Context, id int64) (*DatabaseBackup, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+databaseBackupColumns+" FROM database_backups WHERE id = ?", id)
+	b, err := scanDatabaseBackup(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database backup: %w", err)
+	}
+	return b, nil
+}
+
+// ListDatabaseBackups returns a project's backups, newest first, limited to
+// one service unless serviceID is 0
+func (s *Storage) ListDatabaseBackups(ctx context.Context, projectID, serviceID int64) ([]*DatabaseBackup, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+databaseBackupColumns+` FROM database_backups
+		WHERE project_id = ? AND (? = 0 OR service_id = ?) ORDER BY id DESC`, projectID, serviceID, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list database backups: %w", err)
+	}
+	defer rows.Close()
+
+	backups := []*DatabaseBackup{}
+	for rows.Next() {
+		b, err := scanDatabaseBackup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan database backup: %w", err)
+		}
+		backups = append(backups, b)
+	}
+	return backups, rows.Err()
+}
+
+// FinishDatabaseBackup saves how a backup ended
+func (s *Storage) FinishDatabaseBackup(ctx context.Context, b *DatabaseBackup) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE database_backups SET status = ?, error = ?, size_bytes = ?, job_id = ?, finished_at = ? WHERE id = ?
+	`, b.Status, b.Error, b.SizeBytes, b.JobID, b.FinishedAt, b.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update database backup: %w", err)
+	}
+	return nil
+}
+
+func scanDatabaseBackup(row rowScanner) (*DatabaseBackup, error) {
+	b := &DatabaseBackup{}
+	var finished sql.NullTime
+	if err := row.Scan(&b.ID, &b.ProjectID, &b.ServiceID, &b.Database, &b.Path, &b.SizeBytes, &b.Status, &b.Error, &b.Actor, &b.JobID,
+		&b.CreatedAt, &finished); err != nil {
+		return nil, err
+	}
+	if finished.Valid {
+		b.FinishedAt = &finished.Time
+	}
+	return b, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ListBlueprintDefinitions returns the installed catalog blueprints, ordered by type
+func (s *Storage) ListBlueprintDefinitions(ctx context.Context) ([]*BlueprintDefinition, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT type, definition, sha256, source, installed_at FROM blueprint_definitions ORDER BY type")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blueprint definitions: %w", err)
+	}
+	defer rows.Close()
+
+	defs := []*BlueprintDefinition{}
+	for rows.Next() {
+		d := &BlueprintDefinition{}
+		if err := rows.Scan(&d.Type, &d.Definition, &d.SHA256, &d.Source, &d.InstalledAt); err != nil {
+			return nil, fmt.Errorf("failed to scan blueprint definition: %w", err)
+		}
+		defs = append(defs, d)
+	}
+	return defs, rows.Err()
+}
+
+// SaveBlueprintDefinition installs a catalog blueprint, replacing an earlier version of it
+func (s *Storage) SaveBlueprintDefinition(ctx context.Context, d *BlueprintDefinition) error {
+	d.InstalledAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO blueprint_definitions (type, definition, sha256, source, installed_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(type) DO UPDATE SET definition = excluded.definition, sha256 = excluded.sha256,
+			source = excluded.source, installed_at = excluded.installed_at
+	`, d.Type, d.Definition, d.SHA256, d.Source, d.InstalledAt)
+	if err != nil {
+		return fmt.Errorf("failed to save blueprint definition: %w", err)
+	}
+	return nil
+}
+
+// DeleteBlueprintDefinition removes an installed catalog blueprint
+func (s *Storage) DeleteBlueprintDefinition(ctx context.Context, blueprintType string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM blueprint_definitions WHERE type = ?", blueprintType); err != nil {
+		return fmt.Errorf("failed to delete blueprint definition: %w", err)
+	}
+	return nil
+}
+
+// CountServicesByType returns how many services use a blueprint type
+func (s *Storage) CountServicesByType(ctx context.Context, serviceType string) (int, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM services WHERE type = ?", serviceType).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count services: %w", err)
+	}
+	return n, nil
+}
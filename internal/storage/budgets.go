@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetProjectBudget returns a project's budget, or nil if it has none
+func (s *Storage) GetProjectBudget(ctx context.Context, projectID int64) (*ProjectBudget, error) {
+	b := &ProjectBudget{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT project_id, memory_max, cpu_quota, updated_at FROM project_budgets WHERE project_id = ?
+	`, projectID).Scan(&b.ProjectID, &b.MemoryMax, &b.CPUQuota, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project budget: %w", err)
+	}
+	return b, nil
+}
+
+// SetProjectBudget creates or replaces a project's budget
+func (s *Storage) SetProjectBudget(ctx context.Context, b *ProjectBudget) error {
+	b.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO project_budgets (project_id, memory_max, cpu_quota, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET memory_max = excluded.memory_max, cpu_quota = excluded.cpu_quota, updated_at = excluded.updated_at
+	`, b.ProjectID, b.MemoryMax, b.CPUQuota, b.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set project budget: %w", err)
+	}
+	return nil
+}
+
+// DeleteProjectBudget removes a project's budget
+func (s *Storage) DeleteProjectBudget(ctx context.Context, projectID int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM project_budgets WHERE project_id = ?", projectID); err != nil {
+		return fmt.Errorf("failed to delete project budget: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GetCertificate returns a project's certificate, or nil if it has none
+func (s *Storage) GetCertificate(ctx context.Context, projectID int64) (*Certificate, error) {
+	c := &Certificate{}
+	var domains string
+	var expiresAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT project_id, name, domains, provider, status, error, job_id, expires_at, updated_at
+		FROM certificates WHERE project_id = ?
+	`, projectID).Scan(&c.ProjectID, &c.Name, &domains, &c.Provider, &c.Status, &c.Error, &c.JobID, &expiresAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+	c.Domains = []string{}
+	if domains != "" {
+		c.Domains = strings.Split(domains, ",")
+	}
+	if expiresAt.Valid {
+		c.ExpiresAt = &expiresAt.Time
+	}
+	return c, nil
+}
+
+// SaveCertificate creates or replaces a project's certificate
+func (s *Storage) SaveCertificate(ctx context.Context, c *Certificate) error {
+	c.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO certificates (project_id, name, domains, provider, status, error, job_id, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET name = excluded.name, domains = excluded.domains, provider = excluded.provider,
+			status = excluded.status, error = excluded.error, job_id = excluded.job_id, expires_at = excluded.expires_at,
+			updated_at = excluded.updated_at
+	`, c.ProjectID, c.Name, strings.Join(c.Domains, ","), c.Provider, c.Status, c.Error, c.JobID, c.ExpiresAt, c.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	return nil
+}
+
+// DeleteCertificate removes a project's certificate
+func (s *Storage) DeleteCertificate(ctx context.Context, projectID int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM certificates WHERE project_id = ?", projectID); err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+	return nil
+}
+
+const wildcardCertificateColumns = "id, domain, name, provider, status, error, job_id, expires_at, created_at, updated_at"
+
+// GetWildcardCertificate retrieves a wildcard certificate by ID
+func (s *Storage) GetWildcardCertificate(ctx context.Context, id int64) (*WildcardCertificate, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+wildcardCertificateColumns+" FROM wildcard_certificates WHERE id = ?", id)
+	c, err := scanWildcardCertificate(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wildcard certificate: %w", err)
+	}
+	return c, nil
+}
+
+// GetWildcardCertificateByDomain retrieves the wildcard certificate of a domain
+func (s *Storage) GetWildcardCertificateByDomain(ctx context.Context, domain string) (*WildcardCertificate, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+wildcardCertificateColumns+" FROM wildcard_certificates WHERE domain = ?", domain)
+	c, err := scanWildcardCertificate(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wildcard certificate: %w", err)
+	}
+	return c, nil
+}
+
+// ListWildcardCertificates returns every wildcard certificate, ordered by domain
+func (s *Storage) ListWildcardCertificates(ctx context.Context) ([]*WildcardCertificate, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+wildcardCertificateColumns+" FROM wildcard_certificates ORDER BY domain")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wildcard certificates: %w", err)
+	}
+	defer rows.Close()
+
+	certs := []*WildcardCertificate{}
+	for rows.Next() {
+		c, err := scanWildcardCertificate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan wildcard certificate: %w", err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, rows.Err()
+}
+
+// SaveWildcardCertificate inserts a wildcard certificate, or updates it when it has an ID
+func (s *Storage) SaveWildcardCertificate(ctx context.Context, c *WildcardCertificate) error {
+	c.UpdatedAt = time.Now()
+	if c.ID != 0 {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE wildcard_certificates SET name = ?, provider = ?, status = ?, error = ?, job_id = ?, expires_at = ?, updated_at = ?
+			WHERE id = ?
+		`, c.Name, c.Provider, c.Status, c.Error, c.JobID, c.ExpiresAt, c.UpdatedAt, c.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update wildcard certificate: %w", err)
+		}
+		return nil
+	}
+
+	c.CreatedAt = c.UpdatedAt
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO wildcard_certificates (domain, name, provider, status, error, job_id, expires_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.Domain, c.Name, c.Provider, c.Status, c.Error, c.JobID, c.ExpiresAt, c.CreatedAt, c.UpdatedAt)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return &ValidationError{Fields: []FieldError{{Field: "domain", Message: "already has a wildcard certificate"}}}
+		}
+		return fmt.Errorf("failed to create wildcard certificate: %w", err)
+	}
+	c.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// DeleteWildcardCertificate removes a wildcard certificate
+func (s *Storage) DeleteWildcardCertificate(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM wildcard_certificates WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete wildcard certificate: %w", err)
+	}
+	return nil
+}
+
+func scanWildcardCertificate(row rowScanner) (*WildcardCertificate, error) {
+	c := &WildcardCertificate{Projects: []string{}}
+	var expiresAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.Domain, &c.Name, &c.Provider, &c.Status, &c.Error, &c.JobID, &expiresAt, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		c.ExpiresAt = &expiresAt.Time
+	}
+	return c, nil
+}
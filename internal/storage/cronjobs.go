@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const cronJobColumns = `id, project_id, name, schedule, command, working_dir, user, environment, timeout_seconds, enabled,
+	last_run_at, last_status, last_exit_code, last_duration_ms, created_at, updated_at`
+
+// CreateCronJob inserts a cron job
+func (s *Storage) CreateCronJob(ctx context.Context, j *CronJob) error {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO cron_jobs (project_id, name, schedule, command, working_dir, user, environment, timeout_seconds, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, j.ProjectID, j.Name, j.Schedule, j.Command, j.WorkingDir, j.User, j.Environment, j.Timeout, j.Enabled, now, now)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "is already used by another cron job in this project"}}}
+		}
+		return fmt.Errorf("failed to create cron job: %w", err)
+	}
+
+	j.ID, _ = result.LastInsertId()
+	j.CreatedAt, j.UpdatedAt = now, now
+	return nil
+}
+
+// GetCronJob retrieves a cron job by ID
+func (s *Storage) GetCronJob(ctx context.Context, id int64) (*CronJob, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+cronJobColumns+" FROM cron_jobs WHERE id = ?", id)
+	j, err := scanCronJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cron job: %w", err)
+	}
+	return j, nil
+}
+
+// ListCronJobs returns a project's cron jobs, or every project's when
+// projectID is 0, by name
+func (s *Storage) ListCronJobs(ctx context.Context, projectID int64) ([]*CronJob, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+cronJobColumns+" FROM cron_jobs WHERE ? = 0 OR project_id = ? ORDER BY name, id", projectID, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cron jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*CronJob{}
+	for rows.Next() {
+		j, err := scanCronJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cron job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// UpdateCronJob saves a cron job's settings; its last run is kept
+func (s *Storage) UpdateCronJob(ctx context.Context, j *CronJob) error {
+	j.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE cron_jobs SET schedule = ?, command = ?, working_dir = ?, user = ?, environment = ?, timeout_seconds = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, j.Schedule, j.Command, j.WorkingDir, j.User, j.Environment, j.Timeout, j.Enabled, j.UpdatedAt, j.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update cron job: %w", err)
+	}
+	return nil
+}
+
+// RecordCronRun saves the outcome of a cron job's latest run
+func (s *Storage) RecordCronRun(ctx context.Context, id int64, run *CronRun) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE cron_jobs SET last_run_at = ?, last_status = ?, last_exit_code = ?, last_duration_ms = ?
+		WHERE id = ?
+	`, run.StartedAt, run.Status, run.ExitCode, run.Duration.Milliseconds(), id)
+	if err != nil {
+		return fmt.Errorf("failed to record cron run: %w", err)
+	}
+	return nil
+}
+
+// DeleteCronJob deletes a cron job
+func (s *Storage) DeleteCronJob(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM cron_jobs WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete cron job: %w", err)
+	}
+	return nil
+}
+
+func scanCronJob(row rowScanner) (*CronJob, error) {
+	j := &CronJob{}
+	var lastRun sql.NullTime
+	if err := row.Scan(&j.ID, &j.ProjectID, &j.Name, &j.Schedule, &j.Command, &j.WorkingDir, &j.User, &j.Environment, &j.Timeout, &j.Enabled,
+		&lastRun, &j.LastStatus, &j.LastExitCode, &j.LastDuration, &j.CreatedAt, &j.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if lastRun.Valid {
+		j.LastRunAt = &lastRun.Time
+	}
+	return j, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -16,57 +18,306 @@ type Store interface {
 	GetProject(ctx context.Context, id int64) (*Project, error)
 	GetProjectByName(ctx context.Context, name string) (*Project, error)
 	ListProjects(ctx context.Context) ([]*Project, error)
+	ListProjectsPage(ctx context.Context, opts ListOptions) ([]*Project, int, error)
 	UpdateProject(ctx context.Context, id int64, req *UpdateProjectRequest) (*Project, error)
 	UpdateProjectNginxRaw(ctx context.Context, id int64, nginxRaw string) (*Project, error)
+	SetProjectProtocols(ctx context.Context, id int64, http2, http3 bool) (*Project, error)
 	DeleteProject(ctx context.Context, id int64) error
 
 	// Service methods
 	CreateService(ctx context.Context, req *CreateServiceRequest) (*Service, error)
 	GetService(ctx context.Context, id int64) (*Service, error)
 	ListServicesByProject(ctx context.Context, projectID int64) ([]*Service, error)
+	ListServicesPage(ctx context.Context, projectID int64, opts ListOptions) ([]*Service, int, error)
 	UpdateService(ctx context.Context, id int64, req *UpdateServiceRequest) (*Service, error)
 	DeleteService(ctx context.Context, id int64) error
+	UnitRuntime(ctx context.Context, unit string) (string, error)
+	UsedPorts(ctx context.Context, hostID int64) (map[int]bool, error)
+	PortConflicts(ctx context.Context) ([]PortConflict, error)
+	SetServiceReplicas(ctx context.Context, sv *Service, replicas int) error
+
+	// Revision methods
+	ListServiceRevisions(ctx context.Context, serviceID int64) ([]*ServiceRevision, error)
+	GetServiceRevision(ctx context.Context, id int64) (*ServiceRevision, error)
+
+	// Secret methods (values are stored encrypted; see internal/secrets)
+	CreateSecret(ctx context.Context, key, scope string, ciphertext []byte) (*Secret, error)
+	GetSecret(ctx context.Context, id int64) (*Secret, error)
+	GetSecretByKey(ctx context.Context, key, scope string) (*Secret, error)
+	ListSecrets(ctx context.Context, scope string) ([]*Secret, error)
+	UpdateSecret(ctx context.Context, id int64, ciphertext []byte) (*Secret, error)
+	DeleteSecret(ctx context.Context, id int64) error
+
+	// Audit methods
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error)
+
+	// Activity feed methods: recorded events, listed with the audit trail
+	RecordEvent(ctx context.Context, e *EventRecord) error
+	ListActivity(ctx context.Context, filter ActivityFilter) ([]*Activity, int, error)
+	PruneEvents(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// Deployment methods
+	CreateDeployment(ctx context.Context, d *Deployment) error
+	GetDeployment(ctx context.Context, id int64) (*Deployment, error)
+	ListDeployments(ctx context.Context, serviceID int64, limit int) ([]*Deployment, error)
+	UpdateDeployment(ctx context.Context, d *Deployment) error
+	DeleteDeployment(ctx context.Context, id int64) error
+
+	// Job methods
+	CreateJob(ctx context.Context, j *Job) error
+	GetJob(ctx context.Context, id int64) (*Job, error)
+	ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error)
+	UpdateJob(ctx context.Context, j *Job) error
+
+	// Webhook methods (signing secrets are stored encrypted; see internal/secrets)
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+	ListWebhooks(ctx context.Context) ([]*Webhook, error)
+	UpdateWebhook(ctx context.Context, w *Webhook) error
+	DeleteWebhook(ctx context.Context, id int64) error
+	CreateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error
+	UpdateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*WebhookDelivery, error)
+
+	// Notification channel methods (URLs and bot tokens are stored encrypted)
+	CreateNotificationChannel(ctx context.Context, c *NotificationChannel) error
+	GetNotificationChannel(ctx context.Context, id int64) (*NotificationChannel, error)
+	ListNotificationChannels(ctx context.Context) ([]*NotificationChannel, error)
+	UpdateNotificationChannel(ctx context.Context, c *NotificationChannel) error
+	DeleteNotificationChannel(ctx context.Context, id int64) error
+	CreateNotificationDelivery(ctx context.Context, d *NotificationDelivery) error
+	UpdateNotificationDelivery(ctx context.Context, d *NotificationDelivery) error
+	ListNotificationDeliveries(ctx context.Context, channelID int64, limit int) ([]*NotificationDelivery, error)
+
+	// Team methods (see WithTeamScope for how membership limits what a request sees)
+	CreateTeam(ctx context.Context, t *Team) error
+	GetTeam(ctx context.Context, id int64) (*Team, error)
+	ListTeams(ctx context.Context) ([]*Team, error)
+	UpdateTeam(ctx context.Context, t *Team) error
+	DeleteTeam(ctx context.Context, id int64) error
+	TeamIDsForMember(ctx context.Context, member string) ([]int64, error)
+	SetProjectTeam(ctx context.Context, projectID, teamID int64) (*Project, error)
+
+	// Metric history methods
+	RecordMetricSamples(ctx context.Context, samples []*MetricSample) error
+	ListMetricSamples(ctx context.Context, filter MetricFilter) ([]*MetricSample, error)
+	PruneMetricSamples(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// Application metric methods (custom metrics services push)
+	RecordAppMetrics(ctx context.Context, samples []*AppMetricSample) error
+	ListAppMetrics(ctx context.Context, filter AppMetricFilter) ([]*AppMetricSample, error)
+	PruneAppMetrics(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// Journal retention methods (one policy per service)
+	GetJournalRetention(ctx context.Context, serviceID int64) (*JournalRetention, error)
+	ListJournalRetentions(ctx context.Context) ([]*JournalRetention, error)
+	SetJournalRetention(ctx context.Context, r *JournalRetention) error
+	DeleteJournalRetention(ctx context.Context, serviceID int64) error
+
+	// Project budget methods (one budget per project)
+	GetProjectBudget(ctx context.Context, projectID int64) (*ProjectBudget, error)
+	SetProjectBudget(ctx context.Context, b *ProjectBudget) error
+	DeleteProjectBudget(ctx context.Context, projectID int64) error
+
+	// File logging methods (a row per service that logs to a rotated file)
+	GetFileLogging(ctx context.Context, serviceID int64) (*FileLogging, error)
+	SetFileLogging(ctx context.Context, f *FileLogging) error
+	DeleteFileLogging(ctx context.Context, serviceID int64) error
+
+	// Log forwarding methods (a row per service whose journal is shipped)
+	GetLogForwarding(ctx context.Context, serviceID int64) (*LogForwarding, error)
+	ListLogForwarding(ctx context.Context) ([]*LogForwarding, error)
+	EnableLogForwarding(ctx context.Context, serviceID int64) (*LogForwarding, error)
+	DisableLogForwarding(ctx context.Context, serviceID int64) error
+	SetLogForwardingCursor(ctx context.Context, serviceID int64, cursor string) error
+
+	// Log alert methods (serviceID 0 lists every service's)
+	CreateLogAlert(ctx context.Context, a *LogAlert) error
+	GetLogAlert(ctx context.Context, id int64) (*LogAlert, error)
+	ListLogAlerts(ctx context.Context, serviceID int64) ([]*LogAlert, error)
+	UpdateLogAlert(ctx context.Context, a *LogAlert) error
+	DeleteLogAlert(ctx context.Context, id int64) error
+	CreateLogIncident(ctx context.Context, i *LogIncident) error
+	ListLogIncidents(ctx context.Context, serviceID int64, limit int) ([]*LogIncident, error)
+
+	// Cron job methods (projectID 0 lists every project's)
+	CreateCronJob(ctx context.Context, j *CronJob) error
+	GetCronJob(ctx context.Context, id int64) (*CronJob, error)
+	ListCronJobs(ctx context.Context, projectID int64) ([]*CronJob, error)
+	UpdateCronJob(ctx context.Context, j *CronJob) error
+	RecordCronRun(ctx context.Context, id int64, run *CronRun) error
+	DeleteCronJob(ctx context.Context, id int64) error
+
+	// Database backups
+	CreateDatabaseBackup(ctx context.Context, b *DatabaseBackup) error
+	GetDatabaseBackup(ctx context.Context, id int64) (*DatabaseBackup, error)
+	ListDatabaseBackups(ctx context.Context, projectID, serviceID int64) ([]*DatabaseBackup, error)
+	FinishDatabaseBackup(ctx context.Context, b *DatabaseBackup) error
+
+	// Certificate methods (one Let's Encrypt certificate per project)
+	GetCertificate(ctx context.Context, projectID int64) (*Certificate, error)
+	SaveCertificate(ctx context.Context, c *Certificate) error
+	DeleteCertificate(ctx context.Context, projectID int64) error
+	GetWildcardCertificate(ctx context.Context, id int64) (*WildcardCertificate, error)
+	GetWildcardCertificateByDomain(ctx context.Context, domain string) (*WildcardCertificate, error)
+	ListWildcardCertificates(ctx context.Context) ([]*WildcardCertificate, error)
+	SaveWildcardCertificate(ctx context.Context, c *WildcardCertificate) error
+	DeleteWildcardCertificate(ctx context.Context, id int64) error
+
+	// Catalog blueprints
+	ListBlueprintDefinitions(ctx context.Context) ([]*BlueprintDefinition, error)
+	SaveBlueprintDefinition(ctx context.Context, d *BlueprintDefinition) error
+	DeleteBlueprintDefinition(ctx context.Context, blueprintType string) error
+	CountServicesByType(ctx context.Context, serviceType string) (int, error)
+
+	// Service template methods
+	ListServiceTemplates(ctx context.Context) ([]*ServiceTemplate, error)
+	GetServiceTemplate(ctx context.Context, id int64) (*ServiceTemplate, error)
+	CreateServiceTemplate(ctx context.Context, t *ServiceTemplate) error
+	UpdateServiceTemplate(ctx context.Context, t *ServiceTemplate) error
+	DeleteServiceTemplate(ctx context.Context, id int64) error
+
+	// Managed .env methods (secret values are stored encrypted; see internal/secrets)
+	ListEnvVars(ctx context.Context, serviceID int64) ([]*EnvVar, error)
+	SetEnvVar(ctx context.Context, v *EnvVar) error
+	DeleteEnvVar(ctx context.Context, serviceID int64, key string) error
+	ListProjectEnvVars(ctx context.Context, projectID int64) ([]*EnvVar, error)
+	SetProjectEnvVar(ctx context.Context, v *EnvVar) error
+	DeleteProjectEnvVar(ctx context.Context, projectID int64, key string) error
+	GetEnvFile(ctx context.Context, serviceID int64) (*EnvFile, error)
+	SetEnvFilePath(ctx context.Context, serviceID int64, path string) error
+	RecordEnvFileWrite(ctx context.Context, serviceID int64, checksum string) error
+
+	// Generated file methods (private files are stored encrypted; see internal/audit)
+	SaveArtifact(ctx context.Context, a *Artifact) error
+	DeleteArtifacts(ctx context.Context, path string) error
+	GetArtifact(ctx context.Context, path string) (*Artifact, error)
+	ListArtifacts(ctx context.Context, filter ArtifactFilter) ([]*Artifact, error)
+	SetUnitEnabled(ctx context.Context, unit string, projectID, serviceID int64, enabled bool) error
+	ListEnabledUnits(ctx context.Context) ([]*EnabledUnit, error)
+
+	// Host methods (agent tokens are stored encrypted; see internal/secrets)
+	RegisterHost(ctx context.Context, h *Host) error
+	GetHost(ctx context.Context, id int64) (*Host, error)
+	GetHostByName(ctx context.Context, name string) (*Host, error)
+	ListHosts(ctx context.Context) ([]*Host, error)
+	DeleteHost(ctx context.Context, id int64) error
+	TouchHost(ctx context.Context, id int64, seenAt time.Time) error
+	SetProjectHost(ctx context.Context, projectID, hostID int64) (*Project, error)
+	UnitHostID(ctx context.Context, unit string) (int64, error)
+
+	// Maintenance methods
+	CheckIntegrity(ctx context.Context, repair bool) (*IntegrityReport, error)
 
 	// Settings methods
 	GetSetting(ctx context.Context, key string) (string, error)
 	SetSetting(ctx context.Context, key string, value string) error
+	GetSettingBool(ctx context.Context, key string) (bool, error)
+	GetSettingInt(ctx context.Context, key string) (int, error)
+	ListSettings(ctx context.Context) ([]*Setting, error)
+
+	// Search methods
+	Search(ctx context.Context, query string, limit int) ([]*SearchResult, error)
 
+	Ping(ctx context.Context) error
 	Close() error
 }
 
+// Connection pool limits. SQLite allows a single writer at a time; WAL lets
+// readers proceed concurrently and busy_timeout makes writers wait for the lock
+// instead of failing with "database is locked".
+const (
+	maxOpenConns  = 8
+	maxIdleConns  = 8
+	busyTimeoutMS = 5000
+)
+
 // Storage handles all database operations and implements the Store interface
 type Storage struct {
-	db *sql.DB
+	db    *sql.DB
+	stmts *statements
 }
 
 // New creates a new Storage instance and initializes the database
 func New(dbPath string) (*Storage, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", dsn(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
 
-	// Enable WAL mode for better concurrent access
-	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
-		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
-	}
-
-	// Enable Foreign Keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	s := &Storage{db: db}
 
 	if err := s.migrate(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if err := s.verifyForeignKeys(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if s.stmts, err = prepareStatements(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.ensureSearchIndex(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to build search index: %w", err)
+	}
+
+	if err := s.failInterruptedDeployments(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to recover deployments: %w", err)
+	}
+
+	if err := s.failInterruptedJobs(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to recover jobs: %w", err)
+	}
+
+	if err := s.failInterruptedDeliveries(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to recover webhook and notification deliveries: %w", err)
+	}
+
 	return s, nil
 }
 
-// Close closes the database connection
+// dsn builds the connection string. Pragmas are passed through the DSN so the
+// driver applies them to every pooled connection, not just the first one.
+func dsn(dbPath string) string {
+	return "file:" + dbPath + "?" +
+		"_pragma=" + url.QueryEscape(fmt.Sprintf("busy_timeout(%d)", busyTimeoutMS)) +
+		"&_pragma=" + url.QueryEscape("foreign_keys(1)") +
+		"&_pragma=" + url.QueryEscape("journal_mode(WAL)") +
+		"&_pragma=" + url.QueryEscape("synchronous(NORMAL)") +
+		"&_txlock=immediate"
+}
+
+// Ping checks that the database answers a query
+func (s *Storage) Ping(ctx context.Context) error {
+	var one int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+	return nil
+}
+
+// Close closes the prepared statements and the database connection
 func (s *Storage) Close() error {
+	if s.stmts != nil {
+		s.stmts.close()
+	}
 	return s.db.Close()
 }
 
@@ -135,47 +386,731 @@ func (s *Storage) migrate() error {
 			return fmt.Errorf("failed to create v2 schema: %w", err)
 		}
 		// ... existing migration logic ...
-	} else {
-		// Incremental migration for Phase 5 (Expert Overrides)
-		_, err = s.db.Exec("ALTER TABLE services ADD COLUMN systemd_raw TEXT")
-		if err != nil && !isColumnExistsError(err) {
-			return fmt.Errorf("failed to add systemd_raw column: %w", err)
-		}
+	}
+
+	// Incremental migrations run on every start so fresh installs pick them up too.
+	// Migration for Phase 5 (Expert Overrides)
+	_, err = s.db.Exec("ALTER TABLE services ADD COLUMN systemd_raw TEXT")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add systemd_raw column: %w", err)
+	}
+
+	_, err = s.db.Exec("ALTER TABLE services ADD COLUMN nginx_raw TEXT")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add nginx_raw column: %w", err)
+	}
+
+	// Migration for Phase 7 (Nginx at project level)
+	_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN domain TEXT")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add domain column: %w", err)
+	}
+
+	_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN nginx_raw TEXT")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add nginx_raw column to projects: %w", err)
+	}
+
+	// Migration for Phase 7 (Service port for Nginx proxy)
+	_, err = s.db.Exec("ALTER TABLE services ADD COLUMN port INTEGER DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add port column: %w", err)
+	}
+
+	// Settings table
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create settings table: %w", err)
+	}
 
-		_, err = s.db.Exec("ALTER TABLE services ADD COLUMN nginx_raw TEXT")
+	// Markdown runbook notes
+	for _, table := range []string{"projects", "services"} {
+		_, err = s.db.Exec("ALTER TABLE " + table + " ADD COLUMN notes TEXT NOT NULL DEFAULT ''")
 		if err != nil && !isColumnExistsError(err) {
-			return fmt.Errorf("failed to add nginx_raw column: %w", err)
+			return fmt.Errorf("failed to add %s notes column: %w", table, err)
 		}
+	}
 
-		// Migration for Phase 7 (Nginx at project level)
-		_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN domain TEXT")
+	// Tags for filtering, stored as ",a,b,"
+	for _, table := range []string{"projects", "services"} {
+		_, err = s.db.Exec("ALTER TABLE " + table + " ADD COLUMN tags TEXT NOT NULL DEFAULT ''")
 		if err != nil && !isColumnExistsError(err) {
-			return fmt.Errorf("failed to add domain column: %w", err)
+			return fmt.Errorf("failed to add %s tags column: %w", table, err)
 		}
+	}
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_services_type ON services(type)"); err != nil {
+		return fmt.Errorf("failed to create service type index: %w", err)
+	}
 
-		_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN nginx_raw TEXT")
+	// Container runtimes: "" is systemd. Git branches: "" is the remote's
+	// default. Git filters: "" is a full clone.
+	for _, column := range []string{"runtime", "image", "git_branch", "git_filter"} {
+		_, err = s.db.Exec("ALTER TABLE services ADD COLUMN " + column + " TEXT NOT NULL DEFAULT ''")
 		if err != nil && !isColumnExistsError(err) {
-			return fmt.Errorf("failed to add nginx_raw column to projects: %w", err)
+			return fmt.Errorf("failed to add service %s column: %w", column, err)
 		}
+	}
 
-		// Migration for Phase 7 (Service port for Nginx proxy)
-		_, err = s.db.Exec("ALTER TABLE services ADD COLUMN port INTEGER DEFAULT 0")
-		if err != nil && !isColumnExistsError(err) {
-			return fmt.Errorf("failed to add port column: %w", err)
+	// Service configuration history
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS service_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			changes TEXT NOT NULL,
+			snapshot TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_service_revisions_service_id ON service_revisions(service_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create service_revisions table: %w", err)
+	}
+
+	// Encrypted secrets referenced from service environments as ${secret:KEY}
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS secrets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT 'global',
+			value BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(key, scope)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create secrets table: %w", err)
+	}
+
+	// Audit trail of host actions. Entries outlive the services they refer to,
+	// so there are intentionally no foreign keys here.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER,
+			service_id INTEGER,
+			actor TEXT NOT NULL,
+			category TEXT NOT NULL,
+			action TEXT NOT NULL,
+			command TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			success INTEGER NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_audit_entries_service_id ON audit_entries(service_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_entries_project_id ON audit_entries(project_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create audit_entries table: %w", err)
+	}
+
+	// Events published on the bus, kept for the activity feed. Like audit
+	// entries they outlive the services they refer to.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			project_id INTEGER,
+			service_id INTEGER,
+			data TEXT NOT NULL DEFAULT '{}',
+			failed INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			created_us INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_project_id ON events(project_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create events table: %w", err)
+	}
+
+	// The activity feed orders audit entries and events together by
+	// created_us, the time in unix microseconds: created_at holds the
+	// driver's text for a time.Time, which does not sort across offsets
+	for _, table := range []string{"audit_entries", "events"} {
+		if err := s.addUnixTime(table); err != nil {
+			return err
 		}
+	}
+
+	// The last content of every file Servio generates, by path, to rebuild a
+	// host from. Rows outlive their project and service until the file is
+	// removed, as the file does.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS artifacts (
+			path TEXT PRIMARY KEY,
+			category TEXT NOT NULL,
+			project_id INTEGER,
+			service_id INTEGER,
+			mode TEXT NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			checksum TEXT NOT NULL,
+			encrypted INTEGER NOT NULL DEFAULT 0,
+			content BLOB NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_artifacts_service_id ON artifacts(service_id);
+		CREATE INDEX IF NOT EXISTS idx_artifacts_project_id ON artifacts(project_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create artifacts table: %w", err)
+	}
+
+	// Units Servio enabled, which systemd keeps as symlinks beside the artifacts
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS enabled_units (
+			unit TEXT PRIMARY KEY,
+			project_id INTEGER,
+			service_id INTEGER,
+			enabled_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create enabled_units table: %w", err)
+	}
+
+	// Deploy pipeline runs
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL,
+			commit_sha TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			log TEXT NOT NULL DEFAULT '',
+			started_at DATETIME,
+			finished_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_deployments_service_id ON deployments(service_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create deployments table: %w", err)
+	}
+
+	// Background jobs (install, provision, deploy). No foreign keys: like audit
+	// entries, job history is kept after the service or project is deleted.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			project_id INTEGER NOT NULL DEFAULT 0,
+			service_id INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			log TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			started_at DATETIME,
+			finished_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_jobs_service_id ON jobs(service_id);
+		CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create jobs table: %w", err)
+	}
+
+	_, err = s.db.Exec("ALTER TABLE deployments ADD COLUMN job_id INTEGER NOT NULL DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add job_id column to deployments: %w", err)
+	}
+
+	// Outbound webhooks and their delivery history
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			secret BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_status INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME,
+			FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook tables: %w", err)
+	}
+
+	// Teams own projects; members only see their teams' projects
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS teams (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS team_members (
+			team_id INTEGER NOT NULL,
+			member TEXT NOT NULL,
+			PRIMARY KEY(team_id, member),
+			FOREIGN KEY(team_id) REFERENCES teams(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_team_members_member ON team_members(member);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create team tables: %w", err)
+	}
+
+	_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN team_id INTEGER REFERENCES teams(id) ON DELETE SET NULL")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add team_id column to projects: %w", err)
+	}
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_projects_team_id ON projects(team_id)"); err != nil {
+		return fmt.Errorf("failed to create project team index: %w", err)
+	}
+
+	// Periodic host and service resource samples. Like audit entries, samples
+	// outlive the services they describe, so service_id is not a foreign key.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS metric_samples (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL DEFAULT 0,
+			service TEXT NOT NULL DEFAULT '',
+			cpu_percent REAL NOT NULL DEFAULT 0,
+			memory_percent REAL NOT NULL DEFAULT 0,
+			memory_mb REAL NOT NULL DEFAULT 0,
+			disk_percent REAL NOT NULL DEFAULT 0,
+			taken_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_metric_samples_taken_at ON metric_samples(taken_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create metric_samples table: %w", err)
+	}
+
+	// Custom metrics services push, summed up per minute. Unlike host samples
+	// they are only shown with their service, so they go when it does.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS app_metric_samples (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			value REAL NOT NULL DEFAULT 0,
+			count INTEGER NOT NULL DEFAULT 0,
+			taken_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_app_metric_samples_service ON app_metric_samples(service_id, taken_at);
+		CREATE INDEX IF NOT EXISTS idx_app_metric_samples_taken_at ON app_metric_samples(taken_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create app_metric_samples table: %w", err)
+	}
+
+	// Per-service journal retention policies, enforced on a schedule
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS journal_retention (
+			service_id INTEGER PRIMARY KEY,
+			max_size TEXT NOT NULL DEFAULT '',
+			max_age TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create journal_retention table: %w", err)
+	}
+
+	// Services whose journal is forwarded, with the cursor of the last entry shipped
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS log_forwarding (
+			service_id INTEGER PRIMARY KEY,
+			cursor TEXT NOT NULL DEFAULT '',
+			enabled_at DATETIME NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create log_forwarding table: %w", err)
+	}
+
+	// Services that log to rotated files instead of the journal
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS file_logging (
+			service_id INTEGER PRIMARY KEY,
+			path TEXT NOT NULL,
+			rotate INTEGER NOT NULL,
+			frequency TEXT NOT NULL,
+			max_size TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create file_logging table: %w", err)
+	}
+
+	// Log alert rules and the incidents recorded when they fire. Incidents
+	// outlive their rule but not their service.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS log_alerts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			threshold INTEGER NOT NULL DEFAULT 0,
+			interval_seconds INTEGER NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_log_alerts_service_id ON log_alerts(service_id);
+		CREATE TABLE IF NOT EXISTS log_incidents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			alert_id INTEGER NOT NULL,
+			service_id INTEGER NOT NULL,
+			alert TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			matches INTEGER NOT NULL,
+			sample TEXT NOT NULL DEFAULT '',
+			fired_at DATETIME NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_log_incidents_service_id ON log_incidents(service_id, fired_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create log alert tables: %w", err)
+	}
 
-		// Settings table
-		_, err = s.db.Exec(`
-			CREATE TABLE IF NOT EXISTS settings (
-				key TEXT PRIMARY KEY,
-				value TEXT
-			)
-		`)
+	// Agent hosts. Deleting a host is refused while projects run on it.
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS hosts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			url TEXT NOT NULL,
+			token BLOB NOT NULL,
+			last_seen_at DATETIME,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create hosts table: %w", err)
+	}
+	_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN host_id INTEGER REFERENCES hosts(id)")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add host_id column to projects: %w", err)
+	}
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_projects_host_id ON projects(host_id)"); err != nil {
+		return fmt.Errorf("failed to create project host index: %w", err)
+	}
+	// A service's port is unique on its host, so services keep a copy of
+	// their project's host for the port index
+	_, err = s.db.Exec("ALTER TABLE services ADD COLUMN host_id INTEGER NOT NULL DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add host_id column to services: %w", err)
+	}
+	if err == nil {
+		_, err = s.db.Exec("UPDATE services SET host_id = COALESCE((SELECT host_id FROM projects WHERE projects.id = services.project_id), 0)")
 		if err != nil {
-			return fmt.Errorf("failed to create settings table: %w", err)
+			return fmt.Errorf("failed to fill in service hosts: %w", err)
+		}
+	}
+	if err := s.ensurePortIndex(); err != nil {
+		return fmt.Errorf("failed to create port index: %w", err)
+	}
+
+	// Cron jobs; a job's units are removed before its row
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS cron_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			schedule TEXT NOT NULL,
+			command TEXT NOT NULL,
+			working_dir TEXT NOT NULL DEFAULT '',
+			user TEXT NOT NULL DEFAULT 'root',
+			environment TEXT NOT NULL DEFAULT '',
+			timeout_seconds INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			last_run_at DATETIME,
+			last_status TEXT NOT NULL DEFAULT '',
+			last_exit_code INTEGER NOT NULL DEFAULT 0,
+			last_duration_ms INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
+			UNIQUE(project_id, name)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create cron jobs table: %w", err)
+	}
+
+	// Database dumps of postgres services; the files are kept when a row goes
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS database_backups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL,
+			service_id INTEGER NOT NULL,
+			database TEXT NOT NULL,
+			path TEXT NOT NULL,
+			size_bytes INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			job_id INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			finished_at DATETIME,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_database_backups_project_id ON database_backups(project_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create database backups table: %w", err)
+	}
+
+	// Variables of services' managed .env files, those their projects share,
+	// and where each file goes
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS env_vars (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL DEFAULT '',
+			secret BOOLEAN NOT NULL DEFAULT 0,
+			ciphertext BLOB,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE,
+			UNIQUE(service_id, key)
+		);
+		CREATE TABLE IF NOT EXISTS project_env_vars (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL DEFAULT '',
+			secret BOOLEAN NOT NULL DEFAULT 0,
+			ciphertext BLOB,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
+			UNIQUE(project_id, key)
+		);
+		CREATE TABLE IF NOT EXISTS env_files (
+			service_id INTEGER PRIMARY KEY,
+			path TEXT NOT NULL DEFAULT '',
+			checksum TEXT NOT NULL DEFAULT '',
+			written_at DATETIME,
+			FOREIGN KEY(service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create env tables: %w", err)
+	}
+
+	// Chat and webhook channels notified of events, and their delivery history
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			chat_id TEXT NOT NULL DEFAULT '',
+			events TEXT NOT NULL DEFAULT '',
+			project_id INTEGER,
+			failures_only BOOLEAN NOT NULL DEFAULT 0,
+			template TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			secret BLOB NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS notification_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			message TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_status INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			delivered_at DATETIME,
+			FOREIGN KEY(channel_id) REFERENCES notification_channels(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_notification_deliveries_channel_id ON notification_deliveries(channel_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create notification tables: %w", err)
+	}
+
+	// Let's Encrypt certificates of project sites
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS certificates (
+			project_id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			domains TEXT NOT NULL DEFAULT '',
+			provider TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			job_id INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS wildcard_certificates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			domain TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			job_id INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate tables: %w", err)
+	}
+
+	// Service replicas: 0 and 1 both run a single plain unit
+	_, err = s.db.Exec("ALTER TABLE services ADD COLUMN replicas INTEGER NOT NULL DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add service replicas column: %w", err)
+	}
+
+	// Blueprints installed from the remote catalog, registered at startup
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS blueprint_definitions (
+			type TEXT PRIMARY KEY,
+			definition TEXT NOT NULL,
+			sha256 TEXT NOT NULL,
+			source TEXT NOT NULL,
+			installed_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create blueprint definitions table: %w", err)
+	}
+
+	// Services saved as templates for creating others like them
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS service_templates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			spec TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create service templates table: %w", err)
+	}
+
+	// Per-project memory and CPU budgets, enforced by each project's slice
+	_, err = s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS project_budgets (
+			project_id INTEGER PRIMARY KEY,
+			memory_max TEXT NOT NULL DEFAULT '',
+			cpu_quota TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create project_budgets table: %w", err)
+	}
+
+	// HTTP/2 and HTTP/3 toggles of project sites
+	for _, column := range []string{"http2", "http3"} {
+		_, err = s.db.Exec("ALTER TABLE projects ADD COLUMN " + column + " INTEGER NOT NULL DEFAULT 0")
+		if err != nil && !isColumnExistsError(err) {
+			return fmt.Errorf("failed to add %s column to projects: %w", column, err)
 		}
 	}
 
+	// Full-text search index over projects and services
+	_, err = s.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+			kind UNINDEXED,
+			ref_id UNINDEXED,
+			project_id UNINDEXED,
+			name,
+			description,
+			content,
+			tokenize = 'unicode61'
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	return nil
+}
+
+// addUnixTime adds created_us to table, filled in from created_at for the
+// rows written before it, and indexes it. The column is declared as in a
+// new events table; 0 marks a row still to fill in.
+func (s *Storage) addUnixTime(table string) error {
+	_, err := s.db.Exec("ALTER TABLE " + table + " ADD COLUMN created_us INTEGER NOT NULL DEFAULT 0")
+	if err != nil && !isColumnExistsError(err) {
+		return fmt.Errorf("failed to add %s created_us column: %w", table, err)
+	}
+	// The driver parses created_at back into a time when it is read
+	// from the column itself
+	rows, err := s.db.Query("SELECT id, created_at FROM " + table + " WHERE created_us = 0")
+	if err != nil {
+		return fmt.Errorf("failed to read %s times: %w", table, err)
+	}
+	times := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var createdAt sql.NullTime
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s time: %w", table, err)
+		}
+		times[id] = createdAt.Time.UnixMicro()
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s times: %w", table, err)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, us := range times {
+		if _, err := tx.Exec("UPDATE "+table+" SET created_us = ? WHERE id = ?", us, id); err != nil {
+			return fmt.Errorf("failed to fill in %s created_us: %w", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to fill in %s created_us: %w", table, err)
+	}
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_" + table + "_created_us ON " + table + "(created_us)"); err != nil {
+		return fmt.Errorf("failed to index %s created_us: %w", table, err)
+	}
 	return nil
 }
 
@@ -190,6 +1125,11 @@ func isColumnExistsError(err error) bool {
 		strings.Contains(errStr, "no column named")
 }
 
+// isUniqueConstraintError checks if the error is due to a duplicate value in a unique column
+func isUniqueConstraintError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
 // DB returns the underlying database connection for advanced queries
 func (s *Storage) DB() *sql.DB {
 	return s.db
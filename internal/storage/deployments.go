@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// defaultDeploymentLimit caps deployment listings when no limit is given
+const defaultDeploymentLimit = 50
+
+// CreateDeployment inserts a new deployment. Status defaults to pending and
+// the actor is taken from the context when not set.
+func (s *Storage) CreateDeployment(ctx context.Context, d *Deployment) error {
+	if d.Status == "" {
+		d.Status = DeploymentPending
+	}
+	if d.Actor == "" {
+		d.Actor = ActorFromContext(ctx)
+	}
+	d.CreatedAt = time.Now()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO deployments (service_id, commit_sha, status, actor, log, started_at, finished_at, created_at, job_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.ServiceID, d.Commit, d.Status, d.Actor, d.Log, d.StartedAt, d.FinishedAt, d.CreatedAt, d.JobID)
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+
+	d.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetDeployment retrieves a deployment by ID
+func (s *Storage) GetDeployment(ctx context.Context, id int64) (*Deployment, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, service_id, commit_sha, status, actor, log, started_at, finished_at, created_at, job_id
+		FROM deployments WHERE id = ?
+	`, id)
+
+	d, err := scanDeployment(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	return d, nil
+}
+
+// ListDeployments returns a service's deployments, newest first. Logs are
+// omitted from listings; fetch a single deployment to read its log.
+func (s *Storage) ListDeployments(ctx context.Context, serviceID int64, limit int) ([]*Deployment, error) {
+	if limit <= 0 {
+		limit = defaultDeploymentLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, service_id, commit_sha, status, actor, '', started_at, finished_at, created_at, job_id
+		FROM deployments WHERE service_id = ? ORDER BY id DESC LIMIT ?
+	`, serviceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	defer rows.Close()
+
+	deployments := []*Deployment{}
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan deployment: %w", err)
+		}
+		deployments = append(deployments, d)
+	}
+
+	return deployments, rows.Err()
+}
+
+// UpdateDeployment saves the mutable fields of a deployment (commit, status, log, timestamps, job)
+func (s *Storage) UpdateDeployment(ctx context.Context, d *Deployment) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployments SET commit_sha = ?, status = ?, log = ?, started_at = ?, finished_at = ?, job_id = ?
+		WHERE id = ?
+	`, d.Commit, d.Status, d.Log, d.StartedAt, d.FinishedAt, d.JobID, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update deployment: %w", err)
+	}
+	return nil
+}
+
+// DeleteDeployment deletes a deployment by ID
+func (s *Storage) DeleteDeployment(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM deployments WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+	return nil
+}
+
+// failInterruptedDeployments marks deployments left unfinished by a previous
+// process as failed, so they don't block new deployments forever
+func (s *Storage) failInterruptedDeployments(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE deployments SET status = ?, finished_at = ?, log = log || ?
+		WHERE finished_at IS NULL
+	`, DeploymentFailed, time.Now(), "deploy interrupted: servio was restarted\n")
+	return err
+}
+
+func scanDeployment(row rowScanner) (*Deployment, error) {
+	d := &Deployment{}
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.ServiceID, &d.Commit, &d.Status, &d.Actor, &d.Log,
+		&startedAt, &finishedAt, &d.CreatedAt, &d.JobID); err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		d.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		d.FinishedAt = &finishedAt.Time
+	}
+	return d, nil
+}
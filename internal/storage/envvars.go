@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ListEnvVars returns a service's managed variables by key
+func (s *Storage) ListEnvVars(ctx context.Context, serviceID int64) ([]*EnvVar, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, service_id, key, value, secret, ciphertext, updated_at FROM env_vars
+		WHERE service_id = ? ORDER BY key
+	`, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list env vars: %w", err)
+	}
+	defer rows.Close()
+
+	vars := []*EnvVar{}
+	for rows.Next() {
+		v := &EnvVar{}
+		if err := rows.Scan(&v.ID, &v.ServiceID, &v.Key, &v.Value, &v.Secret, &v.Ciphertext, &v.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan env var: %w", err)
+		}
+		vars = append(vars, v)
+	}
+	return vars, rows.Err()
+}
+
+// SetEnvVar creates or replaces a variable by service and key. A secret's
+// Value is not stored, only its Ciphertext.
+func (s *Storage) SetEnvVar(ctx context.Context, v *EnvVar) error {
+	v.UpdatedAt = time.Now()
+	value, ciphertext := v.Value, v.Ciphertext
+	if v.Secret {
+		value = ""
+	} else {
+		ciphertext = nil
+	}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO env_vars (service_id, key, value, secret, ciphertext, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(service_id, key) DO UPDATE SET value = excluded.value, secret = excluded.secret,
+			ciphertext = excluded.ciphertext, updated_at = excluded.updated_at
+		RETURNING id
+	`, v.ServiceID, v.Key, value, v.Secret, ciphertext, v.UpdatedAt).Scan(&v.ID)
+	if err != nil {
+		return fmt.Errorf("failed to set env var: %w", err)
+	}
+	return nil
+}
+
+// DeleteEnvVar removes a variable by service and key
+func (s *Storage) DeleteEnvVar(ctx context.Context, serviceID int64, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM env_vars WHERE service_id = ? AND key = ?", serviceID, key); err != nil {
+		return fmt.Errorf("failed to delete env var: %w", err)
+	}
+	return nil
+}
+
+// ListProjectEnvVars returns the variables a project's services share, by key
+func (s *Storage) ListProjectEnvVars(ctx context.Context, projectID int64) ([]*EnvVar, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, project_id, key, value, secret, ciphertext, updated_at FROM project_env_vars
+		WHERE project_id = ? ORDER BY key
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project env vars: %w", err)
+	}
+	defer rows.Close()
+
+	vars := []*EnvVar{}
+	for rows.Next() {
+		v := &EnvVar{}
+		if err := rows.Scan(&v.ID, &v.ProjectID, &v.Key, &v.Value, &v.Secret, &v.Ciphertext, &v.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project env var: %w", err)
+		}
+		vars = append(vars, v)
+	}
+	return vars, rows.Err()
+}
+
+// SetProjectEnvVar creates or replaces a shared variable by project and key.
+// As with SetEnvVar, a secret's Value is not stored.
+func (s *Storage) SetProjectEnvVar(ctx context.Context, v *EnvVar) error {
+	v.UpdatedAt = time.Now()
+	value, ciphertext := v.Value, v.Ciphertext
+	if v.Secret {
+		value = ""
+	} else {
+		ciphertext = nil
+	}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO project_env_vars (project_id, key, value, secret, ciphertext, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(project_id, key) DO UPDATE SET value = excluded.value, secret = excluded.secret,
+			ciphertext = excluded.ciphertext, updated_at = excluded.updated_at
+		RETURNING id
+	`, v.ProjectID, v.Key, value, v.Secret, ciphertext, v.UpdatedAt).Scan(&v.ID)
+	if err != nil {
+		return fmt.Errorf("failed to set project env var: %w", err)
+	}
+	return nil
+}
+
+// DeleteProjectEnvVar removes a shared variable by project and key
+func (s *Storage) DeleteProjectEnvVar(ctx context.Context, projectID int64, key string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM project_env_vars WHERE project_id = ? AND key = ?", projectID, key); err != nil {
+		return fmt.Errorf("failed to delete project env var: %w", err)
+	}
+	return nil
+}
+
+// GetEnvFile returns where a service's .env file goes, or nil if it has never been set or written
+func (s *Storage) GetEnvFile(ctx context.Context, serviceID int64) (*EnvFile, error) {
+	f := &EnvFile{}
+	var writtenAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT service_id, path, checksum, written_at FROM env_files WHERE service_id = ?", serviceID).
+		Scan(&f.ServiceID, &f.Path, &f.Checksum, &writtenAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get env file: %w", err)
+	}
+	if writtenAt.Valid {
+		f.WrittenAt = &writtenAt.Time
+	}
+	return f, nil
+}
+
+// SetEnvFilePath sets where a service's .env file goes; empty for the working directory
+func (s *Storage) SetEnvFilePath(ctx context.Context, serviceID int64, path string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO env_files (service_id, path) VALUES (?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET path = excluded.path
+	`, serviceID, path)
+	if err != nil {
+		return fmt.Errorf("failed to set env file path: %w", err)
+	}
+	return nil
+}
+
+// RecordEnvFileWrite stores the checksum of what was just written to a service's .env file
+func (s *Storage) RecordEnvFileWrite(ctx context.Context, serviceID int64, checksum string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO env_files (service_id, checksum, written_at) VALUES (?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET checksum = excluded.checksum, written_at = excluded.written_at
+	`, serviceID, checksum, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record env file write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrHostInUse is returned when deleting a host that projects still run on
+var ErrHostInUse = errors.New("host has projects")
+
+// RegisterHost adds an agent host, or updates the URL and token of the host
+// with the same name when an agent joins again
+func (s *Storage) RegisterHost(ctx context.Context, h *Host) error {
+	now := time.Now()
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO hosts (name, url, token, last_seen_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET url = excluded.url, token = excluded.token,
+			last_seen_at = excluded.last_seen_at, updated_at = excluded.updated_at
+		RETURNING id, created_at
+	`, h.Name, h.URL, h.Ciphertext, now, now, now).Scan(&h.ID, &h.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to register host: %w", err)
+	}
+	h.UpdatedAt, h.LastSeenAt = now, &now
+	return nil
+}
+
+// GetHost retrieves a host by ID
+func (s *Storage) GetHost(ctx context.Context, id int64) (*Host, error) {
+	h, err := scanHost(s.db.QueryRowContext(ctx, `
+		SELECT id, name, url, token, last_seen_at, created_at, updated_at FROM hosts WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host: %w", err)
+	}
+	return h, nil
+}
+
+// GetHostByName retrieves a host by name, or nil when none has it
+func (s *Storage) GetHostByName(ctx context.Context, name string) (*Host, error) {
+	h, err := scanHost(s.db.QueryRowContext(ctx, `
+		SELECT id, name, url, token, last_seen_at, created_at, updated_at FROM hosts WHERE name = ?
+	`, name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host: %w", err)
+	}
+	return h, nil
+}
+
+// ListHosts returns every host by name
+func (s *Storage) ListHosts(ctx context.Context) ([]*Host, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, url, token, last_seen_at, created_at, updated_at FROM hosts ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %w", err)
+	}
+	defer rows.Close()
+
+	hosts := []*Host{}
+	for rows.Next() {
+		h, err := scanHost(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan host: %w", err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// DeleteHost deletes a host, refusing with ErrHostInUse while projects are assigned to it
+func (s *Storage) DeleteHost(ctx context.Context, id int64) error {
+	var inUse bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM projects WHERE host_id = ?)", id).Scan(&inUse); err != nil {
+		return fmt.Errorf("failed to look up host projects: %w", err)
+	}
+	if inUse {
+		return fmt.Errorf("%w: move its projects to another host first", ErrHostInUse)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM hosts WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete host: %w", err)
+	}
+	return nil
+}
+
+// TouchHost records a successful contact with a host
+func (s *Storage) TouchHost(ctx context.Context, id int64, seenAt time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE hosts SET last_seen_at = ? WHERE id = ?", seenAt, id); err != nil {
+		return fmt.Errorf("failed to update host: %w", err)
+	}
+	return nil
+}
+
+// SetProjectHost assigns a project to a host, or back to this server when hostID is 0.
+// The project's units and site are not moved. Its services' ports must be free on the host.
+func (s *Storage) SetProjectHost(ctx context.Context, projectID, hostID int64) (*Project, error) {
+	if hostID != 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM hosts WHERE id = ?)", hostID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to look up host: %w", err)
+		}
+		if !exists {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "host_id", Message: "host not found"}}}
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkHostPorts(ctx, tx, projectID, hostID); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE projects SET host_id = ?, updated_at = ? WHERE id = ?", nullID(hostID), time.Now(), projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set project host: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE services SET host_id = ? WHERE project_id = ?", hostID, projectID); err != nil {
+		return nil, fmt.Errorf("failed to set project host: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit project host: %w", err)
+	}
+	return s.GetProject(ctx, projectID)
+}
+
+// UnitHostID returns the host running a systemd unit, found through the
+// service it was generated for; 0 means this server, including for units
+// Servio does not manage
+func (s *Storage) UnitHostID(ctx context.Context, unit string) (int64, error) {
+	var hostID int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(p.host_id, 0) FROM services s JOIN projects p ON p.id = s.project_id
+		WHERE 'servio-' || s.name || '.service' = ?
+		ORDER BY p.host_id DESC LIMIT 1
+	`, unit).Scan(&hostID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up unit host: %w", err)
+	}
+	return hostID, nil
+}
+
+func scanHost(row rowScanner) (*Host, error) {
+	h := &Host{}
+	var lastSeen sql.NullTime
+	if err := row.Scan(&h.ID, &h.Name, &h.URL, &h.Ciphertext, &lastSeen, &h.CreatedAt, &h.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		h.LastSeenAt = &lastSeen.Time
+	}
+	return h, nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrForeignKeysDisabled is returned when the driver did not enable foreign key enforcement
+var ErrForeignKeysDisabled = errors.New("sqlite foreign key enforcement is disabled")
+
+// Rows referencing projects or services through columns that cannot carry a
+// foreign key: secret scopes ("project:ID") and search index entries
+const (
+	orphanSecretsWhere = ` WHERE scope LIKE 'project:%' AND CAST(SUBSTR(scope, 9) AS INTEGER) NOT IN (SELECT id FROM projects)`
+	orphanSearchWhere  = ` WHERE (kind = ? AND ref_id NOT IN (SELECT id FROM projects)) OR (kind = ? AND ref_id NOT IN (SELECT id FROM services))`
+)
+
+// CheckIntegrity runs SQLite's integrity and foreign key checks and looks for
+// rows that reference projects or services without a real foreign key
+// (project-scoped secrets and search entries). With repair set, offending rows
+// are deleted; deleting an orphaned service cascades to its history.
+func (s *Storage) CheckIntegrity(ctx context.Context, repair bool) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		Errors:               []string{},
+		ForeignKeyViolations: []ForeignKeyViolation{},
+	}
+
+	rows, err := s.db.QueryContext(ctx, "PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		if line != "ok" {
+			report.Errors = append(report.Errors, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+
+	report.ForeignKeyViolations, err = s.foreignKeyViolations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM secrets"+orphanSecretsWhere).Scan(&report.OrphanSecrets); err != nil {
+		return nil, fmt.Errorf("failed to count orphaned secrets: %w", err)
+	}
+
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM search_index"+orphanSearchWhere,
+		SearchKindProject, SearchKindService).Scan(&report.OrphanSearchEntries); err != nil {
+		return nil, fmt.Errorf("failed to count orphaned search entries: %w", err)
+	}
+
+	report.OK = len(report.Errors) == 0 && len(report.ForeignKeyViolations) == 0 &&
+		report.OrphanSecrets == 0 && report.OrphanSearchEntries == 0
+
+	if repair && !report.OK {
+		if err := s.repairOrphans(ctx, report); err != nil {
+			return nil, err
+		}
+		report.Repaired = true
+	}
+
+	return report, nil
+}
+
+// foreignKeyViolations lists rows whose parent row no longer exists
+func (s *Storage) foreignKeyViolations(ctx context.Context) ([]ForeignKeyViolation, error) {
+	rows, err := s.db.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run foreign key check: %w", err)
+	}
+	defer rows.Close()
+
+	violations := []ForeignKeyViolation{}
+	for rows.Next() {
+		var v ForeignKeyViolation
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&v.Table, &rowID, &v.Parent, &fkID); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key check: %w", err)
+		}
+		v.RowID = rowID.Int64
+		violations = append(violations, v)
+	}
+	return violations, rows.Err()
+}
+
+// repairOrphans deletes the rows reported by CheckIntegrity in a single transaction.
+// Corruption reported by integrity_check cannot be repaired here and is left as is.
+func (s *Storage) repairOrphans(ctx context.Context, report *IntegrityReport) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin repair: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, v := range report.ForeignKeyViolations {
+		// Table names come from sqlite itself, never from user input
+		query := fmt.Sprintf(`DELETE FROM "%s" WHERE rowid = ?`, strings.ReplaceAll(v.Table, `"`, `""`))
+		if _, err := tx.ExecContext(ctx, query, v.RowID); err != nil {
+			return fmt.Errorf("failed to delete orphaned %s row %d: %w", v.Table, v.RowID, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM secrets"+orphanSecretsWhere); err != nil {
+		return fmt.Errorf("failed to delete orphaned secrets: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM search_index"+orphanSearchWhere, SearchKindProject, SearchKindService); err != nil {
+		return fmt.Errorf("failed to delete orphaned search entries: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit repair: %w", err)
+	}
+
+	slog.Info("Repaired database integrity", "foreign_key_violations", len(report.ForeignKeyViolations),
+		"orphan_secrets", report.OrphanSecrets, "orphan_search_entries", report.OrphanSearchEntries)
+	return nil
+}
+
+// verifyForeignKeys confirms that foreign keys are enforced on the connection pool
+// and warns about rows orphaned before enforcement was in place
+func (s *Storage) verifyForeignKeys(ctx context.Context) error {
+	var enabled int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil {
+		return fmt.Errorf("failed to read foreign_keys pragma: %w", err)
+	}
+	if enabled != 1 {
+		return ErrForeignKeysDisabled
+	}
+
+	violations, err := s.foreignKeyViolations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		slog.Warn("Database has rows with missing parents; run POST /api/admin/integrity/repair",
+			"violations", len(violations))
+	}
+	return nil
+}
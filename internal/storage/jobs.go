@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultJobLimit caps job listings when no limit is given
+const defaultJobLimit = 50
+
+// CreateJob inserts a new job. Status defaults to queued and the actor is
+// taken from the context when not set.
+func (s *Storage) CreateJob(ctx context.Context, j *Job) error {
+	if j.Status == "" {
+		j.Status = JobQueued
+	}
+	if j.Actor == "" {
+		j.Actor = ActorFromContext(ctx)
+	}
+	j.CreatedAt = time.Now()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO jobs (kind, project_id, service_id, status, actor, log, error, started_at, finished_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, j.Kind, j.ProjectID, j.ServiceID, j.Status, j.Actor, j.Log, j.Error, j.StartedAt, j.FinishedAt, j.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	j.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetJob retrieves a job by ID
+func (s *Storage) GetJob(ctx context.Context, id int64) (*Job, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, kind, project_id, service_id, status, actor, log, error, started_at, finished_at, created_at
+		FROM jobs WHERE id = ?
+	`, id)
+
+	j, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err == nil {
+		if _, scoped := TeamScopeFromContext(ctx); scoped {
+			if ok, err := s.projectInScope(ctx, j.ProjectID); err != nil || !ok {
+				return nil, err
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return j, nil
+}
+
+// ListJobs returns jobs matching the filter, newest first. Logs are omitted
+// from listings; fetch a single job to read its log.
+func (s *Storage) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultJobLimit
+	}
+
+	conds, args := scopeConds(ctx, "project_id")
+	if filter.ProjectID != 0 {
+		conds = append(conds, "project_id = ?")
+		args = append(args, filter.ProjectID)
+	}
+	if filter.ServiceID != 0 {
+		conds = append(conds, "service_id = ?")
+		args = append(args, filter.ServiceID)
+	}
+	if filter.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, filter.Status)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, kind, project_id, service_id, status, actor, '', error, started_at, finished_at, created_at
+		FROM jobs `+where+` ORDER BY id DESC LIMIT ?
+	`, append(args, filter.Limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// UpdateJob saves the mutable fields of a job (status, log, error, timestamps)
+func (s *Storage) UpdateJob(ctx context.Context, j *Job) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, log = ?, error = ?, started_at = ?, finished_at = ?
+		WHERE id = ?
+	`, j.Status, j.Log, j.Error, j.StartedAt, j.FinishedAt, j.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// failInterruptedJobs marks jobs left queued or running by a previous process
+// as failed; their work was held in memory and cannot be resumed
+func (s *Storage) failInterruptedJobs(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, finished_at = ?, error = ?
+		WHERE finished_at IS NULL
+	`, JobFailed, time.Now(), "interrupted: servio was restarted")
+	return err
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	j := &Job{}
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&j.ID, &j.Kind, &j.ProjectID, &j.ServiceID, &j.Status, &j.Actor, &j.Log, &j.Error,
+		&startedAt, &finishedAt, &j.CreatedAt); err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return j, nil
+}
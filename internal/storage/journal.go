@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetJournalRetention returns a service's retention policy, or nil if it has none
+func (s *Storage) GetJournalRetention(ctx context.Context, serviceID int64) (*JournalRetention, error) {
+	r := &JournalRetention{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT service_id, max_size, max_age, updated_at FROM journal_retention WHERE service_id = ?
+	`, serviceID).Scan(&r.ServiceID, &r.MaxSize, &r.MaxAge, &r.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal retention: %w", err)
+	}
+	return r, nil
+}
+
+// ListJournalRetentions returns every retention policy, ordered by service
+func (s *Storage) ListJournalRetentions(ctx context.Context) ([]*JournalRetention, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service_id, max_size, max_age, updated_at FROM journal_retention ORDER BY service_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list journal retention: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []*JournalRetention{}
+	for rows.Next() {
+		r := &JournalRetention{}
+		if err := rows.Scan(&r.ServiceID, &r.MaxSize, &r.MaxAge, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan journal retention: %w", err)
+		}
+		policies = append(policies, r)
+	}
+	return policies, rows.Err()
+}
+
+// SetJournalRetention creates or replaces a service's retention policy
+func (s *Storage) SetJournalRetention(ctx context.Context, r *JournalRetention) error {
+	r.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO journal_retention (service_id, max_size, max_age, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET max_size = excluded.max_size, max_age = excluded.max_age, updated_at = excluded.updated_at
+	`, r.ServiceID, r.MaxSize, r.MaxAge, r.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set journal retention: %w", err)
+	}
+	return nil
+}
+
+// DeleteJournalRetention removes a service's retention policy
+func (s *Storage) DeleteJournalRetention(ctx context.Context, serviceID int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM journal_retention WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to delete journal retention: %w", err)
+	}
+	return nil
+}
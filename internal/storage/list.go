@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxListLimit caps the page size a caller may request
+const MaxListLimit = 500
+
+// projectSortColumns and serviceSortColumns whitelist sortable columns so
+// user-supplied sort keys are never interpolated into SQL directly
+var (
+	projectSortColumns = map[string]string{
+		"id":         "id",
+		"name":       "name",
+		"domain":     "domain",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	}
+	serviceSortColumns = map[string]string{
+		"id":         "id",
+		"name":       "name",
+		"type":       "type",
+		"port":       "port",
+		"created_at": "created_at",
+		"updated_at": "updated_at",
+	}
+)
+
+// ErrInvalidSort is returned when a sort key is not in the whitelist
+var ErrInvalidSort = errors.New("invalid sort field")
+
+// ListProjectsPage retrieves a page of projects along with the total project count
+func (s *Storage) ListProjectsPage(ctx context.Context, opts ListOptions) ([]*Project, int, error) {
+	order, err := orderClause(opts.Sort, projectSortColumns, "name ASC")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where, args := projectFilter(ctx, opts)
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM projects"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count projects: %w", err)
+	}
+
+	query := "SELECT " + projectColumns + " FROM projects" + where + " ORDER BY " + order + limitClause(opts)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list projects: %w", err)
+	}
+	defer rows.Close()
+
+	projects := []*Project{}
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, total, rows.Err()
+}
+
+// ListServicesPage retrieves a page of services along with the total count.
+// A zero projectID lists services across all projects.
+func (s *Storage) ListServicesPage(ctx context.Context, projectID int64, opts ListOptions) ([]*Service, int, error) {
+	order, err := orderClause(opts.Sort, serviceSortColumns, "name ASC")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where, args := serviceFilter(ctx, projectID, opts)
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM services"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count services: %w", err)
+	}
+
+	query := "SELECT " + serviceColumns + " FROM services" + where + " ORDER BY " + order + limitClause(opts)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list services: %w", err)
+	}
+	defer rows.Close()
+
+	services := []*Service{}
+	for rows.Next() {
+		sv, err := scanService(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan service: %w", err)
+		}
+		services = append(services, sv)
+	}
+
+	return services, total, rows.Err()
+}
+
+// projectFilter renders the WHERE clause for project list filters
+func projectFilter(ctx context.Context, opts ListOptions) (string, []interface{}) {
+	conds, args := scopeConds(ctx, "id")
+	if opts.Tag != "" {
+		conds = append(conds, tagMatch)
+		args = append(args, opts.Tag)
+	}
+	if opts.Type != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM services WHERE services.project_id = projects.id AND services.type = ?)")
+		args = append(args, opts.Type)
+	}
+	return whereClause(conds), args
+}
+
+// serviceFilter renders the WHERE clause for service list filters
+func serviceFilter(ctx context.Context, projectID int64, opts ListOptions) (string, []interface{}) {
+	conds, args := scopeConds(ctx, "project_id")
+	if projectID != 0 {
+		conds = append(conds, "project_id = ?")
+		args = append(args, projectID)
+	}
+	if opts.Tag != "" {
+		conds = append(conds, tagMatch)
+		args = append(args, opts.Tag)
+	}
+	if opts.Type != "" {
+		conds = append(conds, "type = ?")
+		args = append(args, opts.Type)
+	}
+	return whereClause(conds), args
+}
+
+// scopeConds starts a condition list with the team scope of ctx, if any
+func scopeConds(ctx context.Context, column string) ([]string, []interface{}) {
+	cond, args, scoped := teamScopeCond(ctx, column)
+	if !scoped {
+		return nil, nil
+	}
+	return []string{cond}, args
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// orderClause converts a sort key like "-created_at" into a safe ORDER BY expression
+func orderClause(sort string, columns map[string]string, fallback string) (string, error) {
+	if sort == "" {
+		return fallback, nil
+	}
+
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		sort = sort[1:]
+	}
+
+	column, ok := columns[sort]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrInvalidSort, sort)
+	}
+	// Secondary key keeps pagination stable when values tie
+	return column + " " + direction + ", id ASC", nil
+}
+
+// limitClause renders LIMIT/OFFSET from list options, clamping to MaxListLimit
+func limitClause(opts ListOptions) string {
+	limit := opts.Limit
+	if limit <= 0 {
+		if opts.Offset <= 0 {
+			return ""
+		}
+		limit = -1 // SQLite: no upper bound
+	} else if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+
+	offset := opts.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+}
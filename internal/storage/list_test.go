@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOrderClause(t *testing.T) {
+	tests := []struct {
+		sort    string
+		want    string
+		wantErr bool
+	}{
+		{sort: "", want: "name ASC"},
+		{sort: "name", want: "name ASC, id ASC"},
+		{sort: "-created_at", want: "created_at DESC, id ASC"},
+		{sort: "port", want: "port ASC, id ASC"},
+		{sort: "-", wantErr: true},
+		{sort: "--name", wantErr: true},
+		{sort: "Name", wantErr: true},
+		{sort: "environment", wantErr: true}, // a column, but not a sortable one
+		{sort: "name; DROP TABLE services", wantErr: true},
+		{sort: "name DESC", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := orderClause(tt.sort, serviceSortColumns, "name ASC")
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidSort) {
+				t.Errorf("orderClause(%q) = %q, %v; want ErrInvalidSort", tt.sort, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("orderClause(%q) = %q, %v; want %q", tt.sort, got, err, tt.want)
+		}
+	}
+
+	if _, err := orderClause("port", projectSortColumns, "name ASC"); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("projects sorted by port: error = %v, want ErrInvalidSort", err)
+	}
+}
+
+func TestLimitClause(t *testing.T) {
+	tests := []struct {
+		limit, offset int
+		want          string
+	}{
+		{0, 0, ""},
+		{-5, 0, ""},
+		{20, 0, " LIMIT 20 OFFSET 0"},
+		{20, 40, " LIMIT 20 OFFSET 40"},
+		{20, -3, " LIMIT 20 OFFSET 0"},
+		{MaxListLimit + 1, 0, " LIMIT 500 OFFSET 0"},
+		{0, 10, " LIMIT -1 OFFSET 10"}, // an offset alone skips without bounding the page
+	}
+	for _, tt := range tests {
+		if got := limitClause(ListOptions{Limit: tt.limit, Offset: tt.offset}); got != tt.want {
+			t.Errorf("limitClause(limit %d, offset %d) = %q, want %q", tt.limit, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // Value(), as stored
+	}{
+		{"", ""},
+		{"prod", ",prod,"},
+		{"Prod, web  prod\tAPI\n", ",api,prod,web,"},
+		{" , ,", ""},
+	}
+	for _, tt := range tests {
+		got, err := ParseTags(tt.input).Value()
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTags(%q).Value() = %q, %v; want %q", tt.input, got, err, tt.want)
+		}
+	}
+}
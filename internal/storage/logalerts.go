@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// defaultIncidentLimit caps incident listings when no limit is given
+const defaultIncidentLimit = 50
+
+// CreateLogAlert inserts a log alert rule
+func (s *Storage) CreateLogAlert(ctx context.Context, a *LogAlert) error {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO log_alerts (service_id, name, pattern, threshold, interval_seconds, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.ServiceID, a.Name, a.Pattern, a.Threshold, a.Interval, a.Enabled, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create log alert: %w", err)
+	}
+
+	a.ID, _ = result.LastInsertId()
+	a.CreatedAt, a.UpdatedAt = now, now
+	return nil
+}
+
+// GetLogAlert retrieves a log alert rule by ID
+func (s *Storage) GetLogAlert(ctx context.Context, id int64) (*LogAlert, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, service_id, name, pattern, threshold, interval_seconds, enabled, created_at, updated_at
+		FROM log_alerts WHERE id = ?
+	`, id)
+
+	a, err := scanLogAlert(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log alert: %w", err)
+	}
+	return a, nil
+}
+
+// ListLogAlerts returns a service's log alert rules, or every service's when
+// serviceID is 0, oldest first
+func (s *Storage) ListLogAlerts(ctx context.Context, serviceID int64) ([]*LogAlert, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, service_id, name, pattern, threshold, interval_seconds, enabled, created_at, updated_at
+		FROM log_alerts WHERE ? = 0 OR service_id = ? ORDER BY id
+	`, serviceID, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []*LogAlert{}
+	for rows.Next() {
+		a, err := scanLogAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan log alert: %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// UpdateLogAlert saves a log alert rule's name, pattern, threshold, interval, and enabled flag
+func (s *Storage) UpdateLogAlert(ctx context.Context, a *LogAlert) error {
+	a.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE log_alerts SET name = ?, pattern = ?, threshold = ?, interval_seconds = ?, enabled = ?, updated_at = ?
+		WHERE id = ?
+	`, a.Name, a.Pattern, a.Threshold, a.Interval, a.Enabled, a.UpdatedAt, a.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update log alert: %w", err)
+	}
+	return nil
+}
+
+// DeleteLogAlert deletes a log alert rule; its incidents are kept
+func (s *Storage) DeleteLogAlert(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM log_alerts WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete log alert: %w", err)
+	}
+	return nil
+}
+
+// CreateLogIncident records a log alert firing
+func (s *Storage) CreateLogIncident(ctx context.Context, i *LogIncident) error {
+	if i.FiredAt.IsZero() {
+		i.FiredAt = time.Now()
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO log_incidents (alert_id, service_id, alert, pattern, matches, sample, fired_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, i.AlertID, i.ServiceID, i.Alert, i.Pattern, i.Matches, i.Sample, i.FiredAt)
+	if err != nil {
+		return fmt.Errorf("failed to create log incident: %w", err)
+	}
+
+	i.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListLogIncidents returns a service's log incidents, newest first
+func (s *Storage) ListLogIncidents(ctx context.Context, serviceID int64, limit int) ([]*LogIncident, error) {
+	if limit <= 0 {
+		limit = defaultIncidentLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, alert_id, service_id, alert, pattern, matches, sample, fired_at
+		FROM log_incidents WHERE service_id = ? ORDER BY id DESC LIMIT ?
+	`, serviceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log incidents: %w", err)
+	}
+	defer rows.Close()
+
+	incidents := []*LogIncident{}
+	for rows.Next() {
+		i := &LogIncident{}
+		if err := rows.Scan(&i.ID, &i.AlertID, &i.ServiceID, &i.Alert, &i.Pattern, &i.Matches, &i.Sample, &i.FiredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan log incident: %w", err)
+		}
+		incidents = append(incidents, i)
+	}
+	return incidents, rows.Err()
+}
+
+func scanLogAlert(row rowScanner) (*LogAlert, error) {
+	a := &LogAlert{}
+	if err := row.Scan(&a.ID, &a.ServiceID, &a.Name, &a.Pattern, &a.Threshold, &a.Interval, &a.Enabled, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetFileLogging returns a service's file logging settings, or nil if it logs to the journal
+func (s *Storage) GetFileLogging(ctx context.Context, serviceID int64) (*FileLogging, error) {
+	f := &FileLogging{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT service_id, path, rotate, frequency, max_size, updated_at FROM file_logging WHERE service_id = ?
+	`, serviceID).Scan(&f.ServiceID, &f.Path, &f.Rotate, &f.Frequency, &f.MaxSize, &f.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file logging: %w", err)
+	}
+	return f, nil
+}
+
+// SetFileLogging creates or replaces a service's file logging settings
+func (s *Storage) SetFileLogging(ctx context.Context, f *FileLogging) error {
+	f.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO file_logging (service_id, path, rotate, frequency, max_size, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(service_id) DO UPDATE SET path = excluded.path, rotate = excluded.rotate,
+			frequency = excluded.frequency, max_size = excluded.max_size, updated_at = excluded.updated_at
+	`, f.ServiceID, f.Path, f.Rotate, f.Frequency, f.MaxSize, f.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set file logging: %w", err)
+	}
+	return nil
+}
+
+// DeleteFileLogging removes a service's file logging settings
+func (s *Storage) DeleteFileLogging(ctx context.Context, serviceID int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM file_logging WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to delete file logging: %w", err)
+	}
+	return nil
+}
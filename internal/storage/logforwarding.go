@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetLogForwarding returns a service's forwarding row, or nil if it is not forwarded
+func (s *Storage) GetLogForwarding(ctx context.Context, serviceID int64) (*LogForwarding, error) {
+	f := &LogForwarding{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT service_id, cursor, enabled_at FROM log_forwarding WHERE service_id = ?
+	`, serviceID).Scan(&f.ServiceID, &f.Cursor, &f.EnabledAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get log forwarding: %w", err)
+	}
+	return f, nil
+}
+
+// ListLogForwarding returns every forwarded service, ordered by service
+func (s *Storage) ListLogForwarding(ctx context.Context) ([]*LogForwarding, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service_id, cursor, enabled_at FROM log_forwarding ORDER BY service_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log forwarding: %w", err)
+	}
+	defer rows.Close()
+
+	forwarded := []*LogForwarding{}
+	for rows.Next() {
+		f := &LogForwarding{}
+		if err := rows.Scan(&f.ServiceID, &f.Cursor, &f.EnabledAt); err != nil {
+			return nil, fmt.Errorf("failed to scan log forwarding: %w", err)
+		}
+		forwarded = append(forwarded, f)
+	}
+	return forwarded, rows.Err()
+}
+
+// EnableLogForwarding starts forwarding a service's journal. Enabling an
+// already forwarded service keeps its cursor.
+func (s *Storage) EnableLogForwarding(ctx context.Context, serviceID int64) (*LogForwarding, error) {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO log_forwarding (service_id, enabled_at) VALUES (?, ?) ON CONFLICT(service_id) DO NOTHING
+	`, serviceID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable log forwarding: %w", err)
+	}
+	return s.GetLogForwarding(ctx, serviceID)
+}
+
+// DisableLogForwarding stops forwarding a service's journal and forgets its cursor
+func (s *Storage) DisableLogForwarding(ctx context.Context, serviceID int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM log_forwarding WHERE service_id = ?", serviceID); err != nil {
+		return fmt.Errorf("failed to disable log forwarding: %w", err)
+	}
+	return nil
+}
+
+// SetLogForwardingCursor records the last journal entry delivered for a service
+func (s *Storage) SetLogForwardingCursor(ctx context.Context, serviceID int64, cursor string) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE log_forwarding SET cursor = ? WHERE service_id = ?", cursor, serviceID); err != nil {
+		return fmt.Errorf("failed to save log forwarding cursor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxMetricSamples caps a single metrics query
+const maxMetricSamples = 100000
+
+// RecordMetricSamples stores a batch of samples taken together
+func (s *Storage) RecordMetricSamples(ctx context.Context, samples []*MetricSample) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, m := range samples {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO metric_samples (service_id, service, cpu_percent, memory_percent, memory_mb, disk_percent, taken_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, m.ServiceID, m.Service, m.CPUPercent, m.MemoryPercent, m.MemoryMB, m.DiskPercent, m.TakenAt.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record metric sample: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit metric samples: %w", err)
+	}
+	return nil
+}
+
+// ListMetricSamples returns samples matching the filter, oldest first. Team
+// scoped contexts see host samples and their own services' samples.
+func (s *Storage) ListMetricSamples(ctx context.Context, filter MetricFilter) ([]*MetricSample, error) {
+	conds := []string{"taken_at >= ?", "taken_at < ?"}
+	// Times are stored as text, so bounds must use the same (UTC) zone to compare correctly
+	args := []interface{}{filter.From.UTC(), filter.To.UTC()}
+	switch {
+	case filter.ServiceID > 0:
+		conds = append(conds, "service_id = ?")
+		args = append(args, filter.ServiceID)
+	case filter.ServiceID < 0:
+		conds = append(conds, "service_id = 0")
+	}
+	if cond, scopeArgs, scoped := teamScopeCond(ctx, "project_id"); scoped {
+		conds = append(conds, "(service_id = 0 OR service_id IN (SELECT id FROM services WHERE "+cond+"))")
+		args = append(args, scopeArgs...)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > maxMetricSamples {
+		limit = maxMetricSamples
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service_id, service, cpu_percent, memory_percent, memory_mb, disk_percent, taken_at
+		FROM metric_samples`+whereClause(conds)+`
+		ORDER BY taken_at, service_id LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metric samples: %w", err)
+	}
+	defer rows.Close()
+
+	samples := []*MetricSample{}
+	for rows.Next() {
+		m := &MetricSample{}
+		if err := rows.Scan(&m.ServiceID, &m.Service, &m.CPUPercent, &m.MemoryPercent, &m.MemoryMB, &m.DiskPercent, &m.TakenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan metric sample: %w", err)
+		}
+		samples = append(samples, m)
+	}
+	return samples, rows.Err()
+}
+
+// PruneMetricSamples deletes samples taken before cutoff and returns how many were removed
+func (s *Storage) PruneMetricSamples(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM metric_samples WHERE taken_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune metric samples: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// RecordAppMetrics stores a batch of application metric samples
+func (s *Storage) RecordAppMetrics(ctx context.Context, samples []*AppMetricSample) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, m := range samples {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO app_metric_samples (service_id, name, kind, value, count, taken_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, m.ServiceID, m.Name, m.Kind, m.Value, m.Count, m.TakenAt.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record app metric sample: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit app metric samples: %w", err)
+	}
+	return nil
+}
+
+// ListAppMetrics returns a service's metric samples matching the filter,
+// ordered by name and then oldest first
+func (s *Storage) ListAppMetrics(ctx context.Context, filter AppMetricFilter) ([]*AppMetricSample, error) {
+	conds := []string{"service_id = ?", "taken_at >= ?", "taken_at < ?"}
+	args := []interface{}{filter.ServiceID, filter.From.UTC(), filter.To.UTC()}
+	if filter.Name != "" {
+		conds = append(conds, "name = ?")
+		args = append(args, filter.Name)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > maxMetricSamples {
+		limit = maxMetricSamples
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT service_id, name, kind, value, count, taken_at
+		FROM app_metric_samples`+whereClause(conds)+`
+		ORDER BY name, taken_at LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list app metric samples: %w", err)
+	}
+	defer rows.Close()
+
+	samples := []*AppMetricSample{}
+	for rows.Next() {
+		m := &AppMetricSample{}
+		if err := rows.Scan(&m.ServiceID, &m.Name, &m.Kind, &m.Value, &m.Count, &m.TakenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan app metric sample: %w", err)
+		}
+		samples = append(samples, m)
+	}
+	return samples, rows.Err()
+}
+
+// PruneAppMetrics deletes application metric samples taken before cutoff and
+// returns how many were removed
+func (s *Storage) PruneAppMetrics(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM app_metric_samples WHERE taken_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune app metric samples: %w", err)
+	}
+	return result.RowsAffected()
+}
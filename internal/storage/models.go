@@ -1,6 +1,9 @@
 package storage
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
 // Project represents a group of related services (e.g., an entire web application stack)
 type Project struct {
@@ -9,6 +12,13 @@ type Project struct {
 	Description string    `json:"description"`
 	Domain      string    `json:"domain,omitempty"`    // e.g., "myapp.com" for Nginx site config
 	NginxRaw    string    `json:"nginx_raw,omitempty"` // Raw Nginx site config override
+	Notes       string    `json:"notes,omitempty"`     // Markdown runbook shown on the detail page
+	Tags        Tags      `json:"tags,omitempty"`
+	TeamID      int64     `json:"team_id,omitempty"`     // Owning team; 0 when unassigned (admins only)
+	HostID      int64     `json:"host_id,omitempty"`     // Agent host running the project; 0 for this server
+	Certificate string    `json:"certificate,omitempty"` // Name of the issued certificate its site serves HTTPS with
+	HTTP2       bool      `json:"http2,omitempty"`       // Its site serves HTTP/2 over HTTPS, where nginx supports it
+	HTTP3       bool      `json:"http3,omitempty"`       // Its site serves HTTP/3 over QUIC, where nginx supports it
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
@@ -23,8 +33,12 @@ type Service struct {
 	Name        string    `json:"name"`
 	Type        string    `json:"type"` // e.g., django, postgres, redis, custom
 	Version     string    `json:"version,omitempty"`
+	Runtime     string    `json:"runtime,omitempty"`      // RuntimeSystemd (also ""), RuntimeDocker, or RuntimePodman
+	Image       string    `json:"image,omitempty"`        // container image, for the container runtimes
 	Port        int       `json:"port,omitempty"`         // Port the service listens on (for Nginx proxy)
 	GitRepoURL  string    `json:"git_repo_url,omitempty"` // Git repository URL for cloning
+	GitBranch   string    `json:"git_branch,omitempty"`   // branch to check out; the remote's default when empty
+	GitFilter   string    `json:"git_filter,omitempty"`   // partial clone filter such as blob:none; a full clone when empty
 	Command     string    `json:"command"`
 	WorkingDir  string    `json:"working_dir"`
 	User        string    `json:"user"`
@@ -33,23 +47,67 @@ type Service struct {
 	Config      string    `json:"config,omitempty"` // JSON configuration overrides
 	SystemdRaw  string    `json:"systemd_raw,omitempty"`
 	NginxRaw    string    `json:"nginx_raw,omitempty"`
+	Notes       string    `json:"notes,omitempty"` // Markdown runbook shown on the detail page
+	Tags        Tags      `json:"tags,omitempty"`
+	Replicas    int       `json:"replicas,omitempty"` // instances on sequential ports from Port; 0 and 1 mean a single unit
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
 	// Runtime status (not stored in DB)
-	Status string `json:"status,omitempty"`
+	Status     string `json:"status,omitempty"`
+	ErrorCount int    `json:"error_count,omitempty"` // error lines since the last start, on service cards
 }
 
-// ServiceName returns the systemd service name for this service
+// Service runtimes: what runs a service's process
+const (
+	RuntimeSystemd = "systemd" // a systemd unit; the default
+	RuntimeDocker  = "docker"  // a Docker container
+	RuntimePodman  = "podman"  // a Podman container
+)
+
+// ServiceName returns the systemd service name for this service. Container
+// services are known by it too, and their container is named without the suffix.
 func (s *Service) ServiceName() string {
 	return "servio-" + s.Name + ".service"
 }
 
+// Scaled reports whether the service runs as several instances of a
+// templated unit, servio-<name>@1.service onwards, rather than one unit
+func (s *Service) Scaled() bool {
+	return s.Replicas > 1
+}
+
+// InstanceName returns the unit of the service's nth instance, counting from 1
+func (s *Service) InstanceName(n int) string {
+	return "servio-" + s.Name + "@" + strconv.Itoa(n) + ".service"
+}
+
+// InstancePorts returns the ports the service's instances listen on: Port
+// for a single unit, or one port per replica counting up from it
+func (s *Service) InstancePorts() []int {
+	if !s.Scaled() {
+		return []int{s.Port}
+	}
+	ports := make([]int, s.Replicas)
+	for i := range ports {
+		ports[i] = s.Port + i
+	}
+	return ports
+}
+
+// IsContainer reports whether the service runs as a container rather than a systemd unit
+func (s *Service) IsContainer() bool {
+	return s.Runtime == RuntimeDocker || s.Runtime == RuntimePodman
+}
+
 // CreateProjectRequest represents the request body for creating a project
 type CreateProjectRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Domain      string `json:"domain"`
+	Notes       string `json:"notes"`
+	Tags        Tags   `json:"tags"`
+	TeamID      int64  `json:"team_id,omitempty"`
 }
 
 // CreateServiceRequest represents the request body for adding a service to a project
@@ -58,8 +116,12 @@ type CreateServiceRequest struct {
 	Name        string `json:"name"`
 	Type        string `json:"type"`
 	Version     string `json:"version"`
+	Runtime     string `json:"runtime"` // fixed once created
+	Image       string `json:"image"`
 	Port        int    `json:"port"`
 	GitRepoURL  string `json:"git_repo_url"`
+	GitBranch   string `json:"git_branch"`
+	GitFilter   string `json:"git_filter"`
 	Command     string `json:"command"`
 	WorkingDir  string `json:"working_dir"`
 	User        string `json:"user"`
@@ -68,6 +130,11 @@ type CreateServiceRequest struct {
 	Config      string `json:"config"`
 	SystemdRaw  string `json:"systemd_raw"`
 	NginxRaw    string `json:"nginx_raw"`
+	Notes       string `json:"notes"`
+	Tags        Tags   `json:"tags"`
+	CreateUser  bool   `json:"create_user,omitempty"` // not stored: create User as a system account if it does not exist
+	// Not stored: create WorkingDir if it does not exist
+	CreateWorkingDir bool `json:"create_working_dir,omitempty"`
 }
 
 // UpdateProjectRequest represents the request body for updating a project
@@ -75,14 +142,19 @@ type UpdateProjectRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Domain      string `json:"domain"`
+	Notes       string `json:"notes"`
+	Tags        Tags   `json:"tags"`
 }
 
 // UpdateServiceRequest represents the request body for updating a service
 type UpdateServiceRequest struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Image       string `json:"image"`
 	Port        int    `json:"port"`
 	GitRepoURL  string `json:"git_repo_url"`
+	GitBranch   string `json:"git_branch"`
+	GitFilter   string `json:"git_filter"`
 	Command     string `json:"command"`
 	WorkingDir  string `json:"working_dir"`
 	User        string `json:"user"`
@@ -91,4 +163,663 @@ type UpdateServiceRequest struct {
 	Config      string `json:"config"`
 	SystemdRaw  string `json:"systemd_raw"`
 	NginxRaw    string `json:"nginx_raw"`
+	Notes       string `json:"notes"`
+	Tags        Tags   `json:"tags"`
+	CreateUser  bool   `json:"create_user,omitempty"` // not stored: create User as a system account if it does not exist
+	// Not stored: create WorkingDir if it does not exist
+	CreateWorkingDir bool `json:"create_working_dir,omitempty"`
+}
+
+// SearchResult is a single hit returned by a full-text search
+type SearchResult struct {
+	Kind      string `json:"kind"` // "project" or "service"
+	ID        int64  `json:"id"`
+	ProjectID int64  `json:"project_id"`
+	Name      string `json:"name"`
+	Snippet   string `json:"snippet"`
+}
+
+// ListOptions controls pagination and ordering for list queries.
+// A zero Limit returns all rows. Sort is a column name, prefixed with "-" for descending order.
+type ListOptions struct {
+	Limit  int
+	Offset int
+	Sort   string
+
+	// Filters; empty matches everything
+	Type string // services of this type (for projects: having at least one)
+	Tag  string // carrying this tag
+}
+
+// FieldChange describes a single field difference between two versions of a record
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ServiceRevision is a recorded change to a service's configuration.
+// Snapshot holds the full configuration as of this revision and can be replayed to revert.
+type ServiceRevision struct {
+	ID        int64                `json:"id"`
+	ServiceID int64                `json:"service_id"`
+	Actor     string               `json:"actor"`
+	Changes   []FieldChange        `json:"changes"`
+	Snapshot  UpdateServiceRequest `json:"snapshot"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// Secret is an encrypted value that services reference as ${secret:KEY}.
+// Ciphertext never leaves the server; read APIs only expose a masked Value.
+type Secret struct {
+	ID         int64     `json:"id"`
+	Key        string    `json:"key"`
+	Scope      string    `json:"scope"` // "global" or "project:<id>"
+	Value      string    `json:"value"`
+	Ciphertext []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AuditEntry records a host action (systemctl, nginx, git, file write) performed by Servio
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	ProjectID  int64     `json:"project_id,omitempty"`
+	ServiceID  int64     `json:"service_id,omitempty"`
+	Actor      string    `json:"actor"`
+	Category   string    `json:"category"` // systemd, nginx, git, container, database, env, user
+	Action     string    `json:"action"`
+	Command    string    `json:"command"`
+	Output     string    `json:"output,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Artifact is the last content Servio wrote to a file it generates: a unit,
+// drop-in, timer, slice, journald config, nginx site, env file, or DNS
+// credentials file. It is kept so the file can be written again on a rebuilt
+// host. Files only their owner may read (mode 0600) hold secrets, so their Content
+// is stored encrypted.
+type Artifact struct {
+	Path      string    `json:"path"`
+	Category  string    `json:"category"` // the audit category of the write: systemd, nginx, env
+	ProjectID int64     `json:"project_id,omitempty"`
+	ServiceID int64     `json:"service_id,omitempty"`
+	Mode      string    `json:"mode"`     // octal, such as 0644
+	Size      int       `json:"size"`     // of the file, not the stored ciphertext
+	Checksum  string    `json:"checksum"` // hex SHA-256 of the file
+	Encrypted bool      `json:"encrypted"`
+	Content   []byte    `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EnabledUnit is a unit Servio enabled to start on boot. systemd records
+// enablement as symlinks it writes itself, so it is kept apart from the
+// artifacts.
+type EnabledUnit struct {
+	Unit      string    `json:"unit"`
+	ProjectID int64     `json:"project_id,omitempty"`
+	ServiceID int64     `json:"service_id,omitempty"`
+	EnabledAt time.Time `json:"enabled_at"`
+}
+
+// ArtifactFilter narrows artifact queries. Zero values mean "any".
+type ArtifactFilter struct {
+	ProjectID   int64
+	ServiceID   int64
+	Category    string
+	WithContent bool
+}
+
+// AuditFilter narrows audit trail queries. Zero values mean "any".
+type AuditFilter struct {
+	ProjectID int64
+	ServiceID int64
+	Category  string
+	Limit     int
+}
+
+// EventRecord is an event from the bus kept for the activity feed
+type EventRecord struct {
+	ID        int64                  `json:"id"`
+	Type      string                 `json:"type"`
+	ProjectID int64                  `json:"project_id,omitempty"`
+	ServiceID int64                  `json:"service_id,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Failed    bool                   `json:"failed"` // a crash, a log alert, or a failed deploy or cron run
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// Activity sources
+const (
+	ActivityAction = "action" // a host action from the audit trail
+	ActivityEvent  = "event"  // a recorded event, such as a deploy or a crash
+)
+
+// Activity is one entry of the activity feed: an audit entry or a recorded
+// event, with the names of its project and service when they still exist
+type Activity struct {
+	Source    string                 `json:"source"` // action or event
+	ID        int64                  `json:"id"`     // of the audit entry or event
+	Type      string                 `json:"type"`   // the action's category, or the event type
+	Action    string                 `json:"action,omitempty"`
+	ProjectID int64                  `json:"project_id,omitempty"`
+	ServiceID int64                  `json:"service_id,omitempty"`
+	Project   string                 `json:"project,omitempty"`
+	Service   string                 `json:"service,omitempty"`
+	Actor     string                 `json:"actor,omitempty"`
+	Command   string                 `json:"command,omitempty"`
+	Failed    bool                   `json:"failed"`
+	Error     string                 `json:"error,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Time      time.Time              `json:"time"`
+}
+
+// ActivityFilter narrows the activity feed. Zero values mean "any".
+type ActivityFilter struct {
+	ProjectID int64
+	ServiceID int64
+	Source    string // ActivityAction or ActivityEvent
+	Limit     int
+	Offset    int
+}
+
+// Deployment statuses
+const (
+	DeploymentPending   = "pending"
+	DeploymentRunning   = "running"
+	DeploymentSucceeded = "succeeded"
+	DeploymentFailed    = "failed"
+)
+
+// Deployment records a single run of the deploy pipeline for a service
+type Deployment struct {
+	ID         int64      `json:"id"`
+	ServiceID  int64      `json:"service_id"`
+	Commit     string     `json:"commit,omitempty"`
+	Status     string     `json:"status"`
+	Actor      string     `json:"actor"`
+	Log        string     `json:"log,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	JobID      int64      `json:"job_id,omitempty"`
+}
+
+// DatabaseBackup records a pg_dump of one database of a postgres service.
+// Status uses the job statuses.
+type DatabaseBackup struct {
+	ID         int64      `json:"id"`
+	ProjectID  int64      `json:"project_id"`
+	ServiceID  int64      `json:"service_id"`
+	Database   string     `json:"database"`
+	Path       string     `json:"path"` // custom-format dump on the server
+	SizeBytes  int64      `json:"size_bytes"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Actor      string     `json:"actor"`
+	JobID      int64      `json:"job_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Certificate is a project's Let's Encrypt certificate, issued through a
+// DNS-01 challenge. Status uses the job statuses; ExpiresAt is set once a
+// certificate was issued, and a failed renewal keeps the previous one.
+type Certificate struct {
+	ProjectID int64      `json:"project_id"`
+	Name      string     `json:"name"` // certbot certificate name
+	Domains   []string   `json:"domains"`
+	Provider  string     `json:"provider"` // DNS provider answering the challenge
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	JobID     int64      `json:"job_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// WildcardCertificate is a Let's Encrypt certificate for Domain and
+// *.Domain, served by every site of this server it covers that has no
+// certificate of its own
+type WildcardCertificate struct {
+	ID        int64      `json:"id"`
+	Domain    string     `json:"domain"`
+	Name      string     `json:"name"` // certbot certificate name
+	Provider  string     `json:"provider"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	JobID     int64      `json:"job_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Projects  []string   `json:"projects"` // names of the projects whose sites serve it; not stored
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// BlueprintDefinition is a blueprint installed from the remote catalog
+type BlueprintDefinition struct {
+	Type        string    `json:"type"`
+	Definition  string    `json:"definition"` // the definition file as fetched
+	SHA256      string    `json:"sha256"`
+	Source      string    `json:"source"` // URL the definition was fetched from
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// ServiceTemplate is a service saved to create others like it. Its strings
+// may hold {{name}} placeholders, filled in when a service is created from it.
+type ServiceTemplate struct {
+	ID          int64               `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Spec        ServiceTemplateSpec `json:"spec"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// ServiceTemplateSpec is the configuration a template gives each service created from it
+type ServiceTemplateSpec struct {
+	Type        string           `json:"type"`
+	Version     string           `json:"version,omitempty"`
+	Runtime     string           `json:"runtime,omitempty"`
+	Image       string           `json:"image,omitempty"`
+	Port        int              `json:"port,omitempty"`
+	GitRepoURL  string           `json:"git_repo_url,omitempty"`
+	GitBranch   string           `json:"git_branch,omitempty"`
+	GitFilter   string           `json:"git_filter,omitempty"`
+	Command     string           `json:"command,omitempty"`
+	WorkingDir  string           `json:"working_dir,omitempty"`
+	User        string           `json:"user,omitempty"`
+	Environment string           `json:"environment,omitempty"`
+	AutoRestart bool             `json:"auto_restart"`
+	Config      string           `json:"config,omitempty"`
+	SystemdRaw  string           `json:"systemd_raw,omitempty"`
+	NginxRaw    string           `json:"nginx_raw,omitempty"`
+	Tags        Tags             `json:"tags,omitempty"`
+	EnvVars     []TemplateEnvVar `json:"env_vars,omitempty"` // managed .env variables
+}
+
+// TemplateEnvVar is a managed .env variable of a template. Secret values are
+// never saved; a secret's Value is a placeholder instead.
+type TemplateEnvVar struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job records a long-running operation executed by the background worker pool
+type Job struct {
+	ID         int64      `json:"id"`
+	Kind       string     `json:"kind"`
+	ProjectID  int64      `json:"project_id,omitempty"`
+	ServiceID  int64      `json:"service_id,omitempty"`
+	Status     string     `json:"status"`
+	Actor      string     `json:"actor"`
+	Log        string     `json:"log,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// JobFilter narrows a job listing; zero values match everything
+type JobFilter struct {
+	ProjectID int64
+	ServiceID int64
+	Status    string
+	Limit     int
+}
+
+// Webhook is an endpoint that receives signed event notifications
+type Webhook struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	Events     []string  `json:"events"` // empty means every event
+	Enabled    bool      `json:"enabled"`
+	Ciphertext []byte    `json:"-"` // encrypted signing secret
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Wants reports whether the webhook subscribes to an event type
+func (w *Webhook) Wants(eventType string) bool {
+	if !w.Enabled {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery statuses of webhook deliveries and notifications
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// DeliveryState is how far a webhook delivery or notification got; see
+// internal/delivery
+type DeliveryState struct {
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookDelivery records the attempts to deliver one event to one webhook
+type WebhookDelivery struct {
+	ID        int64  `json:"id"`
+	WebhookID int64  `json:"webhook_id"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload,omitempty"`
+	DeliveryState
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Notification channel kinds
+const (
+	NotifySlack    = "slack"
+	NotifyDiscord  = "discord"
+	NotifyTelegram = "telegram"
+	NotifyWebhook  = "webhook"
+)
+
+// NotifyKinds lists every notification channel kind
+var NotifyKinds = []string{NotifySlack, NotifyDiscord, NotifyTelegram, NotifyWebhook}
+
+// NotificationChannel is a chat or webhook destination for messages about events
+type NotificationChannel struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Kind         string    `json:"kind"`              // NotifySlack, NotifyDiscord, NotifyTelegram, or NotifyWebhook
+	Target       string    `json:"target"`            // where messages go, without credentials: the URL's host, or the Telegram chat
+	ChatID       string    `json:"chat_id,omitempty"` // Telegram chat ID or @channel
+	Events       []string  `json:"events"`            // event types routed to the channel
+	ProjectID    int64     `json:"project_id,omitempty"`
+	FailuresOnly bool      `json:"failures_only"`      // skip events whose status is not failed, such as successful deploys
+	Template     string    `json:"template,omitempty"` // Go template of the message; empty for the default of each event
+	Enabled      bool      `json:"enabled"`
+	Ciphertext   []byte    `json:"-"` // encrypted webhook URL, or bot token for Telegram
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Wants reports whether the channel takes an event type from a project (0
+// for events outside projects); FailuresOnly is left to the caller
+func (c *NotificationChannel) Wants(eventType string, projectID int64) bool {
+	if !c.Enabled || (c.ProjectID != 0 && c.ProjectID != projectID) {
+		return false
+	}
+	for _, e := range c.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationDelivery records the attempts to send one message to one
+// channel; statuses are the webhook delivery ones
+type NotificationDelivery struct {
+	ID        int64  `json:"id"`
+	ChannelID int64  `json:"channel_id"`
+	Event     string `json:"event"`
+	Message   string `json:"message"`
+	DeliveryState
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IntegrityReport is the result of a database integrity check
+type IntegrityReport struct {
+	OK                   bool                  `json:"ok"`
+	Errors               []string              `json:"errors"`
+	ForeignKeyViolations []ForeignKeyViolation `json:"foreign_key_violations"`
+	OrphanSecrets        int                   `json:"orphan_secrets"`
+	OrphanSearchEntries  int                   `json:"orphan_search_entries"`
+	Repaired             bool                  `json:"repaired"`
+}
+
+// ForeignKeyViolation is a row whose referenced parent row does not exist
+type ForeignKeyViolation struct {
+	Table  string `json:"table"`
+	RowID  int64  `json:"row_id"`
+	Parent string `json:"parent"`
+}
+
+// SettingDefinition describes a registered setting: its type, default, and constraints
+type SettingDefinition struct {
+	Key         string   `json:"key"`
+	Type        string   `json:"type"` // bool, int, enum, json, string
+	Default     string   `json:"default"`
+	Options     []string `json:"options,omitempty"` // enum only
+	Min         *int     `json:"min,omitempty"`     // int only
+	Max         *int     `json:"max,omitempty"`     // int only
+	Description string   `json:"description"`
+}
+
+// Setting is a registered setting with its current value
+type Setting struct {
+	SettingDefinition
+	Value     string `json:"value"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// Team is a group of users who share access to the projects assigned to it
+type Team struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Members   []string  `json:"members"` // basic auth users or client certificate names
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// JournalRetention limits how much journal one service keeps. MaxSize and
+// MaxAge use journald's syntax (500M, 7d); either may be empty.
+type JournalRetention struct {
+	ServiceID int64     `json:"service_id"`
+	MaxSize   string    `json:"max_size,omitempty"`
+	MaxAge    string    `json:"max_age,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProjectBudget caps the memory and CPU all of a project's services may use
+// together. MemoryMax and CPUQuota use systemd's syntax (2G, 150%); either
+// may be empty.
+type ProjectBudget struct {
+	ProjectID int64     `json:"project_id"`
+	MemoryMax string    `json:"memory_max,omitempty"`
+	CPUQuota  string    `json:"cpu_quota,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FileLogging sends a service's output to Path, rotated by logrotate every
+// Frequency or once it passes MaxSize, keeping Rotate old files
+type FileLogging struct {
+	ServiceID int64     `json:"service_id"`
+	Path      string    `json:"path"`
+	Rotate    int       `json:"rotate"`
+	Frequency string    `json:"frequency"`
+	MaxSize   string    `json:"max_size,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LogForwarding marks a service whose journal is shipped to the log sink.
+// Cursor is the journal cursor of the last entry delivered.
+type LogForwarding struct {
+	ServiceID int64     `json:"service_id"`
+	Cursor    string    `json:"cursor,omitempty"`
+	EnabledAt time.Time `json:"enabled_at"`
+}
+
+// LogAlert watches a service's log for lines matching Pattern, a regular
+// expression, and fires once more than Threshold lines match within Interval
+// seconds. It fires at most once per Interval.
+type LogAlert struct {
+	ID        int64     `json:"id"`
+	ServiceID int64     `json:"service_id"`
+	Name      string    `json:"name"`
+	Pattern   string    `json:"pattern"`
+	Threshold int       `json:"threshold"` // 0 fires on the first match
+	Interval  int       `json:"interval"`  // seconds
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LogIncident records a log alert firing. Alert and Pattern are copied from
+// the rule, so the record stands if the rule later changes.
+type LogIncident struct {
+	ID        int64     `json:"id"`
+	AlertID   int64     `json:"alert_id"`
+	ServiceID int64     `json:"service_id"`
+	Alert     string    `json:"alert"`
+	Pattern   string    `json:"pattern"`
+	Matches   int       `json:"matches"` // lines matched within the interval
+	Sample    string    `json:"sample"`  // the line that fired the alert
+	FiredAt   time.Time `json:"fired_at"`
+}
+
+// CronJob is a command a project runs on a schedule, installed as a systemd
+// timer and the oneshot unit it starts. The Last fields describe the latest
+// finished run, as recorded by the cron watcher.
+type CronJob struct {
+	ID           int64       `json:"id"`
+	ProjectID    int64       `json:"project_id"`
+	Name         string      `json:"name"`
+	Schedule     string      `json:"schedule"` // crontab syntax, in the server's time zone
+	Command      string      `json:"command"`
+	WorkingDir   string      `json:"working_dir,omitempty"`
+	User         string      `json:"user"`
+	Environment  string      `json:"environment,omitempty"`
+	Timeout      int         `json:"timeout,omitempty"` // seconds a run may take; 0 for no limit
+	Enabled      bool        `json:"enabled"`
+	LastRunAt    *time.Time  `json:"last_run_at,omitempty"`
+	LastStatus   string      `json:"last_status,omitempty"` // CronSucceeded or CronFailed
+	LastExitCode int         `json:"last_exit_code"`
+	LastDuration int64       `json:"last_duration_ms,omitempty"`
+	NextRuns     []time.Time `json:"next_runs,omitempty"` // filled in by the API
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+}
+
+// Cron job run results
+const (
+	CronSucceeded = "succeeded"
+	CronFailed    = "failed"
+)
+
+// UnitName is the name shared by the job's timer and service units, without
+// their suffix. It carries the ID since job names are only unique per project.
+func (j *CronJob) UnitName() string {
+	return "servio-cron-" + strconv.FormatInt(j.ID, 10) + "-" + j.Name
+}
+
+// CronRun is the outcome of one run of a cron job
+type CronRun struct {
+	StartedAt time.Time
+	Status    string
+	ExitCode  int
+	Duration  time.Duration
+}
+
+// Host is a remote server running `servio agent`, which manages the units and
+// nginx sites of the projects assigned to it
+type Host struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	URL        string     `json:"url"`                    // base URL of the agent API
+	Ciphertext []byte     `json:"-"`                      // encrypted token the agent accepts
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"` // last successful contact
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// PortConflict is a port that several services of one host are configured with
+type PortConflict struct {
+	HostID   int64    `json:"host_id"` // 0 for this server
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port"`
+	Services []string `json:"services"`
+}
+
+// MetricSample is a point-in-time resource reading for the host (ServiceID 0)
+// or one service, recorded for historical reports
+type MetricSample struct {
+	ServiceID     int64     `json:"service_id,omitempty"`
+	Service       string    `json:"service,omitempty"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryPercent float64   `json:"memory_percent,omitempty"` // host only
+	MemoryMB      float64   `json:"memory_mb"`
+	DiskPercent   float64   `json:"disk_percent,omitempty"` // host only
+	TakenAt       time.Time `json:"taken_at"`
+}
+
+// MetricFilter selects metric samples taken in [From, To). A zero ServiceID
+// includes the host and every service; -1 selects the host only.
+type MetricFilter struct {
+	From      time.Time
+	To        time.Time
+	ServiceID int64
+	Limit     int
+}
+
+// AppMetricSample is a custom metric a service pushed, summed up over one
+// minute: a counter's total, a gauge's last value, or a timer's mean of
+// Count observations
+type AppMetricSample struct {
+	ServiceID int64     `json:"service_id"`
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"` // counter, gauge, or timer
+	Value     float64   `json:"value"`
+	Count     int64     `json:"count"`
+	TakenAt   time.Time `json:"taken_at"`
+}
+
+// AppMetricFilter selects a service's metric samples taken in [From, To),
+// all of its metrics when Name is empty
+type AppMetricFilter struct {
+	ServiceID int64
+	Name      string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// EnvVar is a variable of a service's managed .env file, or of a project's,
+// which every service of the project inherits unless it sets the same key.
+// A secret's value is kept in Ciphertext and masked in responses.
+type EnvVar struct {
+	ID         int64     `json:"id"`
+	ServiceID  int64     `json:"service_id,omitempty"` // set for a service's variables
+	ProjectID  int64     `json:"project_id,omitempty"` // set for a project's variables
+	Key        string    `json:"key"`
+	Value      string    `json:"value"`
+	Secret     bool      `json:"secret"`
+	Ciphertext []byte    `json:"-"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// EnvFile is where a service's managed .env file is written and what was
+// written there last
+type EnvFile struct {
+	ServiceID int64      `json:"service_id"`
+	Path      string     `json:"path"`               // empty for .env in the working directory
+	Checksum  string     `json:"checksum,omitempty"` // sha256 of the content last written
+	WrittenAt *time.Time `json:"written_at,omitempty"`
 }
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const notificationChannelColumns = "id, name, kind, target, chat_id, events, project_id, failures_only, template, enabled, secret, created_at, updated_at"
+
+// CreateNotificationChannel inserts a notification channel
+func (s *Storage) CreateNotificationChannel(ctx context.Context, c *NotificationChannel) error {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_channels (name, kind, target, chat_id, events, project_id, failures_only, template, enabled, secret, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.Name, c.Kind, c.Target, c.ChatID, strings.Join(c.Events, ","), nullID(c.ProjectID), c.FailuresOnly, c.Template, c.Enabled, c.Ciphertext, now, now)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "is already used by another notification channel"}}}
+		}
+		return fmt.Errorf("failed to create notification channel: %w", err)
+	}
+
+	c.ID, _ = result.LastInsertId()
+	c.CreatedAt, c.UpdatedAt = now, now
+	return nil
+}
+
+// GetNotificationChannel retrieves a notification channel by ID
+func (s *Storage) GetNotificationChannel(ctx context.Context, id int64) (*NotificationChannel, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+notificationChannelColumns+" FROM notification_channels WHERE id = ?", id)
+	c, err := scanNotificationChannel(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification channel: %w", err)
+	}
+	return c, nil
+}
+
+// ListNotificationChannels returns every notification channel, oldest first
+func (s *Storage) ListNotificationChannels(ctx context.Context) ([]*NotificationChannel, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+notificationChannelColumns+" FROM notification_channels ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notification channels: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []*NotificationChannel{}
+	for rows.Next() {
+		c, err := scanNotificationChannel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification channel: %w", err)
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+// UpdateNotificationChannel saves every field of a notification channel
+func (s *Storage) UpdateNotificationChannel(ctx context.Context, c *NotificationChannel) error {
+	c.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notification_channels SET name = ?, kind = ?, target = ?, chat_id = ?, events = ?, project_id = ?,
+			failures_only = ?, template = ?, enabled = ?, secret = ?, updated_at = ?
+		WHERE id = ?
+	`, c.Name, c.Kind, c.Target, c.ChatID, strings.Join(c.Events, ","), nullID(c.ProjectID),
+		c.FailuresOnly, c.Template, c.Enabled, c.Ciphertext, c.UpdatedAt, c.ID)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "is already used by another notification channel"}}}
+		}
+		return fmt.Errorf("failed to update notification channel: %w", err)
+	}
+	return nil
+}
+
+// DeleteNotificationChannel deletes a notification channel and its delivery history
+func (s *Storage) DeleteNotificationChannel(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM notification_channels WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete notification channel: %w", err)
+	}
+	return nil
+}
+
+// CreateNotificationDelivery records a pending delivery
+func (s *Storage) CreateNotificationDelivery(ctx context.Context, d *NotificationDelivery) error {
+	if d.Status == "" {
+		d.Status = DeliveryPending
+	}
+	d.CreatedAt = time.Now()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO notification_deliveries (channel_id, event, message, status, attempts, response_status, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.ChannelID, d.Event, d.Message, d.Status, d.Attempts, d.ResponseStatus, d.Error, d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create notification delivery: %w", err)
+	}
+
+	d.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// UpdateNotificationDelivery saves the outcome of delivery attempts
+func (s *Storage) UpdateNotificationDelivery(ctx context.Context, d *NotificationDelivery) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE notification_deliveries SET status = ?, attempts = ?, response_status = ?, error = ?, delivered_at = ?
+		WHERE id = ?
+	`, d.Status, d.Attempts, d.ResponseStatus, d.Error, d.DeliveredAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update notification delivery: %w", err)
+	}
+	return nil
+}
+
+// ListNotificationDeliveries returns a channel's deliveries, newest first
+func (s *Storage) ListNotificationDeliveries(ctx context.Context, channelID int64, limit int) ([]*NotificationDelivery, error) {
+	if limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, channel_id, event, message, status, attempts, response_status, error, created_at, delivered_at
+		FROM notification_deliveries WHERE channel_id = ? ORDER BY id DESC LIMIT ?
+	`, channelID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notification deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*NotificationDelivery{}
+	for rows.Next() {
+		d := &NotificationDelivery{}
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.ChannelID, &d.Event, &d.Message, &d.Status, &d.Attempts,
+			&d.ResponseStatus, &d.Error, &d.CreatedAt, &deliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan notification delivery: %w", err)
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func scanNotificationChannel(row rowScanner) (*NotificationChannel, error) {
+	c := &NotificationChannel{}
+	var events string
+	var projectID sql.NullInt64
+	if err := row.Scan(&c.ID, &c.Name, &c.Kind, &c.Target, &c.ChatID, &events, &projectID, &c.FailuresOnly,
+		&c.Template, &c.Enabled, &c.Ciphertext, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	c.ProjectID = projectID.Int64
+	c.Events = []string{}
+	if events != "" {
+		c.Events = strings.Split(events, ",")
+	}
+	return c, nil
+}
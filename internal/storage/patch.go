@@ -0,0 +1,77 @@
+package storage
+
+// PatchProjectRequest changes only the project fields that are present in the body
+type PatchProjectRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Domain      *string `json:"domain"`
+	Notes       *string `json:"notes"`
+	Tags        *Tags   `json:"tags"`
+}
+
+// Apply returns a full update request with the patch laid over the current project
+func (p *PatchProjectRequest) Apply(current *Project) UpdateProjectRequest {
+	req := UpdateProjectRequest{
+		Name:        current.Name,
+		Description: current.Description,
+		Domain:      current.Domain,
+		Notes:       current.Notes,
+		Tags:        current.Tags,
+	}
+	set(&req.Name, p.Name)
+	set(&req.Description, p.Description)
+	set(&req.Domain, p.Domain)
+	set(&req.Notes, p.Notes)
+	set(&req.Tags, p.Tags)
+	return req
+}
+
+// PatchServiceRequest changes only the service fields that are present in the body
+type PatchServiceRequest struct {
+	Name        *string `json:"name"`
+	Image       *string `json:"image"`
+	Port        *int    `json:"port"`
+	GitRepoURL  *string `json:"git_repo_url"`
+	GitBranch   *string `json:"git_branch"`
+	GitFilter   *string `json:"git_filter"`
+	Command     *string `json:"command"`
+	WorkingDir  *string `json:"working_dir"`
+	User        *string `json:"user"`
+	Environment *string `json:"environment"`
+	AutoRestart *bool   `json:"auto_restart"`
+	Config      *string `json:"config"`
+	SystemdRaw  *string `json:"systemd_raw"`
+	NginxRaw    *string `json:"nginx_raw"`
+	Notes       *string `json:"notes"`
+	Tags        *Tags   `json:"tags"`
+}
+
+// Apply returns a full update request with the patch laid over the current service
+func (p *PatchServiceRequest) Apply(current *Service) UpdateServiceRequest {
+	req := snapshotService(current)
+	req.Notes = current.Notes
+	set(&req.Name, p.Name)
+	set(&req.Image, p.Image)
+	set(&req.Port, p.Port)
+	set(&req.GitRepoURL, p.GitRepoURL)
+	set(&req.GitBranch, p.GitBranch)
+	set(&req.GitFilter, p.GitFilter)
+	set(&req.Command, p.Command)
+	set(&req.WorkingDir, p.WorkingDir)
+	set(&req.User, p.User)
+	set(&req.Environment, p.Environment)
+	set(&req.AutoRestart, p.AutoRestart)
+	set(&req.Config, p.Config)
+	set(&req.SystemdRaw, p.SystemdRaw)
+	set(&req.NginxRaw, p.NginxRaw)
+	set(&req.Notes, p.Notes)
+	set(&req.Tags, p.Tags)
+	return req
+}
+
+// set overwrites dst when the patch carries a value
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ErrPortConflict is returned when a service port is already assigned to another service
+var ErrPortConflict = errors.New("port conflict")
+
+// checkPortAvailable ensures no other service on the project's host is
+// configured with the same port, counting the ports of every instance of
+// scaled services. Port 0 means "no port" and never conflicts.
+func (s *Storage) checkPortAvailable(ctx context.Context, projectID int64, port int, excludeID int64) error {
+	return s.checkPortRangeAvailable(ctx, projectID, port, 1, excludeID)
+}
+
+// checkPortRangeAvailable ensures count ports from port are free on the
+// project's host for a service's instances
+func (s *Storage) checkPortRangeAvailable(ctx context.Context, projectID int64, port, count int, excludeID int64) error {
+	if port <= 0 {
+		return nil
+	}
+
+	var name string
+	var used int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT name, MAX(port, ?) FROM services
+		WHERE port > 0 AND id != ? AND port < ? AND ? < port + MAX(replicas, 1)
+			AND host_id = (SELECT COALESCE(host_id, 0) FROM projects WHERE id = ?)
+		ORDER BY port LIMIT 1
+	`, port, excludeID, port+count, port, projectID).Scan(&name, &used)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check port: %w", err)
+	}
+
+	return fmt.Errorf("%w: port %d is already used by service %q", ErrPortConflict, used, name)
+}
+
+// checkHostPorts ensures the services of a project moving to a host use
+// no port that services of other projects on that host already use
+func checkHostPorts(ctx context.Context, tx *sql.Tx, projectID, hostID int64) error {
+	var name, other string
+	var port int
+	err := tx.QueryRowContext(ctx, `
+		SELECT a.name, b.name, MAX(a.port, b.port) FROM services a
+		JOIN services b ON b.host_id = ? AND b.project_id != a.project_id AND b.port > 0
+			AND a.port < b.port + MAX(b.replicas, 1) AND b.port < a.port + MAX(a.replicas, 1)
+		WHERE a.project_id = ? AND a.port > 0
+		ORDER BY a.port LIMIT 1
+	`, hostID, projectID).Scan(&name, &other, &port)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check ports: %w", err)
+	}
+	return fmt.Errorf("%w: service %q uses port %d, which service %q already uses on that host", ErrPortConflict, name, port, other)
+}
+
+// UsedPorts returns the ports configured on any service of a host's projects,
+// including those of every instance of scaled services
+func (s *Storage) UsedPorts(ctx context.Context, hostID int64) (map[int]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT port, MAX(replicas, 1) FROM services WHERE port > 0 AND host_id = ?", hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ports: %w", err)
+	}
+	defer rows.Close()
+
+	ports := map[int]bool{}
+	for rows.Next() {
+		var port, instances int
+		if err := rows.Scan(&port, &instances); err != nil {
+			return nil, fmt.Errorf("failed to scan port: %w", err)
+		}
+		for i := 0; i < instances; i++ {
+			ports[port+i] = true
+		}
+	}
+	return ports, rows.Err()
+}
+
+// PortConflicts lists the ports that several services of one host are
+// configured with. The checks above keep new conflicts out, but databases
+// from before them may hold some, and until they are resolved the unique
+// port index is not created.
+func (s *Storage) PortConflicts(ctx context.Context) ([]PortConflict, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.host_id, COALESCE(h.name, ''), s.port, GROUP_CONCAT(s.name, ', ') FROM services s
+		LEFT JOIN hosts h ON h.id = s.host_id
+		WHERE s.port > 0
+		GROUP BY s.host_id, s.port HAVING COUNT(*) > 1
+		ORDER BY s.host_id, s.port
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list port conflicts: %w", err)
+	}
+	defer rows.Close()
+
+	var conflicts []PortConflict
+	for rows.Next() {
+		var c PortConflict
+		var services string
+		if err := rows.Scan(&c.HostID, &c.Host, &c.Port, &services); err != nil {
+			return nil, fmt.Errorf("failed to scan port conflict: %w", err)
+		}
+		c.Services = strings.Split(services, ", ")
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
+
+// SetServiceReplicas sets how many instances a service runs, once their
+// ports are known to be free
+func (s *Storage) SetServiceReplicas(ctx context.Context, sv *Service, replicas int) error {
+	if err := s.checkPortRangeAvailable(ctx, sv.ProjectID, sv.Port, max(replicas, 1), sv.ID); err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, "UPDATE services SET replicas = ?, updated_at = ? WHERE id = ?", replicas, now, sv.ID); err != nil {
+		return fmt.Errorf("failed to set service replicas: %w", err)
+	}
+	sv.Replicas, sv.UpdatedAt = replicas, now
+	return nil
+}
+
+// ensurePortIndex adds a unique index on the ports of each host's services.
+// Databases that already contain duplicate ports keep working, with only the
+// storage checks enforcing uniqueness; PortConflicts reports the duplicates,
+// and the server's doctor report fails until they are resolved.
+func (s *Storage) ensurePortIndex() error {
+	// Ports used to be unique across every host
+	if _, err := s.db.Exec("DROP INDEX IF EXISTS idx_services_port"); err != nil {
+		return err
+	}
+
+	conflicts, err := s.PortConflicts(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		slog.Warn("Services share ports; resolve the conflicts to enable the unique port index (see servio doctor --remote)", "conflicting_ports", len(conflicts))
+		return nil
+	}
+
+	_, err = s.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_services_host_port ON services(host_id, port) WHERE port > 0")
+	return err
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestServicePortsPerHost(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "servio.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	local, err := s.CreateProject(ctx, &CreateProjectRequest{Name: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := s.CreateProject(ctx, &CreateProjectRequest{Name: "remote"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := &Host{Name: "edge", URL: "https://edge:8421", Ciphertext: []byte("x")}
+	if err := s.RegisterHost(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetProjectHost(ctx, remote.ID, host.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(project *Project, name string, port int) error {
+		_, err := s.CreateService(ctx, &CreateServiceRequest{ProjectID: project.ID, Name: name, Type: "custom", Command: "/bin/true", Port: port})
+		return err
+	}
+	if err := create(local, "api", 8080); err != nil {
+		t.Fatalf("first service on this server: %v", err)
+	}
+	if err := create(remote, "api", 8080); err != nil {
+		t.Fatalf("same port on another host: %v", err)
+	}
+	if err := create(local, "web", 8080); !errors.Is(err, ErrPortConflict) {
+		t.Fatalf("same port on the same host: got %v, want ErrPortConflict", err)
+	}
+
+	used, err := s.UsedPorts(ctx, host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !used[8080] || len(used) != 1 {
+		t.Errorf("UsedPorts(edge) = %v, want only 8080", used)
+	}
+
+	// Moving the remote project back would put two services on 8080 here
+	if _, err := s.SetProjectHost(ctx, remote.ID, 0); !errors.Is(err, ErrPortConflict) {
+		t.Errorf("moving onto a used port: got %v, want ErrPortConflict", err)
+	}
+	if p, err := s.GetProject(ctx, remote.ID); err != nil || p.HostID != host.ID {
+		t.Errorf("refused move changed the project's host: %+v, %v", p, err)
+	}
+
+	// The index enforces the same rule below the checks
+	if _, err := s.db.ExecContext(ctx, "UPDATE services SET host_id = 0 WHERE project_id = ?", remote.ID); err == nil {
+		t.Error("unique port index allowed two services on port 8080 of one host")
+	}
+	if conflicts, err := s.PortConflicts(ctx); err != nil || len(conflicts) != 0 {
+		t.Errorf("PortConflicts() = %v, %v; want none", conflicts, err)
+	}
+}
+
+func TestPortConflicts(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "servio.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	project, err := s.CreateProject(ctx, &CreateProjectRequest{Name: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A database from before the checks, without the index
+	if _, err := s.db.ExecContext(ctx, "DROP INDEX idx_services_host_port"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"api", "web"} {
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO services (project_id, name, type, port, command, user) VALUES (?, ?, 'custom', 9000, '/bin/true', 'root')
+		`, project.ID, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conflicts, err := s.PortConflicts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Port != 9000 || conflicts[0].HostID != 0 || len(conflicts[0].Services) != 2 {
+		t.Fatalf("PortConflicts() = %+v, want port 9000 on this server shared by api and web", conflicts)
+	}
+
+	// The index stays off until the conflict is resolved
+	if err := s.ensurePortIndex(); err != nil {
+		t.Fatal(err)
+	}
+	var indexes int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'idx_services_host_port'").Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 0 {
+		t.Error("unique port index created over conflicting ports")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -11,10 +12,24 @@ import (
 
 // CreateProject creates a new project group
 func (s *Storage) CreateProject(ctx context.Context, req *CreateProjectRequest) (*Project, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Users limited to their teams' projects must create them in one of those teams
+	if teamIDs, scoped := TeamScopeFromContext(ctx); scoped && req.TeamID == 0 && len(teamIDs) == 1 {
+		req.TeamID = teamIDs[0]
+	}
+	if _, scoped := TeamScopeFromContext(ctx); scoped || req.TeamID != 0 {
+		if err := s.checkTeamExists(ctx, req.TeamID); err != nil {
+			return nil, err
+		}
+	}
+
 	result, err := s.db.ExecContext(ctx, `
-		INSERT INTO projects (name, description, domain)
-		VALUES (?, ?, ?)
-	`, req.Name, req.Description, req.Domain)
+		INSERT INTO projects (name, description, domain, notes, tags, team_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, req.Name, req.Description, req.Domain, req.Notes, req.Tags, nullID(req.TeamID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create project: %w", err)
 	}
@@ -24,21 +39,13 @@ func (s *Storage) CreateProject(ctx context.Context, req *CreateProjectRequest)
 		return nil, fmt.Errorf("failed to get last insert ID: %w", err)
 	}
 
-	return s.GetProject(ctx, id)
+	return s.reindexProject(ctx, id)
 }
 
 // GetProject retrieves a project by ID, including its services
 func (s *Storage) GetProject(ctx context.Context, id int64) (*Project, error) {
-	p := &Project{}
-	var domain, nginxRaw sql.NullString
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, description, COALESCE(domain, ''), COALESCE(nginx_raw, ''), created_at, updated_at
-		FROM projects WHERE id = ?
-	`, id).Scan(&p.ID, &p.Name, &p.Description, &domain, &nginxRaw, &p.CreatedAt, &p.UpdatedAt)
-	p.Domain = domain.String
-	p.NginxRaw = nginxRaw.String
-
-	if err == sql.ErrNoRows {
+	p, err := scanProject(s.stmts.getProject.QueryRowContext(ctx, id))
+	if err == sql.ErrNoRows || (err == nil && !inTeamScope(ctx, p.TeamID)) {
 		return nil, nil
 	}
 	if err != nil {
@@ -56,16 +63,8 @@ func (s *Storage) GetProject(ctx context.Context, id int64) (*Project, error) {
 
 // GetProjectByName retrieves a project by name
 func (s *Storage) GetProjectByName(ctx context.Context, name string) (*Project, error) {
-	p := &Project{}
-	var domain, nginxRaw sql.NullString
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, description, COALESCE(domain, ''), COALESCE(nginx_raw, ''), created_at, updated_at
-		FROM projects WHERE name = ?
-	`, name).Scan(&p.ID, &p.Name, &p.Description, &domain, &nginxRaw, &p.CreatedAt, &p.UpdatedAt)
-	p.Domain = domain.String
-	p.NginxRaw = nginxRaw.String
-
-	if err == sql.ErrNoRows {
+	p, err := scanProject(s.db.QueryRowContext(ctx, "SELECT "+projectColumns+" FROM projects WHERE name = ?", name))
+	if err == sql.ErrNoRows || (err == nil && !inTeamScope(ctx, p.TeamID)) {
 		return nil, nil
 	}
 	if err != nil {
@@ -83,10 +82,12 @@ func (s *Storage) GetProjectByName(ctx context.Context, name string) (*Project,
 
 // ListProjects retrieves all projects
 func (s *Storage) ListProjects(ctx context.Context) ([]*Project, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, name, description, COALESCE(domain, ''), COALESCE(nginx_raw, ''), created_at, updated_at
-		FROM projects ORDER BY name ASC
-	`)
+	query := "SELECT " + projectColumns + " FROM projects"
+	cond, args, scoped := teamScopeCond(ctx, "id")
+	if scoped {
+		query += " WHERE " + cond
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY name ASC", args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list projects: %w", err)
 	}
@@ -94,13 +95,10 @@ func (s *Storage) ListProjects(ctx context.Context) ([]*Project, error) {
 
 	var projects []*Project
 	for rows.Next() {
-		p := &Project{}
-		var domain, nginxRaw sql.NullString
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &domain, &nginxRaw, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanProject(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan project: %w", err)
 		}
-		p.Domain = domain.String
-		p.NginxRaw = nginxRaw.String
 		projects = append(projects, p)
 	}
 
@@ -109,15 +107,18 @@ func (s *Storage) ListProjects(ctx context.Context) ([]*Project, error) {
 
 // UpdateProject updates a project group
 func (s *Storage) UpdateProject(ctx context.Context, id int64, req *UpdateProjectRequest) (*Project, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
 	_, err := s.db.ExecContext(ctx, `
-		UPDATE projects SET name = ?, description = ?, domain = ?, updated_at = ?
+		UPDATE projects SET name = ?, description = ?, domain = ?, notes = ?, tags = ?, updated_at = ?
 		WHERE id = ?
-	`, req.Name, req.Description, req.Domain, time.Now(), id)
+	`, req.Name, req.Description, req.Domain, req.Notes, req.Tags, time.Now(), id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update project: %w", err)
 	}
 
-	return s.GetProject(ctx, id)
+	return s.reindexProject(ctx, id)
 }
 
 // UpdateProjectNginxRaw updates only the nginx_raw field of a project
@@ -133,12 +134,32 @@ func (s *Storage) UpdateProjectNginxRaw(ctx context.Context, id int64, nginxRaw
 	return s.GetProject(ctx, id)
 }
 
-// DeleteProject deletes a project and all its services (via CASCADE)
+// SetProjectProtocols sets whether a project's site serves HTTP/2 and HTTP/3
+func (s *Storage) SetProjectProtocols(ctx context.Context, id int64, http2, http3 bool) (*Project, error) {
+	_, err := s.db.ExecContext(ctx, "UPDATE projects SET http2 = ?, http3 = ?, updated_at = ? WHERE id = ?", http2, http3, time.Now(), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set site protocols: %w", err)
+	}
+	return s.GetProject(ctx, id)
+}
+
+// DeleteProject deletes a project. Its services, and their revisions and
+// deployments, are removed by the enforced ON DELETE CASCADE constraints.
 func (s *Storage) DeleteProject(ctx context.Context, id int64) error {
 	_, err := s.db.ExecContext(ctx, "DELETE FROM projects WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete project: %w", err)
 	}
+
+	// Project-scoped secrets are keyed by scope string rather than a foreign key
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM secrets WHERE scope = ?", ProjectScope(id)); err != nil {
+		slog.WarnContext(ctx, "Failed to remove project secrets", "project_id", id, "error", err)
+	}
+
+	// The search index is a virtual table, so CASCADE does not reach it
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM search_index WHERE project_id = ?", id); err != nil {
+		slog.WarnContext(ctx, "Failed to remove project from search index", "project_id", id, "error", err)
+	}
 	return nil
 }
 
@@ -146,15 +167,28 @@ func (s *Storage) DeleteProject(ctx context.Context, id int64) error {
 
 // CreateService adds a service to a project
 func (s *Storage) CreateService(ctx context.Context, req *CreateServiceRequest) (*Service, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if ok, err := s.projectInScope(ctx, req.ProjectID); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "project_id", Message: "project not found"}}}
+	}
+
 	user := req.User
 	if user == "" {
 		user = "root"
 	}
 
+	if err := s.checkPortAvailable(ctx, req.ProjectID, req.Port, 0); err != nil {
+		return nil, err
+	}
+
 	result, err := s.db.ExecContext(ctx, `
-		INSERT INTO services (project_id, name, type, version, port, git_repo_url, command, working_dir, user, environment, auto_restart, config, systemd_raw, nginx_raw)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, req.ProjectID, req.Name, req.Type, req.Version, req.Port, req.GitRepoURL, req.Command, req.WorkingDir, user, req.Environment, req.AutoRestart, req.Config, req.SystemdRaw, req.NginxRaw)
+		INSERT INTO services (project_id, host_id, name, type, version, runtime, image, port, git_repo_url, git_branch, git_filter, command, working_dir, user, environment, auto_restart, config, systemd_raw, nginx_raw, notes, tags)
+		VALUES (?, (SELECT COALESCE(host_id, 0) FROM projects WHERE id = ?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.ProjectID, req.ProjectID, req.Name, req.Type, req.Version, req.Runtime, req.Image, req.Port, req.GitRepoURL, req.GitBranch, req.GitFilter, req.Command, req.WorkingDir, user, req.Environment, req.AutoRestart, req.Config, req.SystemdRaw, req.NginxRaw, req.Notes, req.Tags)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create service: %w", err)
 	}
@@ -164,39 +198,33 @@ func (s *Storage) CreateService(ctx context.Context, req *CreateServiceRequest)
 		return nil, fmt.Errorf("failed to get last insert ID: %w", err)
 	}
 
-	return s.GetService(ctx, id)
+	sv, err := s.reindexService(ctx, id)
+	if err == nil && sv != nil {
+		s.recordServiceRevision(ctx, nil, sv)
+	}
+	return sv, err
 }
 
 // GetService retrieves a service by ID
 func (s *Storage) GetService(ctx context.Context, id int64) (*Service, error) {
-	sv := &Service{}
-	var autoRestart int
-
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, project_id, name, type, version, COALESCE(port, 0), git_repo_url, command, working_dir, user, environment, auto_restart, config, systemd_raw, nginx_raw, created_at, updated_at
-		FROM services WHERE id = ?
-	`, id).Scan(
-		&sv.ID, &sv.ProjectID, &sv.Name, &sv.Type, &sv.Version, &sv.Port, &sv.GitRepoURL, &sv.Command, &sv.WorkingDir,
-		&sv.User, &sv.Environment, &autoRestart, &sv.Config, &sv.SystemdRaw, &sv.NginxRaw, &sv.CreatedAt, &sv.UpdatedAt,
-	)
-
+	sv, err := scanService(s.stmts.getService.QueryRowContext(ctx, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get service: %w", err)
 	}
-
-	sv.AutoRestart = autoRestart == 1
+	if _, scoped := TeamScopeFromContext(ctx); scoped {
+		if ok, err := s.projectInScope(ctx, sv.ProjectID); err != nil || !ok {
+			return nil, err
+		}
+	}
 	return sv, nil
 }
 
 // ListServicesByProject retrieves all services for a project
 func (s *Storage) ListServicesByProject(ctx context.Context, projectID int64) ([]*Service, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, project_id, name, type, version, COALESCE(port, 0), git_repo_url, command, working_dir, user, environment, auto_restart, config, systemd_raw, nginx_raw, created_at, updated_at
-		FROM services WHERE project_id = ? ORDER BY name ASC
-	`, projectID)
+	rows, err := s.stmts.listServicesByProject.QueryContext(ctx, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list services: %w", err)
 	}
@@ -204,35 +232,74 @@ func (s *Storage) ListServicesByProject(ctx context.Context, projectID int64) ([
 
 	var services []*Service
 	for rows.Next() {
-		sv := &Service{}
-		var autoRestart int
-		if err := rows.Scan(
-			&sv.ID, &sv.ProjectID, &sv.Name, &sv.Type, &sv.Version, &sv.Port, &sv.GitRepoURL, &sv.Command, &sv.WorkingDir,
-			&sv.User, &sv.Environment, &autoRestart, &sv.Config, &sv.SystemdRaw, &sv.NginxRaw, &sv.CreatedAt, &sv.UpdatedAt,
-		); err != nil {
+		sv, err := scanService(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan service: %w", err)
 		}
-		sv.AutoRestart = autoRestart == 1
 		services = append(services, sv)
 	}
 
 	return services, rows.Err()
 }
 
-// UpdateService updates a service's configuration
+// UpdateService updates a service's configuration and records the change as a revision
 func (s *Storage) UpdateService(ctx context.Context, id int64, req *UpdateServiceRequest) (*Service, error) {
-	_, err := s.db.ExecContext(ctx, `
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	previous, err := s.GetService(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	replicas, projectID := 1, int64(0)
+	if previous != nil {
+		replicas, projectID = max(previous.Replicas, 1), previous.ProjectID
+	}
+	if err := s.checkPortRangeAvailable(ctx, projectID, req.Port, replicas, id); err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		var v validator
+		v.image(previous.Runtime, req.Image, req.GitRepoURL)
+		if err := v.err(); err != nil {
+			return nil, err
+		}
+		s.ensureBaselineRevision(ctx, previous)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
 		UPDATE services SET
-			name = ?, port = ?, git_repo_url = ?, command = ?, working_dir = ?, user = ?,
-			environment = ?, auto_restart = ?, config = ?, systemd_raw = ?, nginx_raw = ?, updated_at = ?
+			name = ?, image = ?, port = ?, git_repo_url = ?, git_branch = ?, git_filter = ?, command = ?, working_dir = ?, user = ?,
+			environment = ?, auto_restart = ?, config = ?, systemd_raw = ?, nginx_raw = ?, notes = ?, tags = ?, updated_at = ?
 		WHERE id = ?
-	`, req.Name, req.Port, req.GitRepoURL, req.Command, req.WorkingDir, req.User,
-		req.Environment, req.AutoRestart, req.Config, req.SystemdRaw, req.NginxRaw, time.Now(), id)
+	`, req.Name, req.Image, req.Port, req.GitRepoURL, req.GitBranch, req.GitFilter, req.Command, req.WorkingDir, req.User,
+		req.Environment, req.AutoRestart, req.Config, req.SystemdRaw, req.NginxRaw, req.Notes, req.Tags, time.Now(), id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update service: %w", err)
 	}
 
-	return s.GetService(ctx, id)
+	sv, err := s.reindexService(ctx, id)
+	if err == nil && sv != nil && previous != nil {
+		s.recordServiceRevision(ctx, previous, sv)
+	}
+	return sv, err
+}
+
+// UnitRuntime returns the runtime of the service a unit name belongs to;
+// RuntimeSystemd for units Servio does not manage
+func (s *Storage) UnitRuntime(ctx context.Context, unit string) (string, error) {
+	var runtime string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT runtime FROM services WHERE 'servio-' || name || '.service' = ?
+		ORDER BY runtime DESC LIMIT 1
+	`, unit).Scan(&runtime)
+	if err == sql.ErrNoRows || (err == nil && runtime == "") {
+		return RuntimeSystemd, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up unit runtime: %w", err)
+	}
+	return runtime, nil
 }
 
 // DeleteService deletes a service by ID
@@ -241,17 +308,47 @@ func (s *Storage) DeleteService(ctx context.Context, id int64) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete service: %w", err)
 	}
+
+	if err := s.unindex(ctx, SearchKindService, id); err != nil {
+		slog.WarnContext(ctx, "Failed to remove service from search index", "service_id", id, "error", err)
+	}
 	return nil
 }
 
+// scanProject reads a row selected with projectColumns
+func scanProject(row rowScanner) (*Project, error) {
+	p := &Project{}
+	var http2, http3 int
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Domain, &p.NginxRaw, &p.Notes, &p.Tags, &p.TeamID, &p.HostID, &p.Certificate, &http2, &http3, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.HTTP2, p.HTTP3 = http2 == 1, http3 == 1
+	return p, nil
+}
+
+// scanService reads a row selected with serviceColumns
+func scanService(row rowScanner) (*Service, error) {
+	sv := &Service{}
+	var autoRestart int
+	if err := row.Scan(
+		&sv.ID, &sv.ProjectID, &sv.Name, &sv.Type, &sv.Version, &sv.Runtime, &sv.Image, &sv.Port, &sv.GitRepoURL, &sv.GitBranch, &sv.GitFilter, &sv.Command, &sv.WorkingDir,
+		&sv.User, &sv.Environment, &autoRestart, &sv.Config, &sv.SystemdRaw, &sv.NginxRaw, &sv.Notes, &sv.Tags, &sv.Replicas, &sv.CreatedAt, &sv.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	sv.AutoRestart = autoRestart == 1
+	return sv, nil
+}
+
 // --- Settings Methods ---
 
-// GetSetting retrieves a setting by key
+// GetSetting retrieves a setting by key, returning the registered default when it is unset
 func (s *Storage) GetSetting(ctx context.Context, key string) (string, error) {
 	var value string
-	err := s.db.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	err := s.stmts.getSetting.QueryRowContext(ctx, key).Scan(&value)
 	if err == sql.ErrNoRows {
-		return "", nil
+		def, _ := LookupSetting(key)
+		return def.Default, nil
 	}
 	if err != nil {
 		return "", fmt.Errorf("failed to get setting: %w", err)
@@ -259,9 +356,18 @@ func (s *Storage) GetSetting(ctx context.Context, key string) (string, error) {
 	return value, nil
 }
 
-// SetSetting saves or updates a setting
+// SetSetting validates and saves a registered setting
 func (s *Storage) SetSetting(ctx context.Context, key string, value string) error {
-	_, err := s.db.ExecContext(ctx, `
+	def, ok := LookupSetting(key)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	value, err := def.Normalize(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
 		INSERT INTO settings (key, value) VALUES (?, ?)
 		ON CONFLICT(key) DO UPDATE SET value = EXCLUDED.value
 	`, key, value)
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type actorKey struct{}
+
+// defaultActor is recorded when a change is not attributable to a user
+const defaultActor = "system"
+
+// WithActor returns a context that attributes storage changes to the given user
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the user a change should be attributed to
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return defaultActor
+}
+
+// ListServiceRevisions returns the change history of a service, newest first
+func (s *Storage) ListServiceRevisions(ctx context.Context, serviceID int64) ([]*ServiceRevision, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, service_id, actor, changes, snapshot, created_at
+		FROM service_revisions WHERE service_id = ? ORDER BY id DESC
+	`, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []*ServiceRevision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetServiceRevision retrieves a single revision by ID
+func (s *Storage) GetServiceRevision(ctx context.Context, id int64) (*ServiceRevision, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, service_id, actor, changes, snapshot, created_at
+		FROM service_revisions WHERE id = ?
+	`, id)
+
+	rev, err := scanRevision(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return rev, err
+}
+
+// recordServiceRevision stores the difference between two versions of a service.
+// A nil previous version records the initial state. No-op updates are skipped.
+func (s *Storage) recordServiceRevision(ctx context.Context, previous, current *Service) {
+	if previous == nil {
+		previous = &Service{}
+	}
+
+	changes := diffServices(previous, current)
+	if len(changes) == 0 {
+		return
+	}
+
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to encode revision changes", "service_id", current.ID, "error", err)
+		return
+	}
+	snapshotJSON, err := json.Marshal(snapshotService(current))
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to encode revision snapshot", "service_id", current.ID, "error", err)
+		return
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO service_revisions (service_id, actor, changes, snapshot, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, current.ID, ActorFromContext(ctx), string(changesJSON), string(snapshotJSON), time.Now())
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record service revision", "service_id", current.ID, "error", err)
+	}
+}
+
+// ensureBaselineRevision records the current state of a service that predates
+// revision tracking, so its original configuration can still be reverted to
+func (s *Storage) ensureBaselineRevision(ctx context.Context, sv *Service) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM service_revisions WHERE service_id = ?", sv.ID).Scan(&count); err != nil {
+		slog.WarnContext(ctx, "Failed to count service revisions", "service_id", sv.ID, "error", err)
+		return
+	}
+	if count == 0 {
+		s.recordServiceRevision(WithActor(ctx, defaultActor), nil, sv)
+	}
+}
+
+// snapshotService captures the user-editable configuration of a service
+func snapshotService(sv *Service) UpdateServiceRequest {
+	return UpdateServiceRequest{
+		Name:        sv.Name,
+		Image:       sv.Image,
+		Port:        sv.Port,
+		GitRepoURL:  sv.GitRepoURL,
+		GitBranch:   sv.GitBranch,
+		GitFilter:   sv.GitFilter,
+		Command:     sv.Command,
+		WorkingDir:  sv.WorkingDir,
+		User:        sv.User,
+		Environment: sv.Environment,
+		AutoRestart: sv.AutoRestart,
+		Config:      sv.Config,
+		SystemdRaw:  sv.SystemdRaw,
+		NginxRaw:    sv.NginxRaw,
+		Tags:        sv.Tags,
+	}
+}
+
+// diffServices returns field-level changes between two versions of a service
+func diffServices(old, new *Service) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, o, n interface{}) {
+		if o != n {
+			changes = append(changes, FieldChange{Field: field, Old: o, New: n})
+		}
+	}
+
+	add("name", old.Name, new.Name)
+	add("image", old.Image, new.Image)
+	add("port", old.Port, new.Port)
+	add("git_repo_url", old.GitRepoURL, new.GitRepoURL)
+	add("git_branch", old.GitBranch, new.GitBranch)
+	add("git_filter", old.GitFilter, new.GitFilter)
+	add("command", old.Command, new.Command)
+	add("working_dir", old.WorkingDir, new.WorkingDir)
+	add("user", old.User, new.User)
+	add("environment", old.Environment, new.Environment)
+	add("auto_restart", old.AutoRestart, new.AutoRestart)
+	add("config", old.Config, new.Config)
+	add("systemd_raw", old.SystemdRaw, new.SystemdRaw)
+	add("nginx_raw", old.NginxRaw, new.NginxRaw)
+	add("tags", old.Tags.String(), new.Tags.String())
+
+	return changes
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRevision(row rowScanner) (*ServiceRevision, error) {
+	rev := &ServiceRevision{}
+	var changesJSON, snapshotJSON string
+	if err := row.Scan(&rev.ID, &rev.ServiceID, &rev.Actor, &changesJSON, &snapshotJSON, &rev.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan revision: %w", err)
+	}
+	if err := json.Unmarshal([]byte(changesJSON), &rev.Changes); err != nil {
+		return nil, fmt.Errorf("failed to decode revision changes: %w", err)
+	}
+	if err := json.Unmarshal([]byte(snapshotJSON), &rev.Snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode revision snapshot: %w", err)
+	}
+	return rev, nil
+}
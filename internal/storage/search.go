@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Search index entry kinds
+const (
+	SearchKindProject = "project"
+	SearchKindService = "service"
+)
+
+// defaultSearchLimit caps the number of search results when no limit is given
+const defaultSearchLimit = 20
+
+// Search runs a full-text query over projects and services.
+// Each whitespace-separated term is matched as a prefix, and all terms must match.
+func (s *Storage) Search(ctx context.Context, query string, limit int) ([]*SearchResult, error) {
+	match := buildMatchQuery(query)
+	if match == "" {
+		return []*SearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	where, args := "search_index MATCH ?", []interface{}{match}
+	if cond, scopeArgs, scoped := teamScopeCond(ctx, "project_id"); scoped {
+		where += " AND " + cond
+		args = append(args, scopeArgs...)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT kind, ref_id, project_id, name,
+			snippet(search_index, -1, '[', ']', '…', 12)
+		FROM search_index
+		WHERE `+where+`
+		ORDER BY rank
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		r := &SearchResult{}
+		if err := rows.Scan(&r.Kind, &r.ID, &r.ProjectID, &r.Name, &r.Snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// indexProject writes (or replaces) the search entry for a project
+func (s *Storage) indexProject(ctx context.Context, p *Project) error {
+	if _, err := s.db.ExecContext(ctx,
+		"DELETE FROM search_index WHERE kind = ? AND ref_id = ?", SearchKindProject, p.ID); err != nil {
+		return fmt.Errorf("failed to clear project search entry: %w", err)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO search_index (kind, ref_id, project_id, name, description, content)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, SearchKindProject, p.ID, p.ID, p.Name, p.Description, strings.Join(append([]string{p.Domain, p.Notes}, p.Tags...), " "))
+	if err != nil {
+		return fmt.Errorf("failed to index project: %w", err)
+	}
+	return nil
+}
+
+// indexService writes (or replaces) the search entry for a service.
+// Only environment variable keys are indexed, never their values.
+func (s *Storage) indexService(ctx context.Context, sv *Service) error {
+	if _, err := s.db.ExecContext(ctx,
+		"DELETE FROM search_index WHERE kind = ? AND ref_id = ?", SearchKindService, sv.ID); err != nil {
+		return fmt.Errorf("failed to clear service search entry: %w", err)
+	}
+
+	content := []string{sv.Type, sv.Image, sv.Command, sv.WorkingDir, sv.GitRepoURL}
+	if sv.Port > 0 {
+		content = append(content, "port "+strconv.Itoa(sv.Port))
+	}
+	content = append(content, envKeys(sv.Environment)...)
+	content = append(content, sv.Notes)
+	content = append(content, sv.Tags...)
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO search_index (kind, ref_id, project_id, name, description, content)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, SearchKindService, sv.ID, sv.ProjectID, sv.Name, "", strings.Join(content, " "))
+	if err != nil {
+		return fmt.Errorf("failed to index service: %w", err)
+	}
+	return nil
+}
+
+// reindexProject loads a project and refreshes its search entry.
+// Index failures are logged rather than returned since the write itself succeeded.
+func (s *Storage) reindexProject(ctx context.Context, id int64) (*Project, error) {
+	p, err := s.GetProject(ctx, id)
+	if err != nil || p == nil {
+		return p, err
+	}
+	if err := s.indexProject(ctx, p); err != nil {
+		slog.WarnContext(ctx, "Failed to index project", "project_id", id, "error", err)
+	}
+	return p, nil
+}
+
+// reindexService loads a service and refreshes its search entry
+func (s *Storage) reindexService(ctx context.Context, id int64) (*Service, error) {
+	sv, err := s.GetService(ctx, id)
+	if err != nil || sv == nil {
+		return sv, err
+	}
+	if err := s.indexService(ctx, sv); err != nil {
+		slog.WarnContext(ctx, "Failed to index service", "service_id", id, "error", err)
+	}
+	return sv, nil
+}
+
+// unindex removes a single entry from the search index
+func (s *Storage) unindex(ctx context.Context, kind string, id int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM search_index WHERE kind = ? AND ref_id = ?", kind, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove search entry: %w", err)
+	}
+	return nil
+}
+
+// ensureSearchIndex populates the search index from existing rows when it is empty,
+// e.g. right after the index table was introduced on an existing database
+func (s *Storage) ensureSearchIndex(ctx context.Context) error {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM search_index").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	projects, err := s.ListProjects(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range projects {
+		if err := s.indexProject(ctx, p); err != nil {
+			return err
+		}
+		services, err := s.ListServicesByProject(ctx, p.ID)
+		if err != nil {
+			return err
+		}
+		for _, sv := range services {
+			if err := s.indexService(ctx, sv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// buildMatchQuery turns free-form user input into a safe FTS5 MATCH expression.
+// Terms are quoted so FTS operators in user input are treated literally.
+func buildMatchQuery(query string) string {
+	var terms []string
+	for _, term := range strings.Fields(query) {
+		term = strings.Trim(term, `"':`)
+		if term == "" {
+			continue
+		}
+		term = strings.ReplaceAll(term, `"`, `""`)
+		terms = append(terms, `"`+term+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// envKeys extracts the variable names from a KEY=VALUE newline separated block
+func envKeys(environment string) []string {
+	var keys []string
+	for _, line := range strings.Split(environment, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, _, ok := strings.Cut(line, "="); ok && key != "" {
+			keys = append(keys, strings.TrimSpace(key))
+		}
+	}
+	return keys
+}
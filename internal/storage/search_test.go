@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildMatchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"api", `"api"*`},
+		{"shop  api", `"shop"* "api"*`},
+		// FTS5 operators and syntax are searched for as text
+		{"api OR web", `"api"* "OR"* "web"*`},
+		{"NEAR(api web)", `"NEAR(api"* "web)"*`},
+		{"-api +web api*", `"-api"* "+web"* "api*"*`},
+		{"name:api", `"name:api"*`},
+		// Quotes and colons around a term are dropped; quotes inside are escaped
+		{`"api" 'web' :db:`, `"api"* "web"* "db"*`},
+		{`say"hi`, `"say""hi"*`},
+		{`" ' :`, ""},
+	}
+	for _, tt := range tests {
+		if got := buildMatchQuery(tt.query); got != tt.want {
+			t.Errorf("buildMatchQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchOperatorsInInput(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(filepath.Join(t.TempDir(), "servio.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.CreateProject(ctx, &CreateProjectRequest{Name: "shop", Description: "storefront API"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"store", "sto", "shop OR", `NEAR("shop"`, "shop*", "-shop", `"`, "^shop", "AND"} {
+		if _, err := s.Search(ctx, query, 10); err != nil {
+			t.Errorf("Search(%q) error = %v", query, err)
+		}
+	}
+	results, err := s.Search(ctx, "store", 10)
+	if err != nil || len(results) != 1 || results[0].Name != "shop" {
+		t.Errorf("Search(store) = %v, %v; want the shop project by prefix", results, err)
+	}
+}
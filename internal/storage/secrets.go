@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecretScopeGlobal makes a secret available to every service
+const SecretScopeGlobal = "global"
+
+// MaskedSecretValue replaces secret values in all read responses
+const MaskedSecretValue = "********"
+
+// ErrInvalidScope is returned for secret scopes other than "global" or "project:<id>"
+var ErrInvalidScope = errors.New("invalid secret scope")
+
+// ProjectScope returns the secret scope for a single project
+func ProjectScope(projectID int64) string {
+	return "project:" + strconv.FormatInt(projectID, 10)
+}
+
+// ParseScope validates a scope string, returning the project ID for project scopes (0 for global)
+func ParseScope(scope string) (int64, error) {
+	if scope == SecretScopeGlobal {
+		return 0, nil
+	}
+	idStr, ok := strings.CutPrefix(scope, "project:")
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+	}
+	return id, nil
+}
+
+// CreateSecret stores an encrypted secret, replacing any existing value for the same key and scope
+func (s *Storage) CreateSecret(ctx context.Context, key, scope string, ciphertext []byte) (*Secret, error) {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO secrets (key, scope, value, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key, scope) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+	`, key, scope, ciphertext, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save secret: %w", err)
+	}
+	return s.GetSecretByKey(ctx, key, scope)
+}
+
+// GetSecret retrieves a secret by ID
+func (s *Storage) GetSecret(ctx context.Context, id int64) (*Secret, error) {
+	return s.scanSecret(s.db.QueryRowContext(ctx, `
+		SELECT id, key, scope, value, created_at, updated_at FROM secrets WHERE id = ?
+	`, id))
+}
+
+// GetSecretByKey retrieves a secret by key within a single scope
+func (s *Storage) GetSecretByKey(ctx context.Context, key, scope string) (*Secret, error) {
+	return s.scanSecret(s.db.QueryRowContext(ctx, `
+		SELECT id, key, scope, value, created_at, updated_at FROM secrets WHERE key = ? AND scope = ?
+	`, key, scope))
+}
+
+// ListSecrets returns secrets, optionally filtered by scope. Values are masked.
+func (s *Storage) ListSecrets(ctx context.Context, scope string) ([]*Secret, error) {
+	query := "SELECT id, key, scope, created_at, updated_at FROM secrets"
+	var args []interface{}
+	if scope != "" {
+		query += " WHERE scope = ?"
+		args = append(args, scope)
+	}
+	query += " ORDER BY scope ASC, key ASC"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	defer rows.Close()
+
+	secrets := []*Secret{}
+	for rows.Next() {
+		sec := &Secret{Value: MaskedSecretValue}
+		if err := rows.Scan(&sec.ID, &sec.Key, &sec.Scope, &sec.CreatedAt, &sec.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan secret: %w", err)
+		}
+		secrets = append(secrets, sec)
+	}
+	return secrets, rows.Err()
+}
+
+// UpdateSecret replaces the encrypted value of a secret
+func (s *Storage) UpdateSecret(ctx context.Context, id int64, ciphertext []byte) (*Secret, error) {
+	_, err := s.db.ExecContext(ctx, "UPDATE secrets SET value = ?, updated_at = ? WHERE id = ?", ciphertext, time.Now(), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update secret: %w", err)
+	}
+	return s.GetSecret(ctx, id)
+}
+
+// DeleteSecret removes a secret by ID
+func (s *Storage) DeleteSecret(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM secrets WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) scanSecret(row *sql.Row) (*Secret, error) {
+	sec := &Secret{Value: MaskedSecretValue}
+	err := row.Scan(&sec.ID, &sec.Key, &sec.Scope, &sec.Ciphertext, &sec.CreatedAt, &sec.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	return sec, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ListServiceTemplates returns the service templates by name
+func (s *Storage) ListServiceTemplates(ctx context.Context) ([]*ServiceTemplate, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, description, spec, created_at, updated_at FROM service_templates ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []*ServiceTemplate{}
+	for rows.Next() {
+		t, err := scanServiceTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// GetServiceTemplate retrieves a service template by ID, or nil if there is none
+func (s *Storage) GetServiceTemplate(ctx context.Context, id int64) (*ServiceTemplate, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, name, description, spec, created_at, updated_at FROM service_templates WHERE id = ?", id)
+	t, err := scanServiceTemplate(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// CreateServiceTemplate inserts a service template
+func (s *Storage) CreateServiceTemplate(ctx context.Context, t *ServiceTemplate) error {
+	spec, err := json.Marshal(t.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to encode service template: %w", err)
+	}
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO service_templates (name, description, spec, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+	`, t.Name, t.Description, string(spec), now, now)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "is already used by another template"}}}
+		}
+		return fmt.Errorf("failed to create service template: %w", err)
+	}
+	t.ID, _ = result.LastInsertId()
+	t.CreatedAt, t.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateServiceTemplate replaces a service template's name, description, and spec
+func (s *Storage) UpdateServiceTemplate(ctx context.Context, t *ServiceTemplate) error {
+	spec, err := json.Marshal(t.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to encode service template: %w", err)
+	}
+	t.UpdatedAt = time.Now()
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE service_templates SET name = ?, description = ?, spec = ?, updated_at = ? WHERE id = ?
+	`, t.Name, t.Description, string(spec), t.UpdatedAt, t.ID)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return &ValidationError{Fields: []FieldError{{Field: "name", Message: "is already used by another template"}}}
+		}
+		return fmt.Errorf("failed to update service template: %w", err)
+	}
+	return nil
+}
+
+// DeleteServiceTemplate removes a service template; services created from it are kept
+func (s *Storage) DeleteServiceTemplate(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM service_templates WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete service template: %w", err)
+	}
+	return nil
+}
+
+func scanServiceTemplate(row rowScanner) (*ServiceTemplate, error) {
+	t := &ServiceTemplate{}
+	var spec string
+	if err := row.Scan(&t.ID, &t.Name, &t.Description, &spec, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan service template: %w", err)
+	}
+	if err := json.Unmarshal([]byte(spec), &t.Spec); err != nil {
+		return nil, fmt.Errorf("failed to decode service template %s: %w", t.Name, err)
+	}
+	return t, nil
+}
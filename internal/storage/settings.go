@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Setting value types
+const (
+	SettingTypeBool   = "bool"
+	SettingTypeInt    = "int"
+	SettingTypeEnum   = "enum"
+	SettingTypeJSON   = "json"
+	SettingTypeString = "string"
+)
+
+// Known setting keys
+const (
+	SettingDistro                  = "distro"
+	SettingDashboardRefreshSeconds = "dashboard_refresh_seconds"
+	SettingDeployRestart           = "deploy_restart"
+	SettingLogForwardSink          = "log_forward_sink"
+	SettingLogForwardURL           = "log_forward_url"
+	SettingLogForwardIndex         = "log_forward_index"
+	SettingDNSCheck                = "dns_check"
+	SettingPublicIP                = "public_ip"
+	SettingNginxIPv6               = "nginx_ipv6"
+	SettingNginxUpstreamHost       = "nginx_upstream_host"
+	SettingACMEEmail               = "acme_email"
+	SettingACMEDNSProvider         = "acme_dns_provider"
+	SettingACMEDNSCredentials      = "acme_dns_credentials"
+	SettingACMEStaging             = "acme_staging"
+	SettingBlueprintCatalogURL     = "blueprint_catalog_url"
+	SettingBlueprintCatalogKey     = "blueprint_catalog_key"
+)
+
+var (
+	ErrUnknownSetting = errors.New("unknown setting")
+	ErrInvalidSetting = errors.New("invalid setting value")
+)
+
+// settingRegistry lists every setting Servio understands. Values outside the
+// registry are rejected so typos don't silently create dead settings.
+var settingRegistry = map[string]SettingDefinition{
+	SettingDistro: {
+		Key:         SettingDistro,
+		Type:        SettingTypeEnum,
+		Options:     []string{"ubuntu", "debian", "amazon-linux", "rhel"},
+		Description: "Server distribution; selects the nginx sites layout. Empty until setup is completed.",
+	},
+	SettingDashboardRefreshSeconds: {
+		Key:         SettingDashboardRefreshSeconds,
+		Type:        SettingTypeInt,
+		Default:     "2",
+		Min:         intPtr(1),
+		Max:         intPtr(300),
+		Description: "How often the dashboard polls stats and service status, in seconds",
+	},
+	SettingDeployRestart: {
+		Key:         SettingDeployRestart,
+		Type:        SettingTypeBool,
+		Default:     "true",
+		Description: "Restart the service at the end of a deployment",
+	},
+	SettingLogForwardSink: {
+		Key:         SettingLogForwardSink,
+		Type:        SettingTypeEnum,
+		Default:     "none",
+		Options:     []string{"none", "loki", "syslog", "elasticsearch"},
+		Description: "Where journal entries of services with log forwarding enabled are shipped",
+	},
+	SettingLogForwardURL: {
+		Key:         SettingLogForwardURL,
+		Type:        SettingTypeString,
+		Description: "Log sink address: the Loki push URL, udp://, tcp://, or tls:// host:port for syslog, or the Elasticsearch URL. User info is sent as basic auth.",
+	},
+	SettingLogForwardIndex: {
+		Key:         SettingLogForwardIndex,
+		Type:        SettingTypeString,
+		Default:     "servio-logs",
+		Description: "Elasticsearch index forwarded entries are written to",
+	},
+	SettingDNSCheck: {
+		Key:         SettingDNSCheck,
+		Type:        SettingTypeEnum,
+		Default:     "enforce",
+		Options:     []string{"enforce", "warn", "off"},
+		Description: "Whether nginx deploys check that the project's domain resolves to the server: enforce refuses mismatches, warn only logs them",
+	},
+	SettingPublicIP: {
+		Key:         SettingPublicIP,
+		Type:        SettingTypeString,
+		Description: "Comma-separated public addresses of this server for the DNS check. Empty uses the public addresses of its interfaces, which a server behind NAT has none of.",
+	},
+	SettingNginxIPv6: {
+		Key:         SettingNginxIPv6,
+		Type:        SettingTypeBool,
+		Default:     "false",
+		Description: "Generated nginx sites also listen on IPv6 ([::]:80, and [::]:443 with a certificate); needs IPv6 enabled on the host",
+	},
+	SettingNginxUpstreamHost: {
+		Key:         SettingNginxUpstreamHost,
+		Type:        SettingTypeString,
+		Default:     "127.0.0.1",
+		Description: "Address generated nginx sites proxy to services on, e.g. ::1 for services that only listen on IPv6",
+	},
+	SettingACMEEmail: {
+		Key:         SettingACMEEmail,
+		Type:        SettingTypeString,
+		Description: "Email address Let's Encrypt sends certificate expiry notices to; required to issue certificates",
+	},
+	SettingACMEDNSProvider: {
+		Key:         SettingACMEDNSProvider,
+		Type:        SettingTypeEnum,
+		Default:     "none",
+		Options:     []string{"none", "cloudflare", "route53", "digitalocean"},
+		Description: "DNS provider whose API answers the DNS-01 challenge when issuing certificates",
+	},
+	SettingACMEDNSCredentials: {
+		Key:         SettingACMEDNSCredentials,
+		Type:        SettingTypeString,
+		Description: "API token of the DNS provider, usually a secret reference such as ${secret:CLOUDFLARE_API_TOKEN}. Route53 uses root's AWS credentials instead.",
+	},
+	SettingACMEStaging: {
+		Key:         SettingACMEStaging,
+		Type:        SettingTypeBool,
+		Default:     "false",
+		Description: "Issue certificates from Let's Encrypt's staging environment, for testing",
+	},
+	SettingBlueprintCatalogURL: {
+		Key:         SettingBlueprintCatalogURL,
+		Type:        SettingTypeString,
+		Description: "URL of the blueprint catalog's index.json; its signature is fetched from the same URL with .sig appended",
+	},
+	SettingBlueprintCatalogKey: {
+		Key:         SettingBlueprintCatalogKey,
+		Type:        SettingTypeString,
+		Description: "Base64 Ed25519 public key the catalog index must be signed with; the catalog cannot be used without it",
+	},
+}
+
+// SettingDefinitions returns all registered settings ordered by key
+func SettingDefinitions() []SettingDefinition {
+	defs := make([]SettingDefinition, 0, len(settingRegistry))
+	for _, def := range settingRegistry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Key < defs[j].Key })
+	return defs
+}
+
+// LookupSetting returns the definition of a registered setting
+func LookupSetting(key string) (SettingDefinition, bool) {
+	def, ok := settingRegistry[key]
+	return def, ok
+}
+
+// Normalize validates value against the definition and returns its canonical form
+// (e.g. "on" becomes "true" for bools, JSON is compacted)
+func (d SettingDefinition) Normalize(value string) (string, error) {
+	value = strings.TrimSpace(value)
+
+	switch d.Type {
+	case SettingTypeBool:
+		switch strings.ToLower(value) {
+		case "true", "1", "on", "yes":
+			return "true", nil
+		case "false", "0", "off", "no":
+			return "false", nil
+		}
+		return "", fmt.Errorf("%w: %s must be true or false", ErrInvalidSetting, d.Key)
+
+	case SettingTypeInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s must be an integer", ErrInvalidSetting, d.Key)
+		}
+		if d.Min != nil && n < *d.Min {
+			return "", fmt.Errorf("%w: %s must be at least %d", ErrInvalidSetting, d.Key, *d.Min)
+		}
+		if d.Max != nil && n > *d.Max {
+			return "", fmt.Errorf("%w: %s must be at most %d", ErrInvalidSetting, d.Key, *d.Max)
+		}
+		return strconv.Itoa(n), nil
+
+	case SettingTypeEnum:
+		for _, opt := range d.Options {
+			if value == opt {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%w: %s must be one of %s", ErrInvalidSetting, d.Key, strings.Join(d.Options, ", "))
+
+	case SettingTypeJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return "", fmt.Errorf("%w: %s must be valid JSON", ErrInvalidSetting, d.Key)
+		}
+		compact, _ := json.Marshal(v)
+		return string(compact), nil
+	}
+
+	return value, nil
+}
+
+// ListSettings returns every registered setting with its current value
+func (s *Storage) ListSettings(ctx context.Context) ([]*Setting, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT key, value FROM settings")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list settings: %w", err)
+	}
+	defer rows.Close()
+
+	stored := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan setting: %w", err)
+		}
+		stored[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	settings := []*Setting{}
+	for _, def := range SettingDefinitions() {
+		value, ok := stored[def.Key]
+		if !ok {
+			value = def.Default
+		}
+		settings = append(settings, &Setting{SettingDefinition: def, Value: value, IsDefault: !ok})
+	}
+	return settings, nil
+}
+
+// GetSettingBool returns a bool setting, falling back to its default
+func (s *Storage) GetSettingBool(ctx context.Context, key string) (bool, error) {
+	value, err := s.GetSetting(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return value == "true", nil
+}
+
+// GetSettingInt returns an int setting, falling back to its default
+func (s *Storage) GetSettingInt(ctx context.Context, key string) (int, error) {
+	value, err := s.GetSetting(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s is not an integer", ErrInvalidSetting, key)
+	}
+	return n, nil
+}
+
+func intPtr(n int) *int {
+	return &n
+}
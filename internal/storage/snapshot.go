@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// Snapshot writes a consistent copy of the database at dbPath to dest with
+// VACUUM INTO, which is safe while the server has the database open
+func Snapshot(ctx context.Context, dbPath, dest string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database not found: %w", err)
+	}
+	db, err := sql.Open("sqlite", dsn(dbPath))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", dest); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return nil
+}
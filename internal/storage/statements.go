@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Column lists shared by the project and service queries
+const (
+	projectColumns = `id, name, description, COALESCE(domain, ''), COALESCE(nginx_raw, ''), COALESCE(notes, ''), tags, COALESCE(team_id, 0), COALESCE(host_id, 0),
+		COALESCE((SELECT name FROM certificates WHERE project_id = projects.id AND expires_at IS NOT NULL), ''), http2, http3, created_at, updated_at`
+	serviceColumns = `id, project_id, name, type, version, runtime, image, COALESCE(port, 0), git_repo_url, git_branch, git_filter, command, working_dir, user, environment, auto_restart, config, systemd_raw, nginx_raw, COALESCE(notes, ''), tags, replicas, created_at, updated_at`
+)
+
+// statements holds prepared statements for the queries hit on every dashboard
+// refresh and API poll
+type statements struct {
+	getProject            *sql.Stmt
+	getService            *sql.Stmt
+	listServicesByProject *sql.Stmt
+	getSetting            *sql.Stmt
+}
+
+func prepareStatements(db *sql.DB) (*statements, error) {
+	st := &statements{}
+	queries := []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&st.getProject, `SELECT ` + projectColumns + ` FROM projects WHERE id = ?`},
+		{&st.getService, `SELECT ` + serviceColumns + ` FROM services WHERE id = ?`},
+		{&st.listServicesByProject, `SELECT ` + serviceColumns + ` FROM services WHERE project_id = ? ORDER BY name ASC`},
+		{&st.getSetting, `SELECT value FROM settings WHERE key = ?`},
+	}
+
+	for _, q := range queries {
+		stmt, err := db.Prepare(q.query)
+		if err != nil {
+			st.close()
+			return nil, fmt.Errorf("failed to prepare statement %q: %w", q.query, err)
+		}
+		*q.dst = stmt
+	}
+	return st, nil
+}
+
+func (st *statements) close() {
+	for _, stmt := range []*sql.Stmt{st.getProject, st.getService, st.listServicesByProject, st.getSetting} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tags is a normalized set of labels such as "prod" or "team-web".
+// It is stored as ",prod,team-web," so a single tag can be matched with instr().
+type Tags []string
+
+// ParseTags splits comma- or space-separated input into normalized tags:
+// lowercased, deduplicated, and sorted
+func ParseTags(input string) Tags {
+	fields := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	return Tags(fields).normalize()
+}
+
+func (t Tags) normalize() Tags {
+	seen := make(map[string]bool, len(t))
+	out := make(Tags, 0, len(t))
+	for _, tag := range t {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || strings.Contains(tag, ",") || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// String joins the tags for display in forms
+func (t Tags) String() string {
+	return strings.Join(t, ", ")
+}
+
+// Value implements driver.Valuer
+func (t Tags) Value() (driver.Value, error) {
+	t = t.normalize()
+	if len(t) == 0 {
+		return "", nil
+	}
+	return "," + strings.Join(t, ",") + ",", nil
+}
+
+// Scan implements sql.Scanner
+func (t *Tags) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", src)
+	}
+	*t = ParseTags(s)
+	return nil
+}
+
+// tagMatch is the SQL condition matching rows carrying a tag, bound to the tag itself
+const tagMatch = "instr(tags, ',' || ? || ',') > 0"
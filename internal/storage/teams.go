@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// teamScopeKey carries the teams whose projects a request may see
+type teamScopeKey struct{}
+
+// WithTeamScope limits every project lookup made with ctx to projects owned by
+// teamIDs, and services, jobs, and search results to those projects. Contexts
+// without a scope (admins, background work) see everything.
+func WithTeamScope(ctx context.Context, teamIDs []int64) context.Context {
+	if teamIDs == nil {
+		teamIDs = []int64{}
+	}
+	return context.WithValue(ctx, teamScopeKey{}, teamIDs)
+}
+
+// TeamScopeFromContext returns the teams ctx is limited to, and false if it is unscoped
+func TeamScopeFromContext(ctx context.Context) ([]int64, bool) {
+	teamIDs, ok := ctx.Value(teamScopeKey{}).([]int64)
+	return teamIDs, ok
+}
+
+// inTeamScope reports whether a project owned by teamID is visible through ctx
+func inTeamScope(ctx context.Context, teamID int64) bool {
+	teamIDs, scoped := TeamScopeFromContext(ctx)
+	return !scoped || (teamID != 0 && slices.Contains(teamIDs, teamID))
+}
+
+// teamScopeCond renders a condition that keeps rows whose column holds a
+// project ID visible through ctx; ok is false for unscoped contexts
+func teamScopeCond(ctx context.Context, column string) (cond string, args []interface{}, ok bool) {
+	teamIDs, scoped := TeamScopeFromContext(ctx)
+	if !scoped {
+		return "", nil, false
+	}
+	if len(teamIDs) == 0 {
+		return "0", nil, true
+	}
+	for _, id := range teamIDs {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(teamIDs)), ", ")
+	return column + " IN (SELECT id FROM projects WHERE team_id IN (" + placeholders + "))", args, true
+}
+
+// projectInScope reports whether the project exists and is visible through ctx
+func (s *Storage) projectInScope(ctx context.Context, projectID int64) (bool, error) {
+	var teamID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, "SELECT team_id FROM projects WHERE id = ?", projectID).Scan(&teamID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up project team: %w", err)
+	}
+	return inTeamScope(ctx, teamID.Int64), nil
+}
+
+// CreateTeam inserts a team and its members
+func (s *Storage) CreateTeam(ctx context.Context, t *Team) error {
+	if err := s.checkTeamName(ctx, t.Name, 0); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, "INSERT INTO teams (name, created_at, updated_at) VALUES (?, ?, ?)", t.Name, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create team: %w", err)
+	}
+	t.ID, _ = result.LastInsertId()
+	if err := replaceTeamMembers(ctx, tx, t.ID, t.Members); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit team: %w", err)
+	}
+
+	t.CreatedAt, t.UpdatedAt = now, now
+	return nil
+}
+
+// GetTeam retrieves a team with its members. Scoped contexts only see their own teams.
+func (s *Storage) GetTeam(ctx context.Context, id int64) (*Team, error) {
+	if teamIDs, scoped := TeamScopeFromContext(ctx); scoped && !slices.Contains(teamIDs, id) {
+		return nil, nil
+	}
+
+	t := &Team{}
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, created_at, updated_at FROM teams WHERE id = ?", id).
+		Scan(&t.ID, &t.Name, &t.CreatedAt, &t.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get team: %w", err)
+	}
+	if t.Members, err = s.teamMembers(ctx, t.ID); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ListTeams returns teams with their members, by name. Scoped contexts only see their own teams.
+func (s *Storage) ListTeams(ctx context.Context) ([]*Team, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, created_at, updated_at FROM teams ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+	defer rows.Close()
+
+	teamIDs, scoped := TeamScopeFromContext(ctx)
+	teams := []*Team{}
+	for rows.Next() {
+		t := &Team{}
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan team: %w", err)
+		}
+		if !scoped || slices.Contains(teamIDs, t.ID) {
+			teams = append(teams, t)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, t := range teams {
+		if t.Members, err = s.teamMembers(ctx, t.ID); err != nil {
+			return nil, err
+		}
+	}
+	return teams, nil
+}
+
+// UpdateTeam saves a team's name and replaces its members
+func (s *Storage) UpdateTeam(ctx context.Context, t *Team) error {
+	if err := s.checkTeamName(ctx, t.Name, t.ID); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	t.UpdatedAt = time.Now()
+	if _, err := tx.ExecContext(ctx, "UPDATE teams SET name = ?, updated_at = ? WHERE id = ?", t.Name, t.UpdatedAt, t.ID); err != nil {
+		return fmt.Errorf("failed to update team: %w", err)
+	}
+	if err := replaceTeamMembers(ctx, tx, t.ID, t.Members); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit team: %w", err)
+	}
+	return nil
+}
+
+// DeleteTeam deletes a team. Its projects are kept and become unassigned.
+func (s *Storage) DeleteTeam(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM teams WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete team: %w", err)
+	}
+	return nil
+}
+
+// TeamIDsForMember returns the IDs of the teams a user belongs to
+func (s *Storage) TeamIDsForMember(ctx context.Context, member string) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT team_id FROM team_members WHERE member = ? ORDER BY team_id", member)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up team membership: %w", err)
+	}
+	defer rows.Close()
+
+	teamIDs := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan team membership: %w", err)
+		}
+		teamIDs = append(teamIDs, id)
+	}
+	return teamIDs, rows.Err()
+}
+
+// SetProjectTeam assigns a project to a team; a zero teamID unassigns it
+func (s *Storage) SetProjectTeam(ctx context.Context, projectID, teamID int64) (*Project, error) {
+	if teamID != 0 {
+		if err := s.checkTeamExists(ctx, teamID); err != nil {
+			return nil, err
+		}
+	}
+	_, err := s.db.ExecContext(ctx, "UPDATE projects SET team_id = ?, updated_at = ? WHERE id = ?", nullID(teamID), time.Now(), projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set project team: %w", err)
+	}
+	return s.GetProject(ctx, projectID)
+}
+
+// checkTeamExists rejects a team_id that names no team visible through ctx
+func (s *Storage) checkTeamExists(ctx context.Context, teamID int64) error {
+	if !inTeamScope(ctx, teamID) {
+		return &ValidationError{Fields: []FieldError{{Field: "team_id", Message: "is not one of your teams"}}}
+	}
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM teams WHERE id = ?)", teamID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up team: %w", err)
+	}
+	if !exists {
+		return &ValidationError{Fields: []FieldError{{Field: "team_id", Message: "team not found"}}}
+	}
+	return nil
+}
+
+// checkTeamName rejects a name already used by another team
+func (s *Storage) checkTeamName(ctx context.Context, name string, id int64) error {
+	var taken bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM teams WHERE name = ? AND id != ?)", name, id).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check team name: %w", err)
+	}
+	if taken {
+		return &ValidationError{Fields: []FieldError{{Field: "name", Message: "is already used by another team"}}}
+	}
+	return nil
+}
+
+func (s *Storage) teamMembers(ctx context.Context, teamID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT member FROM team_members WHERE team_id = ? ORDER BY member", teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list team members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return nil, fmt.Errorf("failed to scan team member: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func replaceTeamMembers(ctx context.Context, tx *sql.Tx, teamID int64, members []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM team_members WHERE team_id = ?", teamID); err != nil {
+		return fmt.Errorf("failed to clear team members: %w", err)
+	}
+	for _, m := range members {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO team_members (team_id, member) VALUES (?, ?)", teamID, m); err != nil {
+			return fmt.Errorf("failed to add team member: %w", err)
+		}
+	}
+	return nil
+}
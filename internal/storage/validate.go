@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrValidation is wrapped by every *ValidationError so callers can match it with errors.Is
+var ErrValidation = errors.New("validation failed")
+
+// imagePattern accepts container image references such as nginx, nginx:1.25,
+// or ghcr.io/acme/app@sha256:...
+var imagePattern = regexp.MustCompile(`^[a-z0-9][A-Za-z0-9._/:@-]*$`)
+
+// branchPattern accepts git branch names such as main or release/1.2, and
+// keeps them from being read as git options
+var branchPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// gitFilterPattern accepts the partial clone filters offered: blob:none,
+// tree:0, and blob:limit=N with an optional k, m, or g suffix
+var gitFilterPattern = regexp.MustCompile(`^(blob:none|tree:0|blob:limit=[1-9][0-9]*[kmg]?)$`)
+
+// serviceNamePattern keeps service names safe to embed in systemd unit names and file paths
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects every field error found in a request
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(parts, "; "))
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
+
+// validator accumulates field errors while a request is checked
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) check(ok bool, field, message string) {
+	if !ok {
+		v.fields = append(v.fields, FieldError{Field: field, Message: message})
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+func (v *validator) projectName(name string) {
+	v.check(strings.TrimSpace(name) != "", "name", "is required")
+}
+
+func (v *validator) serviceName(name string) {
+	if name == "" {
+		v.check(false, "name", "is required")
+		return
+	}
+	v.check(serviceNamePattern.MatchString(name), "name", "may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit")
+}
+
+func (v *validator) port(port int) {
+	v.check(port >= 0 && port <= 65535, "port", "must be between 0 and 65535")
+}
+
+func (v *validator) runtime(runtime string) {
+	switch runtime {
+	case "", RuntimeSystemd, RuntimeDocker, RuntimePodman:
+	default:
+		v.check(false, "runtime", "must be systemd, docker, or podman")
+	}
+}
+
+func (v *validator) gitBranch(branch string) {
+	if branch == "" {
+		return
+	}
+	v.check(branchPattern.MatchString(branch) && !strings.Contains(branch, ".."), "git_branch", "must be a branch name such as main or release/1.2")
+}
+
+func (v *validator) gitFilter(filter string) {
+	if filter == "" {
+		return
+	}
+	v.check(gitFilterPattern.MatchString(filter), "git_filter", "must be blob:none, tree:0, or blob:limit=SIZE such as blob:limit=1m")
+}
+
+// image checks a service's image against its runtime. Container services
+// are deployed by pulling a new image, so they have no git repository.
+func (v *validator) image(runtime, image, gitRepoURL string) {
+	if runtime != RuntimeDocker && runtime != RuntimePodman {
+		v.check(image == "", "image", "is only used by the docker and podman runtimes")
+		return
+	}
+	if image == "" {
+		v.check(false, "image", "is required for containers")
+	} else {
+		v.check(imagePattern.MatchString(image), "image", "must be an image reference such as nginx:1.25")
+	}
+	v.check(gitRepoURL == "", "git_repo_url", "is not used by containers; deploy a new image instead")
+}
+
+// Validate checks the fields of a project creation request
+func (r *CreateProjectRequest) Validate() error {
+	var v validator
+	v.projectName(r.Name)
+	return v.err()
+}
+
+// Validate checks the fields of a project update request
+func (r *UpdateProjectRequest) Validate() error {
+	var v validator
+	v.projectName(r.Name)
+	return v.err()
+}
+
+// Validate checks the fields of a service creation request
+func (r *CreateServiceRequest) Validate() error {
+	var v validator
+	v.check(r.ProjectID > 0, "project_id", "is required")
+	v.serviceName(r.Name)
+	v.port(r.Port)
+	v.runtime(r.Runtime)
+	v.image(r.Runtime, r.Image, r.GitRepoURL)
+	v.gitBranch(r.GitBranch)
+	v.gitFilter(r.GitFilter)
+	return v.err()
+}
+
+// Validate checks the fields of a service update request
+func (r *UpdateServiceRequest) Validate() error {
+	var v validator
+	v.serviceName(r.Name)
+	v.port(r.Port)
+	v.gitBranch(r.GitBranch)
+	v.gitFilter(r.GitFilter)
+	return v.err()
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultDeliveryLimit caps delivery listings when no limit is given
+const defaultDeliveryLimit = 50
+
+// CreateWebhook inserts a webhook
+func (s *Storage) CreateWebhook(ctx context.Context, w *Webhook) error {
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (url, events, enabled, secret, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, w.URL, strings.Join(w.Events, ","), w.Enabled, w.Ciphertext, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	w.ID, _ = result.LastInsertId()
+	w.CreatedAt, w.UpdatedAt = now, now
+	return nil
+}
+
+// GetWebhook retrieves a webhook by ID
+func (s *Storage) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, url, events, enabled, secret, created_at, updated_at FROM webhooks WHERE id = ?
+	`, id)
+
+	w, err := scanWebhook(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return w, nil
+}
+
+// ListWebhooks returns every webhook, oldest first
+func (s *Storage) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, url, events, enabled, secret, created_at, updated_at FROM webhooks ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook saves a webhook's URL, events, enabled flag, and secret
+func (s *Storage) UpdateWebhook(ctx context.Context, w *Webhook) error {
+	w.UpdatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhooks SET url = ?, events = ?, enabled = ?, secret = ?, updated_at = ? WHERE id = ?
+	`, w.URL, strings.Join(w.Events, ","), w.Enabled, w.Ciphertext, w.UpdatedAt, w.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhook deletes a webhook and its delivery history
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// CreateWebhookDelivery records a pending delivery
+func (s *Storage) CreateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	if d.Status == "" {
+		d.Status = DeliveryPending
+	}
+	d.CreatedAt = time.Now()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, status, attempts, response_status, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.WebhookID, d.Event, d.Payload, d.Status, d.Attempts, d.ResponseStatus, d.Error, d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+
+	d.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// UpdateWebhookDelivery saves the outcome of delivery attempts
+func (s *Storage) UpdateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ?, delivered_at = ?
+		WHERE id = ?
+	`, d.Status, d.Attempts, d.ResponseStatus, d.Error, d.DeliveredAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns a webhook's deliveries, newest first
+func (s *Storage) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, webhook_id, event, payload, status, attempts, response_status, error, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?
+	`, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseStatus, &d.Error, &d.CreatedAt, &deliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// failInterruptedDeliveries marks webhook and notification deliveries whose
+// retries were cut short by a restart as failed
+func (s *Storage) failInterruptedDeliveries(ctx context.Context) error {
+	for _, table := range []string{"webhook_deliveries", "notification_deliveries"} {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE `+table+` SET status = ?, error = ? WHERE status = ?
+		`, DeliveryFailed, "interrupted: servio was restarted", DeliveryPending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	w := &Webhook{}
+	var events string
+	if err := row.Scan(&w.ID, &w.URL, &events, &w.Enabled, &w.Ciphertext, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		return nil, err
+	}
+	w.Events = []string{}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return w, nil
+}
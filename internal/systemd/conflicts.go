@@ -0,0 +1,82 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// VendorUnitDirs are where packages and the runtime install units, which a
+// unit of the same name in ServiceDir overrides
+var VendorUnitDirs = []string{"/run/systemd/system", "/usr/local/lib/systemd/system", "/usr/lib/systemd/system", "/lib/systemd/system"}
+
+// UnitConflict is a unit file Servio did not write that has the name of a
+// service's unit
+type UnitConflict struct {
+	Unit    string `json:"unit"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// UnitConflicts finds unit files in the way of a service's units: a unit at
+// ServiceDir that Servio did not write, which installing overwrites, a mask,
+// which keeps the unit from starting, and units of packages that Servio's
+// would override. generated is the unit Servio writes for the service.
+func UnitConflicts(service *storage.Service, generated string) []UnitConflict {
+	names := []string{service.ServiceName()}
+	if service.Scaled() {
+		names = append(names, templateName(service.ServiceName()))
+	}
+
+	var conflicts []UnitConflict
+	for _, name := range names {
+		path := filepath.Join(ServiceDir, name)
+		if msg := ownUnitProblem(path, service, generated); msg != "" {
+			conflicts = append(conflicts, UnitConflict{Unit: name, Path: path, Message: msg})
+		}
+
+		seen := map[string]bool{}
+		for _, dir := range VendorUnitDirs {
+			vendor := filepath.Join(dir, name)
+			resolved, err := filepath.EvalSymlinks(vendor)
+			if err != nil || seen[resolved] {
+				continue
+			}
+			seen[resolved] = true
+			conflicts = append(conflicts, UnitConflict{
+				Unit: name, Path: vendor,
+				Message: vendor + " is installed under the same name; Servio's unit in " + ServiceDir + " overrides it",
+			})
+		}
+	}
+	return conflicts
+}
+
+// ownUnitProblem returns what is wrong with the file at the path Servio
+// writes a service's unit to, or "" when it is missing or Servio's
+func ownUnitProblem(path string, service *storage.Service, generated string) string {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return ""
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(path)
+		if target == "/dev/null" {
+			return path + " is masked, so the unit cannot start; run systemctl unmask " + filepath.Base(path)
+		}
+		return path + " links to " + target + ", which installing replaces"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	content := string(data)
+	if strings.Contains(content, "# Managed by Servio") ||
+		strings.Contains(content, "Description=Managed Service: "+service.Name+"\n") ||
+		strings.TrimSpace(content) == strings.TrimSpace(generated) {
+		return ""
+	}
+	return path + " was not written by Servio; installing overwrites it"
+}
@@ -0,0 +1,145 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+)
+
+const (
+	// DefaultExecTimeout is how long a one-off command may run unless told otherwise
+	DefaultExecTimeout = 10 * time.Minute
+	// MaxExecTimeout caps the timeout of a one-off command
+	MaxExecTimeout = time.Hour
+	// execStopGrace is how long past its timeout systemd lets a command run
+	// before stopping it itself, in case Servio is no longer there to
+	execStopGrace = time.Minute
+)
+
+// ExecRequest is a one-off command to run in a service's context
+type ExecRequest struct {
+	Command string        // run with /bin/sh -c
+	Input   string        // written to the command's stdin
+	Timeout time.Duration // DefaultExecTimeout when zero
+}
+
+// ExecResult is how a one-off command ended
+type ExecResult struct {
+	ExitCode int // -1 when the command was stopped
+	Duration time.Duration
+}
+
+// Exec runs a one-off command as the installed unit serviceName runs its
+// ExecStart: as its User, in its WorkingDirectory, with its Environment and
+// EnvironmentFile. The command runs in a transient unit started with
+// systemd-run, so it gets the unit's sandbox rather than Servio's, and is
+// audited under the exec category. Each line it prints is passed to out
+// with its stream, "stdout" or "stderr". A command that exits non-zero is
+// not an error; err is set when it could not be run, or was stopped because
+// ctx was cancelled or it ran past its timeout.
+func Exec(ctx context.Context, serviceName string, req ExecRequest, out func(stream, line string)) (ExecResult, error) {
+	result := ExecResult{ExitCode: -1}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+
+	// systemctl cat appends an instance's drop-ins to its template, and
+	// reads the unit as installed, with its secrets resolved
+	content, err := exec.CommandContext(ctx, "systemctl", "cat", serviceName).Output()
+	if err != nil {
+		return result, fmt.Errorf("%w: cat %s: %w", ErrCommandFailed, serviceName, err)
+	}
+	spec := parseUnit(string(content), serviceName)
+
+	unit := fmt.Sprintf("servio-exec-%s-%d.service",
+		strings.ReplaceAll(strings.TrimPrefix(instanceBase(serviceName), "servio-"), "@", "-"), time.Now().UnixNano())
+	args := []string{"systemd-run", "--unit=" + unit, "--description=One-off command of " + serviceName,
+		"--pipe", "--wait", "--collect", "--quiet", "--service-type=exec",
+		"--property=RuntimeMaxSec=" + strconv.Itoa(int((timeout + execStopGrace).Seconds()))}
+	if user := spec.runsAs(); user != "" {
+		args = append(args, "--uid="+user)
+	}
+	if spec.dir != "" {
+		args = append(args, "--working-directory="+spec.dir)
+	}
+	for _, file := range spec.envFiles {
+		args = append(args, "--property=EnvironmentFile="+file)
+	}
+	// Environment lines may hold resolved secrets, which must stay out of
+	// the audit trail and process list, so they are passed in a file
+	if len(spec.env) > 0 {
+		envFile, err := writeExecEnv(ctx, spec.env)
+		if err != nil {
+			return result, err
+		}
+		defer os.Remove(envFile)
+		args = append(args, "--property=EnvironmentFile="+envFile)
+	}
+	args = append(args, "--", "/bin/sh", "-c", req.Command)
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, args[0], args[1:]...)
+	if req.Input != "" {
+		cmd.Stdin = strings.NewReader(req.Input)
+	}
+	start := time.Now()
+	_, err = audit.Stream(runCtx, audit.CategoryExec, "run", cmd, out)
+	result.Duration = time.Since(start)
+
+	if runCtx.Err() != nil {
+		// systemd-run leaves the unit running when it is signalled
+		stop := exec.Command("systemctl", "stop", unit)
+		if output, err := audit.Run(context.WithoutCancel(ctx), audit.CategorySystemd, "stop", stop); err != nil {
+			return result, fmt.Errorf("%w: stop %s: %s - %w", ErrCommandFailed, unit, strings.TrimSpace(string(output)), err)
+		}
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("command stopped: %w", ctx.Err())
+		}
+		return result, fmt.Errorf("command timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to run systemd-run: %w", err)
+	}
+	result.ExitCode = 0
+	return result, nil
+}
+
+// writeExecEnv writes a unit's Environment assignments to a file only root
+// can read, in EnvironmentFile syntax, and returns its path
+func writeExecEnv(ctx context.Context, env []string) (string, error) {
+	if dryrun.FromContext(ctx) != nil {
+		return filepath.Join(os.TempDir(), "servio-exec.env"), nil
+	}
+	f, err := os.CreateTemp("", "servio-exec-*.env")
+	if err != nil {
+		return "", fmt.Errorf("failed to create environment file: %w", err)
+	}
+	defer f.Close()
+	var b strings.Builder
+	for _, assignment := range env {
+		if key, value, ok := strings.Cut(assignment, "="); ok && key != "" {
+			fmt.Fprintf(&b, "%s=%s\n", key, shellQuote(value))
+		}
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write environment file: %w", err)
+	}
+	return f.Name(), nil
+}
@@ -8,11 +8,15 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"servio/internal/audit"
+	"servio/internal/dryrun"
 	"servio/internal/storage"
 )
 
-const serviceDir = "/etc/systemd/system"
+// ServiceDir is where unit files are installed
+const ServiceDir = "/etc/systemd/system"
 
 // GenerateServiceFile creates a systemd service file from a service entity
 func (m *Manager) GenerateServiceFile(service *storage.Service) (string, error) {
@@ -171,13 +175,48 @@ WantedBy=multi-user.target
 func (m *Manager) InstallService(ctx context.Context, service *storage.Service) error {
 	slog.Info("InstallService called", "service", service.Name, "user", service.User, "command", service.Command)
 
+	content, err := m.GenerateServiceFile(service)
+	if err != nil {
+		return fmt.Errorf("failed to generate service file: %w", err)
+	}
+
+	// Secrets are only resolved when writing to disk so previews never expose them
+	private := false
+	if m.secrets != nil && dryrun.FromContext(ctx) == nil {
+		resolved, substituted, err := m.secrets.Resolve(ctx, service, content)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		if substituted {
+			content, private = resolved, true
+		}
+	}
+	if !service.Scaled() {
+		if err := m.removeInstances(ctx, service.ServiceName(), 0); err != nil {
+			return err
+		}
+		return m.InstallServiceFile(ctx, service, content, private)
+	}
+
+	// A scaled service's unit starts its instances, which run the service's
+	// unit as a template
+	if err := m.installInstances(ctx, service, content, private); err != nil {
+		return err
+	}
+	return m.InstallServiceFile(ctx, service, generateGroupFile(service), false)
+}
+
+// InstallServiceFile writes a unit file already generated for service and
+// reloads systemd; agents install the units generated by the central server
+// with it. A private unit holds resolved secrets and is only readable by root.
+func (m *Manager) InstallServiceFile(ctx context.Context, service *storage.Service, content string, private bool) error {
 	// Pre-flight check: Verify User exists
 	if service.User != "" && service.User != "root" {
 		slog.Info("Checking if user exists", "user", service.User)
 		cmd := exec.Command("id", "-u", service.User)
 		if err := cmd.Run(); err != nil {
 			slog.Error("User check failed", "user", service.User, "error", err)
-			return fmt.Errorf("system user '%s' does not exist; please install the corresponding package (e.g. postgresql-server) or change the service user", service.User)
+			return fmt.Errorf("system user '%s' does not exist; install the corresponding package (e.g. postgresql-server), change the service user, or save the service with create_user to create it", service.User)
 		}
 		slog.Info("User exists", "user", service.User)
 	}
@@ -203,13 +242,28 @@ func (m *Manager) InstallService(ctx context.Context, service *storage.Service)
 		}
 	}
 
-	content, err := m.GenerateServiceFile(service)
-	if err != nil {
-		return fmt.Errorf("failed to generate service file: %w", err)
+	fileMode := os.FileMode(0644)
+	if private {
+		fileMode = 0600
 	}
+	plan := dryrun.FromContext(ctx)
 
 	// Ensure working directory exists (and create it if needed)
 	workingDir := service.WorkingDir
+	servicePath := filepath.Join(ServiceDir, service.ServiceName())
+	if plan != nil {
+		if workingDir != "" && workingDir != "/" {
+			plan.Mkdir(workingDir, 0755)
+			if service.User != "" && service.User != "root" {
+				plan.Run([]string{"chown", service.User, workingDir})
+			}
+		}
+		plan.Write(servicePath, content, fileMode)
+		if err := placeInSlice(ctx, service); err != nil {
+			return err
+		}
+		return m.Reload(ctx)
+	}
 	if workingDir != "" && workingDir != "/" {
 		if err := os.MkdirAll(workingDir, 0755); err != nil {
 			return fmt.Errorf("failed to create working directory '%s': %w", workingDir, err)
@@ -225,11 +279,20 @@ func (m *Manager) InstallService(ctx context.Context, service *storage.Service)
 		}
 	}
 
-	servicePath := filepath.Join(serviceDir, service.ServiceName())
-
-	if err := os.WriteFile(servicePath, []byte(content), 0644); err != nil {
+	writeStart := time.Now()
+	err := os.WriteFile(servicePath, []byte(content), fileMode)
+	audit.Log(ctx, audit.CategorySystemd, "write-unit", "write "+servicePath, "", err, time.Since(writeStart))
+	if err != nil {
 		return fmt.Errorf("failed to write service file: %w", err)
 	}
+	// WriteFile keeps the mode of an existing file, so apply it explicitly
+	if err := os.Chmod(servicePath, fileMode); err != nil {
+		slog.Warn("Failed to set service file permissions", "path", servicePath, "error", err)
+	}
+	audit.WroteFile(ctx, audit.CategorySystemd, servicePath, []byte(content), fileMode)
+	if err := placeInSlice(ctx, service); err != nil {
+		return err
+	}
 
 	if err := m.Reload(ctx); err != nil {
 		return fmt.Errorf("failed to reload systemd: %w", err)
@@ -244,18 +307,47 @@ func (m *Manager) UninstallService(ctx context.Context, serviceName string) erro
 	m.Stop(ctx, serviceName)
 	m.Disable(ctx, serviceName)
 
-	servicePath := filepath.Join(serviceDir, serviceName)
+	// Retention and file logging drop-ins and their configs go with the unit
+	if unitNamespace(serviceName) != "" {
+		if err := RemoveJournalRetention(ctx, serviceName); err != nil {
+			slog.WarnContext(ctx, "Failed to remove journal retention", "service", serviceName, "error", err)
+		}
+	}
+	if unitLogFile(serviceName) != "" {
+		if err := RemoveFileLogging(ctx, serviceName); err != nil {
+			slog.WarnContext(ctx, "Failed to remove file logging", "service", serviceName, "error", err)
+		}
+	}
+
+	if err := leaveSlice(ctx, serviceName); err != nil {
+		slog.WarnContext(ctx, "Failed to remove project slice drop-in", "service", serviceName, "error", err)
+	}
+	if err := m.removeInstances(ctx, serviceName, 0); err != nil {
+		return err
+	}
+
+	servicePath := filepath.Join(ServiceDir, serviceName)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Remove(servicePath)
+		return m.Reload(ctx)
+	}
 
+	removeStart := time.Now()
 	if err := os.Remove(servicePath); err != nil && !os.IsNotExist(err) {
+		audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+servicePath, "", err, time.Since(removeStart))
 		return fmt.Errorf("failed to remove service file: %w", err)
 	}
+	audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+servicePath, "", nil, time.Since(removeStart))
+	audit.RemovedFile(ctx, servicePath)
+	// Disabling above fails for a unit whose file was already gone
+	audit.UnitEnabled(ctx, serviceName, false)
 
 	return m.Reload(ctx)
 }
 
 // ServiceExists checks if a service file exists
 func (m *Manager) ServiceExists(serviceName string) bool {
-	servicePath := filepath.Join(serviceDir, serviceName)
+	servicePath := filepath.Join(ServiceDir, serviceName)
 	_, err := os.Stat(servicePath)
 	return err == nil
 }
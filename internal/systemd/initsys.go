@@ -0,0 +1,557 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+	"servio/internal/tail"
+)
+
+// Init systems DetectInit recognizes
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitRunit   = "runit"
+)
+
+// DetectInit returns the init system running the host: InitSystemd,
+// InitOpenRC, or InitRunit, or "" when it is none of them
+func DetectInit() string {
+	if _, err := os.Stat("/run/systemd/system"); err == nil {
+		return InitSystemd
+	}
+	if _, err := os.Stat("/run/openrc"); err == nil {
+		return InitOpenRC
+	}
+	if _, err := exec.LookPath("runsvdir"); err == nil {
+		return InitRunit
+	}
+	return ""
+}
+
+// initSystem is an init system other than systemd, which InitManager drives.
+// Its scripts are named after units without the .service suffix.
+type initSystem interface {
+	// name is the Init* constant
+	name() string
+	// scriptPath is the file or directory that defines a unit
+	scriptPath(serviceName string) string
+	// scripts returns the files that run a unit, in the order to write them.
+	// A spec without a command is a scaled service's unit, which runs nothing.
+	scripts(serviceName string, spec execSpec) []initFile
+	// register makes the init system take up a unit whose scripts were just written
+	register(ctx context.Context, serviceName string) error
+	// unregister makes the init system drop a unit before its scripts are removed
+	unregister(ctx context.Context, serviceName string) error
+	// control starts, stops, or restarts a unit
+	control(ctx context.Context, action, serviceName string) error
+	// setEnabled makes a unit start at boot, or not
+	setEnabled(ctx context.Context, serviceName string, enabled bool) error
+	enabled(serviceName string) bool
+	// status returns a unit's state as systemctl is-active would, and the
+	// init system's own status output
+	status(ctx context.Context, serviceName string) (string, string)
+	// startedAt returns when a running unit started, or the zero time
+	startedAt(ctx context.Context, serviceName string) time.Time
+	// logPath is the file a unit's output goes to
+	logPath(serviceName string) string
+	// entry turns a line of the log file into an entry
+	entry(line string) JournalEntry
+	ping(ctx context.Context) error
+}
+
+// initFile is one file of a unit's scripts; a directory when dir is set
+type initFile struct {
+	path    string
+	content string
+	mode    os.FileMode
+	dir     bool
+}
+
+// InitManager is a ServiceManager for hosts whose init system is not
+// systemd: OpenRC on Alpine or runit on Void. The embedded Manager generates
+// each unit as usual, then the unit's ExecStart, WorkingDirectory, User,
+// Environment, EnvironmentFile, and Restart are turned into the init
+// system's scripts, which run the command through /bin/sh. Output goes to a
+// log file under LogFileDir. A scaled service gets a script per instance, and
+// one for its unit that runs nothing; starting, stopping, and enabling that
+// one acts on every instance.
+type InitManager struct {
+	*Manager
+	init initSystem
+}
+
+// NewOpenRCManager creates an InitManager running units as OpenRC services,
+// generating them with m
+func NewOpenRCManager(m *Manager) *InitManager {
+	return &InitManager{Manager: m, init: openRC{}}
+}
+
+// NewRunitManager creates an InitManager running units as runit services,
+// generating them with m
+func NewRunitManager(m *Manager) *InitManager {
+	return &InitManager{Manager: m, init: newRunit()}
+}
+
+// NewInitManager returns the manager for an init system other than systemd,
+// detecting the host's when init is auto, or nil for systemd
+func NewInitManager(init string, m *Manager) *InitManager {
+	if init == "auto" {
+		init = DetectInit()
+	}
+	switch init {
+	case InitOpenRC:
+		return NewOpenRCManager(m)
+	case InitRunit:
+		return NewRunitManager(m)
+	}
+	return nil
+}
+
+// InitName returns the init system the manager drives
+func (m *InitManager) InitName() string {
+	return m.init.name()
+}
+
+// instances returns the installed instances of a scaled service's unit
+func (m *InitManager) instances(serviceName string) []string {
+	if strings.Contains(serviceName, "@") {
+		return nil
+	}
+	matches, _ := filepath.Glob(m.init.scriptPath(instanceBase(serviceName) + "@*"))
+	var names []string
+	for _, match := range matches {
+		names = append(names, filepath.Base(match)+".service")
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Start starts a unit, after the instances of a scaled service
+func (m *InitManager) Start(ctx context.Context, serviceName string) error {
+	for _, name := range m.instances(serviceName) {
+		if err := m.init.control(ctx, "start", name); err != nil {
+			return err
+		}
+	}
+	return m.init.control(ctx, "start", serviceName)
+}
+
+// Stop stops a unit and the instances of a scaled service
+func (m *InitManager) Stop(ctx context.Context, serviceName string) error {
+	if err := m.init.control(ctx, "stop", serviceName); err != nil {
+		return err
+	}
+	for _, name := range m.instances(serviceName) {
+		if err := m.init.control(ctx, "stop", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restart restarts a unit and the instances of a scaled service
+func (m *InitManager) Restart(ctx context.Context, serviceName string) error {
+	for _, name := range m.instances(serviceName) {
+		if err := m.init.control(ctx, "restart", name); err != nil {
+			return err
+		}
+	}
+	return m.init.control(ctx, "restart", serviceName)
+}
+
+// Enable makes a unit, and the instances of a scaled service, start at boot
+func (m *InitManager) Enable(ctx context.Context, serviceName string) error {
+	for _, name := range append(m.instances(serviceName), serviceName) {
+		if err := m.init.setEnabled(ctx, name, true); err != nil {
+			return err
+		}
+	}
+	audit.UnitEnabled(ctx, serviceName, true)
+	return nil
+}
+
+// Disable stops a unit, and the instances of a scaled service, starting at boot
+func (m *InitManager) Disable(ctx context.Context, serviceName string) error {
+	for _, name := range append(m.instances(serviceName), serviceName) {
+		if err := m.init.setEnabled(ctx, name, false); err != nil {
+			return err
+		}
+	}
+	audit.UnitEnabled(ctx, serviceName, false)
+	return nil
+}
+
+// Status returns a unit's state with the init system's status output
+func (m *InitManager) Status(ctx context.Context, serviceName string) (ServiceStatus, error) {
+	state, output := m.init.status(ctx, serviceName)
+	return ServiceStatus{Name: serviceName, Active: state == "active", Enabled: m.init.enabled(serviceName), Output: output}, nil
+}
+
+// ActiveState returns a unit's state as systemctl is-active would
+func (m *InitManager) ActiveState(ctx context.Context, serviceName string) string {
+	state, _ := m.init.status(ctx, serviceName)
+	return state
+}
+
+// Reload does nothing; both init systems read a unit's scripts when it starts
+func (m *InitManager) Reload(ctx context.Context) error {
+	return nil
+}
+
+// GetStartTime returns when a running unit started, formatted like systemctl show
+func (m *InitManager) GetStartTime(ctx context.Context, serviceName string) (string, error) {
+	started := m.init.startedAt(ctx, serviceName)
+	if started.IsZero() {
+		return "", nil
+	}
+	return started.Format("Mon 2006-01-02 15:04:05 MST"), nil
+}
+
+// logUnits returns the units whose log files make up a unit's log: the
+// instances of a scaled service, or the unit itself
+func (m *InitManager) logUnits(serviceName string) []string {
+	if instances := m.instances(serviceName); len(instances) > 0 {
+		return instances
+	}
+	return []string{serviceName}
+}
+
+// GetLogsWithTimeRange returns the end of a unit's log file; lines in a file
+// have no timestamps the range could apply to
+func (m *InitManager) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	var b strings.Builder
+	for _, name := range m.logUnits(serviceName) {
+		output, err := tail.Read(ctx, m.init.logPath(name), fileLogLines)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(output)
+	}
+	if b.Len() == 0 {
+		return "-- No entries --\n", nil
+	}
+	return b.String(), nil
+}
+
+// GetLogEntries returns the last lines of a unit's log file; since is ignored
+func (m *InitManager) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]JournalEntry, error) {
+	if lines <= 0 {
+		lines = fileLogLines
+	}
+	entries := []JournalEntry{}
+	for _, name := range m.logUnits(serviceName) {
+		output, err := tail.Read(ctx, m.init.logPath(name), lines)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			if line != "" {
+				entries = append(entries, m.init.entry(line))
+			}
+		}
+	}
+	if len(entries) > lines {
+		entries = entries[len(entries)-lines:]
+	}
+	return entries, nil
+}
+
+// StreamLogs follows the lines appended to a unit's log file until ctx is
+// done. The entries have no cursor, so cursor is ignored.
+func (m *InitManager) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error) {
+	var sources []<-chan LogLine
+	for _, name := range m.logUnits(serviceName) {
+		source, err := m.followLog(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	entries := make(chan JournalEntry, 100)
+	go func() {
+		defer close(entries)
+		for line := range MergeLogLines(ctx, sources) {
+			select {
+			case entries <- JournalEntry{Time: line.Time, Priority: line.Priority, Message: line.Message}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return entries, nil
+}
+
+// followLog streams the lines appended to a unit's log file. Lines without a
+// time of their own are given the time they were read.
+func (m *InitManager) followLog(ctx context.Context, serviceName string) (<-chan LogLine, error) {
+	lines, err := tail.Follow(ctx, m.init.logPath(serviceName))
+	if err != nil {
+		return nil, err
+	}
+	source := make(chan LogLine)
+	go func() {
+		defer close(source)
+		for line := range lines {
+			e := m.init.entry(line)
+			if e.Time.IsZero() {
+				e.Time = time.Now()
+			}
+			select {
+			case source <- LogLine{Unit: serviceName, Time: e.Time, Priority: e.Priority, Message: e.Message}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return source, nil
+}
+
+// StreamUnitLogs follows several units' log files as one stream, starting
+// with new lines; lines and after are ignored since file lines have no cursor
+func (m *InitManager) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan LogLine, error) {
+	var sources []<-chan LogLine
+	for _, serviceName := range serviceNames {
+		for _, name := range m.logUnits(serviceName) {
+			source, err := m.followLog(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, source)
+		}
+	}
+	return MergeLogLines(ctx, sources), nil
+}
+
+// InstallService generates the unit and writes the init system's scripts
+// for it, with a set per instance of a scaled service
+func (m *InitManager) InstallService(ctx context.Context, service *storage.Service) error {
+	content, err := m.GenerateServiceFile(service)
+	if err != nil {
+		return fmt.Errorf("failed to generate service file: %w", err)
+	}
+
+	// Secrets are only resolved when writing to disk so previews never expose them
+	private := false
+	if m.secrets != nil && dryrun.FromContext(ctx) == nil {
+		resolved, substituted, err := m.secrets.Resolve(ctx, service, content)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		if substituted {
+			content, private = resolved, true
+		}
+	}
+	if !service.Scaled() {
+		if err := m.removeInitInstances(ctx, service.ServiceName(), 0); err != nil {
+			return err
+		}
+		return m.InstallServiceFile(ctx, service, content, private)
+	}
+
+	template := generateTemplateFile(service, content)
+	for n, port := range service.InstancePorts() {
+		instance := template + "\n" + generateInstanceDropIn(service, content, port)
+		if err := m.installScripts(ctx, service.InstanceName(n+1), instance, private); err != nil {
+			return err
+		}
+	}
+	if err := m.removeInitInstances(ctx, service.ServiceName(), service.Replicas); err != nil {
+		return err
+	}
+	return m.InstallServiceFile(ctx, service, generateGroupFile(service), false)
+}
+
+// InstallServiceFile writes the init system's scripts for a unit already
+// generated for service; agents install the units generated by the central
+// server with it. A private unit holds resolved secrets, and its scripts are
+// only readable by root.
+func (m *InitManager) InstallServiceFile(ctx context.Context, service *storage.Service, content string, private bool) error {
+	workingDir := service.WorkingDir
+	if workingDir != "" && workingDir != "/" {
+		if plan := dryrun.FromContext(ctx); plan != nil {
+			plan.Mkdir(workingDir, 0755)
+		} else if err := os.MkdirAll(workingDir, 0755); err != nil {
+			return fmt.Errorf("failed to create working directory '%s': %w", workingDir, err)
+		}
+		if service.User != "" && service.User != "root" {
+			// Best effort chown
+			if _, err := audit.Run(ctx, audit.CategorySystemd, "chown", exec.Command("chown", service.User, workingDir)); err != nil {
+				slog.Warn("Failed to chown working directory", "dir", workingDir, "user", service.User, "error", err)
+			}
+		}
+	}
+	return m.installScripts(ctx, service.ServiceName(), content, private)
+}
+
+// installScripts writes the scripts of a unit, and registers it with the
+// init system when it is new
+func (m *InitManager) installScripts(ctx context.Context, serviceName, content string, private bool) error {
+	spec := parseUnit(content, serviceName)
+	isNew := !m.ServiceExists(serviceName)
+	plan := dryrun.FromContext(ctx)
+	if plan == nil {
+		if err := os.MkdirAll(LogFileDir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", LogFileDir, err)
+		}
+	}
+	for _, file := range m.init.scripts(serviceName, spec) {
+		mode := file.mode
+		if private && !file.dir {
+			mode &^= 0077
+		}
+		switch {
+		case plan != nil && file.dir:
+			plan.Mkdir(file.path, mode)
+		case plan != nil:
+			plan.Write(file.path, file.content, mode)
+		case file.dir:
+			if err := os.MkdirAll(file.path, mode); err != nil {
+				return fmt.Errorf("failed to create %s: %w", file.path, err)
+			}
+		default:
+			if err := writeUnitFile(ctx, file.path, file.content, mode); err != nil {
+				return err
+			}
+		}
+	}
+	if !isNew {
+		return nil
+	}
+	return m.init.register(ctx, serviceName)
+}
+
+// removeInitInstances stops and removes the instances of a service numbered above keep
+func (m *InitManager) removeInitInstances(ctx context.Context, serviceName string, keep int) error {
+	prefix := instanceBase(serviceName) + "@"
+	for _, name := range m.instances(serviceName) {
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".service")); err == nil && n <= keep {
+			continue
+		}
+		if err := m.removeScripts(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeScripts stops a unit, drops it from the init system, and removes its
+// scripts. Its log file is kept.
+func (m *InitManager) removeScripts(ctx context.Context, serviceName string) error {
+	if !m.ServiceExists(serviceName) {
+		return nil
+	}
+	m.init.control(ctx, "stop", serviceName)
+	if err := m.init.unregister(ctx, serviceName); err != nil {
+		return err
+	}
+	path := m.init.scriptPath(serviceName)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Remove(path)
+		return nil
+	}
+	start := time.Now()
+	err := os.RemoveAll(path)
+	audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+path, "", err, time.Since(start))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	audit.RemovedFile(ctx, path)
+	return nil
+}
+
+// UninstallService stops a unit and removes its scripts, with those of a
+// scaled service's instances
+func (m *InitManager) UninstallService(ctx context.Context, serviceName string) error {
+	if err := m.removeInitInstances(ctx, serviceName, 0); err != nil {
+		return err
+	}
+	if err := m.removeScripts(ctx, serviceName); err != nil {
+		return err
+	}
+	audit.UnitEnabled(ctx, serviceName, false)
+	return nil
+}
+
+// ServiceExists reports whether a unit's scripts are installed
+func (m *InitManager) ServiceExists(serviceName string) bool {
+	_, err := os.Stat(m.init.scriptPath(serviceName))
+	return err == nil
+}
+
+// Ping checks that the init system answers
+func (m *InitManager) Ping(ctx context.Context) error {
+	return m.init.ping(ctx)
+}
+
+// initCommand runs a command of the init system, wrapping its failure in ErrCommandFailed
+func initCommand(ctx context.Context, action string, args ...string) error {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if output, err := audit.Run(ctx, audit.CategorySystemd, action, cmd); err != nil {
+		return fmt.Errorf("%w: %s: %s - %w", ErrCommandFailed, strings.Join(args, " "), strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// shellQuote quotes an argument for /bin/sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellCommand returns the shell command that runs a unit's process: it
+// enters the working directory, loads the environment files, and replaces
+// itself with ExecStart. Environment lines are exported by the scripts
+// around it, which may run as root.
+func (s execSpec) shellCommand() string {
+	var parts []string
+	if s.dir != "" {
+		parts = append(parts, "cd "+shellQuote(s.dir))
+	}
+	if len(s.envFiles) > 0 {
+		parts = append(parts, "set -a")
+		for _, file := range s.envFiles {
+			if path, optional := strings.CutPrefix(file, "-"); optional {
+				parts = append(parts, fmt.Sprintf("{ [ ! -r %s ] || . %s; }", shellQuote(path), shellQuote(path)))
+			} else {
+				parts = append(parts, ". "+shellQuote(path))
+			}
+		}
+		parts = append(parts, "set +a")
+	}
+	parts = append(parts, "exec "+s.command)
+	return strings.Join(parts, " && ")
+}
+
+// exports returns the unit's Environment lines as export statements
+func (s execSpec) exports() string {
+	var b strings.Builder
+	for _, assignment := range s.env {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok || key == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "export %s=%s\n", key, shellQuote(value))
+	}
+	return b.String()
+}
+
+// runsAs reports the user a unit runs as, or "" for root
+func (s execSpec) runsAs() string {
+	if s.user == "root" {
+		return ""
+	}
+	return s.user
+}
@@ -0,0 +1,260 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+)
+
+// Journal directories: persistent, and volatile when /var/log/journal is missing
+var journalDirs = []string{"/var/log/journal", "/run/log/journal"}
+
+// journalConfDir holds journald@<namespace>.conf files
+const journalConfDir = "/etc/systemd"
+
+// retentionDropIn is the drop-in that moves a unit's logs into its own namespace
+const retentionDropIn = "servio-journal.conf"
+
+var (
+	journalSizePattern = regexp.MustCompile(`^[0-9]+[KMGT]?$`)
+	journalAgePattern  = regexp.MustCompile(`^[0-9]+(s|min|h|d|w|weeks?|months?|y|years?)$`)
+)
+
+// ErrInvalidRetention is wrapped by errors for malformed sizes and ages
+var ErrInvalidRetention = errors.New("invalid journal retention")
+
+// Retention limits how much journal a unit keeps. MaxSize uses journald's
+// size syntax (500M, 2G); MaxAge its time syntax (12h, 7d, 2weeks).
+type Retention struct {
+	MaxSize string
+	MaxAge  string
+}
+
+// Validate checks that at least one limit is set and both are well formed
+func (r Retention) Validate() error {
+	if r.MaxSize == "" && r.MaxAge == "" {
+		return fmt.Errorf("%w: set max_size, max_age, or both", ErrInvalidRetention)
+	}
+	if r.MaxSize != "" && !journalSizePattern.MatchString(r.MaxSize) {
+		return fmt.Errorf("%w: max_size %q must be a size such as 500M or 2G", ErrInvalidRetention, r.MaxSize)
+	}
+	if r.MaxAge != "" && !journalAgePattern.MatchString(r.MaxAge) {
+		return fmt.Errorf("%w: max_age %q must be a duration such as 12h, 7d, or 2weeks", ErrInvalidRetention, r.MaxAge)
+	}
+	return nil
+}
+
+// JournalNamespace is the journald namespace a unit logs to once it has a retention policy
+func JournalNamespace(serviceName string) string {
+	return strings.TrimSuffix(serviceName, ".service")
+}
+
+// unitNamespace returns the namespace a unit currently logs to, or "" for the system journal
+func unitNamespace(serviceName string) string {
+	if _, err := os.Stat(filepath.Join(ServiceDir, serviceName+".d", retentionDropIn)); err != nil {
+		return ""
+	}
+	return JournalNamespace(serviceName)
+}
+
+// journalArgs prefixes args with the namespace option a unit's logs need. The
+// "+" also shows what the unit logged to the system journal before it moved.
+func journalArgs(serviceName string, args ...string) []string {
+	if ns := unitNamespace(serviceName); ns != "" {
+		return append([]string{"--namespace=+" + ns}, args...)
+	}
+	return args
+}
+
+// SetJournalRetention moves a unit's logs into their own journald namespace,
+// limited by r, so they can be vacuumed without touching other units. It takes
+// effect when the unit next restarts.
+func SetJournalRetention(ctx context.Context, serviceName string, r Retention) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	ns := JournalNamespace(serviceName)
+
+	var conf strings.Builder
+	conf.WriteString("# Managed by Servio\n[Journal]\n")
+	if r.MaxSize != "" {
+		fmt.Fprintf(&conf, "SystemMaxUse=%s\nRuntimeMaxUse=%s\n", r.MaxSize, r.MaxSize)
+	}
+	if r.MaxAge != "" {
+		fmt.Fprintf(&conf, "MaxRetentionSec=%s\n", r.MaxAge)
+	}
+	confPath := filepath.Join(journalConfDir, "journald@"+ns+".conf")
+	dropInDir := filepath.Join(ServiceDir, serviceName+".d")
+	dropInPath := filepath.Join(dropInDir, retentionDropIn)
+	dropIn := "# Managed by Servio\n[Service]\nLogNamespace=" + ns + "\n"
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Write(confPath, conf.String(), 0644)
+		plan.Mkdir(dropInDir, 0755)
+		plan.Write(dropInPath, dropIn, 0644)
+		return reloadDaemon(ctx)
+	}
+
+	if err := writeJournalFile(ctx, confPath, conf.String()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dropInDir, 0755); err != nil {
+		return fmt.Errorf("failed to create drop-in directory: %w", err)
+	}
+	if err := writeJournalFile(ctx, dropInPath, dropIn); err != nil {
+		return err
+	}
+	return reloadDaemon(ctx)
+}
+
+// RemoveJournalRetention returns a unit's logs to the system journal. Logs
+// already in its namespace stay there until vacuumed.
+func RemoveJournalRetention(ctx context.Context, serviceName string) error {
+	ns := JournalNamespace(serviceName)
+	paths := []string{
+		filepath.Join(ServiceDir, serviceName+".d", retentionDropIn),
+		filepath.Join(journalConfDir, "journald@"+ns+".conf"),
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				plan.Remove(path)
+			}
+		}
+		return reloadDaemon(ctx)
+	}
+
+	for _, path := range paths {
+		start := time.Now()
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			audit.RemovedFile(ctx, path)
+			continue
+		}
+		audit.Log(ctx, audit.CategorySystemd, "remove-journal-config", "remove "+path, "", err, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		audit.RemovedFile(ctx, path)
+	}
+	// Only removes the drop-in directory if nothing else is in it
+	os.Remove(filepath.Join(ServiceDir, serviceName+".d"))
+	return reloadDaemon(ctx)
+}
+
+// writeJournalFile writes a config file and records it in the audit trail
+func writeJournalFile(ctx context.Context, path, content string) error {
+	start := time.Now()
+	err := os.WriteFile(path, []byte(content), 0644)
+	audit.Log(ctx, audit.CategorySystemd, "write-journal-config", "write "+path, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	audit.WroteFile(ctx, audit.CategorySystemd, path, []byte(content), 0644)
+	return nil
+}
+
+// reloadDaemon runs systemctl daemon-reload so drop-ins take effect
+func reloadDaemon(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "systemctl", "daemon-reload")
+	if output, err := audit.Run(ctx, audit.CategorySystemd, "daemon-reload", cmd); err != nil {
+		return fmt.Errorf("%w: daemon-reload: %s - %w", ErrCommandFailed, string(output), err)
+	}
+	return nil
+}
+
+// VacuumJournal deletes archived journal files beyond r's limits, in the
+// given namespace or, when it is empty, the system journal. journald can only
+// drop whole files, so the active file of each journal is kept.
+func VacuumJournal(ctx context.Context, namespace string, r Retention) (string, error) {
+	if err := r.Validate(); err != nil {
+		return "", err
+	}
+	args := []string{"sudo", "journalctl"}
+	if namespace != "" {
+		args = append(args, "--namespace="+namespace)
+	}
+	if r.MaxSize != "" {
+		args = append(args, "--vacuum-size="+r.MaxSize)
+	}
+	if r.MaxAge != "" {
+		args = append(args, "--vacuum-time="+r.MaxAge)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	output, err := audit.Run(ctx, audit.CategorySystemd, "journal-vacuum", cmd)
+	if err != nil {
+		return string(output), fmt.Errorf("%w: journalctl vacuum: %s - %w", ErrCommandFailed, strings.TrimSpace(string(output)), err)
+	}
+	return string(output), nil
+}
+
+// JournalDiskUsage returns the bytes used by journal files, in total and per
+// namespace ("" is the system journal). Namespaced journals live in
+// <machine-id>.<namespace> directories.
+func JournalDiskUsage() (total int64, namespaces map[string]int64, err error) {
+	namespaces = make(map[string]int64)
+	for _, root := range journalDirs {
+		walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !strings.Contains(d.Name(), ".journal") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil // rotated away while walking
+			}
+			rel, _ := filepath.Rel(root, path)
+			ns := ""
+			if _, suffix, ok := strings.Cut(strings.SplitN(rel, string(filepath.Separator), 2)[0], "."); ok {
+				ns = suffix
+			}
+			total += info.Size()
+			namespaces[ns] += info.Size()
+			return nil
+		})
+		if walkErr != nil {
+			return 0, nil, fmt.Errorf("failed to read journal directory %s: %w", root, walkErr)
+		}
+	}
+	return total, namespaces, nil
+}
+
+// UnitJournalUsage estimates the bytes of journal a unit holds. A unit with its
+// own namespace reports the namespace's files exactly; otherwise its entries
+// are measured in journalctl's export format, which is close to their size on
+// disk before compression.
+func UnitJournalUsage(ctx context.Context, serviceName string, namespaces map[string]int64) (int64, error) {
+	if ns := unitNamespace(serviceName); ns != "" {
+		return namespaces[ns], nil
+	}
+	cmd := exec.CommandContext(ctx, "journalctl", "-u", serviceName, "-o", "export", "--no-pager")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start journalctl: %w", err)
+	}
+	n, copyErr := io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return 0, fmt.Errorf("failed to measure journal of %s: %w", serviceName, err)
+	}
+	return n, copyErr
+}
@@ -0,0 +1,208 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/tail"
+)
+
+const (
+	// LogFileDir holds the log files of units that log to files
+	LogFileDir = "/var/log/servio"
+	// logrotateDir holds the generated logrotate configs
+	logrotateDir = "/etc/logrotate.d"
+	// logFileDropIn is the drop-in that sends a unit's output to its log file
+	logFileDropIn = "servio-logfile.conf"
+)
+
+// Rotation defaults applied when a field is left empty
+const (
+	DefaultLogRotate    = 7
+	DefaultLogFrequency = "daily"
+)
+
+// maxLogRotate bounds how many rotated files are kept
+const maxLogRotate = 365
+
+var logrotateSizePattern = regexp.MustCompile(`^[0-9]+[kMG]?$`)
+
+// ErrInvalidLogRotation is wrapped by errors for malformed rotation settings
+var ErrInvalidLogRotation = errors.New("invalid log rotation")
+
+// LogRotation is how logrotate rotates a unit's log file: every Frequency
+// (daily, weekly, or monthly), or sooner once it grows past MaxSize (logrotate's
+// size syntax: 100M, 1G), keeping Rotate old files.
+type LogRotation struct {
+	Rotate    int
+	Frequency string
+	MaxSize   string
+}
+
+// WithDefaults fills in the default count and frequency
+func (r LogRotation) WithDefaults() LogRotation {
+	if r.Rotate == 0 {
+		r.Rotate = DefaultLogRotate
+	}
+	if r.Frequency == "" {
+		r.Frequency = DefaultLogFrequency
+	}
+	return r
+}
+
+// Validate checks each setting, after defaults
+func (r LogRotation) Validate() error {
+	r = r.WithDefaults()
+	if r.Rotate < 1 || r.Rotate > maxLogRotate {
+		return fmt.Errorf("%w: rotate must be between 1 and %d", ErrInvalidLogRotation, maxLogRotate)
+	}
+	switch r.Frequency {
+	case "daily", "weekly", "monthly":
+	default:
+		return fmt.Errorf("%w: frequency %q must be daily, weekly, or monthly", ErrInvalidLogRotation, r.Frequency)
+	}
+	if r.MaxSize != "" && !logrotateSizePattern.MatchString(r.MaxSize) {
+		return fmt.Errorf("%w: max_size %q must be a size such as 100M or 1G", ErrInvalidLogRotation, r.MaxSize)
+	}
+	return nil
+}
+
+// LogFilePath is the file a unit logs to once file logging is on
+func LogFilePath(serviceName string) string {
+	return filepath.Join(LogFileDir, strings.TrimSuffix(serviceName, ".service")+".log")
+}
+
+// logrotatePath is the logrotate config for a unit's log file
+func logrotatePath(serviceName string) string {
+	return filepath.Join(logrotateDir, strings.TrimSuffix(serviceName, ".service"))
+}
+
+// unitLogFile returns the file a unit currently logs to, or "" when it logs to the journal
+func unitLogFile(serviceName string) string {
+	if _, err := os.Stat(filepath.Join(ServiceDir, serviceName+".d", logFileDropIn)); err != nil {
+		return ""
+	}
+	return LogFilePath(serviceName)
+}
+
+// SetFileLogging sends a unit's stdout and stderr to LogFilePath instead of
+// the journal and has logrotate rotate the file. copytruncate keeps the file
+// systemd holds open in place. It takes effect when the unit next restarts.
+func SetFileLogging(ctx context.Context, serviceName string, r LogRotation) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	r = r.WithDefaults()
+	logPath := LogFilePath(serviceName)
+
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "# Managed by Servio\n%s {\n    %s\n    rotate %d\n", logPath, r.Frequency, r.Rotate)
+	if r.MaxSize != "" {
+		fmt.Fprintf(&conf, "    maxsize %s\n", r.MaxSize)
+	}
+	conf.WriteString("    compress\n    delaycompress\n    missingok\n    notifempty\n    copytruncate\n}\n")
+	confPath := logrotatePath(serviceName)
+	dropInDir := filepath.Join(ServiceDir, serviceName+".d")
+	dropInPath := filepath.Join(dropInDir, logFileDropIn)
+	dropIn := "# Managed by Servio\n[Service]\nStandardOutput=append:" + logPath + "\nStandardError=append:" + logPath + "\n"
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Mkdir(LogFileDir, 0755)
+		plan.Write(confPath, conf.String(), 0644)
+		plan.Mkdir(dropInDir, 0755)
+		plan.Write(dropInPath, dropIn, 0644)
+		return reloadDaemon(ctx)
+	}
+
+	// systemd creates the file but not its directory
+	if err := os.MkdirAll(LogFileDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", LogFileDir, err)
+	}
+	if err := writeLogFileConfig(ctx, confPath, conf.String()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dropInDir, 0755); err != nil {
+		return fmt.Errorf("failed to create drop-in directory: %w", err)
+	}
+	if err := writeLogFileConfig(ctx, dropInPath, dropIn); err != nil {
+		return err
+	}
+	return reloadDaemon(ctx)
+}
+
+// RemoveFileLogging returns a unit's output to the journal. The log file and
+// its rotated copies are kept.
+func RemoveFileLogging(ctx context.Context, serviceName string) error {
+	paths := []string{
+		filepath.Join(ServiceDir, serviceName+".d", logFileDropIn),
+		logrotatePath(serviceName),
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				plan.Remove(path)
+			}
+		}
+		return reloadDaemon(ctx)
+	}
+
+	for _, path := range paths {
+		start := time.Now()
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			audit.RemovedFile(ctx, path)
+			continue
+		}
+		audit.Log(ctx, audit.CategorySystemd, "remove-logfile-config", "remove "+path, "", err, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		audit.RemovedFile(ctx, path)
+	}
+	// Only removes the drop-in directory if nothing else is in it
+	os.Remove(filepath.Join(ServiceDir, serviceName+".d"))
+	return reloadDaemon(ctx)
+}
+
+// writeLogFileConfig writes a config file and records it in the audit trail
+func writeLogFileConfig(ctx context.Context, path, content string) error {
+	start := time.Now()
+	err := os.WriteFile(path, []byte(content), 0644)
+	audit.Log(ctx, audit.CategorySystemd, "write-logfile-config", "write "+path, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	audit.WroteFile(ctx, audit.CategorySystemd, path, []byte(content), 0644)
+	return nil
+}
+
+// followLogFile streams lines appended to a log file as entries without
+// priority or cursor
+func followLogFile(ctx context.Context, path string) (<-chan JournalEntry, error) {
+	lines, err := tail.Follow(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan JournalEntry, 100)
+	go func() {
+		defer close(entries)
+		for line := range lines {
+			select {
+			case <-ctx.Done():
+				return
+			case entries <- JournalEntry{Priority: NoPriority, Message: line}:
+			}
+		}
+	}()
+	return entries, nil
+}
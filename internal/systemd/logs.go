@@ -2,24 +2,79 @@ package systemd
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os/exec"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/tail"
 )
 
+// NoPriority is the Priority of lines read from a log file, which has none
+const NoPriority = -1
+
+// JournalEntry is one journal record read by FollowJournal or GetLogEntries
+type JournalEntry struct {
+	Cursor     string
+	Time       time.Time
+	Priority   int // syslog severity, 0 (emerg) to 7 (debug)
+	Hostname   string
+	Identifier string // SYSLOG_IDENTIFIER, usually the process name
+	PID        string
+	Message    string
+}
+
+// Line formats the entry the way journalctl -o short-iso does. Lines read
+// from a log file have no time and are only their message.
+func (e JournalEntry) Line() string {
+	if e.Time.IsZero() {
+		return e.Message
+	}
+	ident := e.Identifier
+	if ident == "" {
+		ident = "unknown"
+	}
+	if e.PID != "" {
+		ident += "[" + e.PID + "]"
+	}
+	return e.Time.Format("2006-01-02T15:04:05-0700") + " " + e.Hostname + " " + ident + ": " + e.Message
+}
+
+// journalRecord is the subset of journalctl's JSON output FollowJournal reads.
+// MESSAGE is an array of bytes when it is not valid UTF-8.
+type journalRecord struct {
+	Cursor     string          `json:"__CURSOR"`
+	Realtime   string          `json:"__REALTIME_TIMESTAMP"` // microseconds since the epoch
+	Priority   string          `json:"PRIORITY"`
+	Hostname   string          `json:"_HOSTNAME"`
+	Identifier string          `json:"SYSLOG_IDENTIFIER"`
+	PID        string          `json:"_PID"`
+	Unit       string          `json:"_SYSTEMD_UNIT"`
+	About      string          `json:"UNIT"` // set on systemd's own messages about a unit
+	RawMessage json.RawMessage `json:"MESSAGE"`
+}
+
 // GetLogs retrieves recent logs for a service
 func (m *Manager) GetLogs(ctx context.Context, serviceName string, lines int) (string, error) {
 	if lines <= 0 {
 		lines = 100
 	}
+	if path := unitLogFile(serviceName); path != "" {
+		return tail.Read(ctx, path, lines)
+	}
 
-	cmd := exec.CommandContext(ctx, "journalctl",
-		"-u", serviceName,
+	cmd := exec.CommandContext(ctx, "journalctl", journalArgs(serviceName, append(unitMatches(serviceName),
 		"-n", strconv.Itoa(lines),
 		"--no-pager",
+		"--all", // print lines with escape codes instead of "[N blob data]"
 		"-o", "short-iso",
-	)
+	)...)...)
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -29,65 +84,326 @@ func (m *Manager) GetLogs(ctx context.Context, serviceName string, lines int) (s
 	return string(output), nil
 }
 
-// StreamLogs streams logs for a service in real-time
-// The returned channel will receive log lines until the context is cancelled
-func (m *Manager) StreamLogs(ctx context.Context, serviceName string) (<-chan string, error) {
-	cmd := exec.CommandContext(ctx, "journalctl",
-		"-u", serviceName,
-		"-f", // Follow mode
+// StreamLogs follows a service's log until ctx is done, starting with the
+// entries after cursor, or the last 10 when cursor is empty. Entries of a
+// unit that logs to a file have no cursor, and start with new lines.
+func (m *Manager) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error) {
+	if path := unitLogFile(serviceName); path != "" {
+		return followLogFile(ctx, path)
+	}
+	args := journalArgs(serviceName, unitMatches(serviceName)...)
+	if cursor != "" {
+		args = append(args, "--after-cursor", cursor)
+	} else {
+		args = append(args, "-n", "10")
+	}
+	return followEntries(ctx, args)
+}
+
+// fileLogLines is how much of a log file GetLogsWithTimeRange returns. Lines
+// in a file have no timestamps of their own, so the range cannot apply.
+const fileLogLines = 1000
+
+// GetLogsWithTimeRange retrieves logs for a service within a time range
+func (m *Manager) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	if path := unitLogFile(serviceName); path != "" {
+		return tail.Read(ctx, path, fileLogLines)
+	}
+	args := journalArgs(serviceName, append(unitMatches(serviceName),
 		"--no-pager",
+		"--all", // print lines with escape codes instead of "[N blob data]"
 		"-o", "short-iso",
-	)
+	)...)
+
+	if since != "" {
+		args = append(args, "--since", since)
+	}
+	if until != "" {
+		args = append(args, "--until", until)
+	}
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get logs: %w", err)
+	}
+
+	return string(output), nil
+}
+
+// GetLogEntries returns a unit's journal entries since the given time, or its
+// whole journal when since is empty, keeping only the last lines when lines
+// is positive. Lines of a unit that logs to a file come with NoPriority.
+func (m *Manager) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]JournalEntry, error) {
+	if path := unitLogFile(serviceName); path != "" {
+		if lines <= 0 {
+			lines = fileLogLines
+		}
+		output, err := tail.Read(ctx, path, lines)
+		if err != nil {
+			return nil, err
+		}
+		entries := []JournalEntry{}
+		for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			if line != "" {
+				entries = append(entries, JournalEntry{Priority: NoPriority, Message: line})
+			}
+		}
+		return entries, nil
+	}
+
+	args := journalArgs(serviceName, append(unitMatches(serviceName), "--no-pager", "-o", "json")...)
+	if since != "" {
+		args = append(args, "--since", since)
+	}
+	if lines > 0 {
+		args = append(args, "-n", strconv.Itoa(lines))
+	}
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs: %s - %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	entries := []JournalEntry{}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		var rec journalRecord
+		if len(line) == 0 || json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		entries = append(entries, rec.entry())
+	}
+	return entries, nil
+}
+
+// FollowJournal streams a unit's journal entries that come after cursor, or
+// new entries when cursor is empty, until ctx is done or journalctl exits,
+// then closes the channel. The channel is unbuffered: a slow reader holds
+// journalctl back, and the journal keeps what it has not read yet.
+func FollowJournal(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error) {
+	args := journalArgs(serviceName, unitMatches(serviceName)...)
+	if cursor != "" {
+		args = append(args, "--after-cursor", cursor)
+	} else {
+		args = append(args, "-n", "0")
+	}
+	return followEntries(ctx, args)
+}
+
+// followEntries is followJournal decoding each record into an entry
+func followEntries(ctx context.Context, args []string) (<-chan JournalEntry, error) {
+	records, err := followJournal(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(chan JournalEntry)
+	go func() {
+		defer close(entries)
+		for rec := range records {
+			select {
+			case <-ctx.Done():
+				return
+			case entries <- rec.entry():
+			}
+		}
+	}()
+	return entries, nil
+}
+
+// followJournal runs journalctl -f -o json with args and decodes its records
+// until ctx is done or journalctl exits
+func followJournal(ctx context.Context, args []string) (<-chan journalRecord, error) {
+	cmd := exec.CommandContext(ctx, "journalctl", append(args, "-f", "--no-pager", "--all", "-o", "json")...)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
-
 	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start journalctl: %w", err)
 	}
 
-	logChan := make(chan string, 100)
-
+	records := make(chan journalRecord)
 	go func() {
-		defer close(logChan)
+		defer close(records)
 		defer cmd.Wait()
 
 		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 		for scanner.Scan() {
+			var rec journalRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
 			select {
 			case <-ctx.Done():
 				return
-			case logChan <- scanner.Text():
+			case records <- rec:
 			}
 		}
 	}()
-
-	return logChan, nil
+	return records, nil
 }
 
-// GetLogsWithTimeRange retrieves logs for a service within a time range
-func (m *Manager) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
-	args := []string{
-		"-u", serviceName,
-		"--no-pager",
-		"-o", "short-iso",
+// entry converts a record, decoding binary messages and defaulting to info
+func (rec journalRecord) entry() JournalEntry {
+	e := JournalEntry{Cursor: rec.Cursor, Hostname: rec.Hostname, Identifier: rec.Identifier, PID: rec.PID, Priority: 6}
+	if usec, err := strconv.ParseInt(rec.Realtime, 10, 64); err == nil {
+		e.Time = time.UnixMicro(usec)
+	}
+	if p, err := strconv.Atoi(rec.Priority); err == nil {
+		e.Priority = p
 	}
+	if err := json.Unmarshal(rec.RawMessage, &e.Message); err != nil {
+		var raw []byte
+		var ints []int
+		if json.Unmarshal(rec.RawMessage, &ints) == nil {
+			for _, b := range ints {
+				raw = append(raw, byte(b))
+			}
+		}
+		e.Message = string(raw)
+	}
+	return e
+}
 
-	if since != "" {
-		args = append(args, "--since", since)
+// LogLine is a line of a stream merging several units' logs
+type LogLine struct {
+	Unit     string    `json:"unit"`
+	Time     time.Time `json:"time"`
+	Priority int       `json:"priority"`
+	Message  string    `json:"message"`
+}
+
+// mergeDelay is how long merged lines are held so that lines arriving from
+// other journals can be sorted in ahead of them
+const mergeDelay = 250 * time.Millisecond
+
+// StreamUnitLogs follows several units' journals as one stream ordered by
+// time, starting with the last lines entries across them, or with the entries
+// after the given time when it is set. Units in the system journal share one
+// journalctl, which interleaves them itself; each unit with its own namespace
+// gets another. The journalctl processes run until ctx is done.
+func (m *Manager) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan LogLine, error) {
+	wanted := make(map[string]bool, len(serviceNames))
+	groups := map[string][]string{}
+	for _, name := range serviceNames {
+		wanted[name] = true
+		ns := unitNamespace(name)
+		groups[ns] = append(groups[ns], unitMatches(name)...)
 	}
-	if until != "" {
-		args = append(args, "--until", until)
+
+	var sources []<-chan LogLine
+	for ns, units := range groups {
+		args := units
+		if after.IsZero() {
+			args = append(args, "-n", strconv.Itoa(lines))
+		} else {
+			// --since takes whole seconds; the entries up to after are skipped below
+			args = append(args, "--since", "@"+strconv.FormatInt(after.Unix(), 10))
+		}
+		if ns != "" {
+			args = append([]string{"--namespace=+" + ns}, args...)
+		}
+		records, err := followJournal(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		source := make(chan LogLine)
+		go func() {
+			defer close(source)
+			for rec := range records {
+				unit := rec.Unit
+				if wanted[rec.About] {
+					unit = rec.About
+				}
+				e := rec.entry()
+				if !e.Time.After(after) {
+					continue
+				}
+				select {
+				case source <- LogLine{Unit: unit, Time: e.Time, Priority: e.Priority, Message: e.Message}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		sources = append(sources, source)
 	}
 
-	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	return MergeLogLines(ctx, sources), nil
+}
 
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to get logs: %w", err)
+// MergeLogLines fans sources into one channel ordered by time. Lines are
+// held for mergeDelay after they arrive, so order is only guaranteed between
+// lines that arrive within that window of each other, which covers the
+// backlog and live lines alike. The channel closes when every source has.
+func MergeLogLines(ctx context.Context, sources []<-chan LogLine) <-chan LogLine {
+	type arrival struct {
+		line LogLine
+		at   time.Time
 	}
+	in := make(chan arrival)
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source <-chan LogLine) {
+			defer wg.Done()
+			for line := range source {
+				select {
+				case in <- arrival{line, time.Now()}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(source)
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
 
-	return string(output), nil
+	out := make(chan LogLine, 100)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(mergeDelay / 2)
+		defer ticker.Stop()
+
+		var pending []arrival
+		flush := func(all bool) bool {
+			sort.SliceStable(pending, func(i, j int) bool { return pending[i].line.Time.Before(pending[j].line.Time) })
+			cutoff := time.Now().Add(-mergeDelay)
+			n := 0
+			for ; n < len(pending) && (all || pending[n].at.Before(cutoff)); n++ {
+				select {
+				case out <- pending[n].line:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			pending = pending[n:]
+			return true
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case a, ok := <-in:
+				if !ok {
+					flush(true)
+					return
+				}
+				pending = append(pending, a)
+			case <-ticker.C:
+				if !flush(false) {
+					return
+				}
+			}
+		}
+	}()
+	return out
 }
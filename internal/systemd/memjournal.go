@@ -0,0 +1,148 @@
+package systemd
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memJournalLines caps the entries a memJournal keeps
+const memJournalLines = 500
+
+// memJournal is the journal of a unit kept in memory, by the managers that
+// run without journald. Its cursors number the entries ever added. The
+// manager's lock guards it.
+type memJournal struct {
+	logs      []JournalEntry
+	logged    int
+	followers []chan JournalEntry
+}
+
+// add numbers e, keeps it, and passes it to followers
+func (j *memJournal) add(e JournalEntry) {
+	j.logged++
+	e.Cursor = strconv.Itoa(j.logged)
+	j.logs = append(j.logs, e)
+	if len(j.logs) > memJournalLines {
+		j.logs = j.logs[len(j.logs)-memJournalLines:]
+	}
+	for _, ch := range j.followers {
+		select {
+		case ch <- e:
+		default: // a slow follower misses lines rather than blocking the manager
+		}
+	}
+}
+
+// tail returns the last lines entries, or all of them when lines is 0
+func (j *memJournal) tail(lines int) []JournalEntry {
+	entries := append([]JournalEntry{}, j.logs...)
+	if lines > 0 && len(entries) > lines {
+		entries = entries[len(entries)-lines:]
+	}
+	return entries
+}
+
+// text formats the journal the way journalctl does
+func (j *memJournal) text() string {
+	if len(j.logs) == 0 {
+		return "-- No entries --\n"
+	}
+	var b strings.Builder
+	for _, e := range j.logs {
+		b.WriteString(e.Line() + "\n")
+	}
+	return b.String()
+}
+
+// follow streams the entries after cursor, or all of them when cursor is
+// empty, then the new ones until ctx is done or close is called. The caller
+// must hold mu, which follow takes again to stop following.
+func (j *memJournal) follow(ctx context.Context, mu *sync.Mutex, cursor string) <-chan JournalEntry {
+	follow := make(chan JournalEntry, 100)
+	j.followers = append(j.followers, follow)
+	after, _ := strconv.Atoi(cursor)
+	var backlog []JournalEntry
+	for _, e := range j.logs {
+		if n, _ := strconv.Atoi(e.Cursor); n > after {
+			backlog = append(backlog, e)
+		}
+	}
+
+	logChan := make(chan JournalEntry, 100)
+	go func() {
+		defer close(logChan)
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			for i, follower := range j.followers {
+				if follower == follow {
+					j.followers = append(j.followers[:i], j.followers[i+1:]...)
+					return
+				}
+			}
+		}()
+		for _, e := range backlog {
+			select {
+			case logChan <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case e, ok := <-follow:
+				if !ok { // uninstalled
+					return
+				}
+				select {
+				case logChan <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return logChan
+}
+
+// close ends the streams of every follower, when the unit is forgotten
+func (j *memJournal) close() {
+	for _, ch := range j.followers {
+		close(ch)
+	}
+	j.followers = nil
+}
+
+// streamJournals follows several in-memory journals as one stream, replaying
+// each after the given time. stream follows one unit's journal; units it
+// fails for are skipped.
+func streamJournals(ctx context.Context, serviceNames []string, after time.Time, stream func(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error)) <-chan LogLine {
+	var sources []<-chan LogLine
+	for _, name := range serviceNames {
+		logChan, err := stream(ctx, name, "")
+		if err != nil {
+			continue
+		}
+		source := make(chan LogLine)
+		go func() {
+			defer close(source)
+			for e := range logChan {
+				if !e.Time.After(after) {
+					continue
+				}
+				select {
+				case source <- LogLine{Unit: name, Time: e.Time, Priority: e.Priority, Message: e.Message}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		sources = append(sources, source)
+	}
+	return MergeLogLines(ctx, sources)
+}
@@ -0,0 +1,341 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// MockManager is a ServiceManager that simulates systemd in memory, for
+// running the panel where there is no systemd, such as a developer's laptop.
+// Unit files are generated but never written, no commands run, and units
+// are lost on restart. Blueprints and secrets are configured on the embedded
+// Manager, which also generates the unit files.
+type MockManager struct {
+	*Manager
+
+	mu    sync.Mutex
+	units map[string]*mockUnit
+}
+
+// mockUnit is the simulated state of one unit
+type mockUnit struct {
+	content   string
+	active    bool
+	enabled   bool
+	startedAt time.Time
+	memJournal
+}
+
+// NewMockManager creates a MockManager generating units with m
+func NewMockManager(m *Manager) *MockManager {
+	return &MockManager{Manager: m, units: make(map[string]*mockUnit)}
+}
+
+// unit returns the named unit; the caller must hold mu
+func (m *MockManager) unit(serviceName string) (*mockUnit, error) {
+	u, ok := m.units[serviceName]
+	if !ok {
+		return nil, fmt.Errorf("%w: Unit %s not found.", ErrCommandFailed, serviceName)
+	}
+	return u, nil
+}
+
+// logf appends a journal entry and passes it to followers; the caller must hold mu
+func (m *MockManager) logf(serviceName string, u *mockUnit, format string, args ...interface{}) {
+	u.add(JournalEntry{Time: time.Now(), Priority: 6, Hostname: "servio-mock", Identifier: "systemd", PID: "1", Message: fmt.Sprintf(format, args...)})
+}
+
+// requestPlan returns the plan of a dry-run request. Global dry-run mode is
+// ignored, since nothing here touches the host.
+func requestPlan(ctx context.Context) *dryrun.Plan {
+	if plan := dryrun.FromContext(ctx); plan != nil && !plan.Global() {
+		return plan
+	}
+	return nil
+}
+
+// setActive starts or stops a unit
+func (m *MockManager) setActive(ctx context.Context, action, serviceName string, active bool) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Run([]string{"systemctl", action, serviceName})
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, err := m.unit(serviceName)
+	if err != nil {
+		return err
+	}
+	switch {
+	case active:
+		u.startedAt = time.Now()
+		m.logf(serviceName, u, "Started %s.", serviceName)
+	case u.active:
+		m.logf(serviceName, u, "Stopped %s.", serviceName)
+	}
+	u.active = active
+	// The instances of a scaled service are part of its unit
+	for name, instance := range m.units {
+		if !strings.HasPrefix(name, instanceBase(serviceName)+"@") {
+			continue
+		}
+		if active {
+			instance.startedAt = time.Now()
+			m.logf(name, instance, "Started %s.", name)
+		} else if instance.active {
+			m.logf(name, instance, "Stopped %s.", name)
+		}
+		instance.active = active
+	}
+	return nil
+}
+
+// Start simulates starting a unit
+func (m *MockManager) Start(ctx context.Context, serviceName string) error {
+	return m.setActive(ctx, "start", serviceName, true)
+}
+
+// Stop simulates stopping a unit
+func (m *MockManager) Stop(ctx context.Context, serviceName string) error {
+	return m.setActive(ctx, "stop", serviceName, false)
+}
+
+// Restart simulates restarting a unit
+func (m *MockManager) Restart(ctx context.Context, serviceName string) error {
+	return m.setActive(ctx, "restart", serviceName, true)
+}
+
+// setEnabled enables or disables a unit
+func (m *MockManager) setEnabled(ctx context.Context, action, serviceName string, enabled bool) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Run([]string{"systemctl", action, serviceName})
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, err := m.unit(serviceName)
+	if err != nil {
+		return err
+	}
+	u.enabled = enabled
+	return nil
+}
+
+// Enable simulates enabling a unit
+func (m *MockManager) Enable(ctx context.Context, serviceName string) error {
+	return m.setEnabled(ctx, "enable", serviceName, true)
+}
+
+// Disable simulates disabling a unit
+func (m *MockManager) Disable(ctx context.Context, serviceName string) error {
+	return m.setEnabled(ctx, "disable", serviceName, false)
+}
+
+// Status returns the simulated state of a unit
+func (m *MockManager) Status(ctx context.Context, serviceName string) (ServiceStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := ServiceStatus{Name: serviceName}
+	if u, ok := m.units[serviceName]; ok {
+		status.Active, status.Enabled = u.active, u.enabled
+		status.Output = fmt.Sprintf("● %s (simulated)\n   Active: %s", serviceName, activeState(u.active))
+	}
+	return status, nil
+}
+
+// ActiveState returns the simulated state as systemctl is-active would
+func (m *MockManager) ActiveState(ctx context.Context, serviceName string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.units[serviceName]
+	return activeState(ok && u.active)
+}
+
+// Process returns no PID, since no process runs, and the simulated state,
+// for monitor.SetProcessSource
+func (m *MockManager) Process(serviceName string) (int, string) {
+	return 0, m.ActiveState(context.Background(), serviceName)
+}
+
+func activeState(active bool) string {
+	if active {
+		return "active"
+	}
+	return "inactive"
+}
+
+// Reload does nothing; there is no daemon to reload
+func (m *MockManager) Reload(ctx context.Context) error {
+	return nil
+}
+
+// GetStartTime returns when the unit was last started, formatted like systemctl show
+func (m *MockManager) GetStartTime(ctx context.Context, serviceName string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.units[serviceName]
+	if !ok || !u.active {
+		return "", nil
+	}
+	return u.startedAt.Format("Mon 2006-01-02 15:04:05 MST"), nil
+}
+
+// GetLogsWithTimeRange returns the simulated journal; the range is ignored
+func (m *MockManager) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.units[serviceName]
+	if !ok {
+		return "-- No entries --\n", nil
+	}
+	return u.text(), nil
+}
+
+// GetLogEntries returns the simulated journal; since is ignored
+func (m *MockManager) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.units[serviceName]
+	if !ok {
+		return []JournalEntry{}, nil
+	}
+	return u.tail(lines), nil
+}
+
+// StreamLogs follows the simulated journal until ctx is done, starting with
+// the entries after cursor, or the whole journal when cursor is empty
+func (m *MockManager) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, err := m.unit(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return u.follow(ctx, &m.mu, cursor), nil
+}
+
+// StreamUnitLogs follows several simulated journals as one stream. Each
+// unit's whole journal after the given time is replayed, and units that are
+// not installed are skipped.
+func (m *MockManager) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan LogLine, error) {
+	return streamJournals(ctx, serviceNames, after, m.StreamLogs), nil
+}
+
+// InstallService generates the unit and keeps it in memory, with one unit
+// per instance of a scaled service. A dry-run request only records the writes.
+func (m *MockManager) InstallService(ctx context.Context, service *storage.Service) error {
+	content, err := m.GenerateServiceFile(service)
+	if err != nil {
+		return fmt.Errorf("failed to generate service file: %w", err)
+	}
+	if !service.Scaled() {
+		if requestPlan(ctx) == nil {
+			m.forgetInstances(service.ServiceName(), 0)
+		}
+		return m.InstallServiceFile(ctx, service, content, false)
+	}
+
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Write(filepath.Join(ServiceDir, templateName(service.ServiceName())), generateTemplateFile(service, content), 0644)
+		for n, port := range service.InstancePorts() {
+			dir := filepath.Join(ServiceDir, service.InstanceName(n+1)+".d")
+			plan.Mkdir(dir, 0755)
+			plan.Write(filepath.Join(dir, instanceDropIn), generateInstanceDropIn(service, content, port), 0644)
+		}
+	} else {
+		m.forgetInstances(service.ServiceName(), service.Replicas)
+		m.mu.Lock()
+		for n, port := range service.InstancePorts() {
+			name := service.InstanceName(n + 1)
+			u, ok := m.units[name]
+			if !ok {
+				u = &mockUnit{}
+				m.units[name] = u
+			}
+			u.content = generateInstanceDropIn(service, content, port)
+			m.logf(name, u, "Installed %s (simulated).", name)
+		}
+		m.mu.Unlock()
+	}
+	return m.InstallServiceFile(ctx, service, generateGroupFile(service), false)
+}
+
+// forgetInstances forgets the instances of a service numbered above keep
+func (m *MockManager) forgetInstances(serviceName string, keep int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := instanceBase(serviceName) + "@"
+	for name, u := range m.units {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".service")); err == nil && n <= keep {
+			continue
+		}
+		u.close()
+		delete(m.units, name)
+	}
+}
+
+// InstallServiceFile keeps a unit generated elsewhere in memory
+func (m *MockManager) InstallServiceFile(ctx context.Context, service *storage.Service, content string, private bool) error {
+	if plan := requestPlan(ctx); plan != nil {
+		mode := os.FileMode(0644)
+		if private {
+			mode = 0600
+		}
+		plan.Write(filepath.Join(ServiceDir, service.ServiceName()), content, mode)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := service.ServiceName()
+	u, ok := m.units[name]
+	if !ok {
+		u = &mockUnit{}
+		m.units[name] = u
+	}
+	u.content = content
+	m.logf(name, u, "Installed %s (simulated).", name)
+	return nil
+}
+
+// UninstallService forgets the unit
+func (m *MockManager) UninstallService(ctx context.Context, serviceName string) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Remove(filepath.Join(ServiceDir, serviceName))
+		return nil
+	}
+
+	m.forgetInstances(serviceName, 0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.units[serviceName]; ok {
+		u.close()
+		delete(m.units, serviceName)
+	}
+	return nil
+}
+
+// ServiceExists reports whether the unit has been installed
+func (m *MockManager) ServiceExists(serviceName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.units[serviceName]
+	return ok
+}
+
+// Ping always succeeds
+func (m *MockManager) Ping(ctx context.Context) error {
+	return nil
+}
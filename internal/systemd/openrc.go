@@ -0,0 +1,131 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// openRCInitDir holds OpenRC's service scripts
+	openRCInitDir = "/etc/init.d"
+	// openRCRunlevel is the runlevel enabled units are added to
+	openRCRunlevel = "default"
+)
+
+// openRC runs units as OpenRC services. Units that restart are supervised by
+// supervise-daemon, which respawns them; the others are started in the
+// background by start-stop-daemon.
+type openRC struct{}
+
+func (openRC) name() string {
+	return InitOpenRC
+}
+
+func (openRC) scriptPath(serviceName string) string {
+	return filepath.Join(openRCInitDir, instanceBase(serviceName))
+}
+
+func (o openRC) scripts(serviceName string, spec execSpec) []initFile {
+	var b strings.Builder
+	b.WriteString("#!/sbin/openrc-run\n# Managed by Servio\n")
+	fmt.Fprintf(&b, "description=%s\n", shellQuote(spec.description))
+	if spec.command == "" {
+		// A scaled service's unit; InitManager starts and stops its instances
+		b.WriteString("\nstart() {\n\treturn 0\n}\n\nstop() {\n\treturn 0\n}\n")
+		return []initFile{{path: o.scriptPath(serviceName), content: b.String(), mode: 0755}}
+	}
+
+	if spec.restart == "no" {
+		b.WriteString("command_background=true\npidfile=\"/run/${RC_SVCNAME}.pid\"\n")
+	} else {
+		fmt.Fprintf(&b, "supervisor=supervise-daemon\nrespawn_delay=%d\nrespawn_max=0\n", int(spec.restartSec.Round(time.Second)/time.Second))
+	}
+	// command_args is evaluated by the shell, so it is quoted twice
+	fmt.Fprintf(&b, "command=/bin/sh\ncommand_args=%s\n", shellQuote("-c "+shellQuote(spec.shellCommand())))
+	if user := spec.runsAs(); user != "" {
+		fmt.Fprintf(&b, "command_user=%s\n", shellQuote(user))
+	}
+	logPath := o.logPath(serviceName)
+	fmt.Fprintf(&b, "output_log=%s\nerror_log=%s\n", shellQuote(logPath), shellQuote(logPath))
+	b.WriteString(spec.exports())
+	b.WriteString("\ndepend() {\n\tafter net\n}\n")
+	if user := spec.runsAs(); user != "" {
+		// The log is opened as the service's user
+		fmt.Fprintf(&b, "\nstart_pre() {\n\tcheckpath --file --owner %s %s\n}\n", shellQuote(user), shellQuote(logPath))
+	}
+	return []initFile{{path: o.scriptPath(serviceName), content: b.String(), mode: 0755}}
+}
+
+// register does nothing: OpenRC reads /etc/init.d as it goes
+func (openRC) register(ctx context.Context, serviceName string) error {
+	return nil
+}
+
+func (o openRC) unregister(ctx context.Context, serviceName string) error {
+	if !o.enabled(serviceName) {
+		return nil
+	}
+	return o.setEnabled(ctx, serviceName, false)
+}
+
+func (openRC) control(ctx context.Context, action, serviceName string) error {
+	return initCommand(ctx, action, "rc-service", instanceBase(serviceName), action)
+}
+
+func (openRC) setEnabled(ctx context.Context, serviceName string, enabled bool) error {
+	if enabled {
+		return initCommand(ctx, "enable", "rc-update", "add", instanceBase(serviceName), openRCRunlevel)
+	}
+	return initCommand(ctx, "disable", "rc-update", "del", instanceBase(serviceName), openRCRunlevel)
+}
+
+func (openRC) enabled(serviceName string) bool {
+	_, err := os.Lstat(filepath.Join("/etc/runlevels", openRCRunlevel, instanceBase(serviceName)))
+	return err == nil
+}
+
+// status reads the exit status of rc-service status: 0 started, 3 stopped,
+// and 32 crashed, which is a supervised process that died
+func (openRC) status(ctx context.Context, serviceName string) (string, string) {
+	output, err := exec.CommandContext(ctx, "rc-service", instanceBase(serviceName), "status").CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "active", string(output)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 32:
+		return "failed", string(output)
+	}
+	return "inactive", string(output)
+}
+
+// startedAt reads when OpenRC marked the unit started
+func (openRC) startedAt(ctx context.Context, serviceName string) time.Time {
+	info, err := os.Lstat(filepath.Join("/run/openrc/started", instanceBase(serviceName)))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (openRC) logPath(serviceName string) string {
+	return LogFilePath(serviceName)
+}
+
+// entry returns a line of the log as it is; OpenRC writes no timestamps
+func (openRC) entry(line string) JournalEntry {
+	return JournalEntry{Priority: NoPriority, Message: line}
+}
+
+func (openRC) ping(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "rc-status", "--runlevel").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: rc-status: %s - %w", ErrCommandFailed, strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package systemd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"servio/internal/storage"
+)
+
+// ErrDependencyCycle is returned when services order themselves after each other
+var ErrDependencyCycle = errors.New("service dependencies form a cycle")
+
+// orderingKeys are the [Unit] directives that make a unit start after another
+var orderingKeys = map[string]bool{"After": true, "Requires": true, "Wants": true, "BindsTo": true}
+
+// UnitDependencies returns the units a unit file declares it starts after
+func UnitDependencies(unitFile string) []string {
+	var deps []string
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(unitFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		if section != "[Unit]" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !orderingKeys[strings.TrimSpace(key)] {
+			continue
+		}
+		deps = append(deps, strings.Fields(value)...)
+	}
+	return deps
+}
+
+// StartOrder sorts services so each one follows the services its custom unit
+// file declares After=/Requires=/Wants=/BindsTo= on. Units outside the given
+// set (network.target, databases managed elsewhere) are ignored, and services
+// without dependencies keep their relative order. It also returns each
+// service's in-set dependencies by service ID.
+func StartOrder(services []*storage.Service) ([]*storage.Service, map[int64][]int64, error) {
+	byUnit := make(map[string]*storage.Service, len(services))
+	for _, sv := range services {
+		byUnit[sv.ServiceName()] = sv
+	}
+
+	deps := make(map[int64][]int64)
+	dependents := make(map[int64][]*storage.Service)
+	pending := make(map[int64]int)
+	for _, sv := range services {
+		seen := make(map[int64]bool)
+		for _, unit := range UnitDependencies(sv.SystemdRaw) {
+			dep, ok := byUnit[unit]
+			if !ok || dep.ID == sv.ID || seen[dep.ID] {
+				continue
+			}
+			seen[dep.ID] = true
+			deps[sv.ID] = append(deps[sv.ID], dep.ID)
+			dependents[dep.ID] = append(dependents[dep.ID], sv)
+			pending[sv.ID]++
+		}
+	}
+
+	// Kahn's algorithm, seeded in input order so the result is stable
+	ordered := make([]*storage.Service, 0, len(services))
+	var ready []*storage.Service
+	for _, sv := range services {
+		if pending[sv.ID] == 0 {
+			ready = append(ready, sv)
+		}
+	}
+	for len(ready) > 0 {
+		sv := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, sv)
+		for _, next := range dependents[sv.ID] {
+			if pending[next.ID]--; pending[next.ID] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if len(ordered) < len(services) {
+		var names []string
+		for _, sv := range services {
+			if pending[sv.ID] > 0 {
+				names = append(names, sv.Name)
+			}
+		}
+		return nil, nil, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(names, ", "))
+	}
+	return ordered, deps, nil
+}
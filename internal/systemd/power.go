@@ -0,0 +1,30 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"servio/internal/audit"
+)
+
+// Power actions, named after the systemctl commands that perform them
+const (
+	PowerReboot   = "reboot"
+	PowerShutdown = "poweroff"
+)
+
+// Power reboots or shuts down the host. systemctl returns once the shutdown
+// is queued, so the caller usually gets to finish before it is stopped.
+func Power(ctx context.Context, action string) error {
+	if action != PowerReboot && action != PowerShutdown {
+		return fmt.Errorf("unknown power action %q", action)
+	}
+	cmd := exec.CommandContext(ctx, "sudo", "systemctl", action)
+	output, err := audit.Run(ctx, audit.CategorySystemd, action, cmd)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s - %w", ErrCommandFailed, action, strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
@@ -0,0 +1,781 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"servio/internal/storage"
+)
+
+// processStopTimeout is how long a stopped unit's process has to exit after
+// SIGTERM before it is killed, as systemd's TimeoutStopSec
+const processStopTimeout = 10 * time.Second
+
+// ProcessManager is a ServiceManager that runs units as child processes of
+// Servio, for hosts without systemd such as a developer's Mac. The embedded
+// Manager generates the units, which ProcessManager keeps in its directory
+// and starts again with Servio when enabled. A unit's ExecStart runs through
+// /bin/sh in its WorkingDirectory, with Servio's environment plus the unit's
+// Environment and EnvironmentFile, and is restarted as its Restart says.
+// User is ignored: every unit runs as Servio's own user. Output goes to the
+// unit's journal, kept in memory, and to a log file per unit.
+type ProcessManager struct {
+	*Manager
+
+	dir      string
+	hostname string
+
+	mu    sync.Mutex
+	units map[string]*procUnit
+}
+
+// procUnit is one unit and the process running it
+type procUnit struct {
+	content   string
+	enabled   bool
+	active    bool // started; units without ExecStart are active without a process
+	failed    bool // the process exited with an error and was not restarted
+	startedAt time.Time
+	pid       int
+	stop      chan struct{} // closed to stop the process
+	done      chan struct{} // closed once the process has exited for good
+	log       *os.File
+	memJournal
+}
+
+// NewProcessManager creates a ProcessManager generating units with m and
+// keeping them in dir/units, with their output in dir/logs. The units found
+// there are loaded, not started; see StartEnabled.
+func NewProcessManager(m *Manager, dir string) (*ProcessManager, error) {
+	for _, sub := range []string{"units", "logs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s directory: %w", sub, err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	p := &ProcessManager{Manager: m, dir: dir, hostname: hostname, units: make(map[string]*procUnit)}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "units"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read units: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".enabled") {
+			continue
+		}
+		content, err := os.ReadFile(p.unitPath(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read unit %s: %w", name, err)
+		}
+		_, err = os.Stat(p.unitPath(name) + ".enabled")
+		p.units[name] = &procUnit{content: string(content), enabled: err == nil}
+	}
+	return p, nil
+}
+
+// unitPath returns where a unit is kept
+func (p *ProcessManager) unitPath(serviceName string) string {
+	return filepath.Join(p.dir, "units", serviceName)
+}
+
+// unit returns the named unit; the caller must hold mu
+func (p *ProcessManager) unit(serviceName string) (*procUnit, error) {
+	u, ok := p.units[serviceName]
+	if !ok {
+		return nil, fmt.Errorf("%w: Unit %s not found.", ErrCommandFailed, serviceName)
+	}
+	return u, nil
+}
+
+// logf appends an entry from the supervisor to a unit's journal; the caller must hold mu
+func (p *ProcessManager) logf(serviceName string, u *procUnit, format string, args ...interface{}) {
+	p.record(serviceName, u, JournalEntry{Time: time.Now(), Priority: 6, Hostname: p.hostname, Identifier: "servio", PID: strconv.Itoa(os.Getpid()), Message: fmt.Sprintf(format, args...)})
+}
+
+// record appends an entry to a unit's journal and log file; the caller must hold mu
+func (p *ProcessManager) record(serviceName string, u *procUnit, e JournalEntry) {
+	u.add(e)
+	if u.log == nil {
+		f, err := os.OpenFile(filepath.Join(p.dir, "logs", serviceName+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			slog.Warn("Failed to open unit log", "unit", serviceName, "error", err)
+			return
+		}
+		u.log = f
+	}
+	fmt.Fprintln(u.log, e.Line())
+}
+
+// StartEnabled starts the enabled units, as booting does under systemd
+func (p *ProcessManager) StartEnabled(ctx context.Context) {
+	p.mu.Lock()
+	var names []string
+	for name, u := range p.units {
+		if u.enabled {
+			names = append(names, name)
+		}
+	}
+	p.mu.Unlock()
+	for _, name := range names {
+		if err := p.Start(ctx, name); err != nil {
+			slog.Error("Failed to start unit", "unit", name, "error", err)
+		}
+	}
+}
+
+// Shutdown stops every running unit, for when Servio exits
+func (p *ProcessManager) Shutdown() {
+	p.mu.Lock()
+	var names []string
+	for name, u := range p.units {
+		if u.active {
+			names = append(names, name)
+		}
+	}
+	p.mu.Unlock()
+	for _, name := range names {
+		p.stopUnit(name)
+	}
+}
+
+// Process returns the PID of a unit's process, 0 when it runs none, and the
+// unit's state as ActiveState does, for monitor.SetProcessSource
+func (p *ProcessManager) Process(serviceName string) (int, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.units[serviceName]
+	if !ok {
+		return 0, "inactive"
+	}
+	return u.pid, u.state()
+}
+
+// state returns the unit's state as systemctl is-active would; the caller must hold mu
+func (u *procUnit) state() string {
+	if u.failed {
+		return "failed"
+	}
+	return activeState(u.active)
+}
+
+// instances returns the instances of a scaled service's unit; the caller must hold mu
+func (p *ProcessManager) instances(serviceName string) []string {
+	var names []string
+	for name := range p.units {
+		if strings.HasPrefix(name, instanceBase(serviceName)+"@") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// startUnit starts a unit's process unless it is running
+func (p *ProcessManager) startUnit(serviceName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, err := p.unit(serviceName)
+	if err != nil {
+		return err
+	}
+	if u.active {
+		return nil
+	}
+	spec := parseUnit(u.content, serviceName)
+	u.active, u.failed, u.startedAt = true, false, time.Now()
+	if spec.command == "" {
+		p.logf(serviceName, u, "Started %s.", serviceName)
+		return nil
+	}
+	u.stop, u.done = make(chan struct{}), make(chan struct{})
+	go p.supervise(serviceName, u, spec, u.stop, u.done)
+	return nil
+}
+
+// stopUnit stops a unit's process, waiting for it to exit
+func (p *ProcessManager) stopUnit(serviceName string) {
+	p.mu.Lock()
+	u, ok := p.units[serviceName]
+	if !ok || !u.active && u.done == nil {
+		p.mu.Unlock()
+		return
+	}
+	stop, done := u.stop, u.done
+	u.active, u.stop, u.done = false, nil, nil
+	if stop == nil {
+		p.logf(serviceName, u, "Stopped %s.", serviceName)
+	}
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// supervise runs a unit's process until stop is closed, restarting it as the
+// unit's Restart says, then closes done
+func (p *ProcessManager) supervise(serviceName string, u *procUnit, spec execSpec, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		cmd, err := spec.cmd()
+		var exited chan error
+		if err == nil {
+			cmd.Stdout = p.output(serviceName, u, spec.identifier)
+			cmd.Stderr = p.output(serviceName, u, spec.identifier)
+			cmd.WaitDelay = processStopTimeout // a child left holding the output must not stall Wait
+			err = cmd.Start()
+		}
+		if err == nil {
+			exited = make(chan error, 1)
+			go func() { exited <- cmd.Wait() }()
+			p.mu.Lock()
+			u.pid = cmd.Process.Pid
+			p.logf(serviceName, u, "Started %s.", serviceName)
+			p.mu.Unlock()
+
+			select {
+			case err = <-exited:
+			case <-stop:
+				cmd.Process.Signal(syscall.SIGTERM)
+				select {
+				case <-exited:
+				case <-time.After(processStopTimeout):
+					cmd.Process.Kill()
+					<-exited
+				}
+				p.mu.Lock()
+				u.pid = 0
+				p.logf(serviceName, u, "Stopped %s.", serviceName)
+				p.mu.Unlock()
+				return
+			}
+		}
+
+		p.mu.Lock()
+		u.pid = 0
+		if err != nil {
+			p.logf(serviceName, u, "%s: Main process exited: %v", serviceName, err)
+		}
+		restart := spec.restarts(err)
+		if restart {
+			p.logf(serviceName, u, "%s: Scheduling restart in %s.", serviceName, spec.restartSec)
+		} else if u.done == done { // not already stopped
+			u.active, u.failed, u.stop, u.done = false, err != nil, nil, nil
+			if err != nil {
+				p.logf(serviceName, u, "%s: Failed with result 'exit-code'.", serviceName)
+			}
+		}
+		p.mu.Unlock()
+		if !restart {
+			return
+		}
+		select {
+		case <-time.After(spec.restartSec):
+		case <-stop:
+			p.mu.Lock()
+			p.logf(serviceName, u, "Stopped %s.", serviceName)
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// output returns a writer passing each line a unit's process prints to its journal
+func (p *ProcessManager) output(serviceName string, u *procUnit, identifier string) *lineWriter {
+	return &lineWriter{line: func(line string) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		pid := ""
+		if u.pid != 0 {
+			pid = strconv.Itoa(u.pid)
+		}
+		p.record(serviceName, u, JournalEntry{Time: time.Now(), Priority: 6, Hostname: p.hostname, Identifier: identifier, PID: pid, Message: line})
+	}}
+}
+
+// lineWriter calls line for each line written to it
+type lineWriter struct {
+	buf  []byte
+	line func(string)
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Start starts a unit's process; a scaled service's unit starts its instances
+func (p *ProcessManager) Start(ctx context.Context, serviceName string) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Run([]string{"systemctl", "start", serviceName})
+		return nil
+	}
+	if err := p.startUnit(serviceName); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	instances := p.instances(serviceName)
+	p.mu.Unlock()
+	for _, name := range instances {
+		if err := p.startUnit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops a unit's process and, for a scaled service, its instances
+func (p *ProcessManager) Stop(ctx context.Context, serviceName string) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Run([]string{"systemctl", "stop", serviceName})
+		return nil
+	}
+	p.mu.Lock()
+	_, err := p.unit(serviceName)
+	instances := p.instances(serviceName)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, name := range instances {
+		p.stopUnit(name)
+	}
+	p.stopUnit(serviceName)
+	return nil
+}
+
+// Restart stops and starts a unit
+func (p *ProcessManager) Restart(ctx context.Context, serviceName string) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Run([]string{"systemctl", "restart", serviceName})
+		return nil
+	}
+	if err := p.Stop(ctx, serviceName); err != nil {
+		return err
+	}
+	return p.Start(ctx, serviceName)
+}
+
+// setEnabled marks whether a unit starts with Servio
+func (p *ProcessManager) setEnabled(ctx context.Context, action, serviceName string, enabled bool) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Run([]string{"systemctl", action, serviceName})
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, err := p.unit(serviceName)
+	if err != nil {
+		return err
+	}
+	marker := p.unitPath(serviceName) + ".enabled"
+	if enabled {
+		err = os.WriteFile(marker, nil, 0644)
+	} else if err = os.Remove(marker); errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", action, serviceName, err)
+	}
+	u.enabled = enabled
+	return nil
+}
+
+// Enable starts a unit whenever Servio starts
+func (p *ProcessManager) Enable(ctx context.Context, serviceName string) error {
+	return p.setEnabled(ctx, "enable", serviceName, true)
+}
+
+// Disable stops starting a unit with Servio
+func (p *ProcessManager) Disable(ctx context.Context, serviceName string) error {
+	return p.setEnabled(ctx, "disable", serviceName, false)
+}
+
+// Status returns a unit's state, with its process in the output
+func (p *ProcessManager) Status(ctx context.Context, serviceName string) (ServiceStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := ServiceStatus{Name: serviceName}
+	u, ok := p.units[serviceName]
+	if !ok {
+		return status, nil
+	}
+	status.Active, status.Enabled = u.active, u.enabled
+	var b strings.Builder
+	fmt.Fprintf(&b, "● %s\n   Loaded: %s", serviceName, p.unitPath(serviceName))
+	if u.enabled {
+		b.WriteString("; enabled")
+	}
+	fmt.Fprintf(&b, "\n   Active: %s", u.state())
+	if u.active {
+		fmt.Fprintf(&b, " since %s", u.startedAt.Format("Mon 2006-01-02 15:04:05 MST"))
+	}
+	if u.pid != 0 {
+		fmt.Fprintf(&b, "\n Main PID: %d", u.pid)
+	}
+	for _, e := range u.tail(10) {
+		b.WriteString("\n" + e.Line())
+	}
+	status.Output = b.String()
+	return status, nil
+}
+
+// ActiveState returns a unit's state as systemctl is-active would
+func (p *ProcessManager) ActiveState(ctx context.Context, serviceName string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.units[serviceName]
+	if !ok {
+		return "inactive"
+	}
+	return u.state()
+}
+
+// Reload does nothing; units are read each time they start
+func (p *ProcessManager) Reload(ctx context.Context) error {
+	return nil
+}
+
+// GetStartTime returns when the unit was last started, formatted like systemctl show
+func (p *ProcessManager) GetStartTime(ctx context.Context, serviceName string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.units[serviceName]
+	if !ok || !u.active {
+		return "", nil
+	}
+	return u.startedAt.Format("Mon 2006-01-02 15:04:05 MST"), nil
+}
+
+// GetLogsWithTimeRange returns the unit's journal since Servio started; the
+// range is ignored
+func (p *ProcessManager) GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.units[serviceName]
+	if !ok {
+		return "-- No entries --\n", nil
+	}
+	return u.text(), nil
+}
+
+// GetLogEntries returns the unit's journal since Servio started; since is ignored
+func (p *ProcessManager) GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]JournalEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.units[serviceName]
+	if !ok {
+		return []JournalEntry{}, nil
+	}
+	return u.tail(lines), nil
+}
+
+// StreamLogs follows the unit's journal until ctx is done, starting with the
+// entries after cursor, or the whole journal when cursor is empty
+func (p *ProcessManager) StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, err := p.unit(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return u.follow(ctx, &p.mu, cursor), nil
+}
+
+// StreamUnitLogs follows several journals as one stream. Each unit's journal
+// after the given time is replayed, and units that are not installed are skipped.
+func (p *ProcessManager) StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan LogLine, error) {
+	return streamJournals(ctx, serviceNames, after, p.StreamLogs), nil
+}
+
+// InstallService generates the unit and keeps it, with one unit per instance
+// of a scaled service: the template with the instance's drop-in after it.
+// A dry-run request only records the writes.
+func (p *ProcessManager) InstallService(ctx context.Context, service *storage.Service) error {
+	content, err := p.GenerateServiceFile(service)
+	if err != nil {
+		return fmt.Errorf("failed to generate service file: %w", err)
+	}
+	plan := requestPlan(ctx)
+	private := false
+	if p.secrets != nil && plan == nil {
+		resolved, substituted, err := p.secrets.Resolve(ctx, service, content)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		if substituted {
+			content, private = resolved, true
+		}
+	}
+	if !service.Scaled() {
+		if plan == nil {
+			p.forgetInstances(service.ServiceName(), 0)
+		}
+		return p.InstallServiceFile(ctx, service, content, private)
+	}
+
+	if plan == nil {
+		p.forgetInstances(service.ServiceName(), service.Replicas)
+	}
+	template := generateTemplateFile(service, content)
+	for n, port := range service.InstancePorts() {
+		instance := template + "\n" + generateInstanceDropIn(service, content, port)
+		if err := p.keepUnit(ctx, service.InstanceName(n+1), instance, private); err != nil {
+			return err
+		}
+	}
+	return p.InstallServiceFile(ctx, service, generateGroupFile(service), false)
+}
+
+// InstallServiceFile keeps a unit generated elsewhere. A private unit holds
+// resolved secrets and is only readable by Servio's user.
+func (p *ProcessManager) InstallServiceFile(ctx context.Context, service *storage.Service, content string, private bool) error {
+	return p.keepUnit(ctx, service.ServiceName(), content, private)
+}
+
+// keepUnit writes a unit to the directory and loads it; a running unit
+// keeps its process until restarted
+func (p *ProcessManager) keepUnit(ctx context.Context, name, content string, private bool) error {
+	mode := os.FileMode(0644)
+	if private {
+		mode = 0600
+	}
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Write(p.unitPath(name), content, mode)
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := os.WriteFile(p.unitPath(name), []byte(content), mode); err != nil {
+		return fmt.Errorf("failed to write unit %s: %w", name, err)
+	}
+	if err := os.Chmod(p.unitPath(name), mode); err != nil {
+		return fmt.Errorf("failed to set permissions of unit %s: %w", name, err)
+	}
+	u, ok := p.units[name]
+	if !ok {
+		u = &procUnit{}
+		p.units[name] = u
+	}
+	u.content = content
+	p.logf(name, u, "Installed %s.", name)
+	return nil
+}
+
+// forgetInstances stops and removes the instances of a service numbered above keep
+func (p *ProcessManager) forgetInstances(serviceName string, keep int) {
+	p.mu.Lock()
+	prefix := instanceBase(serviceName) + "@"
+	var names []string
+	for _, name := range p.instances(serviceName) {
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".service")); err == nil && n <= keep {
+			continue
+		}
+		names = append(names, name)
+	}
+	p.mu.Unlock()
+	for _, name := range names {
+		p.forget(name)
+	}
+}
+
+// forget stops a unit and removes it with its enabled marker; its log file stays
+func (p *ProcessManager) forget(serviceName string) {
+	p.stopUnit(serviceName)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.units[serviceName]
+	if !ok {
+		return
+	}
+	for _, path := range []string{p.unitPath(serviceName), p.unitPath(serviceName) + ".enabled"} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove unit file", "path", path, "error", err)
+		}
+	}
+	u.close()
+	if u.log != nil {
+		u.log.Close()
+	}
+	delete(p.units, serviceName)
+}
+
+// UninstallService stops and removes the unit and its instances
+func (p *ProcessManager) UninstallService(ctx context.Context, serviceName string) error {
+	if plan := requestPlan(ctx); plan != nil {
+		plan.Remove(p.unitPath(serviceName))
+		return nil
+	}
+	p.forgetInstances(serviceName, 0)
+	p.forget(serviceName)
+	return nil
+}
+
+// ServiceExists reports whether the unit has been installed
+func (p *ProcessManager) ServiceExists(serviceName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.units[serviceName]
+	return ok
+}
+
+// Ping always succeeds
+func (p *ProcessManager) Ping(ctx context.Context) error {
+	return nil
+}
+
+// execSpec is what the managers running units without systemd read from
+// a unit to run it
+type execSpec struct {
+	description string
+	command     string
+	dir         string
+	user        string
+	env         []string
+	envFiles    []string // a leading "-" marks a file that may be missing
+	restart     string
+	restartSec  time.Duration
+	identifier  string
+}
+
+// parseUnit reads a unit's Description and [Service] settings. Later lines
+// override earlier ones, so an instance's drop-in after its template takes
+// effect, and an empty ExecStart clears the one before. The instance name
+// replaces %i.
+func parseUnit(content, serviceName string) execSpec {
+	spec := execSpec{restart: "no", restartSec: 100 * time.Millisecond, identifier: instanceBase(serviceName)}
+	instance := ""
+	if _, after, ok := strings.Cut(instanceBase(serviceName), "@"); ok {
+		instance = after
+	}
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value = strings.ReplaceAll(strings.TrimSpace(value), "%i", instance)
+		if section == "[Unit]" && strings.TrimSpace(key) == "Description" {
+			spec.description = value
+		}
+		if section != "[Service]" {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "ExecStart":
+			spec.command = strings.TrimLeft(value, "-@+!:")
+		case "WorkingDirectory":
+			spec.dir = strings.TrimPrefix(value, "-")
+		case "User":
+			spec.user = value
+		case "Environment":
+			spec.env = append(spec.env, splitQuoted(value)...)
+		case "EnvironmentFile":
+			spec.envFiles = append(spec.envFiles, value)
+		case "Restart":
+			spec.restart = value
+		case "RestartSec":
+			if d, err := time.ParseDuration(value); err == nil {
+				spec.restartSec = d
+			} else if secs, err := strconv.ParseFloat(value, 64); err == nil {
+				spec.restartSec = time.Duration(secs * float64(time.Second))
+			}
+		case "SyslogIdentifier":
+			spec.identifier = value
+		}
+	}
+	return spec
+}
+
+// splitQuoted splits an Environment value into its assignments, which are
+// separated by spaces and may be double-quoted
+func splitQuoted(value string) []string {
+	var words []string
+	var b strings.Builder
+	quoted, started := false, false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted, started = !quoted, true
+		case r == ' ' && !quoted:
+			if started {
+				words = append(words, b.String())
+				b.Reset()
+				started = false
+			}
+		default:
+			b.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		words = append(words, b.String())
+	}
+	return words
+}
+
+// cmd builds the process of a unit, reading its environment files afresh
+func (s execSpec) cmd() (*exec.Cmd, error) {
+	env := os.Environ()
+	for _, file := range s.envFiles {
+		optional := strings.HasPrefix(file, "-")
+		data, err := os.ReadFile(strings.TrimPrefix(file, "-"))
+		if err != nil {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read environment file: %w", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || !strings.Contains(line, "=") {
+				continue
+			}
+			key, value, _ := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+			env = append(env, strings.TrimSpace(key)+"="+strings.Trim(strings.TrimSpace(value), `"'`))
+		}
+	}
+	cmd := exec.Command("/bin/sh", "-c", "exec "+s.command)
+	cmd.Dir = s.dir
+	cmd.Env = append(env, s.env...)
+	return cmd, nil
+}
+
+// restarts reports whether the unit's process is started again after it
+// exited with err
+func (s execSpec) restarts(err error) bool {
+	switch s.restart {
+	case "always":
+		return true
+	case "on-failure", "on-abnormal", "on-abort":
+		return err != nil
+	}
+	return false
+}
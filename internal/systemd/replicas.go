@@ -0,0 +1,239 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// instanceDropIn is the drop-in giving each instance of a scaled service its port
+const instanceDropIn = "servio-instance.conf"
+
+// instanceBase returns the unit name of a service without its suffix,
+// which its template and instances start with
+func instanceBase(serviceName string) string {
+	return strings.TrimSuffix(serviceName, ".service")
+}
+
+// templateName returns the template unit the instances of a scaled service run
+func templateName(serviceName string) string {
+	return instanceBase(serviceName) + "@.service"
+}
+
+// isScaled reports whether a unit runs its service's instances, which is
+// when the service's template is installed
+func isScaled(serviceName string) bool {
+	if strings.Contains(serviceName, "@") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(ServiceDir, templateName(serviceName)))
+	return err == nil
+}
+
+// unitMatches returns the journalctl matches for a unit's logs; those of a
+// scaled service's unit take in all its instances
+func unitMatches(serviceName string) []string {
+	if isScaled(serviceName) {
+		return []string{"-u", serviceName, "-u", instanceBase(serviceName) + "@*.service"}
+	}
+	return []string{"-u", serviceName}
+}
+
+// generateGroupFile returns the unit of a scaled service. It runs nothing
+// itself: starting it starts every instance, and the instances are part of
+// it, so stopping, restarting, enabling and disabling it act on them all.
+func generateGroupFile(service *storage.Service) string {
+	instances := make([]string, service.Replicas)
+	for i := range instances {
+		instances[i] = service.InstanceName(i + 1)
+	}
+	return fmt.Sprintf(`# Managed by Servio
+[Unit]
+Description=Managed Service: %s (%d instances)
+Wants=%s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+
+[Install]
+WantedBy=multi-user.target
+`, service.Name, service.Replicas, strings.Join(instances, " "))
+}
+
+// generateTemplateFile turns a service's unit into the template its instances
+// run. Instances are tied to the service's unit and have no [Install]
+// section of their own.
+func generateTemplateFile(service *storage.Service, content string) string {
+	var b strings.Builder
+	inInstall, unitSeen := false, false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inInstall = trimmed == "[Install]"
+		}
+		if inInstall {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "Description=") {
+			line = strings.TrimRight(line, "\n") + " (instance %i)\n"
+		}
+		b.WriteString(line)
+		if trimmed == "[Unit]" && !unitSeen {
+			unitSeen = true
+			fmt.Fprintf(&b, "PartOf=%s\n", service.ServiceName())
+		}
+	}
+	if !unitSeen {
+		return fmt.Sprintf("[Unit]\nPartOf=%s\n\n", service.ServiceName()) + strings.TrimRight(b.String(), "\n") + "\n"
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// generateInstanceDropIn returns the drop-in that moves an instance of a
+// service from the service's port to its own: PORT is set, and the
+// ExecStart and Environment lines of the template that name the service's
+// port are repeated with the instance's, overriding them
+func generateInstanceDropIn(service *storage.Service, template string, port int) string {
+	var b strings.Builder
+	b.WriteString("# Managed by Servio\n[Service]\n")
+	fmt.Fprintf(&b, "Environment=\"PORT=%d\"\n", port)
+	if service.Port <= 0 || port == service.Port {
+		return b.String()
+	}
+
+	portPattern := regexp.MustCompile(`(^|[^0-9])` + strconv.Itoa(service.Port) + `([^0-9]|$)`)
+	replacement := "${1}" + strconv.Itoa(port) + "${2}"
+	for _, line := range strings.Split(template, "\n") {
+		line = strings.TrimSpace(line)
+		isExec := strings.HasPrefix(line, "ExecStart=")
+		if !isExec && !strings.HasPrefix(line, "Environment=") || !portPattern.MatchString(line) {
+			continue
+		}
+		if isExec {
+			b.WriteString("ExecStart=\n") // a template's ExecStart is replaced, not added to
+		}
+		b.WriteString(portPattern.ReplaceAllString(line, replacement) + "\n")
+	}
+	return b.String()
+}
+
+// installInstances writes a scaled service's template and the drop-in of each
+// instance, and stops and removes the instances beyond its replicas. content
+// is the service's unit as GenerateServiceFile returns it.
+func (m *Manager) installInstances(ctx context.Context, service *storage.Service, content string, private bool) error {
+	mode := os.FileMode(0644)
+	if private {
+		mode = 0600
+	}
+	template := generateTemplateFile(service, content)
+	if err := m.removeInstances(ctx, service.ServiceName(), service.Replicas); err != nil {
+		return err
+	}
+
+	plan := dryrun.FromContext(ctx)
+	files := map[string]string{filepath.Join(ServiceDir, templateName(service.ServiceName())): template}
+	for n, port := range service.InstancePorts() {
+		dir := filepath.Join(ServiceDir, service.InstanceName(n+1)+".d")
+		if plan != nil {
+			plan.Mkdir(dir, 0755)
+		} else if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create drop-in directory: %w", err)
+		}
+		files[filepath.Join(dir, instanceDropIn)] = generateInstanceDropIn(service, content, port)
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		if plan != nil {
+			plan.Write(path, files[path], mode)
+			continue
+		}
+		if err := writeUnitFile(ctx, path, files[path], mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeInstances stops the instances of a service numbered above keep and
+// removes their drop-ins. With keep 0, the template goes too, leaving the
+// service a single unit again. Services that never scaled have none.
+func (m *Manager) removeInstances(ctx context.Context, serviceName string, keep int) error {
+	dirs, _ := filepath.Glob(filepath.Join(ServiceDir, instanceBase(serviceName)+"@*.service.d"))
+	plan := dryrun.FromContext(ctx)
+	for _, dir := range dirs {
+		instance := strings.TrimSuffix(filepath.Base(dir), ".d")
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(instance, instanceBase(serviceName)+"@"), ".service"))
+		if err != nil || n <= keep {
+			continue
+		}
+		// The drop-in goes even if the instance fails to stop
+		m.Stop(ctx, instance)
+		if plan != nil {
+			plan.Remove(dir)
+			continue
+		}
+		start := time.Now()
+		err = os.RemoveAll(dir)
+		audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+dir, "", err, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("failed to remove instance drop-in: %w", err)
+		}
+		audit.RemovedFile(ctx, dir)
+	}
+	if keep > 0 {
+		return nil
+	}
+
+	template := filepath.Join(ServiceDir, templateName(serviceName))
+	if _, err := os.Stat(template); err != nil {
+		return nil
+	}
+	// The template's drop-ins, such as its project slice's, go with it
+	if err := removeManagedFile(ctx, filepath.Join(template+".d", sliceDropIn)); err != nil {
+		return err
+	}
+	if plan != nil {
+		plan.Remove(template)
+		return nil
+	}
+	os.Remove(template + ".d")
+	start := time.Now()
+	err := os.Remove(template)
+	audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+template, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to remove template unit: %w", err)
+	}
+	audit.RemovedFile(ctx, template)
+	return nil
+}
+
+// writeUnitFile writes a unit or drop-in, applying mode to existing files too
+func writeUnitFile(ctx context.Context, path, content string, mode os.FileMode) error {
+	start := time.Now()
+	err := os.WriteFile(path, []byte(content), mode)
+	audit.Log(ctx, audit.CategorySystemd, "write-unit", "write "+path, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	audit.WroteFile(ctx, audit.CategorySystemd, path, []byte(content), mode)
+	return nil
+}
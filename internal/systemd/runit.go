@@ -0,0 +1,249 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+)
+
+// runitSvDir holds the service directories of runit units, which are linked
+// into the directory runsvdir watches
+const runitSvDir = "/etc/sv"
+
+// runitSuperviseWait is how long register waits for runsvdir to pick up a
+// newly linked service, which it looks for every five seconds
+const runitSuperviseWait = 10 * time.Second
+
+// runit runs units as runit services. Each service directory has a run
+// script, a finish script applying the unit's Restart, and an svlogd logger.
+// Units are linked into runsvdir's directory as soon as they are installed,
+// with a down file keeping them stopped at boot until enabled.
+type runit struct {
+	serviceDir string // the directory runsvdir watches
+}
+
+// newRunit finds the directory runsvdir watches: $SVDIR, /var/service on Void,
+// or /etc/service elsewhere
+func newRunit() runit {
+	if dir := os.Getenv("SVDIR"); dir != "" {
+		return runit{serviceDir: dir}
+	}
+	if _, err := os.Stat("/var/service"); err == nil {
+		return runit{serviceDir: "/var/service"}
+	}
+	return runit{serviceDir: "/etc/service"}
+}
+
+func (runit) name() string {
+	return InitRunit
+}
+
+func (runit) scriptPath(serviceName string) string {
+	return filepath.Join(runitSvDir, instanceBase(serviceName))
+}
+
+// linkPath is where runsvdir finds the unit
+func (r runit) linkPath(serviceName string) string {
+	return filepath.Join(r.serviceDir, instanceBase(serviceName))
+}
+
+func (r runit) scripts(serviceName string, spec execSpec) []initFile {
+	dir := r.scriptPath(serviceName)
+	run := "#!/bin/sh\n# Managed by Servio\n"
+	if spec.command == "" {
+		// A scaled service's unit only stays up; InitManager starts and stops its instances
+		run += "exec tail -f /dev/null\n"
+	} else {
+		run += "exec 2>&1\n" + spec.exports()
+		command := "/bin/sh -c " + shellQuote(spec.shellCommand())
+		if user := spec.runsAs(); user != "" {
+			command = "chpst -u " + shellQuote(user) + " " + command
+		}
+		run += "exec " + command + "\n"
+	}
+
+	// finish runs when run exits: with its exit code, or -1 when a signal
+	// such as a stop ended it. Writing d to the control pipe keeps it down.
+	finish := "#!/bin/sh\n# Managed by Servio\n"
+	delay := strconv.FormatFloat(spec.restartSec.Seconds(), 'f', -1, 64)
+	switch spec.restart {
+	case "no":
+		finish += "printf d > supervise/control\n"
+	case "always":
+		finish += "[ \"$1\" = -1 ] || sleep " + delay + "\n"
+	default:
+		finish += "[ \"$1\" = 0 ] && printf d > supervise/control\n[ \"$1\" = -1 ] || sleep " + delay + "\n"
+	}
+
+	logDir := filepath.Dir(r.logPath(serviceName))
+	logRun := fmt.Sprintf("#!/bin/sh\n# Managed by Servio\nmkdir -p %s\nexec svlogd -tt %s\n", shellQuote(logDir), shellQuote(logDir))
+
+	return []initFile{
+		{path: dir, mode: 0755, dir: true},
+		{path: filepath.Join(dir, "log"), mode: 0755, dir: true},
+		{path: filepath.Join(dir, "run"), content: run, mode: 0755},
+		{path: filepath.Join(dir, "finish"), content: finish, mode: 0755},
+		{path: filepath.Join(dir, "log", "run"), content: logRun, mode: 0755},
+	}
+}
+
+// register marks a new unit down, so it does not start at boot until
+// enabled, links it for runsvdir, and waits for its supervisor
+func (r runit) register(ctx context.Context, serviceName string) error {
+	down := filepath.Join(r.scriptPath(serviceName), "down")
+	link := r.linkPath(serviceName)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Write(down, "", 0644)
+		plan.Run([]string{"ln", "-s", r.scriptPath(serviceName), link})
+		return nil
+	}
+	if err := os.WriteFile(down, nil, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", down, err)
+	}
+	start := time.Now()
+	err := os.Symlink(r.scriptPath(serviceName), link)
+	audit.Log(ctx, audit.CategorySystemd, "register", "ln -s "+r.scriptPath(serviceName)+" "+link, "", err, time.Since(start))
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to link %s: %w", link, err)
+	}
+
+	ok := filepath.Join(link, "supervise", "ok")
+	for deadline := time.Now().Add(runitSuperviseWait); time.Now().Before(deadline); {
+		if _, err := os.Stat(ok); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("%w: runsvdir did not pick up %s within %s", ErrCommandFailed, link, runitSuperviseWait)
+}
+
+// unregister unlinks a unit, which stops its supervisor
+func (r runit) unregister(ctx context.Context, serviceName string) error {
+	link := r.linkPath(serviceName)
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Remove(link)
+		return nil
+	}
+	start := time.Now()
+	err := os.Remove(link)
+	audit.Log(ctx, audit.CategorySystemd, "unregister", "remove "+link, "", err, time.Since(start))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", link, err)
+	}
+	return nil
+}
+
+// control runs sv; up and down are its words for start and stop
+func (r runit) control(ctx context.Context, action, serviceName string) error {
+	command := map[string]string{"start": "up", "stop": "down", "restart": "restart"}[action]
+	return initCommand(ctx, action, "sv", command, r.linkPath(serviceName))
+}
+
+// setEnabled removes or writes the unit's down file, which runsv reads when
+// it starts, at boot
+func (r runit) setEnabled(ctx context.Context, serviceName string, enabled bool) error {
+	down := filepath.Join(r.scriptPath(serviceName), "down")
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		if enabled {
+			plan.Remove(down)
+		} else {
+			plan.Write(down, "", 0644)
+		}
+		return nil
+	}
+	var err error
+	action := "enable"
+	start := time.Now()
+	if enabled {
+		if err = os.Remove(down); os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		action = "disable"
+		err = os.WriteFile(down, nil, 0644)
+	}
+	audit.Log(ctx, audit.CategorySystemd, action, action+" "+r.scriptPath(serviceName), "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", action, serviceName, err)
+	}
+	return nil
+}
+
+func (r runit) enabled(serviceName string) bool {
+	if _, err := os.Lstat(r.linkPath(serviceName)); err != nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(r.scriptPath(serviceName), "down"))
+	return os.IsNotExist(err)
+}
+
+// status reads sv status, e.g. "run: /var/service/x: (pid 12) 30s; run: log: ...",
+// "down: /var/service/x: 5s", or "fail: /var/service/x: unable to change to service directory"
+func (r runit) status(ctx context.Context, serviceName string) (string, string) {
+	output, _ := exec.CommandContext(ctx, "sv", "status", r.linkPath(serviceName)).CombinedOutput()
+	switch state, _, _ := strings.Cut(string(output), ":"); state {
+	case "run", "finish":
+		return "active", string(output)
+	case "fail":
+		return "failed", string(output)
+	}
+	return "inactive", string(output)
+}
+
+// startedAt works out when a running unit started from how long sv status
+// says it has been up
+func (r runit) startedAt(ctx context.Context, serviceName string) time.Time {
+	output, err := exec.CommandContext(ctx, "sv", "status", r.linkPath(serviceName)).Output()
+	if err != nil || !strings.HasPrefix(string(output), "run:") {
+		return time.Time{}
+	}
+	main, _, _ := strings.Cut(string(output), ";")
+	_, uptime, ok := strings.Cut(main, ") ")
+	if !ok {
+		return time.Time{}
+	}
+	seconds, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(uptime), "s"))
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(seconds) * time.Second)
+}
+
+// logPath is svlogd's current file in the unit's log directory
+func (runit) logPath(serviceName string) string {
+	return filepath.Join(LogFileDir, instanceBase(serviceName), "current")
+}
+
+// entry reads the timestamp svlogd -tt puts in front of each line, e.g.
+// 2024-05-01_12:30:00.12345
+func (runit) entry(line string) JournalEntry {
+	stamp, message, ok := strings.Cut(line, " ")
+	if ok {
+		if t, err := time.Parse("2006-01-02_15:04:05.999999999", stamp); err == nil {
+			return JournalEntry{Time: t.Local(), Priority: NoPriority, Message: message}
+		}
+	}
+	return JournalEntry{Priority: NoPriority, Message: line}
+}
+
+func (r runit) ping(ctx context.Context) error {
+	if _, err := os.Stat(r.serviceDir); err != nil {
+		return fmt.Errorf("%w: runsvdir's directory: %w", ErrCommandFailed, err)
+	}
+	if _, err := exec.LookPath("sv"); err != nil {
+		return fmt.Errorf("%w: %w", ErrCommandFailed, err)
+	}
+	return nil
+}
@@ -3,19 +3,32 @@ package systemd
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
 
+	"servio/internal/audit"
 	"servio/internal/storage"
 )
 
+// ErrCommandFailed is wrapped by errors from systemctl invocations that exit non-zero
+var ErrCommandFailed = errors.New("systemctl command failed")
+
 // BlueprintProvider is an interface for getting blueprints
 type BlueprintProvider interface {
 	Get(serviceType string) (interface{}, bool)
 	IsManaged(serviceType string) bool
 }
 
+// SecretResolver substitutes ${secret:KEY} references, and references to
+// external stores such as ${vault:kv/app#key}, with their values.
+// The boolean result reports whether any reference was substituted.
+type SecretResolver interface {
+	Resolve(ctx context.Context, service *storage.Service, content string) (string, bool, error)
+}
+
 // ServiceManager defines the interface for managing system services
 type ServiceManager interface {
 	Start(ctx context.Context, serviceName string) error
@@ -24,19 +37,34 @@ type ServiceManager interface {
 	Enable(ctx context.Context, serviceName string) error
 	Disable(ctx context.Context, serviceName string) error
 	Status(ctx context.Context, serviceName string) (ServiceStatus, error)
+	ActiveState(ctx context.Context, serviceName string) string
 	Reload(ctx context.Context) error
 	GetStartTime(ctx context.Context, serviceName string) (string, error)
 	GetLogsWithTimeRange(ctx context.Context, serviceName, since, until string) (string, error)
-	StreamLogs(ctx context.Context, serviceName string) (<-chan string, error)
+	GetLogEntries(ctx context.Context, serviceName, since string, lines int) ([]JournalEntry, error)
+	StreamLogs(ctx context.Context, serviceName, cursor string) (<-chan JournalEntry, error)
+	StreamUnitLogs(ctx context.Context, serviceNames []string, lines int, after time.Time) (<-chan LogLine, error)
 	GenerateServiceFile(service *storage.Service) (string, error)
 	InstallService(ctx context.Context, service *storage.Service) error
 	UninstallService(ctx context.Context, serviceName string) error
 	ServiceExists(serviceName string) bool
+	Ping(ctx context.Context) error
+}
+
+// ServiceRuntime is a ServiceManager for the services of one runtime:
+// Manager runs them as systemd units and internal/container as Docker or
+// Podman containers. container.Router picks each service's by Service.Runtime.
+type ServiceRuntime interface {
+	ServiceManager
+	// Name is the storage.Runtime* value the runtime is selected by
+	Name() string
 }
 
 // Manager provides systemd service management and implements ServiceManager
 type Manager struct {
 	blueprints BlueprintProvider
+	secrets    SecretResolver
+	units      *UnitWatcher
 }
 
 // NewManager creates a new systemd Manager
@@ -44,11 +72,27 @@ func NewManager() *Manager {
 	return &Manager{}
 }
 
+// Name returns storage.RuntimeSystemd
+func (m *Manager) Name() string {
+	return storage.RuntimeSystemd
+}
+
 // SetBlueprints sets the blueprint registry for the manager
 func (m *Manager) SetBlueprints(blueprints BlueprintProvider) {
 	m.blueprints = blueprints
 }
 
+// SetSecretResolver sets the resolver used to inject secrets into installed unit files
+func (m *Manager) SetSecretResolver(secrets SecretResolver) {
+	m.secrets = secrets
+}
+
+// SetUnitWatcher makes the manager read active and enabled states from
+// units, falling back to systemctl whenever the watcher cannot tell
+func (m *Manager) SetUnitWatcher(units *UnitWatcher) {
+	m.units = units
+}
+
 // Start starts a systemd service
 func (m *Manager) Start(ctx context.Context, serviceName string) error {
 	return m.runSystemctl(ctx, "start", serviceName)
@@ -66,12 +110,20 @@ func (m *Manager) Restart(ctx context.Context, serviceName string) error {
 
 // Enable enables a systemd service to start on boot
 func (m *Manager) Enable(ctx context.Context, serviceName string) error {
-	return m.runSystemctl(ctx, "enable", serviceName)
+	if err := m.runSystemctl(ctx, "enable", serviceName); err != nil {
+		return err
+	}
+	audit.UnitEnabled(ctx, serviceName, true)
+	return nil
 }
 
 // Disable disables a systemd service from starting on boot
 func (m *Manager) Disable(ctx context.Context, serviceName string) error {
-	return m.runSystemctl(ctx, "disable", serviceName)
+	if err := m.runSystemctl(ctx, "disable", serviceName); err != nil {
+		return err
+	}
+	audit.UnitEnabled(ctx, serviceName, false)
+	return nil
 }
 
 // Status returns the status of a systemd service
@@ -80,15 +132,8 @@ func (m *Manager) Status(ctx context.Context, serviceName string) (ServiceStatus
 		Name: serviceName,
 	}
 
-	// Check if active
-	activeCmd := exec.CommandContext(ctx, "systemctl", "is-active", serviceName)
-	activeOut, _ := activeCmd.Output()
-	status.Active = strings.TrimSpace(string(activeOut)) == "active"
-
-	// Check if enabled
-	enabledCmd := exec.CommandContext(ctx, "systemctl", "is-enabled", serviceName)
-	enabledOut, _ := enabledCmd.Output()
-	status.Enabled = strings.TrimSpace(string(enabledOut)) == "enabled"
+	status.Active = m.ActiveState(ctx, serviceName) == "active"
+	status.Enabled = m.unitFileState(ctx, serviceName) == "enabled"
 
 	// Get full status
 	statusCmd := exec.CommandContext(ctx, "systemctl", "status", serviceName, "--no-pager")
@@ -102,11 +147,45 @@ func (m *Manager) Status(ctx context.Context, serviceName string) (ServiceStatus
 	return status, nil
 }
 
+// ActiveState returns the unit's active state as reported by systemctl
+// is-active (e.g. "active", "inactive", "failed"), or "" if it is unknown
+func (m *Manager) ActiveState(ctx context.Context, serviceName string) string {
+	if m.units != nil {
+		if state, ok := m.units.ActiveState(ctx, serviceName); ok {
+			return state
+		}
+	}
+	out, _ := exec.CommandContext(ctx, "systemctl", "is-active", serviceName).Output()
+	return strings.TrimSpace(string(out))
+}
+
+// unitFileState returns the unit's enablement as reported by systemctl
+// is-enabled (e.g. "enabled", "disabled", "static")
+func (m *Manager) unitFileState(ctx context.Context, serviceName string) string {
+	if m.units != nil {
+		if state, ok := m.units.UnitFileState(ctx, serviceName); ok {
+			return state
+		}
+	}
+	out, _ := exec.CommandContext(ctx, "systemctl", "is-enabled", serviceName).Output()
+	return strings.TrimSpace(string(out))
+}
+
+// Ping checks that systemctl can reach the systemd manager. It is not audited
+// because it changes nothing and runs on every readiness probe.
+func (m *Manager) Ping(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "systemctl", "show", "--property=Version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: show: %s - %w", ErrCommandFailed, strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
 // Reload reloads the systemd daemon
 func (m *Manager) Reload(ctx context.Context) error {
 	cmd := exec.CommandContext(ctx, "systemctl", "daemon-reload")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("daemon-reload failed: %s - %w", string(output), err)
+	if output, err := audit.Run(ctx, audit.CategorySystemd, "daemon-reload", cmd); err != nil {
+		return fmt.Errorf("%w: daemon-reload: %s - %w", ErrCommandFailed, string(output), err)
 	}
 	return nil
 }
@@ -114,8 +193,8 @@ func (m *Manager) Reload(ctx context.Context) error {
 // runSystemctl executes a systemctl command
 func (m *Manager) runSystemctl(ctx context.Context, action, serviceName string) error {
 	cmd := exec.CommandContext(ctx, "systemctl", action, serviceName)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("systemctl %s %s failed: %s - %w", action, serviceName, string(output), err)
+	if output, err := audit.Run(ctx, audit.CategorySystemd, action, cmd); err != nil {
+		return fmt.Errorf("%w: %s %s: %s - %w", ErrCommandFailed, action, serviceName, string(output), err)
 	}
 	return nil
 }
@@ -0,0 +1,235 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// sliceDropIn is the drop-in that places a unit in its project's slice
+const sliceDropIn = "servio-slice.conf"
+
+var (
+	memoryMaxPattern = regexp.MustCompile(`^([0-9]+[KMGT]?|[0-9]{1,3}%)$`)
+	cpuQuotaPattern  = regexp.MustCompile(`^[0-9]+%$`)
+)
+
+// ErrInvalidBudget is wrapped by errors for malformed memory and CPU limits
+var ErrInvalidBudget = errors.New("invalid project budget")
+
+// Budget caps what the units of a project's slice may use together. MemoryMax
+// is a size (512M, 2G) or a share of the host's memory (25%); CPUQuota is CPU
+// time relative to one CPU, so 150% allows one and a half CPUs.
+type Budget struct {
+	MemoryMax string
+	CPUQuota  string
+}
+
+// Validate checks that at least one limit is set and both are well formed
+func (b Budget) Validate() error {
+	if b.MemoryMax == "" && b.CPUQuota == "" {
+		return fmt.Errorf("%w: set memory_max, cpu_quota, or both", ErrInvalidBudget)
+	}
+	if b.MemoryMax != "" && !memoryMaxPattern.MatchString(b.MemoryMax) {
+		return fmt.Errorf("%w: memory_max %q must be a size such as 512M or 2G, or a percentage such as 25%%", ErrInvalidBudget, b.MemoryMax)
+	}
+	if b.MemoryMax != "" && strings.HasSuffix(b.MemoryMax, "%") {
+		if n, _ := strconv.Atoi(strings.TrimSuffix(b.MemoryMax, "%")); n < 1 || n > 100 {
+			return fmt.Errorf("%w: memory_max %q must be between 1%% and 100%%", ErrInvalidBudget, b.MemoryMax)
+		}
+	}
+	if b.CPUQuota != "" {
+		n, _ := strconv.Atoi(strings.TrimSuffix(b.CPUQuota, "%"))
+		if !cpuQuotaPattern.MatchString(b.CPUQuota) || n < 1 {
+			return fmt.Errorf("%w: cpu_quota %q must be a percentage of one CPU such as 50%% or 200%%", ErrInvalidBudget, b.CPUQuota)
+		}
+	}
+	return nil
+}
+
+// ProjectSlice is the slice a project's units run in once it has a budget.
+// The dash makes it a child of servio.slice.
+func ProjectSlice(projectID int64) string {
+	return fmt.Sprintf("servio-project%d.slice", projectID)
+}
+
+// hasSlice reports whether a project's slice unit is installed
+func hasSlice(projectID int64) bool {
+	_, err := os.Stat(filepath.Join(ServiceDir, ProjectSlice(projectID)))
+	return err == nil
+}
+
+// sliceDropIns returns the drop-ins placing a service's units in a slice: its
+// own unit's and, when it is scaled, its template's, which its instances share
+func sliceDropIns(serviceName string) []string {
+	paths := []string{filepath.Join(ServiceDir, serviceName+".d", sliceDropIn)}
+	if isScaled(serviceName) {
+		paths = append(paths, filepath.Join(ServiceDir, templateName(serviceName)+".d", sliceDropIn))
+	}
+	return paths
+}
+
+// SetProjectBudget writes a project's slice with budget b and places the
+// given units in it. Running units move into the slice when they next
+// restart; the limits of a slice they are already in apply at once.
+func SetProjectBudget(ctx context.Context, projectID int64, b Budget, serviceNames []string) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	slicePath := filepath.Join(ServiceDir, ProjectSlice(projectID))
+	var conf strings.Builder
+	fmt.Fprintf(&conf, "# Managed by Servio\n[Unit]\nDescription=Servio project %d\n\n[Slice]\n", projectID)
+	if b.MemoryMax != "" {
+		fmt.Fprintf(&conf, "MemoryMax=%s\n", b.MemoryMax)
+	}
+	if b.CPUQuota != "" {
+		fmt.Fprintf(&conf, "CPUQuota=%s\n", b.CPUQuota)
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Write(slicePath, conf.String(), 0644)
+	} else if err := writeUnitFile(ctx, slicePath, conf.String(), 0644); err != nil {
+		return err
+	}
+	for _, name := range serviceNames {
+		if err := joinSlice(ctx, name, projectID); err != nil {
+			return err
+		}
+	}
+	return reloadDaemon(ctx)
+}
+
+// RemoveProjectBudget removes a project's slice and takes the given units
+// out of it. Running units leave it when they next restart; until then the
+// slice stays, without limits.
+func RemoveProjectBudget(ctx context.Context, projectID int64, serviceNames []string) error {
+	for _, name := range serviceNames {
+		if err := leaveSlice(ctx, name); err != nil {
+			return err
+		}
+	}
+	if err := removeManagedFile(ctx, filepath.Join(ServiceDir, ProjectSlice(projectID))); err != nil {
+		return err
+	}
+	return reloadDaemon(ctx)
+}
+
+// joinSlice writes the drop-ins placing a service's units in its project's
+// slice; it does not reload systemd
+func joinSlice(ctx context.Context, serviceName string, projectID int64) error {
+	dropIn := "# Managed by Servio\n[Service]\nSlice=" + ProjectSlice(projectID) + "\n"
+	plan := dryrun.FromContext(ctx)
+	for _, path := range sliceDropIns(serviceName) {
+		if plan != nil {
+			plan.Mkdir(filepath.Dir(path), 0755)
+			plan.Write(path, dropIn, 0644)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create drop-in directory: %w", err)
+		}
+		if err := writeUnitFile(ctx, path, dropIn, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leaveSlice removes the drop-ins placing a service's units in a slice; it
+// does not reload systemd
+func leaveSlice(ctx context.Context, serviceName string) error {
+	for _, path := range sliceDropIns(serviceName) {
+		if err := removeManagedFile(ctx, path); err != nil {
+			return err
+		}
+		if dryrun.FromContext(ctx) == nil {
+			// Only removes the drop-in directory if nothing else is in it
+			os.Remove(filepath.Dir(path))
+		}
+	}
+	return nil
+}
+
+// removeManagedFile removes a unit or drop-in Servio wrote, if it exists,
+// and records it in the audit trail
+func removeManagedFile(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Remove(path)
+		return nil
+	}
+	start := time.Now()
+	err := os.Remove(path)
+	audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+path, "", err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	audit.RemovedFile(ctx, path)
+	return nil
+}
+
+// UnitSlice returns the slice a unit runs in now, or "" when systemd does
+// not know it
+func UnitSlice(ctx context.Context, serviceName string) string {
+	out, err := exec.CommandContext(ctx, "systemctl", "show", "-p", "Slice", "--value", serviceName).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// SliceUsage is what a project's slice uses now
+type SliceUsage struct {
+	MemoryBytes uint64 `json:"memory_bytes"`
+	CPUNanos    uint64 `json:"cpu_usage_nsec"` // CPU time used since the slice started
+}
+
+// ProjectSliceUsage reads the memory and CPU time a project's slice uses.
+// It returns nil when the slice is not running.
+func ProjectSliceUsage(ctx context.Context, projectID int64) *SliceUsage {
+	out, err := exec.CommandContext(ctx, "systemctl", "show", "-p", "ActiveState,MemoryCurrent,CPUUsageNSec", ProjectSlice(projectID)).Output()
+	if err != nil {
+		return nil
+	}
+	props := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			props[k] = strings.TrimSpace(v)
+		}
+	}
+	if props["ActiveState"] != "active" {
+		return nil
+	}
+	usage := &SliceUsage{}
+	// Unavailable figures read as [not set] or the maximum uint64; both stay 0
+	if v, err := strconv.ParseUint(props["MemoryCurrent"], 10, 64); err == nil && v != 1<<64-1 {
+		usage.MemoryBytes = v
+	}
+	if v, err := strconv.ParseUint(props["CPUUsageNSec"], 10, 64); err == nil && v != 1<<64-1 {
+		usage.CPUNanos = v
+	}
+	return usage
+}
+
+// placeInSlice places a service being installed in its project's slice when
+// the project has one, and takes it out when the project no longer does
+func placeInSlice(ctx context.Context, service *storage.Service) error {
+	if hasSlice(service.ProjectID) {
+		return joinSlice(ctx, service.ServiceName(), service.ProjectID)
+	}
+	return leaveSlice(ctx, service.ServiceName())
+}
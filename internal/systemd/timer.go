@@ -0,0 +1,202 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+	"servio/internal/storage"
+)
+
+// unitEscaper escapes a command for a double-quoted ExecStart argument, so
+// systemd passes it to the shell unchanged
+var unitEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$")
+
+// GenerateCronUnits creates the oneshot service that runs a cron job's
+// command through /bin/sh, as cron does, and the timer that starts it on each
+// of the calendar expressions
+func GenerateCronUnits(job *storage.CronJob, calendars []string) (service, timer string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by Servio - cron job %s of project %d\n", job.Name, job.ProjectID)
+	fmt.Fprintf(&b, "[Unit]\nDescription=Servio cron job: %s\n\n[Service]\nType=oneshot\n", job.Name)
+	user := job.User
+	if user == "" {
+		user = "root"
+	}
+	fmt.Fprintf(&b, "User=%s\n", user)
+	if job.WorkingDir != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", job.WorkingDir)
+	}
+	for _, line := range strings.Split(job.Environment, "\n") {
+		if line = strings.TrimSpace(line); line != "" && strings.Contains(line, "=") {
+			fmt.Fprintf(&b, "Environment=\"%s\"\n", line)
+		}
+	}
+	fmt.Fprintf(&b, "ExecStart=/bin/sh -c \"%s\"\n", unitEscaper.Replace(job.Command))
+	if job.Timeout > 0 {
+		fmt.Fprintf(&b, "TimeoutStartSec=%d\n", job.Timeout)
+	} else {
+		b.WriteString("TimeoutStartSec=infinity\n")
+	}
+	service = b.String()
+
+	b.Reset()
+	fmt.Fprintf(&b, "# Managed by Servio - schedule %q of cron job %s\n", job.Schedule, job.Name)
+	fmt.Fprintf(&b, "[Unit]\nDescription=Schedule of Servio cron job: %s\n\n[Timer]\n", job.Name)
+	for _, calendar := range calendars {
+		fmt.Fprintf(&b, "OnCalendar=%s\n", calendar)
+	}
+	// Timers fire up to a minute late by default; cron runs on the minute
+	fmt.Fprintf(&b, "AccuracySec=1s\nUnit=%s.service\n\n[Install]\nWantedBy=timers.target\n", job.UnitName())
+	timer = b.String()
+	return service, timer
+}
+
+// InstallTimer writes a cron job's units, reloads systemd, and enables and
+// starts the timer, or disables and stops it when the job is disabled. A
+// private service holds resolved secrets and is only readable by root.
+func InstallTimer(ctx context.Context, name, service, timer string, private, enabled bool) error {
+	serviceMode := os.FileMode(0644)
+	if private {
+		serviceMode = 0600
+	}
+	files := []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{filepath.Join(ServiceDir, name+".service"), service, serviceMode},
+		{filepath.Join(ServiceDir, name+".timer"), timer, 0644},
+	}
+
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		for _, f := range files {
+			plan.Write(f.path, f.content, f.mode)
+		}
+	} else {
+		for _, f := range files {
+			start := time.Now()
+			err := os.WriteFile(f.path, []byte(f.content), f.mode)
+			if err == nil {
+				// WriteFile keeps the mode of an existing file
+				err = os.Chmod(f.path, f.mode)
+			}
+			audit.Log(ctx, audit.CategorySystemd, "write-unit", "write "+f.path, "", err, time.Since(start))
+			if err != nil {
+				return fmt.Errorf("failed to write %s: %w", f.path, err)
+			}
+			audit.WroteFile(ctx, audit.CategorySystemd, f.path, []byte(f.content), f.mode)
+		}
+	}
+	if err := reloadDaemon(ctx); err != nil {
+		return err
+	}
+
+	action := "enable"
+	if !enabled {
+		action = "disable"
+	}
+	return runTimerCommand(ctx, action, "--now", name+".timer")
+}
+
+// RemoveTimer stops and removes a cron job's units, stopping a run in
+// progress. The files are removed even when systemctl fails, so a job that
+// failed to install leaves nothing behind. A job whose units were never
+// installed is left alone.
+func RemoveTimer(ctx context.Context, name string) error {
+	timerPath := filepath.Join(ServiceDir, name+".timer")
+	if _, err := os.Stat(timerPath); err != nil {
+		return nil
+	}
+	stopErr := runTimerCommand(ctx, "disable", "--now", name+".timer")
+	if stopErr == nil {
+		stopErr = runTimerCommand(ctx, "stop", name+".service")
+	}
+
+	paths := []string{timerPath, filepath.Join(ServiceDir, name+".service")}
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		for _, path := range paths {
+			plan.Remove(path)
+		}
+		return reloadDaemon(ctx)
+	}
+	for _, path := range paths {
+		start := time.Now()
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			audit.RemovedFile(ctx, path)
+			continue
+		}
+		audit.Log(ctx, audit.CategorySystemd, "remove-unit", "remove "+path, "", err, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		audit.RemovedFile(ctx, path)
+	}
+	if stopErr != nil {
+		return stopErr
+	}
+	return reloadDaemon(ctx)
+}
+
+// StartTimerJob runs a cron job now, without waiting for it to finish. A job
+// that is already running is left alone.
+func StartTimerJob(ctx context.Context, name string) error {
+	return runTimerCommand(ctx, "start", "--no-block", name+".service")
+}
+
+// runTimerCommand runs a systemctl command on a cron job's units
+func runTimerCommand(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "systemctl", args...)
+	if output, err := audit.Run(ctx, audit.CategorySystemd, args[0], cmd); err != nil {
+		return fmt.Errorf("%w: %s: %s - %w", ErrCommandFailed, strings.Join(args, " "), strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// LastTimerRun reads how a cron job's latest run went. It returns nil when
+// the job has not run since systemd started, or is running now.
+func LastTimerRun(ctx context.Context, name string) (*storage.CronRun, error) {
+	cmd := exec.CommandContext(ctx, "systemctl", "show", name+".service",
+		"-p", "ActiveState", "-p", "Result", "-p", "ExecMainStatus", "-p", "ExecMainStartTimestamp",
+		"-p", "ExecMainStartTimestampMonotonic", "-p", "ExecMainExitTimestampMonotonic")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: show %s: %w", ErrCommandFailed, name, err)
+	}
+	props := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			props[key] = value
+		}
+	}
+
+	startMono, _ := strconv.ParseInt(props["ExecMainStartTimestampMonotonic"], 10, 64)
+	exitMono, _ := strconv.ParseInt(props["ExecMainExitTimestampMonotonic"], 10, 64)
+	if startMono == 0 || exitMono < startMono || props["ActiveState"] == "activating" {
+		return nil, nil
+	}
+	startedAt, err := time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", props["ExecMainStartTimestamp"], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time of %s: %w", name, err)
+	}
+	run := &storage.CronRun{
+		StartedAt: startedAt,
+		Status:    storage.CronSucceeded,
+		Duration:  time.Duration(exitMono-startMono) * time.Microsecond,
+	}
+	run.ExitCode, _ = strconv.Atoi(props["ExecMainStatus"])
+	if props["Result"] != "success" {
+		run.Status = storage.CronFailed
+	}
+	return run, nil
+}
@@ -0,0 +1,287 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/dbus"
+)
+
+const (
+	systemdBusName   = "org.freedesktop.systemd1"
+	systemdPath      = "/org/freedesktop/systemd1"
+	managerInterface = "org.freedesktop.systemd1.Manager"
+	unitInterface    = "org.freedesktop.systemd1.Unit"
+	unitPathPrefix   = systemdPath + "/unit/"
+
+	// unitCallTimeout bounds one D-Bus call made to answer a state query
+	unitCallTimeout = 5 * time.Second
+	// maxReconnectDelay caps the wait between attempts to reach the bus
+	maxReconnectDelay = time.Minute
+)
+
+// UnitWatcher follows the active state of units over D-Bus: it subscribes
+// to systemd's signals and keeps the state of every unit it was asked about
+// current from PropertiesChanged, so reading a state needs no systemctl call.
+// Until it is connected, and whenever the bus goes away, it reports nothing
+// and callers fall back to systemctl.
+type UnitWatcher struct {
+	prefix string // units whose changes are passed to the handlers
+
+	mu       sync.RWMutex
+	conn     *dbus.Conn        // nil while disconnected
+	states   map[string]string // unit → ActiveState
+	handlers []func(unit, state string)
+}
+
+// NewUnitWatcher creates a watcher passing changes of units whose names
+// start with prefix to its handlers
+func NewUnitWatcher(prefix string) *UnitWatcher {
+	return &UnitWatcher{prefix: prefix, states: make(map[string]string)}
+}
+
+// OnChange registers fn to be called with a unit's new active state when it
+// changes. Handlers run on the watcher's goroutine and must not block.
+func (w *UnitWatcher) OnChange(fn func(unit, state string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, fn)
+}
+
+// Connected reports whether the watcher is following systemd
+func (w *UnitWatcher) Connected() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.conn != nil
+}
+
+// Run follows systemd until ctx is cancelled, reconnecting with backoff
+// when the bus is unavailable or drops the connection
+func (w *UnitWatcher) Run(ctx context.Context) {
+	delay := time.Second
+	lastErr := ""
+	for {
+		err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && err.Error() != lastErr {
+			slog.WarnContext(ctx, "Unit state subscription unavailable; polling systemctl", "error", err)
+			lastErr = err.Error()
+		}
+		if err == nil {
+			delay, lastErr = time.Second, ""
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// watch connects, subscribes, and handles signals until the connection ends.
+// It returns nil when a working subscription was lost.
+func (w *UnitWatcher) watch(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, unitCallTimeout)
+	defer cancel()
+	conn, err := dbus.SystemBus(dialCtx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	matches := []string{
+		"type='signal',sender='" + systemdBusName + "',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path_namespace='" + systemdPath + "/unit'",
+		"type='signal',sender='" + systemdBusName + "',interface='" + managerInterface + "',member='UnitNew'",
+		"type='signal',sender='" + systemdBusName + "',interface='" + managerInterface + "',member='UnitRemoved'",
+	}
+	for _, rule := range matches {
+		if _, err := conn.Call(dialCtx, "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "s", rule); err != nil {
+			return err
+		}
+	}
+	// systemd only sends unit signals while a client is subscribed
+	if _, err := conn.Call(dialCtx, systemdBusName, systemdPath, managerInterface, "Subscribe", ""); err != nil {
+		return err
+	}
+
+	w.setConn(conn)
+	defer w.setConn(nil)
+	slog.InfoContext(ctx, "Following unit states over D-Bus")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m, ok := <-conn.Messages():
+			if !ok {
+				slog.WarnContext(ctx, "Lost the D-Bus connection; polling systemctl until it is back")
+				return nil
+			}
+			w.handle(m)
+		}
+	}
+}
+
+// setConn switches the connection queries use; states learned over the old
+// one are dropped, since changes may have been missed in between
+func (w *UnitWatcher) setConn(conn *dbus.Conn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.conn = conn
+	w.states = make(map[string]string)
+}
+
+// handle applies a signal from systemd
+func (w *UnitWatcher) handle(m *dbus.Message) {
+	if m.Type != dbus.TypeSignal {
+		return
+	}
+	switch m.Member {
+	case "PropertiesChanged":
+		if len(m.Body) < 3 || m.Body[0] != unitInterface || !strings.HasPrefix(m.Path, unitPathPrefix) {
+			return
+		}
+		unit := unitNameFromPath(m.Path)
+		changed, _ := m.Body[1].(map[interface{}]interface{})
+		if v, ok := changed["ActiveState"].(dbus.Variant); ok {
+			if state, ok := v.Value.(string); ok {
+				w.update(unit, state)
+			}
+			return
+		}
+		invalidated, _ := m.Body[2].([]interface{})
+		for _, name := range invalidated {
+			if name == "ActiveState" {
+				w.forget(unit)
+			}
+		}
+	case "UnitNew", "UnitRemoved":
+		// Loading or unloading a unit changes what reading its state means;
+		// ask systemd again next time
+		if len(m.Body) > 0 {
+			if unit, ok := m.Body[0].(string); ok {
+				w.forget(unit)
+			}
+		}
+	}
+}
+
+// update records a unit's state and tells the handlers when it changed
+func (w *UnitWatcher) update(unit, state string) {
+	w.mu.Lock()
+	prev, seen := w.states[unit]
+	w.states[unit] = state
+	handlers := w.handlers
+	w.mu.Unlock()
+
+	if (!seen || prev != state) && strings.HasPrefix(unit, w.prefix) {
+		for _, fn := range handlers {
+			fn(unit, state)
+		}
+	}
+}
+
+func (w *UnitWatcher) forget(unit string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.states, unit)
+}
+
+// ActiveState returns a unit's active state, as systemctl is-active would,
+// and whether the watcher could tell. States it has not followed yet are
+// asked of systemd over D-Bus and followed from then on.
+func (w *UnitWatcher) ActiveState(ctx context.Context, unit string) (string, bool) {
+	w.mu.RLock()
+	conn := w.conn
+	state, ok := w.states[unit]
+	w.mu.RUnlock()
+	if conn == nil {
+		return "", false
+	}
+	if ok {
+		return state, true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, unitCallTimeout)
+	defer cancel()
+	reply, err := conn.Call(ctx, systemdBusName, systemdPath, managerInterface, "GetUnit", "s", unit)
+	var busErr *dbus.Error
+	switch {
+	case errors.As(err, &busErr) && busErr.Name == "org.freedesktop.systemd1.NoSuchUnit":
+		// Not loaded: is-active reports inactive. Starting it loads it,
+		// and its PropertiesChanged then records the new state.
+		state = "inactive"
+	case err != nil:
+		return "", false
+	default:
+		path, _ := reply.Body[0].(string)
+		prop, err := conn.Call(ctx, systemdBusName, path, "org.freedesktop.DBus.Properties", "Get", "ss", unitInterface, "ActiveState")
+		if err != nil {
+			return "", false
+		}
+		v, _ := prop.Body[0].(dbus.Variant)
+		if state, ok = v.Value.(string); !ok {
+			return "", false
+		}
+	}
+
+	w.mu.Lock()
+	if w.conn == conn {
+		if _, seen := w.states[unit]; !seen {
+			w.states[unit] = state
+		}
+		state = w.states[unit]
+	}
+	w.mu.Unlock()
+	return state, true
+}
+
+// UnitFileState returns whether a unit is enabled, as systemctl is-enabled
+// would, and whether the watcher could tell. systemd does not signal
+// changes to it, so every call asks over D-Bus.
+func (w *UnitWatcher) UnitFileState(ctx context.Context, unit string) (string, bool) {
+	w.mu.RLock()
+	conn := w.conn
+	w.mu.RUnlock()
+	if conn == nil {
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(ctx, unitCallTimeout)
+	defer cancel()
+	reply, err := conn.Call(ctx, systemdBusName, systemdPath, managerInterface, "GetUnitFileState", "s", unit)
+	var busErr *dbus.Error
+	if errors.As(err, &busErr) && busErr.Name == "org.freedesktop.DBus.Error.FileNotFound" {
+		return "not-found", true
+	}
+	if err != nil || len(reply.Body) == 0 {
+		return "", false
+	}
+	state, ok := reply.Body[0].(string)
+	return state, ok
+}
+
+// unitNameFromPath decodes a unit's object path, in which systemd escapes
+// every byte but letters and digits as _xx
+func unitNameFromPath(path string) string {
+	escaped := strings.TrimPrefix(path, unitPathPrefix)
+	var name strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '_' && i+2 < len(escaped) {
+			if b, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8); err == nil {
+				name.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		name.WriteByte(escaped[i])
+	}
+	return name.String()
+}
@@ -0,0 +1,142 @@
+package systemd
+
+import (
+	"reflect"
+	"testing"
+
+	"servio/internal/dbus"
+)
+
+// propertiesChanged builds a unit's PropertiesChanged signal as dbus decodes
+// it; internal/dbus tests the decoding against a recorded signal
+func propertiesChanged(path string, changed map[interface{}]interface{}, invalidated ...interface{}) *dbus.Message {
+	return &dbus.Message{
+		Type:      dbus.TypeSignal,
+		Path:      path,
+		Interface: "org.freedesktop.DBus.Properties",
+		Member:    "PropertiesChanged",
+		Signature: "sa{sv}as",
+		Body:      []interface{}{unitInterface, changed, append([]interface{}{}, invalidated...)},
+	}
+}
+
+func TestUnitWatcherHandle(t *testing.T) {
+	const api = unitPathPrefix + "servio_2dapi_2eservice"
+	type change struct{ unit, state string }
+	tests := []struct {
+		name     string
+		messages []*dbus.Message
+		changes  []change
+		states   map[string]string
+	}{
+		{
+			name: "state change",
+			messages: []*dbus.Message{propertiesChanged(api, map[interface{}]interface{}{
+				"ActiveState": dbus.Variant{Signature: "s", Value: "active"},
+				"SubState":    dbus.Variant{Signature: "s", Value: "running"},
+			})},
+			changes: []change{{"servio-api.service", "active"}},
+			states:  map[string]string{"servio-api.service": "active"},
+		},
+		{
+			name: "repeated state",
+			messages: []*dbus.Message{
+				propertiesChanged(api, map[interface{}]interface{}{"ActiveState": dbus.Variant{Signature: "s", Value: "active"}}),
+				propertiesChanged(api, map[interface{}]interface{}{"ActiveState": dbus.Variant{Signature: "s", Value: "active"}}),
+				propertiesChanged(api, map[interface{}]interface{}{"ActiveState": dbus.Variant{Signature: "s", Value: "deactivating"}}),
+			},
+			changes: []change{{"servio-api.service", "active"}, {"servio-api.service", "deactivating"}},
+			states:  map[string]string{"servio-api.service": "deactivating"},
+		},
+		{
+			name: "other properties",
+			messages: []*dbus.Message{propertiesChanged(api, map[interface{}]interface{}{
+				"SubState": dbus.Variant{Signature: "s", Value: "running"},
+			})},
+			states: map[string]string{},
+		},
+		{
+			name: "unit without the prefix",
+			messages: []*dbus.Message{propertiesChanged(unitPathPrefix+"sshd_2eservice", map[interface{}]interface{}{
+				"ActiveState": dbus.Variant{Signature: "s", Value: "active"},
+			})},
+			states: map[string]string{"sshd.service": "active"},
+		},
+		{
+			name: "invalidated state",
+			messages: []*dbus.Message{
+				propertiesChanged(api, map[interface{}]interface{}{"ActiveState": dbus.Variant{Signature: "s", Value: "active"}}),
+				propertiesChanged(api, map[interface{}]interface{}{}, "ActiveState"),
+			},
+			changes: []change{{"servio-api.service", "active"}},
+			states:  map[string]string{},
+		},
+		{
+			name: "unit removed",
+			messages: []*dbus.Message{
+				propertiesChanged(api, map[interface{}]interface{}{"ActiveState": dbus.Variant{Signature: "s", Value: "active"}}),
+				{Type: dbus.TypeSignal, Path: systemdPath, Interface: managerInterface, Member: "UnitRemoved", Signature: "so", Body: []interface{}{"servio-api.service", api}},
+			},
+			changes: []change{{"servio-api.service", "active"}},
+			states:  map[string]string{},
+		},
+		{
+			name: "another interface",
+			messages: []*dbus.Message{{
+				Type:      dbus.TypeSignal,
+				Path:      api,
+				Member:    "PropertiesChanged",
+				Signature: "sa{sv}as",
+				Body: []interface{}{"org.freedesktop.systemd1.Service", map[interface{}]interface{}{
+					"ActiveState": dbus.Variant{Signature: "s", Value: "active"},
+				}, []interface{}{}},
+			}},
+			states: map[string]string{},
+		},
+		{
+			name: "method return",
+			messages: []*dbus.Message{{
+				Type: dbus.TypeMethodReturn, Path: api, Member: "PropertiesChanged",
+				Body: []interface{}{unitInterface, map[interface{}]interface{}{"ActiveState": dbus.Variant{Signature: "s", Value: "active"}}, []interface{}{}},
+			}},
+			states: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewUnitWatcher("servio-")
+			var changes []change
+			w.OnChange(func(unit, state string) { changes = append(changes, change{unit, state}) })
+			for _, m := range tt.messages {
+				w.handle(m)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Errorf("changes = %v, want %v", changes, tt.changes)
+			}
+			if !reflect.DeepEqual(w.states, tt.states) {
+				t.Errorf("states = %v, want %v", w.states, tt.states)
+			}
+		})
+	}
+}
+
+func TestUnitNameFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{unitPathPrefix + "servio_2dapi_2eservice", "servio-api.service"},
+		{unitPathPrefix + "servio_2dapi_40_2eservice", "servio-api@.service"},
+		{unitPathPrefix + "dbus_2esocket", "dbus.socket"},
+		{unitPathPrefix + "plain", "plain"},
+		// A trailing or invalid escape is kept as it is
+		{unitPathPrefix + "odd_2", "odd_2"},
+		{unitPathPrefix + "odd_zz", "odd_zz"},
+	}
+	for _, tt := range tests {
+		if got := unitNameFromPath(tt.path); got != tt.want {
+			t.Errorf("unitNameFromPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
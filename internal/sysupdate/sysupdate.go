@@ -0,0 +1,370 @@
+// Package sysupdate lists and applies the host's pending OS package updates
+// with apt or dnf, whichever the host has, and reports whether applied
+// updates need a reboot.
+package sysupdate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"servio/internal/audit"
+	"servio/internal/dryrun"
+)
+
+// Package managers
+const (
+	APT = "apt"
+	DNF = "dnf"
+)
+
+var (
+	// ErrUnsupported is returned on hosts with neither apt nor dnf
+	ErrUnsupported = errors.New("no supported package manager (apt or dnf) found")
+	// ErrInvalidPackage is returned for package names the package manager could take for an option
+	ErrInvalidPackage = errors.New("invalid package name")
+	// ErrCommandFailed is returned when the package manager fails
+	ErrCommandFailed = errors.New("package manager failed")
+)
+
+// packagePattern matches apt and dnf package names
+var packagePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9+._:-]*$`)
+
+// Debian and Ubuntu touch these files when an upgraded package needs a reboot
+const (
+	rebootRequiredFile = "/var/run/reboot-required"
+	rebootPackagesFile = "/var/run/reboot-required.pkgs"
+)
+
+// Update is a package with a newer version available
+type Update struct {
+	Name      string `json:"name"`
+	Arch      string `json:"arch,omitempty"`
+	Current   string `json:"current,omitempty"` // installed version; apt only
+	Available string `json:"available"`
+	Repo      string `json:"repo"`     // the apt suites or dnf repository it comes from
+	Security  bool   `json:"security"` // a security update
+}
+
+// Reboot says whether the host needs a reboot for updates to take effect
+type Reboot struct {
+	Required bool     `json:"required"`
+	Packages []string `json:"packages,omitempty"` // the packages that need it, where the host tells
+}
+
+// Detect returns the host's package manager, preferring apt
+func Detect() (string, error) {
+	if _, err := exec.LookPath("apt-get"); err == nil {
+		return APT, nil
+	}
+	if _, err := exec.LookPath("dnf"); err == nil {
+		return DNF, nil
+	}
+	return "", ErrUnsupported
+}
+
+// Refresh downloads the package lists, so List sees updates published since
+// the last refresh
+func Refresh(ctx context.Context, manager string) error {
+	args := []string{"apt-get", "update"}
+	if manager == DNF {
+		args = []string{"dnf", "makecache", "-q"}
+	}
+	output, err := audit.Run(ctx, audit.CategoryPackages, "refresh", privileged(ctx, args))
+	return commandError(err, output)
+}
+
+// List returns the pending updates, or only security updates, sorted by name.
+// It reads the package lists as last refreshed and never downloads them.
+func List(ctx context.Context, manager string, securityOnly bool) ([]Update, error) {
+	var updates []Update
+	var err error
+	switch manager {
+	case APT:
+		updates, err = listAPT(ctx)
+	case DNF:
+		updates, err = listDNF(ctx)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if securityOnly {
+		updates = slices.DeleteFunc(updates, func(u Update) bool { return !u.Security })
+	}
+	slices.SortFunc(updates, func(a, b Update) int { return strings.Compare(a.Name, b.Name) })
+	return updates, nil
+}
+
+// listAPT parses `apt list --upgradable`, whose lines look like
+// "openssl/stable-security 3.0.11-1~deb12u2 amd64 [upgradable from: 3.0.11-1~deb12u1]".
+// Updates from a -security suite are security updates.
+func listAPT(ctx context.Context) ([]Update, error) {
+	cmd := exec.CommandContext(ctx, "apt", "list", "--upgradable")
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, commandError(err, stderr(err))
+	}
+
+	updates := []Update{}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, rest, ok := strings.Cut(line, "/")
+		if !ok {
+			continue // "Listing..."
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 3 {
+			continue
+		}
+		u := Update{Name: name, Repo: fields[0], Available: fields[1], Arch: fields[2]}
+		if _, from, ok := strings.Cut(rest, "upgradable from: "); ok {
+			u.Current = strings.TrimSuffix(strings.TrimSpace(from), "]")
+		}
+		for _, suite := range strings.Split(u.Repo, ",") {
+			if strings.HasSuffix(suite, "-security") {
+				u.Security = true
+			}
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// listDNF parses `dnf check-update`, whose lines look like
+// "openssl.x86_64  1:3.0.7-25.el9  baseos", and marks the packages
+// `dnf check-update --security` also lists
+func listDNF(ctx context.Context) ([]Update, error) {
+	updates, err := checkUpdateDNF(ctx)
+	if err != nil {
+		return nil, err
+	}
+	security, err := checkUpdateDNF(ctx, "--security")
+	if err != nil {
+		return nil, err
+	}
+	secure := map[string]bool{}
+	for _, u := range security {
+		secure[u.Name+"."+u.Arch] = true
+	}
+	for i := range updates {
+		updates[i].Security = secure[updates[i].Name+"."+updates[i].Arch]
+	}
+	return updates, nil
+}
+
+func checkUpdateDNF(ctx context.Context, args ...string) ([]Update, error) {
+	cmd := exec.CommandContext(ctx, "dnf", append([]string{"-q", "--cacheonly", "check-update"}, args...)...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	// check-update exits with 100 when updates are available
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 100) {
+		return nil, commandError(err, stderr(err))
+	}
+
+	updates := []Update{}
+	for _, line := range strings.Split(string(output), "\n") {
+		// Obsoleted packages and security notices follow the updates
+		if strings.HasPrefix(line, "Obsoleting") || strings.HasPrefix(line, "Security:") {
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		dot := strings.LastIndex(fields[0], ".")
+		if dot <= 0 {
+			continue
+		}
+		updates = append(updates, Update{Name: fields[0][:dot], Arch: fields[0][dot+1:], Available: fields[1], Repo: fields[2]})
+	}
+	return updates, nil
+}
+
+// Apply upgrades the named packages, or every pending update (only the
+// security ones with securityOnly) when none are named. Output is passed to
+// out line by line as the package manager prints it. A dry run records the
+// command instead.
+func Apply(ctx context.Context, manager string, packages []string, securityOnly bool, out func(line string)) error {
+	if err := CheckPackages(packages); err != nil {
+		return err
+	}
+
+	var args []string
+	switch manager {
+	case APT:
+		if len(packages) == 0 && securityOnly {
+			// apt has no security filter of its own
+			updates, err := List(ctx, APT, true)
+			if err != nil {
+				return err
+			}
+			if len(updates) == 0 {
+				out("no security updates pending")
+				return nil
+			}
+			for _, u := range updates {
+				packages = append(packages, u.Name)
+			}
+		}
+		args = []string{"apt-get", "-y", "-o", "Dpkg::Options::=--force-confdef", "-o", "Dpkg::Options::=--force-confold"}
+		if len(packages) == 0 {
+			args = append(args, "upgrade")
+		} else {
+			args = append(append(args, "install", "--only-upgrade", "--"), packages...)
+		}
+	case DNF:
+		args = []string{"dnf", "upgrade", "-y"}
+		if securityOnly {
+			args = append(args, "--security")
+		}
+		if len(packages) > 0 {
+			args = append(append(args, "--"), packages...)
+		}
+	default:
+		return ErrUnsupported
+	}
+
+	cmd := privileged(ctx, args)
+	if manager == APT {
+		// Kept through sudo by the env_keep entry servio install writes
+		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+	}
+	if plan := dryrun.FromContext(ctx); plan != nil {
+		plan.Run(cmd.Args)
+		return nil
+	}
+
+	// Lines go to out as they arrive and into the audit entry afterwards
+	var log bytes.Buffer
+	w := &lineWriter{out: func(line string) {
+		log.WriteString(line + "\n")
+		out(line)
+	}}
+	cmd.Stdout, cmd.Stderr = w, w
+	start := time.Now()
+	err := cmd.Run()
+	w.flush()
+	audit.Log(ctx, audit.CategoryPackages, "upgrade", strings.Join(cmd.Args, " "), log.String(), err, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCommandFailed, err)
+	}
+	return nil
+}
+
+// CheckPackages rejects names that are not package names
+func CheckPackages(packages []string) error {
+	for _, name := range packages {
+		if !packagePattern.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidPackage, name)
+		}
+	}
+	return nil
+}
+
+// RebootRequired reports whether updates installed so far need a reboot:
+// the reboot-required file on Debian and Ubuntu, or needs-restarting on
+// dnf hosts. Hosts that cannot tell report no reboot.
+func RebootRequired(ctx context.Context, manager string) Reboot {
+	if manager == DNF {
+		args := []string{"needs-restarting", "-r"}
+		if _, err := exec.LookPath("needs-restarting"); err != nil {
+			args = []string{"dnf", "needs-restarting", "-r"}
+		}
+		// Exit code 1 means a reboot is needed
+		err := exec.CommandContext(ctx, args[0], args[1:]...).Run()
+		var exitErr *exec.ExitError
+		return Reboot{Required: errors.As(err, &exitErr) && exitErr.ExitCode() == 1}
+	}
+
+	if _, err := os.Stat(rebootRequiredFile); err != nil {
+		return Reboot{}
+	}
+	reboot := Reboot{Required: true}
+	if data, err := os.ReadFile(rebootPackagesFile); err == nil {
+		for _, name := range strings.Fields(string(data)) {
+			if !slices.Contains(reboot.Packages, name) {
+				reboot.Packages = append(reboot.Packages, name)
+			}
+		}
+	}
+	return reboot
+}
+
+// privileged returns a command running args through sudo unless Servio runs as root
+func privileged(ctx context.Context, args []string) *exec.Cmd {
+	if os.Geteuid() != 0 {
+		args = append([]string{"sudo"}, args...)
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+// commandError wraps a failed command with its output
+func commandError(err error, output []byte) error {
+	if err == nil {
+		return nil
+	}
+	if msg := strings.TrimSpace(string(output)); msg != "" {
+		return fmt.Errorf("%w: %v: %s", ErrCommandFailed, err, msg)
+	}
+	return fmt.Errorf("%w: %v", ErrCommandFailed, err)
+}
+
+// stderr returns what a command that failed under Output wrote to stderr
+func stderr(err error) []byte {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Stderr
+	}
+	return nil
+}
+
+// lineWriter passes each complete line written to it to out. Stdout and
+// stderr share it, so writes are serialized.
+type lineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	out func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush passes on a final line without a newline
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+// emit drops the carriage returns of progress output
+func (w *lineWriter) emit(line []byte) {
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	w.out(string(line))
+}
@@ -0,0 +1,57 @@
+// Package tail reads and follows plain log files with tail(1), for logs that
+// live outside the journal: services that log to files and nginx sites.
+package tail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Read returns the last lines of a file. A file that does not exist yet
+// reads as empty.
+func Read(ctx context.Context, path string, lines int) (string, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	output, err := exec.CommandContext(ctx, "tail", "-n", strconv.Itoa(lines), path).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s - %w", path, strings.TrimSpace(string(output)), err)
+	}
+	return string(output), nil
+}
+
+// Follow streams lines appended to a file, following it across rotation and
+// creation, until ctx is done
+func Follow(ctx context.Context, path string) (<-chan string, error) {
+	cmd := exec.CommandContext(ctx, "tail", "-n", "0", "-F", path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start tail: %w", err)
+	}
+
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		defer cmd.Wait()
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case <-ctx.Done():
+				return
+			case lines <- scanner.Text():
+			}
+		}
+	}()
+	return lines, nil
+}
@@ -0,0 +1,129 @@
+// Package webhooks delivers events from the events bus to the configured
+// webhook endpoints. Each request body is the JSON-encoded event, signed with
+// the endpoint's secret so receivers can verify it came from servio.
+// internal/delivery queues the deliveries and retries failed ones.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"servio/internal/delivery"
+	"servio/internal/events"
+	"servio/internal/secrets"
+	"servio/internal/storage"
+)
+
+// EventPing is sent by Test to check that an endpoint is reachable
+const EventPing = "ping"
+
+// Request headers set on every delivery
+const (
+	HeaderEvent     = "X-Servio-Event"
+	HeaderDelivery  = "X-Servio-Delivery"
+	HeaderSignature = "X-Servio-Signature"
+)
+
+// Dispatcher fans events out to matching webhooks and records each delivery.
+// Subscribe its Handle to an events.Bus and call Run to start delivering.
+type Dispatcher struct {
+	*delivery.Dispatcher
+	store  storage.Store
+	cipher *secrets.Cipher
+}
+
+// NewDispatcher creates a Dispatcher
+func NewDispatcher(store storage.Store, cipher *secrets.Cipher) *Dispatcher {
+	d := &Dispatcher{store: store, cipher: cipher}
+	d.Dispatcher = delivery.NewDispatcher("webhook", "servio-webhooks", d.dispatch)
+	return d
+}
+
+// dispatch starts a delivery for every enabled webhook subscribed to the event
+func (d *Dispatcher) dispatch(ctx context.Context, e events.Event) {
+	hooks, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list webhooks", "event", e.Type, "error", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Wants(e.Type) {
+			continue
+		}
+		out, err := d.record(ctx, hook, &storage.WebhookDelivery{}, e)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record webhook delivery", "webhook_id", hook.ID, "event", e.Type, "error", err)
+			continue
+		}
+		if out != nil {
+			d.Go(ctx, out)
+		}
+	}
+}
+
+// Test sends a ping event to a webhook with a single attempt and returns the recorded delivery
+func (d *Dispatcher) Test(ctx context.Context, hook *storage.Webhook) (*storage.WebhookDelivery, error) {
+	wd := &storage.WebhookDelivery{}
+	out, err := d.record(ctx, hook, wd, events.Event{
+		Type: EventPing,
+		Time: time.Now(),
+		Data: map[string]interface{}{"webhook_id": hook.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		d.Send(ctx, out, 1)
+	}
+	return wd, nil
+}
+
+// record fills in and stores wd, a pending delivery of an event to hook, and
+// returns the signed request to send, or nil when it already failed
+func (d *Dispatcher) record(ctx context.Context, hook *storage.Webhook, wd *storage.WebhookDelivery, e events.Event) (*delivery.Delivery, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	wd.WebhookID, wd.Event, wd.Payload = hook.ID, e.Type, string(body)
+	wd.Status = storage.DeliveryPending
+	if err := d.store.CreateWebhookDelivery(ctx, wd); err != nil {
+		return nil, err
+	}
+
+	out := &delivery.Delivery{
+		URL:   hook.URL,
+		Body:  body,
+		State: &wd.DeliveryState,
+		Save:  func(ctx context.Context) error { return d.store.UpdateWebhookDelivery(ctx, wd) },
+		Log:   []any{"webhook_id", hook.ID, "delivery_id", wd.ID, "event", wd.Event},
+	}
+	secret, err := d.cipher.Decrypt(hook.Ciphertext)
+	if err != nil {
+		d.Fail(ctx, out, fmt.Errorf("failed to decrypt signing secret: %w", err))
+		return nil, nil
+	}
+	out.Header = http.Header{
+		HeaderEvent:     {wd.Event},
+		HeaderDelivery:  {strconv.FormatInt(wd.ID, 10)},
+		HeaderSignature: {Sign([]byte(secret), body)},
+	}
+	return out, nil
+}
+
+// Sign returns the X-Servio-Signature value for a body: "sha256=" followed
+// by the hex HMAC-SHA256 of the body keyed with the webhook secret
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}